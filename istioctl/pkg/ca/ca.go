@@ -0,0 +1,273 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/istioctl/pkg/cli"
+	"istio.io/istio/istioctl/pkg/clioptions"
+	"istio.io/istio/istioctl/pkg/xds"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/revocationapi"
+	caserver "istio.io/istio/security/pkg/server/ca"
+)
+
+// tokenServiceAccount is the service account of the token used to authenticate to Istiod to retrieve the
+// certificate revocation list, which any authenticated caller can do.
+const tokenServiceAccount = "default"
+
+// Cmd returns the command to manage the certificates issued by the Istio CA.
+func Cmd(ctx cli.Context) *cobra.Command {
+	var centralOpts clioptions.CentralControlPlaneOptions
+	var revision string
+	cmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the certificates issued by the Istio CA",
+		Long: `Manage the certificates issued by the Istio CA.
+
+Certificate revocation must be enabled in Istiod with ENABLE_CA_REVOCATION=true.`,
+	}
+	cmd.AddCommand(revokeCmd(ctx, &centralOpts, &revision), crlCmd(ctx, &centralOpts, &revision))
+	centralOpts.AttachControlPlaneFlags(cmd)
+	cmd.PersistentFlags().StringVarP(&revision, "revision", "r", "", "Control plane revision")
+	return cmd
+}
+
+func revokeCmd(ctx cli.Context, centralOpts *clioptions.CentralControlPlaneOptions, revision *string) *cobra.Command {
+	var reason int32
+	var serviceAccount string
+	var certFiles []string
+	cmd := &cobra.Command{
+		Use:   "revoke [<serial-number>...]",
+		Short: "Revoke certificates issued by the Istio CA",
+		Long: `Revoke certificates issued by the Istio CA.
+
+Serial numbers are hexadecimal, optionally with ':' separators as printed by openssl. The revoked certificates are
+added to the certificate revocation list published by Istiod. Certificates can also be given as PEM files with
+--cert: their revocation is dropped from the list once they expire, while revocations by serial number are kept
+forever since their expiration is unknown. Only the service accounts listed in
+CA_REVOCATION_AUTHORIZED_ACCOUNTS, or the service account of Istiod if it is empty, can revoke certificates. The
+command authenticates with a token of the service account of Istiod, which requires the permission to create tokens
+for it; use --service-account to authenticate as another service account of the Istio namespace.`,
+		Example: `  # Revoke a certificate
+  istioctl x ca revoke 5c:0a:2f:1b:3e:77:11:90:aa:bb:cc:dd:ee:ff:00:11
+
+  # Revoke certificates whose private key was compromised
+  istioctl x ca revoke --reason 1 1f3a 2b4c
+
+  # Revoke a certificate until it expires
+  istioctl x ca revoke --cert cert-chain.pem`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && len(certFiles) == 0 {
+				return fmt.Errorf("at least one serial number or certificate must be provided")
+			}
+			for _, sn := range args {
+				if _, ok := caserver.ParseSerialNumber(sn); !ok {
+					return fmt.Errorf("invalid serial number %q", sn)
+				}
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			certs := make([]string, 0, len(certFiles))
+			for _, f := range certFiles {
+				cert, err := os.ReadFile(f)
+				if err != nil {
+					return fmt.Errorf("failed to read certificate: %v", err)
+				}
+				certs = append(certs, string(cert))
+			}
+			sa := serviceAccount
+			if sa == "" {
+				sa = istiodServiceAccount(*revision)
+			}
+			return withClient(ctx, *centralOpts, *revision, sa, func(client revocationapi.IstioCertificateRevocationServiceClient) error {
+				resp, err := client.RevokeCertificates(cmd.Context(), &revocationapi.RevokeCertificatesRequest{
+					SerialNumbers: args,
+					Certificates:  certs,
+					Reason:        reason,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to revoke certificates: %v", err)
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%d certificate(s) revoked\n", resp.Revoked)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().Int32Var(&reason, "reason", 0, "RFC 5280 revocation reason code (1 for key compromise)")
+	cmd.Flags().StringSliceVar(&certFiles, "cert", nil,
+		"PEM encoded certificate to revoke, issued by the Istio CA. Its revocation is dropped once it expires")
+	cmd.Flags().StringVar(&serviceAccount, "service-account", "",
+		"Service account of the Istio namespace to authenticate as. Defaults to the service account of Istiod")
+	return cmd
+}
+
+func crlCmd(ctx cli.Context, centralOpts *clioptions.CentralControlPlaneOptions, revision *string) *cobra.Command {
+	var list bool
+	cmd := &cobra.Command{
+		Use:   "crl",
+		Short: "Retrieve the certificate revocation list of the Istio CA",
+		Example: `  # Save the PEM encoded certificate revocation list
+  istioctl x ca crl > crl.pem
+
+  # List the revoked certificates
+  istioctl x ca crl --list`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withClient(ctx, *centralOpts, *revision, tokenServiceAccount, func(client revocationapi.IstioCertificateRevocationServiceClient) error {
+				resp, err := client.GetRevocationList(cmd.Context(), &revocationapi.RevocationListRequest{})
+				if err != nil {
+					return fmt.Errorf("failed to get the certificate revocation list: %v", err)
+				}
+				if !list {
+					_, _ = fmt.Fprint(cmd.OutOrStdout(), resp.Crl)
+					return nil
+				}
+				return printRevoked(cmd.OutOrStdout(), []byte(resp.Crl))
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&list, "list", false, "List the revoked certificates instead of printing the PEM encoded list")
+	return cmd
+}
+
+func printRevoked(w io.Writer, crlPEM []byte) error {
+	block, _ := pem.Decode(crlPEM)
+	if block == nil {
+		return fmt.Errorf("invalid certificate revocation list")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SERIAL NUMBER\tREVOKED AT\tREASON")
+	for _, e := range crl.RevokedCertificateEntries {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\n", e.SerialNumber.Text(16), e.RevocationTime.UTC().Format("2006-01-02T15:04:05Z"), e.ReasonCode)
+	}
+	return tw.Flush()
+}
+
+// istiodServiceAccount returns the service account of Istiod, as named by the chart.
+func istiodServiceAccount(revision string) string {
+	if revision == "" {
+		return "istiod"
+	}
+	return "istiod-" + revision
+}
+
+// withClient connects to Istiod, either with the --xds-address flag or by port forwarding to an Istiod pod,
+// and calls f with a client of the revocation service, authenticated with a token of the service account.
+func withClient(ctx cli.Context, centralOpts clioptions.CentralControlPlaneOptions, revision, serviceAccount string,
+	f func(client revocationapi.IstioCertificateRevocationServiceClient) error,
+) error {
+	if err := centralOpts.ValidateControlPlaneFlags(); err != nil {
+		return err
+	}
+	kubeClient, err := ctx.CLIClientWithRevision(revision)
+	if err != nil {
+		return err
+	}
+	istioNamespace := ctx.IstioNamespace()
+	address := centralOpts.Xds
+	if address == "" {
+		labelSelector := centralOpts.XdsPodLabel
+		if labelSelector == "" {
+			labelSelector = "app=istiod"
+		}
+		pods, err := kubeClient.GetIstioPods(context.TODO(), istioNamespace, metav1.ListOptions{
+			LabelSelector: labelSelector,
+			FieldSelector: kube.RunningStatus,
+		})
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			return fmt.Errorf("no running Istio pods in %q", istioNamespace)
+		}
+		fw, err := kubeClient.NewPortForwarder(pods[0].Name, pods[0].Namespace, "localhost", 0, centralOpts.XdsPodPort)
+		if err != nil {
+			return err
+		}
+		if err := fw.Start(); err != nil {
+			return err
+		}
+		defer fw.Close()
+		address = fw.Address()
+	}
+
+	dialOpts, err := xds.DialOptions(centralOpts, istioNamespace, serviceAccount, kubeClient)
+	if err != nil {
+		return err
+	}
+	switch {
+	case centralOpts.Plaintext:
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	case centralOpts.CertDir != "":
+		tlsConfig, err := certDirTLSConfig(centralOpts)
+		if err != nil {
+			return err
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	dialCtx, cancel := context.WithTimeout(context.Background(), centralOpts.Timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, address, append(dialOpts, grpc.WithBlock())...)
+	if err != nil {
+		return fmt.Errorf("could not dial %s: %v", address, err)
+	}
+	defer conn.Close()
+	return f(revocationapi.NewIstioCertificateRevocationServiceClient(conn))
+}
+
+// certDirTLSConfig returns the mTLS configuration using the certificates of the --cert-dir flag.
+func certDirTLSConfig(centralOpts clioptions.CentralControlPlaneOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(centralOpts.CertDir, "cert-chain.pem"), filepath.Join(centralOpts.CertDir, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	rootCert, err := os.ReadFile(filepath.Join(centralOpts.CertDir, "root-cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootCert) {
+		return nil, fmt.Errorf("invalid root certificate in %s", centralOpts.CertDir)
+	}
+	// nolint: gosec
+	// it's insecure only when a user explicitly enable insecure mode.
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		RootCAs:            roots,
+		ServerName:         centralOpts.XDSSAN,
+		InsecureSkipVerify: centralOpts.InsecureSkipVerify,
+	}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/istioctl/pkg/cli"
+)
+
+func TestRevokeArgs(t *testing.T) {
+	cases := []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: []string{"revoke"}, wantErr: "at least one serial number or certificate"},
+		{args: []string{"revoke", "10", "xyz"}, wantErr: `invalid serial number "xyz"`},
+		{args: []string{"crl", "foo"}, wantErr: "unknown command"},
+	}
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := Cmd(cli.NewFakeContext(nil))
+			cmd.SetArgs(tc.args)
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			err := cmd.Execute()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrintRevoked(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"cluster.local"}},
+		SubjectKeyId: []byte{1, 2, 3},
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	revokedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: revokedAt,
+		NextUpdate: revokedAt.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(0x1f3a), RevocationTime: revokedAt, ReasonCode: 1},
+		},
+	}, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := printRevoked(&out, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})); err != nil {
+		t.Fatal(err)
+	}
+	want := "SERIAL NUMBER REVOKED AT           REASON\n1f3a          2024-01-02T03:04:05Z 1\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if err := printRevoked(&out, []byte("garbage")); err == nil {
+		t.Fatal("expected error for invalid CRL")
+	}
+}
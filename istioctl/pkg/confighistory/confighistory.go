@@ -0,0 +1,214 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package confighistory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/spf13/cobra"
+
+	"istio.io/istio/istioctl/pkg/cli"
+	"istio.io/istio/istioctl/pkg/clioptions"
+	"istio.io/istio/istioctl/pkg/multixds"
+	"istio.io/istio/istioctl/pkg/util"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/ledger"
+)
+
+// Cmd returns the config-history command, querying the config history recorded by Istiod.
+func Cmd(ctx cli.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config-history",
+		Short: "Query the version of configs at past pushes of Istiod",
+		Long: `
+Istiod records the resource version of every config at each push in a ledger, if the
+PILOT_ENABLE_CONFIG_HISTORY environment variable is set. The version of a config at a push is
+returned with the root hash of the ledger at that push, and a proof that the ledger held it,
+which can be verified offline.
+` + "\n" + util.ExperimentalMsg,
+	}
+	cmd.AddCommand(versionsCmd(ctx))
+	cmd.AddCommand(getCmd(ctx))
+	cmd.AddCommand(verifyCmd())
+	return cmd
+}
+
+func versionsCmd(ctx cli.Context) *cobra.Command {
+	var opts clioptions.ControlPlaneOptions
+	var centralOpts clioptions.CentralControlPlaneOptions
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "List the push versions retained by Istiod",
+		Example: `  # List the push versions of the config history
+  istioctl x config-history versions`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			body, err := debugRequest(ctx, opts, centralOpts, "config_history")
+			if err != nil {
+				return err
+			}
+			var versions []ledger.Version
+			if err := json.Unmarshal(body, &versions); err != nil {
+				return fmt.Errorf("%s", body)
+			}
+			w := tabwriter.NewWriter(c.OutOrStdout(), 0, 8, 3, ' ', 0)
+			_, _ = fmt.Fprintln(w, "VERSION\tTIME\tROOT HASH")
+			for _, v := range versions {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", v.Version, v.Time.Format("2006-01-02T15:04:05Z07:00"), v.RootHash)
+			}
+			return w.Flush()
+		},
+	}
+	opts.AttachControlPlaneFlags(cmd)
+	centralOpts.AttachControlPlaneFlags(cmd)
+	return cmd
+}
+
+func getCmd(ctx cli.Context) *cobra.Command {
+	var opts clioptions.ControlPlaneOptions
+	var centralOpts clioptions.CentralControlPlaneOptions
+	var version, output string
+	cmd := &cobra.Command{
+		Use:   "get <kind>/<namespace>/<name>",
+		Short: "Get the version of a config at a push, and verify its proof",
+		Example: `  # Get the version of a VirtualService at the last push
+  istioctl x config-history get VirtualService/default/reviews
+
+  # Save the version of a VirtualService at a push, with its proof, to verify it later
+  istioctl x config-history get VirtualService/default/reviews --push-version 2024-05-01T10:00:00Z/42 -o json > record.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			query := url.Values{"key": []string{args[0]}}
+			if version != "" {
+				query.Set("version", version)
+			}
+			body, err := debugRequest(ctx, opts, centralOpts, "config_history?"+query.Encode())
+			if err != nil {
+				return err
+			}
+			record := &ledger.Record{}
+			if err := json.Unmarshal(body, record); err != nil {
+				return fmt.Errorf("%s", body)
+			}
+			if err := record.Verify(); err != nil {
+				return fmt.Errorf("the proof returned by Istiod is invalid: %v", err)
+			}
+			if output == "json" {
+				return writeJSON(c.OutOrStdout(), record)
+			}
+			printRecord(c.OutOrStdout(), record)
+			return nil
+		},
+	}
+	opts.AttachControlPlaneFlags(cmd)
+	centralOpts.AttachControlPlaneFlags(cmd)
+	cmd.Flags().StringVar(&version, "push-version", "", "The push version, defaults to the last push")
+	cmd.Flags().StringVarP(&output, "output", "o", "short", "Output format: one of json|short")
+	return cmd
+}
+
+func verifyCmd() *cobra.Command {
+	var filename string
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the proof of a config version saved with 'get -o json', without access to Istiod",
+		Example: `  # Verify a saved record
+  istioctl x config-history verify -f record.json`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			var in io.Reader = c.InOrStdin()
+			if filename != "-" {
+				f, err := os.Open(filename)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+			return verify(in, c.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVarP(&filename, "filename", "f", "-", "The record to verify, or - for stdin")
+	return cmd
+}
+
+// verify reads a record, checks its proof and prints it.
+func verify(in io.Reader, out io.Writer) error {
+	record := &ledger.Record{}
+	if err := json.NewDecoder(in).Decode(record); err != nil {
+		return fmt.Errorf("invalid record: %v", err)
+	}
+	if err := record.Verify(); err != nil {
+		return err
+	}
+	printRecord(out, record)
+	_, _ = fmt.Fprintln(out, "Proof verified.")
+	return nil
+}
+
+func printRecord(w io.Writer, r *ledger.Record) {
+	value := r.Value
+	if !r.Present {
+		value = "<absent>"
+	}
+	_, _ = fmt.Fprintf(w, "Config:       %s\n", r.Key)
+	_, _ = fmt.Fprintf(w, "Push version: %s\n", r.Version)
+	_, _ = fmt.Fprintf(w, "Version:      %s\n", value)
+	_, _ = fmt.Fprintf(w, "Root hash:    %s\n", r.RootHash)
+}
+
+func writeJSON(w io.Writer, obj any) error {
+	b, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// debugRequest sends a request to a debug endpoint of Istiod, and returns the body of the response.
+func debugRequest(ctx cli.Context, opts clioptions.ControlPlaneOptions, centralOpts clioptions.CentralControlPlaneOptions,
+	path string,
+) ([]byte, error) {
+	kubeClient, err := ctx.CLIClientWithRevision(opts.Revision)
+	if err != nil {
+		return nil, err
+	}
+	xdsRequest := discovery.DiscoveryRequest{
+		ResourceNames: []string{path},
+		Node: &core.Node{
+			Id: "debug~0.0.0.0~istioctl~cluster.local",
+		},
+		TypeUrl: v3.DebugType,
+	}
+	// The history is recorded by each Istiod, so only one of them is queried.
+	responses, err := multixds.FirstRequestAndProcessXds(&xdsRequest, centralOpts, ctx.IstioNamespace(), "", "", kubeClient, multixds.DefaultOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		for _, resource := range response.Resources {
+			return resource.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("no response from Istiod")
+}
@@ -0,0 +1,49 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package confighistory
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/ledger"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func TestVerify(t *testing.T) {
+	h := ledger.NewHistory(10, time.Hour)
+	assert.NoError(t, h.Put("VirtualService/default/reviews", "42"))
+	assert.NoError(t, h.Put("VirtualService/default/ratings", "7"))
+	h.Commit("v1")
+	record, err := h.Lookup("v1", "VirtualService/default/reviews")
+	assert.NoError(t, err)
+	b, err := json.Marshal(record)
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, verify(bytes.NewReader(b), out))
+	assert.Equal(t, strings.Contains(out.String(), "Version:      42"), true)
+	assert.Equal(t, strings.Contains(out.String(), "Proof verified."), true)
+
+	record.Value = "43"
+	b, err = json.Marshal(record)
+	assert.NoError(t, err)
+	assert.Error(t, verify(bytes.NewReader(b), out))
+
+	assert.Error(t, verify(strings.NewReader("not json"), out))
+}
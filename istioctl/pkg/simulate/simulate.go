@@ -0,0 +1,417 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/istioctl/pkg/cli"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/simulation"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/mesh"
+	"istio.io/istio/pkg/kube"
+)
+
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+type simulateArgs struct {
+	files          []string
+	meshConfigFile string
+	output         string
+
+	// Proxy
+	proxyType string
+	labels    map[string]string
+	ip        string
+
+	// Call
+	address  string
+	port     int
+	protocol string
+	tls      string
+	host     string
+	path     string
+	sni      string
+	alpn     string
+	headers  []string
+	mode     string
+	method   string
+
+	// Source
+	sourcePrincipal string
+	sourceNamespace string
+	sourceIP        string
+	jwtClaims       []string
+}
+
+// Result is the outcome of a simulated call.
+type Result struct {
+	Listener      string             `json:"listener,omitempty"`
+	FilterChain   string             `json:"filterChain,omitempty"`
+	RouteConfig   string             `json:"routeConfig,omitempty"`
+	VirtualHost   string             `json:"virtualHost,omitempty"`
+	Route         string             `json:"route,omitempty"`
+	Cluster       string             `json:"cluster,omitempty"`
+	DownstreamTLS simulation.TLSMode `json:"downstreamTLS,omitempty"`
+	UpstreamTLS   simulation.TLSMode `json:"upstreamTLS,omitempty"`
+	AutoMTLS      bool               `json:"autoMTLS,omitempty"`
+	// Authorization is the decision of the authorization policies applied to the call, if any.
+	Authorization simulation.RBACAction `json:"authorization,omitempty"`
+	// AuthorizationPolicy is the policy rule that made the authorization decision.
+	AuthorizationPolicy string `json:"authorizationPolicy,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+func Cmd(ctx cli.Context) *cobra.Command {
+	a := simulateArgs{}
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate how a proxy handles a call, from configuration files",
+		Long: `Simulate how a proxy handles a call, without a cluster.
+
+The Istio configuration and Kubernetes objects (Services, Pods...) are read from files, and the configuration of a
+proxy is generated from them as Istiod would. The call is then matched against this configuration, and the
+listener, filter chain, route and cluster it resolves to are printed, along with the TLS used on each side of the
+proxy and the decision of the authorization policies. The command fails if the proxy would reject the call, which
+allows checking configuration changes in CI before applying them.
+
+Objects without a namespace are created in the namespace of the proxy.`,
+		Example: `  # Simulate a call from a sidecar in the default namespace to the reviews service
+  istioctl x simulate -f config.yaml -f services.yaml --host reviews:9080 --port 9080 --path /v2
+
+  # Simulate a call to an ingress gateway
+  istioctl x simulate -f config.yaml -n istio-system --type router --labels istio=ingressgateway \
+    --port 80 --host bookinfo.example.com
+
+  # Simulate an inbound mTLS call to a workload, with JSON output
+  istioctl x simulate -f config.yaml --labels app=reviews --ip 10.0.0.1 --mode inbound \
+    --address 10.0.0.1 --port 9080 --tls mtls -o json
+
+  # Check the authorization policies applied to an inbound call from a service account with a JWT
+  istioctl x simulate -f config.yaml --labels app=reviews --mode inbound --port 9080 --tls mtls \
+    --source-principal cluster.local/ns/foo/sa/client --jwt-claim iss=example.com --jwt-claim sub=alice`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(a.files) == 0 {
+				return fmt.Errorf("at least one configuration file must be provided with --filename")
+			}
+			if a.output != textOutput && a.output != jsonOutput {
+				return fmt.Errorf("unknown output format %q", a.output)
+			}
+			res, err := simulate(a, ctx.NamespaceOrDefault(ctx.Namespace()))
+			if err != nil {
+				return err
+			}
+			if err := printResult(cmd.OutOrStdout(), a.output, res); err != nil {
+				return err
+			}
+			if res.Error != "" {
+				return fmt.Errorf("call failed: %v", res.Error)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVarP(&a.files, "filename", "f", nil,
+		"Files holding the Istio configuration and Kubernetes objects to simulate")
+	cmd.Flags().StringVar(&a.meshConfigFile, "meshConfigFile", "",
+		"Mesh configuration file. If not set, the default mesh configuration is used")
+	cmd.Flags().StringVarP(&a.output, "output", "o", textOutput, "Output format: one of text|json")
+
+	cmd.Flags().StringVar(&a.proxyType, "type", string(model.SidecarProxy), "Type of the proxy: one of sidecar|router")
+	cmd.Flags().StringToStringVarP(&a.labels, "labels", "l", nil, "Labels of the proxy")
+	cmd.Flags().StringVar(&a.ip, "ip", "", "IP address of the proxy. Defaults to an address no workload uses")
+
+	cmd.Flags().StringVar(&a.address, "address", "", "Destination address of the call")
+	cmd.Flags().IntVar(&a.port, "port", 80, "Destination port of the call")
+	cmd.Flags().StringVar(&a.protocol, "protocol", string(simulation.HTTP), "Protocol of the call: one of http|http2|tcp")
+	cmd.Flags().StringVar(&a.tls, "tls", string(simulation.Plaintext), "TLS of the call: one of plaintext|tls|mtls")
+	cmd.Flags().StringVar(&a.host, "host", "", "Host header of the call")
+	cmd.Flags().StringVar(&a.path, "path", "/", "Path of the call")
+	cmd.Flags().StringVar(&a.sni, "sni", "", "SNI of the call. Defaults to the host for TLS calls")
+	cmd.Flags().StringVar(&a.alpn, "alpn", "", "ALPN of the call")
+	cmd.Flags().StringSliceVarP(&a.headers, "header", "H", nil, "Headers of the call, as key=value")
+	cmd.Flags().StringVar(&a.mode, "mode", "",
+		"How the call reaches the proxy: one of outbound|inbound|gateway. Defaults to outbound for sidecars, and gateway for routers")
+	cmd.Flags().StringVarP(&a.method, "method", "X", http.MethodGet, "HTTP method of the call")
+
+	cmd.Flags().StringVar(&a.sourcePrincipal, "source-principal", "",
+		"Identity of the client, such as cluster.local/ns/foo/sa/bar. Only known to the proxy for mTLS calls")
+	cmd.Flags().StringVar(&a.sourceNamespace, "source-namespace", "",
+		"Namespace of the client, using its default service account. Ignored if --source-principal is set")
+	cmd.Flags().StringVar(&a.sourceIP, "source-ip", "", "IP address of the client")
+	cmd.Flags().StringSliceVar(&a.jwtClaims, "jwt-claim", nil,
+		"Claims of a valid JWT sent with the call, as key=value. Repeat a key for a list")
+	return cmd
+}
+
+func (a simulateArgs) buildCall() (simulation.Call, error) {
+	call := simulation.Call{
+		Address:    a.address,
+		Port:       a.port,
+		Path:       a.path,
+		Protocol:   simulation.Protocol(a.protocol),
+		TLS:        simulation.TLSMode(a.tls),
+		Alpn:       a.alpn,
+		HostHeader: a.host,
+		Headers:    http.Header{},
+		Sni:        a.sni,
+		CallMode:   simulation.CallMode(a.mode),
+		Method:     a.method,
+
+		SourcePrincipal: a.sourcePrincipal,
+		SourceNamespace: a.sourceNamespace,
+		SourceIP:        a.sourceIP,
+	}
+	switch call.Protocol {
+	case simulation.HTTP, simulation.HTTP2, simulation.TCP:
+	default:
+		return call, fmt.Errorf("unknown protocol %q", a.protocol)
+	}
+	switch call.TLS {
+	case simulation.Plaintext, simulation.TLS, simulation.MTLS:
+	default:
+		return call, fmt.Errorf("unknown TLS mode %q", a.tls)
+	}
+	if call.CallMode == "" {
+		call.CallMode = simulation.CallModeOutbound
+		if a.proxyType == string(model.Router) {
+			call.CallMode = simulation.CallModeGateway
+		}
+	}
+	switch call.CallMode {
+	case simulation.CallModeOutbound, simulation.CallModeInbound, simulation.CallModeGateway:
+	default:
+		return call, fmt.Errorf("unknown mode %q", a.mode)
+	}
+	for _, h := range a.headers {
+		k, v, ok := strings.Cut(h, "=")
+		if !ok {
+			return call, fmt.Errorf("invalid header %q, expected key=value", h)
+		}
+		call.Headers.Add(k, v)
+	}
+	if len(a.jwtClaims) > 0 {
+		claims := map[string][]string{}
+		for _, c := range a.jwtClaims {
+			k, v, ok := strings.Cut(c, "=")
+			if !ok {
+				return call, fmt.Errorf("invalid JWT claim %q, expected key=value", c)
+			}
+			claims[k] = append(claims[k], v)
+		}
+		call.JWTClaims = map[string]any{}
+		for k, v := range claims {
+			if len(v) == 1 {
+				call.JWTClaims[k] = v[0]
+			} else {
+				call.JWTClaims[k] = v
+			}
+		}
+	}
+	return call, nil
+}
+
+func (a simulateArgs) buildProxy(namespace string) (*model.Proxy, error) {
+	nodeType := model.NodeType(a.proxyType)
+	if nodeType != model.SidecarProxy && nodeType != model.Router {
+		return nil, fmt.Errorf("unknown proxy type %q", a.proxyType)
+	}
+	p := &model.Proxy{
+		Type:            nodeType,
+		ConfigNamespace: namespace,
+		Labels:          a.labels,
+		Metadata: &model.NodeMetadata{
+			Labels:    a.labels,
+			Namespace: namespace,
+			ClusterID: constants.DefaultClusterName,
+		},
+	}
+	if a.ip != "" {
+		p.IPAddresses = []string{a.ip}
+	}
+	return p, nil
+}
+
+// simulate runs the call against the configuration of the proxy generated from the files.
+func simulate(a simulateArgs, namespace string) (*Result, error) {
+	call, err := a.buildCall()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := a.buildProxy(namespace)
+	if err != nil {
+		return nil, err
+	}
+	configs, objects, err := readInputs(a.files, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var meshConfig *meshconfig.MeshConfig
+	if a.meshConfigFile != "" {
+		if meshConfig, err = mesh.ReadMeshConfig(a.meshConfigFile); err != nil {
+			return nil, fmt.Errorf("failed to read mesh config: %v", err)
+		}
+	}
+
+	gen, err := simulation.NewConfigGen(simulation.ConfigGenOptions{
+		Configs:           configs,
+		KubernetesObjects: objects,
+		MeshConfig:        meshConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the configuration: %v", err)
+	}
+	defer gen.Close()
+	result, mtls, err := gen.Simulate(gen.SetupProxy(proxy), call)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the proxy configuration: %v", err)
+	}
+	res := &Result{
+		Listener:      result.ListenerMatched,
+		FilterChain:   result.FilterChainMatched,
+		RouteConfig:   result.RouteConfigMatched,
+		VirtualHost:   result.VirtualHostMatched,
+		Route:         result.RouteMatched,
+		Cluster:       result.ClusterMatched,
+		DownstreamTLS: mtls.Downstream,
+		UpstreamTLS:   mtls.Upstream,
+		AutoMTLS:      mtls.AutoMTLS,
+
+		Authorization:       result.RBAC,
+		AuthorizationPolicy: result.RBACPolicy,
+	}
+	if result.Error != nil {
+		res.Error = result.Error.Error()
+	}
+	return res, nil
+}
+
+// readInputs reads the files, returning the Istio configuration and Kubernetes objects they hold.
+func readInputs(files []string, namespace string) ([]config.Config, []runtime.Object, error) {
+	var configs []config.Config
+	var objects []runtime.Object
+	decode := kube.IstioCodec.UniversalDeserializer().Decode
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader := yamlDecoder.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+		for {
+			raw, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", f, err)
+			}
+			doc := string(raw)
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			parsed, others, err := crd.ParseInputs(doc)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", f, err)
+			}
+			for _, c := range parsed {
+				if c.Namespace == "" {
+					c.Namespace = namespace
+				}
+				if c.Domain == "" {
+					c.Domain = constants.DefaultClusterLocalDomain
+				}
+				configs = append(configs, c)
+			}
+			if len(others) == 0 {
+				continue
+			}
+			o, _, err := decode([]byte(doc), nil, nil)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", f, err)
+			}
+			if co, ok := o.(client.Object); ok && co.GetNamespace() == "" {
+				co.SetNamespace(namespace)
+			}
+			if svc, ok := o.(*corev1.Service); ok {
+				// Apply the default of the API server, which manifests usually rely on
+				for i, p := range svc.Spec.Ports {
+					if p.TargetPort.IntVal == 0 && p.TargetPort.StrVal == "" {
+						svc.Spec.Ports[i].TargetPort = intstr.FromInt32(p.Port)
+					}
+				}
+			}
+			objects = append(objects, o)
+		}
+	}
+	return configs, objects, nil
+}
+
+func printResult(w io.Writer, format string, res *Result) error {
+	if format == jsonOutput {
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", name, value)
+		}
+	}
+	row("Listener", res.Listener)
+	row("Filter chain", res.FilterChain)
+	row("Route config", res.RouteConfig)
+	row("Virtual host", res.VirtualHost)
+	row("Route", res.Route)
+	row("Cluster", res.Cluster)
+	row("Downstream TLS", string(res.DownstreamTLS))
+	upstream := string(res.UpstreamTLS)
+	if res.AutoMTLS {
+		upstream += " (auto mTLS: only to endpoints with a sidecar)"
+	}
+	row("Upstream TLS", upstream)
+	authz := string(res.Authorization)
+	if res.AuthorizationPolicy != "" {
+		authz += " (" + res.AuthorizationPolicy + ")"
+	}
+	row("Authorization", authz)
+	row("Error", res.Error)
+	return tw.Flush()
+}
@@ -0,0 +1,255 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"istio.io/istio/istioctl/pkg/cli"
+	"istio.io/istio/pilot/pkg/simulation"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+var (
+	testFiles  = []string{"testdata/config.yaml", "testdata/services.yaml"}
+	authzFiles = append([]string{"testdata/authz.yaml"}, testFiles...)
+)
+
+func TestSimulate(t *testing.T) {
+	cases := []struct {
+		name      string
+		args      simulateArgs
+		namespace string
+		want      *Result
+		wantErr   string
+	}{
+		{
+			name: "sidecar outbound",
+			args: simulateArgs{host: "reviews:9080", port: 9080, path: "/v2/reviews"},
+			want: &Result{
+				Listener:      "0.0.0.0_9080",
+				RouteConfig:   "9080",
+				VirtualHost:   "reviews.default.svc.cluster.local:9080",
+				Route:         "v2",
+				Cluster:       "outbound|9080|v2|reviews.default.svc.cluster.local",
+				DownstreamTLS: simulation.Plaintext,
+				UpstreamTLS:   simulation.MTLS,
+				AutoMTLS:      true,
+			},
+		},
+		{
+			name: "sidecar outbound default route",
+			args: simulateArgs{host: "reviews.default.svc.cluster.local", port: 9080, path: "/"},
+			want: &Result{
+				Listener:      "0.0.0.0_9080",
+				RouteConfig:   "9080",
+				VirtualHost:   "reviews.default.svc.cluster.local:9080",
+				Route:         "default",
+				Cluster:       "outbound|9080|v1|reviews.default.svc.cluster.local",
+				DownstreamTLS: simulation.Plaintext,
+				UpstreamTLS:   simulation.MTLS,
+				AutoMTLS:      true,
+			},
+		},
+		{
+			name:      "gateway",
+			args:      simulateArgs{proxyType: "router", labels: map[string]string{"istio": "ingressgateway"}, host: "bookinfo.example.com", port: 80},
+			namespace: "istio-system",
+			want: &Result{
+				Listener:      "0.0.0.0_80",
+				RouteConfig:   "http.80",
+				VirtualHost:   "bookinfo.example.com:80",
+				Cluster:       "outbound|9080||reviews.default.svc.cluster.local",
+				DownstreamTLS: simulation.Plaintext,
+				UpstreamTLS:   simulation.MTLS,
+				AutoMTLS:      true,
+			},
+		},
+		{
+			name: "gateway unknown host",
+			args: simulateArgs{
+				proxyType: "router", labels: map[string]string{"istio": "ingressgateway"},
+				host: "other.example.com", port: 80,
+			},
+			namespace: "istio-system",
+			want: &Result{
+				Listener:      "0.0.0.0_80",
+				RouteConfig:   "http.80",
+				DownstreamTLS: simulation.Plaintext,
+				Error:         simulation.ErrNoVirtualHost.Error(),
+			},
+		},
+		{
+			name: "inbound mtls",
+			args: simulateArgs{
+				labels: map[string]string{"app": "reviews", "version": "v1"}, ip: "10.0.0.1",
+				mode: "inbound", address: "10.0.0.1", port: 9080, tls: "mtls",
+			},
+			want: &Result{
+				Listener:      "virtualInbound",
+				FilterChain:   "0.0.0.0_9080",
+				VirtualHost:   "inbound|http|9080",
+				Route:         "default",
+				Cluster:       "inbound|9080||",
+				DownstreamTLS: simulation.MTLS,
+				UpstreamTLS:   simulation.Plaintext,
+			},
+		},
+		{
+			name: "inbound plaintext rejected",
+			args: simulateArgs{
+				labels: map[string]string{"app": "reviews", "version": "v1"}, ip: "10.0.0.1",
+				mode: "inbound", address: "10.0.0.1", port: 9080,
+			},
+			want: &Result{
+				Listener: "virtualInbound",
+				Error:    simulation.ErrNoFilterChain.Error(),
+			},
+		},
+		{
+			name: "inbound authorized",
+			args: simulateArgs{
+				files:  authzFiles,
+				labels: map[string]string{"app": "reviews", "version": "v1"}, ip: "10.0.0.1",
+				mode: "inbound", address: "10.0.0.1", port: 9080, tls: "mtls",
+				sourcePrincipal: "cluster.local/ns/default/sa/productpage",
+			},
+			want: &Result{
+				Listener:            "virtualInbound",
+				FilterChain:         "0.0.0.0_9080",
+				VirtualHost:         "inbound|http|9080",
+				Route:               "default",
+				Cluster:             "inbound|9080||",
+				DownstreamTLS:       simulation.MTLS,
+				UpstreamTLS:         simulation.Plaintext,
+				Authorization:       simulation.RBACAllow,
+				AuthorizationPolicy: "ns[default]-policy[reviews]-rule[0]",
+			},
+		},
+		{
+			name: "inbound unauthorized method",
+			args: simulateArgs{
+				files:  authzFiles,
+				labels: map[string]string{"app": "reviews", "version": "v1"}, ip: "10.0.0.1",
+				mode: "inbound", address: "10.0.0.1", port: 9080, tls: "mtls", method: "POST",
+				sourcePrincipal: "cluster.local/ns/default/sa/productpage",
+			},
+			want: &Result{
+				Listener:      "virtualInbound",
+				FilterChain:   "0.0.0.0_9080",
+				DownstreamTLS: simulation.MTLS,
+				Authorization: simulation.RBACDeny,
+				Error:         simulation.ErrRBACDenied.Error(),
+			},
+		},
+		{
+			name: "inbound authorized by jwt",
+			args: simulateArgs{
+				files:  authzFiles,
+				labels: map[string]string{"app": "reviews", "version": "v1"}, ip: "10.0.0.1",
+				mode: "inbound", address: "10.0.0.1", port: 9080, tls: "mtls", method: "POST",
+				sourceNamespace: "other", jwtClaims: []string{"iss=example.com", "sub=alice"},
+			},
+			want: &Result{
+				Listener:            "virtualInbound",
+				FilterChain:         "0.0.0.0_9080",
+				VirtualHost:         "inbound|http|9080",
+				Route:               "default",
+				Cluster:             "inbound|9080||",
+				DownstreamTLS:       simulation.MTLS,
+				UpstreamTLS:         simulation.Plaintext,
+				Authorization:       simulation.RBACAllow,
+				AuthorizationPolicy: "ns[default]-policy[reviews]-rule[1]",
+			},
+		},
+		{
+			name:    "invalid jwt claim",
+			args:    simulateArgs{jwtClaims: []string{"iss"}},
+			wantErr: `invalid JWT claim "iss"`,
+		},
+		{
+			name:    "invalid protocol",
+			args:    simulateArgs{protocol: "udp"},
+			wantErr: `unknown protocol "udp"`,
+		},
+		{
+			name:    "invalid proxy type",
+			args:    simulateArgs{proxyType: "ztunnel"},
+			wantErr: `unknown proxy type "ztunnel"`,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.args
+			if a.files == nil {
+				a.files = testFiles
+			}
+			if a.proxyType == "" {
+				a.proxyType = "sidecar"
+			}
+			if a.protocol == "" {
+				a.protocol = "http"
+			}
+			if a.tls == "" {
+				a.tls = "plaintext"
+			}
+			if a.method == "" {
+				a.method = "GET"
+			}
+			if tt.namespace == "" {
+				tt.namespace = "default"
+			}
+			got, err := simulate(a, tt.namespace)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("want error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestSimulateCommand(t *testing.T) {
+	cmd := Cmd(cli.NewFakeContext(&cli.NewFakeContextOption{Namespace: "default"}))
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs([]string{"-f", strings.Join(testFiles, ","), "--host", "reviews", "--port", "9080", "--path", "/v2"})
+	assert.NoError(t, cmd.Execute())
+	assert.Equal(t, out.String(), `Listener:       0.0.0.0_9080
+Route config:   9080
+Virtual host:   reviews.default.svc.cluster.local:9080
+Route:          v2
+Cluster:        outbound|9080|v2|reviews.default.svc.cluster.local
+Downstream TLS: plaintext
+Upstream TLS:   mtls (auto mTLS: only to endpoints with a sidecar)
+`)
+
+	// A call rejected by the proxy fails
+	cmd = Cmd(cli.NewFakeContext(&cli.NewFakeContextOption{Namespace: "default"}))
+	out.Reset()
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs([]string{"-f", strings.Join(testFiles, ","), "--port", "9080", "--mode", "inbound", "--labels", "app=reviews", "-o", "json"})
+	err := cmd.Execute()
+	assert.Error(t, err)
+	assert.Equal(t, strings.Contains(out.String(), `"error": "no filter chains matched"`), true)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/admissionregistration/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	klabels "k8s.io/apimachinery/pkg/labels"
 
@@ -44,6 +45,7 @@ import (
 	tutil "istio.io/istio/pilot/test/util"
 	"istio.io/istio/pkg/file"
 	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/slices"
 	"istio.io/istio/pkg/test"
 	"istio.io/istio/pkg/test/env"
 	"istio.io/istio/pkg/test/util/assert"
@@ -608,6 +610,41 @@ func TestManifestGeneratePilot(t *testing.T) {
 	})
 }
 
+func TestManifestGeneratePilotACME(t *testing.T) {
+	// secretsAllowed returns whether istiod may perform verb on secrets in any namespace.
+	secretsAllowed := func(flags, verb string) bool {
+		g := NewWithT(t)
+		m, _, err := generateManifest("pilot_default", flags, liveCharts, []string{"templates/clusterrole.yaml"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs, err := parseObjectSetFromManifest(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cr := mustGetClusterRole(g, objs, "istiod-clusterrole-istio-system")
+		var rules []rbacv1.PolicyRule
+		by, err := json.Marshal(mustGetPath(t, *cr, "rules"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(by, &rules); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rules {
+			if slices.Contains(r.APIGroups, "") && slices.Contains(r.Resources, "secrets") && slices.Contains(r.Verbs, verb) {
+				return true
+			}
+		}
+		return false
+	}
+	// The ACME controller writes the certificates in the Gateway namespaces.
+	for _, verb := range []string{"create", "update"} {
+		assert.Equal(t, secretsAllowed("", verb), false)
+		assert.Equal(t, secretsAllowed("-s values.pilot.env.ACME_DIRECTORY_URL=https://acme.example.com/directory", verb), true)
+	}
+}
+
 func TestManifestGenerateGateway(t *testing.T) {
 	runTestGroup(t, testGroup{
 		{
@@ -78,6 +78,7 @@ func NewRootCommand(sds istioagent.SDSServiceFactory) *cobra.Command {
 	rootCmd.AddCommand(proxyCmd)
 	rootCmd.AddCommand(requestCmd)
 	rootCmd.AddCommand(waitCmd)
+	rootCmd.AddCommand(newDNSCommand())
 	rootCmd.AddCommand(version.CobraCommand())
 	rootCmd.AddCommand(iptables.GetCommand(loggingOptions))
 	rootCmd.AddCommand(cleaniptables.GetCommand(loggingOptions))
@@ -0,0 +1,187 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"istio.io/istio/pilot/cmd/pilot-agent/options"
+	istiogrpc "istio.io/istio/pilot/pkg/grpc"
+	"istio.io/istio/pkg/cmd"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/dns/authoritative"
+	istioagent "istio.io/istio/pkg/istio-agent"
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/security"
+	"istio.io/istio/pkg/version"
+	"istio.io/istio/security/pkg/credentialfetcher"
+	"istio.io/istio/security/pkg/nodeagent/caclient"
+)
+
+type dnsArgs struct {
+	discoveryAddress string
+	xdsSAN           string
+	rootCert         string
+	certChain        string
+	key              string
+	jwtPath          string
+	trustDomain      string
+	plaintext        bool
+
+	namespaces      []string
+	clusterID       string
+	dnsAutoAllocate bool
+
+	listen        string
+	zones         []string
+	nameserver    string
+	allowTransfer []string
+	notify        []string
+}
+
+// newDNSCommand returns the command running a standalone DNS server, authoritative for the names of the mesh.
+// Unlike the DNS proxy of the sidecars, it is meant to be queried by clients outside of the mesh, or by other
+// DNS servers forwarding or transferring the mesh zones.
+func newDNSCommand() *cobra.Command {
+	args := dnsArgs{}
+	dnsCmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Runs a DNS server authoritative for the mesh names",
+		Long: "Runs a DNS server authoritative for the cluster domain and the ServiceEntry hosts, fed by the name " +
+			"tables istiod sends to the sidecars of the given namespaces. Zone transfers (AXFR) are allowed over TCP " +
+			"to the peers in --allowTransfer.\n\n" +
+			"Unless XDS authentication is disabled in istiod, the identity of the server must belong to each of " +
+			"the namespaces, so a server usually serves the names visible from its own namespace.",
+		PersistentPreRunE: configureLogging,
+		RunE: func(c *cobra.Command, _ []string) error {
+			cmd.PrintFlags(c.Flags())
+			log.Infof("Version %s", version.Info.String())
+			return runDNS(args)
+		},
+	}
+	dnsCmd.PersistentFlags().StringVar(&args.discoveryAddress, "discoveryAddress", "istiod.istio-system.svc:15012",
+		"Address of the XDS server")
+	dnsCmd.PersistentFlags().StringVar(&args.xdsSAN, "xdsSAN", "",
+		"Expected SAN of the XDS server, defaults to the host of --discoveryAddress")
+	dnsCmd.PersistentFlags().StringVar(&args.rootCert, "rootCert",
+		path.Join(istioagent.CitadelCACertPath, constants.CACertNamespaceConfigMapDataName),
+		"Root certificate used to verify the XDS server")
+	dnsCmd.PersistentFlags().StringVar(&args.certChain, "certChain", "",
+		"Client certificate chain used to authenticate to the XDS server")
+	dnsCmd.PersistentFlags().StringVar(&args.key, "key", "",
+		"Client key used to authenticate to the XDS server")
+	dnsCmd.PersistentFlags().StringVar(&args.jwtPath, "jwtPath", constants.ThirdPartyJwtPath,
+		"Token used to authenticate to the XDS server, if it exists")
+	dnsCmd.PersistentFlags().StringVar(&args.trustDomain, "trustDomain", constants.DefaultClusterLocalDomain,
+		"Trust domain of the server")
+	dnsCmd.PersistentFlags().BoolVar(&args.plaintext, "plaintext", false,
+		"Connect to the XDS server without TLS, such as on port 15010")
+	dnsCmd.PersistentFlags().StringSliceVar(&args.namespaces, "namespaces", []string{options.PodNamespaceVar.Get()},
+		"Namespaces whose visible names are served")
+	dnsCmd.PersistentFlags().StringVar(&args.clusterID, "clusterID", "",
+		"Cluster of the server, whose endpoints are preferred for headless services")
+	dnsCmd.PersistentFlags().BoolVar(&args.dnsAutoAllocate, "dnsAutoAllocate", false,
+		"Serve the automatically allocated addresses of the ServiceEntries without addresses")
+	dnsCmd.PersistentFlags().StringVar(&args.listen, "listen", ":53",
+		"Address the server listens on, over both UDP and TCP")
+	dnsCmd.PersistentFlags().StringSliceVar(&args.zones, "zones", []string{constants.DefaultClusterLocalDomain},
+		"Zones the server is authoritative for, in addition to the ServiceEntry hosts")
+	dnsCmd.PersistentFlags().StringVar(&args.nameserver, "nameserver", "",
+		"Name server of the SOA records, defaults to ns.<zone>")
+	dnsCmd.PersistentFlags().StringSliceVar(&args.allowTransfer, "allowTransfer", nil,
+		"CIDRs of the peers allowed to transfer the zones")
+	dnsCmd.PersistentFlags().StringSliceVar(&args.notify, "notify", nil,
+		"Addresses of the secondary servers notified when the zones change")
+	return dnsCmd
+}
+
+func runDNS(args dnsArgs) error {
+	allowTransfer := make([]netip.Prefix, 0, len(args.allowTransfer))
+	for _, cidr := range args.allowTransfer {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid --allowTransfer %q: %v", cidr, err)
+		}
+		allowTransfer = append(allowTransfer, prefix)
+	}
+	if len(args.namespaces) == 0 || args.namespaces[0] == "" {
+		return fmt.Errorf("--namespaces is required")
+	}
+	dialOptions, err := dnsDialOptions(args)
+	if err != nil {
+		return err
+	}
+
+	server, err := authoritative.NewServer(authoritative.Options{
+		Addr:          args.listen,
+		Zones:         args.zones,
+		Nameserver:    args.nameserver,
+		AllowTransfer: allowTransfer,
+		Notify:        args.notify,
+	})
+	if err != nil {
+		return err
+	}
+	server.Start()
+	defer server.Close()
+	log.Infof("DNS server listening on %s", server.Address())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// On SIGINT or SIGTERM, cancel the context, triggering a graceful shutdown
+	go cmd.WaitSignalFunc(cancel)
+
+	nds := authoritative.NewNDSClient(authoritative.NDSOptions{
+		Address:         args.discoveryAddress,
+		DialOptions:     dialOptions,
+		Namespaces:      args.namespaces,
+		IP:              options.InstanceIPVar.Get(),
+		ClusterID:       args.clusterID,
+		DNSAutoAllocate: args.dnsAutoAllocate,
+	}, server.UpdateNameTable)
+	return nds.Run(ctx)
+}
+
+func dnsDialOptions(args dnsArgs) ([]grpc.DialOption, error) {
+	var tlsOpts *istiogrpc.TLSOptions
+	if !args.plaintext {
+		tlsOpts = &istiogrpc.TLSOptions{
+			RootCert:      args.rootCert,
+			Key:           args.key,
+			Cert:          args.certChain,
+			ServerAddress: args.discoveryAddress,
+			SAN:           args.xdsSAN,
+		}
+	}
+	dialOptions, err := istiogrpc.ClientOptions(nil, tlsOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the connection to %s: %v", args.discoveryAddress, err)
+	}
+	if args.jwtPath != "" {
+		credFetcher, err := credentialfetcher.NewCredFetcher(security.JWT, args.trustDomain, args.jwtPath, "")
+		if err != nil {
+			return nil, err
+		}
+		dialOptions = append(dialOptions,
+			grpc.WithPerRPCCredentials(caclient.NewDefaultTokenProvider(&security.Options{CredFetcher: credFetcher})))
+	}
+	return dialOptions, nil
+}
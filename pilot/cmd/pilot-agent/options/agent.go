@@ -21,6 +21,7 @@ import (
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pkg/bootstrap/platform"
+	dnsClient "istio.io/istio/pkg/dns/client"
 	istioagent "istio.io/istio/pkg/istio-agent"
 	"istio.io/istio/pkg/util/sets"
 	"istio.io/istio/pkg/wasm"
@@ -34,6 +35,10 @@ func NewAgentOptions(proxy *ProxyArgs, cfg *meshconfig.ProxyConfig, sds istioage
 	if wasmInsecureRegistries != "" {
 		insecureRegistries = strings.Split(wasmInsecureRegistries, ",")
 	}
+	var dnsUpstreamServers []string
+	if dnsUpstreams != "" {
+		dnsUpstreamServers = strings.Split(dnsUpstreams, ",")
+	}
 	o := &istioagent.AgentOptions{
 		XDSRootCerts:             xdsRootCA,
 		CARootCerts:              caRootCA,
@@ -62,13 +67,25 @@ func NewAgentOptions(proxy *ProxyArgs, cfg *meshconfig.ProxyConfig, sds istioage
 		ProxyXDSDebugViaAgentPort:   proxyXDSDebugViaAgentPort,
 		DNSCapture:                  DNSCaptureByAgent.Get(),
 		DNSForwardParallel:          DNSForwardParallel.Get(),
-		DNSAddr:                     DNSCaptureAddr.Get(),
-		ProxyNamespace:              PodNamespaceVar.Get(),
-		ProxyDomain:                 proxy.DNSDomain,
-		IstiodSAN:                   istiodSAN.Get(),
-		UseExternalWorkloadSDS:      useExternalWorkloadSDSEnv,
-		MetadataDiscovery:           enableWDSEnv,
-		SDSFactory:                  sds,
+		DNSCache: dnsClient.CacheOptions{
+			MaxEntries:     dnsCacheMaxEntries,
+			MaxTTL:         dnsCacheMaxTTL,
+			MaxNegativeTTL: dnsCacheMaxNegativeTTL,
+			Prefetch:       dnsCachePrefetch,
+			ServeStale:     dnsCacheServeStale,
+		},
+		DNSUpstreams: dnsClient.UpstreamOptions{
+			Servers:      dnsUpstreamServers,
+			RootCertFile: dnsUpstreamRootCert,
+		},
+		DNSUpstreamWorkloadCert: dnsUpstreamWorkloadCert,
+		DNSAddr:                 DNSCaptureAddr.Get(),
+		ProxyNamespace:          PodNamespaceVar.Get(),
+		ProxyDomain:             proxy.DNSDomain,
+		IstiodSAN:               istiodSAN.Get(),
+		UseExternalWorkloadSDS:  useExternalWorkloadSDSEnv,
+		MetadataDiscovery:       enableWDSEnv,
+		SDSFactory:              sds,
 	}
 	extractXDSHeadersFromEnv(o)
 	return o
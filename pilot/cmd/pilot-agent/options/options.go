@@ -74,14 +74,23 @@ var (
 	fileDebounceDuration = env.Register("FILE_DEBOUNCE_DURATION", 100*time.Millisecond,
 		"The duration for which the file read operation is delayed once file update is detected").Get()
 
+	crlRefreshIntervalEnv = env.Register("CA_CRL_REFRESH_INTERVAL", time.Duration(0),
+		"If set, the interval between fetches of the certificate revocation list of the CA, used by the proxy to reject "+
+			"revoked peer certificates. Requires ENABLE_CA_REVOCATION in Istiod. The CRL is only enforced when the CA "+
+			"signing the workload certificates is the only root of the trust bundle, as certificates from other CAs "+
+			"would be rejected without a CRL.").Get()
+
 	secretRotationGracePeriodRatioEnv = env.Register("SECRET_GRACE_PERIOD_RATIO", 0.5,
 		"The grace period ratio for the cert rotation, by default 0.5.").Get()
 	workloadRSAKeySizeEnv = env.Register("WORKLOAD_RSA_KEY_SIZE", 2048,
 		"Specify the RSA key size to use for workload certificates.").Get()
 	pkcs8KeysEnv = env.Register("PKCS8_KEY", false,
 		"Whether to generate PKCS#8 private keys").Get()
-	eccSigAlgEnv        = env.Register("ECC_SIGNATURE_ALGORITHM", "", "The type of ECC signature algorithm to use when generating private keys").Get()
-	eccCurvEnv          = env.Register("ECC_CURVE", "P256", "The elliptic curve to use when ECC_SIGNATURE_ALGORITHM is set to ECDSA").Get()
+	eccSigAlgEnv = env.Register("ECC_SIGNATURE_ALGORITHM", "",
+		"The type of ECC signature algorithm to use when generating private keys: ECDSA or ED25519. RSA is used if empty. "+
+			"ED25519 is not supported by Envoy, and is only allowed with DISABLE_ENVOY, such as for proxyless gRPC").Get()
+	eccCurvEnv = env.Register("ECC_CURVE", "P256",
+		"The elliptic curve to use when ECC_SIGNATURE_ALGORITHM is set to ECDSA: P256, P384 or P521").Get()
 	fileMountedCertsEnv = env.Register("FILE_MOUNTED_CERTS", false, "").Get()
 	credFetcherTypeEnv  = env.Register("CREDENTIAL_FETCHER_TYPE", security.JWT,
 		"The type of the credential fetcher. Currently supported types include GoogleComputeEngine").Get()
@@ -102,6 +111,31 @@ var (
 	DNSForwardParallel = env.Register("DNS_FORWARD_PARALLEL", false,
 		"If set to true, agent will send parallel DNS queries to all upstream nameservers")
 
+	dnsUpstreams = env.Register("DNS_UPSTREAMS", "",
+		"Comma separated list of the upstream DNS servers of the agent, in order of preference, as URLs: "+
+			"udp://host:port, tcp://host:port, tls://ip:port for DNS-over-TLS or https://ip/path for DNS-over-HTTPS. "+
+			"The name of TLS servers is set with a servername parameter, such as tls://1.1.1.1?servername=cloudflare-dns.com. "+
+			"If empty, the DNS_UPSTREAMS proxy metadata is used, which may be set for the whole mesh, "+
+			"or else the nameservers of resolv.conf.").Get()
+	dnsUpstreamRootCert = env.Register("DNS_UPSTREAM_ROOT_CERT", "",
+		"File with the PEM encoded root certificates used to verify TLS upstream DNS servers. "+
+			"If empty, the DNS_UPSTREAM_ROOT_CERT proxy metadata is used, or else the system roots.").Get()
+	dnsUpstreamWorkloadCert = env.Register("DNS_UPSTREAM_WORKLOAD_CERT", false,
+		"If set to true, or if the DNS_UPSTREAM_WORKLOAD_CERT proxy metadata is true, the agent presents the "+
+			"workload certificate to TLS upstream DNS servers.").Get()
+
+	dnsCacheMaxEntries = env.Register("DNS_CACHE_MAX_ENTRIES", 0,
+		"Maximum number of upstream DNS responses cached by the agent. If 0, upstream responses are not cached.").Get()
+	dnsCacheMaxTTL = env.Register("DNS_CACHE_MAX_TTL", 5*time.Minute,
+		"Maximum time an upstream DNS response is cached, whatever the TTL of its records.").Get()
+	dnsCacheMaxNegativeTTL = env.Register("DNS_CACHE_MAX_NEGATIVE_TTL", time.Minute,
+		"Maximum time a negative (NXDOMAIN or NODATA) upstream DNS response is cached.").Get()
+	dnsCachePrefetch = env.Register("DNS_CACHE_PREFETCH", true,
+		"If set to true, cached upstream DNS responses which are frequently used are refreshed before they expire.").Get()
+	dnsCacheServeStale = env.Register("DNS_CACHE_SERVE_STALE", time.Duration(0),
+		"How long an expired upstream DNS response may be served when the upstream servers fail. If 0, expired "+
+			"responses are never served.").Get()
+
 	// Ability of istio-agent to retrieve proxyConfig via XDS for dynamic configuration updates
 	enableProxyConfigXdsEnv = env.Register("PROXY_CONFIG_XDS_AGENT", false,
 		"If set to true, agent retrieves dynamic proxy-config updates via xds channel").Get()
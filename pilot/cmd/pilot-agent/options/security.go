@@ -26,6 +26,7 @@ import (
 	"istio.io/istio/pkg/security"
 	"istio.io/istio/security/pkg/credentialfetcher"
 	"istio.io/istio/security/pkg/nodeagent/cafile"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
 )
 
 func NewSecurityOptions(proxyConfig *meshconfig.ProxyConfig, stsPort int, tokenManagerPlugin string) (*security.Options, error) {
@@ -48,6 +49,7 @@ func NewSecurityOptions(proxyConfig *meshconfig.ProxyConfig, stsPort int, tokenM
 		SecretTTL:                      secretTTLEnv,
 		FileDebounceDuration:           fileDebounceDuration,
 		SecretRotationGracePeriodRatio: secretRotationGracePeriodRatioEnv,
+		CRLRefreshInterval:             crlRefreshIntervalEnv,
 		STSPort:                        stsPort,
 		CertSigner:                     certSigner.Get(),
 		CARootPath:                     cafile.CACertFilePath,
@@ -56,6 +58,10 @@ func NewSecurityOptions(proxyConfig *meshconfig.ProxyConfig, stsPort int, tokenM
 		RootCertFilePath:               security.DefaultRootCertFilePath,
 	}
 
+	if err := validateSignatureAlgorithm(o.ECCSigAlg, disableEnvoyEnv); err != nil {
+		return nil, err
+	}
+
 	o, err := SetupSecurityOptions(proxyConfig, o, jwtPolicy.Get(),
 		credFetcherTypeEnv, credIdentityProvider)
 	if err != nil {
@@ -65,6 +71,17 @@ func NewSecurityOptions(proxyConfig *meshconfig.ProxyConfig, stsPort int, tokenM
 	return o, err
 }
 
+// validateSignatureAlgorithm rejects the signature algorithms of workload keys which Envoy does not support.
+// Envoy only accepts RSA and ECDSA certificates, so Ed25519 keys are limited to agents without Envoy, such as
+// the agents of proxyless gRPC workloads.
+func validateSignatureAlgorithm(sigAlg string, disableEnvoy bool) error {
+	if sigAlg == string(pkiutil.Ed25519SigAlg) && !disableEnvoy {
+		return fmt.Errorf("invalid options: ECC_SIGNATURE_ALGORITHM=%s is not supported by Envoy, "+
+			"it can only be used with DISABLE_ENVOY", sigAlg)
+	}
+	return nil
+}
+
 func SetupSecurityOptions(proxyConfig *meshconfig.ProxyConfig, secOpt *security.Options, jwtPolicy,
 	credFetcherTypeEnv, credIdentityProvider string,
 ) (*security.Options, error) {
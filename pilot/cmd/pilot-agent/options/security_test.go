@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"istio.io/istio/pkg/security"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
 )
 
 func TestCheckGkeWorkloadCertificate(t *testing.T) {
@@ -59,3 +60,22 @@ func TestCheckGkeWorkloadCertificate(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateSignatureAlgorithm(t *testing.T) {
+	tests := []struct {
+		sigAlg       string
+		disableEnvoy bool
+		expectErr    bool
+	}{
+		{sigAlg: "", disableEnvoy: false, expectErr: false},
+		{sigAlg: string(pkiutil.EcdsaSigAlg), disableEnvoy: false, expectErr: false},
+		{sigAlg: string(pkiutil.Ed25519SigAlg), disableEnvoy: false, expectErr: true},
+		{sigAlg: string(pkiutil.Ed25519SigAlg), disableEnvoy: true, expectErr: false},
+	}
+	for _, tt := range tests {
+		err := validateSignatureAlgorithm(tt.sigAlg, tt.disableEnvoy)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("validateSignatureAlgorithm(%q, %t): expected error %t, got %v", tt.sigAlg, tt.disableEnvoy, tt.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/kube"
+)
+
+const (
+	// AccountSecretName is the Secret, in the istiod namespace, holding the ACME account key.
+	AccountSecretName = "istio-acme-account"
+	accountKey        = "key.pem"
+)
+
+// LoadOrCreateAccountKey returns the ACME account key stored in namespace, creating it if needed.
+// The key is shared by the istiod replicas, so the account is only registered once.
+func LoadOrCreateAccountKey(kc kube.Client, namespace string) (crypto.Signer, error) {
+	secrets := kc.Kube().CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(context.TODO(), AccountSecretName, metav1.GetOptions{})
+	if err == nil {
+		return parseAccountKey(secret.Data[accountKey])
+	}
+	if !kerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get ACME account: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = secrets.Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: AccountSecretName, Namespace: namespace},
+		Data:       map[string][]byte{accountKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})},
+	}, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		// Created concurrently by another replica.
+		return LoadOrCreateAccountKey(kc, namespace)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME account: %v", err)
+	}
+	return key, nil
+}
+
+func parseAccountKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid ACME account key in %s", AccountSecretName)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACME account key in %s: %v", AccountSecretName, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("invalid ACME account key in %s: unsupported type %T", AccountSecretName, key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,148 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klabels "k8s.io/apimachinery/pkg/labels"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/controllers"
+	"istio.io/istio/pkg/kube/kclient"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/security/pkg/pki/ra"
+)
+
+const (
+	challengeHost             = "host"
+	challengeToken            = "token"
+	challengeKeyAuthorization = "keyAuthorization"
+	challengeCert             = "tls.crt"
+	challengeKey              = "tls.key"
+)
+
+// ChallengeStore holds the pending ACME challenges as Secrets in the istiod namespace, so every
+// istiod replica configures its gateways to answer them.
+type ChallengeStore struct {
+	namespace        string
+	secrets          kclient.Client[*v1.Secret]
+	propagationDelay time.Duration
+}
+
+var (
+	_ model.ACMEChallengeSource = &ChallengeStore{}
+	_ ra.ACMEChallengeSolver    = &ChallengeStore{}
+)
+
+// NewChallengeStore creates a ChallengeStore. Present waits for propagationDelay, to let the
+// gateways receive the challenge responses before they are validated.
+func NewChallengeStore(kc kube.Client, namespace string, propagationDelay time.Duration) *ChallengeStore {
+	return &ChallengeStore{
+		namespace: namespace,
+		secrets: kclient.NewFiltered[*v1.Secret](kc, kclient.Filter{
+			Namespace:     namespace,
+			LabelSelector: ChallengeLabel,
+		}),
+		propagationDelay: propagationDelay,
+	}
+}
+
+// AddEventHandler registers a handler called with the host of a challenge when it changes.
+func (s *ChallengeStore) AddEventHandler(h func(host string)) {
+	s.secrets.AddEventHandler(controllers.ObjectHandler(func(o controllers.Object) {
+		h(string(o.(*v1.Secret).Data[challengeHost]))
+	}))
+}
+
+func (s *ChallengeStore) HasSynced() bool {
+	return s.secrets.HasSynced()
+}
+
+// ACMEChallenges returns the pending challenges.
+func (s *ChallengeStore) ACMEChallenges() []model.ACMEChallenge {
+	secrets := s.secrets.List(s.namespace, klabels.Everything())
+	slices.SortBy(secrets, func(sec *v1.Secret) string {
+		return sec.Name
+	})
+	return slices.Map(secrets, func(sec *v1.Secret) model.ACMEChallenge {
+		return model.ACMEChallenge{
+			Host:             string(sec.Data[challengeHost]),
+			Token:            string(sec.Data[challengeToken]),
+			KeyAuthorization: string(sec.Data[challengeKeyAuthorization]),
+			Certificate:      sec.Data[challengeCert],
+			Key:              sec.Data[challengeKey],
+		}
+	})
+}
+
+// Present stores the challenge response, and waits for it to propagate to the gateways.
+func (s *ChallengeStore) Present(ctx context.Context, ch ra.ACMEChallenge) error {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      challengeSecretName(ch),
+			Namespace: s.namespace,
+			Labels:    map[string]string{ChallengeLabel: ch.Type},
+		},
+		Data: map[string][]byte{
+			challengeHost: []byte(ch.Host),
+		},
+	}
+	switch ch.Type {
+	case ra.ACMEChallengeHTTP01:
+		secret.Data[challengeToken] = []byte(ch.Token)
+		secret.Data[challengeKeyAuthorization] = []byte(ch.KeyAuthorization)
+	case ra.ACMEChallengeTLSALPN01:
+		secret.Data[challengeCert] = ch.CertificatePEM
+		secret.Data[challengeKey] = ch.KeyPEM
+	default:
+		return fmt.Errorf("unsupported challenge type %q", ch.Type)
+	}
+	if _, err := s.secrets.Create(secret); err != nil {
+		if !kerrors.IsAlreadyExists(err) {
+			return err
+		}
+		if _, err := s.secrets.Update(secret); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.propagationDelay):
+		return nil
+	}
+}
+
+// CleanUp removes the challenge response.
+func (s *ChallengeStore) CleanUp(ch ra.ACMEChallenge) error {
+	if err := s.secrets.Delete(challengeSecretName(ch), s.namespace); err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func challengeSecretName(ch ra.ACMEChallenge) string {
+	h := sha256.Sum256([]byte(ch.Type + "/" + ch.Host + "/" + ch.Token))
+	return "istio-acme-challenge-" + hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,279 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acme provisions gateway certificates from an ACME CA.
+//
+// Gateways opt in with the networking.istio.io/acme annotation. For each TLS server terminating
+// with a credentialName, a certificate for the server hosts is obtained from the ACME CA and
+// stored as a kubernetes.io/tls Secret, read by the gateways like any other credential. The ACME
+// challenges are answered by the gateways themselves: pending challenges are stored in the
+// ChallengeStore and injected in the gateway configuration.
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	networking "istio.io/api/networking/v1alpha3"
+	networkingclient "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	credkube "istio.io/istio/pilot/pkg/credentials/kube"
+	"istio.io/istio/pkg/config/schema/gvr"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/controllers"
+	"istio.io/istio/pkg/kube/kclient"
+	"istio.io/istio/pkg/kube/kubetypes"
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/util/istiomultierror"
+	"istio.io/istio/pkg/util/sets"
+	"istio.io/istio/security/pkg/pki/ra"
+)
+
+var log = istiolog.RegisterScope("acme", "ACME gateway certificates")
+
+const (
+	// EnabledAnnotation opts a Gateway in to certificates from the ACME CA, when set to "true".
+	EnabledAnnotation = "networking.istio.io/acme"
+	// ManagedLabel marks the credential Secrets written by the controller. Other Secrets are never modified.
+	ManagedLabel = "istio.io/acme-managed"
+	// HostsAnnotation records the hosts a managed certificate was issued for.
+	HostsAnnotation = "istio.io/acme-hosts"
+	// ChallengeLabel marks the Secrets holding pending challenge responses. Its value is the challenge type.
+	ChallengeLabel = "istio.io/acme-challenge"
+
+	// maxRetries bounds the retries of a failed reconciliation; the periodic resync retries later.
+	maxRetries = 5
+	// issueTimeout bounds the time to obtain a certificate.
+	issueTimeout = 5 * time.Minute
+)
+
+// Controller obtains certificates for the TLS servers of the annotated Gateways.
+type Controller struct {
+	ra             *ra.ACMERA
+	gateways       kclient.Informer[*networkingclient.Gateway]
+	secrets        kclient.Client[*v1.Secret]
+	queue          controllers.Queue
+	resyncInterval time.Duration
+	now            func() time.Time
+}
+
+// NewController creates a Controller. Certificates are checked for renewal every resyncInterval.
+func NewController(kc kube.Client, acmeRA *ra.ACMERA, resyncInterval time.Duration) *Controller {
+	c := &Controller{
+		ra:             acmeRA,
+		resyncInterval: resyncInterval,
+		now:            time.Now,
+	}
+	c.queue = controllers.NewQueue("acme controller",
+		controllers.WithReconciler(c.Reconcile),
+		controllers.WithMaxAttempts(maxRetries))
+	c.gateways = kclient.NewDelayedInformer[*networkingclient.Gateway](kc, gvr.Gateway, kubetypes.StandardInformer,
+		kclient.Filter{ObjectFilter: kc.ObjectFilter()})
+	c.gateways.AddEventHandler(controllers.FilteredObjectSpecHandler(c.queue.AddObject, func(o controllers.Object) bool {
+		return o.GetAnnotations()[EnabledAnnotation] == "true"
+	}))
+	c.secrets = kclient.NewFiltered[*v1.Secret](kc, kclient.Filter{LabelSelector: ManagedLabel})
+	// Reissue deleted certificates.
+	c.secrets.AddEventHandler(controllers.EventHandler[*v1.Secret]{
+		DeleteFunc: func(s *v1.Secret) {
+			c.enqueueGateways(s.Namespace)
+		},
+	})
+	return c
+}
+
+// Run starts the Controller until stop is closed.
+func (c *Controller) Run(stop <-chan struct{}) {
+	if !kube.WaitForCacheSync("acme controller", stop, c.gateways.HasSynced, c.secrets.HasSynced) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(c.resyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.enqueueGateways(metav1.NamespaceAll)
+			}
+		}
+	}()
+	c.queue.Run(stop)
+	controllers.ShutdownAll(c.gateways, c.secrets)
+}
+
+func (c *Controller) enqueueGateways(namespace string) {
+	for _, gw := range c.gateways.List(namespace, klabels.Everything()) {
+		if gw.Annotations[EnabledAnnotation] == "true" {
+			c.queue.AddObject(gw)
+		}
+	}
+}
+
+// Reconcile obtains the missing or expiring certificates of a Gateway.
+func (c *Controller) Reconcile(key types.NamespacedName) error {
+	gw := c.gateways.Get(key.Name, key.Namespace)
+	if gw == nil || gw.Annotations[EnabledAnnotation] != "true" {
+		// Certificates are left in place, as they may still be used.
+		return nil
+	}
+	var errs *multierror.Error
+	for credential, hosts := range credentialHosts(&gw.Spec) {
+		if err := c.reconcileCredential(key.Namespace, credential, hosts); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("credential %s/%s: %v", key.Namespace, credential, err))
+		}
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		errs.ErrorFormat = istiomultierror.MultiErrorFormat()
+		return errs
+	}
+	return nil
+}
+
+// credentialHosts returns the hosts to include in the certificate of each credential. Wildcard
+// hosts are skipped: they can only be validated with dns-01 challenges.
+func credentialHosts(gw *networking.Gateway) map[string][]string {
+	hosts := map[string]sets.String{}
+	for _, server := range gw.Servers {
+		tls := server.GetTls()
+		if tls.GetMode() != networking.ServerTLSSettings_SIMPLE || tls.GetCredentialName() == "" ||
+			strings.Contains(tls.GetCredentialName(), "://") {
+			continue
+		}
+		for _, h := range server.Hosts {
+			if _, name, ok := strings.Cut(h, "/"); ok {
+				h = name
+			}
+			if strings.Contains(h, "*") {
+				continue
+			}
+			if hosts[tls.CredentialName] == nil {
+				hosts[tls.CredentialName] = sets.New[string]()
+			}
+			hosts[tls.CredentialName].Insert(strings.ToLower(h))
+		}
+	}
+	out := make(map[string][]string, len(hosts))
+	for credential, h := range hosts {
+		out[credential] = sets.SortedList(h)
+	}
+	return out
+}
+
+func (c *Controller) reconcileCredential(namespace, name string, hosts []string) error {
+	existing := c.secrets.Get(name, namespace)
+	if existing != nil && existing.Labels[ManagedLabel] != "true" {
+		log.Warnf("credential %s/%s already exists and is not managed by ACME, skipping", namespace, name)
+		return nil
+	}
+	if existing != nil && !c.needsIssue(existing, hosts) {
+		return nil
+	}
+	log.Infof("requesting certificate for %v, stored in %s/%s", hosts, namespace, name)
+	chain, key, err := c.issue(hosts)
+	if err != nil {
+		return err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{ManagedLabel: "true"},
+			Annotations: map[string]string{HostsAnnotation: strings.Join(hosts, ",")},
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			credkube.TLSSecretCert: chain,
+			credkube.TLSSecretKey:  key,
+		},
+	}
+	if existing == nil {
+		if _, err := c.secrets.Create(secret); err != nil {
+			if kerrors.IsAlreadyExists(err) {
+				// Not managed by us; never overwrite a user provided credential.
+				log.Warnf("credential %s/%s already exists and is not managed by ACME, skipping", namespace, name)
+				return nil
+			}
+			return writeError(err)
+		}
+		return nil
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	_, err = c.secrets.Update(secret)
+	return writeError(err)
+}
+
+// writeError explains how to grant the permission to write the credentials, when it is missing.
+func writeError(err error) error {
+	if kerrors.IsForbidden(err) {
+		return fmt.Errorf("%v; istiod needs to create and update Secrets in the Gateway namespaces, "+
+			"which the chart grants when the pilot.env.ACME_DIRECTORY_URL value is set", err)
+	}
+	return err
+}
+
+// needsIssue returns true if the hosts changed, or the certificate is past two thirds of its lifetime.
+func (c *Controller) needsIssue(secret *v1.Secret, hosts []string) bool {
+	if secret.Annotations[HostsAnnotation] != strings.Join(hosts, ",") {
+		return true
+	}
+	block, _ := pem.Decode(secret.Data[credkube.TLSSecretCert])
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	renewAt := cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3)
+	return !c.now().Before(renewAt)
+}
+
+// issue obtains a certificate for hosts, returning the PEM chain and key.
+func (c *Controller) issue(hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: slices.Clone(hosts)}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	chain, err := c.ra.Issue(ctx, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return chain, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+}
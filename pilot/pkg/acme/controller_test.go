@@ -0,0 +1,266 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+	networkingclient "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	credkube "istio.io/istio/pilot/pkg/credentials/kube"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/gvr"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/kclient/clienttest"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/acmetest"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+	"istio.io/istio/security/pkg/pki/ra"
+)
+
+const istiodNamespace = "istio-system"
+
+// fakeGateway answers http-01 challenges from the store, like a gateway configured by istiod.
+func fakeGateway(t *testing.T, store *ChallengeStore) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range store.ACMEChallenges() {
+			if c.Host == r.Host && r.URL.Path == model.ACMEHTTP01PathPrefix+c.Token {
+				_, _ = w.Write([]byte(c.KeyAuthorization))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func setupController(t *testing.T) (kube.Client, *acmetest.Server, *ChallengeStore, *Controller) {
+	stop := test.NewStop(t)
+	kc := kube.NewFakeClient()
+	clienttest.MakeCRD(t, kc, gvr.Gateway)
+
+	server := acmetest.NewServer(t)
+	store := NewChallengeStore(kc, istiodNamespace, 0)
+	server.SetValidationAddresses(fakeGateway(t, store), "")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, server.ServerCACert(), 0o600))
+	key, err := LoadOrCreateAccountKey(kc, istiodNamespace)
+	assert.NoError(t, err)
+	acmeRA, err := ra.NewACMERA(ra.ACMEOptions{
+		DirectoryURL:         server.DirectoryURL(),
+		AccountKey:           key,
+		ServerCACertFile:     caFile,
+		ChallengeTypes:       []string{ra.ACMEChallengeHTTP01},
+		AcceptTermsOfService: true,
+	}, store)
+	assert.NoError(t, err)
+	t.Cleanup(acmeRA.Close)
+
+	c := NewController(kc, acmeRA, time.Hour)
+	kc.RunAndWait(stop)
+	go c.Run(stop)
+	return kc, server, store, c
+}
+
+func acmeGateway(annotated bool, hosts ...string) *networkingclient.Gateway {
+	gw := &networkingclient.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: networking.Gateway{
+			Servers: []*networking.Server{{
+				Port:  &networking.Port{Name: "https", Number: 443, Protocol: "HTTPS"},
+				Hosts: hosts,
+				Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE, CredentialName: "gw-cert"},
+			}},
+		},
+	}
+	if annotated {
+		gw.Annotations = map[string]string{EnabledAnnotation: "true"}
+	}
+	return gw
+}
+
+func TestController(t *testing.T) {
+	kc, server, store, _ := setupController(t)
+	gateways := clienttest.NewWriter[*networkingclient.Gateway](t, kc)
+	secrets := clienttest.NewDirectClient[*v1.Secret, v1.Secret, *v1.SecretList](t, kc)
+
+	gateways.Create(acmeGateway(true, "default/a.example.com", "b.example.com", "*.example.com"))
+	var cert *x509.Certificate
+	retry.UntilSuccessOrFail(t, func() error {
+		s := secrets.Get("gw-cert", "default")
+		if s == nil {
+			return fmt.Errorf("secret not created")
+		}
+		assert.Equal(t, s.Type, v1.SecretTypeTLS)
+		assert.Equal(t, s.Labels[ManagedLabel], "true")
+		block, _ := pem.Decode(s.Data[credkube.TLSSecretCert])
+		if block == nil {
+			t.Fatalf("invalid certificate: %s", s.Data[credkube.TLSSecretCert])
+		}
+		var err error
+		cert, err = x509.ParseCertificate(block.Bytes)
+		return err
+	}, retry.Timeout(time.Second*20))
+	assert.Equal(t, slices.Sort(cert.DNSNames), []string{"a.example.com", "b.example.com"})
+	assert.Equal(t, len(server.Issued()), 1)
+	assert.EventuallyEqual(t, func() int { return len(store.ACMEChallenges()) }, 0)
+
+	// A change of hosts triggers a new certificate.
+	updated := acmeGateway(true, "a.example.com")
+	updated.ResourceVersion = "2"
+	gateways.Update(updated)
+	assert.EventuallyEqual(t, func() string {
+		return secrets.Get("gw-cert", "default").Annotations[HostsAnnotation]
+	}, "a.example.com", retry.Timeout(time.Second*20))
+	assert.Equal(t, len(server.Issued()), 2)
+}
+
+func TestControllerUserSecret(t *testing.T) {
+	kc, server, _, c := setupController(t)
+	secrets := clienttest.NewWriter[*v1.Secret](t, kc)
+	userSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw-cert", Namespace: "default"},
+		Data:       map[string][]byte{credkube.TLSSecretCert: []byte("user")},
+	}
+	secrets.Create(userSecret)
+	clienttest.NewWriter[*networkingclient.Gateway](t, kc).Create(acmeGateway(true, "a.example.com"))
+	assert.EventuallyEqual(t, func() bool { return c.gateways.Get("gw", "default") != nil }, true)
+	// The user secret is neither overwritten nor renewed.
+	assert.NoError(t, c.Reconcile(types.NamespacedName{Name: "gw", Namespace: "default"}))
+	assert.Equal(t, len(server.Issued()), 0)
+	got, err := kc.Kube().CoreV1().Secrets("default").Get(test.NewContext(t), "gw-cert", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, got, userSecret)
+}
+
+func TestControllerSecretsForbidden(t *testing.T) {
+	kc, server, _, c := setupController(t)
+	// Like the default istiod ClusterRole, only allow writing Secrets in the istiod namespace.
+	kc.Kube().(*fake.Clientset).Fake.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == istiodNamespace {
+			return false, nil, nil
+		}
+		return true, nil, kerrors.NewForbidden(v1.Resource("secrets"), "", fmt.Errorf("not allowed"))
+	})
+	clienttest.NewWriter[*networkingclient.Gateway](t, kc).Create(acmeGateway(true, "a.example.com"))
+	assert.EventuallyEqual(t, func() bool { return c.gateways.Get("gw", "default") != nil }, true)
+	err := c.Reconcile(types.NamespacedName{Name: "gw", Namespace: "default"})
+	assert.Error(t, err)
+	if !strings.Contains(err.Error(), "pilot.env.ACME_DIRECTORY_URL") {
+		t.Fatalf("expected the error to explain the missing permission, got %v", err)
+	}
+	assert.Equal(t, len(server.Issued()) > 0, true)
+}
+
+func TestControllerNotAnnotated(t *testing.T) {
+	kc, server, _, c := setupController(t)
+	clienttest.NewWriter[*networkingclient.Gateway](t, kc).Create(acmeGateway(false, "a.example.com"))
+	assert.EventuallyEqual(t, func() bool { return c.gateways.Get("gw", "default") != nil }, true)
+	assert.NoError(t, c.Reconcile(types.NamespacedName{Name: "gw", Namespace: "default"}))
+	assert.Equal(t, len(server.Issued()), 0)
+}
+
+func TestCredentialHosts(t *testing.T) {
+	gw := &networking.Gateway{Servers: []*networking.Server{
+		{
+			Hosts: []string{"ns/A.example.com", "*.example.com"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE, CredentialName: "a"},
+		},
+		{
+			Hosts: []string{"b.example.com", "a.example.com"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE, CredentialName: "a"},
+		},
+		{
+			Hosts: []string{"c.example.com"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_MUTUAL, CredentialName: "c"},
+		},
+		{
+			Hosts: []string{"d.example.com"},
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE, CredentialName: "file://d"},
+		},
+		{
+			Hosts: []string{"e.example.com"},
+		},
+	}}
+	assert.Equal(t, credentialHosts(gw), map[string][]string{"a": {"a.example.com", "b.example.com"}})
+}
+
+func TestNeedsIssue(t *testing.T) {
+	now := time.Now()
+	certPEM := func(notBefore, notAfter time.Time) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testCertificate(t, notBefore, notAfter)})
+	}
+	secret := func(hosts string, cert []byte) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{HostsAnnotation: hosts}},
+			Data:       map[string][]byte{credkube.TLSSecretCert: cert},
+		}
+	}
+	cases := []struct {
+		name   string
+		secret *v1.Secret
+		want   bool
+	}{
+		{"fresh", secret("a.example.com", certPEM(now.Add(-time.Hour), now.Add(2*time.Hour))), false},
+		{"expiring", secret("a.example.com", certPEM(now.Add(-2*time.Hour), now.Add(time.Hour/2))), true},
+		{"hosts changed", secret("b.example.com", certPEM(now.Add(-time.Hour), now.Add(2*time.Hour))), true},
+		{"invalid certificate", secret("a.example.com", []byte("invalid")), true},
+	}
+	c := &Controller{now: func() time.Time { return now }}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, c.needsIssue(tt.secret, []string{"a.example.com"}), tt.want)
+		})
+	}
+}
+
+func testCertificate(t *testing.T, notBefore, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"a.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.NoError(t, err)
+	return der
+}
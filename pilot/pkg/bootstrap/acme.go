@@ -0,0 +1,103 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"strings"
+	"time"
+
+	"istio.io/istio/pilot/pkg/acme"
+	"istio.io/istio/pilot/pkg/leaderelection"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/env"
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/security/pkg/pki/ra"
+)
+
+var (
+	acmeDirectoryURL = env.Register("ACME_DIRECTORY_URL", "",
+		"URL of the ACME directory used to obtain certificates for Gateways annotated with "+
+			acme.EnabledAnnotation+", for example https://acme-v02.api.letsencrypt.org/directory.")
+
+	acmeEmail = env.Register("ACME_EMAIL", "",
+		"Optional contact email of the ACME account.")
+
+	acmeAcceptTermsOfService = env.Register("ACME_ACCEPT_TERMS_OF_SERVICE", false,
+		"If true, agree to the terms of service of the ACME CA when registering the account. The ACME "+
+			"CAs publishing terms of service, such as Let's Encrypt, require it.")
+
+	acmeServerCACert = env.Register("ACME_SERVER_CA_CERT", "",
+		"File containing the PEM roots used to verify the ACME server. The system roots are used if empty.")
+
+	acmeChallengeTypes = env.Register("ACME_CHALLENGE_TYPES", strings.Join(ra.DefaultACMEChallengeTypes, ","),
+		"Comma separated ACME challenge types answered by the gateways, in order of preference.")
+
+	acmeChallengePropagationDelay = env.Register("ACME_CHALLENGE_PROPAGATION_DELAY", 10*time.Second,
+		"The time to wait for challenge responses to reach the gateways before they are validated.")
+
+	acmeRenewalCheckInterval = env.Register("ACME_RENEWAL_CHECK_INTERVAL", time.Hour,
+		"The interval between checks for expiring ACME certificates.")
+)
+
+// initACMEController configures the gateways to answer ACME challenges, and runs the
+// controller obtaining gateway certificates on the leader.
+func (s *Server) initACMEController(args *PilotArgs) {
+	if !acmeAcceptTermsOfService.Get() {
+		log.Warnf("ACME_ACCEPT_TERMS_OF_SERVICE is not set: no account can be registered with an ACME CA publishing terms of service")
+	}
+	store := acme.NewChallengeStore(s.kubeClient, args.Namespace, acmeChallengePropagationDelay.Get())
+	s.environment.ACMEChallenges = store
+	store.AddEventHandler(func(host string) {
+		// Only the gateways serving the host answer the challenge.
+		gateways := model.ACMEChallengeGateways(s.environment, host)
+		if len(gateways) == 0 {
+			return
+		}
+		s.XDSServer.ConfigUpdate(&model.PushRequest{
+			Full:           true,
+			ConfigsUpdated: gateways,
+			Reason:         model.NewReasonStats(model.SecretTrigger),
+		})
+	})
+	s.addStartFunc("acme controller", func(stop <-chan struct{}) error {
+		go leaderelection.
+			NewLeaderElection(args.Namespace, args.PodName, leaderelection.ACMEController, args.Revision, s.kubeClient).
+			AddRunFunction(func(leaderStop <-chan struct{}) {
+				accountKey, err := acme.LoadOrCreateAccountKey(s.kubeClient, args.Namespace)
+				if err != nil {
+					log.Errorf("failed to load ACME account: %v", err)
+					return
+				}
+				acmeRA, err := ra.NewACMERA(ra.ACMEOptions{
+					DirectoryURL:         acmeDirectoryURL.Get(),
+					Email:                acmeEmail.Get(),
+					AccountKey:           accountKey,
+					ServerCACertFile:     acmeServerCACert.Get(),
+					ChallengeTypes:       strings.Split(acmeChallengeTypes.Get(), ","),
+					AcceptTermsOfService: acmeAcceptTermsOfService.Get(),
+				}, store)
+				if err != nil {
+					log.Errorf("failed to create ACME RA: %v", err)
+					return
+				}
+				defer acmeRA.Close()
+				controller := acme.NewController(s.kubeClient, acmeRA, acmeRenewalCheckInterval.Get())
+				// Start the informers created after acquiring the leader lock.
+				s.kubeClient.RunAndWait(stop)
+				controller.Run(leaderStop)
+			}).Run(stop)
+		return nil
+	})
+}
@@ -17,9 +17,12 @@ package bootstrap
 import (
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/pkg/autoregistration"
@@ -34,6 +37,7 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/status/distribution"
 	"istio.io/istio/pkg/adsc"
+	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/analysis/incluster"
 	"istio.io/istio/pkg/config/schema/collections"
 	"istio.io/istio/pkg/config/schema/gvr"
@@ -228,6 +232,13 @@ func (s *Server) initConfigSources(args *PilotArgs) (err error) {
 			s.ConfigStores = append(s.ConfigStores, configController)
 			log.Infof("Started File configSource %s", configSource.Address)
 		case XDS:
+			creds, err := adsc.TransportCredentials(srcAddress.Host, adsc.ClientTLSOptions{
+				Settings:      configSource.TlsSettings,
+				KeyCertBundle: s.istiodCertBundleWatcher,
+			})
+			if err != nil {
+				return fmt.Errorf("invalid TLS settings for config source %s: %v", configSource.Address, err)
+			}
 			xdsMCP, err := adsc.New(srcAddress.Host, &adsc.ADSConfig{
 				InitialDiscoveryRequests: adsc.ConfigInitialRequests(),
 				Config: adsc.Config{
@@ -243,9 +254,7 @@ func (s *Server) initConfigSources(args *PilotArgs) (err error) {
 						args.KeepaliveOptions.ConvertToClientOption(),
 						// Because we use the custom grpc options for adsc, here we should
 						// explicitly set transport credentials.
-						// TODO: maybe we should use the tls settings within ConfigSource
-						// to secure the connection between istiod and remote xds server.
-						grpc.WithTransportCredentials(insecure.NewCredentials()),
+						grpc.WithTransportCredentials(creds),
 					},
 				},
 			})
@@ -253,18 +262,63 @@ func (s *Server) initConfigSources(args *PilotArgs) (err error) {
 				return fmt.Errorf("failed to dial XDS %s %v", configSource.Address, err)
 			}
 			store := memory.Make(collections.Pilot)
-			// TODO: enable namespace filter for memory controller
 			configController := memory.NewController(store)
-			configController.RegisterHasSyncedHandler(xdsMCP.HasSynced)
-			xdsMCP.Store = configController
-			err = xdsMCP.Run()
-			if err != nil {
-				return fmt.Errorf("MCP: failed running %v", err)
+			if s.kubeClient != nil && s.kubeClient.ObjectFilter() != nil {
+				// Apply the same discovery selectors as the Kubernetes config store.
+				configController.SetNamespacesFilter(s.kubeClient.ObjectFilter())
 			}
+			xdsMCP.Store = configController.Unfiltered()
+			snapshotLoaded := false
+			if features.ConfigSourceSnapshotDir != "" {
+				xdsMCP.SnapshotFile = filepath.Join(features.ConfigSourceSnapshotDir, snapshotFileName(srcAddress.Host))
+				// Load directly in the store: no events are needed before the controller runs.
+				snapshotLoaded, err = adsc.LoadSnapshot(xdsMCP.SnapshotFile, store)
+				if err != nil {
+					log.Warnf("Failed to load config snapshot for %s: %v", configSource.Address, err)
+				}
+			}
+			// Configs loaded from the snapshot are served right away, and replaced per type by the first response
+			// of the config source. The snapshot only marks the store as synced once the config source failed
+			// to connect or did not sync in time, so a reachable config source still delays readiness until
+			// it is synced.
+			var useSnapshot atomic.Bool
+			configController.RegisterHasSyncedHandler(func() bool {
+				return useSnapshot.Load() || xdsMCP.HasSynced()
+			})
+			address := configSource.Address
+			// The stream is started once the server starts, so that the istiod certificates used in
+			// ISTIO_MUTUAL mode are available for the handshake.
+			s.addStartFunc("xds config source", func(stop <-chan struct{}) error {
+				if snapshotLoaded {
+					if err := xdsMCP.RunOrReconnect(); err != nil {
+						log.Warnf("Serving config snapshot of unreachable config source %s", address)
+						useSnapshot.Store(true)
+					} else {
+						time.AfterFunc(features.ConfigSourceSnapshotTimeout, func() {
+							if !xdsMCP.HasSynced() {
+								log.Warnf("Config source %s did not sync in %v, serving its config snapshot",
+									address, features.ConfigSourceSnapshotTimeout)
+								useSnapshot.Store(true)
+							}
+						})
+					}
+				} else if err := xdsMCP.Run(); err != nil {
+					return fmt.Errorf("MCP: failed running %v", err)
+				}
+				go func() {
+					<-stop
+					xdsMCP.Close()
+				}()
+				log.Infof("Started XDS configSource %s", address)
+				return nil
+			})
 			s.ConfigStores = append(s.ConfigStores, configController)
-			log.Infof("Started XDS configSource %s", configSource.Address)
 		case Kubernetes:
-			if srcAddress.Path == "" || srcAddress.Path == "/" {
+			clusterName := srcAddress.Host
+			if clusterName == "" {
+				clusterName = strings.Trim(srcAddress.Path, "/")
+			}
+			if clusterName == "" || cluster.ID(clusterName) == s.clusterID {
 				err2 := s.initK8SConfigStore(args)
 				if err2 != nil {
 					log.Warnf("Error loading k8s: %v", err2)
@@ -272,9 +326,18 @@ func (s *Server) initConfigSources(args *PilotArgs) (err error) {
 				}
 				log.Infof("Started Kubernetes configSource %s", configSource.Address)
 			} else {
-				log.Warnf("Not implemented, ignore: %v", configSource.Address)
-				// TODO: handle k8s:// scheme for remote cluster. Use same mechanism as service registry,
-				// using the cluster name as key to match a secret.
+				// Remote config cluster, read using the kubeconfig from the multicluster secret
+				// with the matching cluster name.
+				if s.multiclusterController == nil {
+					return fmt.Errorf("config source %s requires a Kubernetes client for multicluster secrets", configSource.Address)
+				}
+				configController := crdclient.NewRemote(s.multiclusterController, cluster.ID(clusterName), crdclient.Option{
+					Revision:     args.Revision,
+					DomainSuffix: args.RegistryOptions.KubeOptions.DomainSuffix,
+					Identifier:   "crd-controller-" + clusterName,
+				})
+				s.ConfigStores = append(s.ConfigStores, configController)
+				log.Infof("Started remote Kubernetes configSource %s", configSource.Address)
 			}
 		default:
 			log.Warnf("Ignoring unsupported config source: %v", configSource.Address)
@@ -283,6 +346,11 @@ func (s *Server) initConfigSources(args *PilotArgs) (err error) {
 	return nil
 }
 
+// snapshotFileName returns the name of the config snapshot file for the xds:// config source at address.
+func snapshotFileName(address string) string {
+	return strings.NewReplacer(":", "_", "/", "_").Replace(address) + ".yaml"
+}
+
 // initInprocessAnalysisController spins up an instance of Galley which serves no purpose other than
 // running Analyzers for status updates.  The Status Updater will eventually need to allow input from istiod
 // to support config distribution status as well.
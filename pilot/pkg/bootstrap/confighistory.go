@@ -0,0 +1,66 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/config/schema/kind"
+	"istio.io/istio/pkg/ledger"
+	"istio.io/istio/pkg/log"
+)
+
+// initConfigHistory records the resource version of every config in a ledger. The discovery server commits the
+// ledger at each push, so the version of a config at any retained push can be looked up with a proof.
+func (s *Server) initConfigHistory(schemas collection.Schemas) {
+	history := buildConfigHistory()
+	handler := func(_ config.Config, curr config.Config, event model.Event) {
+		key := model.ConfigKey{Kind: kind.MustFromGVK(curr.GroupVersionKind), Name: curr.Name, Namespace: curr.Namespace}.String()
+		var err error
+		if event == model.EventDelete {
+			err = history.Delete(key)
+		} else {
+			err = history.Put(key, curr.ResourceVersion)
+		}
+		if err != nil {
+			log.Errorf("failed to record %s in the config history: %v", key, err)
+		}
+	}
+	for _, schema := range schemas.All() {
+		s.configController.RegisterEventHandler(schema.GroupVersionKind(), handler)
+	}
+	s.addTerminatingStartFunc("config history", func(stop <-chan struct{}) error {
+		history.Run(stop)
+		if err := history.Close(); err != nil {
+			log.Warnf("failed to close the config history: %v", err)
+		}
+		return nil
+	})
+	s.XDSServer.ConfigHistory = history
+}
+
+// buildConfigHistory returns the config history, kept on disk if a directory is configured.
+func buildConfigHistory() *ledger.History {
+	if features.ConfigHistoryDir != "" {
+		history, err := ledger.OpenHistory(features.ConfigHistoryDir, features.ConfigHistoryVersions, features.ConfigHistoryRetention)
+		if err == nil {
+			return history
+		}
+		log.Errorf("failed to open %s, keeping the config history in memory: %v", features.ConfigHistoryDir, err)
+	}
+	return ledger.NewHistory(features.ConfigHistoryVersions, features.ConfigHistoryRetention)
+}
@@ -17,8 +17,10 @@ package bootstrap
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -28,17 +30,22 @@ import (
 	"google.golang.org/grpc"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	"istio.io/api/security/v1beta1"
 	"istio.io/istio/pilot/pkg/features"
 	securityModel "istio.io/istio/pilot/pkg/security/model"
+	tb "istio.io/istio/pilot/pkg/trustbundle"
 	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/env"
 	"istio.io/istio/pkg/log"
 	"istio.io/istio/pkg/security"
+	"istio.io/istio/pkg/spiffe"
+	"istio.io/istio/pkg/util/sets"
 	"istio.io/istio/security/pkg/cmd"
 	"istio.io/istio/security/pkg/pki/ca"
 	"istio.io/istio/security/pkg/pki/ra"
+	"istio.io/istio/security/pkg/pki/signer"
 	caserver "istio.io/istio/security/pkg/server/ca"
 	"istio.io/istio/security/pkg/server/ca/authenticate"
 	"istio.io/istio/security/pkg/util"
@@ -132,13 +139,59 @@ var (
 	// TODO: Likely to be removed and added to mesh config
 	// deprecated - it is only used to enable k8s signing - use presence of K8S_SIGNER instead.
 	externalCaType = env.Register("EXTERNAL_CA", "",
-		"External CA Integration Type. Permitted value is ISTIOD_RA_KUBERNETES_API.").Get()
+		"External CA Integration Type. Permitted values are ISTIOD_RA_KUBERNETES_API and ISTIOD_RA_EST.").Get()
 
 	// TODO: Likely to be removed and added to mesh config
 	k8sSigner = env.Register("K8S_SIGNER", "",
 		"Kubernetes CA Signer type. Valid from Kubernetes 1.18").Get()
+
+	estServerURL = env.Register("EST_SERVER_URL", "",
+		"Base URL of the EST server used with EXTERNAL_CA=ISTIOD_RA_EST, for example https://est.example.com/.well-known/est.")
+
+	estLabel = env.Register("EST_LABEL", "",
+		"Optional label of the CA on the EST server.")
+
+	estServerCACert = env.Register("EST_SERVER_CA_CERT", "",
+		"File containing the PEM roots used to verify the EST server. The system roots are used if empty.")
+
+	estClientCert = env.Register("EST_CLIENT_CERT", "",
+		"File containing the certificate used to authenticate to the EST server.")
+
+	estClientKey = env.Register("EST_CLIENT_KEY", "",
+		"File containing the key used to authenticate to the EST server.")
+
+	estUsername = env.Register("EST_USERNAME", "",
+		"Username for HTTP basic authentication to the EST server.")
+
+	estPasswordFile = env.Register("EST_PASSWORD_FILE", "",
+		"File containing the password for HTTP basic authentication to the EST server.")
+
+	estCACertsRefreshInterval = env.Register("EST_CACERTS_REFRESH_INTERVAL", ra.DefaultESTCACertsRefreshInterval,
+		"The interval between fetches of the CA certificates from the EST server, to detect root rotation.")
+
+	enableCARevocation = env.Register("ENABLE_CA_REVOCATION", false,
+		"If enabled, certificates issued by the Istio CA can be revoked. The revoked certificates are stored in the "+
+			ca.RevocationConfigMap+" ConfigMap, and published as a CRL to the proxies.")
+
+	caCRLValidity = env.Register("CA_CRL_VALIDITY", ca.DefaultCRLValidity,
+		"The validity of the certificate revocation lists signed by the Istio CA. They are signed again after half of it.")
+
+	serviceAccount = env.Register("SERVICE_ACCOUNT", "", "Name of service account")
+
+	enableCAOCSPResponder = env.Register("ENABLE_CA_OCSP_RESPONDER", false,
+		"If enabled with ENABLE_CA_REVOCATION, an OCSP responder for the certificates issued by the Istio CA "+
+			"is served on the webhook port, at /ocsp.")
+
+	caSigningKeyURI = env.Register("CA_SIGNING_KEY_URI", "",
+		"The URI of the CA signing key, when it is not stored in a K8S secret or in ca-key.pem. Either a PKCS#11 URI "+
+			"(pkcs11:token=...;object=...?module-path=...&pin-source=...) for a key held by an HSM, which requires "+
+			"an Istiod built with cgo, unlike the release builds, or an encrypted "+
+			"PKCS#8 key file (file:///path/to/key.pem?passphrase-source=/path/to/passphrase).")
 )
 
+// crlRefreshInterval is the interval between updates of the CRL used to verify the clients of Istiod.
+const crlRefreshInterval = time.Minute
+
 // initCAServer create a CA Server. The CA API uses cert with the max workload cert TTL.
 // 'hostlist' must be non-empty - but is not used since CA Server will start on existing
 // grpc server. Adds client cert auth and kube (sds enabled)
@@ -150,6 +203,60 @@ func (s *Server) initCAServer(ca caserver.CertificateAuthority, opts *caOptions)
 	s.caServer = caServer
 }
 
+// initRevocationServer creates the certificate revocation server of the Istio CA, and the optional OCSP responder.
+func (s *Server) initRevocationServer(opts *caOptions) {
+	authorized := features.CARevocationAuthorizedAccounts
+	if authorized.Len() == 0 {
+		// Only Istiod itself is allowed to revoke certificates by default.
+		authorized = sets.New(types.NamespacedName{Namespace: opts.Namespace, Name: istiodServiceAccount()})
+	}
+	if _, err := s.CA.GetCRL(); err != nil {
+		// CA certificates issued without the cRLSign key usage, as the self-signed roots of older releases,
+		// must be re-issued before enabling revocation.
+		log.Errorf("%s is enabled, but the revoked certificates can't be published: %v. "+
+			"Re-issue the CA certificate with the cRLSign key usage", enableCARevocation.Name, err)
+	}
+	s.revocationServer = caserver.NewRevocationServer(s.CA, opts.Authenticators, authorized)
+	if enableCAOCSPResponder.Get() {
+		if s.httpsMux == nil {
+			log.Warnf("OCSP responder is disabled, as the webhook server is not running")
+			return
+		}
+		ocspHandler := http.StripPrefix("/ocsp", caserver.OCSPHandler(s.CA))
+		s.httpsMux.Handle("/ocsp", ocspHandler)
+		s.httpsMux.Handle("/ocsp/", ocspHandler)
+	}
+}
+
+// watchCRL keeps the CRL of the Istio CA up to date in the verifier of the Istiod clients.
+func (s *Server) watchCRL(verifier *spiffe.PeerCertVerifier) {
+	update := func() {
+		crl, err := s.CA.GetCRL()
+		if err == nil {
+			err = verifier.SetCRLsFromPEM(crl)
+		}
+		if err != nil {
+			log.Warnf("failed to update the CRL of the Istiod clients: %v", err)
+		}
+	}
+	update()
+	s.addStartFunc("crl watcher", func(stop <-chan struct{}) error {
+		go func() {
+			ticker := time.NewTicker(crlRefreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					update()
+				}
+			}
+		}()
+		return nil
+	})
+}
+
 // RunCA will start the cert signing GRPC service on an existing server.
 // Protected by installer options: the CA will be started only if the JWT token in /var/run/secrets
 // is mounted. If it is missing - for example old versions of K8S that don't support such tokens -
@@ -191,6 +298,10 @@ func (s *Server) RunCA(grpc *grpc.Server) {
 	}
 
 	s.caServer.Register(grpc)
+	if s.revocationServer != nil {
+		s.revocationServer.Authenticators = s.caServer.Authenticators
+		s.revocationServer.Register(grpc)
+	}
 
 	log.Info("Istiod CA has started")
 }
@@ -430,8 +541,15 @@ func (s *Server) createIstioCA(opts *caOptions) (*ca.IstioCA, error) {
 	var caOpts *ca.IstioCAOptions
 	var detectedSigningCABundle bool
 	var istioGenerated bool
+	var caSigner crypto.Signer
 	var err error
 
+	if uri := caSigningKeyURI.Get(); uri != "" {
+		if caSigner, err = signer.New(uri); err != nil {
+			return nil, fmt.Errorf("failed to load the CA signing key: %v", err)
+		}
+	}
+
 	// For mounted certificates (if any) determine the set of files.
 	// If not found - we'll load from K8S Secret.
 	fileBundle, err := detectSigningCABundle()
@@ -441,7 +559,12 @@ func (s *Server) createIstioCA(opts *caOptions) (*ca.IstioCA, error) {
 	// the fileBundle will have file paths - but they may not exist.
 	// TODO: if no file is mounted we may fallback to loading from secrets.
 
-	if _, err := os.Stat(fileBundle.SigningKeyFile); err == nil {
+	// With an external signing key, only the signing certificate is mounted.
+	signingFile := fileBundle.SigningKeyFile
+	if caSigner != nil {
+		signingFile = fileBundle.SigningCertFile
+	}
+	if _, err := os.Stat(signingFile); err == nil {
 		detectedSigningCABundle = true
 		if _, err := os.Stat(path.Join(LocalCertDir.Get(), ca.IstioGenerated)); err == nil {
 			istioGenerated = true
@@ -468,7 +591,7 @@ func (s *Server) createIstioCA(opts *caOptions) (*ca.IstioCA, error) {
 
 		// Either the secret is not mounted because it is named `istio-ca-secret`,
 		// or it is `cacerts` secret mounted with "istio-generated" key set.
-		caOpts, err = s.loadCAFromSecrets(&fileBundle, opts)
+		caOpts, err = s.loadCAFromSecrets(&fileBundle, opts, caSigner)
 		if err != nil {
 			return nil, err
 		}
@@ -477,13 +600,22 @@ func (s *Server) createIstioCA(opts *caOptions) (*ca.IstioCA, error) {
 		// The secret is mounted and the "istio-generated" key is not used.
 		log.Info("Use local CA certificate from /etc/cacert, not auto-generated by Istiod")
 
-		caOpts, err = ca.NewPluggedCertIstioCAOptions(fileBundle, workloadCertTTL.Get(), maxWorkloadCertTTL.Get(), caRSAKeySize.Get())
+		caOpts, err = ca.NewPluggedCertIstioCAOptions(fileBundle, workloadCertTTL.Get(), maxWorkloadCertTTL.Get(), caRSAKeySize.Get(), caSigner)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create an istiod CA: %v", err)
 		}
 
 		s.initCACertsWatcher()
 	}
+	if enableCARevocation.Get() {
+		if s.kubeClient != nil {
+			caOpts.RevocationStore = ca.NewConfigMapRevocationStore(s.kubeClient.Kube().CoreV1(), opts.Namespace)
+		} else {
+			log.Warnf("Revoked certificates are not persisted, as there is no K8S access")
+			caOpts.RevocationStore = ca.NewInMemoryRevocationStore()
+		}
+		caOpts.CRLValidity = caCRLValidity.Get()
+	}
 	istioCA, err := ca.NewIstioCA(caOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create an istiod CA: %v", err)
@@ -496,7 +628,7 @@ func (s *Server) createIstioCA(opts *caOptions) (*ca.IstioCA, error) {
 
 // loadCAFromSecrets will initialize the Istio CA using istio-ca-secrets or cacerts.
 // This is only called if mounted files
-func (s *Server) loadCAFromSecrets(fileBundle *ca.SigningCAFileBundle, opts *caOptions) (*ca.IstioCAOptions, error) {
+func (s *Server) loadCAFromSecrets(fileBundle *ca.SigningCAFileBundle, opts *caOptions, caSigner crypto.Signer) (*ca.IstioCAOptions, error) {
 	var caOpts *ca.IstioCAOptions
 	var err error
 	if s.kubeClient != nil {
@@ -513,14 +645,14 @@ func (s *Server) loadCAFromSecrets(fileBundle *ca.SigningCAFileBundle, opts *caO
 			selfSignedRootCertCheckInterval.Get(), workloadCertTTL.Get(),
 			maxWorkloadCertTTL.Get(), opts.TrustDomain, features.UseCacertsForSelfSignedCA, true,
 			opts.Namespace, s.kubeClient.Kube().CoreV1(), fileBundle.RootCertFile,
-			enableJitterForRootCertRotator.Get(), caRSAKeySize.Get())
+			enableJitterForRootCertRotator.Get(), caRSAKeySize.Get(), caSigner)
 	} else {
 		log.Warnf(
 			"Use local self-signed CA certificate for testing. Will use in-memory root CA, no K8S access and no ca key file %s",
 			fileBundle.SigningKeyFile)
 
 		caOpts, err = ca.NewSelfSignedDebugIstioCAOptions(fileBundle.RootCertFile, SelfSignedCACertTTL.Get(),
-			workloadCertTTL.Get(), maxWorkloadCertTTL.Get(), opts.TrustDomain, caRSAKeySize.Get())
+			workloadCertTTL.Get(), maxWorkloadCertTTL.Get(), opts.TrustDomain, caRSAKeySize.Get(), caSigner)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a self-signed istiod CA: %v", err)
@@ -539,6 +671,9 @@ func (s *Server) loadCAFromSecrets(fileBundle *ca.SigningCAFileBundle, opts *caO
 //
 // 3. Extract from the cert-chain signed by other CSR signer.
 func (s *Server) createIstioRA(opts *caOptions) (ra.RegistrationAuthority, error) {
+	if opts.ExternalCAType == ra.ExtCAEST {
+		return s.createESTRA(opts)
+	}
 	if s.kubeClient == nil {
 		return nil, fmt.Errorf("kubeClient is nil")
 	}
@@ -592,3 +727,65 @@ func (s *Server) createIstioRA(opts *caOptions) (ra.RegistrationAuthority, error
 	})
 	return raServer, err
 }
+
+// createESTRA creates a RA forwarding CSRs to the EST server configured with the EST_* variables.
+// The CA certificates are fetched from the server, and refreshed periodically.
+func (s *Server) createESTRA(opts *caOptions) (ra.RegistrationAuthority, error) {
+	raOpts := &ra.IstioRAOptions{
+		ExternalCAType: opts.ExternalCAType,
+		DefaultCertTTL: workloadCertTTL.Get(),
+		MaxCertTTL:     maxWorkloadCertTTL.Get(),
+		TrustDomain:    opts.TrustDomain,
+		EST: ra.ESTOptions{
+			ServerURL:              estServerURL.Get(),
+			Label:                  estLabel.Get(),
+			ServerCACertFile:       estServerCACert.Get(),
+			ClientCertFile:         estClientCert.Get(),
+			ClientKeyFile:          estClientKey.Get(),
+			Username:               estUsername.Get(),
+			PasswordFile:           estPasswordFile.Get(),
+			CACertsRefreshInterval: estCACertsRefreshInterval.Get(),
+		},
+	}
+	raServer, err := ra.NewESTRA(raOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	raServer.SetCACertificatesFromMeshConfig(s.environment.Mesh().CaCertificates)
+	s.environment.AddMeshHandler(func() {
+		raServer.SetCACertificatesFromMeshConfig(s.environment.Mesh().CaCertificates)
+	})
+	// TODO: the peer cert verifier of the secure discovery service is created at startup and
+	// does not pick up new roots.
+	raServer.AddRootCertsHandler(func() {
+		if !features.MultiRootMesh {
+			return
+		}
+		log.Infof("Update trust anchor with new EST root cert")
+		err := s.workloadTrustBundle.UpdateTrustAnchor(&tb.TrustAnchorUpdate{
+			TrustAnchorConfig: tb.TrustAnchorConfig{Certs: []string{string(raServer.GetCAKeyCertBundle().GetRootCertPem())}},
+			Source:            tb.SourceIstioRA,
+		})
+		if err != nil {
+			log.Errorf("failed to update trust anchor from source Istio RA, err: %v", err)
+		}
+	})
+	s.addStartFunc("est ra", func(stop <-chan struct{}) error {
+		go raServer.Run(stop)
+		return nil
+	})
+	return raServer, nil
+}
+
+// istiodServiceAccount returns the service account of Istiod, from the SERVICE_ACCOUNT variable set by the chart or
+// else following the naming of the chart.
+func istiodServiceAccount() string {
+	if sa := serviceAccount.Get(); sa != "" {
+		return sa
+	}
+	if Revision == "" {
+		return "istiod"
+	}
+	return "istiod-" + Revision
+}
@@ -47,6 +47,9 @@ type RegistryOptions struct {
 
 	// DistributionTracking control
 	DistributionTrackingEnabled bool
+
+	// DistributionHistoryFile is the file keeping the distribution ledger. If empty, the ledger is kept in memory.
+	DistributionHistoryFile string
 }
 
 // PilotArgs provides all of the configuration parameters for the Pilot discovery service.
@@ -148,6 +151,7 @@ func (p *PilotArgs) applyDefaults() {
 	p.KeepaliveOptions = keepalive.DefaultOption()
 	p.RegistryOptions.DistributionTrackingEnabled = features.EnableDistributionTracking
 	p.RegistryOptions.DistributionCacheRetention = features.DistributionHistoryRetention
+	p.RegistryOptions.DistributionHistoryFile = features.DistributionHistoryFile
 	p.RegistryOptions.ClusterRegistriesNamespace = p.Namespace
 }
 
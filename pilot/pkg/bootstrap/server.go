@@ -148,6 +148,8 @@ type Server struct {
 	CA       *ca.IstioCA
 	RA       ra.RegistrationAuthority
 	caServer *caserver.Server
+	// revocationServer serves the certificate revocation API of the Istio CA. It is nil if revocation is disabled.
+	revocationServer *caserver.RevocationServer
 
 	// TrustAnchors for workload to workload mTLS
 	workloadTrustBundle *tb.TrustBundle
@@ -223,7 +225,8 @@ func (w *webhookInfo) addHandler(fn func()) {
 func NewServer(args *PilotArgs, initFuncs ...func(*Server)) (*Server, error) {
 	e := model.NewEnvironment()
 	e.DomainSuffix = args.RegistryOptions.KubeOptions.DomainSuffix
-	e.SetLedger(buildLedger(args.RegistryOptions))
+	configLedger, ledgerStore := buildLedger(args.RegistryOptions)
+	e.SetLedger(configLedger)
 
 	ac := aggregate.NewController(aggregate.Options{
 		MeshHolder: e,
@@ -245,6 +248,16 @@ func NewServer(args *PilotArgs, initFuncs ...func(*Server)) (*Server, error) {
 		webhookInfo:             &webhookInfo{},
 	}
 	s.workloadTrustBundle = tb.NewTrustBundle(nil, e.Watcher)
+	if ledgerStore != nil {
+		s.addTerminatingStartFunc("distribution history compaction", func(stop <-chan struct{}) error {
+			// Nodes expire after the retention, so compacting as often keeps the file at about twice its live size.
+			ledgerStore.RunCompaction(stop, max(args.RegistryOptions.DistributionCacheRetention, time.Minute))
+			if err := ledgerStore.Close(); err != nil {
+				log.Warnf("failed to close the distribution history: %v", err)
+			}
+			return nil
+		})
+	}
 
 	// Apply custom initialization functions.
 	for _, fn := range initFuncs {
@@ -759,6 +772,9 @@ func (s *Server) initSecureDiscoveryService(args *PilotArgs, trustDomain string)
 		log.Warnf("The secure discovery service is disabled")
 		return nil
 	}
+	if s.CA != nil && s.CA.RevocationEnabled() {
+		s.watchCRL(peerCertVerifier)
+	}
 	log.Info("initializing secure discovery service")
 	cfg := &tls.Config{
 		GetCertificate: s.getIstiodCertificate,
@@ -901,11 +917,14 @@ func (s *Server) initRegistryEventHandlers() {
 			}
 			s.XDSServer.ConfigUpdate(pushReq)
 		}
-		schemas := collections.Pilot.All()
+		allSchemas := collections.Pilot
 		if features.EnableGatewayAPI {
-			schemas = collections.PilotGatewayAPI().All()
+			allSchemas = collections.PilotGatewayAPI()
 		}
-		for _, schema := range schemas {
+		if features.EnableConfigHistory {
+			s.initConfigHistory(allSchemas)
+		}
+		for _, schema := range allSchemas.All() {
 			// This resource type was handled in external/servicediscovery.go, no need to rehandle here.
 			if schema.GroupVersionKind() == gvk.ServiceEntry {
 				continue
@@ -1149,6 +1168,10 @@ func (s *Server) initControllers(args *PilotArgs) error {
 		s.initNodeUntaintController(args)
 	}
 
+	if acmeDirectoryURL.Get() != "" && s.kubeClient != nil {
+		s.initACMEController(args)
+	}
+
 	if err := s.initConfigController(args); err != nil {
 		return fmt.Errorf("error initializing config controller: %v", err)
 	}
@@ -1248,6 +1271,9 @@ func (s *Server) startCA(caOpts *caOptions) {
 	} else if s.CA != nil {
 		log.Infof("initializing CA server with IstioD CA")
 		s.initCAServer(s.CA, caOpts)
+		if s.CA.RevocationEnabled() {
+			s.initRevocationServer(caOpts)
+		}
 	}
 	s.addStartFunc("ca", func(stop <-chan struct{}) error {
 		grpcServer := s.secureGrpcServer
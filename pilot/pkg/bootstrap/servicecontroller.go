@@ -16,9 +16,13 @@ package bootstrap
 
 import (
 	"fmt"
+	"strings"
 
 	"istio.io/istio/pilot/pkg/serviceregistry/aggregate"
 	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
+	"istio.io/istio/pilot/pkg/serviceregistry/plugin"
+	// Registers the file service registry.
+	_ "istio.io/istio/pilot/pkg/serviceregistry/plugin/file"
 	"istio.io/istio/pilot/pkg/serviceregistry/provider"
 	"istio.io/istio/pilot/pkg/serviceregistry/serviceentry"
 	"istio.io/istio/pkg/log"
@@ -42,7 +46,9 @@ func (s *Server) initServiceControllers(args *PilotArgs) error {
 
 	registered := sets.New[provider.ID]()
 	for _, r := range args.RegistryOptions.Registries {
-		serviceRegistry := provider.ID(r)
+		// Plugin registries may be configured with <name>:<args>
+		name, registryArgs, _ := strings.Cut(r, ":")
+		serviceRegistry := provider.ID(name)
 		if registered.Contains(serviceRegistry) {
 			log.Warnf("%s registry specified multiple times.", r)
 			continue
@@ -55,7 +61,13 @@ func (s *Server) initServiceControllers(args *PilotArgs) error {
 				return err
 			}
 		default:
-			return fmt.Errorf("service registry %s is not supported", r)
+			factory, ok := plugin.Lookup(serviceRegistry)
+			if !ok {
+				return fmt.Errorf("service registry %s is not supported", r)
+			}
+			if err := s.initPluginRegistry(serviceRegistry, factory, registryArgs, args); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -94,3 +106,23 @@ func (s *Server) initKubeRegistry(args *PilotArgs) (err error) {
 
 	return
 }
+
+// initPluginRegistry creates a registry registered with the plugin package, and adds it to the aggregate controller.
+func (s *Server) initPluginRegistry(id provider.ID, factory plugin.Factory, registryArgs string, args *PilotArgs) error {
+	registry, err := factory(plugin.Options{
+		ClusterID:    s.clusterID,
+		DomainSuffix: args.RegistryOptions.KubeOptions.DomainSuffix,
+		Args:         registryArgs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service registry %s: %v", id, err)
+	}
+	c := plugin.NewController(id, registry, plugin.ControllerOptions{
+		ClusterID:  s.clusterID,
+		XDSUpdater: s.XDSServer,
+	})
+	// Workload entries selected by service entries may be backed by the workloads of the registry.
+	c.AppendWorkloadHandler(s.serviceEntryController.WorkloadInstanceHandler)
+	s.ServiceController().AddRegistry(c)
+	return nil
+}
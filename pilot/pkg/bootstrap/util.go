@@ -18,6 +18,7 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/provider"
 	"istio.io/istio/pkg/ledger"
+	"istio.io/istio/pkg/log"
 )
 
 func hasKubeRegistry(registries []string) bool {
@@ -29,12 +30,18 @@ func hasKubeRegistry(registries []string) bool {
 	return false
 }
 
-func buildLedger(ca RegistryOptions) ledger.Ledger {
-	var result ledger.Ledger
-	if ca.DistributionTrackingEnabled {
-		result = ledger.Make(ca.DistributionCacheRetention)
-	} else {
-		result = &model.DisabledLedger{}
+// buildLedger returns the distribution ledger, and the store keeping it on disk if any.
+func buildLedger(ca RegistryOptions) (ledger.Ledger, *ledger.FileStore) {
+	if !ca.DistributionTrackingEnabled {
+		return &model.DisabledLedger{}, nil
 	}
-	return result
+	if ca.DistributionHistoryFile != "" {
+		store, err := ledger.OpenFileStore(ca.DistributionHistoryFile)
+		if err != nil {
+			log.Errorf("failed to open %s, keeping the distribution history in memory: %v", ca.DistributionHistoryFile, err)
+		} else {
+			return ledger.MakeWithStore(ca.DistributionCacheRetention, store), store
+		}
+	}
+	return ledger.Make(ca.DistributionCacheRetention), nil
 }
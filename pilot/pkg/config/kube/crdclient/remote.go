@@ -0,0 +1,282 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdclient
+
+import (
+	"fmt"
+	"sync"
+
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/multicluster"
+)
+
+// ClusterController is the subset of the multicluster secret controller used by RemoteClient.
+type ClusterController interface {
+	multicluster.ComponentBuilder
+	HasSynced() bool
+}
+
+// RemoteClient is a config store reading Istio config from a remote cluster, identified by the cluster
+// name in a multicluster secret. A Client is built whenever a secret for the cluster is added or updated,
+// and torn down when it is removed; event handlers are carried over across these changes.
+type RemoteClient struct {
+	clusterID  cluster.ID
+	opts       Option
+	schemas    collection.Schemas
+	controller ClusterController
+
+	mu       sync.RWMutex
+	current  *remoteCluster
+	handlers map[config.GroupVersionKind][]model.EventHandler
+	// stale holds the configs of a replaced client, until the new client has synced.
+	stale map[config.GroupVersionKind][]config.Config
+}
+
+var _ model.ConfigStoreController = &RemoteClient{}
+
+// NewRemote creates a config store for the remote cluster clusterID.
+func NewRemote(controller ClusterController, clusterID cluster.ID, opts Option) *RemoteClient {
+	schemas := collections.Pilot
+	if features.EnableGatewayAPI {
+		schemas = collections.PilotGatewayAPI()
+	}
+	r := &RemoteClient{
+		clusterID:  clusterID,
+		opts:       opts,
+		schemas:    schemas,
+		controller: controller,
+		handlers:   map[config.GroupVersionKind][]model.EventHandler{},
+	}
+	multicluster.BuildMultiClusterComponent(controller, r.clusterAdded)
+	return r
+}
+
+// remoteCluster is the per-cluster component holding the Client for the remote cluster.
+type remoteCluster struct {
+	owner   *RemoteClient
+	cluster *multicluster.Cluster
+	client  *Client
+	stop    chan struct{}
+}
+
+func (r *RemoteClient) clusterAdded(c *multicluster.Cluster) *remoteCluster {
+	if c.ID != r.clusterID {
+		return &remoteCluster{}
+	}
+	scope.Infof("building config store for remote cluster %s", c.ID)
+	client := NewForSchemas(c.Client, r.opts, r.schemas)
+	rc := &remoteCluster{owner: r, cluster: c, client: client, stop: make(chan struct{})}
+	for _, s := range r.schemas.All() {
+		kind := s.GroupVersionKind()
+		client.RegisterEventHandler(kind, func(old config.Config, cur config.Config, event model.Event) {
+			r.notify(kind, old, cur, event)
+		})
+	}
+
+	r.mu.Lock()
+	r.current = rc
+	r.mu.Unlock()
+
+	go client.Run(rc.stop)
+	go r.reconcileStale(rc)
+	return rc
+}
+
+// reconcileStale removes configs that were present in the previous client of the cluster,
+// but are no longer present once the new client has synced.
+func (r *RemoteClient) reconcileStale(rc *remoteCluster) {
+	if !kube.WaitForCacheSync("remote crdclient", rc.stop, rc.client.HasSynced) {
+		return
+	}
+	r.mu.Lock()
+	if r.current != rc {
+		r.mu.Unlock()
+		return
+	}
+	stale := r.stale
+	r.stale = nil
+	r.mu.Unlock()
+	for kind, cfgs := range stale {
+		for _, cfg := range cfgs {
+			if rc.client.Get(kind, cfg.Name, cfg.Namespace) == nil {
+				r.notify(kind, config.Config{}, cfg, model.EventDelete)
+			}
+		}
+	}
+}
+
+func (rc *remoteCluster) Close() {
+	if rc.client == nil {
+		return
+	}
+	r := rc.owner
+	removed := map[config.GroupVersionKind][]config.Config{}
+	for _, s := range r.schemas.All() {
+		kind := s.GroupVersionKind()
+		removed[kind] = rc.client.List(kind, model.NamespaceAll)
+	}
+	close(rc.stop)
+
+	r.mu.Lock()
+	replaced := r.current != rc
+	if replaced {
+		// The secret was updated; deletes are sent once the new client has synced.
+		r.stale = removed
+	} else {
+		r.current = nil
+		r.stale = nil
+	}
+	r.mu.Unlock()
+	if replaced {
+		return
+	}
+	scope.Infof("remote cluster %s removed, deleting its config", rc.cluster.ID)
+	for kind, cfgs := range removed {
+		for _, cfg := range cfgs {
+			r.notify(kind, config.Config{}, cfg, model.EventDelete)
+		}
+	}
+}
+
+func (rc *remoteCluster) HasSynced() bool {
+	if rc.client == nil {
+		return true
+	}
+	return rc.client.HasSynced()
+}
+
+func (r *RemoteClient) notify(kind config.GroupVersionKind, old config.Config, cur config.Config, event model.Event) {
+	r.mu.RLock()
+	handlers := r.handlers[kind]
+	r.mu.RUnlock()
+	for _, h := range handlers {
+		h(old, cur, event)
+	}
+}
+
+func (r *RemoteClient) client() *Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return nil
+	}
+	return r.current.client
+}
+
+// RegisterEventHandler implements model.ConfigStoreController
+func (r *RemoteClient) RegisterEventHandler(kind config.GroupVersionKind, handler model.EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = append(r.handlers[kind], handler)
+}
+
+// Run implements model.ConfigStoreController. The remote clients are run by the multicluster
+// controller, so this only blocks until stop is closed.
+func (r *RemoteClient) Run(stop <-chan struct{}) {
+	<-stop
+}
+
+// HasSynced returns true once the multicluster secrets have been processed, and the client for
+// the remote cluster, if any, has synced or timed out.
+func (r *RemoteClient) HasSynced() bool {
+	if !r.controller.HasSynced() {
+		return false
+	}
+	r.mu.RLock()
+	rc := r.current
+	r.mu.RUnlock()
+	if rc == nil {
+		return true
+	}
+	return rc.HasSynced() || rc.cluster.SyncDidTimeout()
+}
+
+// Schemas implements model.ConfigStore
+func (r *RemoteClient) Schemas() collection.Schemas {
+	return r.schemas
+}
+
+// Get implements model.ConfigStore
+func (r *RemoteClient) Get(typ config.GroupVersionKind, name, namespace string) *config.Config {
+	cl := r.client()
+	if cl == nil {
+		return nil
+	}
+	return cl.Get(typ, name, namespace)
+}
+
+// List implements model.ConfigStore
+func (r *RemoteClient) List(typ config.GroupVersionKind, namespace string) []config.Config {
+	cl := r.client()
+	if cl == nil {
+		return nil
+	}
+	return cl.List(typ, namespace)
+}
+
+// Create implements model.ConfigStore
+func (r *RemoteClient) Create(cfg config.Config) (string, error) {
+	cl := r.client()
+	if cl == nil {
+		return "", r.unavailable()
+	}
+	return cl.Create(cfg)
+}
+
+// Update implements model.ConfigStore
+func (r *RemoteClient) Update(cfg config.Config) (string, error) {
+	cl := r.client()
+	if cl == nil {
+		return "", r.unavailable()
+	}
+	return cl.Update(cfg)
+}
+
+// UpdateStatus implements model.ConfigStore
+func (r *RemoteClient) UpdateStatus(cfg config.Config) (string, error) {
+	cl := r.client()
+	if cl == nil {
+		return "", r.unavailable()
+	}
+	return cl.UpdateStatus(cfg)
+}
+
+// Patch implements model.ConfigStore
+func (r *RemoteClient) Patch(orig config.Config, patchFn config.PatchFunc) (string, error) {
+	cl := r.client()
+	if cl == nil {
+		return "", r.unavailable()
+	}
+	return cl.Patch(orig, patchFn)
+}
+
+// Delete implements model.ConfigStore
+func (r *RemoteClient) Delete(typ config.GroupVersionKind, name, namespace string, resourceVersion *string) error {
+	cl := r.client()
+	if cl == nil {
+		return r.unavailable()
+	}
+	return cl.Delete(typ, name, namespace, resourceVersion)
+}
+
+func (r *RemoteClient) unavailable() error {
+	return fmt.Errorf("remote cluster %s is not available", r.clusterID)
+}
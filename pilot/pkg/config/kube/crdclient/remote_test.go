@@ -0,0 +1,114 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdclient
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/kclient/clienttest"
+	"istio.io/istio/pkg/kube/multicluster"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func makeRemoteKubeClient(t *testing.T) kube.CLIClient {
+	fake := kube.NewFakeClient()
+	for _, s := range collections.Pilot.All() {
+		clienttest.MakeCRD(t, fake, s.GroupVersionResource())
+	}
+	return fake
+}
+
+func TestRemoteClient(t *testing.T) {
+	mc := multicluster.NewFakeController()
+	store := NewRemote(mc, "remote", Option{})
+	r := collections.VirtualService
+	deletes := atomic.NewInt32(0)
+	store.RegisterEventHandler(r.GroupVersionKind(), func(_ config.Config, _ config.Config, event model.Event) {
+		if event == model.EventDelete {
+			deletes.Inc()
+		}
+	})
+
+	// No secret for the cluster yet
+	assert.Equal(t, store.HasSynced(), true)
+	assert.Equal(t, len(store.List(r.GroupVersionKind(), "")), 0)
+	if _, err := store.Create(config.Config{Meta: config.Meta{GroupVersionKind: r.GroupVersionKind()}}); err == nil {
+		t.Fatalf("expected create to fail without remote cluster")
+	}
+
+	// Other clusters are ignored
+	other := makeRemoteKubeClient(t)
+	otherStop := test.NewStop(t)
+	mc.Add("other", other, otherStop)
+	other.RunAndWait(otherStop)
+	assert.Equal(t, store.client(), nil)
+
+	client := makeRemoteKubeClient(t)
+	stop := test.NewStop(t)
+	mc.Add("remote", client, stop)
+	client.RunAndWait(stop)
+	retry.UntilOrFail(t, store.HasSynced, retry.Timeout(time.Second*5))
+
+	for _, name := range []string{"name1", "name2"} {
+		createResource(t, store, r, config.Meta{
+			Name:             name,
+			Namespace:        "ns",
+			GroupVersionKind: r.GroupVersionKind(),
+		})
+	}
+	expectList := func(n int) {
+		t.Helper()
+		retry.UntilSuccessOrFail(t, func() error {
+			if l := store.List(r.GroupVersionKind(), "ns"); len(l) != n {
+				return fmt.Errorf("expected %d items, got %d", n, len(l))
+			}
+			return nil
+		}, retry.Timeout(time.Second*5))
+	}
+	expectList(2)
+
+	// The secret is updated to point to a cluster which only has one of the configs
+	updated := makeRemoteKubeClient(t)
+	createResource(t, New(updated, Option{}), r, config.Meta{
+		Name:             "name1",
+		Namespace:        "ns",
+		GroupVersionKind: r.GroupVersionKind(),
+	})
+	updatedStop := test.NewStop(t)
+	mc.Update("remote", updated, updatedStop)
+	updated.RunAndWait(updatedStop)
+	retry.UntilOrFail(t, store.HasSynced, retry.Timeout(time.Second*5))
+	expectList(1)
+	retry.UntilOrFail(t, func() bool {
+		return deletes.Load() == 1
+	}, retry.Message("expected stale config to be deleted"), retry.Timeout(time.Second*5))
+
+	// Removing the secret deletes all configs of the cluster
+	mc.Delete("remote")
+	expectList(0)
+	retry.UntilOrFail(t, func() bool {
+		return deletes.Load() == 2
+	}, retry.Message("expected config to be deleted"), retry.Timeout(time.Second*5))
+}
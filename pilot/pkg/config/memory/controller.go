@@ -22,7 +22,9 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/kube/kubetypes"
 	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/util/sets"
 )
 
 // Controller is an implementation of ConfigStoreController.
@@ -32,7 +34,7 @@ type Controller struct {
 	hasSynced   func() bool
 
 	// If meshConfig.DiscoverySelectors are specified, the namespacesFilter tracks the namespaces this controller watches.
-	namespacesFilter func(obj interface{}) bool
+	namespacesFilter kubetypes.DynamicObjectFilter
 }
 
 // NewController return an implementation of ConfigStoreController
@@ -56,6 +58,59 @@ func NewSyncController(cs model.ConfigStore) *Controller {
 	return out
 }
 
+// SetNamespacesFilter restricts the configs returned by Get and List, and the events dispatched to handlers,
+// to the namespaces selected by the filter. The underlying store still holds all configs, so when a namespace
+// is selected or deselected, add or delete events are dispatched for its configs.
+// This must be called before Run.
+func (c *Controller) SetNamespacesFilter(filter kubetypes.DynamicObjectFilter) {
+	c.namespacesFilter = filter
+	filter.AddHandler(func(selected, deselected sets.String) {
+		for _, s := range c.configStore.Schemas().All() {
+			for ns := range selected {
+				for _, cfg := range c.configStore.List(s.GroupVersionKind(), ns) {
+					c.monitor.ScheduleProcessEvent(ConfigEvent{config: cfg, event: model.EventAdd})
+				}
+			}
+			for ns := range deselected {
+				for _, cfg := range c.configStore.List(s.GroupVersionKind(), ns) {
+					c.monitor.ScheduleProcessEvent(ConfigEvent{config: cfg, event: model.EventDelete})
+				}
+			}
+		}
+	})
+}
+
+// Unfiltered returns a view of the controller which ignores the namespaces filter on reads.
+// Writes go through the controller, so events are still dispatched for selected namespaces.
+// Sources reconciling their full state into the controller, such as the XDS config source, should use it.
+func (c *Controller) Unfiltered() model.ConfigStore {
+	return unfilteredController{c}
+}
+
+type unfilteredController struct {
+	*Controller
+}
+
+func (u unfilteredController) Get(kind config.GroupVersionKind, key, namespace string) *config.Config {
+	return u.configStore.Get(kind, key, namespace)
+}
+
+func (u unfilteredController) List(kind config.GroupVersionKind, namespace string) []config.Config {
+	return u.configStore.List(kind, namespace)
+}
+
+func (c *Controller) selected(namespace string) bool {
+	return c.namespacesFilter == nil || c.namespacesFilter.Filter(namespace)
+}
+
+// scheduleEvent dispatches the event, unless the config is in a namespace excluded by the namespaces filter.
+func (c *Controller) scheduleEvent(event ConfigEvent) {
+	if !c.selected(event.config.Namespace) {
+		return
+	}
+	c.monitor.ScheduleProcessEvent(event)
+}
+
 func (c *Controller) RegisterHasSyncedHandler(cb func() bool) {
 	c.hasSynced = cb
 }
@@ -83,7 +138,7 @@ func (c *Controller) Schemas() collection.Schemas {
 }
 
 func (c *Controller) Get(kind config.GroupVersionKind, key, namespace string) *config.Config {
-	if c.namespacesFilter != nil && !c.namespacesFilter(namespace) {
+	if !c.selected(namespace) {
 		return nil
 	}
 	return c.configStore.Get(kind, key, namespace)
@@ -91,7 +146,7 @@ func (c *Controller) Get(kind config.GroupVersionKind, key, namespace string) *c
 
 func (c *Controller) Create(config config.Config) (revision string, err error) {
 	if revision, err = c.configStore.Create(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
+		c.scheduleEvent(ConfigEvent{
 			config: config,
 			event:  model.EventAdd,
 		})
@@ -102,7 +157,7 @@ func (c *Controller) Create(config config.Config) (revision string, err error) {
 func (c *Controller) Update(config config.Config) (newRevision string, err error) {
 	oldconfig := c.configStore.Get(config.GroupVersionKind, config.Name, config.Namespace)
 	if newRevision, err = c.configStore.Update(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
+		c.scheduleEvent(ConfigEvent{
 			old:    *oldconfig,
 			config: config,
 			event:  model.EventUpdate,
@@ -114,7 +169,7 @@ func (c *Controller) Update(config config.Config) (newRevision string, err error
 func (c *Controller) UpdateStatus(config config.Config) (newRevision string, err error) {
 	oldconfig := c.configStore.Get(config.GroupVersionKind, config.Name, config.Namespace)
 	if newRevision, err = c.configStore.UpdateStatus(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
+		c.scheduleEvent(ConfigEvent{
 			old:    *oldconfig,
 			config: config,
 			event:  model.EventUpdate,
@@ -132,7 +187,7 @@ func (c *Controller) Patch(orig config.Config, patchFn config.PatchFunc) (newRev
 		return "", fmt.Errorf("unsupported merge type: %s", typ)
 	}
 	if newRevision, err = c.configStore.Patch(cfg, patchFn); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
+		c.scheduleEvent(ConfigEvent{
 			old:    orig,
 			config: cfg,
 			event:  model.EventUpdate,
@@ -142,11 +197,11 @@ func (c *Controller) Patch(orig config.Config, patchFn config.PatchFunc) (newRev
 }
 
 func (c *Controller) Delete(kind config.GroupVersionKind, key, namespace string, resourceVersion *string) error {
-	if config := c.Get(kind, key, namespace); config != nil {
+	if config := c.configStore.Get(kind, key, namespace); config != nil {
 		if err := c.configStore.Delete(kind, key, namespace, resourceVersion); err != nil {
 			return err
 		}
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
+		c.scheduleEvent(ConfigEvent{
 			config: *config,
 			event:  model.EventDelete,
 		})
@@ -159,7 +214,7 @@ func (c *Controller) List(kind config.GroupVersionKind, namespace string) []conf
 	configs := c.configStore.List(kind, namespace)
 	if c.namespacesFilter != nil {
 		return slices.Filter(configs, func(config config.Config) bool {
-			return c.namespacesFilter(config)
+			return c.namespacesFilter.Filter(config.Namespace)
 		})
 	}
 	return configs
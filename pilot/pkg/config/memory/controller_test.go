@@ -19,8 +19,14 @@ import (
 	"testing"
 
 	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/test/mock"
+	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/test"
+	config2 "istio.io/istio/pkg/test/config"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/util/sets"
 )
 
 const (
@@ -64,3 +70,61 @@ func TestControllerHashSynced(t *testing.T) {
 		t.Error("has not synced but should")
 	}
 }
+
+type testNamespaceFilter struct {
+	selected sets.String
+	handler  func(selected, deselected sets.String)
+}
+
+func (f *testNamespaceFilter) Filter(obj any) bool {
+	return f.selected.Contains(obj.(string))
+}
+
+func (f *testNamespaceFilter) AddHandler(h func(selected, deselected sets.String)) {
+	f.handler = h
+}
+
+func TestControllerNamespacesFilter(t *testing.T) {
+	store := memory.MakeSkipValidation(collections.Mocks)
+	ctl := memory.NewSyncController(store)
+	filter := &testNamespaceFilter{selected: sets.New("selected")}
+	ctl.SetNamespacesFilter(filter)
+
+	events := map[string]model.Event{}
+	ctl.RegisterEventHandler(collections.Mock.GroupVersionKind(), func(_, cfg config.Config, event model.Event) {
+		events[cfg.Namespace+"/"+cfg.Name] = event
+	})
+	stop := test.NewStop(t)
+	go ctl.Run(stop)
+
+	for _, ns := range []string{"selected", "other"} {
+		if _, err := ctl.Create(config.Config{
+			Meta: config.Meta{
+				GroupVersionKind: collections.Mock.GroupVersionKind(),
+				Name:             "name",
+				Namespace:        ns,
+			},
+			Spec: &config2.MockConfig{Key: "key"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gvk := collections.Mock.GroupVersionKind()
+	assert.Equal(t, events, map[string]model.Event{"selected/name": model.EventAdd})
+	assert.Equal(t, len(ctl.List(gvk, "")), 1)
+	assert.Equal(t, ctl.Get(gvk, "name", "other"), nil)
+	assert.Equal(t, len(ctl.Unfiltered().List(gvk, "")), 2)
+	if ctl.Unfiltered().Get(gvk, "name", "other") == nil {
+		t.Fatalf("expected unfiltered view to return config in other namespace")
+	}
+
+	// Configs in unselected namespaces can still be deleted, without event
+	assert.NoError(t, ctl.Delete(gvk, "name", "other", nil))
+	assert.Equal(t, events, map[string]model.Event{"selected/name": model.EventAdd})
+
+	// Changes to the selection are dispatched as events
+	filter.selected = sets.New("other")
+	filter.handler(sets.New("other"), sets.New("selected"))
+	assert.Equal(t, events, map[string]model.Event{"selected/name": model.EventDelete})
+	assert.Equal(t, len(ctl.List(gvk, "")), 0)
+}
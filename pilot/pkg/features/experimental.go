@@ -74,6 +74,53 @@ var (
 		"If enabled, Pilot will keep track of old versions of distributed config for this duration.",
 	).Get()
 
+	DistributionHistoryFile = env.Register(
+		"PILOT_DISTRIBUTION_HISTORY_FILE",
+		"",
+		"If set, with config distribution tracking enabled, Pilot keeps the versions of distributed config in this "+
+			"file instead of memory, so they survive restarts.",
+	).Get()
+
+	ConfigSourceSnapshotDir = env.Register(
+		"PILOT_CONFIG_SOURCE_SNAPSHOT_DIR",
+		"",
+		"If set, the last synced config of each xds:// config source is saved in this directory, and used to start "+
+			"Istiod when the config source is not reachable.",
+	).Get()
+
+	ConfigSourceSnapshotTimeout = env.Register(
+		"PILOT_CONFIG_SOURCE_SNAPSHOT_TIMEOUT",
+		30*time.Second,
+		"How long Istiod waits for a reachable xds:// config source to sync before serving its config snapshot.",
+	).Get()
+
+	EnableConfigHistory = env.Register(
+		"PILOT_ENABLE_CONFIG_HISTORY",
+		false,
+		"If enabled, Istiod records the version of each config at each push in a ledger. The version of a config "+
+			"at a push, with a proof of inclusion in the ledger, is returned by /debug/config_history.",
+	).Get()
+
+	ConfigHistoryVersions = env.Register(
+		"PILOT_CONFIG_HISTORY_VERSIONS",
+		1000,
+		"The number of push versions retained by the config history, if PILOT_ENABLE_CONFIG_HISTORY is enabled.",
+	).Get()
+
+	ConfigHistoryRetention = env.Register(
+		"PILOT_CONFIG_HISTORY_RETENTION",
+		24*time.Hour,
+		"How long the config history retains push versions, if PILOT_ENABLE_CONFIG_HISTORY is enabled. Replaced "+
+			"config versions are removed from memory once the retention has elapsed.",
+	).Get()
+
+	ConfigHistoryDir = env.Register(
+		"PILOT_CONFIG_HISTORY_DIR",
+		"",
+		"If set, with PILOT_ENABLE_CONFIG_HISTORY enabled, Istiod keeps the config history in this directory instead "+
+			"of memory, so it survives restarts.",
+	).Get()
+
 	MCSAPIGroup = env.Register("MCS_API_GROUP", "multicluster.x-k8s.io",
 		"The group to be used for the Kubernetes Multi-Cluster Services (MCS) API.").Get()
 
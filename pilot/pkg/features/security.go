@@ -88,6 +88,31 @@ var (
 		return res
 	}()
 
+	CARevocationAuthorizedAccounts = func() sets.Set[types.NamespacedName] {
+		accounts := env.Register(
+			"CA_REVOCATION_AUTHORIZED_ACCOUNTS",
+			"",
+			"If set, the list of service accounts (namespace/name) that are allowed to revoke certificates issued by the CA. "+
+				"If unset, only the service account of Istiod is allowed.",
+		).Get()
+		res := sets.New[types.NamespacedName]()
+		if accounts == "" {
+			return res
+		}
+		for _, v := range strings.Split(accounts, ",") {
+			ns, sa, valid := strings.Cut(v, "/")
+			if !valid {
+				log.Warnf("Invalid CA_REVOCATION_AUTHORIZED_ACCOUNTS, ignoring: %v", v)
+				continue
+			}
+			res.Insert(types.NamespacedName{
+				Namespace: ns,
+				Name:      sa,
+			})
+		}
+		return res
+	}()
+
 	CertSignerDomain = env.Register("CERT_SIGNER_DOMAIN", "", "The cert signer domain info").Get()
 
 	UseCacertsForSelfSignedCA = env.Register("USE_CACERTS_FOR_SELF_SIGNED_CA", false,
@@ -55,6 +55,8 @@ const (
 	// * Other types use "prioritized leader election", which isn't implemented for Lease
 	GatewayDeploymentController = "istio-gateway-deployment"
 	NodeUntaintController       = "istio-node-untaint"
+	// ACMEController obtains gateway certificates from an ACME CA.
+	ACMEController = "istio-acme-controller"
 )
 
 // Leader election key prefix for remote istiod managed clusters
@@ -0,0 +1,97 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/schema/gvk"
+	"istio.io/istio/pkg/config/schema/kind"
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	// ACMEHTTP01PathPrefix is the path under which http-01 challenge responses are served.
+	ACMEHTTP01PathPrefix = "/.well-known/acme-challenge/"
+	// ACMETLSALPNProtocol is the ALPN protocol used by tls-alpn-01 challenges (RFC 8737).
+	ACMETLSALPNProtocol = "acme-tls/1"
+)
+
+// ACMEChallenge is a pending ACME challenge, answered by the gateways serving Host.
+type ACMEChallenge struct {
+	Host string
+	// Token and KeyAuthorization are set for http-01 challenges: KeyAuthorization is
+	// returned for ACMEHTTP01PathPrefix + Token on plain HTTP servers.
+	Token            string
+	KeyAuthorization string
+	// Certificate and Key are set for tls-alpn-01 challenges: the PEM certificate is served
+	// on TLS servers when the client requests the ACMETLSALPNProtocol.
+	Certificate []byte
+	Key         []byte
+}
+
+// IsTLSALPN returns true for tls-alpn-01 challenges.
+func (c ACMEChallenge) IsTLSALPN() bool {
+	return len(c.Certificate) > 0
+}
+
+// ACMEChallengeSource provides the pending ACME challenges.
+type ACMEChallengeSource interface {
+	ACMEChallenges() []ACMEChallenge
+}
+
+// ACMEChallengesForHosts returns the pending ACME challenges for hosts matching the
+// gateway server hosts.
+func (ps *PushContext) ACMEChallengesForHosts(hosts []host.Name) []ACMEChallenge {
+	var out []ACMEChallenge
+	for _, c := range ps.acmeChallenges {
+		for _, h := range hosts {
+			if host.Name(c.Host).SubsetOf(h) {
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ACMEChallengeGateways returns the keys of the Gateways with a server for the host of a challenge. Only the
+// proxies of these gateways answer the challenge, so a change of the challenge only needs to be pushed to them.
+func ACMEChallengeGateways(env *Environment, challengeHost string) sets.Set[ConfigKey] {
+	out := sets.New[ConfigKey]()
+	for _, gw := range env.List(gvk.Gateway, NamespaceAll) {
+		for _, server := range gw.Spec.(*networking.Gateway).GetServers() {
+			if serverMatchesHost(server, host.Name(challengeHost)) {
+				out.Insert(ConfigKey{Kind: kind.Gateway, Name: gw.Name, Namespace: gw.Namespace})
+				break
+			}
+		}
+	}
+	return out
+}
+
+func serverMatchesHost(server *networking.Server, hostname host.Name) bool {
+	for _, h := range server.Hosts {
+		if _, name, ok := strings.Cut(h, "/"); ok {
+			h = name
+		}
+		if hostname.SubsetOf(host.Name(h)) {
+			return true
+		}
+	}
+	return false
+}
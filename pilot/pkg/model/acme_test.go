@@ -0,0 +1,57 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
+	"istio.io/istio/pkg/config/schema/kind"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/util/sets"
+)
+
+func TestACMEChallengeGateways(t *testing.T) {
+	store := NewFakeStore()
+	gateway := func(name string, hosts ...string) {
+		_, err := store.Create(config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.Gateway, Name: name, Namespace: "istio-system"},
+			Spec: &networking.Gateway{
+				Servers: []*networking.Server{
+					{Port: &networking.Port{Number: 80, Name: "http", Protocol: "HTTP"}, Hosts: []string{"other.example.org"}},
+					{Port: &networking.Port{Number: 443, Name: "https", Protocol: "HTTPS"}, Hosts: hosts},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	gateway("exact", "www.example.com")
+	gateway("wildcard", "*.example.com")
+	gateway("namespaced", "default/www.example.com")
+	gateway("unrelated", "www.example.net")
+	env := NewEnvironment()
+	env.ConfigStore = store
+
+	key := func(name string) ConfigKey {
+		return ConfigKey{Kind: kind.Gateway, Name: name, Namespace: "istio-system"}
+	}
+	assert.Equal(t, ACMEChallengeGateways(env, "www.example.com"), sets.New(key("exact"), key("wildcard"), key("namespaced")))
+	assert.Equal(t, ACMEChallengeGateways(env, "api.example.com"), sets.New(key("wildcard")))
+	assert.Equal(t, ACMEChallengeGateways(env, "www.example.org"), sets.New[ConfigKey]())
+}
@@ -138,6 +138,9 @@ type Environment struct {
 
 	GatewayAPIController GatewayController
 
+	// ACMEChallenges provides the pending ACME challenges answered by gateways.
+	ACMEChallenges ACMEChallengeSource
+
 	// EndpointShards for a service. This is a global (per-server) list, built from
 	// incremental updates. This is keyed by service and namespace
 	EndpointIndex *EndpointIndex
@@ -45,3 +45,11 @@ func (d *DisabledLedger) RootHash() string {
 func (d *DisabledLedger) GetPreviousValue(previousHash, key string) (result string, err error) {
 	return "", errors.New("distribution tracking is disabled")
 }
+
+func (d *DisabledLedger) ProvePreviousValue(previousHash, key string) (*ledger.Proof, error) {
+	return nil, errors.New("distribution tracking is disabled")
+}
+
+func (d *DisabledLedger) Prove(key string) (*ledger.Proof, error) {
+	return nil, errors.New("distribution tracking is disabled")
+}
@@ -230,6 +230,9 @@ type PushContext struct {
 	// clusterLocalHosts extracted from the MeshConfig
 	clusterLocalHosts ClusterLocalHosts
 
+	// acmeChallenges are the pending ACME challenges answered by gateways.
+	acmeChallenges []ACMEChallenge
+
 	// sidecarIndex stores sidecar resources
 	sidecarIndex sidecarIndex
 
@@ -1272,6 +1275,10 @@ func (ps *PushContext) InitContext(env *Environment, oldPushContext *PushContext
 
 	ps.clusterLocalHosts = env.ClusterLocal().GetClusterLocalHosts()
 
+	if env.ACMEChallenges != nil {
+		ps.acmeChallenges = env.ACMEChallenges.ACMEChallenges()
+	}
+
 	ps.InitDone.Store(true)
 	return nil
 }
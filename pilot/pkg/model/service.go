@@ -1324,6 +1324,15 @@ func (s *Service) DeepCopy() *Service {
 	return &out
 }
 
+// ShallowCopy creates a shallow clone of Service, with its own Attributes and ClusterVIPs. The maps and
+// slices of the attributes are shared.
+func (s *Service) ShallowCopy() *Service {
+	// nolint: govet
+	out := *s
+	out.ClusterVIPs = *s.ClusterVIPs.DeepCopy()
+	return &out
+}
+
 // Equals compares two service objects.
 func (s *Service) Equals(other *Service) bool {
 	if s == nil {
@@ -0,0 +1,499 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core_test
+
+import (
+	"net/http"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sv1 "sigs.k8s.io/gateway-api/apis/v1"
+	k8sbeta "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/simulation"
+	"istio.io/istio/pilot/test/xds"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/mesh"
+	kubelib "istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/kclient/clienttest"
+	"istio.io/istio/pkg/test"
+)
+
+const authzPolicies = `
+apiVersion: security.istio.io/v1beta1
+kind: AuthorizationPolicy
+metadata:
+  name: ext
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  action: CUSTOM
+  provider:
+    name: ext-authz
+  rules:
+  - to:
+    - operation:
+        paths: ["/custom"]
+---
+apiVersion: security.istio.io/v1beta1
+kind: AuthorizationPolicy
+metadata:
+  name: deny-admin
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  action: DENY
+  rules:
+  - to:
+    - operation:
+        paths: ["/admin"]
+        methods: ["POST"]
+        ports: ["8080"]
+  - from:
+    - source:
+        ipBlocks: ["10.10.0.0/16"]
+---
+apiVersion: security.istio.io/v1beta1
+kind: AuthorizationPolicy
+metadata:
+  name: allow
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  action: ALLOW
+  rules:
+  - from:
+    - source:
+        namespaces: ["bar"]
+  - from:
+    - source:
+        requestPrincipals: ["example.com/alice"]
+    when:
+    - key: request.auth.claims[groups]
+      values: ["admin"]
+  - to:
+    - operation:
+        hosts: ["public.example.com"]
+  - from:
+    - source:
+        principals: ["cluster.local/ns/other/sa/tcp"]
+    to:
+    - operation:
+        ports: ["9090"]
+---
+`
+
+const authzServices = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+spec:
+  clusterIP: 10.0.0.10
+  selector:
+    app: foo
+  ports:
+  - name: http
+    port: 8080
+    targetPort: 8080
+  - name: tcp
+    port: 9090
+    targetPort: 9090
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ext-authz
+  namespace: default
+spec:
+  clusterIP: 10.0.0.20
+  ports:
+  - name: http
+    port: 8000
+    targetPort: 8000
+---
+`
+
+func authzMeshConfig() *meshconfig.MeshConfig {
+	m := mesh.DefaultMeshConfig()
+	m.ExtensionProviders = append(m.ExtensionProviders, &meshconfig.MeshConfig_ExtensionProvider{
+		Name: "ext-authz",
+		Provider: &meshconfig.MeshConfig_ExtensionProvider_EnvoyExtAuthzHttp{
+			EnvoyExtAuthzHttp: &meshconfig.MeshConfig_ExtensionProvider_EnvoyExternalAuthorizationHttpProvider{
+				Service: "ext-authz.default.svc.cluster.local",
+				Port:    8000,
+			},
+		},
+	})
+	return m
+}
+
+func TestAuthorizationPolicySidecar(t *testing.T) {
+	call := func(path string, mut func(c *simulation.Call)) simulation.Call {
+		c := simulation.Call{
+			Port:            8080,
+			Path:            path,
+			Protocol:        simulation.HTTP,
+			TLS:             simulation.MTLS,
+			CallMode:        simulation.CallModeInbound,
+			SourceNamespace: "bar",
+		}
+		if mut != nil {
+			mut(&c)
+		}
+		return c
+	}
+	proxy := &model.Proxy{
+		Labels:      map[string]string{"app": "foo"},
+		IPAddresses: []string{"10.0.0.1"},
+		Metadata:    &model.NodeMetadata{Labels: map[string]string{"app": "foo"}, ClusterID: "Kubernetes"},
+	}
+	runSimulationTest(t, proxy, xds.FakeOptions{MeshConfig: authzMeshConfig()}, simulationTest{
+		config:     authzPolicies,
+		kubeConfig: authzServices,
+		calls: []simulation.Expect{
+			{
+				Name: "allowed namespace",
+				Call: call("/", nil),
+				Result: simulation.Result{
+					ClusterMatched: "inbound|8080||",
+					RBAC:           simulation.RBACAllow,
+					RBACPolicy:     "ns[default]-policy[allow]-rule[0]",
+				},
+			},
+			{
+				Name: "other namespace",
+				Call: call("/", func(c *simulation.Call) {
+					c.SourceNamespace = "baz"
+				}),
+				Result: simulation.Result{
+					Error: simulation.ErrRBACDenied,
+					RBAC:  simulation.RBACDeny,
+				},
+			},
+			{
+				Name: "plaintext has no identity",
+				Call: call("/", func(c *simulation.Call) {
+					c.TLS = simulation.Plaintext
+				}),
+				Result: simulation.Result{
+					Error: simulation.ErrRBACDenied,
+					RBAC:  simulation.RBACDeny,
+				},
+			},
+			{
+				Name: "denied operation",
+				Call: call("/admin?debug=true", func(c *simulation.Call) {
+					c.Method = http.MethodPost
+				}),
+				Result: simulation.Result{
+					Error:      simulation.ErrRBACDenied,
+					RBAC:       simulation.RBACDeny,
+					RBACPolicy: "ns[default]-policy[deny-admin]-rule[0]",
+				},
+			},
+			{
+				Name: "other method",
+				Call: call("/admin", nil),
+				Result: simulation.Result{
+					RBAC:       simulation.RBACAllow,
+					RBACPolicy: "ns[default]-policy[allow]-rule[0]",
+				},
+			},
+			{
+				Name: "denied source ip",
+				Call: call("/", func(c *simulation.Call) {
+					c.SourceIP = "10.10.1.1"
+				}),
+				Result: simulation.Result{
+					Error:      simulation.ErrRBACDenied,
+					RBAC:       simulation.RBACDeny,
+					RBACPolicy: "ns[default]-policy[deny-admin]-rule[1]",
+				},
+			},
+			{
+				Name: "jwt claims",
+				Call: call("/", func(c *simulation.Call) {
+					c.SourceNamespace = "baz"
+					c.JWTClaims = map[string]any{"iss": "example.com", "sub": "alice", "groups": []string{"dev", "admin"}}
+				}),
+				Result: simulation.Result{
+					RBAC:       simulation.RBACAllow,
+					RBACPolicy: "ns[default]-policy[allow]-rule[1]",
+				},
+			},
+			{
+				Name: "jwt claims mismatch",
+				Call: call("/", func(c *simulation.Call) {
+					c.SourceNamespace = "baz"
+					c.JWTClaims = map[string]any{"iss": "example.com", "sub": "alice", "groups": "dev"}
+				}),
+				Result: simulation.Result{
+					Error: simulation.ErrRBACDenied,
+					RBAC:  simulation.RBACDeny,
+				},
+			},
+			{
+				Name: "host header",
+				Call: call("/", func(c *simulation.Call) {
+					c.SourceNamespace = "baz"
+					c.HostHeader = "PUBLIC.example.com"
+				}),
+				Result: simulation.Result{
+					RBAC:       simulation.RBACAllow,
+					RBACPolicy: "ns[default]-policy[allow]-rule[2]",
+				},
+			},
+			{
+				Name: "custom",
+				Call: call("/custom", nil),
+				Result: simulation.Result{
+					RBAC:       simulation.RBACCustom,
+					RBACPolicy: "istio-ext-authz-ns[default]-policy[ext]-rule[0]",
+				},
+			},
+			{
+				Name: "tcp",
+				Call: simulation.Call{
+					Port:            9090,
+					Protocol:        simulation.TCP,
+					TLS:             simulation.MTLS,
+					CallMode:        simulation.CallModeInbound,
+					SourcePrincipal: "cluster.local/ns/other/sa/tcp",
+				},
+				Result: simulation.Result{
+					ClusterMatched: "inbound|9090||",
+					RBAC:           simulation.RBACAllow,
+					RBACPolicy:     "ns[default]-policy[allow]-rule[3]",
+				},
+			},
+			{
+				Name: "tcp spiffe principal",
+				Call: simulation.Call{
+					Port:            9090,
+					Protocol:        simulation.TCP,
+					TLS:             simulation.MTLS,
+					CallMode:        simulation.CallModeInbound,
+					SourcePrincipal: "spiffe://cluster.local/ns/other/sa/tcp",
+				},
+				Result: simulation.Result{
+					ClusterMatched: "inbound|9090||",
+					RBAC:           simulation.RBACAllow,
+					RBACPolicy:     "ns[default]-policy[allow]-rule[3]",
+				},
+			},
+			{
+				Name: "tcp denied",
+				Call: simulation.Call{
+					Port:            9090,
+					Protocol:        simulation.TCP,
+					TLS:             simulation.MTLS,
+					CallMode:        simulation.CallModeInbound,
+					SourcePrincipal: "cluster.local/ns/other/sa/default",
+				},
+				Result: simulation.Result{
+					Error: simulation.ErrRBACDenied,
+					RBAC:  simulation.RBACDeny,
+				},
+			},
+		},
+	})
+}
+
+func TestAuthorizationPolicyWaypoint(t *testing.T) {
+	test.SetForTest(t, &features.EnableAmbient, true)
+	test.SetForTest(t, &features.EnableAmbientWaypoints, true)
+	policies := `
+apiVersion: security.istio.io/v1
+kind: AuthorizationPolicy
+metadata:
+  name: deny-admin
+  namespace: default
+spec:
+  targetRefs:
+  - kind: Gateway
+    group: gateway.networking.k8s.io
+    name: waypoint
+  action: DENY
+  rules:
+  - to:
+    - operation:
+        paths: ["/admin"]
+---
+apiVersion: security.istio.io/v1
+kind: AuthorizationPolicy
+metadata:
+  name: allow-foo
+  namespace: default
+spec:
+  targetRefs:
+  - kind: Service
+    group: ""
+    name: foo
+  action: ALLOW
+  rules:
+  - from:
+    - source:
+        principals: ["cluster.local/ns/bar/sa/client"]
+---
+`
+	services := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: waypoint
+  namespace: default
+spec:
+  clusterIP: 10.0.0.100
+  selector:
+    gateway.networking.k8s.io/gateway-name: waypoint
+  ports:
+  - name: mesh
+    port: 15008
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+  labels:
+    istio.io/use-waypoint: waypoint
+spec:
+  clusterIP: 10.0.0.10
+  selector:
+    app: foo
+  ports:
+  - name: http
+    port: 8080
+    targetPort: 8080
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: other
+  namespace: default
+  labels:
+    istio.io/use-waypoint: waypoint
+spec:
+  clusterIP: 10.0.0.11
+  selector:
+    app: other
+  ports:
+  - name: http
+    port: 8080
+    targetPort: 8080
+---
+`
+	addrType := k8sv1.IPAddressType
+	opts := xds.FakeOptions{
+		// The waypoint address comes from the Gateway status
+		KubeClientModifier: func(c kubelib.Client) {
+			clienttest.NewWriter[*k8sbeta.Gateway](t, c).Create(&k8sbeta.Gateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "waypoint", Namespace: "default"},
+				Spec: k8sbeta.GatewaySpec{
+					GatewayClassName: constants.WaypointGatewayClassName,
+					Listeners:        []k8sbeta.Listener{{Name: "mesh", Port: 15008, Protocol: "HBONE"}},
+				},
+				Status: k8sbeta.GatewayStatus{Addresses: []k8sv1.GatewayStatusAddress{{Type: &addrType, Value: "10.0.0.100"}}},
+			})
+		},
+	}
+	labels := map[string]string{constants.GatewayNameLabel: "waypoint"}
+	proxy := &model.Proxy{
+		Type:        model.Waypoint,
+		Labels:      labels,
+		IPAddresses: []string{"10.0.0.200"},
+		Metadata:    &model.NodeMetadata{Labels: labels, ClusterID: "Kubernetes", Namespace: "default"},
+	}
+	call := func(address, path, principal string) simulation.Call {
+		return simulation.Call{
+			Address:         address,
+			Port:            8080,
+			Path:            path,
+			Protocol:        simulation.HTTP,
+			CallMode:        simulation.CallModeWaypoint,
+			SourcePrincipal: principal,
+		}
+	}
+	runSimulationTest(t, proxy, opts, simulationTest{
+		config:     policies,
+		kubeConfig: services,
+		calls: []simulation.Expect{
+			{
+				Name: "allowed",
+				Call: call("10.0.0.10", "/", "cluster.local/ns/bar/sa/client"),
+				Result: simulation.Result{
+					ListenerMatched:    "main_internal",
+					FilterChainMatched: "inbound-vip|8080||foo.default.svc.cluster.local-http",
+					ClusterMatched:     "inbound-vip|8080|http|foo.default.svc.cluster.local",
+					RBAC:               simulation.RBACAllow,
+					RBACPolicy:         "ns[default]-policy[allow-foo]-rule[0]",
+				},
+			},
+			{
+				Name: "not allowed",
+				Call: call("10.0.0.10", "/", "cluster.local/ns/bar/sa/default"),
+				Result: simulation.Result{
+					Error: simulation.ErrRBACDenied,
+					RBAC:  simulation.RBACDeny,
+				},
+			},
+			{
+				Name: "denied by waypoint policy",
+				Call: call("10.0.0.10", "/admin", "cluster.local/ns/bar/sa/client"),
+				Result: simulation.Result{
+					Error:      simulation.ErrRBACDenied,
+					RBAC:       simulation.RBACDeny,
+					RBACPolicy: "ns[default]-policy[deny-admin]-rule[0]",
+				},
+			},
+			{
+				Name: "service without allow policy",
+				Call: call("10.0.0.11", "/", "cluster.local/ns/bar/sa/default"),
+				Result: simulation.Result{
+					FilterChainMatched: "inbound-vip|8080||other.default.svc.cluster.local-http",
+					ClusterMatched:     "inbound-vip|8080|http|other.default.svc.cluster.local",
+					RBAC:               simulation.RBACAllow,
+				},
+			},
+			{
+				Name: "waypoint policy applies to all services",
+				Call: call("10.0.0.11", "/admin", "cluster.local/ns/bar/sa/default"),
+				Result: simulation.Result{
+					Error:      simulation.ErrRBACDenied,
+					RBAC:       simulation.RBACDeny,
+					RBACPolicy: "ns[default]-policy[deny-admin]-rule[0]",
+				},
+			},
+			{
+				Name: "unknown address",
+				Call: call("10.0.0.12", "/", "cluster.local/ns/bar/sa/client"),
+				Result: simulation.Result{
+					Error: simulation.ErrNoFilterChain,
+				},
+			},
+		},
+	})
+}
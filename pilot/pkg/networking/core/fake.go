@@ -87,6 +87,9 @@ type TestOptions struct {
 
 	// XDSUpdater to use. Otherwise, our own will be used
 	XDSUpdater model.XDSUpdater
+
+	// ACMEChallenges provides the pending ACME challenges, if set.
+	ACMEChallenges model.ACMEChallengeSource
 }
 
 func (to TestOptions) FuzzValidate() bool {
@@ -173,6 +176,7 @@ func NewConfigGenTest(t test.Failer, opts TestOptions) *ConfigGenTest {
 	env.ServiceDiscovery = serviceDiscovery
 	env.ConfigStore = configController
 	env.NetworksWatcher = opts.NetworksWatcher
+	env.ACMEChallenges = opts.ACMEChallenges
 	env.Init()
 
 	fake := &ConfigGenTest{
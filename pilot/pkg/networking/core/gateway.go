@@ -286,6 +286,7 @@ func (configgen *ConfigGeneratorImpl) buildGatewayTCPBasedFilterChains(
 				opts.filterChainOpts = append(opts.filterChainOpts, tcpChainOpts...)
 			}
 		}
+		opts.filterChainOpts = append(opts.filterChainOpts, buildGatewayACMETLSALPNFilterChains(builder.push, builder.node, serversForPort.Servers)...)
 	}
 }
 
@@ -514,6 +515,8 @@ func (configgen *ConfigGeneratorImpl) buildGatewayHTTPRouteConfig(node *model.Pr
 			}
 			vHostDedupMap[host.Name(hostname)] = newVHost
 		}
+
+		addACMEHTTP01Routes(push, server, port, vHostDedupMap)
 	}
 
 	var virtualHosts []*route.VirtualHost
@@ -0,0 +1,157 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/pkg/util/protoconv"
+	"istio.io/istio/pkg/config/gateway"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/util/sets"
+	"istio.io/istio/pkg/wellknown"
+)
+
+// addACMEHTTP01Routes adds routes answering the pending http-01 challenges for the hosts of a
+// plain HTTP server. Hosts served by a wildcard virtual host get their own virtual host, with
+// the routes of the wildcard one. The challenge is answered over HTTP even if the server
+// redirects to HTTPS, as required by the ACME server.
+func addACMEHTTP01Routes(push *model.PushContext, server *networking.Server, port int,
+	vHostDedupMap map[host.Name]*route.VirtualHost,
+) {
+	if protocol.Parse(server.Port.Protocol).IsTLS() {
+		return
+	}
+	for _, c := range push.ACMEChallengesForHosts(serverHostNames(server)) {
+		if c.IsTLSALPN() {
+			continue
+		}
+		path := model.ACMEHTTP01PathPrefix + c.Token
+		hostname := host.Name(strings.ToLower(c.Host))
+		vHost, exists := vHostDedupMap[hostname]
+		if !exists {
+			vHost = &route.VirtualHost{
+				Name:    util.DomainName(hostname.String(), port),
+				Domains: []string{hostname.String()},
+			}
+			if parent := mostSpecificVirtualHost(hostname, vHostDedupMap); parent != nil {
+				vHost.Routes = slices.Clone(parent.Routes)
+				vHost.RequireTls = parent.RequireTls
+				vHost.TypedPerFilterConfig = parent.TypedPerFilterConfig
+				vHost.IncludeRequestAttemptCount = parent.IncludeRequestAttemptCount
+			}
+			vHostDedupMap[hostname] = vHost
+		}
+		if slices.FindFunc(vHost.Routes, func(r *route.Route) bool { return r.GetMatch().GetPath() == path }) != nil {
+			// Another server on the port already added it.
+			continue
+		}
+		if vHost.RequireTls == route.VirtualHost_ALL {
+			// require_tls applies before routing; redirect everything else with a route instead.
+			vHost.RequireTls = route.VirtualHost_NONE
+			vHost.Routes = []*route.Route{{
+				Match: &route.RouteMatch{PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &route.Route_Redirect{Redirect: &route.RedirectAction{
+					SchemeRewriteSpecifier: &route.RedirectAction_HttpsRedirect{HttpsRedirect: true},
+				}},
+			}}
+		}
+		vHost.Routes = append([]*route.Route{{
+			Name:  "acme-challenge",
+			Match: &route.RouteMatch{PathSpecifier: &route.RouteMatch_Path{Path: path}},
+			Action: &route.Route_DirectResponse{DirectResponse: &route.DirectResponseAction{
+				Status: 200,
+				Body:   &core.DataSource{Specifier: &core.DataSource_InlineString{InlineString: c.KeyAuthorization}},
+			}},
+		}}, vHost.Routes...)
+	}
+}
+
+// serverHostNames returns the hosts of a gateway server, without namespace.
+func serverHostNames(server *networking.Server) []host.Name {
+	return slices.Map(server.Hosts, func(h string) host.Name {
+		if _, name, ok := strings.Cut(h, "/"); ok {
+			return host.Name(name)
+		}
+		return host.Name(h)
+	})
+}
+
+// mostSpecificVirtualHost returns the wildcard virtual host that would serve hostname.
+func mostSpecificVirtualHost(hostname host.Name, vHostDedupMap map[host.Name]*route.VirtualHost) *route.VirtualHost {
+	var best host.Name
+	for h := range vHostDedupMap {
+		if h.IsWildCarded() && hostname.SubsetOf(h) && (best == "" || len(h) > len(best)) {
+			best = h
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return vHostDedupMap[best]
+}
+
+// buildGatewayACMETLSALPNFilterChains returns filter chains answering the pending tls-alpn-01
+// challenges for the hosts of TLS terminating servers. They only match connections requesting
+// the acme-tls/1 protocol, which are closed after the handshake.
+func buildGatewayACMETLSALPNFilterChains(push *model.PushContext, node *model.Proxy, servers []*networking.Server) []*filterChainOpts {
+	var out []*filterChainOpts
+	seen := sets.New[string]()
+	for _, server := range servers {
+		if server.Tls == nil || gateway.IsPassThroughServer(server) {
+			continue
+		}
+		sniHosts := node.MergedGateway.TLSServerInfo[server].SNIHosts
+		for _, c := range push.ACMEChallengesForHosts(serverHostNames(server)) {
+			// Only for exact server hosts: a more specific server name would shadow a wildcard
+			// server for the other protocols.
+			if !c.IsTLSALPN() || !slices.Contains(sniHosts, c.Host) || seen.InsertContains(c.Host) {
+				continue
+			}
+			out = append(out, &filterChainOpts{
+				sniHosts:             []string{c.Host},
+				applicationProtocols: []string{model.ACMETLSALPNProtocol},
+				tlsContext: &tls.DownstreamTlsContext{
+					CommonTlsContext: &tls.CommonTlsContext{
+						TlsCertificates: []*tls.TlsCertificate{{
+							CertificateChain: &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: c.Certificate}},
+							PrivateKey:       &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: c.Key}},
+						}},
+						AlpnProtocols: []string{model.ACMETLSALPNProtocol},
+					},
+				},
+				networkFilters: []*listener.Filter{{
+					Name: wellknown.TCPProxy,
+					ConfigType: &listener.Filter_TypedConfig{TypedConfig: protoconv.MessageToAny(&tcp.TcpProxy{
+						StatPrefix:       util.BlackHoleCluster,
+						ClusterSpecifier: &tcp.TcpProxy_Cluster{Cluster: util.BlackHoleCluster},
+					})},
+				}},
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+type staticACMEChallenges []model.ACMEChallenge
+
+func (s staticACMEChallenges) ACMEChallenges() []model.ACMEChallenge {
+	return s
+}
+
+func acmeGateway(servers ...*networking.Server) config.Config {
+	return config.Config{
+		Meta: config.Meta{Name: "gw", Namespace: "testns", GroupVersionKind: gvk.Gateway},
+		Spec: &networking.Gateway{
+			Selector: map[string]string{"istio": "ingressgateway"},
+			Servers:  servers,
+		},
+	}
+}
+
+var (
+	acmeHTTPChallenge = model.ACMEChallenge{Host: "a.example.com", Token: "token", KeyAuthorization: "token.thumbprint"}
+	acmeTLSChallenge  = model.ACMEChallenge{Host: "a.example.com", Certificate: []byte("cert"), Key: []byte("key")}
+)
+
+func TestGatewayACMEHTTP01Routes(t *testing.T) {
+	wildcardVirtualService := config.Config{
+		Meta: config.Meta{Name: "vs", Namespace: "testns", GroupVersionKind: gvk.VirtualService},
+		Spec: &networking.VirtualService{
+			Gateways: []string{"testns/gw"},
+			Hosts:    []string{"*.example.com"},
+			Http: []*networking.HTTPRoute{{
+				Name:  "default",
+				Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "example.org"}}},
+			}},
+		},
+	}
+	cases := []struct {
+		name       string
+		configs    []config.Config
+		challenges []model.ACMEChallenge
+		// expected route names or redirect ("redirect") per virtual host
+		expected   map[string][]string
+		requireTLS map[string]route.VirtualHost_TlsRequirementType
+	}{
+		{
+			name: "https redirect",
+			configs: []config.Config{acmeGateway(&networking.Server{
+				Port:  &networking.Port{Name: "http", Number: 80, Protocol: "HTTP"},
+				Hosts: []string{"a.example.com"},
+				Tls:   &networking.ServerTLSSettings{HttpsRedirect: true},
+			})},
+			challenges: []model.ACMEChallenge{acmeHTTPChallenge},
+			expected:   map[string][]string{"a.example.com:80": {"acme-challenge", "redirect"}},
+			requireTLS: map[string]route.VirtualHost_TlsRequirementType{"a.example.com:80": route.VirtualHost_NONE},
+		},
+		{
+			name: "wildcard virtual host",
+			configs: []config.Config{acmeGateway(&networking.Server{
+				Port:  &networking.Port{Name: "http", Number: 80, Protocol: "HTTP"},
+				Hosts: []string{"testns/*.example.com"},
+			}), wildcardVirtualService},
+			challenges: []model.ACMEChallenge{acmeHTTPChallenge},
+			expected: map[string][]string{
+				"*.example.com:80": {"default"},
+				"a.example.com:80": {"acme-challenge", "default"},
+			},
+		},
+		{
+			name: "wildcard redirect",
+			configs: []config.Config{acmeGateway(&networking.Server{
+				Port:  &networking.Port{Name: "http", Number: 80, Protocol: "HTTP"},
+				Hosts: []string{"*.example.com"},
+				Tls:   &networking.ServerTLSSettings{HttpsRedirect: true},
+			})},
+			challenges: []model.ACMEChallenge{acmeHTTPChallenge},
+			expected: map[string][]string{
+				"*.example.com:80": {},
+				"a.example.com:80": {"acme-challenge", "redirect"},
+			},
+			requireTLS: map[string]route.VirtualHost_TlsRequirementType{
+				"*.example.com:80": route.VirtualHost_ALL,
+				"a.example.com:80": route.VirtualHost_NONE,
+			},
+		},
+		{
+			name: "other hosts and challenge types",
+			configs: []config.Config{acmeGateway(&networking.Server{
+				Port:  &networking.Port{Name: "http", Number: 80, Protocol: "HTTP"},
+				Hosts: []string{"b.example.com"},
+				Tls:   &networking.ServerTLSSettings{HttpsRedirect: true},
+			})},
+			challenges: []model.ACMEChallenge{acmeHTTPChallenge, {Host: "b.example.com", Certificate: []byte("cert")}},
+			expected:   map[string][]string{"b.example.com:80": {}},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := NewConfigGenTest(t, TestOptions{
+				Configs:        tt.configs,
+				ACMEChallenges: staticACMEChallenges(tt.challenges),
+			})
+			r := cg.ConfigGen.buildGatewayHTTPRouteConfig(cg.SetupProxy(&proxyGateway), cg.PushContext(), "http.80")
+			got := map[string][]string{}
+			for _, vh := range r.VirtualHosts {
+				got[vh.Name] = slices.Map(vh.Routes, func(r *route.Route) string {
+					if r.GetRedirect().GetHttpsRedirect() {
+						return "redirect"
+					}
+					if r.Name == "acme-challenge" {
+						assert.Equal(t, r.Match.GetPath(), "/.well-known/acme-challenge/token")
+						assert.Equal(t, r.GetDirectResponse().GetBody().GetInlineString(), "token.thumbprint")
+					}
+					return r.Name
+				})
+				if want, f := tt.requireTLS[vh.Name]; f {
+					assert.Equal(t, vh.RequireTls, want)
+				}
+			}
+			assert.Equal(t, got, tt.expected)
+		})
+	}
+}
+
+func TestGatewayACMETLSALPNFilterChains(t *testing.T) {
+	httpsServer := func(hosts ...string) *networking.Server {
+		return &networking.Server{
+			Port:  &networking.Port{Name: "https", Number: 443, Protocol: "HTTPS"},
+			Hosts: hosts,
+			Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_SIMPLE, CredentialName: "a-cert"},
+		}
+	}
+	cases := []struct {
+		name       string
+		servers    []*networking.Server
+		challenges []model.ACMEChallenge
+		expected   bool
+	}{
+		{
+			name:       "exact host",
+			servers:    []*networking.Server{httpsServer("a.example.com")},
+			challenges: []model.ACMEChallenge{acmeTLSChallenge},
+			expected:   true,
+		},
+		{
+			name:       "wildcard host",
+			servers:    []*networking.Server{httpsServer("*.example.com")},
+			challenges: []model.ACMEChallenge{acmeTLSChallenge},
+		},
+		{
+			name:       "http-01 challenge",
+			servers:    []*networking.Server{httpsServer("a.example.com")},
+			challenges: []model.ACMEChallenge{acmeHTTPChallenge},
+		},
+		{
+			name: "passthrough",
+			servers: []*networking.Server{{
+				Port:  &networking.Port{Name: "tls", Number: 443, Protocol: "TLS"},
+				Hosts: []string{"a.example.com"},
+				Tls:   &networking.ServerTLSSettings{Mode: networking.ServerTLSSettings_PASSTHROUGH},
+			}},
+			challenges: []model.ACMEChallenge{acmeTLSChallenge},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := NewConfigGenTest(t, TestOptions{
+				Configs:        []config.Config{acmeGateway(tt.servers...)},
+				ACMEChallenges: staticACMEChallenges(tt.challenges),
+			})
+			proxy := cg.SetupProxy(&proxyGateway)
+			builder := cg.ConfigGen.buildGatewayListeners(NewListenerBuilder(proxy, cg.PushContext()))
+			xdstest.ValidateListeners(t, builder.gatewayListeners)
+
+			found := false
+			for _, l := range builder.gatewayListeners {
+				for _, fc := range l.FilterChains {
+					if slices.Contains(fc.GetFilterChainMatch().GetApplicationProtocols(), model.ACMETLSALPNProtocol) {
+						found = true
+						assert.Equal(t, fc.FilterChainMatch.ServerNames, []string{"a.example.com"})
+						assert.Equal(t, xdstest.ExtractTCPProxy(t, fc).GetCluster(), util.BlackHoleCluster)
+					}
+				}
+			}
+			assert.Equal(t, found, tt.expected)
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry"
+	"istio.io/istio/pilot/pkg/serviceregistry/provider"
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/labels"
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/util/sets"
+)
+
+var log = istiolog.RegisterScope("serviceregistry", "service registry plugins")
+
+// Controller adapts a Registry to a serviceregistry.Instance, which can be added to the aggregate controller.
+// It keeps the last state read from the Registry, and on each change computes the service, endpoint and
+// workload events to send.
+type Controller struct {
+	providerID provider.ID
+	clusterID  cluster.ID
+	registry   Registry
+	xdsUpdater model.XDSUpdater
+
+	handlers model.ControllerHandlers
+
+	// syncMu serializes syncs, so events are sent in order.
+	syncMu sync.Mutex
+	synced *atomic.Bool
+
+	mu sync.RWMutex
+	// services by hostname
+	services map[host.Name]*model.Service
+	// endpoints of each service, sorted
+	endpoints map[host.Name][]*model.IstioEndpoint
+	// instances by endpoint address
+	instancesByIP map[string][]*model.ServiceInstance
+	// workloads by endpoint address
+	workloads map[string]*model.WorkloadInstance
+}
+
+var _ serviceregistry.Instance = &Controller{}
+
+// ControllerOptions configures a Controller.
+type ControllerOptions struct {
+	ClusterID cluster.ID
+	// XDSUpdater is notified of service and endpoint changes. Optional.
+	XDSUpdater model.XDSUpdater
+}
+
+// NewController creates a Controller for the registry registered under providerID.
+func NewController(providerID provider.ID, registry Registry, opts ControllerOptions) *Controller {
+	return &Controller{
+		providerID:    providerID,
+		clusterID:     opts.ClusterID,
+		registry:      registry,
+		xdsUpdater:    opts.XDSUpdater,
+		synced:        atomic.NewBool(false),
+		services:      map[host.Name]*model.Service{},
+		endpoints:     map[host.Name][]*model.IstioEndpoint{},
+		instancesByIP: map[string][]*model.ServiceInstance{},
+		workloads:     map[string]*model.WorkloadInstance{},
+	}
+}
+
+func (c *Controller) shardKey() model.ShardKey {
+	return model.ShardKey{Cluster: c.clusterID, Provider: c.providerID}
+}
+
+// Provider implements serviceregistry.Instance
+func (c *Controller) Provider() provider.ID {
+	return c.providerID
+}
+
+// Cluster implements serviceregistry.Instance
+func (c *Controller) Cluster() cluster.ID {
+	return c.clusterID
+}
+
+// Run implements model.Controller
+func (c *Controller) Run(stop <-chan struct{}) {
+	c.sync()
+	c.registry.Run(stop, c.sync)
+}
+
+// HasSynced implements model.Controller
+func (c *Controller) HasSynced() bool {
+	return c.synced.Load()
+}
+
+// AppendServiceHandler implements model.Controller
+func (c *Controller) AppendServiceHandler(f model.ServiceHandler) {
+	c.handlers.AppendServiceHandler(f)
+}
+
+// AppendWorkloadHandler implements model.Controller
+func (c *Controller) AppendWorkloadHandler(f func(*model.WorkloadInstance, model.Event)) {
+	c.handlers.AppendWorkloadHandler(f)
+}
+
+type serviceEvent struct {
+	prev, curr *model.Service
+	event      model.Event
+}
+
+type workloadEvent struct {
+	wi    *model.WorkloadInstance
+	event model.Event
+}
+
+// sync reads the state of the registry, and sends events for the changes since the last sync.
+func (c *Controller) sync() {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	// Read HasSynced first: the state read after it is at least as recent.
+	synced := c.registry.HasSynced()
+
+	services := map[host.Name]*model.Service{}
+	for _, svc := range c.registry.Services() {
+		// The objects of the registry must not be modified: set the registry on a copy.
+		svc = svc.ShallowCopy()
+		svc.Attributes.ServiceRegistry = c.providerID
+		services[svc.Hostname] = svc
+	}
+	endpoints := map[host.Name][]*model.IstioEndpoint{}
+	instancesByIP := map[string][]*model.ServiceInstance{}
+	workloads := map[string]*model.WorkloadInstance{}
+	for _, si := range c.registry.Instances() {
+		if si.Service == nil || services[si.Service.Hostname] == nil || si.Endpoint == nil {
+			log.Warnf("%s: ignoring instance of unknown service", c.providerID)
+			continue
+		}
+		hostname := si.Service.Hostname
+		// Point to the copy of the service.
+		cp := *si
+		cp.Service = services[hostname]
+		si = &cp
+		endpoints[hostname] = append(endpoints[hostname], si.Endpoint)
+		instancesByIP[si.Endpoint.Address] = append(instancesByIP[si.Endpoint.Address], si)
+		workloads[si.Endpoint.Address] = mergeWorkload(workloads[si.Endpoint.Address], si)
+	}
+	for _, eps := range endpoints {
+		sort.Slice(eps, func(i, j int) bool {
+			return compareEndpoints(eps[i], eps[j]) < 0
+		})
+	}
+
+	c.mu.Lock()
+	prevServices, prevEndpoints, prevWorkloads := c.services, c.endpoints, c.workloads
+	c.services, c.endpoints, c.instancesByIP, c.workloads = services, endpoints, instancesByIP, workloads
+	c.mu.Unlock()
+
+	var svcEvents []serviceEvent
+	var endpointsChanged []*model.Service
+	for name, svc := range services {
+		prev, f := prevServices[name]
+		switch {
+		case !f:
+			svcEvents = append(svcEvents, serviceEvent{curr: svc, event: model.EventAdd})
+		case !prev.Equals(svc):
+			svcEvents = append(svcEvents, serviceEvent{prev: prev, curr: svc, event: model.EventUpdate})
+		case !endpointsEqual(prevEndpoints[name], endpoints[name]):
+			endpointsChanged = append(endpointsChanged, svc)
+		}
+	}
+	for name, prev := range prevServices {
+		if _, f := services[name]; !f {
+			svcEvents = append(svcEvents, serviceEvent{curr: prev, event: model.EventDelete})
+		}
+	}
+
+	var wlEvents []workloadEvent
+	for ip, wi := range workloads {
+		prev, f := prevWorkloads[ip]
+		if !f {
+			wlEvents = append(wlEvents, workloadEvent{wi: wi, event: model.EventAdd})
+		} else if !model.WorkloadInstancesEqual(prev, wi) {
+			wlEvents = append(wlEvents, workloadEvent{wi: wi, event: model.EventUpdate})
+		}
+	}
+	for ip, prev := range prevWorkloads {
+		if _, f := workloads[ip]; !f {
+			wlEvents = append(wlEvents, workloadEvent{wi: prev, event: model.EventDelete})
+		}
+	}
+
+	shard := c.shardKey()
+	for _, e := range svcEvents {
+		svc := e.curr
+		log.Debugf("%s: service %s %s", c.providerID, svc.Hostname, e.event)
+		if c.xdsUpdater != nil {
+			c.xdsUpdater.SvcUpdate(shard, string(svc.Hostname), svc.Attributes.Namespace, e.event)
+			if e.event != model.EventDelete {
+				// Services changes trigger a full push, which will include the endpoints.
+				c.xdsUpdater.EDSCacheUpdate(shard, string(svc.Hostname), svc.Attributes.Namespace, endpoints[svc.Hostname])
+			}
+		}
+		c.handlers.NotifyServiceHandlers(e.prev, e.curr, e.event)
+	}
+	for _, svc := range endpointsChanged {
+		log.Debugf("%s: endpoints of %s updated", c.providerID, svc.Hostname)
+		if c.xdsUpdater != nil {
+			c.xdsUpdater.EDSUpdate(shard, string(svc.Hostname), svc.Attributes.Namespace, endpoints[svc.Hostname])
+		}
+	}
+	for _, e := range wlEvents {
+		c.handlers.NotifyWorkloadHandlers(e.wi, e.event)
+	}
+	if synced && !c.synced.Load() {
+		log.Infof("%s: synced %d services", c.providerID, len(services))
+		c.synced.Store(true)
+	}
+}
+
+// mergeWorkload adds the port of the instance to the workload at its address.
+func mergeWorkload(wi *model.WorkloadInstance, si *model.ServiceInstance) *model.WorkloadInstance {
+	if wi == nil {
+		ep := si.Endpoint.ShallowCopy()
+		// The workload is not specific to a service port
+		ep.ServicePortName = ""
+		ep.EndpointPort = 0
+		ep.LegacyClusterPortKey = 0
+		name := ep.WorkloadName
+		if name == "" {
+			name = ep.Address
+		}
+		wi = &model.WorkloadInstance{
+			Name:      name,
+			Namespace: si.Service.Attributes.Namespace,
+			// Instances from other registries are handled like pods by the ServiceEntry controller.
+			Kind:     model.PodKind,
+			Endpoint: ep,
+			PortMap:  map[string]uint32{},
+		}
+	}
+	if si.ServicePort != nil && si.ServicePort.Name != "" {
+		wi.PortMap[si.ServicePort.Name] = si.Endpoint.EndpointPort
+	}
+	return wi
+}
+
+func compareEndpoints(a, b *model.IstioEndpoint) int {
+	if r := cmp.Compare(a.Address, b.Address); r != 0 {
+		return r
+	}
+	if r := cmp.Compare(a.EndpointPort, b.EndpointPort); r != 0 {
+		return r
+	}
+	return cmp.Compare(a.ServicePortName, b.ServicePortName)
+}
+
+func endpointsEqual(a, b []*model.IstioEndpoint) bool {
+	return slices.EqualFunc(a, b, func(x, y *model.IstioEndpoint) bool {
+		return x.Equals(y)
+	})
+}
+
+// Services implements model.ServiceDiscovery
+func (c *Controller) Services() []*model.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]*model.Service, 0, len(c.services))
+	for _, svc := range c.services {
+		out = append(out, svc)
+	}
+	return slices.SortBy(out, func(s *model.Service) host.Name {
+		return s.Hostname
+	})
+}
+
+// GetService implements model.ServiceDiscovery
+func (c *Controller) GetService(hostname host.Name) *model.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.services[hostname]
+}
+
+// GetProxyServiceTargets implements model.ServiceDiscovery
+func (c *Controller) GetProxyServiceTargets(node *model.Proxy) []model.ServiceTarget {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []model.ServiceTarget
+	for _, ip := range node.IPAddresses {
+		for _, si := range c.instancesByIP[ip] {
+			out = append(out, model.ServiceInstanceToTarget(si))
+		}
+	}
+	return out
+}
+
+// GetProxyWorkloadLabels implements model.ServiceDiscovery
+func (c *Controller) GetProxyWorkloadLabels(node *model.Proxy) labels.Instance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, ip := range node.IPAddresses {
+		if wi, f := c.workloads[ip]; f {
+			return wi.Endpoint.Labels
+		}
+	}
+	return nil
+}
+
+// NetworkGateways implements model.NetworkGatewaysWatcher
+func (c *Controller) NetworkGateways() []model.NetworkGateway {
+	return nil
+}
+
+// MCSServices implements model.ServiceDiscovery
+func (c *Controller) MCSServices() []model.MCSServiceInfo {
+	return nil
+}
+
+// AppendNetworkGatewayHandler implements model.NetworkGatewaysWatcher
+func (c *Controller) AppendNetworkGatewayHandler(func()) {}
+
+// AddressInformation implements model.AmbientIndexes
+func (c *Controller) AddressInformation(sets.String) ([]model.AddressInfo, sets.String) {
+	return nil, nil
+}
+
+// AdditionalPodSubscriptions implements model.AmbientIndexes
+func (c *Controller) AdditionalPodSubscriptions(*model.Proxy, sets.String, sets.String) sets.String {
+	return nil
+}
+
+// Policies implements model.AmbientIndexes
+func (c *Controller) Policies(sets.Set[model.ConfigKey]) []model.WorkloadAuthorization {
+	return nil
+}
+
+// ServicesForWaypoint implements model.AmbientIndexes
+func (c *Controller) ServicesForWaypoint(model.WaypointKey) []model.ServiceInfo {
+	return nil
+}
+
+// WorkloadsForWaypoint implements model.AmbientIndexes
+func (c *Controller) WorkloadsForWaypoint(model.WaypointKey) []model.WorkloadInfo {
+	return nil
+}
+
+func (c *Controller) String() string {
+	return fmt.Sprintf("%s/%s", c.providerID, c.clusterID)
+}
@@ -0,0 +1,220 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry/provider"
+	"istio.io/istio/pilot/pkg/serviceregistry/util/xdsfake"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+const testProvider provider.ID = "Test"
+
+type fakeRegistry struct {
+	mu        sync.Mutex
+	services  []*model.Service
+	instances []*model.ServiceInstance
+	synced    atomic.Bool
+	notify    chan struct{}
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{notify: make(chan struct{}, 10)}
+}
+
+func (r *fakeRegistry) Services() []*model.Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.services
+}
+
+func (r *fakeRegistry) Instances() []*model.ServiceInstance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.instances
+}
+
+func (r *fakeRegistry) HasSynced() bool {
+	return r.synced.Load()
+}
+
+func (r *fakeRegistry) Run(stop <-chan struct{}, notify func()) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-r.notify:
+			notify()
+		}
+	}
+}
+
+func (r *fakeRegistry) set(services []*model.Service, instances ...*model.ServiceInstance) {
+	r.mu.Lock()
+	r.services, r.instances = services, instances
+	r.mu.Unlock()
+	r.synced.Store(true)
+	r.notify <- struct{}{}
+}
+
+func makeService(hostname string, ports ...int) *model.Service {
+	svc := &model.Service{
+		Hostname:   host.Name(hostname),
+		Resolution: model.ClientSideLB,
+		Attributes: model.ServiceAttributes{Name: hostname, Namespace: "ns"},
+	}
+	for _, p := range ports {
+		svc.Ports = append(svc.Ports, &model.Port{Name: "tcp-" + string(rune('a'+len(svc.Ports))), Port: p, Protocol: protocol.TCP})
+	}
+	return svc
+}
+
+func makeInstance(svc *model.Service, address string) *model.ServiceInstance {
+	port := svc.Ports[0]
+	return &model.ServiceInstance{
+		Service:     svc,
+		ServicePort: port,
+		Endpoint: &model.IstioEndpoint{
+			Address:         address,
+			EndpointPort:    uint32(port.Port) + 1000,
+			ServicePortName: port.Name,
+			Namespace:       svc.Attributes.Namespace,
+		},
+	}
+}
+
+type recordedEvent struct {
+	Hostname string
+	Event    model.Event
+}
+
+func setupController(t test.Failer) (*fakeRegistry, *Controller, *xdsfake.Updater, chan recordedEvent, chan *model.WorkloadInstance) {
+	reg := newFakeRegistry()
+	fx := xdsfake.NewFakeXDS()
+	c := NewController(testProvider, reg, ControllerOptions{ClusterID: "cluster-1", XDSUpdater: fx})
+	svcEvents := make(chan recordedEvent, 10)
+	c.AppendServiceHandler(func(prev, curr *model.Service, event model.Event) {
+		svcEvents <- recordedEvent{Hostname: string(curr.Hostname), Event: event}
+	})
+	wlEvents := make(chan *model.WorkloadInstance, 10)
+	c.AppendWorkloadHandler(func(wi *model.WorkloadInstance, event model.Event) {
+		wlEvents <- wi
+	})
+	stop := test.NewStop(t)
+	go c.Run(stop)
+	return reg, c, fx, svcEvents, wlEvents
+}
+
+func expectServiceEvent(t *testing.T, events chan recordedEvent, want recordedEvent) {
+	t.Helper()
+	select {
+	case got := <-events:
+		assert.Equal(t, got, want)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", want)
+	}
+}
+
+func TestController(t *testing.T) {
+	reg, c, fx, svcEvents, wlEvents := setupController(t)
+	assert.Equal(t, c.HasSynced(), false)
+
+	svc := makeService("a.example.com", 80)
+	reg.set([]*model.Service{svc}, makeInstance(svc, "10.0.0.1"))
+	expectServiceEvent(t, svcEvents, recordedEvent{"a.example.com", model.EventAdd})
+	fx.MatchOrFail(t,
+		xdsfake.Event{Type: "service", ID: "a.example.com", Namespace: "ns"},
+		xdsfake.Event{Type: "eds cache", ID: "a.example.com", Namespace: "ns", EndpointCount: 1},
+	)
+	retry.UntilOrFail(t, c.HasSynced)
+	wi := <-wlEvents
+	assert.Equal(t, wi.Endpoint.Address, "10.0.0.1")
+	assert.Equal(t, wi.PortMap, map[string]uint32{"tcp-a": 1080})
+	assert.Equal(t, wi.Kind, model.PodKind)
+
+	assert.Equal(t, c.GetService("a.example.com").Attributes.ServiceRegistry, testProvider)
+	// The services of the registry are not modified.
+	assert.Equal(t, svc.Attributes.ServiceRegistry, "")
+	assert.Equal(t, len(c.Services()), 1)
+	targets := c.GetProxyServiceTargets(&model.Proxy{IPAddresses: []string{"10.0.0.1"}})
+	assert.Equal(t, len(targets), 1)
+	assert.Equal(t, targets[0].Service.Attributes.ServiceRegistry, testProvider)
+	assert.Equal(t, targets[0].Port.TargetPort, uint32(1080))
+
+	// Only the endpoints change: no service event, incremental EDS update.
+	reg.set([]*model.Service{svc}, makeInstance(svc, "10.0.0.1"), makeInstance(svc, "10.0.0.2"))
+	fx.MatchOrFail(t, xdsfake.Event{Type: "eds", ID: "a.example.com", Namespace: "ns", EndpointCount: 2})
+	assert.Equal(t, (<-wlEvents).Endpoint.Address, "10.0.0.2")
+	assert.Equal(t, len(svcEvents), 0)
+
+	// The service changes
+	updated := makeService("a.example.com", 80, 81)
+	reg.set([]*model.Service{updated})
+	expectServiceEvent(t, svcEvents, recordedEvent{"a.example.com", model.EventUpdate})
+	fx.MatchOrFail(t, xdsfake.Event{Type: "eds cache", ID: "a.example.com", Namespace: "ns", EndpointCount: 0})
+
+	// The service is removed
+	reg.set(nil)
+	expectServiceEvent(t, svcEvents, recordedEvent{"a.example.com", model.EventDelete})
+	assert.Equal(t, c.GetService("a.example.com"), nil)
+	assert.Equal(t, len(c.GetProxyServiceTargets(&model.Proxy{IPAddresses: []string{"10.0.0.1"}})), 0)
+}
+
+func TestControllerUnknownService(t *testing.T) {
+	reg, c, _, svcEvents, _ := setupController(t)
+	svc := makeService("a.example.com", 80)
+	other := makeService("b.example.com", 80)
+	reg.set([]*model.Service{svc}, makeInstance(svc, "10.0.0.1"), makeInstance(other, "10.0.0.2"))
+	expectServiceEvent(t, svcEvents, recordedEvent{"a.example.com", model.EventAdd})
+	assert.Equal(t, len(c.GetProxyServiceTargets(&model.Proxy{IPAddresses: []string{"10.0.0.2"}})), 0)
+}
+
+func TestRegister(t *testing.T) {
+	factory := func(Options) (Registry, error) {
+		return newFakeRegistry(), nil
+	}
+	Register("TestRegister", factory)
+	t.Cleanup(func() {
+		factoriesMu.Lock()
+		defer factoriesMu.Unlock()
+		delete(factories, "TestRegister")
+	})
+	_, ok := Lookup("TestRegister")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, Registered(), []provider.ID{"TestRegister"})
+
+	expectPanic := func(id provider.ID) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected registering %s to panic", id)
+			}
+		}()
+		Register(id, factory)
+	}
+	expectPanic("TestRegister")
+	expectPanic(provider.Kubernetes)
+}
@@ -0,0 +1,312 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file implements a service registry reading services and their endpoints from a YAML file.
+// It is enabled with `--registries=File:<path>`, and reloads the file when it changes.
+package file
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry/plugin"
+	"istio.io/istio/pilot/pkg/serviceregistry/provider"
+	"istio.io/istio/pkg/cluster"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/validation/agent"
+	"istio.io/istio/pkg/filewatcher"
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/network"
+)
+
+// ProviderID is the name of the file registry.
+const ProviderID provider.ID = "File"
+
+var log = istiolog.RegisterScope("fileregistry", "file based service registry")
+
+func init() {
+	plugin.Register(ProviderID, func(opts plugin.Options) (plugin.Registry, error) {
+		if opts.Args == "" {
+			return nil, fmt.Errorf("the path of the registry file is required, with --registries=%s:<path>", ProviderID)
+		}
+		return New(opts.Args, opts.ClusterID, filewatcher.NewWatcher()), nil
+	})
+}
+
+// Config is the content of a registry file.
+type Config struct {
+	Services  []Service  `json:"services,omitempty"`
+	Endpoints []Endpoint `json:"endpoints,omitempty"`
+}
+
+// Service is a service of the registry.
+type Service struct {
+	// Hostname is the fully qualified name of the service.
+	Hostname string `json:"hostname"`
+	// Namespace of the service. Required.
+	Namespace string `json:"namespace"`
+	// Address is the virtual IP of the service. Optional.
+	Address string            `json:"address,omitempty"`
+	Ports   []Port            `json:"ports"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Port is a port of a service.
+type Port struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// Protocol of the port, such as HTTP or TCP. Defaults to TCP.
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// Endpoint is a workload backing a service.
+type Endpoint struct {
+	// Service is the hostname of the service.
+	Service string `json:"service"`
+	// Address is the IP address of the workload.
+	Address string `json:"address"`
+	// Ports maps the service port names to the ports of the workload. Service ports missing from the
+	// map are served on the same port number.
+	Ports          map[string]uint32 `json:"ports,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	ServiceAccount string            `json:"serviceAccount,omitempty"`
+	Network        string            `json:"network,omitempty"`
+	// Locality is the region/zone/subzone of the workload.
+	Locality string `json:"locality,omitempty"`
+	Weight   uint32 `json:"weight,omitempty"`
+	// Name of the workload, defaults to its address.
+	Name string `json:"name,omitempty"`
+}
+
+// Registry is a plugin.Registry reading services from a file.
+type Registry struct {
+	path      string
+	clusterID cluster.ID
+	watcher   filewatcher.FileWatcher
+
+	mu        sync.RWMutex
+	services  []*model.Service
+	instances []*model.ServiceInstance
+	synced    *atomic.Bool
+}
+
+var _ plugin.Registry = &Registry{}
+
+// New creates a registry reading the file at path, and watching it for changes with watcher.
+func New(path string, clusterID cluster.ID, watcher filewatcher.FileWatcher) *Registry {
+	return &Registry{
+		path:      path,
+		clusterID: clusterID,
+		watcher:   watcher,
+		synced:    atomic.NewBool(false),
+	}
+}
+
+// Services implements plugin.Registry
+func (r *Registry) Services() []*model.Service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.services
+}
+
+// Instances implements plugin.Registry
+func (r *Registry) Instances() []*model.ServiceInstance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.instances
+}
+
+// HasSynced implements plugin.Registry. The registry is synced once the file has been read, even
+// if it is invalid.
+func (r *Registry) HasSynced() bool {
+	return r.synced.Load()
+}
+
+// Run implements plugin.Registry
+func (r *Registry) Run(stop <-chan struct{}, notify func()) {
+	if err := r.watcher.Add(r.path); err != nil {
+		log.Errorf("failed to watch %s: %v", r.path, err)
+	}
+	defer r.watcher.Close()
+	r.reload()
+	notify()
+
+	var timerC <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timerC:
+			timerC = nil
+			r.reload()
+			notify()
+		case <-r.watcher.Events(r.path):
+			// Use a timer to debounce updates
+			if timerC == nil {
+				timerC = time.After(100 * time.Millisecond)
+			}
+		case err := <-r.watcher.Errors(r.path):
+			log.Warnf("error watching %s: %v", r.path, err)
+		}
+	}
+}
+
+// reload reads the file. If it is invalid, the previous state is kept.
+func (r *Registry) reload() {
+	defer r.synced.Store(true)
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		log.Errorf("failed to read %s: %v", r.path, err)
+		return
+	}
+	services, instances, err := Parse(data, r.clusterID)
+	if err != nil {
+		log.Errorf("invalid registry file %s: %v", r.path, err)
+		return
+	}
+	r.mu.Lock()
+	r.services, r.instances = services, instances
+	r.mu.Unlock()
+	log.Infof("loaded %d services and %d instances from %s", len(services), len(instances), r.path)
+}
+
+// Parse converts the content of a registry file to services and instances.
+func Parse(data []byte, clusterID cluster.ID) ([]*model.Service, []*model.ServiceInstance, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, nil, err
+	}
+
+	services := make([]*model.Service, 0, len(cfg.Services))
+	byHost := map[string]*model.Service{}
+	creationTime := time.Now()
+	for _, s := range cfg.Services {
+		svc, err := convertService(s, clusterID, creationTime)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, f := byHost[s.Hostname]; f {
+			return nil, nil, fmt.Errorf("duplicate service %s", s.Hostname)
+		}
+		byHost[s.Hostname] = svc
+		services = append(services, svc)
+	}
+
+	var instances []*model.ServiceInstance
+	for _, ep := range cfg.Endpoints {
+		svc, f := byHost[ep.Service]
+		if !f {
+			return nil, nil, fmt.Errorf("endpoint %s: unknown service %q", ep.Address, ep.Service)
+		}
+		if _, err := netip.ParseAddr(ep.Address); err != nil {
+			return nil, nil, fmt.Errorf("endpoint of %s: invalid address %q", ep.Service, ep.Address)
+		}
+		for name := range ep.Ports {
+			if _, f := svc.Ports.Get(name); !f {
+				return nil, nil, fmt.Errorf("endpoint %s: service %s has no port %q", ep.Address, ep.Service, name)
+			}
+		}
+		for _, port := range svc.Ports {
+			instances = append(instances, convertInstance(svc, port, ep, clusterID))
+		}
+	}
+	return services, instances, nil
+}
+
+func convertService(s Service, clusterID cluster.ID, creationTime time.Time) (*model.Service, error) {
+	if err := agent.ValidateFQDN(s.Hostname); err != nil {
+		return nil, fmt.Errorf("service %q: %v", s.Hostname, err)
+	}
+	if s.Namespace == "" {
+		return nil, fmt.Errorf("service %s: namespace is required", s.Hostname)
+	}
+	if len(s.Ports) == 0 {
+		return nil, fmt.Errorf("service %s: at least one port is required", s.Hostname)
+	}
+	address := constants.UnspecifiedIP
+	if s.Address != "" {
+		if _, err := netip.ParseAddr(s.Address); err != nil {
+			return nil, fmt.Errorf("service %s: invalid address %q", s.Hostname, s.Address)
+		}
+		address = s.Address
+	}
+	ports := make(model.PortList, 0, len(s.Ports))
+	for _, p := range s.Ports {
+		if p.Name == "" || p.Port <= 0 || p.Port > 65535 {
+			return nil, fmt.Errorf("service %s: invalid port %q/%d", s.Hostname, p.Name, p.Port)
+		}
+		proto := protocol.TCP
+		if p.Protocol != "" {
+			proto = protocol.Parse(p.Protocol)
+			if proto.IsUnsupported() {
+				return nil, fmt.Errorf("service %s: unsupported protocol %q", s.Hostname, p.Protocol)
+			}
+		}
+		ports = append(ports, &model.Port{Name: p.Name, Port: p.Port, Protocol: proto})
+	}
+	svc := &model.Service{
+		Hostname:       host.Name(s.Hostname),
+		DefaultAddress: address,
+		Ports:          ports,
+		Resolution:     model.ClientSideLB,
+		CreationTime:   creationTime,
+		Attributes: model.ServiceAttributes{
+			ServiceRegistry: ProviderID,
+			Name:            s.Hostname,
+			Namespace:       s.Namespace,
+			Labels:          s.Labels,
+		},
+	}
+	if s.Address != "" {
+		svc.ClusterVIPs.SetAddressesFor(clusterID, []string{s.Address})
+	}
+	return svc, nil
+}
+
+func convertInstance(svc *model.Service, port *model.Port, ep Endpoint, clusterID cluster.ID) *model.ServiceInstance {
+	targetPort := uint32(port.Port)
+	if p, f := ep.Ports[port.Name]; f {
+		targetPort = p
+	}
+	return &model.ServiceInstance{
+		Service:     svc,
+		ServicePort: port,
+		Endpoint: &model.IstioEndpoint{
+			Address:         ep.Address,
+			EndpointPort:    targetPort,
+			ServicePortName: port.Name,
+			Labels:          labels.Instance(ep.Labels),
+			ServiceAccount:  ep.ServiceAccount,
+			Network:         network.ID(ep.Network),
+			Locality: model.Locality{
+				Label:     ep.Locality,
+				ClusterID: clusterID,
+			},
+			LbWeight:     ep.Weight,
+			Namespace:    svc.Attributes.Namespace,
+			WorkloadName: ep.Name,
+			HostName:     ep.Name,
+		},
+	}
+}
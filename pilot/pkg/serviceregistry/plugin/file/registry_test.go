@@ -0,0 +1,146 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/filewatcher"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+const registryFile = `
+services:
+- hostname: db.example.com
+  namespace: data
+  address: 240.0.0.10
+  ports:
+  - name: tcp-db
+    port: 5432
+  - name: http-admin
+    port: 8080
+    protocol: HTTP
+endpoints:
+- service: db.example.com
+  address: 10.0.0.1
+  ports:
+    tcp-db: 15432
+  labels:
+    app: db
+  serviceAccount: spiffe://cluster.local/ns/data/sa/db
+  locality: us-east1/a
+  name: db-0
+`
+
+func TestParse(t *testing.T) {
+	services, instances, err := Parse([]byte(registryFile), "cluster-1")
+	assert.NoError(t, err)
+	assert.Equal(t, len(services), 1)
+	svc := services[0]
+	assert.Equal(t, svc.Hostname, "db.example.com")
+	assert.Equal(t, svc.Attributes.Namespace, "data")
+	assert.Equal(t, svc.Attributes.ServiceRegistry, ProviderID)
+	assert.Equal(t, svc.DefaultAddress, "240.0.0.10")
+	assert.Equal(t, svc.ClusterVIPs.GetAddressesFor("cluster-1"), []string{"240.0.0.10"})
+	assert.Equal(t, svc.Ports[0].Protocol, protocol.TCP)
+	assert.Equal(t, svc.Ports[1].Protocol, protocol.HTTP)
+
+	assert.Equal(t, len(instances), 2)
+	for _, si := range instances {
+		assert.Equal(t, si.Service, svc)
+		assert.Equal(t, si.Endpoint.Address, "10.0.0.1")
+		assert.Equal(t, si.Endpoint.Locality.Label, "us-east1/a")
+		assert.Equal(t, si.Endpoint.Locality.ClusterID, "cluster-1")
+		assert.Equal(t, si.Endpoint.WorkloadName, "db-0")
+	}
+	assert.Equal(t, instances[0].Endpoint.EndpointPort, uint32(15432))
+	assert.Equal(t, instances[1].Endpoint.EndpointPort, uint32(8080))
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"unknown field", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80}], foo: bar}]`},
+		{"invalid hostname", `services: [{hostname: "a..com", namespace: ns, ports: [{name: tcp, port: 80}]}]`},
+		{"no namespace", `services: [{hostname: a.example.com, ports: [{name: tcp, port: 80}]}]`},
+		{"no ports", `services: [{hostname: a.example.com, namespace: ns}]`},
+		{"invalid port", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 0}]}]`},
+		{"invalid protocol", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80, protocol: foo}]}]`},
+		{"invalid address", `services: [{hostname: a.example.com, namespace: ns, address: foo, ports: [{name: tcp, port: 80}]}]`},
+		{"duplicate service", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80}]},
+{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80}]}]`},
+		{"unknown service", `endpoints: [{service: a.example.com, address: 10.0.0.1}]`},
+		{"invalid endpoint address", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80}]}]
+endpoints: [{service: a.example.com, address: foo}]`},
+		{"unknown endpoint port", `services: [{hostname: a.example.com, namespace: ns, ports: [{name: tcp, port: 80}]}]
+endpoints: [{service: a.example.com, address: 10.0.0.1, ports: {http: 8080}}]`},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Parse([]byte(tt.data), "cluster-1")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(registryFile), 0o644))
+
+	r := New(path, "cluster-1", filewatcher.NewWatcher())
+	notified := make(chan struct{}, 10)
+	stop := test.NewStop(t)
+	go r.Run(stop, func() {
+		notified <- struct{}{}
+	})
+	<-notified
+	assert.Equal(t, r.HasSynced(), true)
+	assert.Equal(t, len(r.Services()), 1)
+	assert.Equal(t, len(r.Instances()), 2)
+
+	// An invalid file keeps the previous state
+	assert.NoError(t, os.WriteFile(path, []byte("services: foo"), 0o644))
+	<-notified
+	assert.Equal(t, len(r.Services()), 1)
+
+	updated := registryFile + `
+- service: db.example.com
+  address: 10.0.0.2
+`
+	assert.NoError(t, os.WriteFile(path, []byte(updated), 0o644))
+	retry.UntilOrFail(t, func() bool {
+		return len(r.Instances()) == 4
+	})
+}
+
+func TestRegistryMissingFile(t *testing.T) {
+	r := New(filepath.Join(t.TempDir(), "missing.yaml"), "cluster-1", filewatcher.NewWatcher())
+	notified := make(chan struct{}, 1)
+	stop := test.NewStop(t)
+	go r.Run(stop, func() {
+		notified <- struct{}{}
+	})
+	<-notified
+	// The registry is synced, to not block istiod readiness, and empty.
+	assert.Equal(t, r.HasSynced(), true)
+	assert.Equal(t, len(r.Services()), 0)
+}
@@ -0,0 +1,101 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugin allows service registries which are not built into istiod to be added to the
+// aggregate controller. A registry implements Registry, and registers a Factory under its provider
+// name; it is then enabled by adding the name to the --registries flag of pilot-discovery.
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/serviceregistry/provider"
+	"istio.io/istio/pkg/cluster"
+)
+
+// Registry is a source of services and their instances.
+// The returned services and instances must not be modified afterwards: a change must be reported
+// by returning new objects.
+type Registry interface {
+	// Services returns all the services of the registry.
+	Services() []*model.Service
+
+	// Instances returns the instances of all the services. The Service of each instance must be one
+	// of the services returned by Services.
+	Instances() []*model.ServiceInstance
+
+	// Run watches the registry until stop is closed. notify must be called whenever the services or
+	// instances change, including when the registry becomes synced.
+	Run(stop <-chan struct{}, notify func())
+
+	// HasSynced returns true once the initial state of the registry has been loaded.
+	HasSynced() bool
+}
+
+// Options are passed to a Factory to create a Registry.
+type Options struct {
+	// ClusterID is the cluster istiod runs in.
+	ClusterID cluster.ID
+	// DomainSuffix is the DNS domain suffix of the mesh.
+	DomainSuffix string
+	// Args is the registry specific configuration, set with the `<name>:<args>` form of the registry name.
+	Args string
+}
+
+// Factory creates a Registry.
+type Factory func(opts Options) (Registry, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[provider.ID]Factory{}
+)
+
+// Register makes a registry available under the provider name id. It panics if the name is
+// already registered, or is one of the built-in providers.
+func Register(id provider.ID, factory Factory) {
+	if id == provider.Kubernetes || id == provider.External || id == provider.Mock {
+		panic(fmt.Sprintf("service registry %s is built in", id))
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, f := factories[id]; f {
+		panic(fmt.Sprintf("service registry %s is already registered", id))
+	}
+	factories[id] = factory
+}
+
+// Lookup returns the factory registered under id.
+func Lookup(id provider.ID) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	f, ok := factories[id]
+	return f, ok
+}
+
+// Registered returns the names of all registered registries, sorted.
+func Registered() []provider.ID {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	ids := make([]provider.ID, 0, len(factories))
+	for id := range factories {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
@@ -0,0 +1,195 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"fmt"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/core"
+	"istio.io/istio/pilot/pkg/serviceregistry/aggregate"
+	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
+	"istio.io/istio/pilot/pkg/serviceregistry/serviceentry"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/mesh"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/test"
+)
+
+// ConfigGenOptions are the inputs of a ConfigGen.
+type ConfigGenOptions struct {
+	// Configs is the Istio configuration.
+	Configs []config.Config
+	// KubernetesObjects are the objects of the cluster, such as Services and Pods.
+	KubernetesObjects []runtime.Object
+	// MeshConfig is the mesh configuration. If not set, the default mesh configuration is used.
+	MeshConfig *meshconfig.MeshConfig
+}
+
+// ConfigGen generates the configuration of proxies from static inputs, as Istiod would. Unlike the fake discovery
+// server of the tests, it only runs the config store, the service registries and the config generator, so that
+// tools such as istioctl can simulate calls without shipping the test harnesses.
+type ConfigGen struct {
+	env  *model.Environment
+	gen  *core.ConfigGeneratorImpl
+	stop chan struct{}
+}
+
+var _ ConfigGenerator = &ConfigGen{}
+
+// NewConfigGen loads the inputs and computes the push context. Close must be called once the ConfigGen is no
+// longer used.
+func NewConfigGen(opts ConfigGenOptions) (*ConfigGen, error) {
+	m := opts.MeshConfig
+	if m == nil {
+		m = mesh.DefaultMeshConfig()
+	}
+	env := model.NewEnvironment()
+	env.Watcher = mesh.NewFixedWatcher(m)
+	env.NetworksWatcher = mesh.NewFixedNetworksWatcher(nil)
+	xdsUpdater := model.NewEndpointIndexUpdater(env.EndpointIndex)
+
+	store := memory.NewSyncController(memory.MakeSkipValidation(collections.Pilot))
+	client := kube.NewFakeClient(opts.KubernetesObjects...)
+	registries := aggregate.NewController(aggregate.Options{MeshHolder: env.Watcher})
+	se := serviceentry.NewController(store, xdsUpdater, env.Watcher, serviceentry.WithClusterID(constants.DefaultClusterName))
+	registries.AddRegistry(se)
+	registries.AddRegistry(kubecontroller.NewController(client, kubecontroller.Options{
+		DomainSuffix:          constants.DefaultClusterLocalDomain,
+		ClusterID:             constants.DefaultClusterName,
+		XDSUpdater:            xdsUpdater,
+		Metrics:               env,
+		MeshWatcher:           env.Watcher,
+		MeshNetworksWatcher:   env.NetworksWatcher,
+		MeshServiceController: registries,
+		ConfigCluster:         true,
+	}))
+	env.ServiceDiscovery = registries
+	env.ConfigStore = store
+	env.Init()
+
+	c := &ConfigGen{
+		env:  env,
+		gen:  core.NewConfigGenerator(&model.DisabledCache{}),
+		stop: make(chan struct{}),
+	}
+	go store.Run(c.stop)
+	client.RunAndWait(c.stop)
+	go registries.Run(c.stop)
+	for _, cfg := range opts.Configs {
+		if _, err := store.Create(cfg); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to create %v %v/%v: %v", cfg.GroupVersionKind.Kind, cfg.Namespace, cfg.Name, err)
+		}
+	}
+	kube.WaitForCacheSync("simulation", c.stop, store.HasSynced, registries.HasSynced)
+	se.ResyncEDS()
+
+	if err := env.InitNetworksManager(xdsUpdater); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := env.PushContext().InitContext(env, nil, nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize push context: %v", err)
+	}
+	return c, nil
+}
+
+// Close stops the config store and the service registries.
+func (c *ConfigGen) Close() {
+	close(c.stop)
+}
+
+// SetupProxy initializes a proxy for the current configuration, with the same defaults as the fakes of the tests.
+func (c *ConfigGen) SetupProxy(p *model.Proxy) *model.Proxy {
+	if p.Metadata == nil {
+		p.Metadata = &model.NodeMetadata{}
+	}
+	if p.IstioVersion == nil {
+		p.IstioVersion = model.ParseIstioVersion(p.Metadata.IstioVersion)
+	}
+	if p.Type == "" {
+		p.Type = model.SidecarProxy
+	}
+	if p.ConfigNamespace == "" {
+		p.ConfigNamespace = "default"
+	}
+	if p.Metadata.Namespace == "" {
+		p.Metadata.Namespace = p.ConfigNamespace
+	}
+	if p.ID == "" {
+		p.ID = "app.test"
+	}
+	if p.DNSDomain == "" {
+		p.DNSDomain = p.ConfigNamespace + ".svc." + constants.DefaultClusterLocalDomain
+	}
+	if len(p.IPAddresses) == 0 {
+		p.IPAddresses = []string{"1.1.1.1"}
+	}
+
+	pc := c.env.PushContext()
+	p.SetSidecarScope(pc)
+	p.SetServiceTargets(c.env.ServiceDiscovery)
+	p.SetGatewaysForProxy(pc)
+	p.DiscoverIPMode()
+	return p
+}
+
+func (c *ConfigGen) Listeners(p *model.Proxy) []*listener.Listener {
+	return c.gen.BuildListeners(p, c.env.PushContext())
+}
+
+func (c *ConfigGen) Clusters(p *model.Proxy) []*cluster.Cluster {
+	raw, _ := c.gen.BuildClusters(p, &model.PushRequest{Push: c.env.PushContext()})
+	res := make([]*cluster.Cluster, 0, len(raw))
+	for _, r := range raw {
+		cl := &cluster.Cluster{}
+		_ = r.Resource.UnmarshalTo(cl)
+		res = append(res, cl)
+	}
+	return res
+}
+
+func (c *ConfigGen) RoutesFromListeners(p *model.Proxy, l []*listener.Listener) []*route.RouteConfiguration {
+	resources, _ := c.gen.BuildHTTPRoutes(p, &model.PushRequest{Push: c.env.PushContext()}, core.ExtractRoutesFromListeners(l))
+	out := make([]*route.RouteConfiguration, 0, len(resources))
+	for _, resource := range resources {
+		rc := &route.RouteConfiguration{}
+		_ = resource.Resource.UnmarshalTo(rc)
+		out = append(out, rc)
+	}
+	return out
+}
+
+// Simulate runs the call against the configuration generated for the proxy, which must have been set up with
+// SetupProxy. Configuration the simulation does not support is reported as an error.
+func (c *ConfigGen) Simulate(proxy *model.Proxy, call Call) (result Result, mtls MTLSDecision, err error) {
+	err = test.Wrap(func(t test.Failer) {
+		sim := NewSimulation(t, c, proxy)
+		result = sim.Run(call)
+		mtls = sim.MTLS(result)
+	})
+	return result, mtls, err
+}
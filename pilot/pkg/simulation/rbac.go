@@ -0,0 +1,480 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	envoycore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	rbachttp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	rbactcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/rbac/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	authzmodel "istio.io/istio/pilot/pkg/security/authz/model"
+	"istio.io/istio/pilot/pkg/xds/filters"
+	"istio.io/istio/pkg/maps"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/spiffe"
+	"istio.io/istio/pkg/wellknown"
+)
+
+// RBACAction is the decision of the authorization filters of the proxy for a call.
+type RBACAction string
+
+const (
+	// RBACAllow is set when the call is allowed by the authorization policies.
+	RBACAllow RBACAction = "ALLOW"
+	// RBACDeny is set when the call is denied, either by a DENY policy or because no ALLOW policy matched.
+	RBACDeny RBACAction = "DENY"
+	// RBACCustom is set when the call matched a CUSTOM policy, so the decision is delegated to the external
+	// authorizer. Policies that would apply afterwards are not evaluated.
+	RBACCustom RBACAction = "CUSTOM"
+)
+
+// rbacFilter holds the rules of an HTTP or network RBAC filter.
+type rbacFilter struct {
+	rules                 *rbacpb.RBAC
+	shadowRules           *rbacpb.RBAC
+	shadowRulesStatPrefix string
+}
+
+// rbacFilters returns the RBAC filters applied to a call on the filter chain, in order: the network filters of the
+// chain, then the HTTP filters of the HTTP connection manager, if any.
+func (sim *Simulation) rbacFilters(fc *listener.FilterChain, h *hcm.HttpConnectionManager) []rbacFilter {
+	var res []rbacFilter
+	for _, f := range fc.GetFilters() {
+		if f.Name != wellknown.RoleBasedAccessControl {
+			continue
+		}
+		r := &rbactcp.RBAC{}
+		if err := f.GetTypedConfig().UnmarshalTo(r); err != nil {
+			sim.t.Fatal(err)
+		}
+		res = append(res, rbacFilter{rules: r.Rules, shadowRules: r.ShadowRules, shadowRulesStatPrefix: r.ShadowRulesStatPrefix})
+	}
+	for _, f := range h.GetHttpFilters() {
+		if f.Name != wellknown.HTTPRoleBasedAccessControl {
+			continue
+		}
+		r := &rbachttp.RBAC{}
+		if err := f.GetTypedConfig().UnmarshalTo(r); err != nil {
+			sim.t.Fatal(err)
+		}
+		res = append(res, rbacFilter{rules: r.Rules, shadowRules: r.ShadowRules, shadowRulesStatPrefix: r.ShadowRulesStatPrefix})
+	}
+	return res
+}
+
+// rbacRequest holds the attributes of a call the RBAC filters match on.
+type rbacRequest struct {
+	input Call
+	// authenticated is true if the peer identity of the call is known, from mTLS or an HBONE tunnel.
+	authenticated bool
+	// headers holds the lower cased request headers, including pseudo headers. It is nil for TCP.
+	headers  map[string][]string
+	metadata map[string]*structpb.Struct
+}
+
+// evaluateRBAC returns the decision of the filters for the call, along with the name of the policy that matched.
+// The filters are evaluated in order, as generated: CUSTOM, then DENY, then ALLOW. AUDIT and dry-run policies do not
+// change the decision and are ignored. An empty action is returned if there are no RBAC filters.
+func (sim *Simulation) evaluateRBAC(rbacFilters []rbacFilter, req rbacRequest) (RBACAction, string) {
+	if len(rbacFilters) == 0 {
+		return "", ""
+	}
+	allowedBy := ""
+	for _, f := range rbacFilters {
+		if f.rules == nil {
+			// CUSTOM policies are shadow rules, enforced by the ext_authz filter that follows
+			if f.shadowRulesStatPrefix == authzmodel.RBACExtAuthzShadowRulesStatPrefix {
+				if name := sim.matchRBACPolicies(f.shadowRules, req); name != "" {
+					return RBACCustom, name
+				}
+			}
+			continue
+		}
+		name := sim.matchRBACPolicies(f.rules, req)
+		switch f.rules.Action {
+		case rbacpb.RBAC_ALLOW:
+			if name == "" {
+				return RBACDeny, ""
+			}
+			allowedBy = name
+		case rbacpb.RBAC_DENY:
+			if name != "" {
+				return RBACDeny, name
+			}
+		}
+	}
+	return RBACAllow, allowedBy
+}
+
+// matchRBACPolicies returns the name of the first policy matching the request. Like Envoy, policies are evaluated in
+// the order of their names.
+func (sim *Simulation) matchRBACPolicies(rules *rbacpb.RBAC, req rbacRequest) string {
+	for _, name := range slices.Sort(maps.Keys(rules.GetPolicies())) {
+		p := rules.Policies[name]
+		if p.Condition != nil || p.CheckedCondition != nil {
+			sim.t.Fatalf("unsupported condition in RBAC policy %v", name)
+		}
+		permission := false
+		for _, perm := range p.Permissions {
+			if sim.matchPermission(perm, req) {
+				permission = true
+				break
+			}
+		}
+		if !permission {
+			continue
+		}
+		for _, principal := range p.Principals {
+			if sim.matchPrincipal(principal, req) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func (sim *Simulation) matchPermission(p *rbacpb.Permission, req rbacRequest) bool {
+	switch r := p.Rule.(type) {
+	case *rbacpb.Permission_Any:
+		return r.Any
+	case *rbacpb.Permission_AndRules:
+		for _, sub := range r.AndRules.Rules {
+			if !sim.matchPermission(sub, req) {
+				return false
+			}
+		}
+		return true
+	case *rbacpb.Permission_OrRules:
+		for _, sub := range r.OrRules.Rules {
+			if sim.matchPermission(sub, req) {
+				return true
+			}
+		}
+		return false
+	case *rbacpb.Permission_NotRule:
+		return !sim.matchPermission(r.NotRule, req)
+	case *rbacpb.Permission_Header:
+		return sim.matchHeader(r.Header, req.headers)
+	case *rbacpb.Permission_UrlPath:
+		return req.headers != nil && sim.matchString(r.UrlPath.GetPath(), pathWithoutQuery(req.input.Path))
+	case *rbacpb.Permission_DestinationIp:
+		return matchCidr(r.DestinationIp, req.input.Address)
+	case *rbacpb.Permission_DestinationPort:
+		return int(r.DestinationPort) == req.input.Port
+	case *rbacpb.Permission_DestinationPortRange:
+		return req.input.Port >= int(r.DestinationPortRange.Start) && req.input.Port < int(r.DestinationPortRange.End)
+	case *rbacpb.Permission_RequestedServerName:
+		return sim.matchString(r.RequestedServerName, req.input.Sni)
+	case *rbacpb.Permission_Metadata:
+		return sim.matchMetadata(r.Metadata, req.metadata)
+	default:
+		sim.t.Fatalf("unknown RBAC permission type %T", r)
+	}
+	return false
+}
+
+func (sim *Simulation) matchPrincipal(p *rbacpb.Principal, req rbacRequest) bool {
+	switch id := p.Identifier.(type) {
+	case *rbacpb.Principal_Any:
+		return id.Any
+	case *rbacpb.Principal_AndIds:
+		for _, sub := range id.AndIds.Ids {
+			if !sim.matchPrincipal(sub, req) {
+				return false
+			}
+		}
+		return true
+	case *rbacpb.Principal_OrIds:
+		for _, sub := range id.OrIds.Ids {
+			if sim.matchPrincipal(sub, req) {
+				return true
+			}
+		}
+		return false
+	case *rbacpb.Principal_NotId:
+		return !sim.matchPrincipal(id.NotId, req)
+	case *rbacpb.Principal_Authenticated_:
+		if !req.authenticated {
+			return false
+		}
+		if id.Authenticated.PrincipalName == nil {
+			return true
+		}
+		return sim.matchString(id.Authenticated.PrincipalName, sourcePrincipal(req.input.SourcePrincipal))
+	case *rbacpb.Principal_FilterState:
+		// The peer principal is set in the filter state by waypoints, from the HBONE tunnel.
+		if id.FilterState.Key != "io.istio.peer_principal" || !req.authenticated {
+			return false
+		}
+		return sim.matchString(id.FilterState.GetStringMatch(), sourcePrincipal(req.input.SourcePrincipal))
+	case *rbacpb.Principal_DirectRemoteIp:
+		return matchCidr(id.DirectRemoteIp, req.input.SourceIP)
+	case *rbacpb.Principal_RemoteIp:
+		return matchCidr(id.RemoteIp, req.input.SourceIP)
+	case *rbacpb.Principal_SourceIp:
+		return matchCidr(id.SourceIp, req.input.SourceIP)
+	case *rbacpb.Principal_Header:
+		return sim.matchHeader(id.Header, req.headers)
+	case *rbacpb.Principal_UrlPath:
+		return req.headers != nil && sim.matchString(id.UrlPath.GetPath(), pathWithoutQuery(req.input.Path))
+	case *rbacpb.Principal_Metadata:
+		return sim.matchMetadata(id.Metadata, req.metadata)
+	default:
+		sim.t.Fatalf("unknown RBAC principal type %T", id)
+	}
+	return false
+}
+
+// matchHeader follows the Envoy semantics, where a missing header only matches a present_match: false, and
+// inverted matchers do not match missing headers.
+func (sim *Simulation) matchHeader(h *route.HeaderMatcher, headers map[string][]string) bool {
+	values, present := headers[strings.ToLower(h.Name)]
+	if !present {
+		pm, ok := h.HeaderMatchSpecifier.(*route.HeaderMatcher_PresentMatch)
+		return ok && pm.PresentMatch == h.InvertMatch
+	}
+	value := strings.Join(values, ",")
+	var match bool
+	switch m := h.HeaderMatchSpecifier.(type) {
+	case nil:
+		match = true
+	case *route.HeaderMatcher_PresentMatch:
+		match = m.PresentMatch
+	case *route.HeaderMatcher_StringMatch:
+		match = sim.matchString(m.StringMatch, value)
+	case *route.HeaderMatcher_ExactMatch:
+		match = value == m.ExactMatch
+	case *route.HeaderMatcher_PrefixMatch:
+		match = strings.HasPrefix(value, m.PrefixMatch)
+	case *route.HeaderMatcher_SuffixMatch:
+		match = strings.HasSuffix(value, m.SuffixMatch)
+	case *route.HeaderMatcher_ContainsMatch:
+		match = strings.Contains(value, m.ContainsMatch)
+	case *route.HeaderMatcher_SafeRegexMatch:
+		match = sim.matchRegex(m.SafeRegexMatch.GetRegex(), value)
+	default:
+		sim.t.Fatalf("unknown header matcher type %T", m)
+	}
+	return match != h.InvertMatch
+}
+
+func (sim *Simulation) matchString(m *matcher.StringMatcher, value string) bool {
+	if m.IgnoreCase {
+		value = strings.ToLower(value)
+	}
+	lower := func(s string) string {
+		if m.IgnoreCase {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	switch p := m.MatchPattern.(type) {
+	case *matcher.StringMatcher_Exact:
+		return value == lower(p.Exact)
+	case *matcher.StringMatcher_Prefix:
+		return strings.HasPrefix(value, lower(p.Prefix))
+	case *matcher.StringMatcher_Suffix:
+		return strings.HasSuffix(value, lower(p.Suffix))
+	case *matcher.StringMatcher_Contains:
+		return strings.Contains(value, lower(p.Contains))
+	case *matcher.StringMatcher_SafeRegex:
+		return sim.matchRegex(p.SafeRegex.GetRegex(), value)
+	default:
+		sim.t.Fatalf("unknown string matcher type %T", p)
+	}
+	return false
+}
+
+func (sim *Simulation) matchRegex(expr, value string) bool {
+	// Envoy regexes must match the full value
+	r, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		sim.t.Fatalf("invalid regex %v: %v", expr, err)
+	}
+	return r.MatchString(value)
+}
+
+func (sim *Simulation) matchMetadata(m *matcher.MetadataMatcher, metadata map[string]*structpb.Struct) bool {
+	var value *structpb.Value
+	if s := metadata[m.Filter]; s != nil {
+		value = structpb.NewStructValue(s)
+		for _, p := range m.Path {
+			value = value.GetStructValue().GetFields()[p.GetKey()]
+			if value == nil {
+				break
+			}
+		}
+	}
+	return sim.matchValue(m.Value, value) != m.Invert
+}
+
+func (sim *Simulation) matchValue(m *matcher.ValueMatcher, value *structpb.Value) bool {
+	switch p := m.MatchPattern.(type) {
+	case *matcher.ValueMatcher_NullMatch_:
+		_, ok := value.GetKind().(*structpb.Value_NullValue)
+		return ok
+	case *matcher.ValueMatcher_PresentMatch:
+		return p.PresentMatch && value.GetKind() != nil
+	case *matcher.ValueMatcher_BoolMatch:
+		b, ok := value.GetKind().(*structpb.Value_BoolValue)
+		return ok && b.BoolValue == p.BoolMatch
+	case *matcher.ValueMatcher_StringMatch:
+		s, ok := value.GetKind().(*structpb.Value_StringValue)
+		return ok && sim.matchString(p.StringMatch, s.StringValue)
+	case *matcher.ValueMatcher_ListMatch:
+		for _, v := range value.GetListValue().GetValues() {
+			if sim.matchValue(p.ListMatch.GetOneOf(), v) {
+				return true
+			}
+		}
+		return false
+	case *matcher.ValueMatcher_OrMatch:
+		for _, sub := range p.OrMatch.ValueMatchers {
+			if sim.matchValue(sub, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		sim.t.Fatalf("unknown value matcher type %T", p)
+	}
+	return false
+}
+
+func matchCidr(cidr *envoycore.CidrRange, address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	prefix, err := netip.ParsePrefix(fmt.Sprintf("%s/%d", cidr.AddressPrefix, cidr.GetPrefixLen().GetValue()))
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(addr)
+}
+
+// sourcePrincipal returns the principal as seen by the proxy, as a SPIFFE URI. The principal may be given with or
+// without the spiffe:// prefix.
+func sourcePrincipal(principal string) string {
+	if strings.HasPrefix(principal, spiffe.URIPrefix) {
+		return principal
+	}
+	return spiffe.URIPrefix + principal
+}
+
+func pathWithoutQuery(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// rbacHeaders returns the headers of an HTTP call as seen by the RBAC filters.
+func rbacHeaders(input Call) map[string][]string {
+	res := map[string][]string{}
+	for k, v := range input.Headers {
+		if k == "Host" {
+			continue
+		}
+		res[strings.ToLower(k)] = v
+	}
+	if host := input.Headers["Host"]; len(host) > 0 {
+		res[":authority"] = host
+	}
+	res[":method"] = []string{input.Method}
+	res[":path"] = []string{input.Path}
+	return res
+}
+
+// jwtMetadata returns the dynamic metadata set by the authentication filters for a request with a valid JWT. Both
+// the Istio authn filter metadata and the Envoy JWT payload are set, as either may be used depending on the proxy
+// version.
+func (sim *Simulation) jwtMetadata(claims map[string]any) map[string]*structpb.Struct {
+	if claims == nil {
+		return nil
+	}
+	payload, err := structpb.NewStruct(toStructValues(claims, false).(map[string]any))
+	if err != nil {
+		sim.t.Fatalf("invalid JWT claims: %v", err)
+	}
+	authnClaims, err := structpb.NewStruct(toStructValues(claims, true).(map[string]any))
+	if err != nil {
+		sim.t.Fatalf("invalid JWT claims: %v", err)
+	}
+	claim := func(name string) string {
+		switch v := claims[name].(type) {
+		case string:
+			return v
+		case []string:
+			if len(v) > 0 {
+				return v[0]
+			}
+		case []any:
+			if len(v) > 0 {
+				return fmt.Sprint(v[0])
+			}
+		}
+		return ""
+	}
+	authn := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"request.auth.principal": structpb.NewStringValue(claim("iss") + "/" + claim("sub")),
+		"request.auth.claims":    structpb.NewStructValue(authnClaims),
+	}}
+	if aud := claim("aud"); aud != "" {
+		authn.Fields["request.auth.audiences"] = structpb.NewStringValue(aud)
+	}
+	if azp := claim("azp"); azp != "" {
+		authn.Fields["request.auth.presenter"] = structpb.NewStringValue(azp)
+	}
+	return map[string]*structpb.Struct{
+		filters.AuthnFilterName: authn,
+		filters.EnvoyJwtFilterName: {Fields: map[string]*structpb.Value{
+			filters.EnvoyJwtFilterPayload: structpb.NewStructValue(payload),
+		}},
+	}
+}
+
+// toStructValues converts the claims to values supported by structpb. If asLists is set, scalar claims are converted
+// to single element lists, as done by the Istio authn filter.
+func toStructValues(v any, asLists bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, e := range v {
+			res[k] = toStructValues(e, asLists)
+		}
+		return res
+	case []string:
+		return slices.Map(v, func(s string) any { return s })
+	case []any:
+		return v
+	default:
+		if asLists {
+			return []any{v}
+		}
+		return v
+	}
+}
@@ -20,23 +20,28 @@ import (
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
+	xdscore "github.com/cncf/xds/go/xds/core/v3"
+	xdsmatcher "github.com/cncf/xds/go/xds/type/matcher/v3"
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	envoycore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	network "github.com/envoyproxy/go-control-plane/envoy/extensions/matching/common_inputs/network/v3"
 	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/yl2chen/cidranger"
+	wrappers "google.golang.org/protobuf/types/known/wrapperspb"
 
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/core"
 	xdsfilters "istio.io/istio/pilot/pkg/xds/filters"
-	"istio.io/istio/pilot/test/xds"
 	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/config/host"
 	istiolog "istio.io/istio/pkg/log"
 	"istio.io/istio/pkg/test"
@@ -78,6 +83,8 @@ var (
 	ErrProtocolError = errors.New("protocol error")
 	ErrTLSError      = errors.New("invalid TLS")
 	ErrMTLSError     = errors.New("invalid mTLS")
+	// ErrRBACDenied happens when the call is denied by the authorization policies
+	ErrRBACDenied = errors.New("denied by authorization policy")
 )
 
 type Expect struct {
@@ -97,6 +104,8 @@ var (
 	CallModeOutbound CallMode = "outbound"
 	// CallModeInbound simulate iptables redirect to 15006
 	CallModeInbound CallMode = "inbound"
+	// CallModeWaypoint simulate traffic tunneled to a waypoint, after the HBONE connection is terminated
+	CallModeWaypoint CallMode = "waypoint"
 )
 
 type Call struct {
@@ -123,6 +132,19 @@ type Call struct {
 	CustomListenerValidations []CustomFilterChainValidation
 
 	MtlsSecretConfigName string
+
+	// Method is the HTTP method of the request. Defaults to GET.
+	Method string
+	// SourcePrincipal is the identity of the client, such as "cluster.local/ns/foo/sa/bar", with or without the
+	// spiffe:// prefix. It is only known to the proxy for mTLS calls, and calls tunneled to a waypoint.
+	SourcePrincipal string
+	// SourceNamespace is a convenience field for SourcePrincipal, setting it to the default service account of the
+	// namespace.
+	SourceNamespace string
+	// SourceIP is the address of the client.
+	SourceIP string
+	// JWTClaims are the claims of a valid JWT sent with the request. "iss" and "sub" form the request principal.
+	JWTClaims map[string]any
 }
 
 func (c Call) FillDefaults() Call {
@@ -152,6 +174,12 @@ func (c Call) FillDefaults() Call {
 	if c.TLS == TLS && c.Alpn == "" {
 		c.Alpn = protocolToTLSAlpn(c.Protocol)
 	}
+	if c.Method == "" {
+		c.Method = http.MethodGet
+	}
+	if c.SourcePrincipal == "" && c.SourceNamespace != "" {
+		c.SourcePrincipal = fmt.Sprintf("%s/ns/%s/sa/default", constants.DefaultClusterLocalDomain, c.SourceNamespace)
+	}
 	return c
 }
 
@@ -163,6 +191,11 @@ type Result struct {
 	RouteConfigMatched string
 	VirtualHostMatched string
 	ClusterMatched     string
+	// RBAC is the decision of the authorization policies, if any apply to the matched filter chain.
+	RBAC RBACAction
+	// RBACPolicy is the name of the policy rule that made the decision. It is empty when a call is denied because
+	// no ALLOW policy matched.
+	RBACPolicy string
 	// StrictMatch controls whether we will strictly match the result. If unset, empty fields will
 	// be ignored, allowing testing only fields we care about This allows asserting that the result
 	// is *exactly* equal, allowing asserting a field is empty
@@ -218,6 +251,16 @@ func (r Result) Matches(t *testing.T, want Result) {
 	} else {
 		want.ClusterMatched = r.ClusterMatched
 	}
+	if want.RBAC != "" && want.RBAC != r.RBAC {
+		t.Errorf("want rbac %q got %q", want.RBAC, r.RBAC)
+	} else {
+		want.RBAC = r.RBAC
+	}
+	if want.RBACPolicy != "" && want.RBACPolicy != r.RBACPolicy {
+		t.Errorf("want rbac policy %q got %q", want.RBACPolicy, r.RBACPolicy)
+	} else {
+		want.RBACPolicy = r.RBACPolicy
+	}
 	if t.Failed() {
 		t.Logf("Diff: %+v", diff)
 		t.Logf("Full Diff: %+v", cmp.Diff(want, r, cmpopts.IgnoreUnexported(Result{}), cmpopts.EquateErrors()))
@@ -227,13 +270,27 @@ func (r Result) Matches(t *testing.T, want Result) {
 }
 
 type Simulation struct {
-	t         *testing.T
+	t         test.Failer
 	Listeners []*listener.Listener
 	Clusters  []*cluster.Cluster
 	Routes    []*route.RouteConfiguration
 }
 
-func NewSimulationFromConfigGen(t *testing.T, s *core.ConfigGenTest, proxy *model.Proxy) *Simulation {
+// ConfigGenerator generates the configuration of proxies. It is implemented by ConfigGen, and by the fakes of
+// the tests.
+type ConfigGenerator interface {
+	Listeners(p *model.Proxy) []*listener.Listener
+	Clusters(p *model.Proxy) []*cluster.Cluster
+	RoutesFromListeners(p *model.Proxy, l []*listener.Listener) []*route.RouteConfiguration
+}
+
+func NewSimulationFromConfigGen(t test.Failer, s *core.ConfigGenTest, proxy *model.Proxy) *Simulation {
+	return NewSimulation(t, s, proxy)
+}
+
+// NewSimulation builds a Simulation of the configuration generated for the proxy. Outside of tests, t may be a
+// test.Wrap Failer, so that invalid configuration is reported as an error.
+func NewSimulation(t test.Failer, s ConfigGenerator, proxy *model.Proxy) *Simulation {
 	l := s.Listeners(proxy)
 	sim := &Simulation{
 		t:         t,
@@ -244,10 +301,6 @@ func NewSimulationFromConfigGen(t *testing.T, s *core.ConfigGenTest, proxy *mode
 	return sim
 }
 
-func NewSimulation(t *testing.T, s *xds.FakeDiscoveryServer, proxy *model.Proxy) *Simulation {
-	return NewSimulationFromConfigGen(t, s.ConfigGenTest, proxy)
-}
-
 // withT swaps out the testing struct. This allows executing sub tests.
 func (sim *Simulation) withT(t *testing.T) *Simulation {
 	cpy := *sim
@@ -255,9 +308,15 @@ func (sim *Simulation) withT(t *testing.T) *Simulation {
 	return &cpy
 }
 
+// RunExpectations runs each expectation as a sub test. The Simulation must be built with a *testing.T.
 func (sim *Simulation) RunExpectations(es []Expect) {
+	t, ok := sim.t.(*testing.T)
+	if !ok {
+		sim.t.Fatalf("expectations can only be run in tests, got %T", sim.t)
+		return
+	}
 	for _, e := range es {
-		sim.t.Run(e.Name, func(t *testing.T) {
+		t.Run(e.Name, func(t *testing.T) {
 			sim.withT(t).Run(e.Call).Matches(t, e.Result)
 		})
 	}
@@ -304,7 +363,13 @@ func (sim *Simulation) Run(input Call) (result Result) {
 		}
 	}
 
-	fc, err := sim.matchFilterChain(l.FilterChains, l.DefaultFilterChain, input, hasTLSInspector)
+	var fc *listener.FilterChain
+	var err error
+	if l.FilterChainMatcher != nil {
+		fc, err = sim.matchFilterChainMatcher(l, input, hasTLSInspector)
+	} else {
+		fc, err = sim.matchFilterChain(l.FilterChains, l.DefaultFilterChain, input, hasTLSInspector)
+	}
 	if err != nil {
 		result.Error = err
 		return
@@ -336,6 +401,11 @@ func (sim *Simulation) Run(input Call) (result Result) {
 		}
 	}
 
+	rbac := rbacRequest{
+		input: input,
+		// The peer identity comes from the client certificate, or from the HBONE tunnel for waypoints
+		authenticated: input.TLS == MTLS || input.CallMode == CallModeWaypoint,
+	}
 	if hcm := xdstest.ExtractHTTPConnectionManager(sim.t, fc); hcm != nil {
 		// We matched HCM and didn't terminate TLS, but we are sending TLS traffic - decoding will fail
 		if input.TLS != Plaintext && fc.TransportSocket == nil {
@@ -348,6 +418,15 @@ func (sim *Simulation) Run(input Call) (result Result) {
 			return
 		}
 
+		// Authorization happens before the request is routed
+		rbac.headers = rbacHeaders(input)
+		rbac.metadata = sim.jwtMetadata(input.JWTClaims)
+		result.RBAC, result.RBACPolicy = sim.evaluateRBAC(sim.rbacFilters(fc, hcm), rbac)
+		if result.RBAC == RBACDeny {
+			result.Error = ErrRBACDenied
+			return
+		}
+
 		// Fetch inline route
 		rc := hcm.GetRouteConfig()
 		if rc == nil {
@@ -382,6 +461,11 @@ func (sim *Simulation) Run(input Call) (result Result) {
 			result.ClusterMatched = t.Route.GetCluster()
 		}
 	} else if tcp := xdstest.ExtractTCPProxy(sim.t, fc); tcp != nil {
+		result.RBAC, result.RBACPolicy = sim.evaluateRBAC(sim.rbacFilters(fc, nil), rbac)
+		if result.RBAC == RBACDeny {
+			result.Error = ErrRBACDenied
+			return
+		}
 		result.ClusterMatched = tcp.GetCluster()
 	}
 	return
@@ -409,6 +493,71 @@ func (sim *Simulation) requiresMTLS(fc *listener.FilterChain, mTLSSecretConfigNa
 	return true
 }
 
+// MTLSDecision describes the TLS used on each side of the proxy for a call.
+type MTLSDecision struct {
+	// Downstream is the TLS terminated by the matched filter chain.
+	Downstream TLSMode
+	// Upstream is the TLS originated to the matched cluster.
+	Upstream TLSMode
+	// AutoMTLS is true if Upstream is only used for endpoints with a sidecar, and plaintext otherwise.
+	AutoMTLS bool
+}
+
+// MTLS returns the TLS decision of the proxy for the result of a call, based on the matched filter chain
+// and cluster. Fields for which nothing matched are empty.
+func (sim *Simulation) MTLS(result Result) MTLSDecision {
+	res := MTLSDecision{}
+	if fc := sim.findFilterChain(result.ListenerMatched, result.FilterChainMatched); fc != nil {
+		switch {
+		case fc.TransportSocket == nil:
+			res.Downstream = Plaintext
+		case sim.requiresMTLS(fc, "default"):
+			res.Downstream = MTLS
+		default:
+			res.Downstream = TLS
+		}
+	}
+	if c := xdstest.ExtractClusters(sim.Clusters)[result.ClusterMatched]; c != nil {
+		ts := c.TransportSocket
+		for _, m := range c.TransportSocketMatches {
+			if m.Name == "tlsMode-"+model.IstioMutualTLSModeLabel {
+				ts = m.TransportSocket
+				res.AutoMTLS = true
+			}
+		}
+		res.Upstream = Plaintext
+		if ts != nil {
+			res.Upstream = TLS
+			t := &tls.UpstreamTlsContext{}
+			if err := ts.GetTypedConfig().UnmarshalTo(t); err != nil {
+				sim.t.Fatal(err)
+			}
+			// Same heuristic as requiresMTLS: Istio certificates are served as the default resource
+			if sds := t.GetCommonTlsContext().GetTlsCertificateSdsSecretConfigs(); len(sds) > 0 && sds[0].Name == "default" {
+				res.Upstream = MTLS
+			}
+		}
+	}
+	return res
+}
+
+func (sim *Simulation) findFilterChain(listenerName, filterChainName string) *listener.FilterChain {
+	for _, l := range sim.Listeners {
+		if l.Name != listenerName {
+			continue
+		}
+		if l.DefaultFilterChain != nil && l.DefaultFilterChain.Name == filterChainName {
+			return l.DefaultFilterChain
+		}
+		for _, fc := range l.FilterChains {
+			if fc.Name == filterChainName {
+				return fc
+			}
+		}
+	}
+	return nil
+}
+
 func (sim *Simulation) matchRoute(vh *route.VirtualHost, input Call) *route.Route {
 	for _, r := range vh.Routes {
 		// check path
@@ -583,6 +732,111 @@ func (sim *Simulation) matchFilterChain(chains []*listener.FilterChain, defaultC
 	return chains[0], nil
 }
 
+// matchFilterChainMatcher selects the filter chain with the listener FilterChainMatcher, as done instead of the
+// FilterChainMatch of each chain when it is set.
+func (sim *Simulation) matchFilterChainMatcher(l *listener.Listener, input Call, hasTLSInspector bool) (*listener.FilterChain, error) {
+	name, ok := sim.evaluateMatcher(l.FilterChainMatcher, input, hasTLSInspector)
+	if !ok {
+		if l.DefaultFilterChain != nil {
+			return l.DefaultFilterChain, nil
+		}
+		return nil, ErrNoFilterChain
+	}
+	for _, fc := range l.FilterChains {
+		if fc.Name == name {
+			return fc, nil
+		}
+	}
+	sim.t.Fatalf("matcher selected unknown filter chain %v", name)
+	return nil, nil
+}
+
+// evaluateMatcher returns the name of the filter chain selected by the matcher, if any.
+func (sim *Simulation) evaluateMatcher(m *xdsmatcher.Matcher, input Call, hasTLSInspector bool) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	tree := m.GetMatcherTree()
+	if tree == nil {
+		sim.t.Fatalf("unsupported matcher type %T", m.MatcherType)
+	}
+	value := sim.matcherInput(tree.Input, input, hasTLSInspector)
+	var onMatch *xdsmatcher.Matcher_OnMatch
+	switch t := tree.TreeType.(type) {
+	case *xdsmatcher.Matcher_MatcherTree_ExactMatchMap:
+		onMatch = t.ExactMatchMap.Map[value]
+	case *xdsmatcher.Matcher_MatcherTree_PrefixMatchMap:
+		longest := -1
+		for prefix, om := range t.PrefixMatchMap.Map {
+			if strings.HasPrefix(value, prefix) && len(prefix) > longest {
+				onMatch, longest = om, len(prefix)
+			}
+		}
+	case *xdsmatcher.Matcher_MatcherTree_CustomMatch:
+		ipMatcher := &xdsmatcher.IPMatcher{}
+		if err := t.CustomMatch.GetTypedConfig().UnmarshalTo(ipMatcher); err != nil {
+			sim.t.Fatalf("unsupported custom matcher %v: %v", t.CustomMatch.Name, err)
+		}
+		// The most specific range wins
+		longest := -1
+		for _, rm := range ipMatcher.RangeMatchers {
+			for _, r := range rm.Ranges {
+				cidr := &envoycore.CidrRange{AddressPrefix: r.AddressPrefix, PrefixLen: r.PrefixLen}
+				if matchCidr(cidr, value) && int(r.GetPrefixLen().GetValue()) > longest {
+					onMatch, longest = rm.OnMatch, int(r.GetPrefixLen().GetValue())
+				}
+			}
+		}
+	default:
+		sim.t.Fatalf("unsupported matcher tree type %T", t)
+	}
+	if onMatch == nil {
+		onMatch = m.OnNoMatch
+	}
+	if onMatch == nil {
+		return "", false
+	}
+	if next := onMatch.GetMatcher(); next != nil {
+		return sim.evaluateMatcher(next, input, hasTLSInspector)
+	}
+	name := &wrappers.StringValue{}
+	if err := onMatch.GetAction().GetTypedConfig().UnmarshalTo(name); err != nil {
+		sim.t.Fatalf("unsupported matcher action: %v", err)
+	}
+	return name.Value, true
+}
+
+// matcherInput returns the value of a matcher input for the call.
+func (sim *Simulation) matcherInput(in *xdscore.TypedExtensionConfig, input Call, hasTLSInspector bool) string {
+	m, err := in.GetTypedConfig().UnmarshalNew()
+	if err != nil {
+		sim.t.Fatalf("invalid matcher input %v: %v", in.Name, err)
+	}
+	switch m.(type) {
+	case *network.DestinationIPInput:
+		return input.Address
+	case *network.DestinationPortInput:
+		return strconv.Itoa(input.Port)
+	case *network.SourceIPInput:
+		return input.SourceIP
+	case *network.ServerNameInput:
+		return input.Sni
+	case *network.TransportProtocolInput:
+		if hasTLSInspector && input.TLS != Plaintext {
+			return xdsfilters.TLSTransportProtocol
+		}
+		return xdsfilters.RawBufferTransportProtocol
+	case *network.ApplicationProtocolInput:
+		if input.Alpn == "" {
+			return ""
+		}
+		return "'" + input.Alpn + "'"
+	default:
+		sim.t.Fatalf("unsupported matcher input %T", m)
+	}
+	return ""
+}
+
 func filter(desc string, chains []*listener.FilterChain,
 	empty func(fc *listener.FilterChainMatch) bool,
 	match func(fc *listener.FilterChainMatch) bool,
@@ -657,6 +911,9 @@ func matchListener(listeners []*listener.Listener, input Call) *listener.Listene
 	if input.CallMode == CallModeInbound {
 		return xdstest.ExtractListener(model.VirtualInboundListenerName, listeners)
 	}
+	if input.CallMode == CallModeWaypoint {
+		return xdstest.ExtractListener(core.MainInternalName, listeners)
+	}
 	// First find exact match for the IP/Port, then fallback to wildcard IP/Port
 	// There is no wildcard port
 	for _, l := range listeners {
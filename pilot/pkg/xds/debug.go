@@ -46,6 +46,7 @@ import (
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/resource"
 	"istio.io/istio/pkg/config/xds"
+	"istio.io/istio/pkg/kube/krt"
 	istiolog "istio.io/istio/pkg/log"
 	"istio.io/istio/pkg/security"
 	"istio.io/istio/pkg/util/protomarshal"
@@ -184,6 +185,7 @@ func (s *DiscoveryServer) AddDebugHandlers(mux, internalMux *http.ServeMux, enab
 
 	s.addDebugHandler(mux, internalMux, "/debug/syncz", "Synchronization status of all Envoys connected to this Pilot instance", s.Syncz)
 	s.addDebugHandler(mux, internalMux, "/debug/config_distribution", "Version status of all Envoys connected to this Pilot instance", s.distributedVersions)
+	s.addDebugHandler(mux, internalMux, "/debug/config_history", "Version of a config at a push, with a proof of inclusion", s.configHistory)
 
 	s.addDebugHandler(mux, internalMux, "/debug/registryz", "Debug support for registry", s.registryz)
 	s.addDebugHandler(mux, internalMux, "/debug/endpointz", "Obsolete, use endpointShardz", s.endpointShardz)
@@ -208,6 +210,8 @@ func (s *DiscoveryServer) AddDebugHandlers(mux, internalMux *http.ServeMux, enab
 	s.addDebugHandler(mux, internalMux, "/debug/clusterz", "List remote clusters where istiod reads endpoints", s.clusterz)
 	s.addDebugHandler(mux, internalMux, "/debug/networkz", "List cross-network gateways", s.networkz)
 	s.addDebugHandler(mux, internalMux, "/debug/mcsz", "List information about Kubernetes MCS services", s.mcsz)
+	s.addDebugHandler(mux, internalMux, "/debug/krtz", "Status of the krt collections and their dependencies", s.krtz)
+	s.addDebugHandler(mux, internalMux, "/debug/krtz?format=dot", "Dependency graph of the krt collections, in Graphviz DOT", s.krtz)
 
 	s.addDebugHandler(mux, internalMux, "/debug/list", "List all supported debug commands in json", s.list)
 }
@@ -394,6 +398,42 @@ func (s *DiscoveryServer) distributedVersions(w http.ResponseWriter, req *http.R
 	}
 }
 
+const ConfigHistoryDisabledMessage = "Config history is disabled. It may be enabled by setting the " +
+	"PILOT_ENABLE_CONFIG_HISTORY environment variable to true."
+
+// configHistory returns the version of the config identified by the 'key' parameter, in the <kind>/<namespace>/<name>
+// format, at the push version in the 'version' parameter or at the last push. The response includes the root hash of
+// the config ledger at that push, and a proof of inclusion. Without a key, the retained push versions are returned.
+func (s *DiscoveryServer) configHistory(w http.ResponseWriter, req *http.Request) {
+	if s.ConfigHistory == nil {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = fmt.Fprint(w, ConfigHistoryDisabledMessage)
+		return
+	}
+	versions := s.ConfigHistory.Versions()
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, versions, req)
+		return
+	}
+	version := req.URL.Query().Get("version")
+	if version == "" {
+		if len(versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, "no push has been recorded yet")
+			return
+		}
+		version = versions[len(versions)-1].Version
+	}
+	record, err := s.ConfigHistory.Lookup(version, key)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+	writeJSON(w, record, req)
+}
+
 // VersionLen is the Config Version and is only used as the nonce prefix, but we can reconstruct
 // it because is is a b64 encoding of a 64 bit array, which will always be 12 chars in length.
 // len = ceil(bitlength/(2^6))+1
@@ -1078,6 +1118,20 @@ func (s *DiscoveryServer) networkz(w http.ResponseWriter, req *http.Request) {
 	writeJSON(w, s.Env.NetworkManager.AllGateways(), req)
 }
 
+// krtz returns the krt collections with their size, dependencies and recomputations. With format=dot, their
+// dependency graph is returned in the Graphviz DOT language instead, which can be rendered with `dot -Tsvg`.
+func (s *DiscoveryServer) krtz(w http.ResponseWriter, req *http.Request) {
+	collections := krt.DebugCollections()
+	if req.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if err := krt.WriteDebugGraph(w, collections); err != nil {
+			handleHTTPError(w, err)
+		}
+		return
+	}
+	writeJSON(w, collections, req)
+}
+
 func (s *DiscoveryServer) mcsz(w http.ResponseWriter, req *http.Request) {
 	svcs := sortMCSServices(s.Env.MCSServices())
 	writeJSON(w, svcs, req)
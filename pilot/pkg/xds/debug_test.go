@@ -19,7 +19,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 
@@ -28,6 +30,13 @@ import (
 	"istio.io/istio/pilot/pkg/xds"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	xdsfake "istio.io/istio/pilot/test/xds"
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/ledger"
+	"istio.io/istio/pkg/ptr"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
 )
 
 func TestSyncz(t *testing.T) {
@@ -233,3 +242,91 @@ func TestDebugHandlers(t *testing.T) {
 		t.Errorf("Error in generatating debug endpoint list")
 	}
 }
+
+func TestConfigHistory(t *testing.T) {
+	s := xdsfake.NewFakeDiscoveryServer(t, xdsfake.FakeOptions{})
+	mux := s.Discovery.InitDebug(http.NewServeMux(), false, func() map[string]string { return nil })
+	get := func(path string, wantCode int) []byte {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != wantCode {
+			t.Fatalf("wanted response code %v, got %v: %s", wantCode, rr.Code, rr.Body.String())
+		}
+		return rr.Body.Bytes()
+	}
+	get("/debug/config_history", http.StatusConflict)
+
+	history := ledger.NewHistory(10, time.Hour)
+	s.Discovery.ConfigHistory = history
+	get("/debug/config_history?key=VirtualService/default/a", http.StatusNotFound)
+
+	assert.NoError(t, history.Put("VirtualService/default/a", "1"))
+	s.Discovery.ConfigUpdate(&model.PushRequest{Full: true, Reason: model.NewReasonStats(model.DebugTrigger)})
+	retry.UntilOrFail(t, func() bool {
+		return len(history.Versions()) > 0
+	})
+	pushVersion := history.Versions()[0].Version
+	assert.NoError(t, history.Put("VirtualService/default/a", "2"))
+	history.Commit("later")
+
+	versions := []ledger.Version{}
+	assert.NoError(t, json.Unmarshal(get("/debug/config_history", http.StatusOK), &versions))
+	assert.Equal(t, len(versions) >= 2, true)
+
+	record := &ledger.Record{}
+	assert.NoError(t, json.Unmarshal(get("/debug/config_history?key=VirtualService/default/a&version="+pushVersion, http.StatusOK), record))
+	assert.Equal(t, record.Value, "1")
+	assert.NoError(t, record.Verify())
+
+	// Without a version, the last push is used
+	assert.NoError(t, json.Unmarshal(get("/debug/config_history?key=VirtualService/default/a", http.StatusOK), record))
+	assert.Equal(t, record.Version, "later")
+	assert.Equal(t, record.Value, "2")
+	assert.NoError(t, record.Verify())
+
+	get("/debug/config_history?key=VirtualService/default/a&version=unknown", http.StatusNotFound)
+}
+
+func TestKrtz(t *testing.T) {
+	s := xdsfake.NewFakeDiscoveryServer(t, xdsfake.FakeOptions{})
+	mux := s.Discovery.InitDebug(http.NewServeMux(), false, func() map[string]string { return nil })
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("wanted response code 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	stop := test.NewStop(t)
+	singleton := krt.NewSingleton(func(ctx krt.HandlerContext) *string {
+		return ptr.Of("value")
+	}, krt.WithName("KrtzSingleton"), krt.WithStop(stop))
+	singleton.AsCollection().Synced().WaitUntilSynced(stop)
+
+	collections := []krt.DebugCollection{}
+	assert.NoError(t, json.Unmarshal(get("/debug/krtz").Body.Bytes(), &collections))
+	found := slices.FindFunc(collections, func(c krt.DebugCollection) bool { return c.Name == "KrtzSingleton" })
+	if found == nil {
+		t.Fatalf("collection not found in %+v", collections)
+	}
+	assert.Equal(t, found.Size, 1)
+	assert.Equal(t, found.Recomputes, uint64(1))
+
+	rr := get("/debug/krtz?format=dot")
+	assert.Equal(t, rr.Header().Get("Content-Type"), "text/vnd.graphviz")
+	if !strings.Contains(rr.Body.String(), `KrtzSingleton\ncollection\nsize=1`) {
+		t.Fatalf("unexpected graph:\n%s", rr.Body.String())
+	}
+}
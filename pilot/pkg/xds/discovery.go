@@ -34,6 +34,7 @@ import (
 	"istio.io/istio/pilot/pkg/networking/core/envoyfilter"
 	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/config/schema/kind"
+	"istio.io/istio/pkg/ledger"
 	"istio.io/istio/pkg/maps"
 	"istio.io/istio/pkg/security"
 )
@@ -104,6 +105,9 @@ type DiscoveryServer struct {
 
 	StatusReporter DistributionStatusCache
 
+	// ConfigHistory, if set, records the root hash of the config ledger at each push version.
+	ConfigHistory *ledger.History
+
 	// Authenticators for XDS requests. Should be same/subset of the CA authenticators.
 	Authenticators []security.Authenticator
 
@@ -501,6 +505,11 @@ func (s *DiscoveryServer) initPushContext(req *model.PushRequest, oldPushContext
 	push := model.NewPushContext()
 	push.PushVersion = version
 	push.JwtKeyResolver = s.JwtKeyResolver
+	// The version holds the configs seen when the push context is built, not the ones changed since.
+	var history ledger.Snapshot
+	if s.ConfigHistory != nil {
+		history = s.ConfigHistory.Snapshot()
+	}
 	if err := push.InitContext(s.Env, oldPushContext, req); err != nil {
 		log.Errorf("XDS: failed to init push context: %v", err)
 		// We can't push if we can't read the data - stick with previous version.
@@ -510,6 +519,9 @@ func (s *DiscoveryServer) initPushContext(req *model.PushRequest, oldPushContext
 
 	s.dropCacheForRequest(req)
 	s.Env.SetPushContext(push)
+	if s.ConfigHistory != nil {
+		s.ConfigHistory.CommitSnapshot(version, history)
+	}
 
 	return push, nil
 }
@@ -32,6 +32,7 @@ import (
 )
 
 func TestNDS(t *testing.T) {
+	httpPort := []*dnsProto.NameTable_Port{{Name: "http", Port: 80, Protocol: "tcp"}}
 	cases := []struct {
 		name     string
 		meta     model.NodeMetadata
@@ -48,14 +49,17 @@ func TestNDS(t *testing.T) {
 					"random-1.host.example": {
 						Ips:      []string{"240.240.116.21"},
 						Registry: "External",
+						Ports:    httpPort,
 					},
 					"random-2.host.example": {
 						Ips:      []string{"9.9.9.9"},
 						Registry: "External",
+						Ports:    httpPort,
 					},
 					"random-3.host.example": {
 						Ips:      []string{"240.240.81.100"},
 						Registry: "External",
+						Ports:    httpPort,
 					},
 				},
 			},
@@ -70,6 +74,7 @@ func TestNDS(t *testing.T) {
 					"random-2.host.example": {
 						Ips:      []string{"9.9.9.9"},
 						Registry: "External",
+						Ports:    httpPort,
 					},
 				},
 			},
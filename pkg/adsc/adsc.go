@@ -123,6 +123,9 @@ type Config struct {
 	BackoffPolicy backoff.BackOff
 
 	GrpcOpts []grpc.DialOption
+
+	// Recorder, if set, records all the requests and responses of the XDS streams.
+	Recorder *Recorder
 }
 
 // ADSConfig for the ADS connection.
@@ -159,6 +162,8 @@ type ADSC struct {
 
 	// Indicates if the ADSC client is closed
 	closed bool
+	// done is closed when the client is closed.
+	done chan struct{}
 
 	// NodeID is the node identity sent to Pilot.
 	nodeID string
@@ -218,6 +223,15 @@ type ADSC struct {
 	// restarts.
 	LocalCacheDir string
 
+	// SnapshotFile, if set, is rewritten with all the configs in Store after config responses received
+	// once the client is synced. It is written in the background, at most once per second, so the
+	// changes of the last second before the client is closed may be lost. LoadSnapshot can be used to
+	// start from it after a restart, when the XDS server is not reachable.
+	SnapshotFile string
+	// snapshotDirty is notified when the snapshot should be rewritten.
+	snapshotDirty chan struct{}
+	snapshotOnce  sync.Once
+
 	cfg *ADSConfig
 
 	// sendNodeMeta is set to true if the connection is new - and we need to send node meta.,
@@ -279,6 +293,7 @@ func New(discoveryAddr string, opts *ADSConfig) (*ADSC, error) {
 		cfg:         opts,
 		sync:        map[string]time.Time{},
 		errChan:     make(chan error, 10),
+		done:        make(chan struct{}),
 	}
 
 	adsc.Metadata = opts.Meta
@@ -349,6 +364,9 @@ func dialWithConfig(config *Config) (*grpc.ClientConn, error) {
 		// Only disable transport security if the user didn't supply custom dial options
 		grpcDialOptions = append(grpcDialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
+	if config.Recorder != nil {
+		grpcDialOptions = append(grpcDialOptions, config.Recorder.DialOption())
+	}
 
 	conn, err := grpc.Dial(config.Address, grpcDialOptions...)
 	if err != nil {
@@ -412,6 +430,9 @@ func tlsConfig(config *Config) (*tls.Config, error) {
 func (a *ADSC) Close() {
 	a.mutex.Lock()
 	_ = a.conn.Close()
+	if !a.closed && a.done != nil {
+		close(a.done)
+	}
 	a.closed = true
 	a.mutex.Unlock()
 }
@@ -480,6 +501,17 @@ func (a *ADSC) reconnect() {
 	}
 }
 
+// RunOrReconnect runs the client like Run. If the stream can not be established, it keeps retrying in
+// the background using the backoff policy, and returns the error of the first attempt.
+func (a *ADSC) RunOrReconnect() error {
+	err := a.Run()
+	if err != nil {
+		adscLog.Warnf("Failed to connect to %s, will retry: %v", a.cfg.Address, err)
+		time.AfterFunc(a.cfg.BackoffPolicy.NextBackOff(), a.reconnect)
+	}
+	return err
+}
+
 func (a *ADSC) handleRecv() {
 	// We connected, so reset the backoff
 	if a.cfg.BackoffPolicy != nil {
@@ -597,6 +629,10 @@ func (a *ADSC) handleRecv() {
 		a.ack(msg)
 		a.mutex.Unlock()
 
+		if isMCP && a.SnapshotFile != "" && a.Store != nil && a.HasSynced() {
+			a.markSnapshotDirty()
+		}
+
 		select {
 		case a.XDSUpdates <- msg:
 		default:
@@ -210,6 +210,11 @@ type Client struct {
 
 	// closed is set to true when the client is closed
 	closed bool
+
+	// connectHandlers are called each time a new stream is established, before the initial watches are sent.
+	connectHandlers []func()
+	// responseHandlers are called after each response was handled.
+	responseHandlers []func(typeURL string)
 }
 
 func (c *Client) trigger(ctx *handlerContext, typeURL string, r *discovery.Resource, event Event) error {
@@ -270,6 +275,9 @@ func (c *Client) Run(ctx context.Context) error {
 	}
 	c.sendNodeMeta.Store(true)
 	c.xdsClient = xdsClient
+	for _, h := range c.connectHandlers {
+		h()
+	}
 	go c.handleRecv()
 	for _, w := range c.initialWatches {
 		c.request(w)
@@ -288,22 +296,37 @@ func (c *Client) Dial() error {
 
 // reconnect will create a new stream
 func (c *Client) reconnect() {
-	c.mutex.RLock()
+	c.mutex.Lock()
 	if c.closed {
-		c.mutex.RUnlock()
+		c.mutex.Unlock()
 		return
 	}
-	c.mutex.RUnlock()
+	if c.conn != nil {
+		// The previous stream is done, release its connection
+		c.conn.Close()
+	}
+	c.mutex.Unlock()
 
 	err := c.Run(context.Background())
 	if err != nil {
-		time.AfterFunc(c.cfg.BackoffPolicy.NextBackOff(), c.reconnect)
+		c.scheduleReconnect()
 	} else if c.cfg.BackoffPolicy != nil {
 		// We connected, so reset the backoff
+		c.mutex.Lock()
 		c.cfg.BackoffPolicy.Reset()
+		c.mutex.Unlock()
 	}
 }
 
+// scheduleReconnect runs reconnect after the next backoff. The new stream may fail before reconnect resets the
+// backoff, so the policy is accessed with the mutex held.
+func (c *Client) scheduleReconnect() {
+	c.mutex.Lock()
+	next := c.cfg.BackoffPolicy.NextBackOff()
+	c.mutex.Unlock()
+	time.AfterFunc(next, c.reconnect)
+}
+
 type Option func(c *Client)
 
 func NewDelta(discoveryAddr string, config *DeltaADSConfig, opts ...Option) *Client {
@@ -360,6 +383,21 @@ func Watch[T proto.Message](resourceName string) Option {
 	return initWatch(typeName[T](), resourceName)
 }
 
+// OnConnect registers a function called each time a new stream is established, including reconnections.
+// The server sends the full state of the watched resources again on a new stream.
+func OnConnect(f func()) Option {
+	return func(c *Client) {
+		c.connectHandlers = append(c.connectHandlers, f)
+	}
+}
+
+// OnResponse registers a function called after the handlers of each response ran.
+func OnResponse(f func(typeURL string)) Option {
+	return func(c *Client) {
+		c.responseHandlers = append(c.responseHandlers, f)
+	}
+}
+
 func initWatch(typeURL string, resourceName string) Option {
 	return func(c *Client) {
 		if resourceName == "*" {
@@ -397,7 +435,7 @@ func (c *Client) handleRecv() {
 			}
 			// if 'reconnect' enabled - schedule a new Run
 			if c.cfg.BackoffPolicy != nil {
-				time.AfterFunc(c.cfg.BackoffPolicy.NextBackOff(), c.reconnect)
+				c.scheduleReconnect()
 			} else {
 				c.Close()
 			}
@@ -409,6 +447,9 @@ func (c *Client) handleRecv() {
 			c.Close()
 			return
 		}
+		for _, h := range c.responseHandlers {
+			h(msg.TypeUrl)
+		}
 		c.mutex.Lock()
 		c.lastReceived[msg.TypeUrl] = msg
 		c.mutex.Unlock()
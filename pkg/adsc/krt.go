@@ -0,0 +1,117 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/maps"
+)
+
+// TypedResource is a resource received from the XDS server, as stored in a collection built by NewCollection.
+type TypedResource[T proto.Message] struct {
+	Name    string
+	Version string
+	Entity  T
+}
+
+func (r TypedResource[T]) ResourceName() string {
+	return r.Name
+}
+
+func (r TypedResource[T]) Equals(other TypedResource[T]) bool {
+	return r.Name == other.Name && r.Version == other.Version && proto.Equal(r.Entity, other.Entity)
+}
+
+// xdsCollection feeds a krt collection from the responses of a delta client.
+type xdsCollection[T proto.Message] struct {
+	typeURL    string
+	collection krt.ExternalCollection[TypedResource[T]]
+
+	mu sync.Mutex
+	// resync is true from the time a stream is established until the first response for the type is handled.
+	// Meanwhile, the resources received are buffered in pending, and replace the content of the collection at once.
+	resync  bool
+	pending map[string]TypedResource[T]
+}
+
+// NewCollection returns a krt collection holding the resources of type T received by a delta client, and the Option
+// to pass to NewDelta to feed it. The Option registers the handler of the type and watches resourceName ("*" for
+// all resources), so no other handler should be registered for the type.
+//
+// The collection is synced once the first response for the type was handled. Each time the client reconnects, the
+// server sends the full state of the watched resources again; once the first response of the new stream is handled,
+// resources that were not sent again are deleted from the collection, and only the differences are sent as events.
+// Types that are not watched directly, but as dependencies of other resources, may not be complete in their first
+// response, and so should not rely on the collection being synced.
+func NewCollection[T proto.Message](resourceName string, opts ...krt.CollectionOption) (krt.Collection[TypedResource[T]], Option) {
+	typeURL := typeName[T]()
+	opts = append([]krt.CollectionOption{krt.WithName(fmt.Sprintf("XDS[%v]", typeURL))}, opts...)
+	x := &xdsCollection[T]{
+		typeURL:    typeURL,
+		collection: krt.NewExternalCollection[TypedResource[T]](opts...),
+	}
+	handler := Register(func(ctx HandlerContext, name string, version string, entity T, event Event) {
+		x.handle(name, version, entity, event)
+	})
+	options := []Option{handler, Watch[T](resourceName), OnConnect(x.connected), OnResponse(x.responded)}
+	return x.collection, func(c *Client) {
+		for _, o := range options {
+			o(c)
+		}
+	}
+}
+
+func (x *xdsCollection[T]) connected() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.resync = true
+	x.pending = map[string]TypedResource[T]{}
+}
+
+func (x *xdsCollection[T]) handle(name, version string, entity T, event Event) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	res := TypedResource[T]{Name: name, Version: version, Entity: entity}
+	switch {
+	case x.resync && event == EventDelete:
+		delete(x.pending, name)
+	case x.resync:
+		x.pending[name] = res
+	case event == EventDelete:
+		x.collection.DeleteObject(krt.Key[TypedResource[T]](name))
+	default:
+		x.collection.UpdateObject(res)
+	}
+}
+
+func (x *xdsCollection[T]) responded(typeURL string) {
+	if typeURL != x.typeURL {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.resync {
+		return
+	}
+	x.collection.Reset(maps.Values(x.pending))
+	x.collection.MarkSynced()
+	x.resync = false
+	x.pending = nil
+}
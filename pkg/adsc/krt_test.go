@@ -0,0 +1,130 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"istio.io/istio/pilot/pkg/util/protoconv"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/backoff"
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func clusterResponse(removed []string, clusters ...*cluster.Cluster) *discovery.DeltaDiscoveryResponse {
+	resp := &discovery.DeltaDiscoveryResponse{
+		TypeUrl:          v3.ClusterType,
+		RemovedResources: removed,
+	}
+	for _, c := range clusters {
+		resp.Resources = append(resp.Resources, &discovery.Resource{
+			Name:     c.Name,
+			Version:  c.ConnectTimeout.AsDuration().String(),
+			Resource: protoconv.MessageToAny(c),
+		})
+	}
+	return resp
+}
+
+func timeoutCluster(name string, timeout time.Duration) *cluster.Cluster {
+	return &cluster.Cluster{Name: name, ConnectTimeout: durationpb.New(timeout)}
+}
+
+func TestCollection(t *testing.T) {
+	stop := test.NewStop(t)
+	// Each step sends a response on the current stream; a nil response closes the stream.
+	steps := make(chan *discovery.DeltaDiscoveryResponse)
+	connections := atomic.NewInt32(0)
+	deltaHandler = func(delta discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+		connections.Inc()
+		for {
+			select {
+			case <-delta.Context().Done():
+				return nil
+			case resp := <-steps:
+				if resp == nil {
+					return fmt.Errorf("closed")
+				}
+				if err := delta.Send(resp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	xds := grpc.NewServer()
+	discovery.RegisterAggregatedDiscoveryServiceServer(xds, new(mockDeltaXdsServer))
+	go func() {
+		_ = xds.Serve(l)
+	}()
+	t.Cleanup(xds.Stop)
+
+	clusters, opt := NewCollection[*cluster.Cluster]("*", krt.WithStop(stop))
+	client := NewDeltaWithBackoffPolicy(l.Addr().String(), &DeltaADSConfig{},
+		backoff.NewExponentialBackOff(backoff.Option{InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond}), opt)
+	assert.NoError(t, client.Run(context.Background()))
+	t.Cleanup(client.Close)
+
+	tt := assert.NewTracker[string](t)
+	clusters.Register(func(o krt.Event[TypedResource[*cluster.Cluster]]) {
+		tt.Record(fmt.Sprintf("%v/%v", o.Event, o.Latest().Name))
+	})
+	list := func() []string {
+		return slices.Sort(slices.Map(clusters.List(), func(c TypedResource[*cluster.Cluster]) string {
+			return c.Name + "=" + c.Entity.ConnectTimeout.AsDuration().String()
+		}))
+	}
+
+	assert.Equal(t, clusters.Synced().HasSynced(), false)
+	steps <- clusterResponse(nil, timeoutCluster("a", time.Second), timeoutCluster("b", time.Second))
+	clusters.Synced().WaitUntilSynced(stop)
+	tt.WaitUnordered("add/a", "add/b")
+	assert.Equal(t, list(), []string{"a=1s", "b=1s"})
+
+	steps <- clusterResponse(nil, timeoutCluster("a", 2*time.Second))
+	tt.WaitOrdered("update/a")
+	steps <- clusterResponse([]string{"a"})
+	tt.WaitOrdered("delete/a")
+	assert.Equal(t, list(), []string{"b=1s"})
+
+	// On reconnection, the full state is sent again. Resources missing from it are removed, and unchanged
+	// resources do not trigger events.
+	steps <- nil
+	assert.EventuallyEqual(t, connections.Load, int32(2))
+	steps <- clusterResponse(nil, timeoutCluster("c", time.Second))
+	tt.WaitUnordered("add/c", "delete/b")
+	assert.Equal(t, list(), []string{"c=1s"})
+
+	steps <- nil
+	assert.EventuallyEqual(t, connections.Load, int32(3))
+	steps <- clusterResponse(nil, timeoutCluster("c", time.Second), timeoutCluster("d", time.Second))
+	tt.WaitOrdered("add/d")
+	tt.Empty()
+	assert.Equal(t, list(), []string{"c=1s", "d=1s"})
+}
@@ -0,0 +1,405 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/util/sets"
+)
+
+var recordLog = log.RegisterScope("adscrecord", "adsc record and replay")
+
+// Direction is the direction of a recorded message.
+type Direction string
+
+const (
+	// DirectionRequest is a message sent by the client.
+	DirectionRequest Direction = "request"
+	// DirectionResponse is a message sent by the server.
+	DirectionResponse Direction = "response"
+)
+
+// Record is a single message of a recorded XDS session.
+// A recording is a file with one JSON encoded Record per line, in the order messages were sent and received.
+type Record struct {
+	// Time the message was sent or received by the client.
+	Time time.Time `json:"time"`
+	// Stream identifies the stream of the message. Streams are numbered from 1, in the order they were opened,
+	// so reconnections show up as new streams.
+	Stream uint64 `json:"stream"`
+	// Delta is true for messages of a delta stream, and false for state of the world streams.
+	Delta     bool      `json:"delta,omitempty"`
+	Direction Direction `json:"direction"`
+	// TypeURL and Nonce are copied from the message, for readability.
+	TypeURL string `json:"typeUrl"`
+	Nonce   string `json:"nonce,omitempty"`
+	// Message is the serialized DiscoveryRequest, DiscoveryResponse, DeltaDiscoveryRequest or DeltaDiscoveryResponse.
+	Message []byte `json:"message"`
+}
+
+// Decode returns the recorded message.
+func (r Record) Decode() (proto.Message, error) {
+	var m proto.Message
+	switch {
+	case r.Delta && r.Direction == DirectionRequest:
+		m = &discovery.DeltaDiscoveryRequest{}
+	case r.Delta && r.Direction == DirectionResponse:
+		m = &discovery.DeltaDiscoveryResponse{}
+	case r.Direction == DirectionRequest:
+		m = &discovery.DiscoveryRequest{}
+	case r.Direction == DirectionResponse:
+		m = &discovery.DiscoveryResponse{}
+	default:
+		return nil, fmt.Errorf("unknown direction %q", r.Direction)
+	}
+	if err := proto.Unmarshal(r.Message, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadRecording reads the records written by a Recorder.
+func ReadRecording(r io.Reader) ([]Record, error) {
+	var res []Record
+	dec := json.NewDecoder(r)
+	for {
+		rec := Record{}
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return res, nil
+			}
+			return nil, fmt.Errorf("record %d: %v", len(res)+1, err)
+		}
+		res = append(res, rec)
+	}
+}
+
+// Recorder records the XDS sessions of a client, both state of the world and delta, including all the requests and
+// responses in order with their nonces and timing. Unlike Save, which only keeps the last known state, a recording
+// can be served back with a ReplayServer to reproduce the exact sequence of pushes.
+//
+// A Recorder is set with Config.Recorder, or with the DialOption of any other gRPC client.
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	streams uint64
+	err     error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// DialOption returns the option recording the XDS streams of a connection. Other calls are not recorded.
+func (r *Recorder) DialOption() grpc.DialOption {
+	return grpc.WithChainStreamInterceptor(r.intercept)
+}
+
+// Err returns the first error writing the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) intercept(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	var delta bool
+	switch method {
+	case discovery.AggregatedDiscoveryService_StreamAggregatedResources_FullMethodName:
+	case discovery.AggregatedDiscoveryService_DeltaAggregatedResources_FullMethodName:
+		delta = true
+	default:
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.streams++
+	id := r.streams
+	r.mu.Unlock()
+	return &recordedStream{ClientStream: cs, recorder: r, stream: id, delta: delta}, nil
+}
+
+func (r *Recorder) record(stream uint64, delta bool, direction Direction, m any) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	rec := Record{
+		Time:      time.Now(),
+		Stream:    stream,
+		Delta:     delta,
+		Direction: direction,
+	}
+	switch msg := msg.(type) {
+	case *discovery.DiscoveryRequest:
+		rec.TypeURL, rec.Nonce = msg.TypeUrl, msg.ResponseNonce
+	case *discovery.DiscoveryResponse:
+		rec.TypeURL, rec.Nonce = msg.TypeUrl, msg.Nonce
+	case *discovery.DeltaDiscoveryRequest:
+		rec.TypeURL, rec.Nonce = msg.TypeUrl, msg.ResponseNonce
+	case *discovery.DeltaDiscoveryResponse:
+		rec.TypeURL, rec.Nonce = msg.TypeUrl, msg.Nonce
+	}
+	b, err := proto.Marshal(msg)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		rec.Message = b
+		err = r.enc.Encode(rec)
+	}
+	if err != nil && r.err == nil {
+		recordLog.Warnf("failed to record message of stream %d: %v", stream, err)
+		r.err = err
+	}
+}
+
+// recordedStream records the messages of a client stream.
+type recordedStream struct {
+	grpc.ClientStream
+	recorder *Recorder
+	stream   uint64
+	delta    bool
+}
+
+func (s *recordedStream) SendMsg(m any) error {
+	// Requests are recorded before they are sent, so they are always ahead of the responses they triggered.
+	s.recorder.record(s.stream, s.delta, DirectionRequest, m)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordedStream) RecvMsg(m any) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.recorder.record(s.stream, s.delta, DirectionResponse, m)
+	return nil
+}
+
+// ReplayServer serves recorded XDS sessions back to a client, such as Envoy or a gRPC client.
+//
+// Each stream opened to the server replays the next recorded stream of the same kind (state of the world or
+// delta): the first stream replays the first recorded stream, and a reconnection replays the following one. The
+// recorded responses are sent in order, with their original nonces, versions and resources. A response is only
+// sent once the client requested its type, and sent the request recorded just before it, with the same type and
+// response nonce, so the client acknowledged the previous responses as it did when it was recorded. Streams opened
+// once all the recorded ones were replayed fail with Unavailable.
+type ReplayServer struct {
+	// Realtime preserves the delays between the recorded responses. Otherwise, they are sent as fast as possible.
+	Realtime bool
+
+	mu    sync.Mutex
+	sotw  [][]Record
+	delta [][]Record
+}
+
+var _ discovery.AggregatedDiscoveryServiceServer = &ReplayServer{}
+
+// NewReplayServer returns a server replaying the records, as read by ReadRecording. Recorded streams without any
+// response are skipped.
+func NewReplayServer(records []Record) *ReplayServer {
+	s := &ReplayServer{}
+	streams := map[uint64][]Record{}
+	responses := sets.New[uint64]()
+	var order []uint64
+	for _, r := range records {
+		if _, f := streams[r.Stream]; !f {
+			order = append(order, r.Stream)
+		}
+		streams[r.Stream] = append(streams[r.Stream], r)
+		if r.Direction == DirectionResponse {
+			responses.Insert(r.Stream)
+		}
+	}
+	for _, id := range order {
+		if !responses.Contains(id) {
+			continue
+		}
+		if streams[id][0].Delta {
+			s.delta = append(s.delta, streams[id])
+		} else {
+			s.sotw = append(s.sotw, streams[id])
+		}
+	}
+	return s
+}
+
+// Register registers the server on a gRPC server.
+func (s *ReplayServer) Register(server *grpc.Server) {
+	discovery.RegisterAggregatedDiscoveryServiceServer(server, s)
+}
+
+func (s *ReplayServer) next(delta bool) ([]Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	streams := &s.sotw
+	if delta {
+		streams = &s.delta
+	}
+	if len(*streams) == 0 {
+		return nil, false
+	}
+	res := (*streams)[0]
+	*streams = (*streams)[1:]
+	return res, true
+}
+
+func (s *ReplayServer) StreamAggregatedResources(stream discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	records, ok := s.next(false)
+	if !ok {
+		return status.Error(codes.Unavailable, "no more recorded streams")
+	}
+	return s.replay(stream.Context(), records, func() (replayRequest, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return replayRequest{}, err
+		}
+		return replayRequest{typeURL: req.TypeUrl, nonce: req.ResponseNonce}, nil
+	}, func(m proto.Message) error {
+		return stream.Send(m.(*discovery.DiscoveryResponse))
+	})
+}
+
+func (s *ReplayServer) DeltaAggregatedResources(stream discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	records, ok := s.next(true)
+	if !ok {
+		return status.Error(codes.Unavailable, "no more recorded streams")
+	}
+	return s.replay(stream.Context(), records, func() (replayRequest, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return replayRequest{}, err
+		}
+		return replayRequest{typeURL: req.TypeUrl, nonce: req.ResponseNonce}, nil
+	}, func(m proto.Message) error {
+		return stream.Send(m.(*discovery.DeltaDiscoveryResponse))
+	})
+}
+
+// replayRequest identifies a request of the client by its type and the nonce of the response it acknowledges.
+type replayRequest struct {
+	typeURL string
+	nonce   string
+}
+
+// replay sends the recorded responses of a stream. recv returns the next request of the client.
+func (s *ReplayServer) replay(ctx context.Context, records []Record, recv func() (replayRequest, error), send func(proto.Message) error) error {
+	requests := make(chan replayRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	subscribed := sets.New[string]()
+	received := sets.New[replayRequest]()
+	// wait processes the requests of the client until the condition is met.
+	wait := func(cond func() bool, timer <-chan time.Time) error {
+		for !cond() {
+			select {
+			case req := <-requests:
+				subscribed.Insert(req.typeURL)
+				received.Insert(req)
+			case <-timer:
+				return nil
+			case err := <-recvErr:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	start := time.Now()
+	var first *Record
+	// previous is the request recorded just before the next response, if any.
+	var previous *replayRequest
+	replayed := 0
+	for i, r := range records {
+		if r.Direction == DirectionRequest {
+			previous = &replayRequest{typeURL: r.TypeURL, nonce: r.Nonce}
+			continue
+		}
+		m, err := r.Decode()
+		if err != nil {
+			return status.Errorf(codes.Internal, "invalid record %d of stream %d: %v", i, r.Stream, err)
+		}
+		if first == nil {
+			first = &records[i]
+		}
+		if s.Realtime {
+			delay := r.Time.Sub(first.Time) - time.Since(start)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				err := wait(func() bool { return false }, timer.C)
+				timer.Stop()
+				if err != nil {
+					return ignoreClosed(err)
+				}
+			}
+		}
+		ready := func() bool {
+			return subscribed.Contains(r.TypeURL) && (previous == nil || received.Contains(*previous))
+		}
+		if err := wait(ready, nil); err != nil {
+			return ignoreClosed(err)
+		}
+		recordLog.Debugf("replaying response %d of stream %d: %v %v", i, r.Stream, r.TypeURL, r.Nonce)
+		if err := send(m); err != nil {
+			return err
+		}
+		replayed++
+	}
+	recordLog.Infof("replayed %d responses of stream %d", replayed, records[0].Stream)
+	// Keep the stream open, as the server would, until the client closes it.
+	return ignoreClosed(wait(func() bool { return false }, nil))
+}
+
+func ignoreClosed(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,225 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"istio.io/istio/pilot/pkg/util/protoconv"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func mustRecord(t *testing.T, stream uint64, delta bool, m proto.Message) Record {
+	b, err := proto.Marshal(m)
+	assert.NoError(t, err)
+	r := Record{Time: time.Now(), Stream: stream, Delta: delta, Direction: DirectionResponse, Message: b}
+	switch m := m.(type) {
+	case *discovery.DiscoveryResponse:
+		r.TypeURL, r.Nonce = m.TypeUrl, m.Nonce
+	case *discovery.DeltaDiscoveryResponse:
+		r.TypeURL, r.Nonce = m.TypeUrl, m.Nonce
+	}
+	return r
+}
+
+func startReplay(t *testing.T, s *ReplayServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	s.Register(server)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	t.Cleanup(server.Stop)
+	return l.Addr().String()
+}
+
+// session runs a client session against the server: a state of the world stream subscribing to clusters then
+// listeners, and a delta stream subscribing to clusters. It returns the responses received.
+func session(t *testing.T, addr string, recorder *Recorder) []proto.Message {
+	conn, err := dialWithConfig(&Config{Address: addr, Recorder: recorder})
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := discovery.NewAggregatedDiscoveryServiceClient(conn)
+	var res []proto.Message
+
+	sotw, err := client.StreamAggregatedResources(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, sotw.Send(&discovery.DiscoveryRequest{TypeUrl: v3.ClusterType}))
+	for i := 0; i < 2; i++ {
+		resp, err := sotw.Recv()
+		assert.NoError(t, err)
+		res = append(res, resp)
+		assert.NoError(t, sotw.Send(&discovery.DiscoveryRequest{TypeUrl: resp.TypeUrl, ResponseNonce: resp.Nonce, VersionInfo: resp.VersionInfo}))
+	}
+	// The listeners are only sent once requested
+	assert.NoError(t, sotw.Send(&discovery.DiscoveryRequest{TypeUrl: v3.ListenerType}))
+	resp, err := sotw.Recv()
+	assert.NoError(t, err)
+	res = append(res, resp)
+	assert.NoError(t, sotw.CloseSend())
+
+	delta, err := client.DeltaAggregatedResources(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, delta.Send(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType}))
+	deltaResp, err := delta.Recv()
+	assert.NoError(t, err)
+	res = append(res, deltaResp)
+	assert.NoError(t, delta.CloseSend())
+	return res
+}
+
+func TestRecordReplay(t *testing.T) {
+	clusters := func(version string, names ...string) *discovery.DiscoveryResponse {
+		resp := &discovery.DiscoveryResponse{TypeUrl: v3.ClusterType, VersionInfo: version, Nonce: "cds-" + version}
+		for _, n := range names {
+			resp.Resources = append(resp.Resources, protoconv.MessageToAny(&cluster.Cluster{Name: n}))
+		}
+		return resp
+	}
+	responses := []proto.Message{
+		clusters("1", "a"),
+		clusters("2", "a", "b"),
+		&discovery.DiscoveryResponse{
+			TypeUrl:     v3.ListenerType,
+			VersionInfo: "1",
+			Nonce:       "lds-1",
+			Resources:   []*anypb.Any{protoconv.MessageToAny(&listener.Listener{Name: "l"})},
+		},
+		&discovery.DeltaDiscoveryResponse{
+			TypeUrl:          v3.ClusterType,
+			Nonce:            "delta-1",
+			Resources:        []*discovery.Resource{{Name: "a", Version: "1", Resource: protoconv.MessageToAny(&cluster.Cluster{Name: "a"})}},
+			RemovedResources: []string{"b"},
+		},
+	}
+	source := NewReplayServer([]Record{
+		mustRecord(t, 1, false, responses[0]),
+		mustRecord(t, 1, false, responses[1]),
+		mustRecord(t, 1, false, responses[2]),
+		mustRecord(t, 2, true, responses[3]),
+	})
+
+	// Record a session
+	buf := &bytes.Buffer{}
+	recorder := NewRecorder(buf)
+	got := session(t, startReplay(t, source), recorder)
+	assert.NoError(t, recorder.Err())
+	for i := range responses {
+		assert.Equal(t, got[i], responses[i])
+	}
+
+	records, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	summary := make([]string, 0, len(records))
+	for _, r := range records {
+		_, err := r.Decode()
+		assert.NoError(t, err)
+		summary = append(summary, fmt.Sprintf("%d/%v/%v/%v/%v", r.Stream, r.Delta, r.Direction, v3.GetShortType(r.TypeURL), r.Nonce))
+	}
+	assert.Equal(t, summary, []string{
+		"1/false/request/CDS/",
+		"1/false/response/CDS/cds-1",
+		"1/false/request/CDS/cds-1",
+		"1/false/response/CDS/cds-2",
+		"1/false/request/CDS/cds-2",
+		"1/false/request/LDS/",
+		"1/false/response/LDS/lds-1",
+		"2/true/request/CDS/",
+		"2/true/response/CDS/delta-1",
+	})
+
+	// Replaying the recording serves the same session
+	replay := NewReplayServer(records)
+	replay.Realtime = true
+	addr := startReplay(t, replay)
+	got = session(t, addr, nil)
+	for i := range responses {
+		assert.Equal(t, got[i], responses[i])
+	}
+
+	// All the recorded streams were replayed
+	conn, err := dialWithConfig(&Config{Address: addr})
+	assert.NoError(t, err)
+	defer conn.Close()
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(context.Background())
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, status.Code(err), codes.Unavailable)
+}
+
+func TestReplayWaitsForRequest(t *testing.T) {
+	request := func(typeURL, nonce string) Record {
+		b, err := proto.Marshal(&discovery.DiscoveryRequest{TypeUrl: typeURL, ResponseNonce: nonce})
+		assert.NoError(t, err)
+		return Record{Time: time.Now(), Stream: 1, Direction: DirectionRequest, TypeURL: typeURL, Nonce: nonce, Message: b}
+	}
+	addr := startReplay(t, NewReplayServer([]Record{
+		request(v3.ClusterType, ""),
+		mustRecord(t, 1, false, &discovery.DiscoveryResponse{TypeUrl: v3.ClusterType, Nonce: "cds-1"}),
+		request(v3.ClusterType, "cds-1"),
+		mustRecord(t, 1, false, &discovery.DiscoveryResponse{TypeUrl: v3.ClusterType, Nonce: "cds-2"}),
+	}))
+	conn, err := dialWithConfig(&Config{Address: addr})
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&discovery.DiscoveryRequest{TypeUrl: v3.ClusterType}))
+	resp, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Nonce, "cds-1")
+
+	responses := make(chan *discovery.DiscoveryResponse, 1)
+	go func() {
+		resp, err := stream.Recv()
+		if err == nil {
+			responses <- resp
+		}
+	}()
+	// The second response is only sent once the first one is acknowledged
+	assert.NoError(t, stream.Send(&discovery.DiscoveryRequest{TypeUrl: v3.ClusterType, ResponseNonce: "other"}))
+	select {
+	case resp := <-responses:
+		t.Fatalf("unexpected response %v before the acknowledgement", resp.Nonce)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NoError(t, stream.Send(&discovery.DiscoveryRequest{TypeUrl: v3.ClusterType, ResponseNonce: "cds-1"}))
+	select {
+	case resp := <-responses:
+		assert.Equal(t, resp.Nonce, "cds-2")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second response was not sent")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+)
+
+// snapshotInterval is the minimum time between two writes of the snapshot.
+var snapshotInterval = time.Second
+
+// LoadSnapshot populates store with the configs saved in file by the SnapshotFile of a previous run.
+// It returns false if there is no snapshot to load.
+// This should be called before Run, so the configs are served until the upstream server is reachable;
+// the first response for each type replaces the configs loaded from the snapshot.
+func LoadSnapshot(file string, store model.ConfigStore) (bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	configs, _, err := crd.ParseInputs(string(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to parse snapshot %s: %v", file, err)
+	}
+	for _, cfg := range configs {
+		if _, err := store.Create(cfg); err != nil {
+			adscLog.Warnf("Error adding %s %s/%s from snapshot: %v", cfg.GroupVersionKind.Kind, cfg.Namespace, cfg.Name, err)
+		}
+	}
+	adscLog.Infof("Loaded %d configs from snapshot %s", len(configs), file)
+	return true, nil
+}
+
+// saveSnapshot writes all configs in the Store to SnapshotFile, in the YAML format read by the file
+// config monitor. The file is replaced atomically, so a partial write never overrides a complete snapshot.
+func (a *ADSC) saveSnapshot() error {
+	t0 := time.Now()
+	var buf bytes.Buffer
+	count := 0
+	for _, s := range a.Store.Schemas().All() {
+		for _, cfg := range a.Store.List(s.GroupVersionKind(), "") {
+			if err := writeSnapshotConfig(&buf, cfg); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.SnapshotFile), filepath.Base(a.SnapshotFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), a.SnapshotFile); err != nil {
+		return err
+	}
+	adscLog.Debugf("Saved %d configs to snapshot %s in %v", count, a.SnapshotFile, time.Since(t0))
+	return nil
+}
+
+// markSnapshotDirty requests the snapshot to be rewritten. The writer is started on the first request.
+func (a *ADSC) markSnapshotDirty() {
+	a.snapshotOnce.Do(func() {
+		a.snapshotDirty = make(chan struct{}, 1)
+		go a.runSnapshotWriter()
+	})
+	select {
+	case a.snapshotDirty <- struct{}{}:
+	default:
+	}
+}
+
+// runSnapshotWriter rewrites the snapshot each time it is marked dirty, at most once per snapshotInterval,
+// until the client is closed. Writing in the background keeps the receive loop, and so the ACKs, from
+// waiting on a full rewrite after each response.
+func (a *ADSC) runSnapshotWriter() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.snapshotDirty:
+		}
+		if err := a.saveSnapshot(); err != nil {
+			adscLog.Warnf("Error saving config snapshot to %s: %v", a.SnapshotFile, err)
+		}
+		select {
+		case <-a.done:
+			return
+		case <-time.After(snapshotInterval):
+		}
+	}
+}
+
+func writeSnapshotConfig(buf *bytes.Buffer, cfg config.Config) error {
+	obj, err := crd.ConvertConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s %s/%s: %v", cfg.GroupVersionKind.Kind, cfg.Namespace, cfg.Name, err)
+	}
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	buf.WriteString("---\n")
+	buf.Write(out)
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/config/memory"
+	configmonitor "istio.io/istio/pilot/pkg/config/monitor"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/config/schema/gvk"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store := memory.Make(collections.Pilot)
+	for _, ns := range []string{"ns1", "ns2"} {
+		_, err := store.Create(config.Config{
+			Meta: config.Meta{
+				GroupVersionKind: gvk.ServiceEntry,
+				Name:             "se",
+				Namespace:        ns,
+				ResourceVersion:  "1",
+			},
+			Spec: &networking.ServiceEntry{
+				Hosts:      []string{"example.com"},
+				Ports:      []*networking.ServicePort{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_DNS,
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	a := &ADSC{Store: store, SnapshotFile: filepath.Join(dir, "snapshot.yaml")}
+	assert.NoError(t, a.saveSnapshot())
+
+	// The snapshot is readable by the file monitor
+	files, err := configmonitor.NewFileSnapshot(dir, collections.Pilot, "cluster.local").ReadConfigFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, len(files), 2)
+
+	restored := memory.Make(collections.Pilot)
+	loaded, err := LoadSnapshot(a.SnapshotFile, restored)
+	assert.NoError(t, err)
+	assert.Equal(t, loaded, true)
+	for _, ns := range []string{"ns1", "ns2"} {
+		got := restored.Get(gvk.ServiceEntry, "se", ns)
+		if got == nil {
+			t.Fatalf("expected config in %s to be restored", ns)
+		}
+		assert.Equal(t, got.Spec, store.Get(gvk.ServiceEntry, "se", ns).Spec)
+		assert.Equal(t, got.ResourceVersion, "1")
+	}
+
+	loaded, err = LoadSnapshot(filepath.Join(dir, "missing.yaml"), restored)
+	assert.NoError(t, err)
+	assert.Equal(t, loaded, false)
+}
+
+func TestSnapshotWriter(t *testing.T) {
+	dir := t.TempDir()
+	store := memory.Make(collections.Pilot)
+	a := &ADSC{Store: store, SnapshotFile: filepath.Join(dir, "snapshot.yaml"), done: make(chan struct{})}
+	defer close(a.done)
+	createSE := func(name string) {
+		_, err := store.Create(config.Config{
+			Meta: config.Meta{GroupVersionKind: gvk.ServiceEntry, Name: name, Namespace: "ns"},
+			Spec: &networking.ServiceEntry{Hosts: []string{name + ".example.com"}},
+		})
+		assert.NoError(t, err)
+	}
+	snapshotted := func() []string {
+		restored := memory.Make(collections.Pilot)
+		if _, err := LoadSnapshot(a.SnapshotFile, restored); err != nil {
+			return nil
+		}
+		var names []string
+		for _, cfg := range restored.List(gvk.ServiceEntry, "") {
+			names = append(names, cfg.Name)
+		}
+		return names
+	}
+
+	createSE("first")
+	a.markSnapshotDirty()
+	assert.EventuallyEqual(t, snapshotted, []string{"first"})
+
+	// Changes made while the writer waits are written together, once the interval has passed.
+	createSE("second")
+	a.markSnapshotDirty()
+	createSE("third")
+	a.markSnapshotDirty()
+	assert.EventuallyEqual(t, snapshotted, []string{"first", "second", "third"}, retry.Timeout(5*time.Second))
+}
@@ -0,0 +1,206 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/keycertbundle"
+)
+
+// ClientTLSOptions configures the transport security of an ADSC connection based on
+// the tlsSettings of a mesh ConfigSource.
+type ClientTLSOptions struct {
+	// Settings are the ConfigSource TLS settings. If nil or DISABLE, a plaintext connection is used.
+	Settings *networking.ClientTLSSettings
+
+	// KeyCertBundle provides the client certificate and the root bundle in ISTIO_MUTUAL mode.
+	// Istiod passes its own key cert bundle, so the identity presented to the config server follows
+	// istiod certificate rotation.
+	KeyCertBundle *keycertbundle.Watcher
+}
+
+// TransportCredentials returns the gRPC transport credentials to use for connecting to the
+// XDS server at address.
+func TransportCredentials(address string, opts ClientTLSOptions) (credentials.TransportCredentials, error) {
+	if opts.Settings == nil || opts.Settings.Mode == networking.ClientTLSSettings_DISABLE {
+		return insecure.NewCredentials(), nil
+	}
+	cfg, err := ClientTLSConfig(address, opts)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// ClientTLSConfig builds a tls.Config for the XDS server at address. Client certificates and roots
+// are loaded on each handshake, so rotated files or key cert bundles are picked up without
+// recreating the client.
+func ClientTLSConfig(address string, opts ClientTLSOptions) (*tls.Config, error) {
+	settings := opts.Settings
+	if settings == nil {
+		return nil, fmt.Errorf("no TLS settings configured for %s", address)
+	}
+	if settings.CredentialName != "" {
+		return nil, fmt.Errorf("credentialName is not supported for config sources")
+	}
+
+	var getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	var getRoots func() (*x509.CertPool, error)
+	switch settings.Mode {
+	case networking.ClientTLSSettings_SIMPLE:
+	case networking.ClientTLSSettings_MUTUAL:
+		if settings.ClientCertificate == "" || settings.PrivateKey == "" {
+			return nil, fmt.Errorf("MUTUAL mode requires clientCertificate and privateKey")
+		}
+		getClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(settings.ClientCertificate, settings.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	case networking.ClientTLSSettings_ISTIO_MUTUAL:
+		if opts.KeyCertBundle == nil {
+			return nil, fmt.Errorf("ISTIO_MUTUAL mode requires a key cert bundle")
+		}
+		getClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			bundle := opts.KeyCertBundle.GetKeyCertBundle()
+			cert, err := tls.X509KeyPair(bundle.CertPem, bundle.KeyPem)
+			if err != nil {
+				return nil, fmt.Errorf("istiod certificate is not available: %v", err)
+			}
+			return &cert, nil
+		}
+		getRoots = func() (*x509.CertPool, error) {
+			return certPoolFromPEM(opts.KeyCertBundle.GetCABundle())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported TLS mode %v", settings.Mode)
+	}
+
+	if settings.CaCertificates != "" {
+		getRoots = func() (*x509.CertPool, error) {
+			rootPEM, err := os.ReadFile(settings.CaCertificates)
+			if err != nil {
+				return nil, err
+			}
+			return certPoolFromPEM(rootPEM)
+		}
+	}
+	if getRoots == nil {
+		getRoots = x509.SystemCertPool
+	}
+
+	serverName := settings.Sni
+	if serverName == "" {
+		// The address may not have a port, in which case it is the host name.
+		serverName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			serverName = host
+		}
+	}
+	skipVerify := settings.InsecureSkipVerify.GetValue()
+	if serverName == "" && len(settings.SubjectAltNames) == 0 && !skipVerify {
+		return nil, fmt.Errorf("no server name to verify the certificate of %q: set sni or subjectAltNames", address)
+	}
+
+	// nolint: gosec
+	// Verification is done in VerifyPeerCertificate, so that roots can be reloaded on each handshake.
+	return &tls.Config{
+		GetClientCertificate: getClientCertificate,
+		ServerName:           serverName,
+		InsecureSkipVerify:   true,
+		MinVersion:           tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if skipVerify {
+				return nil
+			}
+			roots, err := getRoots()
+			if err != nil {
+				return fmt.Errorf("failed to load root certificates: %v", err)
+			}
+			return verifyServerCert(rawCerts, roots, serverName, settings.SubjectAltNames)
+		},
+	}, nil
+}
+
+// verifyServerCert verifies the chain presented by the server against roots. If sans is set, the leaf
+// certificate must contain one of them, otherwise it must be valid for serverName.
+func verifyServerCert(rawCerts [][]byte, roots *x509.CertPool, serverName string, sans []string) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no server certificate presented")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	verifyOpts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	}
+	if len(sans) == 0 {
+		verifyOpts.DNSName = serverName
+	}
+	if _, err := leaf.Verify(verifyOpts); err != nil {
+		return err
+	}
+	if len(sans) == 0 {
+		return nil
+	}
+	for _, san := range sans {
+		for _, name := range leaf.DNSNames {
+			if name == san {
+				return nil
+			}
+		}
+		for _, uri := range leaf.URIs {
+			if uri.String() == san {
+				return nil
+			}
+		}
+		for _, ip := range leaf.IPAddresses {
+			if ip.String() == san {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("server certificate does not match any of the expected SANs %v", sans)
+}
+
+func certPoolFromPEM(rootPEM []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(rootPEM) {
+		return nil, fmt.Errorf("no valid root certificates found")
+	}
+	return pool, nil
+}
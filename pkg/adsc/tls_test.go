@@ -0,0 +1,323 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adsc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/keycertbundle"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+type testCA struct {
+	rootPEM []byte
+	cert    *x509.Certificate
+	key     any
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	rootPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Host:         "test-ca",
+		TTL:          time.Hour,
+		Org:          "istio",
+		IsCA:         true,
+		IsSelfSigned: true,
+		ECSigAlg:     util.EcdsaSigAlg,
+	})
+	assert.NoError(t, err)
+	cert, err := util.ParsePemEncodedCertificate(rootPEM)
+	assert.NoError(t, err)
+	key, err := util.ParsePemEncodedKey(keyPEM)
+	assert.NoError(t, err)
+	return testCA{rootPEM: rootPEM, cert: cert, key: key}
+}
+
+func (ca testCA) issue(t *testing.T, host string, server bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	certPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Host:       host,
+		TTL:        time.Hour,
+		SignerCert: ca.cert,
+		SignerPriv: ca.key,
+		IsServer:   server,
+		IsClient:   !server,
+		ECSigAlg:   util.EcdsaSigAlg,
+	})
+	assert.NoError(t, err)
+	return certPEM, keyPEM
+}
+
+type echoADSServer struct {
+	discovery.UnimplementedAggregatedDiscoveryServiceServer
+}
+
+func (echoADSServer) StreamAggregatedResources(stream discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&discovery.DiscoveryResponse{TypeUrl: req.TypeUrl, VersionInfo: "1", Nonce: "1"}); err != nil {
+			return err
+		}
+	}
+}
+
+// startTLSServer starts an in-process ADS server requiring client certificates signed by ca.
+func startTLSServer(t *testing.T, ca testCA, certPEM, keyPEM []byte) string {
+	t.Helper()
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(ca.rootPEM)
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := grpc.NewServer(grpc.Creds(creds))
+	discovery.RegisterAggregatedDiscoveryServiceServer(srv, echoADSServer{})
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+// roundTrip dials address through the adsc dialer and exchanges a single request.
+func roundTrip(address string, creds credentials.TransportCredentials) error {
+	conn, err := dialWithConfig(&Config{
+		Address:  address,
+		GrpcOpts: []grpc.DialOption{grpc.WithTransportCredentials(creds)},
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&discovery.DiscoveryRequest{TypeUrl: "test"}); err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(p, data, 0o600))
+	return p
+}
+
+func TestClientTLS(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "xds.example.com,spiffe://cluster.local/ns/istio-config/sa/config-server", true)
+	address := startTLSServer(t, ca, serverCert, serverKey)
+
+	dir := t.TempDir()
+	clientCert, clientKey := ca.issue(t, "spiffe://cluster.local/ns/istio-system/sa/istiod", false)
+	certFile := writeFile(t, dir, "cert.pem", clientCert)
+	keyFile := writeFile(t, dir, "key.pem", clientKey)
+	rootFile := writeFile(t, dir, "root.pem", ca.rootPEM)
+	otherRootFile := writeFile(t, dir, "other-root.pem", otherCA.rootPEM)
+
+	bundle := keycertbundle.NewWatcher()
+	bundle.SetAndNotify(clientKey, clientCert, ca.rootPEM)
+
+	cases := []struct {
+		name     string
+		opts     ClientTLSOptions
+		expected bool
+	}{
+		{
+			name: "mutual from files",
+			opts: ClientTLSOptions{Settings: &networking.ClientTLSSettings{
+				Mode:              networking.ClientTLSSettings_MUTUAL,
+				ClientCertificate: certFile,
+				PrivateKey:        keyFile,
+				CaCertificates:    rootFile,
+				Sni:               "xds.example.com",
+			}},
+			expected: true,
+		},
+		{
+			name: "istio mutual from key cert bundle",
+			opts: ClientTLSOptions{
+				Settings: &networking.ClientTLSSettings{
+					Mode:            networking.ClientTLSSettings_ISTIO_MUTUAL,
+					SubjectAltNames: []string{"spiffe://cluster.local/ns/istio-config/sa/config-server"},
+				},
+				KeyCertBundle: bundle,
+			},
+			expected: true,
+		},
+		{
+			name: "san mismatch",
+			opts: ClientTLSOptions{
+				Settings: &networking.ClientTLSSettings{
+					Mode:            networking.ClientTLSSettings_ISTIO_MUTUAL,
+					SubjectAltNames: []string{"spiffe://cluster.local/ns/other/sa/other"},
+				},
+				KeyCertBundle: bundle,
+			},
+			expected: false,
+		},
+		{
+			name: "server name mismatch",
+			opts: ClientTLSOptions{Settings: &networking.ClientTLSSettings{
+				Mode:              networking.ClientTLSSettings_MUTUAL,
+				ClientCertificate: certFile,
+				PrivateKey:        keyFile,
+				CaCertificates:    rootFile,
+			}},
+			expected: false,
+		},
+		{
+			name: "untrusted root",
+			opts: ClientTLSOptions{Settings: &networking.ClientTLSSettings{
+				Mode:              networking.ClientTLSSettings_MUTUAL,
+				ClientCertificate: certFile,
+				PrivateKey:        keyFile,
+				CaCertificates:    otherRootFile,
+				Sni:               "xds.example.com",
+			}},
+			expected: false,
+		},
+		{
+			name: "insecure skip verify",
+			opts: ClientTLSOptions{Settings: &networking.ClientTLSSettings{
+				Mode:               networking.ClientTLSSettings_MUTUAL,
+				ClientCertificate:  certFile,
+				PrivateKey:         keyFile,
+				CaCertificates:     otherRootFile,
+				InsecureSkipVerify: wrapperspb.Bool(true),
+			}},
+			expected: true,
+		},
+		{
+			name: "no client certificate",
+			opts: ClientTLSOptions{Settings: &networking.ClientTLSSettings{
+				Mode:           networking.ClientTLSSettings_SIMPLE,
+				CaCertificates: rootFile,
+				Sni:            "xds.example.com",
+			}},
+			expected: false,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := TransportCredentials(address, tt.opts)
+			assert.NoError(t, err)
+			err = roundTrip(address, creds)
+			if tt.expected {
+				assert.NoError(t, err)
+			} else if err == nil {
+				t.Fatalf("expected connection to fail")
+			}
+		})
+	}
+}
+
+func TestClientTLSRootRotation(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "xds.example.com", true)
+	address := startTLSServer(t, ca, serverCert, serverKey)
+	clientCert, clientKey := ca.issue(t, "spiffe://cluster.local/ns/istio-system/sa/istiod", false)
+
+	bundle := keycertbundle.NewWatcher()
+	bundle.SetAndNotify(clientKey, clientCert, otherCA.rootPEM)
+	opts := ClientTLSOptions{
+		Settings: &networking.ClientTLSSettings{
+			Mode:            networking.ClientTLSSettings_ISTIO_MUTUAL,
+			SubjectAltNames: []string{"xds.example.com"},
+		},
+		KeyCertBundle: bundle,
+	}
+	creds, err := TransportCredentials(address, opts)
+	assert.NoError(t, err)
+	if err := roundTrip(address, creds); err == nil {
+		t.Fatalf("expected connection to fail with untrusted root")
+	}
+
+	// Rotating the bundle should be picked up without rebuilding the credentials.
+	bundle.SetAndNotify(clientKey, clientCert, ca.rootPEM)
+	retry.UntilSuccessOrFail(t, func() error {
+		return roundTrip(address, creds)
+	}, retry.Timeout(10*time.Second))
+}
+
+func TestClientTLSServerName(t *testing.T) {
+	settings := &networking.ClientTLSSettings{Mode: networking.ClientTLSSettings_SIMPLE}
+	for address, want := range map[string]string{
+		"xds.example.com:15010": "xds.example.com",
+		"xds.example.com":       "xds.example.com",
+		"[::1]:15010":           "::1",
+	} {
+		cfg, err := ClientTLSConfig(address, ClientTLSOptions{Settings: settings})
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+		if cfg.ServerName != want {
+			t.Errorf("%s: got server name %q, want %q", address, cfg.ServerName, want)
+		}
+	}
+	if _, err := ClientTLSConfig("", ClientTLSOptions{Settings: settings}); err == nil {
+		t.Fatalf("expected an error without a server name to verify")
+	}
+}
+
+func TestClientTLSInvalidSettings(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings *networking.ClientTLSSettings
+	}{
+		{"mutual without certificates", &networking.ClientTLSSettings{Mode: networking.ClientTLSSettings_MUTUAL}},
+		{"istio mutual without bundle", &networking.ClientTLSSettings{Mode: networking.ClientTLSSettings_ISTIO_MUTUAL}},
+		{"credential name", &networking.ClientTLSSettings{Mode: networking.ClientTLSSettings_SIMPLE, CredentialName: "secret"}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := TransportCredentials("xds.example.com:15010", ClientTLSOptions{Settings: tt.settings}); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"istio.io/istio/pkg/monitoring"
+)
+
+var (
+	authoritativeRequests = monitoring.NewSum(
+		"dns_authoritative_requests_total",
+		"Total number of DNS requests to the authoritative DNS server.",
+	)
+
+	zoneTransfers = monitoring.NewSum(
+		"dns_zone_transfers_total",
+		"Total number of zone transfers served by the authoritative DNS server.",
+	)
+)
@@ -0,0 +1,192 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"istio.io/istio/pkg/adsc"
+	"istio.io/istio/pkg/cluster"
+	dnsProto "istio.io/istio/pkg/dns/proto"
+	"istio.io/istio/pkg/model"
+	"istio.io/istio/pkg/version"
+)
+
+// NDSOptions configures an NDSClient.
+type NDSOptions struct {
+	// Address of the XDS server, such as istiod.istio-system.svc:15012.
+	Address string
+	// DialOptions configure the connection to the XDS server, such as its transport security and credentials.
+	DialOptions []grpc.DialOption
+	// Namespaces are the namespaces whose name tables are subscribed to. The names of a namespace are the
+	// services exported to it, within the scope of its Sidecar resources. Unless XDS authentication is disabled,
+	// the identity of the client must belong to each namespace.
+	Namespaces []string
+	// IP is the IP of the client sent to the XDS server.
+	IP string
+	// ClusterID is the cluster of the client. Its endpoints are preferred for headless services.
+	ClusterID string
+	// DNSAutoAllocate requests the automatically allocated addresses of the ServiceEntries without addresses.
+	DNSAutoAllocate bool
+}
+
+// NDSClient subscribes to the name tables of several namespaces with NDS, and calls a handler with their union
+// each time one of them changes, once all were received. Each namespace is subscribed to by an adsc client,
+// with the identity of a sidecar of the namespace.
+type NDSClient struct {
+	opts    NDSOptions
+	handler func(*dnsProto.NameTable)
+
+	mu     sync.Mutex
+	tables map[string]*dnsProto.NameTable
+}
+
+// NewNDSClient creates an NDSClient calling handler with the merged name tables.
+func NewNDSClient(opts NDSOptions, handler func(*dnsProto.NameTable)) *NDSClient {
+	return &NDSClient{
+		opts:    opts,
+		handler: handler,
+		tables:  map[string]*dnsProto.NameTable{},
+	}
+}
+
+// Run subscribes to the name tables until the context is cancelled. Streams are reestablished with a backoff
+// when they fail, while the last name tables keep being served.
+func (c *NDSClient) Run(ctx context.Context) error {
+	clients := make([]*adsc.ADSC, 0, len(c.opts.Namespaces))
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for _, ns := range c.opts.Namespaces {
+		client, err := adsc.New(c.opts.Address, &adsc.ADSConfig{
+			Config: adsc.Config{
+				Namespace: ns,
+				Workload:  "dns",
+				IP:        c.opts.IP,
+				Meta:      c.nodeMetadata(ns),
+				GrpcOpts:  c.opts.DialOptions,
+			},
+			InitialDiscoveryRequests: []*discovery.DiscoveryRequest{{TypeUrl: model.NameTableType}},
+			ResponseHandler:          nameTableHandler{client: c, namespace: ns},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", c.opts.Address, err)
+		}
+		clients = append(clients, client)
+		// Failures are retried in the background.
+		_ = client.RunOrReconnect()
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// HasSynced returns true once the name tables of all the namespaces were received.
+func (c *NDSClient) HasSynced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tables) == len(c.opts.Namespaces)
+}
+
+// nodeMetadata returns the metadata of the client for the namespace. It is a sidecar with DNS capture enabled,
+// so that it is sent name tables.
+func (c *NDSClient) nodeMetadata(ns string) *structpb.Struct {
+	meta := model.NodeMetadata{
+		Namespace:       ns,
+		ClusterID:       cluster.ID(c.opts.ClusterID),
+		IstioVersion:    version.Info.Version,
+		DNSCapture:      true,
+		DNSAutoAllocate: model.StringBool(c.opts.DNSAutoAllocate),
+	}
+	return meta.ToStruct()
+}
+
+// nameTableHandler handles the name tables of a namespace received by an adsc client.
+type nameTableHandler struct {
+	client    *NDSClient
+	namespace string
+}
+
+func (h nameTableHandler) HandleResponse(_ *adsc.ADSC, res *discovery.DiscoveryResponse) {
+	if res.TypeUrl != model.NameTableType {
+		return
+	}
+	nt := &dnsProto.NameTable{}
+	if len(res.Resources) > 0 {
+		if err := res.Resources[0].UnmarshalTo(nt); err != nil {
+			log.Warnf("invalid name table for namespace %s: %v", h.namespace, err)
+			return
+		}
+	}
+	h.client.update(h.namespace, nt)
+}
+
+func (c *NDSClient) update(ns string, nt *dnsProto.NameTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[ns] = nt
+	if len(c.tables) < len(c.opts.Namespaces) {
+		log.Infof("received the name table of namespace %s, waiting for %d more", ns, len(c.opts.Namespaces)-len(c.tables))
+		return
+	}
+	// The handler is called with the lock held, so that updates are not reordered.
+	c.handler(mergeNameTables(c.tables))
+}
+
+// mergeNameTables returns the union of the name tables. A host visible from several namespaces has the union
+// of its IPs, ports and endpoints.
+func mergeNameTables(tables map[string]*dnsProto.NameTable) *dnsProto.NameTable {
+	out := &dnsProto.NameTable{Table: map[string]*dnsProto.NameTable_NameInfo{}}
+	namespaces := make([]string, 0, len(tables))
+	for ns := range tables {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+	for _, ns := range namespaces {
+		for host, ni := range tables[ns].Table {
+			existing, f := out.Table[host]
+			if !f {
+				out.Table[host] = proto.Clone(ni).(*dnsProto.NameTable_NameInfo)
+				continue
+			}
+			existing.Ips = appendMissing(existing.Ips, ni.Ips...)
+			existing.EndpointHosts = appendMissing(existing.EndpointHosts, ni.EndpointHosts...)
+			for _, port := range ni.Ports {
+				if !slices.ContainsFunc(existing.Ports, func(p *dnsProto.NameTable_Port) bool { return proto.Equal(p, port) }) {
+					existing.Ports = append(existing.Ports, proto.Clone(port).(*dnsProto.NameTable_Port))
+				}
+			}
+		}
+	}
+	return out
+}
+
+func appendMissing(s []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
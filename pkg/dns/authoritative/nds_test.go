@@ -0,0 +1,182 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/test/xds"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
+	dnsProto "istio.io/istio/pkg/dns/proto"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+const ndsServiceEntries = `
+apiVersion: networking.istio.io/v1alpha3
+kind: ServiceEntry
+metadata:
+  name: shared
+  namespace: ns1
+spec:
+  hosts:
+  - shared.example.com
+  addresses:
+  - 10.0.0.1
+  ports:
+  - number: 80
+    name: http
+    protocol: HTTP
+  resolution: STATIC
+  endpoints:
+  - address: 10.1.0.1
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: ServiceEntry
+metadata:
+  name: private
+  namespace: ns1
+spec:
+  exportTo:
+  - "."
+  hosts:
+  - private.ns1.example.com
+  addresses:
+  - 10.0.0.2
+  ports:
+  - number: 80
+    name: http
+    protocol: HTTP
+  resolution: STATIC
+  endpoints:
+  - address: 10.1.0.2
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: ServiceEntry
+metadata:
+  name: private
+  namespace: ns2
+spec:
+  exportTo:
+  - "."
+  hosts:
+  - private.ns2.example.com
+  addresses:
+  - 10.0.0.3
+  ports:
+  - number: 80
+    name: http
+    protocol: HTTP
+  resolution: STATIC
+  endpoints:
+  - address: 10.1.0.3
+`
+
+func TestNDSClient(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{ConfigString: ndsServiceEntries})
+
+	var mu sync.Mutex
+	var latest *dnsProto.NameTable
+	c := NewNDSClient(NDSOptions{
+		Address: s.Listener.Addr().String(),
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return s.BufListener.Dial()
+			}),
+		},
+		Namespaces: []string{"ns1", "ns2"},
+		IP:         "10.10.10.10",
+	}, func(nt *dnsProto.NameTable) {
+		mu.Lock()
+		defer mu.Unlock()
+		latest = nt
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, c.Run(ctx))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	hosts := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		if latest == nil {
+			return nil
+		}
+		return sortedKeys(latest.Table)
+	}
+	// The names private to each namespace are merged
+	retry.UntilOrFail(t, c.HasSynced, retry.Timeout(10*time.Second))
+	assert.EventuallyEqual(t, hosts, []string{"private.ns1.example.com", "private.ns2.example.com", "shared.example.com"})
+	mu.Lock()
+	assert.Equal(t, latest.Table["shared.example.com"].Ips, []string{"10.0.0.1"})
+	mu.Unlock()
+
+	// Changes are pushed
+	if _, err := s.Store().Create(config.Config{
+		Meta: config.Meta{GroupVersionKind: gvk.ServiceEntry, Name: "new", Namespace: "ns2"},
+		Spec: &networking.ServiceEntry{
+			Hosts:      []string{"new.example.com"},
+			Addresses:  []string{"10.0.0.4"},
+			Ports:      []*networking.ServicePort{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Resolution: networking.ServiceEntry_STATIC,
+			Endpoints:  []*networking.WorkloadEntry{{Address: "10.1.0.4"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assert.EventuallyEqual(t, hosts,
+		[]string{"new.example.com", "private.ns1.example.com", "private.ns2.example.com", "shared.example.com"})
+}
+
+func TestMergeNameTables(t *testing.T) {
+	http := &dnsProto.NameTable_Port{Name: "http", Port: 80, Protocol: "tcp"}
+	grpcPort := &dnsProto.NameTable_Port{Name: "grpc", Port: 90, Protocol: "tcp"}
+	merged := mergeNameTables(map[string]*dnsProto.NameTable{
+		"ns1": {Table: map[string]*dnsProto.NameTable_NameInfo{
+			"a.example.com": {Ips: []string{"10.0.0.1"}, Ports: []*dnsProto.NameTable_Port{http}},
+			"b.example.com": {Ips: []string{"10.0.0.2"}, EndpointHosts: []string{"b-0.example.com"}},
+		}},
+		"ns2": {Table: map[string]*dnsProto.NameTable_NameInfo{
+			"a.example.com": {Ips: []string{"10.0.0.1", "10.0.0.3"}, Ports: []*dnsProto.NameTable_Port{http, grpcPort}},
+			"b.example.com": {Ips: []string{"10.0.0.2"}, EndpointHosts: []string{"b-1.example.com"}},
+			"c.example.com": {Ips: []string{"10.0.0.4"}},
+		}},
+	})
+	expected := &dnsProto.NameTable{Table: map[string]*dnsProto.NameTable_NameInfo{
+		"a.example.com": {Ips: []string{"10.0.0.1", "10.0.0.3"}, Ports: []*dnsProto.NameTable_Port{http, grpcPort}},
+		"b.example.com": {Ips: []string{"10.0.0.2"}, EndpointHosts: []string{"b-0.example.com", "b-1.example.com"}},
+		"c.example.com": {Ips: []string{"10.0.0.4"}},
+	}}
+	if !proto.Equal(merged, expected) {
+		t.Fatalf("got %v, want %v", merged, expected)
+	}
+}
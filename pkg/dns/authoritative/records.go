@@ -0,0 +1,90 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	istiolog "istio.io/istio/pkg/log"
+)
+
+var log = istiolog.RegisterScope("dns", "Istio DNS proxy")
+
+// defaultTTLInSeconds is the TTL of the records, the same as in the DNS proxy of the agent.
+const defaultTTLInSeconds = 30
+
+// a returns the A RRs of a host.
+func a(host string, ips []netip.Addr) []dns.RR {
+	answers := make([]dns.RR, len(ips))
+	for i, ip := range ips {
+		r := new(dns.A)
+		r.Hdr = dns.RR_Header{Name: host, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTLInSeconds}
+		r.A = ip.AsSlice()
+		answers[i] = r
+	}
+	return answers
+}
+
+// aaaa returns the AAAA RRs of a host.
+func aaaa(host string, ips []netip.Addr) []dns.RR {
+	answers := make([]dns.RR, len(ips))
+	for i, ip := range ips {
+		r := new(dns.AAAA)
+		r.Hdr = dns.RR_Header{Name: host, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: defaultTTLInSeconds}
+		r.AAAA = ip.AsSlice()
+		answers[i] = r
+	}
+	return answers
+}
+
+// ptr returns a PTR RR from the reverse name of an IP to a host.
+func ptr(name string, host string) dns.RR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: defaultTTLInSeconds},
+		Ptr: host,
+	}
+}
+
+// srv returns the SRV RRs for a port of each of the target hosts. As with Kubernetes DNS, the weight is shared
+// equally among the targets, and is at least 1.
+func srv(name string, port uint32, targets []string) []dns.RR {
+	answers := make([]dns.RR, len(targets))
+	weight := uint16(max(100/len(targets), 1))
+	for i, target := range targets {
+		answers[i] = &dns.SRV{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: defaultTTLInSeconds},
+			Weight: weight,
+			Port:   uint16(port),
+			Target: strings.ToLower(dns.Fqdn(target)),
+		}
+	}
+	return answers
+}
+
+// size returns the maximum size of the response to a request: the buffer size advertised in its OPT record
+// over UDP, or 64K over TCP.
+func size(protocol string, r *dns.Msg) int {
+	if protocol == "tcp" {
+		return dns.MaxMsgSize
+	}
+	size := uint16(0)
+	if o := r.IsEdns0(); o != nil {
+		size = o.UDPSize()
+	}
+	return int(max(size, dns.MinMsgSize))
+}
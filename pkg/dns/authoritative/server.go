@@ -0,0 +1,507 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+
+	dnsProto "istio.io/istio/pkg/dns/proto"
+	"istio.io/istio/pkg/maps"
+	netutil "istio.io/istio/pkg/util/net"
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	// transferChunkSize is the number of records sent in each message of a zone transfer.
+	transferChunkSize = 100
+	// The timers of the SOA records of the zones. Secondary servers check the serial often, as the names of the
+	// mesh change often.
+	soaRefreshInSeconds = 60
+	soaRetryInSeconds   = 10
+	soaExpireInSeconds  = 3600
+)
+
+// Options configures a Server.
+type Options struct {
+	// Addr is the address the server listens on, for both UDP and TCP.
+	Addr string
+	// Zones are the domains the server is authoritative for, such as cluster.local. Names of the name table
+	// outside the zones, such as ServiceEntry hosts, are answered too, but NXDOMAIN and zone transfers are only
+	// available within the zones.
+	Zones []string
+	// Nameserver is the name of the server in the SOA and NS records of the zones. Defaults to ns.<zone>.
+	Nameserver string
+	// AllowTransfer are the networks allowed to transfer the zones (AXFR) over TCP. Zone transfers are refused if
+	// empty.
+	AllowTransfer []netip.Prefix
+	// Notify are the addresses of the secondary servers notified when the zones change, as specified by RFC 1996.
+	Notify []string
+}
+
+// Server is an authoritative DNS server for the names of a name table, for networks which can
+// not use the DNS proxy of the agent, such as a node or a VM network. It does not forward other queries.
+type Server struct {
+	opts    Options
+	zones   []string
+	servers []*dns.Server
+	now     func() time.Time
+
+	records atomic.Pointer[zoneRecords]
+
+	mu        sync.Mutex
+	nameTable *dnsProto.NameTable
+	serial    uint32
+}
+
+// zoneRecords is an immutable snapshot of the records served by a Server.
+type zoneRecords struct {
+	// zones are the SOA and NS records of the zones, by origin.
+	zones map[string][]dns.RR
+	// origins are the origins of the zones, the longest first.
+	origins []string
+	// rrs are the records by owner name and type. Owner names are lower case and fully qualified.
+	rrs map[string]map[uint16][]dns.RR
+	// nodes are the names which exist within the zones, including the empty non-terminals between the owner
+	// names and the origin of their zone, which are answered with NODATA rather than NXDOMAIN.
+	nodes sets.String
+}
+
+// NewServer creates a Server listening on opts.Addr. The server answers
+// SERVFAIL until the first name table is set with UpdateNameTable.
+func NewServer(opts Options) (*Server, error) {
+	s := &Server{
+		opts: opts,
+		now:  time.Now,
+	}
+	for _, z := range opts.Zones {
+		z = strings.ToLower(dns.Fqdn(strings.TrimSpace(z)))
+		if _, ok := dns.IsDomainName(z); !ok || z == "." {
+			return nil, fmt.Errorf("invalid zone %q", z)
+		}
+		if !slices.Contains(s.zones, z) {
+			s.zones = append(s.zones, z)
+		}
+	}
+	// Queries are answered from the most specific zone.
+	slices.SortFunc(s.zones, func(a, b string) int {
+		return dns.CountLabel(b) - dns.CountLabel(a)
+	})
+
+	udp, err := net.ListenPacket("udp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %v", opts.Addr, err)
+	}
+	// Listen on the port picked for UDP, if any.
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		_ = udp.Close()
+		return nil, fmt.Errorf("failed to listen on tcp %s: %v", opts.Addr, err)
+	}
+	s.servers = []*dns.Server{
+		{PacketConn: udp, Handler: s},
+		{Listener: tcp, Handler: s},
+	}
+	return s, nil
+}
+
+// Start starts serving DNS-over-UDP and DNS-over-TCP.
+func (s *Server) Start() {
+	for _, srv := range s.servers {
+		go func(srv *dns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				log.Errorf("authoritative DNS server terminated: %v", err)
+			}
+		}(srv)
+	}
+	log.Infof("Starting authoritative DNS server on %s for zones %v", s.Address(), s.zones)
+}
+
+// Address returns the address the server listens on.
+func (s *Server) Address() string {
+	return s.servers[0].PacketConn.LocalAddr().String()
+}
+
+func (s *Server) Close() {
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(); err != nil {
+			// The server may not have been started.
+			if srv.PacketConn != nil {
+				_ = srv.PacketConn.Close()
+			}
+			if srv.Listener != nil {
+				_ = srv.Listener.Close()
+			}
+		}
+	}
+}
+
+// IsReady returns true if a name table was set.
+func (s *Server) IsReady() bool {
+	return s.records.Load() != nil
+}
+
+// Serial returns the serial of the SOA records of the zones, which is increased each time the name table changes.
+func (s *Server) Serial() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serial
+}
+
+// UpdateNameTable sets the names served. If the names changed, the serial of the zones is increased and the
+// secondary servers are notified.
+func (s *Server) UpdateNameTable(nt *dnsProto.NameTable) {
+	s.mu.Lock()
+	if s.nameTable != nil && proto.Equal(s.nameTable, nt) {
+		s.mu.Unlock()
+		return
+	}
+	// As is common, the serial is a timestamp, so that it keeps increasing across restarts.
+	s.serial = max(s.serial+1, uint32(s.now().Unix()))
+	s.nameTable = nt
+	records := s.buildRecords(nt, s.serial)
+	s.records.Store(records)
+	log.Debugf("updated authoritative records with %d names, serial %d", len(records.rrs), s.serial)
+	s.mu.Unlock()
+
+	if len(s.opts.Notify) > 0 {
+		go s.notify()
+	}
+}
+
+func (s *Server) buildRecords(nt *dnsProto.NameTable, serial uint32) *zoneRecords {
+	r := &zoneRecords{
+		zones:   map[string][]dns.RR{},
+		origins: s.zones,
+		rrs:     map[string]map[uint16][]dns.RR{},
+		nodes:   sets.New[string](),
+	}
+	for _, origin := range s.zones {
+		nameserver := s.opts.Nameserver
+		if nameserver == "" {
+			nameserver = "ns." + origin
+		}
+		hdr := func(rrtype uint16) dns.RR_Header {
+			return dns.RR_Header{Name: origin, Rrtype: rrtype, Class: dns.ClassINET, Ttl: defaultTTLInSeconds}
+		}
+		r.zones[origin] = []dns.RR{
+			&dns.SOA{
+				Hdr:     hdr(dns.TypeSOA),
+				Ns:      dns.Fqdn(nameserver),
+				Mbox:    "hostmaster." + origin,
+				Serial:  serial,
+				Refresh: soaRefreshInSeconds,
+				Retry:   soaRetryInSeconds,
+				Expire:  soaExpireInSeconds,
+				Minttl:  defaultTTLInSeconds,
+			},
+			&dns.NS{Hdr: hdr(dns.TypeNS), Ns: dns.Fqdn(nameserver)},
+		}
+	}
+
+	add := func(rrs []dns.RR) {
+		for _, rr := range rrs {
+			name := rr.Header().Name
+			if r.rrs[name] == nil {
+				r.rrs[name] = map[uint16][]dns.RR{}
+			}
+			r.rrs[name][rr.Header().Rrtype] = append(r.rrs[name][rr.Header().Rrtype], rr)
+		}
+	}
+	for hostname, ni := range nt.Table {
+		name := strings.ToLower(dns.Fqdn(hostname))
+		ipv4, ipv6 := netutil.ParseIPsSplitToV4V6(ni.Ips)
+		add(a(name, ipv4))
+		add(aaaa(name, ipv6))
+		if len(ni.Ports) > 0 && !strings.HasPrefix(name, "*") {
+			targets := ni.EndpointHosts
+			if len(targets) == 0 {
+				targets = []string{name}
+			}
+			for _, port := range ni.Ports {
+				add(srv(strings.ToLower("_"+port.Name+"._"+port.Protocol+"."+name), port.Port, targets))
+			}
+		}
+		// As in the DNS proxy, the IPs of headless services are resolved to the hostnames of their endpoints.
+		if len(ni.EndpointHosts) == 0 && !strings.HasPrefix(name, "*") {
+			for _, ip := range ni.Ips {
+				reverse, err := dns.ReverseAddr(ip)
+				if err != nil {
+					continue
+				}
+				if slices.ContainsFunc(r.rrs[reverse][dns.TypePTR], func(rr dns.RR) bool { return rr.(*dns.PTR).Ptr == name }) {
+					continue
+				}
+				add([]dns.RR{ptr(reverse, name)})
+			}
+		}
+	}
+
+	for name, types := range r.rrs {
+		// Records are served in a stable order, which also keeps zone transfers stable.
+		for _, records := range types {
+			slices.SortFunc(records, func(a, b dns.RR) int {
+				return strings.Compare(a.String(), b.String())
+			})
+		}
+		origin := r.zoneOf(name)
+		if origin == "" {
+			continue
+		}
+		for n := name; dns.IsSubDomain(origin, n); {
+			r.nodes.Insert(n)
+			if n == origin {
+				break
+			}
+			n = parentDomain(n)
+		}
+	}
+	for _, origin := range s.zones {
+		r.nodes.Insert(origin)
+	}
+	return r
+}
+
+// parentDomain returns the parent of a fully qualified name.
+func parentDomain(name string) string {
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// zoneOf returns the origin of the zone of name, or an empty string if the name is not within a zone.
+func (r *zoneRecords) zoneOf(name string) string {
+	for _, origin := range r.origins {
+		if dns.IsSubDomain(origin, name) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// lookup returns the answer and authority sections and the rcode of the response to a query. It returns false
+// if the server is not authoritative for the name.
+func (r *zoneRecords) lookup(qname string, qtype uint16) ([]dns.RR, []dns.RR, int, bool) {
+	name := strings.ToLower(qname)
+	origin := r.zoneOf(name)
+	var authority []dns.RR
+	if origin != "" {
+		authority = r.zones[origin][:1]
+	}
+
+	owner := name
+	types, found := r.rrs[name]
+	if !found && !r.nodes.Contains(name) {
+		owner, found = r.wildcard(name, origin)
+		if !found {
+			if origin == "" {
+				return nil, nil, 0, false
+			}
+			return nil, authority, dns.RcodeNameError, true
+		}
+		types = r.rrs[owner]
+	}
+
+	var answer []dns.RR
+	if name == origin {
+		for _, rr := range r.zones[origin] {
+			if qtype == dns.TypeANY || qtype == rr.Header().Rrtype {
+				answer = append(answer, rr)
+			}
+		}
+	}
+	if qtype == dns.TypeANY {
+		for _, t := range sortedKeys(types) {
+			answer = append(answer, types[t]...)
+		}
+	} else {
+		answer = append(answer, types[qtype]...)
+	}
+	if len(answer) == 0 {
+		// NODATA
+		return nil, authority, dns.RcodeSuccess, true
+	}
+	if owner != qname {
+		// Records of a wildcard are synthesized with the name of the query, whose case is preserved.
+		for i, rr := range answer {
+			answer[i] = dns.Copy(rr)
+			answer[i].Header().Name = qname
+		}
+	}
+	return answer, nil, dns.RcodeSuccess, true
+}
+
+// wildcard returns the wildcard name matching name, such as *.example.com. for foo.example.com. As specified
+// by RFC 4592, the search stops at the closest existing ancestor of the name.
+func (r *zoneRecords) wildcard(name string, origin string) (string, bool) {
+	for parent := parentDomain(name); parent != "."; parent = parentDomain(parent) {
+		if origin != "" && !dns.IsSubDomain(origin, parent) {
+			break
+		}
+		if _, f := r.rrs["*."+parent]; f {
+			return "*." + parent, true
+		}
+		if _, f := r.rrs[parent]; f || r.nodes.Contains(parent) {
+			break
+		}
+	}
+	return "", false
+}
+
+// transfer returns the records of a zone transfer of the zone, starting and ending with its SOA record.
+func (r *zoneRecords) transfer(origin string) []dns.RR {
+	out := slices.Clone(r.zones[origin])
+	for _, name := range sortedKeys(r.rrs) {
+		// Names of more specific zones are transferred with them.
+		if r.zoneOf(name) != origin {
+			continue
+		}
+		types := r.rrs[name]
+		for _, t := range sortedKeys(types) {
+			out = append(out, types[t]...)
+		}
+	}
+	return append(out, r.zones[origin][0])
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	authoritativeRequests.Increment()
+	response := new(dns.Msg)
+	response.SetReply(req)
+	if len(req.Question) != 1 {
+		response.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(response)
+		return
+	}
+	records := s.records.Load()
+	if records == nil {
+		log.Debugf("dns request for host %q before the name table is loaded", req.Question[0].Name)
+		response.Rcode = dns.RcodeServerFailure
+		_ = w.WriteMsg(response)
+		return
+	}
+	protocol := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		protocol = "tcp"
+	}
+
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+	switch {
+	case q.Qclass != dns.ClassINET && q.Qclass != dns.ClassANY:
+		response.Rcode = dns.RcodeRefused
+	case q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR && protocol == "tcp":
+		// Incremental transfers are answered with a full transfer, as allowed by RFC 1995.
+		s.transfer(w, req, records, name, protocol)
+		return
+	case q.Qtype == dns.TypeIXFR:
+		// RFC 1995 section 2: a response with the SOA record only asks the client to use TCP.
+		if soa := records.zones[name]; soa != nil {
+			response.Authoritative = true
+			response.Answer = soa[:1]
+		} else {
+			response.Rcode = dns.RcodeNotAuth
+		}
+	default:
+		answer, authority, rcode, ok := records.lookup(q.Name, q.Qtype)
+		if !ok {
+			// Not an open resolver: queries for other names are not forwarded.
+			response.Rcode = dns.RcodeRefused
+			break
+		}
+		response.Authoritative = true
+		response.Rcode = rcode
+		response.Answer = answer
+		response.Ns = authority
+	}
+	response.Truncate(size(protocol, req))
+	_ = w.WriteMsg(response)
+}
+
+// transfer answers a zone transfer request (AXFR) for the zone, if the client is allowed to.
+func (s *Server) transfer(w dns.ResponseWriter, req *dns.Msg, records *zoneRecords,
+	origin string, protocol string,
+) {
+	response := new(dns.Msg)
+	response.SetReply(req)
+	remote, _ := netip.ParseAddrPort(w.RemoteAddr().String())
+	allowed := slices.ContainsFunc(s.opts.AllowTransfer, func(p netip.Prefix) bool {
+		return p.Contains(remote.Addr().Unmap())
+	})
+	switch {
+	case protocol != "tcp" || !allowed:
+		log.Infof("zone transfer of %s refused for %v", origin, w.RemoteAddr())
+		response.Rcode = dns.RcodeRefused
+	case records.zones[origin] == nil:
+		response.Rcode = dns.RcodeNotAuth
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		_ = w.WriteMsg(response)
+		return
+	}
+
+	rrs := records.transfer(origin)
+	ch := make(chan *dns.Envelope)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- new(dns.Transfer).Out(w, req, ch)
+	}()
+	for i := 0; i < len(rrs); i += transferChunkSize {
+		select {
+		case ch <- &dns.Envelope{RR: rrs[i:min(i+transferChunkSize, len(rrs))]}:
+		case err := <-errCh:
+			log.Warnf("zone transfer of %s to %v failed: %v", origin, w.RemoteAddr(), err)
+			return
+		}
+	}
+	close(ch)
+	if err := <-errCh; err != nil {
+		log.Warnf("zone transfer of %s to %v failed: %v", origin, w.RemoteAddr(), err)
+		return
+	}
+	zoneTransfers.Increment()
+	log.Debugf("transferred %d records of zone %s to %v", len(rrs), origin, w.RemoteAddr())
+}
+
+// notify notifies the secondary servers that the zones changed.
+func (s *Server) notify() {
+	client := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	for _, origin := range s.zones {
+		for _, addr := range s.opts.Notify {
+			req := new(dns.Msg)
+			req.SetNotify(origin)
+			if _, _, err := client.Exchange(req, addr); err != nil {
+				log.Warnf("failed to notify %s of the changes of zone %s: %v", addr, origin, err)
+			}
+		}
+	}
+}
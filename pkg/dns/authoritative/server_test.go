@@ -0,0 +1,404 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authoritative
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"istio.io/istio/pilot/pkg/serviceregistry/provider"
+	dnsProto "istio.io/istio/pkg/dns/proto"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func testNameTable() *dnsProto.NameTable {
+	k8s := string(provider.Kubernetes)
+	external := string(provider.External)
+	return &dnsProto.NameTable{
+		Table: map[string]*dnsProto.NameTable_NameInfo{
+			"productpage.ns1.svc.cluster.local": {
+				Ips:       []string{"10.0.0.1"},
+				Registry:  k8s,
+				Shortname: "productpage",
+				Namespace: "ns1",
+				Ports:     []*dnsProto.NameTable_Port{{Name: "http", Port: 9080, Protocol: "tcp"}},
+			},
+			"dual.ns1.svc.cluster.local": {
+				Ips:       []string{"10.0.0.2", "2001:db8::2"},
+				Registry:  k8s,
+				Shortname: "dual",
+				Namespace: "ns1",
+			},
+			"mysql.ns1.svc.cluster.local": {
+				Ips:           []string{"10.0.0.5", "10.0.0.6"},
+				Registry:      k8s,
+				Shortname:     "mysql",
+				Namespace:     "ns1",
+				Ports:         []*dnsProto.NameTable_Port{{Name: "mysql", Port: 3306, Protocol: "tcp"}},
+				EndpointHosts: []string{"mysql-0.mysql.ns1.svc.cluster.local", "mysql-1.mysql.ns1.svc.cluster.local"},
+			},
+			"mysql-0.mysql.ns1.svc.cluster.local": {
+				Ips:       []string{"10.0.0.5"},
+				Registry:  k8s,
+				Shortname: "mysql-0.mysql",
+				Namespace: "ns1",
+			},
+			"mysql-1.mysql.ns1.svc.cluster.local": {
+				Ips:       []string{"10.0.0.6"},
+				Registry:  k8s,
+				Shortname: "mysql-1.mysql",
+				Namespace: "ns1",
+			},
+			"www.example.com": {
+				Ips:      []string{"10.1.0.1"},
+				Registry: external,
+			},
+			"*.wildcard.example.com": {
+				Ips:      []string{"10.1.0.2"},
+				Registry: external,
+			},
+		},
+	}
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func question(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	return req
+}
+
+func newTestServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+	opts.Addr = "127.0.0.1:0"
+	if opts.Zones == nil {
+		opts.Zones = []string{"cluster.local"}
+	}
+	s, err := NewServer(opts)
+	assert.NoError(t, err)
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestServer(t *testing.T) {
+	s := newTestServer(t, Options{Nameserver: "dns.istio-system.svc.cluster.local"})
+
+	// Not ready
+	client := dns.Client{Net: "udp", Timeout: 3 * time.Second}
+	res, _, err := client.Exchange(question("productpage.ns1.svc.cluster.local.", dns.TypeA), s.Address())
+	assert.NoError(t, err)
+	assert.Equal(t, res.Rcode, dns.RcodeServerFailure)
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	s.now = clock.Now
+	s.UpdateNameTable(testNameTable())
+	assert.Equal(t, s.IsReady(), true)
+	soa := "cluster.local.\t30\tIN\tSOA\tdns.istio-system.svc.cluster.local. hostmaster.cluster.local. 1000 60 10 3600 30"
+
+	cases := []struct {
+		name      string
+		qtype     uint16
+		rcode     int
+		answer    []string
+		authority []string
+	}{
+		{
+			name:   "productpage.ns1.svc.cluster.local.",
+			answer: []string{"productpage.ns1.svc.cluster.local.\t30\tIN\tA\t10.0.0.1"},
+		},
+		{
+			name:   "ProductPage.ns1.svc.cluster.local.",
+			answer: []string{"ProductPage.ns1.svc.cluster.local.\t30\tIN\tA\t10.0.0.1"},
+		},
+		{
+			name:      "productpage.ns1.svc.cluster.local.",
+			qtype:     dns.TypeAAAA,
+			authority: []string{soa},
+		},
+		{
+			name:   "dual.ns1.svc.cluster.local.",
+			qtype:  dns.TypeAAAA,
+			answer: []string{"dual.ns1.svc.cluster.local.\t30\tIN\tAAAA\t2001:db8::2"},
+		},
+		{
+			name:      "ns1.svc.cluster.local.",
+			authority: []string{soa},
+		},
+		{
+			name:      "productpage.ns2.svc.cluster.local.",
+			rcode:     dns.RcodeNameError,
+			authority: []string{soa},
+		},
+		{
+			name:      "productpage.ns1.svc.cluster.local.ns1.svc.cluster.local.",
+			rcode:     dns.RcodeNameError,
+			authority: []string{soa},
+		},
+		{
+			name:   "cluster.local.",
+			qtype:  dns.TypeSOA,
+			answer: []string{soa},
+		},
+		{
+			name:   "cluster.local.",
+			qtype:  dns.TypeNS,
+			answer: []string{"cluster.local.\t30\tIN\tNS\tdns.istio-system.svc.cluster.local."},
+		},
+		{
+			name:   "_http._tcp.productpage.ns1.svc.cluster.local.",
+			qtype:  dns.TypeSRV,
+			answer: []string{"_http._tcp.productpage.ns1.svc.cluster.local.\t30\tIN\tSRV\t0 100 9080 productpage.ns1.svc.cluster.local."},
+		},
+		{
+			name:  "_mysql._tcp.mysql.ns1.svc.cluster.local.",
+			qtype: dns.TypeSRV,
+			answer: []string{
+				"_mysql._tcp.mysql.ns1.svc.cluster.local.\t30\tIN\tSRV\t0 50 3306 mysql-0.mysql.ns1.svc.cluster.local.",
+				"_mysql._tcp.mysql.ns1.svc.cluster.local.\t30\tIN\tSRV\t0 50 3306 mysql-1.mysql.ns1.svc.cluster.local.",
+			},
+		},
+		{
+			name:   "1.0.0.10.in-addr.arpa.",
+			qtype:  dns.TypePTR,
+			answer: []string{"1.0.0.10.in-addr.arpa.\t30\tIN\tPTR\tproductpage.ns1.svc.cluster.local."},
+		},
+		{
+			name:   "5.0.0.10.in-addr.arpa.",
+			qtype:  dns.TypePTR,
+			answer: []string{"5.0.0.10.in-addr.arpa.\t30\tIN\tPTR\tmysql-0.mysql.ns1.svc.cluster.local."},
+		},
+		{
+			name:   "www.example.com.",
+			answer: []string{"www.example.com.\t30\tIN\tA\t10.1.0.1"},
+		},
+		{
+			name:   "foo.wildcard.example.com.",
+			answer: []string{"foo.wildcard.example.com.\t30\tIN\tA\t10.1.0.2"},
+		},
+		{
+			name:  "other.example.com.",
+			rcode: dns.RcodeRefused,
+		},
+		{
+			name:  "istio.io.",
+			rcode: dns.RcodeRefused,
+		},
+	}
+	for _, tt := range cases {
+		for _, protocol := range []string{"udp", "tcp"} {
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dns.TypeA
+			}
+			t.Run(protocol+"/"+dns.TypeToString[qtype]+"/"+tt.name, func(t *testing.T) {
+				client := dns.Client{Net: protocol, Timeout: 3 * time.Second}
+				res, _, err := client.Exchange(question(tt.name, qtype), s.Address())
+				assert.NoError(t, err)
+				assert.Equal(t, res.Rcode, tt.rcode)
+				assert.Equal(t, res.Authoritative, tt.rcode != dns.RcodeRefused)
+				var answer, authority []string
+				for _, rr := range res.Answer {
+					answer = append(answer, rr.String())
+				}
+				for _, rr := range res.Ns {
+					authority = append(authority, rr.String())
+				}
+				if len(answer) > 1 {
+					// Answers are shuffled
+					slices.Sort(answer)
+				}
+				assert.Equal(t, answer, tt.answer)
+				assert.Equal(t, authority, tt.authority)
+			})
+		}
+	}
+}
+
+func transferZone(t *testing.T, addr string, zone string) ([]dns.RR, error) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetAxfr(zone)
+	tr := &dns.Transfer{ReadTimeout: 3 * time.Second}
+	envelopes, err := tr.In(req, addr)
+	if err != nil {
+		return nil, err
+	}
+	var out []dns.RR
+	for e := range envelopes {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		out = append(out, e.RR...)
+	}
+	return out, nil
+}
+
+func TestZoneTransfer(t *testing.T) {
+	s := newTestServer(t, Options{
+		Zones:         []string{"cluster.local", "example.com"},
+		AllowTransfer: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	})
+	nt := testNameTable()
+	// More records than fit in a message
+	for i := 0; i < 300; i++ {
+		nt.Table[fmt.Sprintf("svc-%d.ns2.svc.cluster.local", i)] = &dnsProto.NameTable_NameInfo{
+			Ips:      []string{fmt.Sprintf("10.2.%d.%d", i/256, i%256)},
+			Registry: string(provider.Kubernetes),
+		}
+	}
+	s.UpdateNameTable(nt)
+
+	rrs, err := transferZone(t, s.Address(), "cluster.local.")
+	assert.NoError(t, err)
+	assert.Equal(t, rrs[0].Header().Rrtype, dns.TypeSOA)
+	assert.Equal(t, rrs[len(rrs)-1].Header().Rrtype, dns.TypeSOA)
+	names := map[string]int{}
+	for _, rr := range rrs {
+		names[rr.Header().Name]++
+		assert.Equal(t, dns.IsSubDomain("cluster.local.", rr.Header().Name), true)
+	}
+	// SOA, NS, the 7 A and AAAA and 3 SRV records of ns1, the 300 A records of ns2, and the final SOA
+	assert.Equal(t, len(rrs), 2+7+3+300+1)
+	assert.Equal(t, names["productpage.ns1.svc.cluster.local."], 1)
+	assert.Equal(t, names["dual.ns1.svc.cluster.local."], 2)
+
+	rrs, err = transferZone(t, s.Address(), "example.com.")
+	assert.NoError(t, err)
+	var records []string
+	for _, rr := range rrs[2 : len(rrs)-1] {
+		records = append(records, rr.String())
+	}
+	assert.Equal(t, records, []string{
+		"*.wildcard.example.com.\t30\tIN\tA\t10.1.0.2",
+		"www.example.com.\t30\tIN\tA\t10.1.0.1",
+	})
+
+	// Not a zone
+	_, err = transferZone(t, s.Address(), "ns1.svc.cluster.local.")
+	assert.Error(t, err)
+
+	// Not over UDP
+	req := new(dns.Msg)
+	req.SetAxfr("cluster.local.")
+	client := dns.Client{Net: "udp", Timeout: 3 * time.Second}
+	res, _, err := client.Exchange(req, s.Address())
+	assert.NoError(t, err)
+	assert.Equal(t, res.Rcode, dns.RcodeRefused)
+
+	// An incremental transfer over UDP only returns the SOA record
+	req = new(dns.Msg)
+	req.SetIxfr("cluster.local.", 0, "ns.cluster.local.", "hostmaster.cluster.local.")
+	res, _, err = client.Exchange(req, s.Address())
+	assert.NoError(t, err)
+	assert.Equal(t, len(res.Answer), 1)
+	assert.Equal(t, res.Answer[0].(*dns.SOA).Serial, s.Serial())
+}
+
+func TestZoneTransferRefused(t *testing.T) {
+	s := newTestServer(t, Options{
+		AllowTransfer: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	s.UpdateNameTable(testNameTable())
+	_, err := transferZone(t, s.Address(), "cluster.local.")
+	assert.Error(t, err)
+}
+
+func TestSerial(t *testing.T) {
+	notifications := make(chan string, 10)
+	up := make(chan struct{})
+	secondary := &dns.Server{
+		Addr:              "127.0.0.1:0",
+		Net:               "udp",
+		NotifyStartedFunc: func() { close(up) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if req.Opcode == dns.OpcodeNotify {
+				notifications <- req.Question[0].Name
+			}
+			res := new(dns.Msg)
+			res.SetReply(req)
+			_ = w.WriteMsg(res)
+		}),
+	}
+	go func() {
+		_ = secondary.ListenAndServe()
+	}()
+	<-up
+	t.Cleanup(func() { _ = secondary.Shutdown() })
+
+	s := newTestServer(t, Options{Notify: []string{secondary.PacketConn.LocalAddr().String()}})
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	s.now = clock.Now
+	expectNotification := func() {
+		t.Helper()
+		select {
+		case zone := <-notifications:
+			assert.Equal(t, zone, "cluster.local.")
+		case <-time.After(5 * time.Second):
+			t.Fatal("secondary server not notified")
+		}
+	}
+
+	nt := testNameTable()
+	s.UpdateNameTable(nt)
+	assert.Equal(t, s.Serial(), uint32(1000))
+	expectNotification()
+
+	// Unchanged
+	s.UpdateNameTable(testNameTable())
+	assert.Equal(t, s.Serial(), uint32(1000))
+
+	// Changed within the same second
+	nt = testNameTable()
+	delete(nt.Table, "dual.ns1.svc.cluster.local")
+	s.UpdateNameTable(nt)
+	assert.Equal(t, s.Serial(), uint32(1001))
+	expectNotification()
+
+	clock.Advance(time.Hour)
+	nt = testNameTable()
+	s.UpdateNameTable(nt)
+	assert.Equal(t, s.Serial(), uint32(4600))
+	expectNotification()
+
+	retry.UntilOrFail(t, func() bool {
+		client := dns.Client{Net: "udp", Timeout: 3 * time.Second}
+		res, _, err := client.Exchange(question("cluster.local.", dns.TypeSOA), s.Address())
+		return err == nil && len(res.Answer) == 1 && res.Answer[0].(*dns.SOA).Serial == 4600
+	}, retry.Timeout(5*time.Second))
+}
@@ -0,0 +1,232 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/miekg/dns"
+)
+
+const (
+	// staleTTLInSeconds is the TTL of the records of a stale response, as recommended by RFC 8767.
+	staleTTLInSeconds = 30
+	// prefetchMinHits is the number of hits an entry needs to be prefetched.
+	prefetchMinHits = 2
+	// prefetchPercentage is the percentage of the TTL of an entry left when it is prefetched.
+	prefetchPercentage = 10
+)
+
+// CacheOptions configures the cache of the responses of the upstream DNS servers.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of responses in the cache. The least recently used responses are evicted
+	// first. Zero disables the cache.
+	MaxEntries int
+	// MaxTTL caps the time a response is cached, whatever the TTL of its records.
+	MaxTTL time.Duration
+	// MaxNegativeTTL caps the time a negative (NXDOMAIN or NODATA) response is cached. As specified by RFC 2308,
+	// negative responses are cached for the TTL of the SOA record of their authority section, if any.
+	MaxNegativeTTL time.Duration
+	// Prefetch enables refreshing the responses which were served several times, when their TTL is almost expired,
+	// so that they do not expire for the clients.
+	Prefetch bool
+	// ServeStale is how long an expired response may be served when the upstream servers fail, as specified by
+	// RFC 8767. Zero disables serving stale responses.
+	ServeStale time.Duration
+}
+
+// responseCache is a size bounded cache of upstream responses, which respects their TTL.
+type responseCache struct {
+	opts CacheOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries simplelru.LRUCache[string, *cacheEntry]
+}
+
+type cacheEntry struct {
+	msg      *dns.Msg
+	stored   time.Time
+	expires  time.Time
+	hits     int
+	negative bool
+	// prefetching is true while the entry is being refreshed.
+	prefetching bool
+}
+
+func newResponseCache(opts CacheOptions) *responseCache {
+	if opts.MaxEntries <= 0 {
+		return nil
+	}
+	entries, err := simplelru.NewLRU[string, *cacheEntry](opts.MaxEntries, nil)
+	if err != nil {
+		log.Errorf("failed to create DNS cache: %v", err)
+		return nil
+	}
+	return &responseCache{
+		opts:    opts,
+		now:     time.Now,
+		entries: entries,
+	}
+}
+
+// cacheKey returns the key of the responses to the request. Responses depend on the question and on whether the
+// client asked for DNSSEC records.
+func cacheKey(req *dns.Msg) string {
+	q := req.Question[0]
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	return strings.ToLower(q.Name) + "/" + strconv.Itoa(int(q.Qtype)) + "/" + strconv.Itoa(int(q.Qclass)) +
+		"/" + strconv.FormatBool(do) + "/" + strconv.FormatBool(req.CheckingDisabled)
+}
+
+// get returns the cached response to the request, with the TTL of its records decreased by the time it was
+// cached. It also returns whether the response should be prefetched.
+func (c *responseCache) get(req *dns.Msg) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, f := c.entries.Get(cacheKey(req))
+	if !f {
+		return nil, false
+	}
+	now := c.now()
+	if !now.Before(e.expires) {
+		return nil, false
+	}
+	e.hits++
+	prefetch := false
+	if c.opts.Prefetch && !e.prefetching && !e.negative && e.hits >= prefetchMinHits &&
+		e.expires.Sub(now)*100 <= e.expires.Sub(e.stored)*prefetchPercentage {
+		e.prefetching = true
+		prefetch = true
+	}
+	return reply(req, e.msg, uint32(now.Sub(e.stored)/time.Second), 0), prefetch
+}
+
+// getStale returns an expired response to the request, if it expired less than ServeStale ago.
+func (c *responseCache) getStale(req *dns.Msg) *dns.Msg {
+	if c.opts.ServeStale <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, f := c.entries.Get(cacheKey(req))
+	if !f || c.now().After(e.expires.Add(c.opts.ServeStale)) {
+		return nil
+	}
+	return reply(req, e.msg, 0, staleTTLInSeconds)
+}
+
+// add caches the response to the request, if it can be cached.
+func (c *responseCache) add(req *dns.Msg, res *dns.Msg) {
+	ttl, negative, ok := c.cacheTTL(res)
+	key := cacheKey(req)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		// Keep a previous entry, to be served stale or prefetched again.
+		if e, f := c.entries.Peek(key); f {
+			e.prefetching = false
+		}
+		return
+	}
+	now := c.now()
+	e := &cacheEntry{
+		msg:      res.Copy(),
+		stored:   now,
+		expires:  now.Add(ttl),
+		negative: negative,
+	}
+	// Hits are kept across refreshes, so that hot entries keep being prefetched.
+	if prev, f := c.entries.Peek(key); f {
+		e.hits = prev.hits
+	}
+	c.entries.Add(key, e)
+}
+
+// cacheTTL returns how long the response can be cached, and whether it is negative. Truncated responses and
+// failures are not cached.
+func (c *responseCache) cacheTTL(res *dns.Msg) (time.Duration, bool, bool) {
+	if res.Truncated {
+		return 0, false, false
+	}
+	switch {
+	case res.Rcode == dns.RcodeSuccess && len(res.Answer) > 0:
+		ttl := minTTL(res.Answer)
+		if ttl == 0 {
+			return 0, false, false
+		}
+		return capTTL(time.Duration(ttl)*time.Second, c.opts.MaxTTL), false, true
+	case res.Rcode == dns.RcodeNameError || res.Rcode == dns.RcodeSuccess:
+		// RFC 2308 section 5: negative responses without SOA record should not be cached.
+		for _, rr := range res.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := min(soa.Hdr.Ttl, soa.Minttl)
+				if ttl == 0 {
+					return 0, false, false
+				}
+				d := capTTL(time.Duration(ttl)*time.Second, c.opts.MaxNegativeTTL)
+				return capTTL(d, c.opts.MaxTTL), true, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+func minTTL(records []dns.RR) uint32 {
+	ttl := records[0].Header().Ttl
+	for _, rr := range records[1:] {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	return ttl
+}
+
+func capTTL(d time.Duration, limit time.Duration) time.Duration {
+	if limit > 0 && d > limit {
+		return limit
+	}
+	return d
+}
+
+// reply returns a copy of the cached response for the request. The TTL of its records is decreased by elapsed
+// seconds, or set to ttl if not zero.
+func reply(req *dns.Msg, cached *dns.Msg, elapsed uint32, ttl uint32) *dns.Msg {
+	res := cached.Copy()
+	res.Id = req.Id
+	res.Question = req.Question
+	for _, section := range [][]dns.RR{res.Answer, res.Ns, res.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			switch {
+			case ttl != 0:
+				h.Ttl = ttl
+			case h.Ttl > elapsed:
+				h.Ttl -= elapsed
+			default:
+				h.Ttl = 0
+			}
+		}
+	}
+	return res
+}
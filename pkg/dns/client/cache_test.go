@@ -0,0 +1,346 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pkg/monitoring/monitortest"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(t *testing.T, opts CacheOptions) (*responseCache, *fakeClock) {
+	t.Helper()
+	c := newResponseCache(opts)
+	if c == nil {
+		t.Fatal("cache is disabled")
+	}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c.now = clock.Now
+	return c, clock
+}
+
+func question(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	return req
+}
+
+func answer(req *dns.Msg, ttls ...uint32) *dns.Msg {
+	res := new(dns.Msg)
+	res.SetReply(req)
+	for i, ttl := range ttls {
+		rr := a(req.Question[0].Name, []netip.Addr{netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)})})[0]
+		rr.Header().Ttl = ttl
+		res.Answer = append(res.Answer, rr)
+	}
+	return res
+}
+
+func negative(req *dns.Msg, rcode int, soaTTL, minTTL uint32) *dns.Msg {
+	res := new(dns.Msg)
+	res.SetRcode(req, rcode)
+	res.Ns = []dns.RR{&dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soaTTL},
+		Ns:     "ns.example.",
+		Mbox:   "admin.example.",
+		Minttl: minTTL,
+	}}
+	return res
+}
+
+func ttls(res *dns.Msg) []uint32 {
+	var out []uint32
+	for _, rr := range append(res.Answer, res.Ns...) {
+		out = append(out, rr.Header().Ttl)
+	}
+	return out
+}
+
+func TestResponseCache(t *testing.T) {
+	c, clock := newTestCache(t, CacheOptions{MaxEntries: 10})
+	req := question("www.example.com.", dns.TypeA)
+	c.add(req, answer(req, 100, 60))
+
+	// Cached for the lowest TTL, which is decreased by the time spent in the cache
+	clock.Advance(10 * time.Second)
+	req.Id = 1234
+	res, _ := c.get(req)
+	assert.Equal(t, res.Id, req.Id)
+	assert.Equal(t, ttls(res), []uint32{90, 50})
+
+	// Names are case insensitive, but the type is part of the key
+	res, _ = c.get(question("WWW.example.com.", dns.TypeA))
+	assert.Equal(t, res != nil, true)
+	res, _ = c.get(question("www.example.com.", dns.TypeAAAA))
+	assert.Equal(t, res == nil, true)
+
+	clock.Advance(50 * time.Second)
+	res, _ = c.get(req)
+	assert.Equal(t, res == nil, true)
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	req := question("www.example.com.", dns.TypeA)
+	truncated := answer(req, 100)
+	truncated.Truncated = true
+	cases := []struct {
+		name     string
+		opts     CacheOptions
+		response *dns.Msg
+		// expected is how long the response is cached, zero if not cached.
+		expected time.Duration
+	}{
+		{
+			name:     "positive",
+			response: answer(req, 100),
+			expected: 100 * time.Second,
+		},
+		{
+			name:     "positive capped",
+			opts:     CacheOptions{MaxTTL: time.Minute},
+			response: answer(req, 100),
+			expected: time.Minute,
+		},
+		{
+			name:     "zero TTL",
+			response: answer(req, 0),
+		},
+		{
+			name:     "nxdomain with SOA minimum",
+			response: negative(req, dns.RcodeNameError, 300, 30),
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "nxdomain with SOA TTL",
+			response: negative(req, dns.RcodeNameError, 20, 30),
+			expected: 20 * time.Second,
+		},
+		{
+			name:     "nodata",
+			response: negative(req, dns.RcodeSuccess, 300, 30),
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "nxdomain capped",
+			opts:     CacheOptions{MaxNegativeTTL: 10 * time.Second},
+			response: negative(req, dns.RcodeNameError, 300, 30),
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "nxdomain capped by max TTL",
+			opts:     CacheOptions{MaxTTL: 5 * time.Second, MaxNegativeTTL: 10 * time.Second},
+			response: negative(req, dns.RcodeNameError, 300, 30),
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "nxdomain without SOA",
+			response: answer(req),
+		},
+		{
+			name:     "server failure",
+			response: negative(req, dns.RcodeServerFailure, 300, 30),
+		},
+		{
+			name:     "truncated",
+			response: truncated,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.MaxEntries = 10
+			c, clock := newTestCache(t, tt.opts)
+			c.add(req, tt.response)
+			res, _ := c.get(req)
+			if tt.expected == 0 {
+				assert.Equal(t, res == nil, true)
+				return
+			}
+			assert.Equal(t, res != nil, true)
+			clock.Advance(tt.expected - time.Second)
+			res, _ = c.get(req)
+			assert.Equal(t, res != nil, true)
+			clock.Advance(time.Second)
+			res, _ = c.get(req)
+			assert.Equal(t, res == nil, true)
+		})
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c, _ := newTestCache(t, CacheOptions{MaxEntries: 2})
+	first := question("first.example.com.", dns.TypeA)
+	second := question("second.example.com.", dns.TypeA)
+	third := question("third.example.com.", dns.TypeA)
+	c.add(first, answer(first, 100))
+	c.add(second, answer(second, 100))
+	// first is now the most recently used
+	res, _ := c.get(first)
+	assert.Equal(t, res != nil, true)
+	c.add(third, answer(third, 100))
+
+	res, _ = c.get(second)
+	assert.Equal(t, res == nil, true)
+	res, _ = c.get(first)
+	assert.Equal(t, res != nil, true)
+	res, _ = c.get(third)
+	assert.Equal(t, res != nil, true)
+}
+
+func TestResponseCachePrefetch(t *testing.T) {
+	c, clock := newTestCache(t, CacheOptions{MaxEntries: 10, Prefetch: true})
+	req := question("www.example.com.", dns.TypeA)
+	c.add(req, answer(req, 100))
+
+	// Not hot enough
+	clock.Advance(95 * time.Second)
+	_, prefetch := c.get(req)
+	assert.Equal(t, prefetch, false)
+	// Hot, and close to expiry
+	_, prefetch = c.get(req)
+	assert.Equal(t, prefetch, true)
+	// Already prefetching
+	_, prefetch = c.get(req)
+	assert.Equal(t, prefetch, false)
+
+	// The refreshed entry is still hot
+	c.add(req, answer(req, 100))
+	_, prefetch = c.get(req)
+	assert.Equal(t, prefetch, false)
+	clock.Advance(95 * time.Second)
+	_, prefetch = c.get(req)
+	assert.Equal(t, prefetch, true)
+
+	// A failed refresh can be retried
+	c.add(req, negative(req, dns.RcodeServerFailure, 0, 0))
+	_, prefetch = c.get(req)
+	assert.Equal(t, prefetch, true)
+}
+
+func TestResponseCacheServeStale(t *testing.T) {
+	c, clock := newTestCache(t, CacheOptions{MaxEntries: 10, ServeStale: time.Hour})
+	req := question("www.example.com.", dns.TypeA)
+	c.add(req, answer(req, 100))
+	assert.Equal(t, c.getStale(req) != nil, true)
+
+	clock.Advance(100 * time.Second)
+	res, _ := c.get(req)
+	assert.Equal(t, res == nil, true)
+	stale := c.getStale(req)
+	assert.Equal(t, ttls(stale), []uint32{staleTTLInSeconds})
+
+	clock.Advance(time.Hour + time.Second)
+	assert.Equal(t, c.getStale(req) == nil, true)
+}
+
+func TestDNSCache(t *testing.T) {
+	upstreamQueries := atomic.NewInt32(0)
+	upstreamFailing := atomic.NewBool(false)
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		upstreamQueries.Inc()
+		res := answer(req, 60)
+		if upstreamFailing.Load() {
+			res = new(dns.Msg)
+			res.SetRcode(req, dns.RcodeServerFailure)
+		}
+		_ = w.WriteMsg(res)
+	})
+	up := make(chan struct{})
+	upstream := &dns.Server{Addr: "127.0.0.1:0", Net: "udp", Handler: mux, NotifyStartedFunc: func() { close(up) }}
+	go func() {
+		_ = upstream.ListenAndServe()
+	}()
+	<-up
+	t.Cleanup(func() { _ = upstream.Shutdown() })
+
+	mt := monitortest.New(t)
+	d, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", false,
+		CacheOptions{MaxEntries: 10, Prefetch: true, ServeStale: time.Hour}, UpstreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	d.cache.now = clock.Now
+	d.upstreams = plainUpstreams([]string{upstream.PacketConn.LocalAddr().String()})
+	d.StartDNS()
+	fillTable(d)
+	t.Cleanup(d.Close)
+
+	client := dns.Client{Net: "udp", Timeout: 3 * time.Second}
+	query := func(host string) *dns.Msg {
+		t.Helper()
+		res, _, err := client.Exchange(question(host, dns.TypeA), d.dnsProxies[0].Address())
+		assert.NoError(t, err)
+		return res
+	}
+
+	// Hosts of the name table are not cached
+	query("productpage.ns1.svc.cluster.local.")
+	assert.Equal(t, upstreamQueries.Load(), int32(0))
+
+	query("www.example.com.")
+	query("www.example.com.")
+	assert.Equal(t, upstreamQueries.Load(), int32(1))
+	mt.Assert(cacheMisses.Name(), nil, monitortest.Exactly(1))
+	mt.Assert(cacheHits.Name(), nil, monitortest.Exactly(1))
+
+	// Hot entries are refreshed in the background before they expire
+	clock.Advance(55 * time.Second)
+	assert.Equal(t, ttls(query("www.example.com.")), []uint32{5})
+	retry.UntilOrFail(t, func() bool {
+		return upstreamQueries.Load() == 2
+	}, retry.Timeout(5*time.Second))
+	mt.Assert(cachePrefetches.Name(), nil, monitortest.Exactly(1))
+	retry.UntilOrFail(t, func() bool {
+		res, _ := d.cache.get(question("www.example.com.", dns.TypeA))
+		return res != nil && ttls(res)[0] == 60
+	}, retry.Timeout(5*time.Second))
+
+	// Expired entries are served if upstream fails
+	upstreamFailing.Store(true)
+	clock.Advance(2 * time.Minute)
+	res := query("www.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, ttls(res), []uint32{staleTTLInSeconds})
+	mt.Assert(cacheStaleResponses.Name(), nil, monitortest.Exactly(1))
+
+	res = query("other.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeServerFailure)
+}
@@ -20,6 +20,7 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"istio.io/istio/pkg/config/host"
 	dnsProto "istio.io/istio/pkg/dns/proto"
 	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/maps"
 	netutil "istio.io/istio/pkg/util/net"
 	"istio.io/istio/pkg/util/sets"
 )
@@ -49,6 +51,8 @@ type LocalDNSServer struct {
 
 	resolvConfServers []string
 	searchNamespaces  []string
+	// upstreams are the servers unknown names are forwarded to, in order of preference.
+	upstreams []*upstream
 	// The namespace where the proxy resides
 	// determines the hosts used for shortname resolution
 	proxyNamespace string
@@ -58,6 +62,9 @@ type LocalDNSServer struct {
 
 	respondBeforeSync         bool
 	forwardToUpstreamParallel bool
+
+	// cache holds the responses of the upstream servers, nil if disabled.
+	cache *responseCache
 }
 
 // LookupTable is borrowed from https://github.com/coredns/coredns/blob/master/plugin/hosts/hostsfile.go
@@ -77,6 +84,11 @@ type LookupTable struct {
 	// The cname records here (comprised of different variants of the hosts above,
 	// expanded by the search namespaces) pointing to the actual host.
 	cname map[string][]dns.RR
+	// The PTR records for the IPs of the hosts above, keyed by the reverse name of the IP
+	// (like 5.0.0.10.in-addr.arpa.).
+	ptr map[string][]dns.RR
+	// The SRV records for the named ports of the hosts above (like _http._tcp.productpage.ns1.svc.cluster.local.).
+	srv map[string][]dns.RR
 }
 
 const (
@@ -86,10 +98,13 @@ const (
 	defaultTTLInSeconds = 30
 )
 
-func NewLocalDNSServer(proxyNamespace, proxyDomain string, addr string, forwardToUpstreamParallel bool) (*LocalDNSServer, error) {
+func NewLocalDNSServer(proxyNamespace, proxyDomain string, addr string, forwardToUpstreamParallel bool,
+	cacheOptions CacheOptions, upstreamOptions UpstreamOptions,
+) (*LocalDNSServer, error) {
 	h := &LocalDNSServer{
 		proxyNamespace:            proxyNamespace,
 		forwardToUpstreamParallel: forwardToUpstreamParallel,
+		cache:                     newResponseCache(cacheOptions),
 	}
 
 	// proxyDomain could contain the namespace making it redundant.
@@ -141,7 +156,15 @@ func NewLocalDNSServer(proxyNamespace, proxyDomain string, addr string, forwardT
 		h.searchNamespaces = dnsConfig.Search
 	}
 
-	log.WithLabels("search", h.searchNamespaces, "servers", h.resolvConfServers).Debugf("initialized DNS")
+	if len(upstreamOptions.Servers) > 0 {
+		if h.upstreams, err = newUpstreams(upstreamOptions); err != nil {
+			return nil, err
+		}
+	} else {
+		h.upstreams = plainUpstreams(h.resolvConfServers)
+	}
+
+	log.WithLabels("search", h.searchNamespaces, "servers", h.upstreams).Debugf("initialized DNS")
 
 	if addr == "" {
 		addr = "localhost:15053"
@@ -190,8 +213,12 @@ func (h *LocalDNSServer) UpdateLookupTable(nt *dnsProto.NameTable) {
 		name4:    map[string][]dns.RR{},
 		name6:    map[string][]dns.RR{},
 		cname:    map[string][]dns.RR{},
+		ptr:      map[string][]dns.RR{},
+		srv:      map[string][]dns.RR{},
 	}
 	h.BuildAlternateHosts(nt, lookupTable.buildDNSAnswers)
+	h.buildSRVAnswers(nt, lookupTable)
+	lookupTable.buildPTRAnswers(nt)
 	h.lookupTable.Store(lookupTable)
 	h.nameTable.Store(nt)
 	log.Debugf("updated lookup table with %d hosts", len(lookupTable.allHosts))
@@ -203,19 +230,7 @@ func (h *LocalDNSServer) BuildAlternateHosts(nt *dnsProto.NameTable,
 	apply func(map[string]struct{}, []netip.Addr, []netip.Addr, []string),
 ) {
 	for hostname, ni := range nt.Table {
-		// Given a host
-		// if its a non-k8s host, store the host+. as the key with the pre-computed DNS RR records
-		// if its a k8s host, store all variants (i.e. shortname+., shortname+namespace+., fqdn+., etc.)
-		// shortname+. is only for hosts in current namespace
-		var altHosts sets.String
-		if ni.Registry == string(provider.Kubernetes) {
-			altHosts = generateAltHosts(hostname, ni, h.proxyNamespace, h.proxyDomain, h.proxyDomainParts)
-		} else {
-			if !strings.HasSuffix(hostname, ".") {
-				hostname += "."
-			}
-			altHosts = sets.New(hostname)
-		}
+		altHosts := h.alternateHosts(hostname, ni)
 		ipv4, ipv6 := netutil.ParseIPsSplitToV4V6(ni.Ips)
 		if len(ipv6) == 0 && len(ipv4) == 0 {
 			// malformed ips
@@ -225,8 +240,127 @@ func (h *LocalDNSServer) BuildAlternateHosts(nt *dnsProto.NameTable,
 	}
 }
 
-// upstream sends the request to the upstream server, with associated logs and metrics
+// alternateHosts returns the names the host can be queried with.
+func (h *LocalDNSServer) alternateHosts(hostname string, ni *dnsProto.NameTable_NameInfo) sets.String {
+	// Given a host
+	// if its a non-k8s host, store the host+. as the key with the pre-computed DNS RR records
+	// if its a k8s host, store all variants (i.e. shortname+., shortname+namespace+., fqdn+., etc.)
+	// shortname+. is only for hosts in current namespace
+	if ni.Registry == string(provider.Kubernetes) {
+		return generateAltHosts(hostname, ni, h.proxyNamespace, h.proxyDomain, h.proxyDomainParts)
+	}
+	if !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+	return sets.New(hostname)
+}
+
+// buildSRVAnswers stores the SRV records of the named ports of the hosts, for each of their names. As for
+// A records, a variant expanded with the first search namespace is stored with a CNAME record.
+// Like Kubernetes DNS, the SRV records of a headless service point to each of its endpoints which has a
+// hostname, on the port of the endpoint, and the SRV records of other services point to the service itself.
+func (h *LocalDNSServer) buildSRVAnswers(nt *dnsProto.NameTable, table *LookupTable) {
+	endpointHosts := sets.New[string]()
+	for _, ni := range nt.Table {
+		endpointHosts.InsertAll(ni.EndpointHosts...)
+	}
+	for hostname, ni := range nt.Table {
+		if len(ni.Ports) == 0 || strings.HasPrefix(hostname, "*") || endpointHosts.Contains(hostname) {
+			continue
+		}
+		hosts := ni.EndpointHosts
+		if len(hosts) == 0 {
+			hosts = []string{hostname}
+		}
+		for _, port := range ni.Ports {
+			targets := make([]srvTarget, 0, len(hosts))
+			for _, host := range hosts {
+				targets = append(targets, srvTarget{host: host, port: targetPort(nt, host, port)})
+			}
+			for althost := range h.alternateHosts(hostname, ni) {
+				name := strings.ToLower("_" + port.Name + "._" + port.Protocol + "." + althost)
+				table.allHosts.Insert(name)
+				table.srv[name] = srv(name, targets)
+			}
+		}
+	}
+	if len(h.searchNamespaces) == 0 {
+		return
+	}
+	for _, name := range maps.Keys(table.srv) {
+		// The expanded name of a short name, like _http._tcp.productpage.ns1.svc.cluster.local., may be
+		// the name of the SRV records already.
+		expandedHost := strings.ToLower(name + strings.TrimSuffix(h.searchNamespaces[0], ".") + ".")
+		if _, exists := table.srv[expandedHost]; !exists {
+			table.cname[expandedHost] = cname(expandedHost, name)
+			table.allHosts.Insert(expandedHost)
+		}
+	}
+}
+
+// buildPTRAnswers stores the PTR records for the IPs of the hosts. The IPs of a headless service with
+// endpoint hostnames are resolved to the hostnames of the endpoints instead, like Kubernetes DNS.
+func (table *LookupTable) buildPTRAnswers(nt *dnsProto.NameTable) {
+	for hostname, ni := range nt.Table {
+		if len(ni.EndpointHosts) > 0 || strings.HasPrefix(hostname, "*") {
+			continue
+		}
+		target := strings.ToLower(dns.Fqdn(hostname))
+		for _, ip := range ni.Ips {
+			name, err := dns.ReverseAddr(ip)
+			if err != nil {
+				continue
+			}
+			if slices.ContainsFunc(table.ptr[name], func(rr dns.RR) bool { return rr.(*dns.PTR).Ptr == target }) {
+				continue
+			}
+			table.allHosts.Insert(name)
+			table.ptr[name] = append(table.ptr[name], ptr(name, target))
+		}
+	}
+	for _, records := range table.ptr {
+		// Hosts sharing an IP are returned in a stable order.
+		slices.SortFunc(records, func(a, b dns.RR) int {
+			return strings.Compare(a.(*dns.PTR).Ptr, b.(*dns.PTR).Ptr)
+		})
+	}
+}
+
+// upstream answers the request from the cache of upstream responses if possible, or sends it to the upstream
+// server. If the upstream server fails, an expired cached response may be served instead.
 func (h *LocalDNSServer) upstream(proxy *dnsProxy, req *dns.Msg, hostname string) *dns.Msg {
+	if h.cache == nil {
+		return h.forward(proxy, req, hostname)
+	}
+	if response, prefetch := h.cache.get(req); response != nil {
+		cacheHits.Increment()
+		log.Debugf("cached response for hostname %q : %v", hostname, response)
+		if prefetch {
+			go h.prefetch(proxy, req.Copy(), hostname)
+		}
+		return response
+	}
+	cacheMisses.Increment()
+	response := h.forward(proxy, req, hostname)
+	if response.Rcode == dns.RcodeServerFailure {
+		if stale := h.cache.getStale(req); stale != nil {
+			cacheStaleResponses.Increment()
+			log.Debugf("serving stale response for hostname %q : %v", hostname, stale)
+			return stale
+		}
+	}
+	h.cache.add(req, response)
+	return response
+}
+
+// prefetch refreshes the cached response to the request before it expires.
+func (h *LocalDNSServer) prefetch(proxy *dnsProxy, req *dns.Msg, hostname string) {
+	cachePrefetches.Increment()
+	h.cache.add(req, h.forward(proxy, req, hostname))
+}
+
+// forward sends the request to the upstream server, with associated logs and metrics
+func (h *LocalDNSServer) forward(proxy *dnsProxy, req *dns.Msg, hostname string) *dns.Msg {
 	upstreamRequests.Increment()
 	start := time.Now()
 	// We did not find the host in our internal cache. Query upstream and return the response as is.
@@ -386,6 +520,11 @@ func (h *LocalDNSServer) Close() {
 	for _, p := range h.dnsProxies {
 		p.close()
 	}
+	for _, u := range h.upstreams {
+		if c, ok := u.upstreamResolver.(interface{ close() }); ok {
+			c.close()
+		}
+	}
 }
 
 func (h *LocalDNSServer) queryUpstream(upstreamClient *dns.Client, req *dns.Msg, scope *istiolog.Scope) *dns.Msg {
@@ -395,8 +534,10 @@ func (h *LocalDNSServer) queryUpstream(upstreamClient *dns.Client, req *dns.Msg,
 
 	var response *dns.Msg
 
-	for _, upstream := range h.resolvConfServers {
-		cResponse, _, err := upstreamClient.Exchange(req, upstream)
+	// Upstream servers are tried in order of preference, the ones which recently failed last.
+	for _, upstream := range orderUpstreams(h.upstreams) {
+		cResponse, err := upstream.exchange(context.Background(), upstreamClient, req)
+		upstream.record(err)
 		if err == nil {
 			response = cResponse
 			break
@@ -428,9 +569,13 @@ func (h *LocalDNSServer) queryUpstreamParallel(upstreamClient *dns.Client, req *
 	responseCh := make(chan *dns.Msg)
 	errCh := make(chan error)
 
-	queryOne := func(upstream string) {
+	queryOne := func(upstream *upstream) {
 		// Note: After DialContext in ExchangeContext is called, this function cannot be cancelled by context.
-		cResponse, _, err := upstreamClient.ExchangeContext(ctx, req, upstream)
+		cResponse, err := upstream.exchange(ctx, upstreamClient, req)
+		if ctx.Err() == nil {
+			// Queries cancelled after the first response are not failures of the server.
+			upstream.record(err)
+		}
 		if err == nil {
 			// Only reserve first response and ignore others.
 			select {
@@ -446,7 +591,7 @@ func (h *LocalDNSServer) queryUpstreamParallel(upstreamClient *dns.Client, req *
 		}
 	}
 
-	for _, upstream := range h.resolvConfServers {
+	for _, upstream := range h.upstreams {
 		go queryOne(upstream)
 	}
 
@@ -459,7 +604,7 @@ func (h *LocalDNSServer) queryUpstreamParallel(upstreamClient *dns.Client, req *
 		case <-errCh:
 			errorsCount++
 			// All servers returned error - return failure.
-			if errorsCount == len(h.resolvConfServers) {
+			if errorsCount == len(h.upstreams) {
 				scope.Infof("all upstream failed")
 				return serverFailure(req)
 			}
@@ -554,8 +699,11 @@ func (table *LookupTable) lookupHost(qtype uint16, hostname string) ([]dns.RR, b
 		ipAnswers = table.name4[hostname]
 	case dns.TypeAAAA:
 		ipAnswers = table.name6[hostname]
+	case dns.TypePTR:
+		ipAnswers = table.ptr[hostname]
+	case dns.TypeSRV:
+		ipAnswers = table.srv[hostname]
 	default:
-		// TODO: handle PTR records for reverse dns lookups
 		return nil, false
 	}
 
@@ -675,6 +823,56 @@ func cname(host string, targetHost string) []dns.RR {
 	return []dns.RR{answer}
 }
 
+// ptr returns a PTR RR from the reverse name of an IP to a host.
+func ptr(name string, host string) dns.RR {
+	answer := new(dns.PTR)
+	answer.Hdr = dns.RR_Header{
+		Name:   name,
+		Rrtype: dns.TypePTR,
+		Class:  dns.ClassINET,
+		Ttl:    defaultTTLInSeconds,
+	}
+	answer.Ptr = host
+	return answer
+}
+
+// srvTarget is a host and port an SRV record points to.
+type srvTarget struct {
+	host string
+	port uint32
+}
+
+// targetPort returns the port an SRV record for a port of a host points to, on the given target. The
+// endpoints of a headless service carry their own named ports in the name table, as they may listen on
+// another port than the service.
+func targetPort(nt *dnsProto.NameTable, target string, port *dnsProto.NameTable_Port) uint32 {
+	if ni, f := nt.Table[target]; f {
+		for _, p := range ni.Ports {
+			if p.Name == port.Name && p.Protocol == port.Protocol {
+				return p.Port
+			}
+		}
+	}
+	return port.Port
+}
+
+// srv returns a slice of SRV RRs for each of the targets. As with Kubernetes DNS, the weight is shared
+// equally among the targets. It is at least 1, since a weight of 0 makes a target a last resort.
+func srv(name string, targets []srvTarget) []dns.RR {
+	answers := make([]dns.RR, len(targets))
+	weight := uint16(max(100/len(targets), 1))
+	for i, target := range targets {
+		r := new(dns.SRV)
+		r.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: defaultTTLInSeconds}
+		r.Priority = 0
+		r.Weight = weight
+		r.Port = uint16(target.port)
+		r.Target = strings.ToLower(dns.Fqdn(target.host))
+		answers[i] = r
+	}
+	return answers
+}
+
 // Size returns if buffer size *advertised* in the requests OPT record.
 // Or when the request was over TCP, we return the maximum allowed size of 64K.
 func size(proto string, r *dns.Msg) int {
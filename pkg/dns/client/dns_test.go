@@ -41,9 +41,24 @@ func TestDNS(t *testing.T) {
 	testDNS(t, d)
 }
 
+func TestSRVWeight(t *testing.T) {
+	cases := map[int]uint16{1: 100, 3: 33, 100: 1, 150: 1}
+	for n, weight := range cases {
+		targets := make([]srvTarget, n)
+		for i := range targets {
+			targets[i] = srvTarget{host: fmt.Sprintf("host-%d.ns1.svc.cluster.local", i), port: 80}
+		}
+		for _, rr := range srv("_http._tcp.headless.ns1.svc.cluster.local.", targets) {
+			if w := rr.(*dns.SRV).Weight; w != weight {
+				t.Fatalf("got weight %d for %d targets, want %d", w, n, weight)
+			}
+		}
+	}
+}
+
 func TestBuildAlternateHosts(t *testing.T) {
 	// Create the server instance without starting it, as it's unnecessary for this test
-	d, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", false)
+	d, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", false, CacheOptions{}, UpstreamOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -131,6 +146,7 @@ func testDNS(t *testing.T, d *LocalDNSServer) {
 		host                     string
 		id                       int
 		queryAAAA                bool
+		qtype                    uint16
 		expected                 []dns.RR
 		expectResolutionFailure  int
 		expectExternalResolution bool
@@ -337,6 +353,75 @@ func testDNS(t *testing.T, d *LocalDNSServer) {
 			host:     "example.localhost.",
 			expected: a("example.localhost.", []netip.Addr{netip.MustParseAddr("3.3.3.3")}),
 		},
+		{
+			name:     "success: SRV query for k8s host - fqdn",
+			host:     "_http._tcp.productpage.ns1.svc.cluster.local.",
+			qtype:    dns.TypeSRV,
+			expected: srv("_http._tcp.productpage.ns1.svc.cluster.local.", []srvTarget{{host: "productpage.ns1.svc.cluster.local", port: 9080}}),
+		},
+		{
+			name:     "success: SRV query for k8s host - shortname",
+			host:     "_http._tcp.productpage.",
+			qtype:    dns.TypeSRV,
+			expected: srv("_http._tcp.productpage.", []srvTarget{{host: "productpage.ns1.svc.cluster.local", port: 9080}}),
+		},
+		{
+			name:  "success: SRV query for k8s host (name.namespace) with search namespace yields cname+SRV record",
+			host:  "_http._tcp.productpage.ns1.ns1.svc.cluster.local.",
+			qtype: dns.TypeSRV,
+			expected: append(cname("_http._tcp.productpage.ns1.ns1.svc.cluster.local.", "_http._tcp.productpage.ns1."),
+				srv("_http._tcp.productpage.ns1.", []srvTarget{{host: "productpage.ns1.svc.cluster.local", port: 9080}})...),
+		},
+		{
+			name:  "success: SRV query for headless service returns the endpoints",
+			host:  "_tcp-mysql._tcp.mysql.ns1.svc.cluster.local.",
+			qtype: dns.TypeSRV,
+			expected: srv("_tcp-mysql._tcp.mysql.ns1.svc.cluster.local.", []srvTarget{
+				{host: "mysql-0.mysql.ns1.svc.cluster.local", port: 3307},
+				{host: "mysql-1.mysql.ns1.svc.cluster.local", port: 3307},
+			}),
+		},
+		{
+			name:     "success: SRV query for non k8s host",
+			host:     "_https._tcp.www.google.com.",
+			qtype:    dns.TypeSRV,
+			expected: srv("_https._tcp.www.google.com.", []srvTarget{{host: "www.google.com", port: 443}}),
+		},
+		{
+			// This is not a NXDOMAIN, but empty response
+			name: "success: A query for SRV name",
+			host: "_http._tcp.productpage.",
+		},
+		{
+			name:     "success: PTR query for service IP",
+			host:     "9.9.9.9.in-addr.arpa.",
+			qtype:    dns.TypePTR,
+			expected: []dns.RR{ptr("9.9.9.9.in-addr.arpa.", "productpage.ns1.svc.cluster.local.")},
+		},
+		{
+			name:  "success: PTR query for IP of several hosts",
+			host:  "2.2.2.2.in-addr.arpa.",
+			qtype: dns.TypePTR,
+			expected: []dns.RR{
+				ptr("2.2.2.2.in-addr.arpa.", "dual.localhost."),
+				ptr("2.2.2.2.in-addr.arpa.", "ipv4.localhost."),
+			},
+		},
+		{
+			name:  "success: PTR query for IPv6",
+			host:  "9.2.3.8.2.4.0.0.0.0.f.f.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+			qtype: dns.TypePTR,
+			expected: []dns.RR{
+				ptr("9.2.3.8.2.4.0.0.0.0.f.f.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "dual.localhost."),
+				ptr("9.2.3.8.2.4.0.0.0.0.f.f.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "ipv6.localhost."),
+			},
+		},
+		{
+			name:     "success: PTR query for headless service endpoint returns the endpoint hostname",
+			host:     "1.0.0.20.in-addr.arpa.",
+			qtype:    dns.TypePTR,
+			expected: []dns.RR{ptr("1.0.0.20.in-addr.arpa.", "mysql-0.mysql.ns1.svc.cluster.local.")},
+		},
 	}
 
 	clients := []dns.Client{
@@ -373,6 +458,9 @@ func testDNS(t *testing.T, d *LocalDNSServer) {
 				if tt.queryAAAA {
 					q = dns.TypeAAAA
 				}
+				if tt.qtype != 0 {
+					q = tt.qtype
+				}
 				m.SetQuestion(tt.host, q)
 				if tt.modifyReq != nil {
 					tt.modifyReq(m)
@@ -589,11 +677,11 @@ func makeUpstream(t test.Failer, responses map[string]string) string {
 
 func initDNS(t test.Failer, forwardToUpstreamParallel bool) *LocalDNSServer {
 	srv := makeUpstream(t, map[string]string{"www.bing.com.": "1.1.1.1"})
-	testAgentDNS, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", forwardToUpstreamParallel)
+	testAgentDNS, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", forwardToUpstreamParallel, CacheOptions{}, UpstreamOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	testAgentDNS.resolvConfServers = []string{srv}
+	testAgentDNS.upstreams = plainUpstreams([]string{srv})
 	testAgentDNS.StartDNS()
 	fillTable(testAgentDNS)
 	t.Cleanup(testAgentDNS.Close)
@@ -607,12 +695,36 @@ func fillTable(server *LocalDNSServer) {
 			"www.google.com": {
 				Ips:      []string{"1.1.1.1"},
 				Registry: "External",
+				Ports:    []*dnsProto.NameTable_Port{{Name: "https", Port: 443, Protocol: "tcp"}},
 			},
 			"productpage.ns1.svc.cluster.local": {
 				Ips:       []string{"9.9.9.9"},
 				Registry:  "Kubernetes",
 				Namespace: "ns1",
 				Shortname: "productpage",
+				Ports:     []*dnsProto.NameTable_Port{{Name: "http", Port: 9080, Protocol: "tcp"}},
+			},
+			"mysql.ns1.svc.cluster.local": {
+				Ips:           []string{"20.0.0.1", "20.0.0.2"},
+				Registry:      "Kubernetes",
+				Namespace:     "ns1",
+				Shortname:     "mysql",
+				Ports:         []*dnsProto.NameTable_Port{{Name: "tcp-mysql", Port: 3306, Protocol: "tcp"}},
+				EndpointHosts: []string{"mysql-0.mysql.ns1.svc.cluster.local", "mysql-1.mysql.ns1.svc.cluster.local"},
+			},
+			"mysql-0.mysql.ns1.svc.cluster.local": {
+				Ips:       []string{"20.0.0.1"},
+				Registry:  "Kubernetes",
+				Namespace: "ns1",
+				Shortname: "mysql-0.mysql",
+				Ports:     []*dnsProto.NameTable_Port{{Name: "tcp-mysql", Port: 3307, Protocol: "tcp"}},
+			},
+			"mysql-1.mysql.ns1.svc.cluster.local": {
+				Ips:       []string{"20.0.0.2"},
+				Registry:  "Kubernetes",
+				Namespace: "ns1",
+				Shortname: "mysql-1.mysql",
+				Ports:     []*dnsProto.NameTable_Port{{Name: "tcp-mysql", Port: 3307, Protocol: "tcp"}},
 			},
 			"example.ns2.svc.cluster.local": {
 				Ips:       []string{"10.10.10.10"},
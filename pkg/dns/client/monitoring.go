@@ -19,6 +19,8 @@ import (
 )
 
 var (
+	upstreamTag = monitoring.CreateLabel("upstream")
+
 	requests = monitoring.NewSum(
 		"dns_requests_total",
 		"Total number of DNS requests.",
@@ -34,9 +36,34 @@ var (
 		"Total number of DNS failures.",
 	)
 
+	upstreamHealthy = monitoring.NewGauge(
+		"dns_upstream_healthy",
+		"Whether an upstream DNS server is healthy (1) or skipped after failures (0).",
+	)
+
 	requestDuration = monitoring.NewDistribution(
 		"dns_upstream_request_duration_seconds",
 		"Total time in seconds Istio takes to get DNS response from upstream.",
 		[]float64{.001, .005, 0.01, 0.1, 1, 5},
 	)
+
+	cacheHits = monitoring.NewSum(
+		"dns_cache_hits_total",
+		"Total number of DNS requests answered from the cache of upstream responses.",
+	)
+
+	cacheMisses = monitoring.NewSum(
+		"dns_cache_misses_total",
+		"Total number of DNS requests not found in the cache of upstream responses.",
+	)
+
+	cacheStaleResponses = monitoring.NewSum(
+		"dns_cache_stale_responses_total",
+		"Total number of expired cached responses served because upstream failed.",
+	)
+
+	cachePrefetches = monitoring.NewSum(
+		"dns_cache_prefetches_total",
+		"Total number of cached responses refreshed from upstream before they expired.",
+	)
 )
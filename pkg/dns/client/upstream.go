@@ -0,0 +1,383 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// upstreamTimeout is the timeout of a query to an upstream server.
+	upstreamTimeout = 5 * time.Second
+	// minUnhealthyDuration is how long an upstream server is skipped after a failure. It doubles with each
+	// consecutive failure, up to maxUnhealthyDuration.
+	minUnhealthyDuration = 5 * time.Second
+	maxUnhealthyDuration = time.Minute
+	// maxIdleTLSConns is the number of idle connections kept to a DNS-over-TLS server.
+	maxIdleTLSConns = 4
+	// dohMediaType is the media type of DNS-over-HTTPS requests and responses, defined in RFC 8484.
+	dohMediaType = "application/dns-message"
+)
+
+// UpstreamOptions configures the upstream servers of the DNS proxy.
+type UpstreamOptions struct {
+	// Servers are the upstream servers, in order of preference, as URLs:
+	//   - udp://host:port and tcp://host:port for plain DNS over UDP or TCP.
+	//   - tls://ip:port for DNS-over-TLS (RFC 7858). The port defaults to 853.
+	//   - https://ip[:port]/path for DNS-over-HTTPS (RFC 8484).
+	// The host of TLS servers must be an IP, since resolving their name would leak it in plain DNS. The name
+	// of the server, used to verify its certificate and as the HTTP host, is set with a servername query
+	// parameter (e.g. tls://1.1.1.1?servername=cloudflare-dns.com). If not set, the certificate must be
+	// issued for the IP.
+	// If empty, the nameservers of resolv.conf are used, with the protocol of the downstream query.
+	Servers []string
+	// RootCertFile is the file with the PEM encoded root certificates used to verify TLS servers. If empty,
+	// the system roots are used.
+	RootCertFile string
+	// ClientCertificate, if set, returns the certificate presented to TLS servers, such as the workload
+	// certificate.
+	ClientCertificate func() (*tls.Certificate, error)
+}
+
+// upstreamResolver sends queries to an upstream server.
+type upstreamResolver interface {
+	// exchange sends the request to the server. plain is the client for plain DNS, using the protocol of the
+	// downstream query.
+	exchange(ctx context.Context, plain *dns.Client, req *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// upstream is an upstream server, with its health. An upstream server is unhealthy for some time after a failure.
+type upstream struct {
+	upstreamResolver
+
+	mu             sync.Mutex
+	failures       int
+	unhealthyUntil time.Time
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.unhealthyUntil)
+}
+
+// record updates the health of the server with the result of a query.
+func (u *upstream) record(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err == nil {
+		if u.failures > 0 {
+			log.Infof("upstream %v is healthy", u)
+			upstreamHealthy.With(upstreamTag.Value(u.String())).Record(1)
+		}
+		u.failures = 0
+		u.unhealthyUntil = time.Time{}
+		return
+	}
+	u.failures++
+	d := maxUnhealthyDuration
+	if u.failures < 8 {
+		d = min(minUnhealthyDuration<<(u.failures-1), maxUnhealthyDuration)
+	}
+	u.unhealthyUntil = time.Now().Add(d)
+	if u.failures == 1 {
+		log.Infof("upstream %v is unhealthy: %v", u, err)
+		upstreamHealthy.With(upstreamTag.Value(u.String())).Record(0)
+	}
+}
+
+// orderUpstreams returns the healthy upstream servers followed by the unhealthy ones, each in order of preference.
+// Unhealthy servers are kept as a last resort, in case all servers are unhealthy.
+func orderUpstreams(upstreams []*upstream) []*upstream {
+	now := time.Now()
+	out := make([]*upstream, 0, len(upstreams))
+	var unhealthy []*upstream
+	for _, u := range upstreams {
+		if u.healthy(now) {
+			out = append(out, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	return append(out, unhealthy...)
+}
+
+// plainUpstreams returns the upstream servers for nameservers, queried with the protocol of the downstream query.
+func plainUpstreams(nameservers []string) []*upstream {
+	out := make([]*upstream, 0, len(nameservers))
+	for _, ns := range nameservers {
+		out = append(out, &upstream{upstreamResolver: &plainUpstream{address: ns}})
+	}
+	return out
+}
+
+// newUpstreams returns the upstream servers configured by opts.
+func newUpstreams(opts UpstreamOptions) ([]*upstream, error) {
+	var tlsConfig *tls.Config
+	out := make([]*upstream, 0, len(opts.Servers))
+	for _, server := range opts.Servers {
+		u, err := url.Parse(strings.TrimSpace(server))
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream DNS server %q: %v", server, err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid upstream DNS server %q: missing host", server)
+		}
+		var r upstreamResolver
+		switch u.Scheme {
+		case "udp", "tcp":
+			r = &plainUpstream{address: hostPort(u.Host, "53"), network: u.Scheme}
+		case "tls", "https":
+			if _, err := netip.ParseAddr(u.Hostname()); err != nil {
+				return nil, fmt.Errorf("invalid upstream DNS server %q: the host must be an IP, with the name of the server "+
+					"in the servername parameter", server)
+			}
+			if tlsConfig == nil {
+				if tlsConfig, err = newUpstreamTLSConfig(opts); err != nil {
+					return nil, err
+				}
+			}
+			config := tlsConfig.Clone()
+			config.ServerName = u.Hostname()
+			name := u.Query().Get("servername")
+			if name != "" {
+				config.ServerName = name
+			}
+			if u.Scheme == "tls" {
+				r = newTLSUpstream(hostPort(u.Host, "853"), config)
+			} else {
+				u.RawQuery = ""
+				r = newHTTPSUpstream(u.String(), name, config)
+			}
+		default:
+			return nil, fmt.Errorf("invalid upstream DNS server %q: unsupported scheme %q", server, u.Scheme)
+		}
+		out = append(out, &upstream{upstreamResolver: r})
+	}
+	return out, nil
+}
+
+func hostPort(host, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, defaultPort)
+}
+
+func newUpstreamTLSConfig(opts UpstreamOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.RootCertFile != "" {
+		pem, err := os.ReadFile(opts.RootCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the root certificates of upstream DNS servers: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no root certificates of upstream DNS servers found in %s", opts.RootCertFile)
+		}
+	}
+	if opts.ClientCertificate != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := opts.ClientCertificate()
+			if err != nil {
+				// Servers which do not require a client certificate can still be used.
+				log.Warnf("no client certificate for upstream DNS server: %v", err)
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		}
+	}
+	return config, nil
+}
+
+// plainUpstream is a plain DNS server.
+type plainUpstream struct {
+	address string
+	// network is the protocol used to query the server, or empty to use the protocol of the downstream query.
+	network string
+}
+
+func (p *plainUpstream) exchange(ctx context.Context, plain *dns.Client, req *dns.Msg) (*dns.Msg, error) {
+	client := plain
+	if p.network != "" && p.network != plain.Net {
+		c := *plain
+		c.Net = p.network
+		client = &c
+	}
+	res, _, err := client.ExchangeContext(ctx, req, p.address)
+	return res, err
+}
+
+func (p *plainUpstream) String() string {
+	if p.network == "" {
+		return p.address
+	}
+	return p.network + "://" + p.address
+}
+
+// tlsUpstream is a DNS-over-TLS server. Connections are reused between queries.
+type tlsUpstream struct {
+	address string
+	client  *dns.Client
+	idle    chan *dns.Conn
+}
+
+func newTLSUpstream(address string, config *tls.Config) *tlsUpstream {
+	return &tlsUpstream{
+		address: address,
+		client: &dns.Client{
+			Net:       "tcp-tls",
+			TLSConfig: config,
+			Timeout:   upstreamTimeout,
+		},
+		idle: make(chan *dns.Conn, maxIdleTLSConns),
+	}
+}
+
+func (t *tlsUpstream) exchange(ctx context.Context, _ *dns.Client, req *dns.Msg) (*dns.Msg, error) {
+	var conn *dns.Conn
+	select {
+	case conn = <-t.idle:
+	default:
+	}
+	if conn != nil {
+		// The server may have closed an idle connection, in which case the query is retried on a new one.
+		if res, _, err := t.client.ExchangeWithConnContext(ctx, req, conn); err == nil {
+			t.release(conn)
+			return res, nil
+		}
+		_ = conn.Close()
+	}
+	conn, err := t.client.DialContext(ctx, t.address)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := t.client.ExchangeWithConnContext(ctx, req, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	t.release(conn)
+	return res, nil
+}
+
+func (t *tlsUpstream) release(conn *dns.Conn) {
+	select {
+	case t.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+func (t *tlsUpstream) close() {
+	for {
+		select {
+		case conn := <-t.idle:
+			_ = conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (t *tlsUpstream) String() string {
+	return "tls://" + t.address
+}
+
+// httpsUpstream is a DNS-over-HTTPS server.
+type httpsUpstream struct {
+	url string
+	// host is the HTTP host of the requests, if not the IP of the url.
+	host   string
+	client *http.Client
+}
+
+func newHTTPSUpstream(url, host string, config *tls.Config) *httpsUpstream {
+	return &httpsUpstream{
+		url:  url,
+		host: host,
+		client: &http.Client{
+			Timeout: upstreamTimeout,
+			Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				TLSClientConfig:   config,
+				ForceAttemptHTTP2: true,
+				IdleConnTimeout:   90 * time.Second,
+			},
+		},
+	}
+}
+
+func (h *httpsUpstream) exchange(ctx context.Context, _ *dns.Client, req *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 section 4.1: the ID should be 0, to make responses cacheable by HTTP caches.
+	q := req.Copy()
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if h.host != "" {
+		httpReq.Host = h.host
+	}
+	httpReq.Header.Set("Content-Type", dohMediaType)
+	httpReq.Header.Set("Accept", dohMediaType)
+	httpRes, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", httpRes.StatusCode, h.url)
+	}
+	// A DNS message is at most 64KB.
+	b, err := io.ReadAll(io.LimitReader(httpRes.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+	res := new(dns.Msg)
+	if err := res.Unpack(b); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %v", h.url, err)
+	}
+	res.Id = req.Id
+	return res, nil
+}
+
+func (h *httpsUpstream) close() {
+	h.client.CloseIdleConnections()
+}
+
+func (h *httpsUpstream) String() string {
+	return h.url
+}
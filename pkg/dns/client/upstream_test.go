@@ -0,0 +1,352 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func TestNewUpstreams(t *testing.T) {
+	cases := []struct {
+		server     string
+		expected   string
+		serverName string
+		err        bool
+	}{
+		{server: "udp://10.0.0.1", expected: "udp://10.0.0.1:53"},
+		{server: "tcp://10.0.0.1:5353", expected: "tcp://10.0.0.1:5353"},
+		{server: "tls://1.1.1.1", expected: "tls://1.1.1.1:853", serverName: "1.1.1.1"},
+		{server: " tls://[2606:4700::1111]:8853", expected: "tls://[2606:4700::1111]:8853", serverName: "2606:4700::1111"},
+		{server: "tls://1.1.1.1?servername=cloudflare-dns.com", expected: "tls://1.1.1.1:853", serverName: "cloudflare-dns.com"},
+		{server: "https://8.8.8.8/dns-query", expected: "https://8.8.8.8/dns-query", serverName: "8.8.8.8"},
+		{server: "https://8.8.8.8/dns-query?servername=dns.google", expected: "https://8.8.8.8/dns-query", serverName: "dns.google"},
+		// Resolving the name of the server would leak it in plain DNS.
+		{server: "https://dns.google/dns-query", err: true},
+		{server: "tls://cloudflare-dns.com", err: true},
+		{server: "quic://1.1.1.1", err: true},
+		{server: "1.1.1.1", err: true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.server, func(t *testing.T) {
+			upstreams, err := newUpstreams(UpstreamOptions{Servers: []string{tt.server}})
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, upstreams[0].String(), tt.expected)
+			switch r := upstreams[0].upstreamResolver.(type) {
+			case *tlsUpstream:
+				assert.Equal(t, r.client.TLSConfig.ServerName, tt.serverName)
+			case *httpsUpstream:
+				assert.Equal(t, r.client.Transport.(*http.Transport).TLSClientConfig.ServerName, tt.serverName)
+				if r.host != "" {
+					assert.Equal(t, r.host, tt.serverName)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	upstreams := plainUpstreams([]string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"})
+	names := func() []string {
+		var out []string
+		for _, u := range orderUpstreams(upstreams) {
+			out = append(out, u.String())
+		}
+		return out
+	}
+	assert.Equal(t, names(), []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"})
+
+	// Failed servers are tried last, in order of preference
+	upstreams[1].record(errors.New("timeout"))
+	upstreams[0].record(errors.New("timeout"))
+	assert.Equal(t, names(), []string{"10.0.0.3:53", "10.0.0.1:53", "10.0.0.2:53"})
+
+	upstreams[0].record(nil)
+	assert.Equal(t, names(), []string{"10.0.0.1:53", "10.0.0.3:53", "10.0.0.2:53"})
+
+	// Consecutive failures back off up to the maximum
+	for i := 0; i < 100; i++ {
+		upstreams[1].record(errors.New("timeout"))
+	}
+	assert.Equal(t, upstreams[1].unhealthyUntil.Before(time.Now().Add(maxUnhealthyDuration+time.Second)), true)
+	assert.Equal(t, upstreams[1].unhealthyUntil.After(time.Now().Add(maxUnhealthyDuration-time.Second)), true)
+}
+
+// testPKI holds a root certificate, and certificates for a DNS server on 127.0.0.1 and for a client.
+type testPKI struct {
+	rootFile string
+	roots    *x509.CertPool
+	server   tls.Certificate
+	client   tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &key.PublicKey, key)
+	assert.NoError(t, err)
+	root, err = x509.ParseCertificate(rootDER)
+	assert.NoError(t, err)
+
+	issue := func(serial int64, template *x509.Certificate) tls.Certificate {
+		leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore = root.NotBefore
+		template.NotAfter = root.NotAfter
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, template, root, &leafKey.PublicKey, key)
+		assert.NoError(t, err)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: leafKey}
+	}
+	p := &testPKI{
+		rootFile: filepath.Join(t.TempDir(), "root-cert.pem"),
+		roots:    x509.NewCertPool(),
+		server: issue(2, &x509.Certificate{
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}),
+		client: issue(3, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "client"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}),
+	}
+	p.roots.AddCert(root)
+	assert.NoError(t, os.WriteFile(p.rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}), 0o600))
+	return p
+}
+
+// serverTLSConfig returns the TLS config of a stub server, requiring a client certificate if requested.
+func (p *testPKI) serverTLSConfig(requireClientCert bool) *tls.Config {
+	config := &tls.Config{Certificates: []tls.Certificate{p.server}, MinVersion: tls.VersionTLS12}
+	if requireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = p.roots
+	}
+	return config
+}
+
+// stubAnswer answers A queries with 10.0.0.1, counting the queries.
+func stubAnswer(queries *atomic.Int32, req *dns.Msg) *dns.Msg {
+	queries.Inc()
+	res := new(dns.Msg)
+	res.SetReply(req)
+	res.Answer = a(req.Question[0].Name, []netip.Addr{netip.MustParseAddr("10.0.0.1")})
+	return res
+}
+
+// startDoTStub starts a DNS-over-TLS stub resolver, and returns its address.
+func startDoTStub(t *testing.T, config *tls.Config, queries *atomic.Int32) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	assert.NoError(t, err)
+	up := make(chan struct{})
+	server := &dns.Server{
+		Listener:          l,
+		Net:               "tcp-tls",
+		NotifyStartedFunc: func() { close(up) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			_ = w.WriteMsg(stubAnswer(queries, req))
+		}),
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-up
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return l.Addr().String()
+}
+
+// startDoHStub starts a DNS-over-HTTPS stub resolver, and returns its URL.
+func startDoHStub(t *testing.T, config *tls.Config, queries *atomic.Int32) string {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != dohMediaType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if err != nil || req.Unpack(b) != nil || req.Id != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res, err := stubAnswer(queries, req).Pack()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(res)
+	}))
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL + "/dns-query"
+}
+
+func newUpstreamTestServer(t *testing.T, opts UpstreamOptions) *LocalDNSServer {
+	t.Helper()
+	d, err := NewLocalDNSServer("ns1", "ns1.svc.cluster.local", "localhost:0", false, CacheOptions{}, opts)
+	assert.NoError(t, err)
+	d.StartDNS()
+	fillTable(d)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func queryA(t *testing.T, d *LocalDNSServer, host string) *dns.Msg {
+	t.Helper()
+	client := dns.Client{Net: "udp", Timeout: 10 * time.Second}
+	res, _, err := client.Exchange(question(host, dns.TypeA), d.dnsProxies[0].Address())
+	assert.NoError(t, err)
+	return res
+}
+
+func TestEncryptedUpstreams(t *testing.T) {
+	pki := newTestPKI(t)
+	clientCert := func() (*tls.Certificate, error) {
+		return &pki.client, nil
+	}
+	noClientCert := func() (*tls.Certificate, error) {
+		return nil, errors.New("not ready")
+	}
+	cases := []struct {
+		name              string
+		doh               bool
+		requireClientCert bool
+		clientCert        func() (*tls.Certificate, error)
+		expectRcode       int
+	}{
+		{name: "dot", expectRcode: dns.RcodeSuccess},
+		{name: "dot with client certificate", requireClientCert: true, clientCert: clientCert, expectRcode: dns.RcodeSuccess},
+		{name: "dot missing client certificate", requireClientCert: true, clientCert: noClientCert, expectRcode: dns.RcodeServerFailure},
+		{name: "doh", doh: true, expectRcode: dns.RcodeSuccess},
+		{name: "doh with client certificate", doh: true, requireClientCert: true, clientCert: clientCert, expectRcode: dns.RcodeSuccess},
+		{name: "doh without client certificate", doh: true, requireClientCert: true, expectRcode: dns.RcodeServerFailure},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := atomic.NewInt32(0)
+			var server string
+			if tt.doh {
+				server = startDoHStub(t, pki.serverTLSConfig(tt.requireClientCert), queries)
+			} else {
+				server = "tls://" + startDoTStub(t, pki.serverTLSConfig(tt.requireClientCert), queries)
+			}
+			d := newUpstreamTestServer(t, UpstreamOptions{
+				Servers:           []string{server},
+				RootCertFile:      pki.rootFile,
+				ClientCertificate: tt.clientCert,
+			})
+
+			// Names of the mesh are not forwarded
+			res := queryA(t, d, "productpage.ns1.svc.cluster.local.")
+			assert.Equal(t, res.Rcode, dns.RcodeSuccess)
+			assert.Equal(t, queries.Load(), int32(0))
+
+			for i := 0; i < 3; i++ {
+				res = queryA(t, d, "www.example.com.")
+				assert.Equal(t, res.Rcode, tt.expectRcode)
+			}
+			if tt.expectRcode == dns.RcodeSuccess {
+				assert.Equal(t, res.Answer[0].(*dns.A).A.String(), "10.0.0.1")
+				assert.Equal(t, queries.Load(), int32(3))
+			}
+		})
+	}
+}
+
+func TestUntrustedUpstream(t *testing.T) {
+	pki := newTestPKI(t)
+	queries := atomic.NewInt32(0)
+	server := startDoTStub(t, pki.serverTLSConfig(false), queries)
+	// The system roots do not trust the server
+	d := newUpstreamTestServer(t, UpstreamOptions{Servers: []string{"tls://" + server}})
+	res := queryA(t, d, "www.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeServerFailure)
+	assert.Equal(t, queries.Load(), int32(0))
+}
+
+func TestUpstreamFallback(t *testing.T) {
+	pki := newTestPKI(t)
+	dotQueries := atomic.NewInt32(0)
+	dohQueries := atomic.NewInt32(0)
+	dot := startDoTStub(t, pki.serverTLSConfig(false), dotQueries)
+	doh := startDoHStub(t, pki.serverTLSConfig(false), dohQueries)
+
+	// An address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	down := l.Addr().String()
+	assert.NoError(t, l.Close())
+
+	d := newUpstreamTestServer(t, UpstreamOptions{
+		Servers:      []string{"tls://" + down, "tls://" + dot, doh},
+		RootCertFile: pki.rootFile,
+	})
+	res := queryA(t, d, "www.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, dotQueries.Load(), int32(1))
+	assert.Equal(t, d.upstreams[0].healthy(time.Now()), false)
+
+	// The failed server is skipped
+	res = queryA(t, d, "www.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, dotQueries.Load(), int32(2))
+	assert.Equal(t, d.upstreams[0].failures, 1)
+
+	// The next server is used when the preferred ones fail
+	d.upstreams[1].record(errors.New("timeout"))
+	res = queryA(t, d, "www.example.com.")
+	assert.Equal(t, res.Rcode, dns.RcodeSuccess)
+	assert.Equal(t, dohQueries.Load(), int32(1))
+	assert.Equal(t, dotQueries.Load(), int32(2))
+}
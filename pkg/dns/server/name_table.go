@@ -15,11 +15,13 @@
 package server
 
 import (
+	"slices"
 	"strings"
 
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/serviceregistry/provider"
 	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/protocol"
 	dnsProto "istio.io/istio/pkg/dns/proto"
 	netutil "istio.io/istio/pkg/util/net"
 )
@@ -44,6 +46,7 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 	for _, svc := range cfg.Node.SidecarScope.Services() {
 		svcAddress := svc.GetAddressForProxy(cfg.Node)
 		var addressList []string
+		var endpointHosts []string
 		hostName := svc.Hostname
 		if svcAddress != constants.UnspecifiedIP {
 			// Filter out things we cannot parse as IP. Generally this means CIDRs, as anything else
@@ -56,6 +59,7 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 			// The IP will be unspecified here if its headless service or if the auto
 			// IP allocation logic for service entry was unable to allocate an IP.
 			if svc.Resolution == model.Passthrough && len(svc.Ports) > 0 {
+				var ports map[string][]*dnsProto.NameTable_Port
 				for _, instance := range cfg.Push.ServiceEndpointsByPort(svc, svc.Ports[0].Port, nil) {
 					// empty addresses are possible here
 					if !netutil.IsValidIPAddress(instance.Address) {
@@ -76,14 +80,21 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 						address := []string{instance.Address}
 						shortName := instance.HostName + "." + instance.SubDomain
 						host := shortName + "." + parts[1] // Add cluster domain.
+						if ports == nil {
+							ports = endpointNamedPorts(cfg.Push, svc)
+						}
 						nameInfo := &dnsProto.NameTable_NameInfo{
 							Ips:       address,
 							Registry:  string(svc.Attributes.ServiceRegistry),
 							Namespace: svc.Attributes.Namespace,
 							Shortname: shortName,
+							Ports:     ports[instance.Address],
 						}
 
 						if _, f := out.Table[host]; !f || sameCluster {
+							if !f {
+								endpointHosts = append(endpointHosts, host)
+							}
 							// We may have the same pod in two clusters (ie mysql-0 deployed in both places).
 							// We can only return a single IP for these queries. We should prefer the local cluster,
 							// so if the entry already exists only overwrite it if the instance is in our own cluster.
@@ -113,10 +124,13 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 			}
 		}
 
+		slices.Sort(endpointHosts)
 		if ni, f := out.Table[hostName.String()]; !f {
 			nameInfo := &dnsProto.NameTable_NameInfo{
-				Ips:      addressList,
-				Registry: string(svc.Attributes.ServiceRegistry),
+				Ips:           addressList,
+				Registry:      string(svc.Attributes.ServiceRegistry),
+				Ports:         namedPorts(svc),
+				EndpointHosts: endpointHosts,
 			}
 			if svc.Attributes.ServiceRegistry == provider.Kubernetes &&
 				!strings.HasSuffix(hostName.String(), "."+constants.DefaultClusterSetLocalDomain) {
@@ -136,6 +150,8 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 			if svc.Attributes.ServiceRegistry == provider.Kubernetes {
 				ni.Ips = addressList
 				ni.Registry = string(provider.Kubernetes)
+				ni.Ports = namedPorts(svc)
+				ni.EndpointHosts = endpointHosts
 				if !strings.HasSuffix(hostName.String(), "."+constants.DefaultClusterSetLocalDomain) {
 					ni.Namespace = svc.Attributes.Namespace
 					ni.Shortname = svc.Attributes.Name
@@ -147,3 +163,39 @@ func BuildNameTable(cfg Config) *dnsProto.NameTable {
 	}
 	return out
 }
+
+// namedPorts returns the ports of the service which have a name, to be used in SRV records.
+func namedPorts(svc *model.Service) []*dnsProto.NameTable_Port {
+	var out []*dnsProto.NameTable_Port
+	for _, port := range svc.Ports {
+		if port.Name == "" {
+			continue
+		}
+		proto := "tcp"
+		if port.Protocol == protocol.UDP {
+			proto = "udp"
+		}
+		out = append(out, &dnsProto.NameTable_Port{
+			Name:     port.Name,
+			Port:     uint32(port.Port),
+			Protocol: proto,
+		})
+	}
+	return out
+}
+
+// endpointNamedPorts returns the named ports of the endpoints of a headless service by address, with the
+// ports the endpoints listen on. Like Kubernetes DNS, the SRV records of the service use them.
+func endpointNamedPorts(push *model.PushContext, svc *model.Service) map[string][]*dnsProto.NameTable_Port {
+	out := map[string][]*dnsProto.NameTable_Port{}
+	for _, port := range namedPorts(svc) {
+		for _, instance := range push.ServiceEndpointsByPort(svc, int(port.Port), nil) {
+			out[instance.Address] = append(out[instance.Address], &dnsProto.NameTable_Port{
+				Name:     port.Name,
+				Port:     instance.EndpointPort,
+				Protocol: port.Protocol,
+			})
+		}
+	}
+	return out
+}
@@ -34,7 +34,8 @@ import (
 func makeServiceInstances(proxy *model.Proxy, service *model.Service, hostname, subdomain string) map[int][]*model.IstioEndpoint {
 	instances := make(map[int][]*model.IstioEndpoint)
 	for _, port := range service.Ports {
-		instances[port.Port] = makeInstances(proxy, service, port.Port, port.Port)
+		// The endpoints listen on another port than the service.
+		instances[port.Port] = makeInstances(proxy, service, port.Port, port.Port+1)
 		instances[port.Port][0].HostName = hostname
 		instances[port.Port][0].SubDomain = subdomain
 		instances[port.Port][0].Network = proxy.Metadata.Network
@@ -192,6 +193,28 @@ func TestNameTable(t *testing.T) {
 	decoratedService.DefaultAddress = "10.0.0.7"
 	decoratedService.Attributes.ServiceRegistry = provider.Kubernetes
 
+	dnsService := &model.Service{
+		Hostname:       host.Name("dns.testns.svc.cluster.local"),
+		DefaultAddress: "10.0.0.10",
+		Ports: model.PortList{
+			&model.Port{
+				Name:     "dns",
+				Port:     53,
+				Protocol: protocol.UDP,
+			},
+			&model.Port{
+				Port:     8080,
+				Protocol: protocol.HTTP,
+			},
+		},
+		Resolution: model.ClientSideLB,
+		Attributes: model.ServiceAttributes{
+			Name:            "dns",
+			Namespace:       "testns",
+			ServiceRegistry: provider.Kubernetes,
+		},
+	}
+
 	push := model.NewPushContext()
 	push.Mesh = mesh
 	push.AddPublicServices([]*model.Service{headlessService})
@@ -204,6 +227,21 @@ func TestNameTable(t *testing.T) {
 	push.AddServiceInstances(headlessService,
 		makeServiceInstances(pod4, headlessService, "pod4", "headless-svc"))
 
+	podHosts := []string{
+		"pod1.headless-svc.testns.svc.cluster.local",
+		"pod2.headless-svc.testns.svc.cluster.local",
+		"pod3.headless-svc.testns.svc.cluster.local",
+		"pod4.headless-svc.testns.svc.cluster.local",
+	}
+	nw1PodHosts := []string{
+		"pod1.headless-svc.testns.svc.cluster.local",
+		"pod3.headless-svc.testns.svc.cluster.local",
+		"pod4.headless-svc.testns.svc.cluster.local",
+	}
+	tcpPort := []*dnsProto.NameTable_Port{{Name: "tcp-port", Port: 9000, Protocol: "tcp"}}
+	tcpTargetPort := []*dnsProto.NameTable_Port{{Name: "tcp-port", Port: 9001, Protocol: "tcp"}}
+	mysqlPort := []*dnsProto.NameTable_Port{{Name: "tcp", Port: 3306, Protocol: "tcp"}}
+
 	wpush := model.NewPushContext()
 	wpush.Mesh = mesh
 	wpush.AddPublicServices([]*model.Service{wildcardService})
@@ -241,31 +279,37 @@ func TestNameTable(t *testing.T) {
 						Ips:       []string{"1.2.3.4"},
 						Registry:  "Kubernetes",
 						Shortname: "pod1.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod2.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod2.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod3.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"19.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod3.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod4.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.16.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod4.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"headless-svc.testns.svc.cluster.local": {
-						Ips:       []string{"1.2.3.4", "9.6.7.8", "19.6.7.8", "9.16.7.8"},
-						Registry:  "Kubernetes",
-						Shortname: "headless-svc",
-						Namespace: "testns",
+						Ips:           []string{"1.2.3.4", "9.6.7.8", "19.6.7.8", "9.16.7.8"},
+						Registry:      "Kubernetes",
+						Shortname:     "headless-svc",
+						Namespace:     "testns",
+						Ports:         tcpPort,
+						EndpointHosts: podHosts,
 					},
 				},
 			},
@@ -280,25 +324,30 @@ func TestNameTable(t *testing.T) {
 						Ips:       []string{"1.2.3.4"},
 						Registry:  "Kubernetes",
 						Shortname: "pod1.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod3.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"19.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod3.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod4.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.16.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod4.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"headless-svc.testns.svc.cluster.local": {
-						Ips:       []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
-						Registry:  "Kubernetes",
-						Shortname: "headless-svc",
-						Namespace: "testns",
+						Ips:           []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
+						Registry:      "Kubernetes",
+						Shortname:     "headless-svc",
+						Namespace:     "testns",
+						Ports:         tcpPort,
+						EndpointHosts: nw1PodHosts,
 					},
 				},
 			},
@@ -313,31 +362,37 @@ func TestNameTable(t *testing.T) {
 						Ips:       []string{"1.2.3.4"},
 						Registry:  "Kubernetes",
 						Shortname: "pod1.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod2.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod2.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod3.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"19.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod3.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod4.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.16.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod4.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"headless-svc.testns.svc.cluster.local": {
-						Ips:       []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
-						Registry:  "Kubernetes",
-						Shortname: "headless-svc",
-						Namespace: "testns",
+						Ips:           []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
+						Registry:      "Kubernetes",
+						Shortname:     "headless-svc",
+						Namespace:     "testns",
+						Ports:         tcpPort,
+						EndpointHosts: podHosts,
 					},
 				},
 			},
@@ -353,31 +408,37 @@ func TestNameTable(t *testing.T) {
 						Ips:       []string{"1.2.3.4"},
 						Registry:  "Kubernetes",
 						Shortname: "pod1.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod2.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod2.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod3.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"19.6.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod3.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"pod4.headless-svc.testns.svc.cluster.local": {
 						Ips:       []string{"9.16.7.8"},
 						Registry:  "Kubernetes",
 						Shortname: "pod4.headless-svc",
+						Ports:     tcpTargetPort,
 						Namespace: "testns",
 					},
 					"headless-svc.testns.svc.cluster.local": {
-						Ips:       []string{"1.2.3.4", "9.6.7.8", "19.6.7.8", "9.16.7.8"},
-						Registry:  "Kubernetes",
-						Shortname: "headless-svc",
-						Namespace: "testns",
+						Ips:           []string{"1.2.3.4", "9.6.7.8", "19.6.7.8", "9.16.7.8"},
+						Registry:      "Kubernetes",
+						Shortname:     "headless-svc",
+						Namespace:     "testns",
+						Ports:         tcpPort,
+						EndpointHosts: podHosts,
 					},
 				},
 			},
@@ -393,6 +454,10 @@ func TestNameTable(t *testing.T) {
 						Registry:  "Kubernetes",
 						Shortname: "wildcard-svc",
 						Namespace: "testns",
+						Ports: []*dnsProto.NameTable_Port{
+							{Name: "tcp-port", Port: 9000, Protocol: "tcp"},
+							{Name: "http-port", Port: 8000, Protocol: "tcp"},
+						},
 					},
 				},
 			},
@@ -414,6 +479,7 @@ func TestNameTable(t *testing.T) {
 					"foo.bar.com": {
 						Ips:      []string{"1.2.3.4", "9.6.7.8", "19.6.7.8", "9.16.7.8"},
 						Registry: "External",
+						Ports:    tcpPort,
 					},
 				},
 			},
@@ -427,6 +493,7 @@ func TestNameTable(t *testing.T) {
 					"foo.bar.com": {
 						Ips:      []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
 						Registry: "External",
+						Ports:    tcpPort,
 					},
 				},
 			},
@@ -440,6 +507,7 @@ func TestNameTable(t *testing.T) {
 					"foo.bar.com": {
 						Ips:      []string{"1.2.3.4", "19.6.7.8", "9.16.7.8"},
 						Registry: "External",
+						Ports:    tcpPort,
 					},
 				},
 			},
@@ -458,6 +526,7 @@ func TestNameTable(t *testing.T) {
 					serviceWithVIP1.Hostname.String(): {
 						Ips:      []string{serviceWithVIP1.DefaultAddress},
 						Registry: provider.External.String(),
+						Ports:    mysqlPort,
 					},
 				},
 			},
@@ -478,6 +547,7 @@ func TestNameTable(t *testing.T) {
 						Registry:  provider.Kubernetes.String(),
 						Shortname: decoratedService.Attributes.Name,
 						Namespace: decoratedService.Attributes.Namespace,
+						Ports:     mysqlPort,
 					},
 				},
 			},
@@ -498,6 +568,28 @@ func TestNameTable(t *testing.T) {
 						Registry:  provider.Kubernetes.String(),
 						Shortname: decoratedService.Attributes.Name,
 						Namespace: decoratedService.Attributes.Namespace,
+						Ports:     mysqlPort,
+					},
+				},
+			},
+		},
+		{
+			name:  "service with udp and unnamed ports",
+			proxy: proxy,
+			push: func() *model.PushContext {
+				push := model.NewPushContext()
+				push.Mesh = mesh
+				push.AddPublicServices([]*model.Service{dnsService})
+				return push
+			}(),
+			expectedNameTable: &dnsProto.NameTable{
+				Table: map[string]*dnsProto.NameTable_NameInfo{
+					"dns.testns.svc.cluster.local": {
+						Ips:       []string{"10.0.0.10"},
+						Registry:  "Kubernetes",
+						Shortname: "dns",
+						Namespace: "testns",
+						Ports:     []*dnsProto.NameTable_Port{{Name: "dns", Port: 53, Protocol: "udp"}},
 					},
 				},
 			},
@@ -0,0 +1,175 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Capsules (RFC 9297) carry HTTP datagrams over the stream of a CONNECT-UDP request.
+// Each capsule is encoded as:
+//
+//	Capsule {
+//	  Capsule Type (i),
+//	  Capsule Length (i),
+//	  Capsule Value (..),
+//	}
+//
+// where (i) is a QUIC variable-length integer (RFC 9000, section 16).
+
+const (
+	// capsuleTypeDatagram is the type of DATAGRAM capsules, carrying one HTTP datagram.
+	capsuleTypeDatagram = 0x00
+
+	// udpContextID is the context ID of HTTP datagrams carrying UDP payloads (RFC 9298, section 4).
+	udpContextID = 0
+
+	// maxCapsuleLength bounds the length of a DATAGRAM capsule we are willing to read. It holds at most
+	// one UDP payload and its context ID.
+	maxCapsuleLength = 65535 + 8
+
+	// maxVarint is the largest value representable as a variable-length integer.
+	maxVarint = 1<<62 - 1
+)
+
+var errCapsuleTooLarge = errors.New("capsule too large")
+
+// appendVarint appends v to b, encoded as a variable-length integer using the shortest encoding.
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 63:
+		return append(b, byte(v))
+	case v <= 16383:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v <= 1073741823:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	case v <= maxVarint:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		panic(fmt.Sprintf("%d does not fit in a varint", v))
+	}
+}
+
+// readVarint reads a variable-length integer from r.
+func readVarint(r io.ByteReader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1 << (first >> 6)
+	v := uint64(first & 0x3f)
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// appendCapsule appends a capsule with the type typ and the value to b.
+func appendCapsule(b []byte, typ uint64, value []byte) []byte {
+	b = appendVarint(b, typ)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// readCapsule reads the next capsule from r. The value of a DATAGRAM capsule is returned, and is only
+// valid until the next call. The value of other capsules is discarded whatever its length, as their
+// types are unknown and must be skipped (RFC 9297, section 3.2).
+func readCapsule(r *bufio.Reader, buf []byte) (typ uint64, value []byte, err error) {
+	typ, err = readVarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := readVarint(r)
+	if err != nil {
+		return 0, nil, noEOF(err)
+	}
+	if typ != capsuleTypeDatagram {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return 0, nil, noEOF(err)
+		}
+		return typ, nil, nil
+	}
+	if length > maxCapsuleLength {
+		return 0, nil, errCapsuleTooLarge
+	}
+	if uint64(cap(buf)) < length {
+		buf = make([]byte, length)
+	}
+	value = buf[:length]
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, noEOF(err)
+	}
+	return typ, value, nil
+}
+
+// appendUDPDatagram appends a DATAGRAM capsule carrying the UDP payload to b.
+func appendUDPDatagram(b []byte, payload []byte) []byte {
+	b = appendVarint(b, capsuleTypeDatagram)
+	b = appendVarint(b, uint64(len(payload)+1))
+	b = appendVarint(b, udpContextID)
+	return append(b, payload...)
+}
+
+// readUDPDatagram reads capsules from r until a DATAGRAM capsule carrying a UDP payload is found,
+// and returns the payload. Other capsules are skipped, as required by RFC 9297.
+func readUDPDatagram(r *bufio.Reader, buf []byte) ([]byte, error) {
+	for {
+		typ, value, err := readCapsule(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		if typ != capsuleTypeDatagram {
+			continue
+		}
+		v := bytesReader(value)
+		id, err := readVarint(&v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid datagram: %v", err)
+		}
+		if id != udpContextID {
+			// Datagrams with unknown context IDs are dropped
+			continue
+		}
+		return value[len(value)-len(v):], nil
+	}
+}
+
+// bytesReader is a minimal io.ByteReader over a byte slice, consuming it as it reads.
+type bytesReader []byte
+
+func (b *bytesReader) ReadByte() (byte, error) {
+	if len(*b) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c := (*b)[0]
+	*b = (*b)[1:]
+	return c, nil
+}
+
+// noEOF converts io.EOF in the middle of a capsule to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
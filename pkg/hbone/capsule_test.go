@@ -0,0 +1,119 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func TestVarint(t *testing.T) {
+	// Examples from RFC 9000, appendix A.1
+	cases := []struct {
+		encoded string
+		value   uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652},
+		{"9d7f3e7d", 494878333},
+		{"7bbd", 15293},
+		{"25", 37},
+		{"00", 0},
+		{"3f", 63},
+		{"4040", 64},
+		{"ffffffffffffffff", maxVarint},
+	}
+	for _, tt := range cases {
+		t.Run(tt.encoded, func(t *testing.T) {
+			assert.Equal(t, hex.EncodeToString(appendVarint(nil, tt.value)), tt.encoded)
+			b, _ := hex.DecodeString(tt.encoded)
+			v, err := readVarint(bytes.NewReader(b))
+			assert.NoError(t, err)
+			assert.Equal(t, v, tt.value)
+		})
+	}
+
+	// Non-minimal encodings are valid
+	v, err := readVarint(bytes.NewReader([]byte{0x40, 0x25}))
+	assert.NoError(t, err)
+	assert.Equal(t, v, uint64(37))
+
+	if _, err := readVarint(bytes.NewReader([]byte{0x9d, 0x7f})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	if _, err := readVarint(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestCapsules(t *testing.T) {
+	var b []byte
+	b = appendUDPDatagram(b, []byte("hello"))
+	// Unknown capsule types are skipped, even if they are larger than a datagram
+	b = appendCapsule(b, 0x2a, []byte("unknown"))
+	b = appendCapsule(b, 0x2b, bytes.Repeat([]byte{'u'}, maxCapsuleLength+1))
+	// Datagrams with an unknown context ID are dropped
+	b = appendCapsule(b, capsuleTypeDatagram, append(appendVarint(nil, 2), "dropped"...))
+	b = appendUDPDatagram(b, nil)
+	b = appendUDPDatagram(b, bytes.Repeat([]byte{'x'}, 1000))
+
+	// A DATAGRAM capsule with a UDP payload is the type, the length, the context ID and the payload
+	assert.Equal(t, hex.EncodeToString(b[:8]), "000600"+hex.EncodeToString([]byte("hello")))
+
+	r := bufio.NewReader(bytes.NewReader(b))
+	read := func() string {
+		t.Helper()
+		p, err := readUDPDatagram(r, nil)
+		assert.NoError(t, err)
+		return string(p)
+	}
+	assert.Equal(t, read(), "hello")
+	assert.Equal(t, read(), "")
+	assert.Equal(t, read(), string(bytes.Repeat([]byte{'x'}, 1000)))
+	if _, err := readUDPDatagram(r, nil); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestCapsuleErrors(t *testing.T) {
+	full := appendUDPDatagram(nil, []byte("hello"))
+	cases := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"truncated type", []byte{0x40}, io.ErrUnexpectedEOF},
+		{"truncated length", []byte{0x00}, io.ErrUnexpectedEOF},
+		{"truncated value", full[:len(full)-1], io.ErrUnexpectedEOF},
+		{"truncated unknown value", appendCapsule(nil, 0x2a, []byte("unknown"))[:5], io.ErrUnexpectedEOF},
+		{"too large", appendVarint(appendVarint(nil, capsuleTypeDatagram), maxCapsuleLength+1), errCapsuleTooLarge},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readUDPDatagram(bufio.NewReader(bytes.NewReader(tt.input)), nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+	if _, err := readUDPDatagram(bufio.NewReader(bytes.NewReader(appendCapsule(nil, capsuleTypeDatagram, nil))), nil); err == nil {
+		t.Fatalf("expected error for datagram without context ID")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tcpAddr is the address of the target of a CONNECT stream, which may be a host name.
+type tcpAddr string
+
+func (a tcpAddr) Network() string { return "tcp" }
+
+func (a tcpAddr) String() string { return string(a) }
+
+// TCPConn is a TCP connection tunneled over a CONNECT stream. Reads come from the response body
+// and writes go to the request body. Deadlines apply to blocked reads and writes, as with net.Conn.
+type TCPConn struct {
+	st     *stream
+	target net.Addr
+	local  net.Addr
+
+	rmu sync.Mutex
+	// pending is a read from the stream, which may have outlived the Read that started it. It is
+	// completed by the next Read.
+	pending *pendingRead
+	// rbuf is the buffer of the reads from the stream, reused once their data is consumed.
+	rbuf []byte
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type pendingRead struct {
+	buf  []byte
+	err  error
+	done chan struct{}
+}
+
+var _ net.Conn = &TCPConn{}
+
+func newTCPConn(st *stream, target string, local net.Addr) *TCPConn {
+	return &TCPConn{
+		st:            st,
+		target:        tcpAddr(target),
+		local:         local,
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Read reads from the stream. The read deadline interrupts a blocked Read, even if it is set after
+// the Read started.
+func (c *TCPConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	if c.pending == nil {
+		// The body can not be interrupted by a deadline, so it is read in the background. If the
+		// deadline is exceeded first, the data is returned by the next Read.
+		if cap(c.rbuf) < len(p) {
+			c.rbuf = make([]byte, len(p))
+		}
+		pr := &pendingRead{buf: c.rbuf[:len(p)], done: make(chan struct{})}
+		go func() {
+			defer close(pr.done)
+			n, err := c.st.resp.Body.Read(pr.buf)
+			pr.buf, pr.err = pr.buf[:n], err
+		}()
+		c.pending = pr
+	}
+	select {
+	case <-c.pending.done:
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+	pr := c.pending
+	n := copy(p, pr.buf)
+	pr.buf = pr.buf[n:]
+	if len(pr.buf) > 0 {
+		return n, nil
+	}
+	c.pending = nil
+	return n, c.closedError(pr.err)
+}
+
+// Write writes to the stream. The write deadline interrupts a Write blocked by flow control, which
+// returns the number of bytes sent so far.
+func (c *TCPConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	n, err := c.st.body.write(p, c.writeDeadline.wait())
+	return n, c.closedError(err)
+}
+
+// CloseWrite ends the request body, which half-closes the connection to the target. Reads are still
+// possible until the target closes its side.
+func (c *TCPConn) CloseWrite() error {
+	return c.st.body.Close()
+}
+
+// Close closes the stream.
+func (c *TCPConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.st.body.Close()
+		_ = c.st.resp.Body.Close()
+		c.st.cancel()
+	})
+	return nil
+}
+
+// closedError returns net.ErrClosed for the errors caused by closing the connection.
+func (c *TCPConn) closedError(err error) error {
+	if err != nil && isClosed(c.closed) {
+		return net.ErrClosed
+	}
+	return err
+}
+
+// LocalAddr returns the local address of the connection to the proxy.
+func (c *TCPConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the address of the target.
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return c.target
+}
+
+func (c *TCPConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *TCPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *TCPConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// bodyPipe is the request body of a CONNECT stream, read by the HTTP/2 transport as it sends the data.
+// Unlike io.Pipe, a write can be interrupted: the data is handed to the transport in chunks, so that
+// the written count is exact.
+type bodyPipe struct {
+	// wmu serializes the writes.
+	wmu sync.Mutex
+	// wr hands the data being written to the reader, which sends back the number of bytes it read on rd.
+	wr chan []byte
+	rd chan int
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newBodyPipe() *bodyPipe {
+	return &bodyPipe{
+		wr:   make(chan []byte),
+		rd:   make(chan int),
+		done: make(chan struct{}),
+	}
+}
+
+// Read is called by the HTTP/2 transport. It returns io.EOF once the pipe is closed.
+func (p *bodyPipe) Read(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, io.EOF
+	case data := <-p.wr:
+		n := copy(b, data)
+		p.rd <- n
+		return n, nil
+	}
+}
+
+// Write writes b, blocking until the transport reads all of it.
+func (p *bodyPipe) Write(b []byte) (int, error) {
+	return p.write(b, nil)
+}
+
+// write writes b, until the transport reads all of it or cancel is closed.
+func (p *bodyPipe) write(b []byte, cancel <-chan struct{}) (n int, err error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	for once := true; once || len(b) > 0; once = false {
+		select {
+		case <-p.done:
+			return n, io.ErrClosedPipe
+		case <-cancel:
+			return n, os.ErrDeadlineExceeded
+		case p.wr <- b:
+			nr := <-p.rd
+			b = b[nr:]
+			n += nr
+		}
+	}
+	return n, nil
+}
+
+// Close ends the body. It is called by the writer to half-close the stream, and by the transport
+// once the stream is done.
+func (p *bodyPipe) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
@@ -18,18 +18,16 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/proxy"
 
 	istiolog "istio.io/istio/pkg/log"
-	"istio.io/istio/security/pkg/pki/util"
+	"istio.io/istio/pkg/slices"
 )
 
 var log = istiolog.RegisterScope("hbone", "")
@@ -38,9 +36,33 @@ var log = istiolog.RegisterScope("hbone", "")
 type Config struct {
 	// ProxyAddress defines the address of the HBONE proxy we are connecting to
 	ProxyAddress string
-	Headers      http.Header
-	TLS          *tls.Config
-	Timeout      *time.Duration
+	// Headers are added to each CONNECT request
+	Headers http.Header
+	TLS     *tls.Config
+	// Timeout bounds the time to establish the connection to the proxy
+	Timeout *time.Duration
+	// Pool holds the connections to the proxy. It can be shared between dialers, to multiplex
+	// tunnels to the same proxy over a single connection. If unset, the dialer uses its own pool.
+	Pool *Pool
+	// Hops are additional proxies to traverse, in order, with nested tunnels ("double HBONE").
+	// The first hop is reached through a tunnel to ProxyAddress, each following hop through a
+	// tunnel to the previous one, and the target through a tunnel to the last hop.
+	Hops []Hop
+	// UDP enables CONNECT-UDP (RFC 9298) for the "udp" networks. Connections are returned as *UDPConn,
+	// which also implements net.PacketConn. If unset, UDP is dialed directly, without the proxy.
+	// The proxy must support extended CONNECT (RFC 8441).
+	UDP bool
+}
+
+// Hop is a proxy in a chain of nested HBONE tunnels.
+type Hop struct {
+	// ProxyAddress is the address of the proxy, used as the target of the CONNECT to the previous hop.
+	ProxyAddress string
+	// Headers are added to each CONNECT request sent to this proxy
+	Headers http.Header
+	// TLS configures the connection to this proxy, established end to end inside the tunnel from the
+	// previous hop. If nil, the tunnel is used for plaintext HTTP/2.
+	TLS *tls.Config
 }
 
 type Dialer interface {
@@ -50,109 +72,175 @@ type Dialer interface {
 
 // NewDialer creates a Dialer that proxies connections over HBONE to the configured proxy.
 func NewDialer(cfg Config) Dialer {
-	var transport *http2.Transport
-
-	if cfg.TLS != nil {
-		transport = &http2.Transport{
-			TLSClientConfig: cfg.TLS,
-		}
-	} else {
-		transport = &http2.Transport{
-			// For h2c
-			AllowHTTP: true,
-			DialTLSContext: func(ctx context.Context, network, addr string, tlsCfg *tls.Config) (net.Conn, error) {
-				d := net.Dialer{}
-				if cfg.Timeout != nil {
-					d.Timeout = *cfg.Timeout
-				}
-				return d.Dial(network, addr)
-			},
-		}
+	pool := cfg.Pool
+	if pool == nil {
+		pool = NewPool(PoolOptions{})
 	}
 	return &dialer{
-		cfg:       cfg,
-		transport: transport,
+		cfg:  cfg,
+		pool: pool,
 	}
 }
 
 type dialer struct {
-	cfg       Config
-	transport *http2.Transport
+	cfg  Config
+	pool *Pool
 }
 
-// DialContext connects to `address` via the HBONE proxy.
+// DialContext connects to `address` via the HBONE proxy. TCP connections are returned as *TCPConn.
+// The context bounds establishing the tunnel; once DialContext returns, canceling it has no effect on the connection.
 func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	if network != "tcp" {
-		return net.Dial(network, address)
+	udp := d.cfg.UDP && isUDP(network)
+	if network != "tcp" && !udp {
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	hops := append([]Hop{{ProxyAddress: d.cfg.ProxyAddress, Headers: d.cfg.Headers, TLS: d.cfg.TLS}}, d.cfg.Hops...)
+	keys := make([]poolKey, len(hops))
+	via := ""
+	for i, h := range hops {
+		keys[i] = poolKey{address: h.ProxyAddress, tls: h.TLS, via: via}
+		via += h.ProxyAddress + ","
+	}
+
+	// Reuse the innermost connection available; only the hops after it need new tunnels.
+	var cc *http2.ClientConn
+	level := len(hops) - 1
+	for ; level >= 0; level-- {
+		if cc = d.pool.reserve(keys[level]); cc != nil {
+			break
+		}
+	}
+	if cc == nil {
+		conn, err := dialProxy(ctx, hops[0], d.cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if cc, err = d.pool.add(keys[0], conn); err != nil {
+			return nil, err
+		}
+		level = 0
+	}
+	for ; level < len(hops)-1; level++ {
+		next := hops[level+1]
+		tunnel, err := d.connect(ctx, cc, hops[level], next.ProxyAddress)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := clientHandshake(ctx, tunnel, next)
+		if err != nil {
+			return nil, fmt.Errorf("handshake with %v: %v", next.ProxyAddress, err)
+		}
+		if cc, err = d.pool.add(keys[level+1], conn); err != nil {
+			return nil, err
+		}
+	}
+	if udp {
+		c, err := connectUDP(ctx, cc, hops[len(hops)-1], address)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
 	}
-	// TODO: use context
-	c, s := net.Pipe()
-	err := d.proxyTo(s, d.cfg, address)
+	c, err := d.connect(ctx, cc, hops[len(hops)-1], address)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-func (d dialer) Dial(network, address string) (c net.Conn, err error) {
+func (d *dialer) Dial(network, address string) (c net.Conn, err error) {
 	return d.DialContext(context.Background(), network, address)
 }
 
-func (d *dialer) proxyTo(conn io.ReadWriteCloser, req Config, address string) error {
-	t0 := time.Now()
+// connect initiates a CONNECT to address through hop over cc, which must have a stream reserved.
+func (d *dialer) connect(ctx context.Context, cc *http2.ClientConn, hop Hop, address string) (*TCPConn, error) {
+	st, err := openStream(ctx, cc, hop, address, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return newTCPConn(st, address, &net.TCPAddr{}), nil
+}
 
-	url := "http://" + req.ProxyAddress
-	if req.TLS != nil {
-		url = "https://" + req.ProxyAddress
+// connectUDP initiates a CONNECT-UDP to address through hop over cc, which must have a stream reserved.
+// It is an extended CONNECT to the proxy, with the target in the path.
+func connectUDP(ctx context.Context, cc *http2.ClientConn, hop Hop, address string) (*UDPConn, error) {
+	path, err := udpPath(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", address, err)
 	}
+	st, err := openStream(ctx, cc, hop, hop.ProxyAddress, path, http.Header{
+		protocolPseudoHeader:  {ProtocolConnectUDP},
+		capsuleProtocolHeader: {"?1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newUDPConn(st, address, &net.UDPAddr{}), nil
+}
+
+// stream is an established CONNECT stream.
+type stream struct {
+	resp *http.Response
+	// body is the request body, sent to the proxy
+	body *bodyPipe
+	// cancel aborts the stream
+	cancel context.CancelFunc
+}
+
+// openStream sends a CONNECT to authority through hop over cc, which must have a stream reserved,
+// and waits for a successful response. The path is only set for extended CONNECT requests.
+// header is added to the headers of the hop.
+func openStream(ctx context.Context, cc *http2.ClientConn, hop Hop, authority, path string, header http.Header) (*stream, error) {
+	url := "http://" + hop.ProxyAddress + path
+	if hop.TLS != nil {
+		url = "https://" + hop.ProxyAddress + path
+	}
+	// The stream outlives ctx, which only applies until the tunnel is established.
+	streamCtx, cancel := context.WithCancel(context.Background())
 	// Setup a pipe. We could just pass `conn` to `http.NewRequest`, but this has a few issues:
 	// * Less visibility into i/o
 	// * http will call conn.Close, which will close before we want to (finished writing response).
-	pr, pw := io.Pipe()
-	r, err := http.NewRequest(http.MethodConnect, url, pr)
+	body := newBodyPipe()
+	r, err := http.NewRequestWithContext(streamCtx, http.MethodConnect, url, body)
 	if err != nil {
-		return fmt.Errorf("new request: %v", err)
+		cancel()
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+	r.Host = authority
+	for k, v := range hop.Headers {
+		r.Header[k] = slices.Clone(v)
+	}
+	for k, v := range header {
+		r.Header[k] = slices.Clone(v)
 	}
-	r.Host = address
 
 	// Initiate CONNECT.
 	log.Infof("initiate CONNECT to %v via %v", r.Host, url)
 
-	resp, err := d.transport.RoundTrip(r)
-	if err != nil {
-		return fmt.Errorf("round trip: %v", err)
-	}
-	var remoteID string
-	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
-		ids, _ := util.ExtractIDs(resp.TLS.PeerCertificates[0].Extensions)
-		if len(ids) > 0 {
-			remoteID = ids[0]
+	// The reserved stream must always be consumed by RoundTrip; if ctx is already done the
+	// request is aborted immediately.
+	stop := context.AfterFunc(ctx, cancel)
+	resp, err := cc.RoundTrip(r)
+	if !stop() {
+		// ctx was canceled before the tunnel was established
+		if err == nil {
+			_ = resp.Body.Close()
 		}
+		cancel()
+		return nil, fmt.Errorf("round trip: %w", ctx.Err())
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("round trip: %v", err)
 	}
+	remoteID := verifiedIdentity(resp.TLS)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("round trip failed: %v", resp.Status)
+		_ = resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("round trip failed: %v", resp.Status)
 	}
 	log.WithLabels("host", r.Host, "remote", remoteID).Info("CONNECT established")
-	go func() {
-		defer conn.Close()
-		defer resp.Body.Close()
-
-		wg := sync.WaitGroup{}
-		wg.Add(1)
-		go func() {
-			// handle upstream (hbone server) --> downstream (app)
-			copyBuffered(conn, resp.Body, log.WithLabels("name", "body to conn"))
-			wg.Done()
-		}()
-		// Copy from conn into the pipe, which will then be sent as part of the request
-		// handle upstream (hbone server) <-- downstream (app)
-		copyBuffered(pw, conn, log.WithLabels("name", "conn to pipe"))
-
-		wg.Wait()
-		log.Infof("stream closed in %v", time.Since(t0))
-	}()
-
-	return nil
+	return &stream{resp: resp, body: body, cancel: cancel}, nil
 }
 
 // TLSDialWithDialer is an implementation of tls.DialWithDialer that accepts a generic Dialer
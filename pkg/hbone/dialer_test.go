@@ -15,9 +15,19 @@
 package hbone
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"os"
 	"testing"
 	"time"
+
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pkg/test/util/assert"
 )
 
 func newTCPServer(t testing.TB, data string) string {
@@ -100,17 +110,361 @@ func TestDialer(t *testing.T) {
 	send()
 }
 
-func newHBONEServer(t *testing.T) string {
-	s := NewServer()
-	l, err := net.Listen("tcp", "0.0.0.0:0")
+func TestDialerContext(t *testing.T) {
+	// A proxy that accepts connections but never answers the CONNECT
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
 	go func() {
-		_ = s.Serve(l)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = c.Close()
+			})
+		}
 	}()
+	d := NewDialer(Config{ProxyAddress: l.Addr().String()})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		t0 := time.Now()
+		_, err := d.DialContext(ctx, "tcp", "127.0.0.1:1")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+		if time.Since(t0) > 5*time.Second {
+			t.Fatalf("dial did not honor the context deadline")
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := d.DialContext(ctx, "tcp", "127.0.0.1:1")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected canceled, got %v", err)
+		}
+	})
+}
+
+func TestDialerPool(t *testing.T) {
+	testAddr := newEchoServer(t)
+	proxy, _ := newRecordingHBONEServer(t)
+	dial := func(d Dialer) net.Conn {
+		t.Helper()
+		c, err := d.DialContext(context.Background(), "tcp", testAddr)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+		expectEcho(t, c, "hello")
+		return c
+	}
+
+	t.Run("shared connection", func(t *testing.T) {
+		pool := NewPool(PoolOptions{})
+		defer pool.Close()
+		d1 := NewDialer(Config{ProxyAddress: proxy, Pool: pool})
+		d2 := NewDialer(Config{ProxyAddress: proxy, Pool: pool})
+		for i := 0; i < 3; i++ {
+			dial(d1)
+			dial(d2)
+		}
+		assert.Equal(t, pool.connections(proxy), 1)
+	})
+	t.Run("max streams", func(t *testing.T) {
+		pool := NewPool(PoolOptions{MaxStreamsPerConnection: 2})
+		defer pool.Close()
+		d := NewDialer(Config{ProxyAddress: proxy, Pool: pool})
+		for i := 0; i < 5; i++ {
+			dial(d)
+		}
+		assert.Equal(t, pool.connections(proxy), 3)
+	})
+	t.Run("closed streams are reused", func(t *testing.T) {
+		pool := NewPool(PoolOptions{MaxStreamsPerConnection: 1})
+		defer pool.Close()
+		d := NewDialer(Config{ProxyAddress: proxy, Pool: pool})
+		c := dial(d)
+		_ = c.Close()
+		// The stream is released asynchronously, once both directions are done
+		assert.EventuallyEqual(t, func() int {
+			return pool.activeStreams(proxy)
+		}, 0)
+		dial(d)
+		assert.Equal(t, pool.connections(proxy), 1)
+	})
+	t.Run("closed connections are removed", func(t *testing.T) {
+		pool := NewPool(PoolOptions{IdleTimeout: 50 * time.Millisecond})
+		defer pool.Close()
+		d := NewDialer(Config{ProxyAddress: proxy, Pool: pool})
+		c := dial(d)
+		_ = c.Close()
+		// The connection is closed by the idle timeout, without dialing the proxy again.
+		assert.EventuallyEqual(t, func() int {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return len(pool.conns)
+		}, 0)
+	})
+}
+
+func TestDialerHeaders(t *testing.T) {
+	testAddr := newEchoServer(t)
+	proxy, headers := newRecordingHBONEServer(t)
+	d := NewDialer(Config{
+		ProxyAddress: proxy,
+		Headers: map[string][]string{
+			"some-addition-metadata": {"test-value"},
+		},
+	})
+	c, err := d.Dial("tcp", testAddr)
+	assert.NoError(t, err)
+	defer c.Close()
+	expectEcho(t, c, "hello")
+	assert.Equal(t, headers.Load().Get("some-addition-metadata"), "test-value")
+}
+
+func TestDialerCloseWrite(t *testing.T) {
+	testAddr := newEchoServer(t)
+	d := NewDialer(Config{ProxyAddress: newHBONEServer(t)})
+	c, err := d.Dial("tcp", testAddr)
+	assert.NoError(t, err)
+	defer c.Close()
+	tc, ok := c.(*TCPConn)
+	if !ok {
+		t.Fatalf("unexpected connection %T", c)
+	}
+
+	// A read deadline does not break the connection.
+	_ = tc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := tc.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	_ = tc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = tc.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, tc.CloseWrite())
+	// The echo server closes its side once it reads the end of the stream.
+	got, err := io.ReadAll(tc)
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), "hello")
+}
+
+func TestDialerDeadlines(t *testing.T) {
+	// The target accepts connections, but never reads or writes.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
 	t.Cleanup(func() {
 		_ = l.Close()
 	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = c.Close()
+			})
+		}
+	}()
+	d := NewDialer(Config{ProxyAddress: newHBONEServer(t)})
+
+	t.Run("read", func(t *testing.T) {
+		c, err := d.Dial("tcp", l.Addr().String())
+		assert.NoError(t, err)
+		defer c.Close()
+		errs := make(chan error, 1)
+		go func() {
+			_, err := c.Read(make([]byte, 1))
+			errs <- err
+		}()
+		// The deadline is set while the Read is blocked.
+		time.Sleep(50 * time.Millisecond)
+		_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		select {
+		case err := <-errs:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected the deadline to be exceeded, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("read was not interrupted by the deadline")
+		}
+	})
+	t.Run("write", func(t *testing.T) {
+		c, err := d.Dial("tcp", l.Addr().String())
+		assert.NoError(t, err)
+		defer c.Close()
+		type result struct {
+			n   int
+			err error
+		}
+		results := make(chan result, 1)
+		data := make([]byte, 64<<20)
+		go func() {
+			// The target does not read, so the write is blocked by flow control.
+			n, err := c.Write(data)
+			results <- result{n, err}
+		}()
+		time.Sleep(100 * time.Millisecond)
+		_ = c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		select {
+		case r := <-results:
+			if !errors.Is(r.err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected the deadline to be exceeded, got %v", r.err)
+			}
+			if r.n >= len(data) {
+				t.Fatalf("expected a partial write, got %d bytes", r.n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("write was not interrupted by the deadline")
+		}
+	})
+}
+
+func (p *Pool) activeStreams(address string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for k, conns := range p.conns {
+		if k.address == address {
+			for _, cc := range conns {
+				n += cc.State().StreamsActive
+			}
+		}
+	}
+	return n
+}
+
+// newEchoServer starts a TCP server writing back everything it reads.
+func newEchoServer(t testing.TB) string {
+	n, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := n.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				copyBuffered(c, c, log)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		n.Close()
+	})
+	return n.Addr().String()
+}
+
+func expectEcho(t *testing.T, c net.Conn, data string) {
+	t.Helper()
+	_, err := c.Write([]byte(data))
+	assert.NoError(t, err)
+	buf := make([]byte, len(data))
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf[:n]), data)
+}
+
+// newRecordingHBONEServer starts an HBONE server recording the headers of the last CONNECT request.
+func newRecordingHBONEServer(t *testing.T) (string, *atomic.Pointer[http.Header]) {
+	headers := atomic.NewPointer[http.Header](nil)
+	s, err := NewServerWithOptions(ServerOptions{
+		Authorize: func(_ context.Context, req AuthorizationRequest) error {
+			h := req.Headers.Clone()
+			headers.Store(&h)
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	return serve(t, s), headers
+}
+
+func serve(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
 	return l.Addr().String()
 }
+
+func newHBONEServer(t *testing.T) string {
+	s, err := NewServerWithOptions(ServerOptions{})
+	assert.NoError(t, err)
+	return serve(t, s)
+}
+
+func TestDialerHops(t *testing.T) {
+	ca := newTestCA(t)
+	testAddr := newEchoServer(t)
+	// Each proxy counts the CONNECT requests it receives
+	newProxy := func(opts ServerOptions) (string, *atomic.Int32) {
+		connects := atomic.NewInt32(0)
+		opts.Authorize = func(_ context.Context, req AuthorizationRequest) error {
+			if req.Headers.Get("x-hop") == "" {
+				return fmt.Errorf("missing hop header")
+			}
+			connects.Inc()
+			return nil
+		}
+		s, err := NewServerWithOptions(opts)
+		assert.NoError(t, err)
+		return serve(t, s), connects
+	}
+	first, firstConnects := newProxy(ServerOptions{})
+	second, secondConnects := newProxy(ServerOptions{TLS: ca.serverTLS(t)})
+	third, thirdConnects := newProxy(ServerOptions{TLS: ca.serverTLS(t), LocalhostOnly: true})
+
+	pool := NewPool(PoolOptions{})
+	defer pool.Close()
+	d := NewDialer(Config{
+		ProxyAddress: first,
+		Headers:      map[string][]string{"x-hop": {"first"}},
+		Pool:         pool,
+		Hops: []Hop{
+			{
+				ProxyAddress: second,
+				Headers:      map[string][]string{"x-hop": {"second"}},
+				TLS:          ca.clientTLS(t, "spiffe://cluster.local/ns/default/sa/client"),
+			},
+			{
+				ProxyAddress: third,
+				Headers:      map[string][]string{"x-hop": {"third"}},
+				TLS:          ca.clientTLS(t, "spiffe://cluster.local/ns/default/sa/client"),
+			},
+		},
+	})
+	for i := 0; i < 3; i++ {
+		c, err := d.Dial("tcp", testAddr)
+		assert.NoError(t, err)
+		expectEcho(t, c, "hello")
+		assert.NoError(t, c.Close())
+	}
+	// The tunnels to the first two proxies are established once, and reused for all connections
+	assert.Equal(t, firstConnects.Load(), int32(1))
+	assert.Equal(t, secondConnects.Load(), int32(1))
+	assert.Equal(t, thirdConnects.Load(), int32(3))
+	assert.Equal(t, pool.connections(first), 1)
+	assert.Equal(t, pool.connections(second), 1)
+	assert.Equal(t, pool.connections(third), 1)
+}
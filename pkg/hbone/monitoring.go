@@ -0,0 +1,55 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"istio.io/istio/pkg/monitoring"
+)
+
+const (
+	resultSuccess         = "success"
+	resultForbiddenTarget = "forbidden_target"
+	resultUnauthorized    = "unauthorized"
+	resultDialError       = "dial_error"
+	// resultUnsupportedProtocol is reported for unknown protocols, and for CONNECT-UDP if it is not enabled.
+	resultUnsupportedProtocol = "unsupported_protocol"
+)
+
+var (
+	resultTag = monitoring.CreateLabel("result")
+
+	streams = monitoring.NewSum(
+		"hbone_server_streams_total",
+		"Total number of CONNECT streams received by the HBONE server, by result.",
+	)
+
+	streamDuration = monitoring.NewDistribution(
+		"hbone_server_stream_duration_seconds",
+		"Duration in seconds of the CONNECT streams forwarded by the HBONE server.",
+		[]float64{.01, .1, 1, 10, 60, 300, 1800, 3600},
+	)
+
+	sentBytes = monitoring.NewSum(
+		"hbone_server_sent_bytes_total",
+		"Total number of bytes sent to HBONE clients, from the targets of their CONNECT streams.",
+		monitoring.WithUnit(monitoring.Bytes),
+	)
+
+	receivedBytes = monitoring.NewSum(
+		"hbone_server_received_bytes_total",
+		"Total number of bytes received from HBONE clients and forwarded to the targets of their CONNECT streams.",
+		monitoring.WithUnit(monitoring.Bytes),
+	)
+)
@@ -0,0 +1,237 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"istio.io/istio/pkg/slices"
+)
+
+// DefaultIdleTimeout is the default duration after which a pooled connection without streams is closed.
+const DefaultIdleTimeout = 90 * time.Second
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// MaxStreamsPerConnection limits the number of concurrent CONNECT streams multiplexed over a
+	// single HTTP/2 connection. When all connections are at the limit, a new connection is opened.
+	// If zero, only the limit advertised by the proxy applies.
+	MaxStreamsPerConnection int
+
+	// IdleTimeout is the duration after which a connection without active streams is closed.
+	// Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+}
+
+// Pool maintains HTTP/2 connections to HBONE proxies, multiplexing CONNECT streams to the
+// same proxy over a single connection. A Pool can be shared by multiple dialers.
+type Pool struct {
+	opts      PoolOptions
+	transport *http2.Transport
+
+	mu    sync.Mutex
+	conns map[poolKey][]*http2.ClientConn
+}
+
+// poolKey identifies connections which can be shared: the same proxy, reached with the same TLS config
+// through the same chain of proxies.
+type poolKey struct {
+	address string
+	tls     *tls.Config
+	// via lists the proxies the connection is tunneled through, for nested tunnels.
+	via string
+}
+
+// NewPool creates a new connection pool.
+func NewPool(opts PoolOptions) *Pool {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	return &Pool{
+		opts: opts,
+		transport: &http2.Transport{
+			AllowHTTP:       true,
+			IdleConnTimeout: opts.IdleTimeout,
+		},
+		conns: map[poolKey][]*http2.ClientConn{},
+	}
+}
+
+// Close closes all connections in the pool. Active streams are interrupted.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = map[poolKey][]*http2.ClientConn{}
+	p.mu.Unlock()
+	for _, cc := range conns {
+		for _, c := range cc {
+			_ = c.Close()
+		}
+	}
+}
+
+// connections returns the number of open connections to the proxy at address.
+func (p *Pool) connections(address string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for k, conns := range p.conns {
+		if k.address != address {
+			continue
+		}
+		for _, cc := range conns {
+			if !cc.State().Closed {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// reserve returns a connection for key with a stream reserved for a new request, or nil if there is
+// no connection with spare capacity. The caller must use the reservation by calling RoundTrip on the connection.
+func (p *Pool) reserve(key poolKey) *http2.ClientConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var found *http2.ClientConn
+	p.conns[key] = slices.FilterInPlace(p.conns[key], func(cc *http2.ClientConn) bool {
+		st := cc.State()
+		if st.Closed || st.Closing {
+			return false
+		}
+		if found == nil && p.hasCapacity(st) && cc.ReserveNewRequest() {
+			found = cc
+		}
+		return true
+	})
+	return found
+}
+
+// add starts an HTTP/2 connection over conn and adds it to the pool for key, with a stream
+// reserved for a new request. The connection is removed from the pool once it is closed.
+func (p *Pool) add(key poolKey, conn net.Conn) (*http2.ClientConn, error) {
+	var cc *http2.ClientConn
+	conn = withCloseHook(conn, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.conns[key] = slices.FilterInPlace(p.conns[key], func(c *http2.ClientConn) bool {
+			return c != cc
+		})
+		if len(p.conns[key]) == 0 {
+			delete(p.conns, key)
+		}
+	})
+	c, err := p.transport.NewClientConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("new client conn: %v", err)
+	}
+	if !c.ReserveNewRequest() {
+		_ = c.Close()
+		return nil, fmt.Errorf("connection to %v can not take new requests", key.address)
+	}
+	p.mu.Lock()
+	cc = c
+	if !c.State().Closed {
+		p.conns[key] = append(p.conns[key], c)
+	}
+	p.mu.Unlock()
+	log.Debugf("opened new connection to %v", key.address)
+	return c, nil
+}
+
+func (p *Pool) hasCapacity(st http2.ClientConnState) bool {
+	if p.opts.MaxStreamsPerConnection <= 0 {
+		return true
+	}
+	return st.StreamsActive+st.StreamsReserved+st.StreamsPending < p.opts.MaxStreamsPerConnection
+}
+
+// closeHookConn is a connection calling a hook once it is closed, such as when the HTTP/2 connection
+// over it is closed after an idle timeout or an error.
+type closeHookConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *closeHookConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.onClose)
+	return err
+}
+
+// tlsCloseHookConn is a closeHookConn over a TLS connection. The HTTP/2 transport reads the TLS state
+// of the connection through ConnectionState.
+type tlsCloseHookConn struct {
+	*closeHookConn
+	tls *tls.Conn
+}
+
+func (c *tlsCloseHookConn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+func withCloseHook(conn net.Conn, onClose func()) net.Conn {
+	c := &closeHookConn{Conn: conn, onClose: onClose}
+	if tc, ok := conn.(*tls.Conn); ok {
+		return &tlsCloseHookConn{closeHookConn: c, tls: tc}
+	}
+	return c
+}
+
+// dialProxy opens the transport connection to the first proxy, with TLS if configured.
+func dialProxy(ctx context.Context, hop Hop, timeout *time.Duration) (net.Conn, error) {
+	d := net.Dialer{}
+	if timeout != nil {
+		d.Timeout = *timeout
+	}
+	conn, err := d.DialContext(ctx, "tcp", hop.ProxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	return clientHandshake(ctx, conn, hop)
+}
+
+// clientHandshake starts TLS with the proxy over conn, if configured.
+func clientHandshake(ctx context.Context, conn net.Conn, hop Hop) (net.Conn, error) {
+	if hop.TLS == nil {
+		// h2c
+		return conn, nil
+	}
+	tlsCfg := hop.TLS
+	if len(tlsCfg.NextProtos) == 0 || tlsCfg.ServerName == "" {
+		tlsCfg = tlsCfg.Clone()
+		if len(tlsCfg.NextProtos) == 0 {
+			tlsCfg.NextProtos = []string{http2.NextProtoTLS}
+		}
+		if host, _, err := net.SplitHostPort(hop.ProxyAddress); err == nil && tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -16,30 +16,120 @@ package hbone
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"sync"
 	"time"
 
 	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
 
 	"istio.io/istio/pkg/h2c"
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/security/pkg/pki/util"
 )
 
+// DefaultDialTimeout is the default timeout to connect to the target of a CONNECT request.
+const DefaultDialTimeout = 10 * time.Second
+
+// AuthorizationRequest describes a CONNECT request to authorize.
+type AuthorizationRequest struct {
+	// Identity is the SPIFFE identity of the peer, extracted from its client certificate.
+	// It is empty if the connection is not mTLS, or if the certificate was not verified.
+	Identity string
+	// Target is the address the peer asked to connect to.
+	Target string
+	// Headers are the headers of the CONNECT request.
+	Headers http.Header
+}
+
+// AuthorizeFunc decides if a CONNECT request is allowed. Returning an error denies the request.
+type AuthorizeFunc func(ctx context.Context, req AuthorizationRequest) error
+
+// ServerOptions configures a Server. All fields are optional.
+type ServerOptions struct {
+	// TLS enables mTLS termination. If ClientAuth is not set, client certificates are required and
+	// verified against ClientCAs. If nil, the server accepts plaintext HTTP/2 (h2c).
+	TLS *tls.Config
+
+	// Authorize is called for each CONNECT request, after the target checks. If nil, all requests
+	// to allowed targets are accepted.
+	Authorize AuthorizeFunc
+
+	// AllowedTargets restricts the targets of CONNECT requests. Each entry is an address (host:port),
+	// a host or IP matching any port, or a CIDR range. If empty, any target is allowed unless
+	// LocalhostOnly is set.
+	AllowedTargets []string
+
+	// LocalhostOnly restricts the targets of CONNECT requests to loopback addresses, as a sidecar does
+	// when forwarding to the application.
+	LocalhostOnly bool
+
+	// DialTimeout bounds the time to connect to the target. Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// UDP enables CONNECT-UDP (RFC 9298) requests. They are extended CONNECT (RFC 8441) requests, which
+	// golang.org/x/net/http2 only accepts with GODEBUG=http2xconnect=1, so this must be set in the
+	// environment of the process.
+	UDP bool
+
+	// Dialer connects to the targets of CONNECT requests. Set it to an HBONE Dialer to forward
+	// each CONNECT to a next hop proxy, as a gateway between networks does. Defaults to a net.Dialer.
+	Dialer proxy.ContextDialer
+}
+
+// Server is an HBONE server, accepting CONNECT requests and forwarding the tunneled streams to their target.
+type Server struct {
+	opts     ServerOptions
+	prefixes []netip.Prefix
+	hosts    map[string]struct{}
+	srv      *http.Server
+}
+
+// NewServer creates an HBONE server accepting plaintext connections and forwarding to any target.
 func NewServer() *http.Server {
-	// Need to set this to allow timeout on the read header
-	h1 := &http.Transport{
-		ExpectContinueTimeout: 3 * time.Second,
+	s, _ := NewServerWithOptions(ServerOptions{})
+	return s.srv
+}
+
+// NewServerWithOptions creates an HBONE server.
+func NewServerWithOptions(opts ServerOptions) (*Server, error) {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = &net.Dialer{}
+	}
+	if opts.UDP && !extendedConnectEnabled() {
+		return nil, fmt.Errorf("CONNECT-UDP requires extended CONNECT, enabled with GODEBUG=http2xconnect=1")
+	}
+	s := &Server{
+		opts:  opts,
+		hosts: map[string]struct{}{},
 	}
-	h2, _ := http2.ConfigureTransports(h1)
-	h2.ReadIdleTimeout = 10 * time.Minute // TODO: much larger to support long-lived connections
-	h2.AllowHTTP = true
+	for _, t := range opts.AllowedTargets {
+		if t == "" {
+			return nil, fmt.Errorf("invalid empty allowed target")
+		}
+		if p, err := netip.ParsePrefix(t); err == nil {
+			s.prefixes = append(s.prefixes, p)
+			continue
+		}
+		if ip, err := netip.ParseAddr(t); err == nil {
+			// Normalize, so IPv6 addresses match regardless of their textual form.
+			t = ip.String()
+		}
+		s.hosts[t] = struct{}{}
+	}
+
 	h2Server := &http2.Server{}
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodConnect {
-			if handleConnect(w, r) {
-				return
-			}
+			s.handleConnect(w, r)
 		} else {
 			log.Errorf("non-CONNECT: %v", r.Method)
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -48,40 +138,196 @@ func NewServer() *http.Server {
 	hs := &http.Server{
 		Handler: h2c.NewHandler(handler, h2Server),
 	}
-	return hs
+	if opts.TLS != nil {
+		tlsConfig := opts.TLS.Clone()
+		if tlsConfig.ClientAuth == tls.NoClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		if len(tlsConfig.NextProtos) == 0 {
+			tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+		}
+		hs.TLSConfig = tlsConfig
+		if err := http2.ConfigureServer(hs, h2Server); err != nil {
+			return nil, err
+		}
+	}
+	s.srv = hs
+	return s, nil
+}
+
+// Serve accepts connections on l, terminating TLS if configured. It blocks until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	if s.srv.TLSConfig != nil {
+		return s.srv.ServeTLS(l, "", "")
+	}
+	return s.srv.Serve(l)
+}
+
+// Close immediately closes the listeners and all connections, including active tunnels.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// Shutdown gracefully stops the server, waiting for active tunnels to complete until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// peerIdentity returns the SPIFFE identity in the client certificate of the request, if any.
+func peerIdentity(r *http.Request) string {
+	return verifiedIdentity(r.TLS)
+}
+
+// verifiedIdentity returns the SPIFFE identity in the certificate of the peer of a TLS connection, if
+// it was verified. The certificates of peers which were not verified, such as with RequireAnyClientCert,
+// are ignored, as they could claim any identity.
+func verifiedIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	ids, err := util.ExtractIDs(state.VerifiedChains[0][0].Extensions)
+	if err != nil || len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
 }
 
-func handleConnect(w http.ResponseWriter, r *http.Request) bool {
+// targetAllowed checks the target of a CONNECT request against the configured restrictions.
+func (s *Server) targetAllowed(target string) error {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	ip, ipErr := netip.ParseAddr(host)
+	if s.opts.LocalhostOnly {
+		if ipErr != nil || !ip.IsLoopback() {
+			return fmt.Errorf("target %v is not a loopback address", target)
+		}
+	}
+	if len(s.hosts) == 0 && len(s.prefixes) == 0 {
+		return nil
+	}
+	if _, f := s.hosts[target]; f {
+		return nil
+	}
+	if ipErr == nil {
+		if _, f := s.hosts[ip.String()]; f {
+			return nil
+		}
+		for _, p := range s.prefixes {
+			if p.Contains(ip.Unmap()) {
+				return nil
+			}
+		}
+	} else if _, f := s.hosts[host]; f {
+		return nil
+	}
+	return fmt.Errorf("target %v is not allowed", target)
+}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
-	log.WithLabels("host", r.Host, "source", r.RemoteAddr).Info("Received CONNECT")
-	// Send headers back immediately so we can start getting the body
-	w.(http.Flusher).Flush()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	identity := peerIdentity(r)
+	log := log.WithLabels("host", r.Host, "source", r.RemoteAddr, "identity", identity)
+	log.Info("Received CONNECT")
 
-	dst, err := (&net.Dialer{}).DialContext(ctx, "tcp", r.Host)
+	network, target := "tcp", r.Host
+	switch protocol := r.Header.Get(protocolPseudoHeader); protocol {
+	case "":
+	case ProtocolConnectUDP:
+		if !s.opts.UDP {
+			log.Warnf("rejected CONNECT: UDP is not enabled")
+			streams.With(resultTag.Value(resultUnsupportedProtocol)).Increment()
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		t, err := udpTarget(r.URL)
+		if err != nil {
+			log.Warnf("rejected CONNECT-UDP: %v", err)
+			streams.With(resultTag.Value(resultUnsupportedProtocol)).Increment()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		network, target = "udp", t
+		log = log.WithLabels("target", target)
+	default:
+		log.Warnf("rejected CONNECT: unknown protocol %q", protocol)
+		streams.With(resultTag.Value(resultUnsupportedProtocol)).Increment()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := s.targetAllowed(target); err != nil {
+		log.Warnf("rejected CONNECT: %v", err)
+		streams.With(resultTag.Value(resultForbiddenTarget)).Increment()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if s.opts.Authorize != nil {
+		err := s.opts.Authorize(r.Context(), AuthorizationRequest{
+			Identity: identity,
+			Target:   target,
+			Headers:  r.Header,
+		})
+		if err != nil {
+			log.Warnf("unauthorized CONNECT: %v", err)
+			streams.With(resultTag.Value(resultUnauthorized)).Increment()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.opts.DialTimeout)
+	dst, err := s.opts.Dialer.DialContext(ctx, network, target)
+	cancel()
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
 		log.Errorf("failed to dial upstream: %v", err)
-		return true
+		streams.With(resultTag.Value(resultDialError)).Increment()
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+	defer dst.Close()
+	log.Infof("Connected to %v/%v", network, target)
+	streams.With(resultTag.Value(resultSuccess)).Increment()
+
+	var sent, received int64
+	if network == "udp" {
+		sent, received = forwardUDP(w, r, dst, log)
+	} else {
+		sent, received = forwardTCP(w, r, dst, log)
 	}
-	log.Infof("Connected to %v", r.Host)
+	d := time.Since(t0)
+	streamDuration.Record(d.Seconds())
+	sentBytes.RecordInt(sent)
+	receivedBytes.RecordInt(received)
+	log.Infof("connection closed in %v, sent %d bytes, received %d bytes", d, sent, received)
+}
+
+// forwardTCP copies data between the CONNECT stream and the target, until both directions are closed.
+func forwardTCP(w http.ResponseWriter, r *http.Request, dst net.Conn, log *istiolog.Scope) (sent, received int64) {
+	// Send headers back immediately so we can start getting the body
 	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
 
+	// WithLabels is not safe to call concurrently on the same scope.
+	upstreamLog, downstreamLog := log.WithLabels("name", "dst to w"), log.WithLabels("name", "body to dst")
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		// downstream (hbone client) <-- upstream (app)
-		copyBuffered(w, dst, log.WithLabels("name", "dst to w"))
-		err = r.Body.Close()
+		sent = copyBuffered(w, dst, upstreamLog)
+		err := r.Body.Close()
 		if err != nil {
 			log.Infof("connection to hbone client is not closed: %v", err)
 		}
 		wg.Done()
 	}()
 	// downstream (hbone client) --> upstream (app)
-	copyBuffered(dst, r.Body, log.WithLabels("name", "body to dst"))
+	received = copyBuffered(dst, r.Body, downstreamLog)
 	wg.Wait()
-	log.Infof("connection closed in %v", time.Since(t0))
-	return false
+	return sent, received
 }
@@ -0,0 +1,279 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pkg/monitoring/monitortest"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+type testCA struct {
+	pool *x509.CertPool
+	cert *x509.Certificate
+	key  any
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	rootPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Host:         "test-ca",
+		TTL:          time.Hour,
+		Org:          "istio",
+		IsCA:         true,
+		IsSelfSigned: true,
+		ECSigAlg:     util.EcdsaSigAlg,
+	})
+	assert.NoError(t, err)
+	cert, err := util.ParsePemEncodedCertificate(rootPEM)
+	assert.NoError(t, err)
+	key, err := util.ParsePemEncodedKey(keyPEM)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return testCA{pool: pool, cert: cert, key: key}
+}
+
+func (ca testCA) issue(t *testing.T, host string, server bool) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Host:       host,
+		TTL:        time.Hour,
+		SignerCert: ca.cert,
+		SignerPriv: ca.key,
+		IsServer:   server,
+		IsClient:   !server,
+		ECSigAlg:   util.EcdsaSigAlg,
+	})
+	assert.NoError(t, err)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	return cert
+}
+
+// serverTLS returns the TLS config of an HBONE server with a certificate issued by ca.
+func (ca testCA) serverTLS(t *testing.T) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "127.0.0.1", true)},
+		ClientCAs:    ca.pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// clientTLS returns the TLS config of an HBONE client with the identity id, issued by ca.
+func (ca testCA) clientTLS(t *testing.T, id string) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, id, false)},
+		RootCAs:      ca.pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func TestServerIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	testAddr := newEchoServer(t)
+	identity := atomic.NewString("")
+	s, err := NewServerWithOptions(ServerOptions{
+		TLS: ca.serverTLS(t),
+		Authorize: func(_ context.Context, req AuthorizationRequest) error {
+			identity.Store(req.Identity)
+			if req.Identity != "spiffe://cluster.local/ns/default/sa/allowed" {
+				return fmt.Errorf("identity %v is not allowed", req.Identity)
+			}
+			if req.Headers.Get("x-test") != "value" {
+				return fmt.Errorf("missing header")
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	proxy := serve(t, s)
+
+	dial := func(id string, headers map[string][]string) error {
+		d := NewDialer(Config{
+			ProxyAddress: proxy,
+			TLS:          ca.clientTLS(t, id),
+			Headers:      headers,
+		})
+		c, err := d.Dial("tcp", testAddr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		expectEcho(t, c, "hello")
+		return nil
+	}
+	headers := map[string][]string{"x-test": {"value"}}
+
+	assert.NoError(t, dial("spiffe://cluster.local/ns/default/sa/allowed", headers))
+	assert.Equal(t, identity.Load(), "spiffe://cluster.local/ns/default/sa/allowed")
+
+	expectForbidden(t, dial("spiffe://cluster.local/ns/default/sa/other", headers))
+	assert.Equal(t, identity.Load(), "spiffe://cluster.local/ns/default/sa/other")
+
+	expectForbidden(t, dial("spiffe://cluster.local/ns/default/sa/allowed", nil))
+
+	// Clients without a certificate are rejected during the handshake
+	d := NewDialer(Config{
+		ProxyAddress: proxy,
+		TLS:          &tls.Config{RootCAs: ca.pool, MinVersion: tls.VersionTLS12},
+	})
+	if _, err := d.Dial("tcp", testAddr); err == nil {
+		t.Fatal("expected client without certificate to be rejected")
+	}
+}
+
+func TestServerIdentityNotVerified(t *testing.T) {
+	ca := newTestCA(t)
+	testAddr := newEchoServer(t)
+	identity := atomic.NewString("unset")
+	serverTLS := ca.serverTLS(t)
+	// Client certificates are requested, but not verified.
+	serverTLS.ClientAuth = tls.RequireAnyClientCert
+	s, err := NewServerWithOptions(ServerOptions{
+		TLS: serverTLS,
+		Authorize: func(_ context.Context, req AuthorizationRequest) error {
+			identity.Store(req.Identity)
+			if req.Identity != "spiffe://cluster.local/ns/default/sa/allowed" {
+				return fmt.Errorf("identity %v is not allowed", req.Identity)
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	proxy := serve(t, s)
+
+	// The certificate is issued by a CA the server does not trust, so its identity is ignored.
+	clientTLS := newTestCA(t).clientTLS(t, "spiffe://cluster.local/ns/default/sa/allowed")
+	clientTLS.RootCAs = ca.pool
+	d := NewDialer(Config{ProxyAddress: proxy, TLS: clientTLS})
+	_, err = d.Dial("tcp", testAddr)
+	expectForbidden(t, err)
+	assert.Equal(t, identity.Load(), "")
+}
+
+func TestServerTargets(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    ServerOptions
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "any",
+			opts:    ServerOptions{},
+			allowed: []string{"127.0.0.1:80", "10.0.0.1:80", "example.com:443", "[::1]:80"},
+			denied:  []string{"no-port"},
+		},
+		{
+			name:    "localhost",
+			opts:    ServerOptions{LocalhostOnly: true},
+			allowed: []string{"127.0.0.1:80", "127.0.0.2:8080", "[::1]:80"},
+			denied:  []string{"10.0.0.1:80", "localhost.example.com:80", "[::2]:80"},
+		},
+		{
+			name:    "allowlist",
+			opts:    ServerOptions{AllowedTargets: []string{"10.0.0.0/8", "192.168.0.1:80", "example.com", "::1"}},
+			allowed: []string{"10.1.2.3:80", "192.168.0.1:80", "example.com:443", "[0:0::1]:80"},
+			denied:  []string{"11.0.0.1:80", "192.168.0.1:81", "other.com:443", "127.0.0.1:80"},
+		},
+		{
+			name:    "allowlist and localhost",
+			opts:    ServerOptions{LocalhostOnly: true, AllowedTargets: []string{"127.0.0.1:80"}},
+			allowed: []string{"127.0.0.1:80"},
+			denied:  []string{"127.0.0.1:81", "10.0.0.1:80"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServerWithOptions(tt.opts)
+			assert.NoError(t, err)
+			for _, target := range tt.allowed {
+				if err := s.targetAllowed(target); err != nil {
+					t.Errorf("expected %v to be allowed: %v", target, err)
+				}
+			}
+			for _, target := range tt.denied {
+				if err := s.targetAllowed(target); err == nil {
+					t.Errorf("expected %v to be denied", target)
+				}
+			}
+		})
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	mt := monitortest.New(t)
+	testAddr := newEchoServer(t)
+	s, err := NewServerWithOptions(ServerOptions{LocalhostOnly: true})
+	assert.NoError(t, err)
+	d := NewDialer(Config{ProxyAddress: serve(t, s)})
+
+	c, err := d.Dial("tcp", testAddr)
+	assert.NoError(t, err)
+	expectEcho(t, c, "hello")
+	assert.NoError(t, c.Close())
+	expectForbidden(t, func() error {
+		_, err := d.Dial("tcp", "10.0.0.1:80")
+		return err
+	}())
+
+	mt.Assert(streams.Name(), map[string]string{"result": resultSuccess}, monitortest.Exactly(1))
+	mt.Assert(streams.Name(), map[string]string{"result": resultForbiddenTarget}, monitortest.Exactly(1))
+	mt.Assert(receivedBytes.Name(), nil, monitortest.Exactly(5))
+	mt.Assert(sentBytes.Name(), nil, monitortest.Exactly(5))
+	mt.Assert(streamDuration.Name(), nil, func(got any) error {
+		if h := got.(*dto.Histogram); h.GetSampleCount() == 0 {
+			return fmt.Errorf("no stream duration recorded")
+		}
+		return nil
+	})
+}
+
+func expectForbidden(t *testing.T, err error) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected forbidden, got %v", err)
+	}
+}
+
+func TestServerForward(t *testing.T) {
+	testAddr := newEchoServer(t)
+	next, nextHeaders := newRecordingHBONEServer(t)
+	gateway, err := NewServerWithOptions(ServerOptions{
+		Dialer: NewDialer(Config{
+			ProxyAddress: next,
+			Headers:      map[string][]string{"x-forwarded-by": {"gateway"}},
+		}),
+	})
+	assert.NoError(t, err)
+	d := NewDialer(Config{ProxyAddress: serve(t, gateway)})
+	c, err := d.Dial("tcp", testAddr)
+	assert.NoError(t, err)
+	defer c.Close()
+	expectEcho(t, c, "hello")
+	assert.Equal(t, nextHeaders.Load().Get("x-forwarded-by"), "gateway")
+}
@@ -0,0 +1,310 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/slices"
+)
+
+const (
+	// ProtocolConnectUDP is the :protocol of the extended CONNECT (RFC 8441) requests opening
+	// CONNECT-UDP (RFC 9298) streams, carrying UDP payloads in DATAGRAM capsules.
+	ProtocolConnectUDP = "connect-udp"
+
+	// protocolPseudoHeader is the :protocol pseudo-header of extended CONNECT requests. The HTTP/2
+	// library sends and exposes it as a request header.
+	protocolPseudoHeader = ":protocol"
+
+	// capsuleProtocolHeader signals the use of the capsule protocol (RFC 9297, section 3.4).
+	capsuleProtocolHeader = "Capsule-Protocol"
+
+	// udpPathPrefix is the prefix of the default URI template of CONNECT-UDP (RFC 9298, section 2):
+	// /.well-known/masque/udp/{target_host}/{target_port}/
+	udpPathPrefix = "/.well-known/masque/udp/"
+)
+
+// maxUDPPayload is the largest UDP payload which can be sent.
+const maxUDPPayload = 65527
+
+// isUDP returns true for the UDP networks accepted by net.Dial.
+func isUDP(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
+
+// extendedConnectEnabled returns true if the HTTP/2 library accepts extended CONNECT requests. It
+// only does with GODEBUG=http2xconnect=1, read at startup.
+func extendedConnectEnabled() bool {
+	return strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1")
+}
+
+// udpPath returns the path of the CONNECT-UDP request to address, following the default URI template.
+// The colons of IPv6 addresses are percent-encoded.
+func udpPath(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	host = strings.ReplaceAll(url.PathEscape(host), ":", "%3A")
+	return udpPathPrefix + host + "/" + url.PathEscape(port) + "/", nil
+}
+
+// udpTarget returns the target address of a CONNECT-UDP request, from its path.
+func udpTarget(u *url.URL) (string, error) {
+	rest, ok := strings.CutPrefix(u.EscapedPath(), udpPathPrefix)
+	if !ok {
+		return "", fmt.Errorf("unexpected path %q", u.EscapedPath())
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "" {
+		return "", fmt.Errorf("unexpected path %q", u.EscapedPath())
+	}
+	host, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", err
+	}
+	port, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// udpAddr is the address of the target of a CONNECT-UDP stream, which may be a host name.
+type udpAddr string
+
+func (a udpAddr) Network() string { return "udp" }
+
+func (a udpAddr) String() string { return string(a) }
+
+// UDPConn is a UDP flow tunneled over a CONNECT-UDP stream. All datagrams are exchanged with the
+// target the stream was opened to. It implements both net.Conn and net.PacketConn.
+type UDPConn struct {
+	st     *stream
+	target net.Addr
+	local  net.Addr
+
+	// in receives the payloads read from the stream. It is closed, after setting readErr, when the
+	// stream ends.
+	in      chan []byte
+	readErr error
+
+	wmu  sync.Mutex
+	wbuf []byte
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var (
+	_ net.Conn       = &UDPConn{}
+	_ net.PacketConn = &UDPConn{}
+)
+
+func newUDPConn(st *stream, target string, local net.Addr) *UDPConn {
+	c := &UDPConn{
+		st:            st,
+		target:        udpAddr(target),
+		local:         local,
+		in:            make(chan []byte, 16),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		closed:        make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *UDPConn) readLoop() {
+	defer close(c.in)
+	r := bufio.NewReader(c.st.resp.Body)
+	buf := make([]byte, maxCapsuleLength)
+	for {
+		payload, err := readUDPDatagram(r, buf)
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		select {
+		case c.in <- slices.Clone(payload):
+		case <-c.closed:
+			c.readErr = net.ErrClosed
+			return
+		}
+	}
+}
+
+// ReadFrom reads the next datagram from the target. If p is too small, the datagram is truncated.
+func (c *UDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case b, ok := <-c.in:
+		if !ok {
+			return 0, nil, c.readErr
+		}
+		return copy(p, b), c.target, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends p as a datagram to the target. addr must be nil or the target address.
+func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr != nil && addr.String() != c.target.String() {
+		return 0, fmt.Errorf("can not send to %v: the tunnel is connected to %v", addr, c.target)
+	}
+	if len(p) > maxUDPPayload {
+		return 0, fmt.Errorf("datagram of %d bytes is too large", len(p))
+	}
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.writeDeadline.wait():
+		// Writes to the stream do not block for long, so the deadline is only checked before writing.
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf = appendUDPDatagram(c.wbuf[:0], p)
+	if _, err := c.st.body.Write(c.wbuf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read implements net.Conn
+func (c *UDPConn) Read(p []byte) (int, error) {
+	n, _, err := c.ReadFrom(p)
+	return n, err
+}
+
+// Write implements net.Conn
+func (c *UDPConn) Write(p []byte) (int, error) {
+	return c.WriteTo(p, nil)
+}
+
+// Close closes the stream.
+func (c *UDPConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.st.body.Close()
+		_ = c.st.resp.Body.Close()
+		c.st.cancel()
+	})
+	return nil
+}
+
+// LocalAddr returns the local address of the connection to the proxy.
+func (c *UDPConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the address of the target.
+func (c *UDPConn) RemoteAddr() net.Addr {
+	return c.target
+}
+
+func (c *UDPConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *UDPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *UDPConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// forwardUDP forwards the datagrams of a CONNECT-UDP stream to the target.
+func forwardUDP(w http.ResponseWriter, r *http.Request, dst net.Conn, log *istiolog.Scope) (sent, received int64) {
+	w.Header().Set(capsuleProtocolHeader, "?1")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	done := make(chan struct{})
+	go func() {
+		// downstream (hbone client) <-- upstream (app)
+		defer close(done)
+		buf := make([]byte, maxUDPPayload)
+		var out []byte
+		for {
+			n, err := dst.Read(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Debugf("read from target: %v", err)
+				}
+				return
+			}
+			out = appendUDPDatagram(out[:0], buf[:n])
+			if _, err := w.Write(out); err != nil {
+				log.Debugf("write to client: %v", err)
+				return
+			}
+			w.(http.Flusher).Flush()
+			sent += int64(n)
+		}
+	}()
+
+	// downstream (hbone client) --> upstream (app)
+	br := bufio.NewReader(r.Body)
+	buf := make([]byte, maxCapsuleLength)
+	for {
+		payload, err := readUDPDatagram(br, buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debugf("read from client: %v", err)
+			}
+			break
+		}
+		if _, err := dst.Write(payload); err != nil {
+			log.Debugf("write to target: %v", err)
+		} else {
+			received += int64(len(payload))
+		}
+	}
+	// The client closed the stream; stop reading from the target.
+	_ = dst.Close()
+	<-done
+	return sent, received
+}
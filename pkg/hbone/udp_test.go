@@ -0,0 +1,170 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hbone
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+// newUDPEchoServer starts a UDP server writing back each datagram it receives.
+func newUDPEchoServer(t *testing.T) string {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		buf := make([]byte, maxUDPPayload)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = c.WriteTo(buf[:n], addr)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c.LocalAddr().String()
+}
+
+func TestUDPPath(t *testing.T) {
+	cases := []struct {
+		address string
+		path    string
+	}{
+		{"10.0.0.10:53", "/.well-known/masque/udp/10.0.0.10/53/"},
+		{"[2001:db8::42]:443", "/.well-known/masque/udp/2001%3Adb8%3A%3A42/443/"},
+		{"example.com:8443", "/.well-known/masque/udp/example.com/8443/"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.address, func(t *testing.T) {
+			path, err := udpPath(tt.address)
+			assert.NoError(t, err)
+			assert.Equal(t, path, tt.path)
+			u, err := url.Parse("https://proxy:15008" + path)
+			assert.NoError(t, err)
+			target, err := udpTarget(u)
+			assert.NoError(t, err)
+			assert.Equal(t, target, tt.address)
+		})
+	}
+	for _, path := range []string{"/", "/.well-known/masque/udp/10.0.0.10/", "/.well-known/masque/udp/10.0.0.10/53", "/.well-known/masque/udp//53/"} {
+		u, err := url.Parse("https://proxy:15008" + path)
+		assert.NoError(t, err)
+		if _, err := udpTarget(u); err == nil {
+			t.Fatalf("expected %q to be rejected", path)
+		}
+	}
+}
+
+func TestDialerUDP(t *testing.T) {
+	target := newUDPEchoServer(t)
+	authorized := atomic.NewString("")
+	s, err := NewServerWithOptions(ServerOptions{
+		UDP:           true,
+		LocalhostOnly: true,
+		Authorize: func(_ context.Context, req AuthorizationRequest) error {
+			authorized.Store(req.Target)
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	d := NewDialer(Config{ProxyAddress: serve(t, s), UDP: true})
+
+	c, err := d.Dial("udp", target)
+	assert.NoError(t, err)
+	defer c.Close()
+	pc, ok := c.(net.PacketConn)
+	if !ok {
+		t.Fatalf("expected a net.PacketConn, got %T", c)
+	}
+	assert.Equal(t, c.RemoteAddr().String(), target)
+	// The target is sent in the path of the extended CONNECT
+	assert.Equal(t, authorized.Load(), target)
+
+	// Datagram boundaries are preserved
+	for _, msg := range []string{"hello", "", strings.Repeat("x", 10000)} {
+		_, err := pc.WriteTo([]byte(msg), c.RemoteAddr())
+		assert.NoError(t, err)
+		buf := make([]byte, maxUDPPayload)
+		assert.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, addr, err := pc.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf[:n]), msg)
+		assert.Equal(t, addr.String(), target)
+	}
+
+	// The tunnel is bound to a single target
+	if _, err := pc.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}); err == nil {
+		t.Fatalf("expected write to another address to fail")
+	}
+
+	// Deadlines
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	if _, err := c.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	// Extending the deadline after it expired allows reading again
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = c.Write([]byte("again"))
+	assert.NoError(t, err)
+	buf := make([]byte, 10)
+	n, err := c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf[:n]), "again")
+
+	assert.NoError(t, c.Close())
+	if _, err := c.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
+
+func TestDialerUDPDisabled(t *testing.T) {
+	target := newUDPEchoServer(t)
+	s, err := NewServerWithOptions(ServerOptions{})
+	assert.NoError(t, err)
+	proxy := serve(t, s)
+
+	// The server does not accept CONNECT-UDP unless enabled
+	_, err = NewDialer(Config{ProxyAddress: proxy, UDP: true}).Dial("udp", target)
+	if err == nil || !strings.Contains(err.Error(), "501") {
+		t.Fatalf("expected not implemented, got %v", err)
+	}
+
+	// Without UDP, the dialer connects directly
+	c, err := NewDialer(Config{ProxyAddress: proxy}).Dial("udp", target)
+	assert.NoError(t, err)
+	defer c.Close()
+	if _, ok := c.(*net.UDPConn); !ok {
+		t.Fatalf("expected a direct UDP connection, got %T", c)
+	}
+}
+
+func TestServerUDPRequiresExtendedConnect(t *testing.T) {
+	t.Setenv("GODEBUG", "")
+	if _, err := NewServerWithOptions(ServerOptions{UDP: true}); err == nil {
+		t.Fatal("expected an error without extended CONNECT")
+	}
+}
@@ -30,12 +30,15 @@ var bufferPoolCopy = sync.Pool{New: func() any {
 	return make([]byte, 0, 32*1024)
 }}
 
-func copyBuffered(dst io.Writer, src io.Reader, log *istiolog.Scope) {
+// copyBuffered copies from src to dst until src is done, then closes the write side of dst.
+// It returns the number of bytes written to dst.
+func copyBuffered(dst io.Writer, src io.Reader, log *istiolog.Scope) int64 {
 	buf1 := bufferPoolCopy.Get().([]byte)
 	// nolint: staticcheck
 	defer bufferPoolCopy.Put(buf1)
 	bufCap := cap(buf1)
 	buf := buf1[0:bufCap:bufCap]
+	var written int64
 
 	// For netstack: src is a gonet.Conn, doesn't implement WriterTo. Dst is a net.TcpConn - and implements ReadFrom.
 	// CopyBuffered is the actual implementation of Copy and CopyBuffer.
@@ -62,6 +65,9 @@ func copyBuffered(dst io.Writer, src io.Reader, log *istiolog.Scope) {
 		if nr > 0 { // before dealing with the read error
 			nw, ew := dst.Write(buf[0:nr])
 			log.Debugf("write %v/%v", nw, ew)
+			if nw > 0 {
+				written += int64(nw)
+			}
 			if f, ok := dst.(http.Flusher); ok {
 				f.Flush()
 			}
@@ -69,13 +75,13 @@ func copyBuffered(dst io.Writer, src io.Reader, log *istiolog.Scope) {
 				ew = io.ErrShortWrite
 			}
 			if ew != nil {
-				return
+				return written
 			}
 		}
 		if err != nil {
 			// read is already closed - we need to close out
 			_ = closeWriter(dst)
-			return
+			return written
 		}
 	}
 }
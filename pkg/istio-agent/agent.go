@@ -16,6 +16,7 @@ package istioagent
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/netip"
@@ -24,6 +25,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
@@ -70,6 +72,15 @@ const (
 	MetadataClientCertChain = "ISTIO_META_TLS_CLIENT_CERT_CHAIN"
 	// MetadataClientRootCert is ISTIO_META env var used for client root cert.
 	MetadataClientRootCert = "ISTIO_META_TLS_CLIENT_ROOT_CERT"
+
+	// MetadataDNSUpstreams is the proxy metadata with the upstream DNS servers, used if not set by the agent options.
+	// Set in the default proxy config of the mesh config, it applies to all the proxies of the mesh.
+	MetadataDNSUpstreams = "DNS_UPSTREAMS"
+	// MetadataDNSUpstreamRootCert is the proxy metadata with the root certificates of TLS upstream DNS servers.
+	MetadataDNSUpstreamRootCert = "DNS_UPSTREAM_ROOT_CERT"
+	// MetadataDNSUpstreamWorkloadCert is the proxy metadata which, if true, makes the agent present the workload
+	// certificate to TLS upstream DNS servers.
+	MetadataDNSUpstreamWorkloadCert = "DNS_UPSTREAM_WORKLOAD_CERT"
 )
 
 var _ ready.Prober = &Agent{}
@@ -141,6 +152,8 @@ type Agent struct {
 
 	sdsServer   SDSService
 	secretCache *cache.SecretManagerClient
+	// dnsSecretCache is secretCache, for the local DNS server which is started before it is created.
+	dnsSecretCache atomic.Pointer[cache.SecretManagerClient]
 
 	// Used when proxying envoy xds via istio-agent is enabled.
 	xdsProxy    *XdsProxy
@@ -169,6 +182,13 @@ type AgentOptions struct {
 	DNSAddr string
 	// DNSForwardParallel indicates whether the agent should send parallel DNS queries to all upstream nameservers.
 	DNSForwardParallel bool
+	// DNSCache configures the cache of the responses of the upstream DNS servers.
+	DNSCache dnsClient.CacheOptions
+	// DNSUpstreams configures the upstream DNS servers, such as DNS-over-TLS or DNS-over-HTTPS servers.
+	DNSUpstreams dnsClient.UpstreamOptions
+	// DNSUpstreamWorkloadCert, if true, authenticates the agent to TLS upstream DNS servers with the
+	// workload certificate.
+	DNSUpstreamWorkloadCert bool
 	// ProxyType is the type of proxy we are configured to handle
 	ProxyType model.NodeType
 	// ProxyNamespace to use for local dns resolution
@@ -432,6 +452,7 @@ func (a *Agent) initSdsServer() error {
 	if err != nil {
 		return fmt.Errorf("failed to start workload secret manager %v", err)
 	}
+	a.dnsSecretCache.Store(a.secretCache)
 
 	if a.cfg.DisableEnvoy {
 		// For proxyless we don't need an SDS server, but still need the keys and
@@ -513,7 +534,7 @@ func (a *Agent) initLocalDNSServer() (err error) {
 	// we don't need dns server on gateways
 	if a.cfg.DNSCapture && a.cfg.ProxyType == model.SidecarProxy {
 		if a.localDNSServer, err = dnsClient.NewLocalDNSServer(a.cfg.ProxyNamespace, a.cfg.ProxyDomain, a.cfg.DNSAddr,
-			a.cfg.DNSForwardParallel); err != nil {
+			a.cfg.DNSForwardParallel, a.cfg.DNSCache, a.dnsUpstreams()); err != nil {
 			return err
 		}
 		a.localDNSServer.StartDNS()
@@ -521,6 +542,40 @@ func (a *Agent) initLocalDNSServer() (err error) {
 	return nil
 }
 
+// dnsUpstreams returns the upstream servers of the local DNS server. The settings missing from the agent options
+// are taken from the proxy metadata, which may come from the mesh config or the proxy.istio.io/config annotation.
+func (a *Agent) dnsUpstreams() dnsClient.UpstreamOptions {
+	upstreams := a.cfg.DNSUpstreams
+	md := a.proxyConfig.GetProxyMetadata()
+	if len(upstreams.Servers) == 0 && md[MetadataDNSUpstreams] != "" {
+		upstreams.Servers = strings.Split(md[MetadataDNSUpstreams], ",")
+	}
+	if upstreams.RootCertFile == "" {
+		upstreams.RootCertFile = md[MetadataDNSUpstreamRootCert]
+	}
+	if a.cfg.DNSUpstreamWorkloadCert || md[MetadataDNSUpstreamWorkloadCert] == "true" {
+		upstreams.ClientCertificate = a.dnsClientCertificate
+	}
+	return upstreams
+}
+
+// dnsClientCertificate returns the workload certificate, for the local DNS server to authenticate to upstream servers.
+func (a *Agent) dnsClientCertificate() (*tls.Certificate, error) {
+	st := a.dnsSecretCache.Load()
+	if st == nil {
+		return nil, errors.New("workload certificate is not available yet")
+	}
+	item, err := st.GenerateSecret(security.WorkloadKeyCertResourceName)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(item.CertificateChain, item.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
 func (a *Agent) generateGRPCBootstrap() error {
 	// generate metadata
 	node, err := a.generateNodeMetadata()
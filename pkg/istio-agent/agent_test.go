@@ -108,6 +108,36 @@ func TestServiceNode(t *testing.T) {
 	}
 }
 
+func TestDNSUpstreams(t *testing.T) {
+	metadata := map[string]string{
+		MetadataDNSUpstreams:            "tls://1.1.1.1?servername=cloudflare-dns.com,https://8.8.8.8/dns-query",
+		MetadataDNSUpstreamRootCert:     "/etc/dns/root-cert.pem",
+		MetadataDNSUpstreamWorkloadCert: "true",
+	}
+	a := &Agent{proxyConfig: &meshconfig.ProxyConfig{ProxyMetadata: metadata}, cfg: &AgentOptions{}}
+	upstreams := a.dnsUpstreams()
+	if !reflect.DeepEqual(upstreams.Servers, []string{"tls://1.1.1.1?servername=cloudflare-dns.com", "https://8.8.8.8/dns-query"}) {
+		t.Fatalf("unexpected servers from the proxy metadata: %v", upstreams.Servers)
+	}
+	if upstreams.RootCertFile != "/etc/dns/root-cert.pem" || upstreams.ClientCertificate == nil {
+		t.Fatalf("unexpected TLS settings from the proxy metadata: %+v", upstreams)
+	}
+
+	// The agent options take precedence over the proxy metadata.
+	a.cfg.DNSUpstreams.Servers = []string{"tls://9.9.9.9"}
+	a.cfg.DNSUpstreams.RootCertFile = "/etc/certs/root-cert.pem"
+	upstreams = a.dnsUpstreams()
+	if !reflect.DeepEqual(upstreams.Servers, []string{"tls://9.9.9.9"}) || upstreams.RootCertFile != "/etc/certs/root-cert.pem" {
+		t.Fatalf("unexpected upstreams with agent options: %+v", upstreams)
+	}
+
+	a.proxyConfig = &meshconfig.ProxyConfig{}
+	a.cfg = &AgentOptions{}
+	if upstreams = a.dnsUpstreams(); len(upstreams.Servers) != 0 || upstreams.ClientCertificate != nil {
+		t.Fatalf("unexpected upstreams without configuration: %+v", upstreams)
+	}
+}
+
 func TestAgent(t *testing.T) {
 	test.SetForTest(t, &version.Info.Version, "version")
 
@@ -153,6 +183,12 @@ func TestAgent(t *testing.T) {
 			return a
 		}).Check(t, security.WorkloadKeyCertResourceName, security.RootCertReqResourceName)
 	})
+	t.Run("Ed25519", func(t *testing.T) {
+		Setup(t, func(a AgentTest) AgentTest {
+			a.Security.ECCSigAlg = string(pkiutil.Ed25519SigAlg)
+			return a
+		}).Check(t, security.WorkloadKeyCertResourceName, security.RootCertReqResourceName)
+	})
 	t.Run("Kubernetes defaults output key and cert", func(t *testing.T) {
 		// same as "Kubernetes defaults", but also output the key and cert. This can be used for tools
 		// that expect certs as files, like Prometheus.
@@ -17,6 +17,8 @@ package krt_test
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -35,6 +37,7 @@ import (
 	"istio.io/istio/pkg/log"
 	"istio.io/istio/pkg/slices"
 	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/retry"
 )
 
 type Workload struct {
@@ -52,14 +55,21 @@ func (s ServiceWrapper) GetLabelSelector() map[string]string {
 
 var _ krt.LabelSelectorer = ServiceWrapper{}
 
-func NewModern(c kube.Client, events chan string, _ <-chan struct{}) {
-	Pods := krt.NewInformer[*v1.Pod](c)
-	Services := krt.NewInformer[*v1.Service](c, krt.WithObjectAugmentation(func(o any) any {
+func NewModern(c kube.Client, events chan string, stop <-chan struct{}) {
+	Workloads := newWorkloads(c, stop)
+	Workloads.Register(func(e krt.Event[Workload]) {
+		events <- fmt.Sprintf(e.Latest().Name, e.Event)
+	})
+}
+
+func newWorkloads(c kube.Client, stop <-chan struct{}, opts ...krt.CollectionOption) krt.Collection[Workload] {
+	Pods := krt.NewInformer[*v1.Pod](c, krt.WithStop(stop))
+	Services := krt.NewInformer[*v1.Service](c, krt.WithStop(stop), krt.WithObjectAugmentation(func(o any) any {
 		return ServiceWrapper{o.(*v1.Service)}
 	}))
 	ServicesByNamespace := krt.NewNamespaceIndex(Services)
 
-	Workloads := krt.NewCollection(Pods, func(ctx krt.HandlerContext, p *v1.Pod) *Workload {
+	return krt.NewCollection(Pods, func(ctx krt.HandlerContext, p *v1.Pod) *Workload {
 		if p.Status.PodIP == "" {
 			return nil
 		}
@@ -69,10 +79,7 @@ func NewModern(c kube.Client, events chan string, _ <-chan struct{}) {
 			IP:           p.Status.PodIP,
 			ServiceNames: slices.Map(services, func(e *v1.Service) string { return e.Name }),
 		}
-	})
-	Workloads.Register(func(e krt.Event[Workload]) {
-		events <- fmt.Sprintf(e.Latest().Name, e.Event)
-	})
+	}, append(opts, krt.WithStop(stop))...)
 }
 
 type legacy struct {
@@ -180,9 +187,8 @@ func drainN(c chan string, n int) {
 	}
 }
 
-func BenchmarkControllers(b *testing.B) {
-	log.FindScope("krt").SetOutputLevel(log.InfoLevel)
-	watch.DefaultChanSize = 100_000
+// benchmarkObjects returns 1000 pods, selected by 50 services.
+func benchmarkObjects() ([]*v1.Pod, []*v1.Service) {
 	initialPods := []*v1.Pod{}
 	for i := 0; i < 1000; i++ {
 		initialPods = append(initialPods, &v1.Pod{
@@ -216,6 +222,13 @@ func BenchmarkControllers(b *testing.B) {
 			},
 		})
 	}
+	return initialPods, initialServices
+}
+
+func BenchmarkControllers(b *testing.B) {
+	log.FindScope("krt").SetOutputLevel(log.InfoLevel)
+	watch.DefaultChanSize = 100_000
+	initialPods, initialServices := benchmarkObjects()
 	benchmark := func(b *testing.B, fn func(client kube.Client, events chan string, stop <-chan struct{})) {
 		c := kube.NewFakeClient()
 		events := make(chan string, 1000)
@@ -261,3 +274,56 @@ func BenchmarkControllers(b *testing.B) {
 		benchmark(b, NewLegacy)
 	})
 }
+
+// BenchmarkStartup measures the time for a collection to be synced and complete, when started cold from its
+// inputs, and warm from a snapshot.
+func BenchmarkStartup(b *testing.B) {
+	log.FindScope("krt").SetOutputLevel(log.WarnLevel)
+	watch.DefaultChanSize = 100_000
+	initialPods, initialServices := benchmarkObjects()
+	start := func(b *testing.B, opts ...krt.CollectionOption) chan struct{} {
+		b.StopTimer()
+		c := kube.NewFakeClient()
+		stop := make(chan struct{})
+		pods := clienttest.NewWriter[*v1.Pod](b, c)
+		services := clienttest.NewWriter[*v1.Service](b, c)
+		for _, p := range initialPods {
+			pods.Create(p)
+		}
+		for _, s := range initialServices {
+			services.Create(s)
+		}
+		b.StartTimer()
+		workloads := newWorkloads(c, stop, opts...)
+		go c.RunAndWait(stop)
+		workloads.Synced().WaitUntilSynced(stop)
+		if n := len(workloads.List()); n != len(initialPods) {
+			b.Fatalf("expected %d workloads, got %d", len(initialPods), n)
+		}
+		b.StopTimer()
+		return stop
+	}
+	b.Run("cold", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			stop := start(b)
+			close(stop)
+		}
+	})
+	b.Run("warm", func(b *testing.B) {
+		file := filepath.Join(b.TempDir(), "workloads.json")
+		// Populate the snapshot with a cold start
+		stop := start(b, krt.WithSnapshot(file))
+		if err := retry.UntilSuccess(func() error {
+			_, err := os.Stat(file)
+			return err
+		}); err != nil {
+			b.Fatal(err)
+		}
+		close(stop)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			stop := start(b, krt.WithSnapshot(file))
+			close(stop)
+		}
+	})
+}
@@ -39,7 +39,7 @@ type manyCollection[I, O any] struct {
 	collectionName string
 	id             collectionUID
 	// parent is the input collection we are building off of.
-	parent Collection[I]
+	parent internalCollection[I]
 
 	// log is a logger for the collection, with additional labels already added to identify it.
 	log *istiolog.Scope
@@ -68,6 +68,15 @@ type manyCollection[I, O any] struct {
 	augmentation func(a any) any
 	synced       chan struct{}
 	stop         <-chan struct{}
+
+	// pendingSnapshot holds the entries loaded from the snapshot whose inputs were not seen yet. It is only set until
+	// the collection reconciled with its inputs. This is protected by recomputeMu.
+	pendingSnapshot map[Key[I]]snapshotEntry[O]
+	// snapshotDirty is notified when the snapshot should be rewritten. It is nil when snapshots are disabled.
+	snapshotDirty chan struct{}
+
+	// stats records the activity of the collection, for debugging.
+	stats *collectionStats
 }
 
 var _ internalCollection[any] = &manyCollection[any, any]{}
@@ -168,13 +177,15 @@ func (h *manyCollection[I, O]) onPrimaryInputEvent(items []Event[I], lock bool)
 		}
 		items[idx] = ev
 	}
-	h.onPrimaryInputEventLocked(items)
+	h.onPrimaryInputEventLocked(items, h.parent.uid())
 }
 
 // onPrimaryInputEventLocked takes a list of I's that changed and reruns the handler over them.
+// The trigger is the collection whose change caused the recomputation.
 // This should be called with recomputeMu acquired.
-func (h *manyCollection[I, O]) onPrimaryInputEventLocked(items []Event[I]) {
+func (h *manyCollection[I, O]) onPrimaryInputEventLocked(items []Event[I], trigger collectionUID) {
 	var events []Event[O]
+	recomputes := 0
 	recomputedResults := make([]map[Key[O]]O, len(items))
 	for idx, a := range items {
 		if a.Event == controllers.EventDelete {
@@ -184,7 +195,14 @@ func (h *manyCollection[I, O]) onPrimaryInputEventLocked(items []Event[I]) {
 		i := a.Latest()
 		iKey := GetKey(i)
 
+		if entry, f := h.pendingSnapshot[iKey]; f && entry.reusable(i) {
+			// The input did not change since the snapshot, and its outputs do not depend on anything else.
+			recomputedResults[idx] = slices.GroupUnique(entry.Outputs, GetKey[O])
+			h.objectDependencies[iKey] = nil
+			continue
+		}
 		ctx := &collectionDependencyTracker[I, O]{h, nil, iKey}
+		recomputes++
 		results := slices.GroupUnique(h.transformation(ctx, i), GetKey[O])
 		recomputedResults[idx] = results
 		// Update the I -> Dependency mapping
@@ -196,6 +214,7 @@ func (h *manyCollection[I, O]) onPrimaryInputEventLocked(items []Event[I]) {
 	for idx, a := range items {
 		i := a.Latest()
 		iKey := GetKey(i)
+		delete(h.pendingSnapshot, iKey)
 		if a.Event == controllers.EventDelete {
 			for oKey := range h.collectionState.mappings[iKey] {
 				oldRes, f := h.collectionState.outputs[oKey]
@@ -258,11 +277,16 @@ func (h *manyCollection[I, O]) onPrimaryInputEventLocked(items []Event[I]) {
 		}
 	}
 	h.mu.Unlock()
+	if len(items) > 0 {
+		h.markSnapshotDirty()
+	}
+	h.stats.recomputed(trigger, recomputes)
 
 	// Short circuit if we have nothing to do
 	if len(events) == 0 {
 		return
 	}
+	h.stats.sent(len(events))
 	handlers := h.eventHandlers.Get()
 
 	if h.log.DebugEnabled() {
@@ -352,6 +376,33 @@ func newManyCollection[I, O any](cc Collection[I], hf TransformationMulti[I, O],
 		augmentation:  opts.augmentation,
 		synced:        make(chan struct{}),
 		stop:          opts.stop,
+		stats:         newCollectionStats(),
+	}
+	h.stats.addDependency(c.uid(), c.name(), true)
+	registerDebug(h.id, debugEntry{
+		name:     h.collectionName,
+		kind:     "collection",
+		typeName: ptr.TypeName[O](),
+		stop:     opts.debugStop(),
+		stats:    h.stats,
+		size: func() int {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return len(h.collectionState.outputs)
+		},
+		synced: h.Synced().HasSynced,
+	})
+	warm := false
+	if opts.snapshot != "" {
+		h.snapshotDirty = make(chan struct{}, 1)
+		warm = h.loadSnapshot(opts.snapshot)
+	}
+	if warm {
+		// The snapshot is served right away. Handlers registered from now on get it as their initial state,
+		// and the reconciliation with the inputs is sent as regular events.
+		h.eventHandlers.MarkInitialized()
+		close(h.synced)
+		h.log.Infof("%v synced from snapshot", h.name())
 	}
 	go func() {
 		// Wait for primary dependency to be ready
@@ -363,8 +414,12 @@ func newManyCollection[I, O any](cc Collection[I], hf TransformationMulti[I, O],
 		// When we run RegisterBatch, it will trigger events for the initial state. However, other events could trigger
 		// while we are processing these.
 		// By holding the lock, we ensure we have exclusive access during this time.
-		h.recomputeMu.Lock()
-		h.eventHandlers.MarkInitialized()
+		// When started from a snapshot, handlers are already initialized, so each batch is processed like any later
+		// event instead; this avoids blocking the handlers registering on the warm collection during the reconciliation.
+		if !warm {
+			h.recomputeMu.Lock()
+			h.eventHandlers.MarkInitialized()
+		}
 		handlerReg := c.RegisterBatch(func(events []Event[I], initialSync bool) {
 			if log.DebugEnabled() {
 				h.log.WithLabels("dep", "primary", "batch", len(events)).
@@ -372,16 +427,28 @@ func newManyCollection[I, O any](cc Collection[I], hf TransformationMulti[I, O],
 			}
 			// Lock after the initial sync only
 			// For initial sync we explicitly hold the lock ourselves to ensure we have a broad enough critical section.
-			lock := !initialSync
+			lock := !initialSync || warm
 			h.onPrimaryInputEvent(events, lock)
 		}, true)
 		if !handlerReg.WaitUntilSynced(h.stop) {
-			h.recomputeMu.Unlock()
+			if !warm {
+				h.recomputeMu.Unlock()
+			}
 			return
 		}
-		h.recomputeMu.Unlock()
-		close(h.synced)
-		h.log.Infof("%v synced", h.name())
+		if warm {
+			h.recomputeMu.Lock()
+			h.dropStaleSnapshot()
+			h.recomputeMu.Unlock()
+		} else {
+			h.recomputeMu.Unlock()
+			close(h.synced)
+			h.log.Infof("%v synced", h.name())
+		}
+		if h.snapshotDirty != nil {
+			h.markSnapshotDirty()
+			go h.runSnapshotWriter(opts.snapshot)
+		}
 	}()
 	return h
 }
@@ -438,7 +505,7 @@ func (h *manyCollection[I, O]) onSecondaryDependencyEvent(sourceCollection colle
 			})
 		}
 	}
-	h.onPrimaryInputEventLocked(toRun)
+	h.onPrimaryInputEventLocked(toRun, sourceCollection)
 }
 
 func (h *manyCollection[I, O]) objectChanged(iKey Key[I], dependencies []*dependency, sourceCollection collectionUID, ev Event[any]) bool {
@@ -557,6 +624,7 @@ func (i *collectionDependencyTracker[I, O]) registerDependency(
 	// For any new collections we depend on, start watching them if its the first time we have watched them.
 	if !i.collectionDependencies.InsertContains(d.id) {
 		i.log.WithLabels("collection", d.collectionName).Debugf("register new dependency")
+		i.stats.addDependency(d.id, d.collectionName, false)
 		syncer.WaitUntilSynced(i.stop)
 		register(func(o []Event[any], initialSync bool) {
 			i.onSecondaryDependencyEvent(d.id, o)
@@ -0,0 +1,228 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/maps"
+)
+
+// DebugCollection describes a collection, for debugging.
+type DebugCollection struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+	// Kind is the kind of collection, such as informer, collection or join.
+	Kind string `json:"kind"`
+	// Type is the type of the objects of the collection.
+	Type   string `json:"type"`
+	Synced bool   `json:"synced"`
+	Size   int    `json:"size"`
+	// Dependencies are the collections this collection is computed from.
+	Dependencies []DebugDependency `json:"dependencies,omitempty"`
+	// Recomputes is the number of times the transformation was run.
+	Recomputes uint64 `json:"recomputes"`
+	// Events is the number of events sent by the collection.
+	Events    uint64     `json:"events"`
+	LastEvent *time.Time `json:"lastEvent,omitempty"`
+}
+
+// DebugDependency describes a dependency of a collection, for debugging.
+type DebugDependency struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+	// Primary is true for the input collection, and false for collections fetched from the transformation.
+	Primary bool `json:"primary"`
+	// Recomputes is the number of times a change of the dependency ran the transformation.
+	Recomputes uint64 `json:"recomputes"`
+}
+
+// collectionStats records the activity of a collection, for debugging.
+type collectionStats struct {
+	mu           sync.Mutex
+	dependencies map[collectionUID]*DebugDependency
+	recomputes   uint64
+	events       uint64
+	lastEvent    time.Time
+}
+
+func newCollectionStats() *collectionStats {
+	return &collectionStats{dependencies: map[collectionUID]*DebugDependency{}}
+}
+
+// addDependency records a dependency of the collection.
+func (s *collectionStats) addDependency(id collectionUID, name string, primary bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, f := s.dependencies[id]; !f {
+		s.dependencies[id] = &DebugDependency{ID: uint64(id), Name: name, Primary: primary}
+	}
+}
+
+// recomputed records that n transformations were run because of a change of the dependency.
+func (s *collectionStats) recomputed(dependency collectionUID, n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recomputes += uint64(n)
+	if d, f := s.dependencies[dependency]; f {
+		d.Recomputes += uint64(n)
+	}
+}
+
+// sent records that n events were sent by the collection.
+func (s *collectionStats) sent(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events += uint64(n)
+	s.lastEvent = time.Now()
+}
+
+func (s *collectionStats) fill(c *DebugCollection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Recomputes = s.recomputes
+	c.Events = s.events
+	if !s.lastEvent.IsZero() {
+		t := s.lastEvent
+		c.LastEvent = &t
+	}
+	for _, d := range s.dependencies {
+		c.Dependencies = append(c.Dependencies, *d)
+	}
+	sort.Slice(c.Dependencies, func(i, j int) bool { return c.Dependencies[i].ID < c.Dependencies[j].ID })
+}
+
+// debugEntry is a collection registered for debugging.
+type debugEntry struct {
+	name     string
+	kind     string
+	typeName string
+	stop     <-chan struct{}
+	stats    *collectionStats
+	size     func() int
+	synced   func() bool
+}
+
+// debugRegistry holds all the collections, for debugging.
+var debugRegistry = struct {
+	mu          sync.Mutex
+	collections map[collectionUID]debugEntry
+}{collections: map[collectionUID]debugEntry{}}
+
+// registerDebug registers a collection for debugging, until its stop channel is closed. Collections without a
+// stop channel are not registered, since the registry would keep them forever.
+func registerDebug(id collectionUID, e debugEntry) {
+	if e.stop == nil {
+		return
+	}
+	debugRegistry.mu.Lock()
+	defer debugRegistry.mu.Unlock()
+	debugRegistry.collections[id] = e
+}
+
+// DebugCollections returns the description of all the running collections built with a stop channel, ordered
+// by creation.
+func DebugCollections() []DebugCollection {
+	debugRegistry.mu.Lock()
+	entries := map[collectionUID]debugEntry{}
+	for id, e := range debugRegistry.collections {
+		select {
+		case <-e.stop:
+			// Stopped collections are dropped lazily, to avoid a goroutine per collection.
+			delete(debugRegistry.collections, id)
+		default:
+			entries[id] = e
+		}
+	}
+	debugRegistry.mu.Unlock()
+
+	ids := maps.Keys(entries)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	res := make([]DebugCollection, 0, len(ids))
+	for _, id := range ids {
+		e := entries[id]
+		c := DebugCollection{
+			ID:     uint64(id),
+			Name:   e.name,
+			Kind:   e.kind,
+			Type:   e.typeName,
+			Synced: e.synced(),
+			Size:   e.size(),
+		}
+		if e.stats != nil {
+			e.stats.fill(&c)
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// WriteDebugGraph writes the dependency graph of the collections, in the Graphviz DOT language. Edges go from a
+// dependency to the collections computed from it, labeled by the number of recomputes it triggered; dashed edges
+// are dependencies fetched from transformations.
+func WriteDebugGraph(w io.Writer, collections []DebugCollection) error {
+	node := func(id uint64) string {
+		return "c" + strconv.FormatUint(id, 10)
+	}
+	b := &debugGraphWriter{w: w}
+	b.printf("digraph krt {\n")
+	b.printf("  rankdir=LR;\n")
+	b.printf("  node [shape=box];\n")
+	known := map[uint64]bool{}
+	for _, c := range collections {
+		known[c.ID] = true
+		b.printf("  %s [label=%q];\n", node(c.ID),
+			fmt.Sprintf("%s\n%s\nsize=%d recomputes=%d events=%d", c.Name, c.Kind, c.Size, c.Recomputes, c.Events))
+	}
+	for _, c := range collections {
+		for _, d := range c.Dependencies {
+			if !known[d.ID] {
+				// Dependencies may not be registered, such as static collections.
+				known[d.ID] = true
+				b.printf("  %s [label=%q, style=dashed];\n", node(d.ID), d.Name)
+			}
+			style := ""
+			if !d.Primary {
+				style = ", style=dashed"
+			}
+			b.printf("  %s -> %s [label=\"%d\"%s];\n", node(d.ID), node(c.ID), d.Recomputes, style)
+		}
+	}
+	b.printf("}\n")
+	return b.err
+}
+
+type debugGraphWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *debugGraphWriter) printf(format string, args ...any) {
+	if b.err != nil {
+		return
+	}
+	_, b.err = fmt.Fprintf(b.w, format, args...)
+}
@@ -0,0 +1,143 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/kclient/clienttest"
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+// debugCollection returns the debug description of the running collection with the name, if any.
+func debugCollection(name string) *krt.DebugCollection {
+	for _, c := range krt.DebugCollections() {
+		if c.Name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+func TestDebugCollections(t *testing.T) {
+	stop := test.NewStop(t)
+	c := kube.NewFakeClient()
+	pods := krt.NewInformer[*corev1.Pod](c, krt.WithName("DebugPods"), krt.WithStop(stop))
+	services := krt.NewInformer[*corev1.Service](c, krt.WithName("DebugServices"), krt.WithStop(stop))
+	simplePods := krt.NewCollection(pods, func(ctx krt.HandlerContext, i *corev1.Pod) *SimplePod {
+		return &SimplePod{Named: NewNamed(i), Labeled: NewLabeled(i.Labels), IP: i.Status.PodIP}
+	}, krt.WithName("DebugSimplePods"), krt.WithStop(stop))
+	endpoints := krt.NewManyCollection(services, func(ctx krt.HandlerContext, svc *corev1.Service) []SimpleEndpoint {
+		pods := krt.Fetch(ctx, simplePods, krt.FilterLabel(svc.Spec.Selector))
+		return slices.Map(pods, func(pod SimplePod) SimpleEndpoint {
+			return SimpleEndpoint{Pod: pod.Name, Service: svc.Name, Namespace: svc.Namespace, IP: pod.IP}
+		})
+	}, krt.WithName("DebugEndpoints"), krt.WithStop(stop))
+	joined := krt.JoinCollection([]krt.Collection[SimplePod]{simplePods}, krt.WithName("DebugJoin"), krt.WithStop(stop))
+
+	pc := clienttest.NewWriter[*corev1.Pod](t, c)
+	sc := clienttest.NewWriter[*corev1.Service](t, c)
+	pc.Create(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "namespace", Labels: map[string]string{"app": "foo"}},
+		Status:     corev1.PodStatus{PodIP: "1.2.3.4"},
+	})
+	sc.Create(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "namespace"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "foo"}},
+	})
+	c.RunAndWait(stop)
+	endpoints.Synced().WaitUntilSynced(stop)
+	joined.Synced().WaitUntilSynced(stop)
+	assert.EventuallyEqual(t, func() int { return len(endpoints.List()) }, 1)
+
+	// Changing the pod recomputes the endpoints through the secondary dependency
+	pc.Update(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "namespace", Labels: map[string]string{"app": "foo"}},
+		Status:     corev1.PodStatus{PodIP: "1.2.3.5"},
+	})
+	assert.EventuallyEqual(t, func() string { return endpoints.List()[0].IP }, "1.2.3.5")
+
+	podsInfo := debugCollection("DebugPods")
+	assert.Equal(t, podsInfo.Kind, "informer")
+	assert.Equal(t, podsInfo.Size, 1)
+	assert.Equal(t, podsInfo.Synced, true)
+	assert.Equal(t, podsInfo.Events, uint64(2))
+
+	simplePodsInfo := debugCollection("DebugSimplePods")
+	assert.Equal(t, simplePodsInfo.Kind, "collection")
+	assert.Equal(t, simplePodsInfo.Recomputes, uint64(2))
+	assert.Equal(t, simplePodsInfo.Events, uint64(2))
+	assert.Equal(t, simplePodsInfo.LastEvent != nil, true)
+	assert.Equal(t, simplePodsInfo.Dependencies, []krt.DebugDependency{
+		{ID: podsInfo.ID, Name: "DebugPods", Primary: true, Recomputes: 2},
+	})
+
+	servicesInfo := debugCollection("DebugServices")
+	endpointsInfo := debugCollection("DebugEndpoints")
+	assert.Equal(t, endpointsInfo.Size, 1)
+	assert.Equal(t, endpointsInfo.Recomputes, uint64(2))
+	assert.Equal(t, endpointsInfo.Dependencies, []krt.DebugDependency{
+		{ID: servicesInfo.ID, Name: "DebugServices", Primary: true, Recomputes: 1},
+		{ID: simplePodsInfo.ID, Name: "DebugSimplePods", Primary: false, Recomputes: 1},
+	})
+
+	joinInfo := debugCollection("DebugJoin")
+	assert.Equal(t, joinInfo.Kind, "join")
+	assert.Equal(t, joinInfo.Size, 1)
+	assert.Equal(t, joinInfo.Dependencies, []krt.DebugDependency{
+		{ID: simplePodsInfo.ID, Name: "DebugSimplePods", Primary: true},
+	})
+
+	sb := &strings.Builder{}
+	assert.NoError(t, krt.WriteDebugGraph(sb, []krt.DebugCollection{*podsInfo, *simplePodsInfo, *servicesInfo, *endpointsInfo}))
+	graph := sb.String()
+	for _, want := range []string{
+		"digraph krt {",
+		`[label="DebugEndpoints\ncollection\nsize=1 recomputes=2 events=2"]`,
+		`c` + strconv.FormatUint(podsInfo.ID, 10) + ` -> c` + strconv.FormatUint(simplePodsInfo.ID, 10) + ` [label="2"];`,
+		`c` + strconv.FormatUint(simplePodsInfo.ID, 10) + ` -> c` + strconv.FormatUint(endpointsInfo.ID, 10) + ` [label="1", style=dashed];`,
+	} {
+		if !strings.Contains(graph, want) {
+			t.Errorf("graph does not contain %q:\n%s", want, graph)
+		}
+	}
+}
+
+func TestDebugCollectionsStopped(t *testing.T) {
+	stop := make(chan struct{})
+	c := kube.NewFakeClient()
+	krt.NewInformer[*corev1.Pod](c, krt.WithName("DebugStopped"), krt.WithStop(stop))
+	c.RunAndWait(stop)
+	assert.Equal(t, debugCollection("DebugStopped") != nil, true)
+	close(stop)
+	assert.Equal(t, debugCollection("DebugStopped") == nil, true)
+}
+
+func TestDebugCollectionsWithoutStop(t *testing.T) {
+	stop := test.NewStop(t)
+	c := kube.NewFakeClient()
+	krt.NewInformer[*corev1.Pod](c, krt.WithName("DebugWithoutStop"))
+	c.RunAndWait(stop)
+	assert.Equal(t, debugCollection("DebugWithoutStop") == nil, true)
+}
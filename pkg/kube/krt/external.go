@@ -0,0 +1,240 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt
+
+import (
+	"fmt"
+	"sync"
+
+	"istio.io/istio/pkg/kube/controllers"
+	istiolog "istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/maps"
+	"istio.io/istio/pkg/ptr"
+)
+
+// ExternalCollection is a Collection fed by a source outside of krt, such as an XDS stream.
+// The source pushes the changes of its objects, and marks the collection synced once it received its initial state.
+type ExternalCollection[T any] interface {
+	Collection[T]
+	// UpdateObject adds or replaces an object.
+	UpdateObject(obj T)
+	// DeleteObject removes the object with the key, if present.
+	DeleteObject(k Key[T])
+	// Reset replaces all the objects, sending events for the differences only.
+	// This is typically used when the source reconnects and sends its full state again.
+	Reset(objs []T)
+	// MarkSynced marks the collection as synced. This should be called once the initial state was pushed.
+	MarkSynced()
+}
+
+type external[T any] struct {
+	collectionName string
+	id             collectionUID
+	log            *istiolog.Scope
+
+	// eventMu serializes the changes and the calls to the handlers, so events are delivered in order.
+	eventMu sync.Mutex
+	// mu protects vals.
+	mu   sync.RWMutex
+	vals map[Key[T]]T
+
+	eventHandlers *handlers[T]
+	augmentation  func(a any) any
+	stats         *collectionStats
+	synced        chan struct{}
+	syncOnce      sync.Once
+}
+
+var _ internalCollection[any] = &external[any]{}
+
+// NewExternalCollection creates an empty ExternalCollection. It is not synced until MarkSynced is called.
+func NewExternalCollection[T any](opts ...CollectionOption) ExternalCollection[T] {
+	o := buildCollectionOptions(opts...)
+	if o.name == "" {
+		o.name = fmt.Sprintf("External[%v]", ptr.TypeName[T]())
+	}
+	c := &external[T]{
+		collectionName: o.name,
+		id:             nextUID(),
+		log:            log.WithLabels("owner", o.name),
+		vals:           map[Key[T]]T{},
+		eventHandlers:  &handlers[T]{},
+		augmentation:   o.augmentation,
+		stats:          newCollectionStats(),
+		synced:         make(chan struct{}),
+	}
+	registerDebug(c.id, debugEntry{
+		name:     c.collectionName,
+		kind:     "external",
+		typeName: ptr.TypeName[T](),
+		stop:     o.debugStop(),
+		stats:    c.stats,
+		size: func() int {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return len(c.vals)
+		},
+		synced: c.Synced().HasSynced,
+	})
+	return c
+}
+
+func (c *external[T]) UpdateObject(obj T) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	k := GetKey(obj)
+	c.mu.Lock()
+	old, f := c.vals[k]
+	c.vals[k] = obj
+	c.mu.Unlock()
+	if !f {
+		c.send([]Event[T]{{New: &obj, Event: controllers.EventAdd}})
+	} else if !equal(old, obj) {
+		c.send([]Event[T]{{Old: &old, New: &obj, Event: controllers.EventUpdate}})
+	}
+}
+
+func (c *external[T]) DeleteObject(k Key[T]) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	c.mu.Lock()
+	old, f := c.vals[k]
+	delete(c.vals, k)
+	c.mu.Unlock()
+	if f {
+		c.send([]Event[T]{{Old: &old, Event: controllers.EventDelete}})
+	}
+}
+
+func (c *external[T]) Reset(objs []T) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	vals := make(map[Key[T]]T, len(objs))
+	for _, obj := range objs {
+		vals[GetKey(obj)] = obj
+	}
+	var events []Event[T]
+	c.mu.Lock()
+	for k, old := range c.vals {
+		if _, f := vals[k]; !f {
+			events = append(events, Event[T]{Old: &old, Event: controllers.EventDelete})
+		}
+	}
+	for k, obj := range vals {
+		old, f := c.vals[k]
+		if !f {
+			events = append(events, Event[T]{New: &obj, Event: controllers.EventAdd})
+		} else if !equal(old, obj) {
+			events = append(events, Event[T]{Old: &old, New: &obj, Event: controllers.EventUpdate})
+		}
+	}
+	c.vals = vals
+	c.mu.Unlock()
+	c.log.Debugf("reset with %d objects, %d changes", len(vals), len(events))
+	c.send(events)
+}
+
+func (c *external[T]) MarkSynced() {
+	c.syncOnce.Do(func() {
+		close(c.synced)
+		c.log.Infof("%v synced", c.name())
+	})
+}
+
+// send calls the handlers with the events. This should be called with eventMu held.
+func (c *external[T]) send(events []Event[T]) {
+	if len(events) == 0 {
+		return
+	}
+	c.stats.sent(len(events))
+	for _, h := range c.eventHandlers.Get() {
+		h(events, false)
+	}
+}
+
+func (c *external[T]) GetKey(k Key[T]) *T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if o, f := c.vals[k]; f {
+		return &o
+	}
+	return nil
+}
+
+func (c *external[T]) List() []T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Values(c.vals)
+}
+
+func (c *external[T]) Register(f func(o Event[T])) Syncer {
+	return registerHandlerAsBatched[T](c, f)
+}
+
+func (c *external[T]) RegisterBatch(f func(o []Event[T], initialSync bool), runExistingState bool) Syncer {
+	// Hold eventMu so the handler gets no event between the existing state and its registration.
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+	if runExistingState {
+		c.mu.RLock()
+		events := make([]Event[T], 0, len(c.vals))
+		for _, o := range c.vals {
+			o := o
+			events = append(events, Event[T]{New: &o, Event: controllers.EventAdd})
+		}
+		c.mu.RUnlock()
+		if len(events) > 0 {
+			f(events, true)
+		}
+	}
+	c.eventHandlers.Insert(f)
+	return c.Synced()
+}
+
+func (c *external[T]) Synced() Syncer {
+	return channelSyncer{
+		name:   c.collectionName,
+		synced: c.synced,
+	}
+}
+
+// nolint: unused // (not true, its to implement an interface)
+func (c *external[T]) dump() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.log.Errorf(">>> BEGIN DUMP")
+	for k, v := range c.vals {
+		c.log.Errorf("%v -> %+v", k, v)
+	}
+	c.log.Errorf("<<< END DUMP")
+}
+
+// nolint: unused // (not true, its to implement an interface)
+func (c *external[T]) augment(a any) any {
+	if c.augmentation != nil {
+		return c.augmentation(a)
+	}
+	return a
+}
+
+// nolint: unused // (not true, its to implement an interface)
+func (c *external[T]) name() string {
+	return c.collectionName
+}
+
+// nolint: unused // (not true, its to implement an interface)
+func (c *external[T]) uid() collectionUID {
+	return c.id
+}
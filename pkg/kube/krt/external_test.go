@@ -0,0 +1,71 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func TestExternalCollection(t *testing.T) {
+	stop := test.NewStop(t)
+	c := krt.NewExternalCollection[SimplePod](krt.WithStop(stop))
+	pod := func(name, ip string) SimplePod {
+		return SimplePod{Named: Named{Name: name, Namespace: "namespace"}, IP: ip}
+	}
+
+	c.UpdateObject(pod("a", "1.1.1.1"))
+	assert.Equal(t, c.Synced().HasSynced(), false)
+	c.MarkSynced()
+	assert.Equal(t, c.Synced().HasSynced(), true)
+
+	tt := assert.NewTracker[string](t)
+	c.Register(func(o krt.Event[SimplePod]) {
+		tt.Record(fmt.Sprintf("%v/%v", o.Event, o.Latest().ResourceName()))
+	})
+	tt.WaitOrdered("add/namespace/a")
+
+	// Derived collections follow the changes
+	ips := krt.NewCollection(c, func(ctx krt.HandlerContext, p SimplePod) *string {
+		return &p.IP
+	}, krt.WithStop(stop))
+	ips.Synced().WaitUntilSynced(stop)
+	assert.Equal(t, len(ips.List()), 1)
+
+	c.UpdateObject(pod("a", "1.1.1.1"))
+	c.UpdateObject(pod("a", "1.1.1.2"))
+	c.UpdateObject(pod("b", "1.1.1.3"))
+	tt.WaitOrdered("update/namespace/a", "add/namespace/b")
+	assert.Equal(t, c.GetKey("namespace/a").IP, "1.1.1.2")
+
+	c.DeleteObject("namespace/a")
+	c.DeleteObject("namespace/unknown")
+	tt.WaitOrdered("delete/namespace/a")
+	assert.Equal(t, c.GetKey("namespace/a"), nil)
+
+	// Reset only sends the differences
+	c.Reset([]SimplePod{pod("b", "1.1.1.3"), pod("c", "1.1.1.4")})
+	tt.WaitOrdered("add/namespace/c")
+	c.Reset([]SimplePod{pod("c", "1.1.1.5")})
+	tt.WaitUnordered("delete/namespace/b", "update/namespace/c")
+	assert.EventuallyEqual(t, func() []string {
+		return slices.Sort(ips.List())
+	}, []string{"1.1.1.5"})
+}
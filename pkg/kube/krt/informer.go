@@ -157,6 +157,19 @@ func WrapClient[I controllers.ComparableObject](c kclient.Informer[I], opts ...C
 		augmentation:   o.augmentation,
 		synced:         make(chan struct{}),
 	}
+	stats := newCollectionStats()
+	c.AddEventHandler(informerEventHandler[I](func(o Event[I], initialSync bool) {
+		stats.sent(1)
+	}))
+	registerDebug(h.id, debugEntry{
+		name:     h.collectionName,
+		kind:     "informer",
+		typeName: ptr.TypeName[I](),
+		stop:     o.debugStop(),
+		stats:    stats,
+		size:     func() int { return len(h.List()) },
+		synced:   h.Synced().HasSynced,
+	})
 
 	go func() {
 		// First, wait for the informer to populate
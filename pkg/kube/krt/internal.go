@@ -62,15 +62,28 @@ func buildCollectionOptions(opts ...CollectionOption) collectionOptions {
 	}
 	if c.stop == nil {
 		c.stop = make(chan struct{})
+		c.defaultStop = true
 	}
 	return *c
 }
 
+// debugStop returns the stop channel of the collection for the debug registry, or nil if the collection was
+// built without one, as it could never be dropped from the registry.
+func (c collectionOptions) debugStop() <-chan struct{} {
+	if c.defaultStop {
+		return nil
+	}
+	return c.stop
+}
+
 // collectionOptions tracks options for a collection
 type collectionOptions struct {
 	name         string
 	augmentation func(o any) any
 	stop         <-chan struct{}
+	// defaultStop is true if no stop channel was set, in which case stop is never closed.
+	defaultStop bool
+	snapshot    string
 }
 
 // dependency is a specific thing that can be depended on
@@ -115,10 +115,24 @@ func JoinCollection[T any](cs []Collection[T], opts ...CollectionOption) Collect
 		log.Infof("%v synced", o.name)
 	}()
 	// TODO: in the future, we could have a custom merge function. For now, since we just take the first, we optimize around that case
-	return &join[T]{
+	j := &join[T]{
 		collectionName: o.name,
 		id:             nextUID(),
 		synced:         synced,
 		collections:    c,
 	}
+	stats := newCollectionStats()
+	for _, c := range c {
+		stats.addDependency(c.uid(), c.name(), true)
+	}
+	registerDebug(j.id, debugEntry{
+		name:     j.collectionName,
+		kind:     "join",
+		typeName: ptr.TypeName[T](),
+		stop:     o.debugStop(),
+		stats:    stats,
+		size:     func() int { return len(j.List()) },
+		synced:   j.Synced().HasSynced,
+	})
+	return j
 }
@@ -0,0 +1,238 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/kube/controllers"
+	"istio.io/istio/pkg/ptr"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/util/sets"
+)
+
+// snapshotFormat is the version of the snapshot file format. Snapshots of another format are ignored.
+const snapshotFormat = 1
+
+// snapshotInterval is the minimum time between two writes of a snapshot.
+var snapshotInterval = time.Second
+
+// WithSnapshot persists the state of a derived collection (NewCollection, NewManyCollection or NewSingleton) to
+// the provided file, so that it can be restarted warm.
+//
+// When the file holds a snapshot, the collection is populated from it and is synced immediately, before its
+// inputs are. Once the inputs sync, the collection reconciles against them in the background: outputs that changed
+// are sent as regular events, and outputs of inputs that no longer exist are deleted. The transformation is skipped
+// for inputs whose resource version is the one recorded in the snapshot, if they had no dependencies other than
+// the input itself. Other inputs are always recomputed.
+//
+// The snapshot is rewritten, at most once per second, after each change. Changes made in the last second before
+// the collection is stopped may not be persisted; they are recomputed on the next start.
+//
+// Outputs are stored with encoding/json, and so must round-trip through it; types with unexported state cannot
+// be snapshotted. Each collection must have its own file.
+func WithSnapshot(file string) CollectionOption {
+	return func(c *collectionOptions) {
+		c.snapshot = file
+	}
+}
+
+// collectionSnapshot is the persisted state of a collection.
+type collectionSnapshot[O any] struct {
+	Format int    `json:"format"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	// Inputs maps each input key to the outputs computed from it.
+	Inputs map[string]snapshotEntry[O] `json:"inputs"`
+}
+
+type snapshotEntry[O any] struct {
+	// Version is the resource version of the input, if it has one.
+	Version string `json:"version,omitempty"`
+	// Dependencies is true if the transformation of the input fetched from other collections.
+	Dependencies bool `json:"dependencies,omitempty"`
+	Outputs      []O  `json:"outputs"`
+}
+
+// reusable returns true if the outputs of the entry can be used for the input without running the transformation.
+func (e snapshotEntry[O]) reusable(i any) bool {
+	return e.Version != "" && !e.Dependencies && e.Version == resourceVersion(i)
+}
+
+// resourceVersion returns the resource version of an object, or an empty string if it has none.
+func resourceVersion(a any) string {
+	switch o := a.(type) {
+	case controllers.Object:
+		return o.GetResourceVersion()
+	case config.Config:
+		return o.ResourceVersion
+	case interface{ GetResourceVersion() string }:
+		return o.GetResourceVersion()
+	}
+	return ""
+}
+
+// loadSnapshot populates the collection from its snapshot file. It returns false if there is no usable snapshot.
+func (h *manyCollection[I, O]) loadSnapshot(file string) bool {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		h.log.Warnf("failed to read snapshot %v: %v", file, err)
+		return false
+	}
+	snap := collectionSnapshot[O]{}
+	if err := json.Unmarshal(b, &snap); err != nil {
+		h.log.Warnf("failed to decode snapshot %v: %v", file, err)
+		return false
+	}
+	if snap.Format != snapshotFormat || snap.Type != ptr.TypeName[O]() {
+		h.log.Warnf("ignoring snapshot %v of format %v and type %v", file, snap.Format, snap.Type)
+		return false
+	}
+	h.pendingSnapshot = make(map[Key[I]]snapshotEntry[O], len(snap.Inputs))
+	for k, entry := range snap.Inputs {
+		iKey := Key[I](k)
+		oKeys := sets.NewWithLength[Key[O]](len(entry.Outputs))
+		for _, o := range entry.Outputs {
+			oKey := GetKey(o)
+			oKeys.Insert(oKey)
+			h.collectionState.outputs[oKey] = o
+		}
+		h.collectionState.mappings[iKey] = oKeys
+		h.pendingSnapshot[iKey] = entry
+	}
+	h.log.Infof("loaded snapshot %v with %d inputs and %d outputs", file, len(snap.Inputs), len(h.collectionState.outputs))
+	return true
+}
+
+// dropStaleSnapshot deletes the outputs of the snapshot whose inputs were not seen during the initial sync.
+// This should be called with recomputeMu acquired.
+func (h *manyCollection[I, O]) dropStaleSnapshot() {
+	events := make([]Event[O], 0, len(h.pendingSnapshot))
+	h.mu.Lock()
+	for iKey := range h.pendingSnapshot {
+		for oKey := range h.collectionState.mappings[iKey] {
+			oldRes, f := h.collectionState.outputs[oKey]
+			if !f {
+				continue
+			}
+			events = append(events, Event[O]{
+				Event: controllers.EventDelete,
+				Old:   &oldRes,
+			})
+			delete(h.collectionState.outputs, oKey)
+		}
+		delete(h.collectionState.mappings, iKey)
+	}
+	h.mu.Unlock()
+	h.log.Infof("reconciled snapshot, %d stale inputs removed", len(h.pendingSnapshot))
+	h.pendingSnapshot = nil
+	if len(events) == 0 {
+		return
+	}
+	h.stats.sent(len(events))
+	for _, handler := range h.eventHandlers.Get() {
+		handler(slices.Clone(events), false)
+	}
+}
+
+// buildSnapshot captures the current state of the collection.
+func (h *manyCollection[I, O]) buildSnapshot() collectionSnapshot[O] {
+	h.recomputeMu.Lock()
+	defer h.recomputeMu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snap := collectionSnapshot[O]{
+		Format: snapshotFormat,
+		Name:   h.collectionName,
+		Type:   ptr.TypeName[O](),
+		Inputs: make(map[string]snapshotEntry[O], len(h.collectionState.mappings)),
+	}
+	for iKey, oKeys := range h.collectionState.mappings {
+		keys := oKeys.UnsortedList()
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		entry := snapshotEntry[O]{
+			Dependencies: len(h.objectDependencies[iKey]) > 0,
+			Outputs:      make([]O, 0, len(keys)),
+		}
+		if i, f := h.collectionState.inputs[iKey]; f {
+			entry.Version = resourceVersion(i)
+		}
+		for _, oKey := range keys {
+			entry.Outputs = append(entry.Outputs, h.collectionState.outputs[oKey])
+		}
+		snap.Inputs[string(iKey)] = entry
+	}
+	return snap
+}
+
+// writeSnapshot persists the current state of the collection. The file is replaced atomically.
+func (h *manyCollection[I, O]) writeSnapshot(file string) error {
+	b, err := json.Marshal(h.buildSnapshot())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// markSnapshotDirty requests the snapshot to be rewritten.
+func (h *manyCollection[I, O]) markSnapshotDirty() {
+	if h.snapshotDirty == nil {
+		return
+	}
+	select {
+	case h.snapshotDirty <- struct{}{}:
+	default:
+	}
+}
+
+// runSnapshotWriter rewrites the snapshot each time it is marked dirty, at most once per snapshotInterval,
+// until the collection is stopped.
+func (h *manyCollection[I, O]) runSnapshotWriter(file string) {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.snapshotDirty:
+		}
+		if err := h.writeSnapshot(file); err != nil {
+			h.log.Warnf("failed to write snapshot %v: %v", file, err)
+		}
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(snapshotInterval):
+		}
+	}
+}
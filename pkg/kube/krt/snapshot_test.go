@@ -0,0 +1,179 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krt_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/atomic"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/kube/kclient/clienttest"
+	"istio.io/istio/pkg/kube/krt"
+	"istio.io/istio/pkg/ptr"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func snapshotPod(name, ip, version string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "namespace",
+			ResourceVersion: version,
+		},
+		Status: corev1.PodStatus{PodIP: ip},
+	}
+}
+
+// snapshotOutputs returns the sorted outputs stored in a snapshot file.
+func snapshotOutputs(t *testing.T, file string) []string {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	snap := struct {
+		Inputs map[string]struct {
+			Outputs []SimplePod `json:"outputs"`
+		} `json:"inputs"`
+	}{}
+	assert.NoError(t, json.Unmarshal(b, &snap))
+	var res []string
+	for _, entry := range snap.Inputs {
+		for _, pod := range entry.Outputs {
+			res = append(res, pod.ResourceName()+"="+pod.IP)
+		}
+	}
+	return slices.Sort(res)
+}
+
+func listSimplePods(c krt.Collection[SimplePod]) func() []string {
+	return func() []string {
+		return slices.Sort(slices.Map(c.List(), func(p SimplePod) string { return p.ResourceName() + "=" + p.IP }))
+	}
+}
+
+func TestCollectionSnapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pods.json")
+	calls := atomic.NewInt32(0)
+	simplePods := func(pods krt.Collection[*corev1.Pod], stop <-chan struct{}) krt.Collection[SimplePod] {
+		return krt.NewCollection(pods, func(ctx krt.HandlerContext, i *corev1.Pod) *SimplePod {
+			calls.Inc()
+			return &SimplePod{Named: NewNamed(i), IP: i.Status.PodIP}
+		}, krt.WithSnapshot(file), krt.WithStop(stop))
+	}
+
+	// Cold start: the collection is computed from its inputs, then persisted.
+	stop := make(chan struct{})
+	c := kube.NewFakeClient()
+	pc := clienttest.NewWriter[*corev1.Pod](t, c)
+	pc.Create(snapshotPod("a", "1.1.1.1", "1"))
+	pc.Create(snapshotPod("b", "1.1.1.2", "1"))
+	pc.Create(snapshotPod("old", "1.1.1.3", "1"))
+	pods := krt.NewInformer[*corev1.Pod](c, krt.WithStop(stop))
+	cold := simplePods(pods, stop)
+	assert.Equal(t, cold.Synced().HasSynced(), false)
+	c.RunAndWait(stop)
+	cold.Synced().WaitUntilSynced(stop)
+	expected := []string{"namespace/a=1.1.1.1", "namespace/b=1.1.1.2", "namespace/old=1.1.1.3"}
+	assert.EventuallyEqual(t, func() []string { return snapshotOutputs(t, file) }, expected)
+	assert.Equal(t, calls.Load(), int32(3))
+	close(stop)
+
+	// Warm start: the snapshot is served before the inputs sync.
+	calls.Store(0)
+	stop = test.NewStop(t)
+	c = kube.NewFakeClient()
+	pc = clienttest.NewWriter[*corev1.Pod](t, c)
+	pc.Create(snapshotPod("a", "1.1.1.1", "1"))
+	pc.Create(snapshotPod("b", "1.1.1.4", "2"))
+	pc.Create(snapshotPod("new", "1.1.1.5", "1"))
+	pods = krt.NewInformer[*corev1.Pod](c, krt.WithStop(stop))
+	warm := simplePods(pods, stop)
+	assert.Equal(t, warm.Synced().HasSynced(), true)
+	assert.Equal(t, listSimplePods(warm)(), expected)
+
+	tt := assert.NewTracker[string](t)
+	warm.Register(func(o krt.Event[SimplePod]) {
+		tt.Record(fmt.Sprintf("%v/%v", o.Event, o.Latest().ResourceName()))
+	})
+	tt.WaitUnordered("add/namespace/a", "add/namespace/b", "add/namespace/old")
+
+	// Reconciliation is sent as regular events. The unchanged pod is not recomputed.
+	c.RunAndWait(stop)
+	tt.WaitUnordered("update/namespace/b", "add/namespace/new", "delete/namespace/old")
+	expected = []string{"namespace/a=1.1.1.1", "namespace/b=1.1.1.4", "namespace/new=1.1.1.5"}
+	assert.Equal(t, listSimplePods(warm)(), expected)
+	assert.Equal(t, calls.Load(), int32(2))
+	assert.EventuallyEqual(t, func() []string { return snapshotOutputs(t, file) }, expected)
+}
+
+func TestCollectionSnapshotDependencies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pods.json")
+	calls := atomic.NewInt32(0)
+	suffixedPods := func(pods krt.Collection[*corev1.Pod], suffix krt.StaticSingleton[string], stop <-chan struct{}) krt.Collection[SimplePod] {
+		return krt.NewCollection(pods, func(ctx krt.HandlerContext, i *corev1.Pod) *SimplePod {
+			calls.Inc()
+			s := krt.FetchOne(ctx, suffix.AsCollection())
+			return &SimplePod{Named: NewNamed(i), IP: i.Status.PodIP + *s}
+		}, krt.WithSnapshot(file), krt.WithStop(stop))
+	}
+
+	stop := make(chan struct{})
+	c := kube.NewFakeClient()
+	clienttest.NewWriter[*corev1.Pod](t, c).Create(snapshotPod("a", "1.1.1.1", "1"))
+	cold := suffixedPods(krt.NewInformer[*corev1.Pod](c, krt.WithStop(stop)), krt.NewStatic(ptr.Of("/1")), stop)
+	c.RunAndWait(stop)
+	cold.Synced().WaitUntilSynced(stop)
+	assert.EventuallyEqual(t, func() []string { return snapshotOutputs(t, file) }, []string{"namespace/a=1.1.1.1/1"})
+	close(stop)
+
+	// The pod did not change, but it is recomputed as its output depends on another collection.
+	calls.Store(0)
+	stop = test.NewStop(t)
+	c = kube.NewFakeClient()
+	clienttest.NewWriter[*corev1.Pod](t, c).Create(snapshotPod("a", "1.1.1.1", "1"))
+	warm := suffixedPods(krt.NewInformer[*corev1.Pod](c, krt.WithStop(stop)), krt.NewStatic(ptr.Of("/2")), stop)
+	assert.Equal(t, listSimplePods(warm)(), []string{"namespace/a=1.1.1.1/1"})
+	c.RunAndWait(stop)
+	assert.EventuallyEqual(t, listSimplePods(warm), []string{"namespace/a=1.1.1.1/2"})
+	assert.Equal(t, calls.Load(), int32(1))
+}
+
+func TestCollectionSnapshotInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pods.json")
+	assert.NoError(t, os.WriteFile(file, []byte("not json"), 0o644))
+
+	stop := test.NewStop(t)
+	c := kube.NewFakeClient()
+	clienttest.NewWriter[*corev1.Pod](t, c).Create(snapshotPod("a", "1.1.1.1", "1"))
+	pods := krt.NewInformer[*corev1.Pod](c, krt.WithStop(stop))
+	col := krt.NewCollection(pods, func(ctx krt.HandlerContext, i *corev1.Pod) *SimplePod {
+		return &SimplePod{Named: NewNamed(i), IP: i.Status.PodIP}
+	}, krt.WithSnapshot(file), krt.WithStop(stop))
+	// An invalid snapshot is ignored, and replaced once the collection synced.
+	assert.Equal(t, col.Synced().HasSynced(), false)
+	c.RunAndWait(stop)
+	retry.UntilOrFail(t, col.Synced().HasSynced)
+	assert.EventuallyEqual(t, func() []string { return snapshotOutputs(t, file) }, []string{"namespace/a=1.1.1.1"})
+}
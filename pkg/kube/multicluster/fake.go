@@ -15,6 +15,8 @@
 package multicluster
 
 import (
+	"go.uber.org/atomic"
+
 	"istio.io/istio/pkg/cluster"
 	"istio.io/istio/pkg/kube"
 )
@@ -29,12 +31,13 @@ func (f *Fake) registerHandler(h handler) {
 
 func (f *Fake) Add(id cluster.ID, client kube.Client, stop chan struct{}) {
 	for _, handler := range f.handlers {
-		handler.clusterAdded(&Cluster{
-			ID:            id,
-			Client:        client,
-			kubeConfigSha: [32]byte{},
-			stop:          stop,
-		})
+		handler.clusterAdded(newFakeCluster(id, client, stop))
+	}
+}
+
+func (f *Fake) Update(id cluster.ID, client kube.Client, stop chan struct{}) {
+	for _, handler := range f.handlers {
+		handler.clusterUpdated(newFakeCluster(id, client, stop))
 	}
 }
 
@@ -44,6 +47,21 @@ func (f *Fake) Delete(id cluster.ID) {
 	}
 }
 
+func (f *Fake) HasSynced() bool {
+	return true
+}
+
+func newFakeCluster(id cluster.ID, client kube.Client, stop chan struct{}) *Cluster {
+	return &Cluster{
+		ID:                 id,
+		Client:             client,
+		kubeConfigSha:      [32]byte{},
+		stop:               stop,
+		initialSync:        atomic.NewBool(false),
+		initialSyncTimeout: atomic.NewBool(false),
+	}
+}
+
 func NewFakeController() *Fake {
 	return &Fake{}
 }
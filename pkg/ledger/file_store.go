@@ -0,0 +1,421 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	istiolog "istio.io/istio/pkg/log"
+)
+
+var log = istiolog.RegisterScope("ledger", "ledger persistence")
+
+// The file of a FileStore is a log of records, each encoded as:
+//
+//	type (1 byte) | payload length (uvarint) | payload | CRC-32 of type and payload (4 bytes)
+//
+// Node records hold the hash of the node followed by its sub-nodes, each prefixed by its length as an
+// uvarint. Root records hold the root of the tree. The last root record is the current root. Expire records
+// hold the hash of a node followed by its expiration time, in Unix nanoseconds as 8 bytes, or 0 if the
+// node no longer expires.
+const (
+	recordNode   byte = 'n'
+	recordRoot   byte = 'r'
+	recordExpire byte = 'e'
+
+	// maxRecordLength bounds the length of a record, to detect corruption. A node holds batchLen
+	// sub-nodes of at most a key and a flag.
+	maxRecordLength = 1 << 16
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileStore is a Store keeping the nodes in an append-only file, so the history of the Ledger survives
+// restarts. Only the offsets of the nodes are kept in memory. Expired nodes are dropped from the file by
+// Compact, which RunCompaction calls periodically.
+type FileStore struct {
+	path string
+
+	mu   sync.RWMutex
+	file *os.File
+	size int64
+	// index is the offset and length of the payload of each node record.
+	index   map[hash]fileRecord
+	expires map[hash]time.Time
+	root    []byte
+	buf     []byte
+}
+
+type fileRecord struct {
+	offset int64
+	length int
+}
+
+var _ Store = &FileStore{}
+
+// OpenFileStore opens the store in the file at path, creating it if needed. A truncated record at the end
+// of the file, left by a crash, is discarded.
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		path:    path,
+		file:    f,
+		index:   map[hash]fileRecord{},
+		expires: map[hash]time.Time{},
+	}
+	if err := s.load(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the records of the file, and truncates it after the last valid record.
+func (s *FileStore) load() error {
+	r := bufio.NewReader(io.NewSectionReader(s.file, 0, 1<<62))
+	var offset int64
+	for {
+		typ, payload, n, err := readRecord(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Warnf("discarding the end of %s after offset %d: %v", s.path, offset, err)
+			}
+			break
+		}
+		switch typ {
+		case recordNode:
+			if len(payload) < hashLength {
+				log.Warnf("discarding the end of %s after offset %d: invalid node", s.path, offset)
+				return s.truncate(offset)
+			}
+			payloadOffset := offset + int64(n-len(payload)-crc32.Size)
+			s.index[toHash(payload)] = fileRecord{offset: payloadOffset, length: len(payload)}
+		case recordRoot:
+			s.root = append([]byte(nil), payload...)
+		case recordExpire:
+			if len(payload) != hashLength+8 {
+				log.Warnf("discarding the end of %s after offset %d: invalid expiration", s.path, offset)
+				return s.truncate(offset)
+			}
+			h := toHash(payload)
+			if exp := int64(binary.BigEndian.Uint64(payload[hashLength:])); exp == 0 {
+				delete(s.expires, h)
+			} else if _, f := s.index[h]; f {
+				s.expires[h] = time.Unix(0, exp)
+			}
+		}
+		offset += int64(n)
+	}
+	return s.truncate(offset)
+}
+
+func (s *FileStore) truncate(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+	return nil
+}
+
+// readRecord reads a record, and returns its type, payload and total length.
+func readRecord(r *bufio.Reader) (byte, []byte, int, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, 0, noEOF(err)
+	}
+	if length > maxRecordLength {
+		return 0, nil, 0, fmt.Errorf("record of %d bytes is too large", length)
+	}
+	b := make([]byte, int(length)+crc32.Size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, 0, noEOF(err)
+	}
+	payload, sum := b[:length], binary.BigEndian.Uint32(b[length:])
+	if recordCRC(typ, payload) != sum {
+		return 0, nil, 0, errors.New("checksum mismatch")
+	}
+	return typ, payload, 1 + uvarintLen(length) + len(b), nil
+}
+
+func recordCRC(typ byte, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, []byte{typ})
+	return crc32.Update(crc, crcTable, payload)
+}
+
+func uvarintLen(v uint64) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], v)
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendRecord appends a record to the file, and returns the offset of its payload.
+func (s *FileStore) appendRecord(typ byte, payload []byte) (int64, error) {
+	b := append(s.buf[:0], typ)
+	b = binary.AppendUvarint(b, uint64(len(payload)))
+	payloadOffset := s.size + int64(len(b))
+	b = append(b, payload...)
+	b = binary.BigEndian.AppendUint32(b, recordCRC(typ, payload))
+	s.buf = b
+	if _, err := s.file.WriteAt(b, s.size); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(b))
+	return payloadOffset, nil
+}
+
+// appendExpire appends the expiration of a node to the file. A zero time clears it.
+func (s *FileStore) appendExpire(h hash, exp time.Time) error {
+	payload := make([]byte, 0, hashLength+8)
+	payload = append(payload, h[:]...)
+	var nanos int64
+	if !exp.IsZero() {
+		nanos = exp.UnixNano()
+	}
+	payload = binary.BigEndian.AppendUint64(payload, uint64(nanos))
+	_, err := s.appendRecord(recordExpire, payload)
+	return err
+}
+
+func encodeNode(b []byte, key []byte, node [][]byte) []byte {
+	h := toHash(key)
+	b = append(b, h[:]...)
+	for _, n := range node {
+		b = binary.AppendUvarint(b, uint64(len(n)))
+		b = append(b, n...)
+	}
+	return b
+}
+
+func decodeNode(payload []byte) ([][]byte, error) {
+	payload = payload[hashLength:]
+	node := make([][]byte, 0, batchLen)
+	for len(payload) > 0 {
+		l, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < l {
+			return nil, errors.New("invalid node")
+		}
+		payload = payload[n:]
+		var sub []byte
+		if l > 0 {
+			sub = payload[:l:l]
+		}
+		node = append(node, sub)
+		payload = payload[l:]
+	}
+	if len(node) != batchLen {
+		return nil, fmt.Errorf("invalid node with %d sub-nodes", len(node))
+	}
+	return node, nil
+}
+
+// Set implements Store
+func (s *FileStore) Set(key []byte, node [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := toHash(key)
+	if _, f := s.expires[h]; f {
+		delete(s.expires, h)
+		if err := s.appendExpire(h, time.Time{}); err != nil {
+			log.Errorf("failed to write to %s: %v", s.path, err)
+		}
+	}
+	if _, f := s.index[h]; f {
+		return
+	}
+	payload := encodeNode(nil, key, node)
+	offset, err := s.appendRecord(recordNode, payload)
+	if err != nil {
+		log.Errorf("failed to write to %s: %v", s.path, err)
+		return
+	}
+	s.index[h] = fileRecord{offset: offset, length: len(payload)}
+}
+
+// Get implements Store
+func (s *FileStore) Get(key []byte) ([][]byte, bool) {
+	h := toHash(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, f := s.index[h]
+	if !f {
+		return nil, false
+	}
+	if exp, ok := s.expires[h]; ok && time.Now().After(exp) {
+		return nil, false
+	}
+	payload := make([]byte, rec.length)
+	if _, err := s.file.ReadAt(payload, rec.offset); err != nil {
+		log.Errorf("failed to read from %s: %v", s.path, err)
+		return nil, false
+	}
+	node, err := decodeNode(payload)
+	if err != nil {
+		log.Errorf("failed to read from %s: %v", s.path, err)
+		return nil, false
+	}
+	return node, true
+}
+
+// Expire implements Store. Expired nodes are no longer returned, and are removed from the file by Compact.
+func (s *FileStore) Expire(key []byte, after time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := toHash(key)
+	if _, f := s.index[h]; !f {
+		return
+	}
+	exp := time.Now().Add(after)
+	if err := s.appendExpire(h, exp); err != nil {
+		log.Errorf("failed to write to %s: %v", s.path, err)
+	}
+	s.expires[h] = exp
+}
+
+// Root implements Store
+func (s *FileStore) Root() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.root
+}
+
+// SetRoot implements Store
+func (s *FileStore) SetRoot(root []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.appendRecord(recordRoot, root); err != nil {
+		log.Errorf("failed to write to %s: %v", s.path, err)
+		return
+	}
+	s.root = root
+}
+
+// Compact rewrites the file without the expired nodes.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".ledger-compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	compacted := &FileStore{path: tmp.Name(), file: tmp, index: map[hash]fileRecord{}}
+	for h, rec := range s.index {
+		if exp, f := s.expires[h]; f && now.After(exp) {
+			continue
+		}
+		payload := make([]byte, rec.length)
+		if _, err := s.file.ReadAt(payload, rec.offset); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		offset, err := compacted.appendRecord(recordNode, payload)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		compacted.index[h] = fileRecord{offset: offset, length: rec.length}
+		if exp, f := s.expires[h]; f {
+			if err := compacted.appendExpire(h, exp); err != nil {
+				_ = tmp.Close()
+				return err
+			}
+		}
+	}
+	if _, err := compacted.appendRecord(recordRoot, s.root); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	_ = s.file.Close()
+	s.file, s.size, s.index = tmp, compacted.size, compacted.index
+	for h, exp := range s.expires {
+		if now.After(exp) {
+			delete(s.expires, h)
+		}
+	}
+	return nil
+}
+
+// RunCompaction compacts the file every interval, if nodes expired, until stop is closed.
+func (s *FileStore) RunCompaction(stop <-chan struct{}, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !s.hasExpiredNodes() {
+				continue
+			}
+			if err := s.Compact(); err != nil {
+				log.Errorf("failed to compact %s: %v", s.path, err)
+			}
+		}
+	}
+}
+
+func (s *FileStore) hasExpiredNodes() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	for _, exp := range s.expires {
+		if now.After(exp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close syncs and closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		_ = s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
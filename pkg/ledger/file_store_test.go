@@ -0,0 +1,191 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func openFileStore(t testing.TB, path string) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+	s := openFileStore(t, path)
+	l := MakeWithStore(time.Minute, s)
+	for i := 0; i < 50; i++ {
+		_, err := l.Put(fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+		assert.NoError(t, err)
+	}
+	previous := l.RootHash()
+	_, err := l.Put("key-1", "updated")
+	assert.NoError(t, err)
+	assert.NoError(t, l.Delete("key-2"))
+	current := l.RootHash()
+	assert.NoError(t, s.Close())
+
+	// The state, and the previous versions, survive a restart
+	l = MakeWithStore(time.Minute, openFileStore(t, path))
+	assert.Equal(t, l.RootHash(), current)
+	v, err := l.Get("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, v, "updated")
+	v, err = l.Get("key-2")
+	assert.NoError(t, err)
+	assert.Equal(t, v, "")
+	v, err = l.GetPreviousValue(previous, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, v, "1")
+
+	proof, err := l.Prove("key-3")
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(current, "key-3", "3", proof))
+	proof, err = l.ProvePreviousValue(previous, "key-2")
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(previous, "key-2", "2", proof))
+
+	// The ledger can still be updated
+	_, err = l.Put("key-4", "updated")
+	assert.NoError(t, err)
+	v, err = l.Get("key-4")
+	assert.NoError(t, err)
+	assert.Equal(t, v, "updated")
+}
+
+func TestFileStoreTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+	s := openFileStore(t, path)
+	l := MakeWithStore(time.Minute, s)
+	_, err := l.Put("foo", "bar")
+	assert.NoError(t, err)
+	root := l.RootHash()
+	assert.NoError(t, s.Close())
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	size := info.Size()
+
+	// A partial record, as left by a crash, is discarded
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{recordNode, 40, 1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s = openFileStore(t, path)
+	l = MakeWithStore(time.Minute, s)
+	assert.Equal(t, l.RootHash(), root)
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), size)
+
+	// A corrupted record is discarded, along with the records after it
+	assert.NoError(t, s.Close())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	l = MakeWithStore(time.Minute, openFileStore(t, path))
+	assert.Equal(t, l.RootHash() != root, true)
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+	s := openFileStore(t, path)
+	node := make([][]byte, batchLen)
+	node[0] = []byte{0}
+	node[1] = []byte("12345678")
+	s.Set([]byte("aaaaaaaa"), node)
+	s.Set([]byte("bbbbbbbb"), node)
+	s.SetRoot([]byte("aaaaaaaa"))
+	s.Expire([]byte("bbbbbbbb"), 0)
+	time.Sleep(time.Millisecond)
+
+	_, f := s.Get([]byte("bbbbbbbb"))
+	assert.Equal(t, f, false)
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Compact())
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, after.Size() < before.Size(), true)
+
+	got, f := s.Get([]byte("aaaaaaaa"))
+	assert.Equal(t, f, true)
+	assert.Equal(t, got, node)
+	assert.NoError(t, s.Close())
+
+	s = openFileStore(t, path)
+	assert.Equal(t, s.Root(), []byte("aaaaaaaa"))
+	_, f = s.Get([]byte("aaaaaaaa"))
+	assert.Equal(t, f, true)
+	_, f = s.Get([]byte("bbbbbbbb"))
+	assert.Equal(t, f, false)
+}
+
+func TestFileStoreExpirePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+	s := openFileStore(t, path)
+	node := make([][]byte, batchLen)
+	node[0] = []byte{0}
+	s.Set([]byte("aaaaaaaa"), node)
+	s.Set([]byte("bbbbbbbb"), node)
+	s.Set([]byte("cccccccc"), node)
+	s.Expire([]byte("aaaaaaaa"), 0)
+	s.Expire([]byte("bbbbbbbb"), time.Hour)
+	s.Expire([]byte("cccccccc"), 0)
+	// Set again: no longer expires
+	s.Set([]byte("cccccccc"), node)
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, s.Close())
+
+	// The expirations survive a restart, and a compaction
+	s = openFileStore(t, path)
+	assert.Equal(t, s.hasExpiredNodes(), true)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.RunCompaction(stop, time.Millisecond)
+		close(done)
+	}()
+	retry.UntilOrFail(t, func() bool {
+		return !s.hasExpiredNodes()
+	})
+	close(stop)
+	<-done
+	assert.NoError(t, s.Close())
+
+	s = openFileStore(t, path)
+	_, f := s.Get([]byte("aaaaaaaa"))
+	assert.Equal(t, f, false)
+	_, f = s.Get([]byte("bbbbbbbb"))
+	assert.Equal(t, f, true)
+	assert.Equal(t, len(s.expires), 1)
+	_, f = s.Get([]byte("cccccccc"))
+	assert.Equal(t, f, true)
+}
@@ -0,0 +1,292 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/cache"
+)
+
+// History records the values of a set of keys in a Ledger, and the root hash of the Ledger at each
+// committed version, such as each push of istiod. The value of a key at any retained version can be
+// looked up, along with a proof of inclusion in the root hash of that version.
+//
+// The Ledger only holds a digest of the key and its value, which History maps back to the value.
+// Versions are retained up to a maximum number, and for a maximum duration after which the nodes of
+// the tree they no longer share with the current state expire.
+type History struct {
+	mu          sync.RWMutex
+	ledger      Ledger
+	nodes       cache.ExpiringCache
+	maxVersions int
+	retention   time.Duration
+	// current is the digest of the current value of each key.
+	current map[string]string
+	// values are the values by digest, for the current values and the values of retained versions.
+	values map[string]*historyValue
+	// versions are the retained versions, oldest first.
+	versions []Version
+	// mutations is the number of changes of the keys.
+	mutations uint64
+
+	// store keeps the nodes of the tree, and file logs the changes of the History, if it is persisted.
+	store *FileStore
+	file  *historyFile
+}
+
+type historyValue struct {
+	key   string
+	value string
+	// supersededAt is the mutation which replaced the value, once it is no longer current. The value is
+	// held by the versions snapshotted before it.
+	supersededAt uint64
+	superseded   bool
+}
+
+// Version is a committed version of the History.
+type Version struct {
+	Version  string `json:"version"`
+	RootHash string `json:"rootHash"`
+	// Time is when the state of the version was snapshotted.
+	Time time.Time `json:"time"`
+
+	mutations uint64
+}
+
+// Snapshot is the state of a History at some point, which can be committed as a version later.
+type Snapshot struct {
+	rootHash  string
+	mutations uint64
+	time      time.Time
+}
+
+// Record is the value of a key at a version of the History, with the proof that the Ledger held it. It
+// can be checked with Verify without access to the History.
+type Record struct {
+	Version  string `json:"version"`
+	RootHash string `json:"rootHash"`
+	Key      string `json:"key"`
+	// Present is false if the key did not exist at the version.
+	Present bool   `json:"present"`
+	Value   string `json:"value,omitempty"`
+	Proof   *Proof `json:"proof"`
+}
+
+// NewHistory creates a History retaining the last maxVersions versions, for at most the retention. The
+// nodes of the tree are kept in memory, and those replaced are evicted by Run once the retention has
+// elapsed.
+func NewHistory(maxVersions int, retention time.Duration) *History {
+	nodes := cache.NewTTL(forever, 0)
+	return &History{
+		ledger:      smtLedger{tree: newSMT(hasher, nodes, retention)},
+		nodes:       nodes,
+		maxVersions: maxVersions,
+		retention:   retention,
+		current:     map[string]string{},
+		values:      map[string]*historyValue{},
+	}
+}
+
+// Run evicts the expired nodes of the tree every second, until stop is closed. If the History is persisted,
+// the expired nodes are instead removed from its file.
+func (h *History) Run(stop <-chan struct{}) {
+	if h.store != nil {
+		// Nodes expire after the retention, so compacting as often keeps the file at about twice its live size.
+		h.store.RunCompaction(stop, max(h.retention, time.Minute))
+		return
+	}
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			h.nodes.EvictExpired()
+		}
+	}
+}
+
+// digest is the value stored in the Ledger for the value of a key.
+func digest(key, value string) string {
+	return string(hasher([]byte(key), []byte{0}, []byte(value)))
+}
+
+// Put sets the value of a key.
+func (h *History) Put(key, value string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := digest(key, value)
+	prev, f := h.current[key]
+	if f && prev == d {
+		return nil
+	}
+	if _, err := h.ledger.Put(key, d); err != nil {
+		return err
+	}
+	h.put(key, value)
+	return h.file.append(historyRecord{Put: &historyPut{Key: key, Value: value}})
+}
+
+// put records the value of a key, once set in the Ledger.
+func (h *History) put(key, value string) {
+	h.mutations++
+	if prev, f := h.current[key]; f {
+		h.supersede(prev)
+	}
+	d := digest(key, value)
+	h.current[key] = d
+	h.values[d] = &historyValue{key: key, value: value}
+}
+
+// Delete removes a key.
+func (h *History) Delete(key string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev, f := h.current[key]
+	if !f {
+		return nil
+	}
+	if err := h.ledger.Delete(key); err != nil {
+		return err
+	}
+	h.remove(key, prev)
+	return h.file.append(historyRecord{Delete: key})
+}
+
+// remove records the removal of a key with the given current digest, once deleted from the Ledger.
+func (h *History) remove(key, prev string) {
+	h.mutations++
+	h.supersede(prev)
+	delete(h.current, key)
+}
+
+func (h *History) supersede(d string) {
+	if v, f := h.values[d]; f {
+		v.superseded = true
+		v.supersededAt = h.mutations
+	}
+}
+
+// Snapshot returns the current state, to be committed with CommitSnapshot once the version built from
+// it is known.
+func (h *History) Snapshot() Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Snapshot{rootHash: h.ledger.RootHash(), mutations: h.mutations, time: time.Now()}
+}
+
+// Commit records the current state as the given version, and returns its root hash.
+func (h *History) Commit(version string) string {
+	return h.CommitSnapshot(version, h.Snapshot())
+}
+
+// CommitSnapshot records a snapshot as the given version, and returns its root hash. Snapshots must be
+// committed in the order they were taken. The oldest versions are forgotten once more than the maximum
+// number of versions are retained, or once they are older than the retention.
+func (h *History) CommitSnapshot(version string, s Snapshot) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := Version{Version: version, RootHash: s.rootHash, Time: s.time, mutations: s.mutations}
+	h.versions = append(h.versions, v)
+	h.forget(time.Now())
+	if err := h.file.append(historyRecord{Commit: commitRecord(v)}); err != nil {
+		log.Errorf("failed to persist version %s of the history: %v", version, err)
+	}
+	h.file.maybeCompact(h)
+	return s.rootHash
+}
+
+// forget drops the versions beyond the maximum number, and those older than the retention, along with the
+// values only they hold. The nodes replaced after such a version was snapshotted may already have expired.
+// The most recent version is kept regardless of its age, as it holds the current state.
+func (h *History) forget(now time.Time) {
+	n := max(len(h.versions)-h.maxVersions, 0)
+	for n < len(h.versions)-1 && now.Sub(h.versions[n].Time) > h.retention {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	h.versions = append(h.versions[:0], h.versions[n:]...)
+	oldest := h.versions[0].mutations
+	for d, v := range h.values {
+		if v.superseded && v.supersededAt <= oldest {
+			delete(h.values, d)
+		}
+	}
+}
+
+// Versions returns the retained versions, oldest first.
+func (h *History) Versions() []Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]Version(nil), h.versions...)
+}
+
+// Lookup returns the value of key at the given version.
+func (h *History) Lookup(version, key string) (*Record, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var root string
+	found := false
+	// Search from the most recent version, in case the same version was committed more than once.
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		if h.versions[i].Version == version {
+			root, found = h.versions[i].RootHash, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("version %q is unknown or no longer retained", version)
+	}
+	d, err := h.ledger.GetPreviousValue(root, key)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := h.ledger.ProvePreviousValue(root, key)
+	if err != nil {
+		return nil, err
+	}
+	r := &Record{
+		Version:  version,
+		RootHash: root,
+		Key:      key,
+		Proof:    proof,
+	}
+	if d == "" {
+		return r, nil
+	}
+	// GetPreviousValue trims the leading zeros of the digest.
+	v, f := h.values[string(coerceToHashLen(d))]
+	if !f {
+		return nil, fmt.Errorf("value of %s at version %q is no longer retained", key, version)
+	}
+	r.Present = true
+	r.Value = v.value
+	return r, nil
+}
+
+// Verify checks that the proof of the record matches its root hash.
+func (r *Record) Verify() error {
+	value := ""
+	if r.Present {
+		value = digest(r.Key, r.Value)
+	}
+	return Verify(r.RootHash, r.Key, value, r.Proof)
+}
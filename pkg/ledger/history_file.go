@@ -0,0 +1,266 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A persisted History keeps the nodes of its tree in a FileStore, and logs its changes in another file, one
+// JSON record per line. The log starts with the state of the History when it was last compacted.
+const (
+	historyNodesFile = "nodes"
+	historyLogFile   = "history"
+
+	// minHistoryCompaction is the number of records below which the log is not compacted.
+	minHistoryCompaction = 1000
+)
+
+type historyRecord struct {
+	Put    *historyPut    `json:"put,omitempty"`
+	Delete string         `json:"delete,omitempty"`
+	Commit *historyCommit `json:"commit,omitempty"`
+	State  *historyState  `json:"state,omitempty"`
+}
+
+type historyPut struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type historyCommit struct {
+	Version   string    `json:"version"`
+	RootHash  string    `json:"rootHash"`
+	Time      time.Time `json:"time"`
+	Mutations uint64    `json:"mutations"`
+}
+
+type historyState struct {
+	Mutations uint64 `json:"mutations"`
+	// Current is the current value of each key.
+	Current map[string]string `json:"current,omitempty"`
+	// Superseded are the values which are no longer current, but still held by retained versions.
+	Superseded []historySuperseded `json:"superseded,omitempty"`
+	Versions   []historyCommit     `json:"versions,omitempty"`
+}
+
+type historySuperseded struct {
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	SupersededAt uint64 `json:"supersededAt"`
+}
+
+func commitRecord(v Version) *historyCommit {
+	return &historyCommit{Version: v.Version, RootHash: v.RootHash, Time: v.Time, Mutations: v.mutations}
+}
+
+func (c historyCommit) version() Version {
+	return Version{Version: c.Version, RootHash: c.RootHash, Time: c.Time, mutations: c.Mutations}
+}
+
+// historyFile is the log of a persisted History. Its methods do nothing on a nil historyFile, for a History
+// kept in memory.
+type historyFile struct {
+	path string
+	file *os.File
+	// records is the number of records in the log.
+	records int
+}
+
+// OpenHistory opens the History persisted in dir, creating it if needed, so that the versions it retains
+// survive restarts. Like NewHistory, it retains the last maxVersions versions for at most the retention.
+// Run removes the expired nodes from the files, and Close closes them.
+func OpenHistory(dir string, maxVersions int, retention time.Duration) (*History, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	store, err := OpenFileStore(filepath.Join(dir, historyNodesFile))
+	if err != nil {
+		return nil, err
+	}
+	h := &History{
+		ledger:      MakeWithStore(retention, store),
+		maxVersions: maxVersions,
+		retention:   retention,
+		current:     map[string]string{},
+		values:      map[string]*historyValue{},
+		store:       store,
+		file:        &historyFile{path: filepath.Join(dir, historyLogFile)},
+	}
+	if err := h.file.load(h); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// load replays the log into h, and opens it for appending. A truncated record at the end of the log, left by
+// a crash, is discarded.
+func (f *historyFile) load(h *History) error {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			var rec historyRecord
+			if err = json.Unmarshal(line, &rec); err == nil {
+				err = h.replay(rec)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Warnf("discarding the end of %s after offset %d: %v", f.path, offset, err)
+			}
+			break
+		}
+		offset += int64(len(line))
+		f.records++
+	}
+	if err := file.Truncate(offset); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+// replay applies a record of the log to h, without changing its Ledger which already holds the changes.
+func (h *History) replay(rec historyRecord) error {
+	switch {
+	case rec.State != nil:
+		h.mutations = rec.State.Mutations
+		h.current = map[string]string{}
+		h.values = map[string]*historyValue{}
+		for key, value := range rec.State.Current {
+			d := digest(key, value)
+			h.current[key] = d
+			h.values[d] = &historyValue{key: key, value: value}
+		}
+		for _, v := range rec.State.Superseded {
+			h.values[digest(v.Key, v.Value)] = &historyValue{key: v.Key, value: v.Value, superseded: true, supersededAt: v.SupersededAt}
+		}
+		h.versions = nil
+		for _, c := range rec.State.Versions {
+			h.versions = append(h.versions, c.version())
+		}
+	case rec.Put != nil:
+		h.put(rec.Put.Key, rec.Put.Value)
+	case rec.Delete != "":
+		if prev, f := h.current[rec.Delete]; f {
+			h.remove(rec.Delete, prev)
+		}
+	case rec.Commit != nil:
+		h.versions = append(h.versions, rec.Commit.version())
+		h.forget(time.Now())
+	default:
+		return errors.New("unknown record")
+	}
+	return nil
+}
+
+// append appends a record to the log.
+func (f *historyFile) append(rec historyRecord) error {
+	if f == nil {
+		return nil
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	f.records++
+	return nil
+}
+
+// maybeCompact rewrites the log as the state of h, once it holds more than twice as many records as the
+// state has values and versions.
+func (f *historyFile) maybeCompact(h *History) {
+	if f == nil || f.records < max(2*(len(h.values)+len(h.versions)), minHistoryCompaction) {
+		return
+	}
+	if err := f.compact(h); err != nil {
+		log.Errorf("failed to compact %s: %v", f.path, err)
+	}
+}
+
+func (f *historyFile) compact(h *History) error {
+	state := &historyState{Mutations: h.mutations, Current: map[string]string{}}
+	for key, d := range h.current {
+		state.Current[key] = h.values[d].value
+	}
+	for _, v := range h.values {
+		if v.superseded {
+			state.Superseded = append(state.Superseded, historySuperseded{Key: v.key, Value: v.value, SupersededAt: v.supersededAt})
+		}
+	}
+	for _, v := range h.versions {
+		state.Versions = append(state.Versions, *commitRecord(v))
+	}
+	b, err := json.Marshal(historyRecord{State: state})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".history-compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	_ = f.file.Close()
+	f.file, f.records = tmp, 1
+	return nil
+}
+
+// Close closes the files of a persisted History.
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.file.Sync()
+	err = errors.Join(err, h.file.file.Close())
+	return errors.Join(err, h.store.Close())
+}
@@ -0,0 +1,112 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func openHistory(t *testing.T, dir string, maxVersions int) *History {
+	t.Helper()
+	h, err := OpenHistory(dir, maxVersions, forever)
+	assert.NoError(t, err)
+	return h
+}
+
+func expectValue(t *testing.T, h *History, version, key string, present bool, value string) {
+	t.Helper()
+	r, err := h.Lookup(version, key)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Present, present)
+	assert.Equal(t, r.Value, value)
+	assert.NoError(t, r.Verify())
+}
+
+func TestOpenHistory(t *testing.T) {
+	dir := t.TempDir()
+	h := openHistory(t, dir, 10)
+	assert.NoError(t, h.Put("vs/default/a", "1"))
+	assert.NoError(t, h.Put("vs/default/b", "1"))
+	v1 := h.Commit("v1")
+	assert.NoError(t, h.Put("vs/default/a", "2"))
+	h.Commit("v2")
+	assert.NoError(t, h.Delete("vs/default/b"))
+	assert.NoError(t, h.Close())
+
+	h = openHistory(t, dir, 10)
+	defer h.Close()
+	assert.Equal(t, len(h.Versions()), 2)
+	assert.Equal(t, h.Versions()[0].RootHash, v1)
+	expectValue(t, h, "v1", "vs/default/a", true, "1")
+	expectValue(t, h, "v2", "vs/default/a", true, "2")
+	expectValue(t, h, "v2", "vs/default/b", true, "1")
+
+	// The changes made after the last version are restored too.
+	h.Commit("v3")
+	expectValue(t, h, "v3", "vs/default/a", true, "2")
+	expectValue(t, h, "v3", "vs/default/b", false, "")
+	assert.NoError(t, h.Put("vs/default/b", "1"))
+	assert.Equal(t, h.Commit("v4"), h.Versions()[1].RootHash)
+}
+
+func TestOpenHistoryCompaction(t *testing.T) {
+	dir := t.TempDir()
+	h := openHistory(t, dir, 3)
+	for i := 0; i < minHistoryCompaction; i++ {
+		assert.NoError(t, h.Put("key", fmt.Sprint(i)))
+		h.Commit(fmt.Sprintf("v%d", i))
+	}
+	assert.Equal(t, h.file.records < minHistoryCompaction, true)
+	assert.NoError(t, h.Close())
+
+	h = openHistory(t, dir, 3)
+	defer h.Close()
+	assert.Equal(t, len(h.Versions()), 3)
+	assert.Equal(t, len(h.values), 3)
+	for i := minHistoryCompaction - 3; i < minHistoryCompaction; i++ {
+		expectValue(t, h, fmt.Sprintf("v%d", i), "key", true, fmt.Sprint(i))
+	}
+}
+
+func TestOpenHistoryTruncated(t *testing.T) {
+	dir := t.TempDir()
+	h := openHistory(t, dir, 10)
+	assert.NoError(t, h.Put("key", "1"))
+	h.Commit("v1")
+	assert.NoError(t, h.Close())
+
+	path := filepath.Join(dir, historyLogFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"put":{"key":"key","va`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	h = openHistory(t, dir, 10)
+	expectValue(t, h, "v1", "key", true, "1")
+	assert.NoError(t, h.Put("key", "2"))
+	h.Commit("v2")
+	assert.NoError(t, h.Close())
+
+	h = openHistory(t, dir, 10)
+	defer h.Close()
+	expectValue(t, h, "v1", "key", true, "1")
+	expectValue(t, h, "v2", "key", true, "2")
+}
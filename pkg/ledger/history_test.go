@@ -0,0 +1,139 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3, forever)
+	expect := func(version, key string, present bool, value string) {
+		t.Helper()
+		r, err := h.Lookup(version, key)
+		assert.NoError(t, err)
+		assert.Equal(t, r.Version, version)
+		assert.Equal(t, r.Present, present)
+		assert.Equal(t, r.Value, value)
+		assert.NoError(t, r.Verify())
+
+		// Records can be verified after a round trip through JSON
+		b, err := json.Marshal(r)
+		assert.NoError(t, err)
+		decoded := &Record{}
+		assert.NoError(t, json.Unmarshal(b, decoded))
+		assert.NoError(t, decoded.Verify())
+		if decoded.Present {
+			decoded.Value += "x"
+		} else {
+			decoded.Present = true
+		}
+		assert.Error(t, decoded.Verify())
+	}
+
+	assert.NoError(t, h.Put("vs/default/a", "1"))
+	assert.NoError(t, h.Put("vs/default/b", "1"))
+	h.Commit("v1")
+	assert.NoError(t, h.Put("vs/default/a", "2"))
+	h.Commit("v2")
+	assert.NoError(t, h.Delete("vs/default/b"))
+	h.Commit("v3")
+
+	expect("v1", "vs/default/a", true, "1")
+	expect("v1", "vs/default/b", true, "1")
+	expect("v2", "vs/default/a", true, "2")
+	expect("v2", "vs/default/b", true, "1")
+	expect("v3", "vs/default/a", true, "2")
+	expect("v3", "vs/default/b", false, "")
+	expect("v3", "vs/default/missing", false, "")
+
+	_, err := h.Lookup("v0", "vs/default/a")
+	assert.Error(t, err)
+
+	// v1 is forgotten, along with the values only it holds
+	h.Commit("v4")
+	assert.Equal(t, len(h.Versions()), 3)
+	_, err = h.Lookup("v1", "vs/default/a")
+	assert.Error(t, err)
+	expect("v2", "vs/default/a", true, "2")
+	expect("v2", "vs/default/b", true, "1")
+	assert.Equal(t, len(h.values), 2)
+	h.Commit("v5")
+	assert.Equal(t, len(h.values), 1)
+	expect("v5", "vs/default/a", true, "2")
+}
+
+func TestHistoryUnchangedValue(t *testing.T) {
+	h := NewHistory(10, forever)
+	assert.NoError(t, h.Put("key", "1"))
+	root := h.Commit("v1")
+	assert.NoError(t, h.Put("key", "1"))
+	assert.Equal(t, h.Commit("v2"), root)
+}
+
+func TestHistorySnapshot(t *testing.T) {
+	h := NewHistory(1, forever)
+	assert.NoError(t, h.Put("key", "1"))
+	s := h.Snapshot()
+	// Changed after the snapshot, while the version is being built.
+	assert.NoError(t, h.Put("key", "2"))
+	h.CommitSnapshot("v1", s)
+	r, err := h.Lookup("v1", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, r.Value, "1")
+	assert.NoError(t, r.Verify())
+
+	h.Commit("v2")
+	r, err = h.Lookup("v2", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, r.Value, "2")
+	assert.Equal(t, len(h.values), 1)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	h := NewHistory(10, 50*time.Millisecond)
+	go h.Run(test.NewStop(t))
+	assert.NoError(t, h.Put("key", "1"))
+	v1 := h.Commit("v1")
+	assert.NoError(t, h.Put("key", "2"))
+	h.Commit("v2")
+	time.Sleep(100 * time.Millisecond)
+
+	// The most recent version is retained regardless of its age.
+	h.Commit("v2")
+	assert.Equal(t, len(h.Versions()), 1)
+	_, err := h.Lookup("v1", "key")
+	assert.Error(t, err)
+	r, err := h.Lookup("v2", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, r.Value, "2")
+	assert.Equal(t, len(h.values), 1)
+
+	// The nodes replaced after v1 are evicted from memory.
+	retry.UntilSuccessOrFail(t, func() error {
+		_, err := h.ledger.GetPreviousValue(v1, "key")
+		if err == nil {
+			return errors.New("nodes of v1 are still retained")
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
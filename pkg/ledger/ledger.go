@@ -40,6 +40,11 @@ type Ledger interface {
 	RootHash() string
 	// GetPreviousValue executes a get against a previous version of the ledger, using that version's root hash.
 	GetPreviousValue(previousRootHash, key string) (result string, err error)
+	// ProvePreviousValue returns a proof of the value of the key in a previous version of the ledger, which can
+	// be checked with Verify.
+	ProvePreviousValue(previousRootHash, key string) (*Proof, error)
+	// Prove returns a proof of the value of the key in the Ledger's current state, which can be checked with Verify.
+	Prove(key string) (*Proof, error)
 }
 
 type smtLedger struct {
@@ -51,6 +56,12 @@ func Make(retention time.Duration) Ledger {
 	return smtLedger{tree: newSMT(hasher, nil, retention)}
 }
 
+// MakeWithStore returns a Ledger keeping its nodes in store, such as a FileStore. It starts from the state
+// saved in the store.
+func MakeWithStore(retention time.Duration, store Store) Ledger {
+	return smtLedger{tree: newSMTWithStore(hasher, store, retention)}
+}
+
 // Put adds a key value pair to the ledger, overwriting previous values and marking them for
 // removal after the retention specified in Make()
 func (s smtLedger) Put(key, value string) (result string, err error) {
@@ -61,7 +72,7 @@ func (s smtLedger) Put(key, value string) (result string, err error) {
 
 // Delete removes a key value pair from the ledger, marking it for removal after the retention specified in Make()
 func (s smtLedger) Delete(key string) (err error) {
-	_, err = s.tree.Update([][]byte{[]byte(key)}, [][]byte{defaultLeaf})
+	_, err = s.tree.Update([][]byte{coerceKeyToHashLen(key)}, [][]byte{defaultLeaf})
 	return
 }
 
@@ -83,6 +94,20 @@ func (s smtLedger) GetPreviousValue(previousRootHash, key string) (result string
 	return
 }
 
+// ProvePreviousValue returns a proof of the value of key in the version of the ledger with the given root hash.
+func (s smtLedger) ProvePreviousValue(previousRootHash, key string) (*Proof, error) {
+	prevBytes, err := base64.StdEncoding.DecodeString(previousRootHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.tree.prove(prevBytes, coerceKeyToHashLen(key))
+}
+
+// Prove returns a proof of the current value of key.
+func (s smtLedger) Prove(key string) (*Proof, error) {
+	return s.ProvePreviousValue(s.RootHash(), key)
+}
+
 // Get returns the current value of key.
 func (s smtLedger) Get(key string) (result string, err error) {
 	return s.GetPreviousValue(s.RootHash(), key)
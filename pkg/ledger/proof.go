@@ -0,0 +1,156 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// Proof is a Merkle proof of the value of a key in a version of the Ledger. It can be checked with
+// Verify without access to the Ledger.
+//
+// The tree is a sparse Merkle tree: each key has a fixed path from the root to a leaf, and each node is
+// the hash of its two children. The proof holds the siblings of the nodes on the path of the key which
+// are not empty; the others are the hashes of empty subtrees, which the verifier computes.
+type Proof struct {
+	Siblings []Sibling `json:"siblings,omitempty"`
+}
+
+// Sibling is the hash of a sibling of a node on the path of a key.
+type Sibling struct {
+	// Height of the sibling, 0 for leaves.
+	Height int    `json:"height"`
+	Hash   []byte `json:"hash"`
+}
+
+// prove returns the proof of key in the tree with the given root.
+func (s *smt) prove(root []byte, key []byte) (*Proof, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.atomicUpdate = false
+	proof := &Proof{}
+	err := s.proveNode(root, key, nil, 0, s.trieHeight, proof)
+	return proof, err
+}
+
+func (s *smt) proveNode(root []byte, key []byte, batch [][]byte, iBatch, height int, proof *Proof) error {
+	if len(root) == 0 || height == 0 {
+		return nil
+	}
+	batch, iBatch, lnode, rnode, isShortcut, err := s.loadChildren(root, height, iBatch, batch)
+	if err != nil {
+		return err
+	}
+	if isShortcut {
+		// The subtree only holds one key. If it is not the key, its path diverges from the path of the
+		// key at some height, where the single key subtree is the only non empty sibling.
+		k, v := lnode[:hashLength], rnode[:hashLength]
+		if bytes.Equal(k, key) {
+			return nil
+		}
+		for h := height - 1; h >= 0; h-- {
+			bit := s.trieHeight - h - 1
+			if bitIsSet(k, bit) != bitIsSet(key, bit) {
+				proof.Siblings = append(proof.Siblings, Sibling{Height: h, Hash: singleLeafHash(s.hash, s.defaultHashes, k, v, h, s.trieHeight)})
+				return nil
+			}
+		}
+		return nil
+	}
+	if bitIsSet(key, s.trieHeight-height) {
+		if len(lnode) != 0 {
+			proof.Siblings = append(proof.Siblings, Sibling{Height: height - 1, Hash: bytes.Clone(lnode[:hashLength])})
+		}
+		return s.proveNode(rnode, key, batch, 2*iBatch+2, height-1, proof)
+	}
+	if len(rnode) != 0 {
+		proof.Siblings = append(proof.Siblings, Sibling{Height: height - 1, Hash: bytes.Clone(rnode[:hashLength])})
+	}
+	return s.proveNode(lnode, key, batch, 2*iBatch+1, height-1, proof)
+}
+
+// singleLeafHash returns the hash of the subtree of the given height holding only the key with the value.
+func singleLeafHash(hash func(data ...[]byte) []byte, defaultHashes [][]byte, key, value []byte, height, trieHeight int) []byte {
+	h := value
+	for i := 0; i < height; i++ {
+		if bitIsSet(key, trieHeight-i-1) {
+			h = hash(defaultHashes[i], h)
+		} else {
+			h = hash(h, defaultHashes[i])
+		}
+	}
+	return h
+}
+
+var defaultHashes = sync.OnceValue(func() [][]byte {
+	return newSMT(hasher, nil, forever).defaultHashes
+})
+
+// Verify checks that proof proves that key had value in the version of the Ledger with the root hash
+// rootHash. An empty value proves that the key was absent.
+func Verify(rootHash, key, value string, proof *Proof) error {
+	root, err := base64.StdEncoding.DecodeString(rootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %v", err)
+	}
+	if proof == nil {
+		return fmt.Errorf("missing proof")
+	}
+	defaults := defaultHashes()
+	trieHeight := len(defaults) - 1
+	k := coerceKeyToHashLen(key)
+
+	siblings := map[int][]byte{}
+	for _, sib := range proof.Siblings {
+		if sib.Height < 0 || sib.Height >= trieHeight || len(sib.Hash) != hashLength {
+			return fmt.Errorf("invalid sibling at height %d", sib.Height)
+		}
+		if _, f := siblings[sib.Height]; f {
+			return fmt.Errorf("duplicate sibling at height %d", sib.Height)
+		}
+		siblings[sib.Height] = sib.Hash
+	}
+
+	var h []byte
+	if value != "" {
+		h = coerceToHashLen(value)
+	}
+	for height := 0; height < trieHeight; height++ {
+		sib, f := siblings[height]
+		if !f {
+			sib = defaults[height]
+		}
+		if h == nil && !f {
+			// Both children are empty, so is the parent.
+			continue
+		}
+		cur := h
+		if cur == nil {
+			cur = defaults[height]
+		}
+		if bitIsSet(k, trieHeight-height-1) {
+			h = hasher(sib, cur)
+		} else {
+			h = hasher(cur, sib)
+		}
+	}
+	if !bytes.Equal(h, root) {
+		return fmt.Errorf("proof of %s does not match root hash %s", key, rootHash)
+	}
+	return nil
+}
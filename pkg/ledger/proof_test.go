@@ -0,0 +1,90 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/test/util/assert"
+)
+
+func TestProof(t *testing.T) {
+	l := Make(time.Minute)
+	expectProof := func(root, key, value string) {
+		t.Helper()
+		proof, err := l.ProvePreviousValue(root, key)
+		assert.NoError(t, err)
+		assert.NoError(t, Verify(root, key, value, proof))
+		if value != "" {
+			assert.Error(t, Verify(root, key, value+"x", proof))
+			assert.Error(t, Verify(root, key, "", proof))
+		} else {
+			assert.Error(t, Verify(root, key, "x", proof))
+		}
+	}
+
+	// Empty ledger
+	expectProof(l.RootHash(), "foo", "")
+
+	// A single key is held in a shortcut node
+	_, err := l.Put("foo", "bar")
+	assert.NoError(t, err)
+	single := l.RootHash()
+	expectProof(single, "foo", "bar")
+	expectProof(single, "other", "")
+
+	for i := 0; i < 100; i++ {
+		_, err := l.Put(fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+		assert.NoError(t, err)
+	}
+	root := l.RootHash()
+	for i := 0; i < 100; i++ {
+		expectProof(root, fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+	}
+	expectProof(root, "foo", "bar")
+	expectProof(root, "missing", "")
+
+	assert.NoError(t, l.Delete("foo"))
+	expectProof(l.RootHash(), "foo", "")
+	expectProof(l.RootHash(), "key-1", "1")
+	// Previous versions can still be proven
+	expectProof(single, "foo", "bar")
+	expectProof(root, "foo", "bar")
+
+	// A proof does not hold for another root
+	proof, err := l.ProvePreviousValue(root, "key-1")
+	assert.NoError(t, err)
+	assert.Error(t, Verify(single, "key-1", "1", proof))
+}
+
+func TestVerifyInvalidProof(t *testing.T) {
+	l := Make(time.Minute)
+	_, err := l.Put("foo", "bar")
+	assert.NoError(t, err)
+	_, err = l.Put("baz", "qux")
+	assert.NoError(t, err)
+	proof, err := l.ProvePreviousValue(l.RootHash(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, len(proof.Siblings) > 0, true)
+
+	assert.Error(t, Verify("not base64!", "foo", "bar", proof))
+	assert.Error(t, Verify(l.RootHash(), "foo", "bar", nil))
+	assert.Error(t, Verify(l.RootHash(), "foo", "bar", &Proof{Siblings: []Sibling{{Height: 64, Hash: make([]byte, hashLength)}}}))
+	tampered := &Proof{Siblings: append([]Sibling(nil), proof.Siblings...)}
+	tampered.Siblings[0].Hash = make([]byte, hashLength)
+	assert.Error(t, Verify(l.RootHash(), "foo", "bar", tampered))
+}
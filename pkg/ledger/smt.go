@@ -85,13 +85,19 @@ func newSMT(hash func(data ...[]byte) []byte, updateCache cache.ExpiringCache, r
 	if updateCache == nil {
 		updateCache = cache.NewTTL(forever, time.Second)
 	}
+	return newSMTWithStore(hash, newMemoryStore(updateCache), retentionDuration)
+}
+
+// newSMTWithStore creates a new smt keeping its nodes in store, starting from the root saved in the store.
+func newSMTWithStore(hash func(data ...[]byte) []byte, store Store, retentionDuration time.Duration) *smt {
 	s := &smt{
 		hash:              hash,
 		trieHeight:        len(hash([]byte("height"))) * 8, // hash any string to get output length
 		retentionDuration: retentionDuration,
+		root:              store.Root(),
 	}
 	s.db = &cacheDB{
-		updatedNodes: byteCache{cache: updateCache},
+		updatedNodes: store,
 	}
 	s.loadDefaultHashes()
 	return s
@@ -137,6 +143,7 @@ func (s *smt) Update(keys, values [][]byte) ([]byte, error) {
 	} else {
 		s.root = nil
 	}
+	s.db.updatedNodes.SetRoot(s.root)
 
 	return s.root, nil
 }
@@ -341,12 +348,9 @@ func (s *smt) loadChildren(root []byte, height, iBatch int, batch [][]byte) ([][
 
 // loadBatch fetches a batch of nodes in cache or db
 func (s *smt) loadBatch(root []byte) ([][]byte, error) {
-	var node hash
-	copy(node[:], root)
-
 	// checking updated nodes is useful if get() or update() is called twice in a row without db commit
 	s.db.updatedMux.RLock()
-	val, exists := s.db.updatedNodes.Get(node)
+	val, exists := s.db.updatedNodes.Get(root)
 	s.db.updatedMux.RUnlock()
 	if exists {
 		if s.atomicUpdate {
@@ -412,28 +416,18 @@ func (s *smt) interiorHash(left, right []byte, height, iBatch int, oldRoot []byt
 // storeNode stores a batch and deletes the old node from cache
 func (s *smt) storeNode(batch [][]byte, h, oldRoot []byte) {
 	if !bytes.Equal(h, oldRoot) {
-		var node hash
-		copy(node[:], h)
 		// record new node
 		s.db.updatedMux.Lock()
-		s.db.updatedNodes.Set(node, batch)
+		s.db.updatedNodes.Set(h[:hashLength], batch)
 		s.db.updatedMux.Unlock()
 		s.deleteOldNode(oldRoot)
 	}
 }
 
-// deleteOldNode deletes an old node that has been updated
+// deleteOldNode marks an old node that has been updated for expiration after the retention duration, so
+// previous versions of the trie can be read until then.
 func (s *smt) deleteOldNode(root []byte) {
-	var node hash
-	copy(node[:], root)
-	if !s.atomicUpdate {
-		// dont delete old nodes with atomic updated except when
-		// moving up a shortcut, we dont record every single move
-		s.db.updatedMux.Lock()
-		// mark for expiration?
-		if val, ok := s.db.updatedNodes.Get(node); ok {
-			s.db.updatedNodes.SetWithExpiration(node, val, s.retentionDuration)
-		}
-		s.db.updatedMux.Unlock()
-	}
+	s.db.updatedMux.Lock()
+	s.db.updatedNodes.Expire(root, s.retentionDuration)
+	s.db.updatedMux.Unlock()
 }
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
@@ -231,7 +232,7 @@ func TestSmtRaisesError(t *testing.T) {
 	values := getFreshData(10)
 	_, err := smt.Update(keys, values)
 	assert.NoError(t, err)
-	smt.db.updatedNodes = byteCache{cache: cache.NewTTL(forever, time.Minute)}
+	smt.db.updatedNodes = newMemoryStore(cache.NewTTL(forever, time.Minute))
 	smt.loadDefaultHashes()
 
 	// Check errors are raised is a keys is not in cache nor db
@@ -292,3 +293,52 @@ func BenchmarkCacheHeightLimit(b *testing.B) {
 	smt := newSMT(hasher, cache.NewTTL(forever, time.Minute), time.Minute)
 	benchmark10MAccounts10Ktps(smt, b)
 }
+
+func BenchmarkFileStoreHeightLimit(b *testing.B) {
+	store, err := OpenFileStore(filepath.Join(b.TempDir(), "ledger"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	smt := newSMTWithStore(hasher, store, time.Minute)
+	benchmark10MAccounts10Ktps(smt, b)
+}
+
+// BenchmarkStores compares the stores on batches of updates followed by reads, as in benchmark10MAccounts10Ktps.
+func BenchmarkStores(b *testing.B) {
+	stores := map[string]func(b *testing.B) Store{
+		"memory": func(b *testing.B) Store {
+			return newMemoryStore(cache.NewTTL(forever, time.Minute))
+		},
+		"file": func(b *testing.B) Store {
+			store, err := OpenFileStore(filepath.Join(b.TempDir(), "ledger"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() {
+				_ = store.Close()
+			})
+			return store
+		},
+	}
+	for _, name := range []string{"memory", "file"} {
+		b.Run(name, func(b *testing.B) {
+			smt := newSMTWithStore(hasher, stores[name](b), time.Minute)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				keys := getFreshData(100)
+				values := getFreshData(100)
+				if _, err := smt.Update(keys, values); err != nil {
+					b.Fatal(err)
+				}
+				for i, key := range keys {
+					val, err := smt.Get(key)
+					if err != nil || !bytes.Equal(val, values[i]) {
+						b.Fatal("new key not included")
+					}
+				}
+			}
+		})
+	}
+}
@@ -21,41 +21,75 @@ import (
 	"istio.io/istio/pkg/cache"
 )
 
+// Store holds the nodes of the tree of a Ledger, keyed by their hash, and the current root of the tree.
+// A node is a batch of sub-nodes; its content must not be modified once stored.
+type Store interface {
+	// Get returns the node with the given hash.
+	Get(key []byte) (node [][]byte, ok bool)
+	// Set stores a node.
+	Set(key []byte, node [][]byte)
+	// Expire removes a node once the duration has elapsed, if it is still stored.
+	Expire(key []byte, after time.Duration)
+	// Root returns the root saved by SetRoot, or nil.
+	Root() []byte
+	// SetRoot saves the current root of the tree.
+	SetRoot(root []byte)
+}
+
 type cacheDB struct {
 	// updatedNodes that have will be flushed to disk
-	updatedNodes byteCache
+	updatedNodes Store
 	// updatedMux is a lock for updatedNodes
 	updatedMux sync.RWMutex
 }
 
-// byteCache implements a modified ExpiringCache interface, returning byte arrays
-// for ease of integration with smt calls.
-type byteCache struct {
+// memoryStore is a Store keeping the nodes in an expiring cache.
+type memoryStore struct {
 	cache cache.ExpiringCache
+
+	mu   sync.RWMutex
+	root []byte
 }
 
-// Set inserts an entry in the cache. This will replace any entry with
-// the same key that is already in the cache. The entry may be automatically
-// expunged from the cache at some point, depending on the eviction policies
-// of the cache and the options specified when the cache was created.
-func (b *byteCache) Set(key hash, value [][]byte) {
-	b.cache.Set(key, value)
+var _ Store = &memoryStore{}
+
+func newMemoryStore(c cache.ExpiringCache) *memoryStore {
+	return &memoryStore{cache: c}
 }
 
-// Get retrieves the value associated with the supplied key if the key
-// is present in the cache.
-func (b *byteCache) Get(key hash) (value [][]byte, ok bool) {
-	ivalue, ok := b.cache.Get(key)
+func (m *memoryStore) Set(key []byte, value [][]byte) {
+	m.cache.Set(toHash(key), value)
+}
+
+func (m *memoryStore) Get(key []byte) (value [][]byte, ok bool) {
+	ivalue, ok := m.cache.Get(toHash(key))
 	if ok {
 		value, _ = ivalue.([][]byte)
 	}
 	return
 }
 
-// SetWithExpiration inserts an entry in the cache with a requested expiration time.
-// This will replace any entry with the same key that is already in the cache.
-// The entry will be automatically expunged from the cache at or slightly after the
-// requested expiration time.
-func (b *byteCache) SetWithExpiration(key hash, value [][]byte, expiration time.Duration) {
-	b.cache.SetWithExpiration(key, value, expiration)
+func (m *memoryStore) Expire(key []byte, after time.Duration) {
+	h := toHash(key)
+	if val, ok := m.cache.Get(h); ok {
+		m.cache.SetWithExpiration(h, val, after)
+	}
+}
+
+func (m *memoryStore) Root() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.root
+}
+
+func (m *memoryStore) SetRoot(root []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.root = root
+}
+
+func toHash(key []byte) hash {
+	var h hash
+	copy(h[:], key)
+	return h
 }
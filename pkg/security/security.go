@@ -16,6 +16,7 @@ package security
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -186,11 +187,11 @@ type Options struct {
 	// match the cluster name set in the MC setup.
 	ClusterID string
 
-	// The type of Elliptical Signature algorithm to use
-	// when generating private keys. Currently only ECDSA is supported.
+	// The type of Elliptical Signature algorithm to use when generating private keys: ECDSA, ED25519,
+	// or a signature scheme registered with pkiutil.RegisterSignatureScheme. If empty, RSA is used.
 	ECCSigAlg string
 
-	// The type of curve to use when generating private keys with ECC. Currently only ECDSA is supported.
+	// The type of curve to use when generating private keys with ECDSA: P256 (default), P384 or P521.
 	ECCCurve string
 
 	// FileMountedCerts indicates whether the proxy is using file
@@ -245,6 +246,10 @@ type Options struct {
 	// Root Cert read from the OS
 	CARootPath string
 
+	// CRLRefreshInterval is the interval between fetches of the certificate revocation list of the CA,
+	// delivered to the proxy with the ROOTCA resource. Revocation checks are disabled if zero.
+	CRLRefreshInterval time.Duration
+
 	// The path for an existing certificate chain file
 	CertChainFilePath string
 	// The path for an existing key file
@@ -264,6 +269,15 @@ type Client interface {
 	GetRootCertBundle() ([]string, error)
 }
 
+// ErrRevocationListUnsupported is returned by a RevocationListClient if the CA doesn't publish a revocation list.
+var ErrRevocationListUnsupported = errors.New("the CA does not publish a certificate revocation list")
+
+// RevocationListClient is implemented by the Clients of CAs that can revoke the certificates they issued.
+type RevocationListClient interface {
+	// GetRevocationList returns the PEM encoded certificate revocation list of the CA.
+	GetRevocationList() ([]byte, error)
+}
+
 // SecretManager defines secrets management interface which is used by SDS.
 type SecretManager interface {
 	// GenerateSecret generates new secret for the given resource.
@@ -282,6 +296,9 @@ type SecretItem struct {
 
 	RootCert []byte
 
+	// CRL is the PEM encoded certificate revocation list of the CA, delivered with RootCert.
+	CRL []byte
+
 	// ResourceName passed from envoy SDS discovery request.
 	// "ROOTCA" for root cert request, "default" for key/cert request.
 	ResourceName string
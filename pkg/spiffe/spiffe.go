@@ -15,6 +15,7 @@
 package spiffe
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -24,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	jose "github.com/go-jose/go-jose/v3"
@@ -259,6 +261,10 @@ func RetrieveSpiffeBundleRootCerts(config map[string]string, caCertPool *x509.Ce
 type PeerCertVerifier struct {
 	generalCertPool *x509.CertPool
 	certPools       map[string]*x509.CertPool
+
+	// crlMu protects crls, which can be replaced while peer certificates are verified.
+	crlMu sync.RWMutex
+	crls  []*x509.RevocationList
 }
 
 // NewPeerCertVerifier returns a new PeerCertVerifier.
@@ -314,8 +320,49 @@ func (v *PeerCertVerifier) AddMappings(certMap map[string][]*x509.Certificate) {
 	}
 }
 
+// SetCRLsFromPEM replaces the certificate revocation lists used to check the peer certificates.
+// A CRL only applies to the certificates issued by the certificate that signed it.
+func (v *PeerCertVerifier) SetCRLsFromPEM(crlBytes []byte) error {
+	var crls []*x509.RevocationList
+	for block, rest := pem.Decode(crlBytes); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parse revocation list got error: %v", err)
+		}
+		crls = append(crls, crl)
+	}
+	v.crlMu.Lock()
+	defer v.crlMu.Unlock()
+	v.crls = crls
+	return nil
+}
+
+// checkRevocation returns an error if a certificate of the chain is revoked by a CRL of its issuer.
+func (v *PeerCertVerifier) checkRevocation(chain []*x509.Certificate) error {
+	v.crlMu.RLock()
+	defer v.crlMu.RUnlock()
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range v.crls {
+			if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, revoked := range crl.RevokedCertificateEntries {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate with serial number %s is revoked", cert.SerialNumber.Text(16))
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // VerifyPeerCert is an implementation of tls.Config.VerifyPeerCertificate.
-// It verifies the peer certificate using the root certificates associated with its trust domain.
+// It verifies the peer certificate using the root certificates associated with its trust domain,
+// and checks it is not revoked.
 func (v *PeerCertVerifier) VerifyPeerCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
 	if len(rawCerts) == 0 {
 		// Peer doesn't present a certificate. Just skip. Other authn methods may be used.
@@ -346,9 +393,17 @@ func (v *PeerCertVerifier) VerifyPeerCert(rawCerts [][]byte, _ [][]*x509.Certifi
 		return fmt.Errorf("no cert pool found for trust domain %s", trustDomain)
 	}
 
-	_, err = peerCert.Verify(x509.VerifyOptions{
+	chains, err := peerCert.Verify(x509.VerifyOptions{
 		Roots:         rootCertPool,
 		Intermediates: intCertPool,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if err := v.checkRevocation(chain); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -16,11 +16,17 @@ package spiffe
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -443,6 +449,94 @@ func TestGetGeneralCertPoolAndVerifyPeerCert(t *testing.T) {
 	}
 }
 
+func TestVerifyPeerCertRevoked(t *testing.T) {
+	newCert := func(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	now := time.Now()
+	newCA := func(name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+		return newCert(&x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{Organization: []string{name}},
+			NotBefore:             now.Add(-time.Minute),
+			NotAfter:              now.Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, nil, nil)
+	}
+	root, rootKey := newCA("root")
+	other, otherKey := newCA("root")
+	spiffeID, _ := url.Parse("spiffe://cluster.local/ns/default/sa/default")
+	newLeaf := func(serial int64) *x509.Certificate {
+		cert, _ := newCert(&x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			NotBefore:    now.Add(-time.Minute),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			URIs:         []*url.URL{spiffeID},
+		}, root, rootKey)
+		return cert
+	}
+	newCRL := func(issuer *x509.Certificate, key *ecdsa.PrivateKey, serial int64) []byte {
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(1),
+			ThisUpdate:                now,
+			NextUpdate:                now.Add(time.Hour),
+			RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(serial), RevocationTime: now}},
+		}, issuer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+
+	verifier := NewPeerCertVerifier()
+	verifier.AddMapping("cluster.local", []*x509.Certificate{root})
+	good, revoked := newLeaf(2), newLeaf(3)
+	if err := verifier.VerifyPeerCert([][]byte{revoked.Raw}, nil); err != nil {
+		t.Fatalf("expected certificate to be valid without CRL, got %v", err)
+	}
+
+	// A CRL with the same issuer name, but signed by another key, is ignored.
+	if err := verifier.SetCRLsFromPEM(newCRL(other, otherKey, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyPeerCert([][]byte{revoked.Raw}, nil); err != nil {
+		t.Fatalf("expected CRL of another issuer to be ignored, got %v", err)
+	}
+
+	if err := verifier.SetCRLsFromPEM(newCRL(root, rootKey, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyPeerCert([][]byte{good.Raw}, nil); err != nil {
+		t.Fatalf("expected certificate to be valid, got %v", err)
+	}
+	if err := verifier.VerifyPeerCert([][]byte{revoked.Raw}, nil); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fatalf("expected certificate to be revoked, got %v", err)
+	}
+
+	if err := verifier.SetCRLsFromPEM([]byte("-----BEGIN X509 CRL-----\naW52YWxpZA==\n-----END X509 CRL-----\n")); err == nil {
+		t.Fatal("expected invalid CRL error")
+	}
+}
+
 func TestExpandWithTrustDomains(t *testing.T) {
 	testCases := []struct {
 		name         string
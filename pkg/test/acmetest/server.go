@@ -0,0 +1,809 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acmetest provides an in-process ACME (RFC 8555) server for tests, in the spirit of Pebble.
+//
+// It implements the subset of the protocol used by golang.org/x/crypto/acme: accounts, orders,
+// http-01 and tls-alpn-01 validation, finalization and certificate download. Challenges are
+// validated by connecting to configurable addresses instead of port 80 and 443 of the identifier.
+package acmetest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	statusPending    = "pending"
+	statusProcessing = "processing"
+	statusReady      = "ready"
+	statusValid      = "valid"
+	statusInvalid    = "invalid"
+
+	// validationAttempts and validationInterval bound how long a challenge is retried, to let the
+	// response propagate to the server answering it.
+	validationAttempts = 20
+	validationInterval = 100 * time.Millisecond
+)
+
+// idPeACMEIdentifier is the tls-alpn-01 certificate extension (RFC 8737).
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type account struct {
+	id  string
+	key crypto.PublicKey
+}
+
+type challenge struct {
+	id     string
+	typ    string
+	token  string
+	status string
+	authz  *authorization
+}
+
+type authorization struct {
+	id         string
+	host       string
+	status     string
+	challenges []*challenge
+}
+
+type order struct {
+	id      string
+	account string
+	status  string
+	hosts   []string
+	authzs  []*authorization
+	chain   []byte
+}
+
+// Server is an in-process ACME server.
+type Server struct {
+	srv    *httptest.Server
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	caPem  []byte
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	nextID int
+
+	mu          sync.Mutex
+	httpAddress string
+	tlsAddress  string
+	lifetime    time.Duration
+	nonces      sets.String
+	accounts    map[string]*account
+	orders      map[string]*order
+	authzs      map[string]*authorization
+	challenges  map[string]*challenge
+	issued      []*x509.Certificate
+}
+
+// NewServer starts an ACME server, stopped when the test ends.
+func NewServer(t test.Failer) *Server {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acmetest root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		ca:         ca,
+		caKey:      caKey,
+		caPem:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		stop:       make(chan struct{}),
+		lifetime:   time.Hour,
+		nonces:     sets.New[string](),
+		accounts:   map[string]*account{},
+		orders:     map[string]*order{},
+		authzs:     map[string]*authorization{},
+		challenges: map[string]*challenge{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.handleDirectory)
+	mux.HandleFunc("/new-nonce", s.handleNonce)
+	mux.HandleFunc("POST /new-account", s.handleNewAccount)
+	mux.HandleFunc("POST /account/{id}", s.handleAccount)
+	mux.HandleFunc("POST /new-order", s.handleNewOrder)
+	mux.HandleFunc("POST /order/{id}", s.handleOrder)
+	mux.HandleFunc("POST /authz/{id}", s.handleAuthz)
+	mux.HandleFunc("POST /challenge/{id}", s.handleChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.handleFinalize)
+	mux.HandleFunc("POST /cert/{id}", s.handleCert)
+	s.srv = httptest.NewTLSServer(mux)
+	t.Cleanup(func() {
+		close(s.stop)
+		s.wg.Wait()
+		s.srv.Close()
+	})
+	return s
+}
+
+// DirectoryURL returns the URL of the ACME directory.
+func (s *Server) DirectoryURL() string {
+	return s.srv.URL + "/directory"
+}
+
+// TermsOfService returns the URL of the terms of service, which must be agreed to when creating an account.
+func (s *Server) TermsOfService() string {
+	return s.srv.URL + "/terms"
+}
+
+// ServerCACert returns the PEM certificate of the ACME server TLS endpoint, to be trusted by clients.
+func (s *Server) ServerCACert() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.srv.Certificate().Raw})
+}
+
+// RootCert returns the PEM certificate of the CA issuing certificates.
+func (s *Server) RootCert() []byte {
+	return s.caPem
+}
+
+// SetValidationAddresses sets the addresses dialed to validate http-01 and tls-alpn-01 challenges.
+func (s *Server) SetValidationAddresses(httpAddress, tlsAddress string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.httpAddress = httpAddress
+	s.tlsAddress = tlsAddress
+}
+
+// SetCertificateLifetime sets the lifetime of issued certificates. Defaults to one hour.
+func (s *Server) SetCertificateLifetime(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lifetime = d
+}
+
+// Issued returns the certificates issued so far.
+func (s *Server) Issued() []*x509.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.issued)
+}
+
+func (s *Server) url(format string, args ...any) string {
+	return s.srv.URL + fmt.Sprintf(format, args...)
+}
+
+// newID must be called with mu held.
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *Server) addNonce(w http.ResponseWriter) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	s.mu.Lock()
+	s.nonces.Insert(nonce)
+	s.mu.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *Server) problem(w http.ResponseWriter, status int, typ, format string, args ...any) {
+	s.addNonce(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": fmt.Sprintf(format, args...),
+		"status": status,
+	})
+}
+
+func (s *Server) respond(w http.ResponseWriter, status int, location string, body any) {
+	s.addNonce(w)
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, _ *http.Request) {
+	s.respond(w, http.StatusOK, "", map[string]any{
+		"newNonce":   s.url("/new-nonce"),
+		"newAccount": s.url("/new-account"),
+		"newOrder":   s.url("/new-order"),
+		"revokeCert": s.url("/revoke-cert"),
+		"keyChange":  s.url("/key-change"),
+		"meta":       map[string]any{"termsOfService": s.TermsOfService()},
+	})
+}
+
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	s.addNonce(w)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	KID   string          `json:"kid"`
+	JWK   json.RawMessage `json:"jwk"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// verify checks the JWS of a request, and returns its payload and the key that signed it. The
+// account is set for requests signed with a key ID. On failure, the problem is written.
+func (s *Server) verify(w http.ResponseWriter, r *http.Request, wantJWK bool) ([]byte, crypto.PublicKey, *account, bool) {
+	var msg jwsMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid JWS: %v", err)
+		return nil, nil, nil, false
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid protected header: %v", err)
+		return nil, nil, nil, false
+	}
+	var hdr jwsHeader
+	if err := json.Unmarshal(rawHeader, &hdr); err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid protected header: %v", err)
+		return nil, nil, nil, false
+	}
+	s.mu.Lock()
+	validNonce := s.nonces.Contains(hdr.Nonce)
+	s.nonces.Delete(hdr.Nonce)
+	s.mu.Unlock()
+	if !validNonce {
+		s.problem(w, http.StatusBadRequest, "badNonce", "invalid nonce %q", hdr.Nonce)
+		return nil, nil, nil, false
+	}
+	if hdr.URL != s.url("%s", r.URL.Path) {
+		s.problem(w, http.StatusUnauthorized, "unauthorized", "url %q does not match the request", hdr.URL)
+		return nil, nil, nil, false
+	}
+
+	var key crypto.PublicKey
+	var acct *account
+	if wantJWK {
+		if key, err = parseJWK(hdr.JWK); err != nil {
+			s.problem(w, http.StatusBadRequest, "malformed", "invalid jwk: %v", err)
+			return nil, nil, nil, false
+		}
+	} else {
+		s.mu.Lock()
+		acct = s.accounts[strings.TrimPrefix(hdr.KID, s.url("/account/"))]
+		s.mu.Unlock()
+		if acct == nil {
+			s.problem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", hdr.KID)
+			return nil, nil, nil, false
+		}
+		key = acct.key
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid signature encoding: %v", err)
+		return nil, nil, nil, false
+	}
+	digest := sha256.Sum256([]byte(msg.Protected + "." + msg.Payload))
+	if !verifySignature(hdr.Alg, key, digest[:], sig) {
+		s.problem(w, http.StatusUnauthorized, "unauthorized", "invalid signature")
+		return nil, nil, nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid payload: %v", err)
+		return nil, nil, nil, false
+	}
+	return payload, key, acct, true
+}
+
+func parseJWK(raw []byte) (crypto.PublicKey, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, err
+	}
+	decode := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk.Kty {
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(jwk.X), Y: decode(jwk.Y)}, nil
+	case "RSA":
+		return &rsa.PublicKey{N: decode(jwk.N), E: int(decode(jwk.E).Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			return false
+		}
+		return ecdsa.Verify(k, digest, new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	}
+	return false
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	payload, key, _, ok := s.verify(w, r, true)
+	if !ok {
+		return
+	}
+	var req struct {
+		OnlyReturnExisting bool `json:"onlyReturnExisting"`
+		TermsAgreed        bool `json:"termsOfServiceAgreed"`
+	}
+	_ = json.Unmarshal(payload, &req)
+	thumbprint, err := acme.JWKThumbprint(key)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badPublicKey", "%v", err)
+		return
+	}
+
+	s.mu.Lock()
+	var existing *account
+	for _, a := range s.accounts {
+		if t, _ := acme.JWKThumbprint(a.key); t == thumbprint {
+			existing = a
+		}
+	}
+	if existing == nil && !req.OnlyReturnExisting {
+		if !req.TermsAgreed {
+			s.mu.Unlock()
+			s.problem(w, http.StatusForbidden, "userActionRequired", "the terms of service must be agreed to")
+			return
+		}
+		existing = &account{id: s.newID(), key: key}
+		s.accounts[existing.id] = existing
+		s.mu.Unlock()
+		s.respond(w, http.StatusCreated, s.url("/account/%s", existing.id), map[string]any{"status": statusValid})
+		return
+	}
+	s.mu.Unlock()
+	if existing == nil {
+		s.problem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for key")
+		return
+	}
+	s.respond(w, http.StatusOK, s.url("/account/%s", existing.id), map[string]any{"status": statusValid})
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if _, _, acct, ok := s.verify(w, r, false); ok {
+		s.respond(w, http.StatusOK, s.url("/account/%s", acct.id), map[string]any{"status": statusValid})
+	}
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	payload, _, acct, ok := s.verify(w, r, false)
+	if !ok {
+		return
+	}
+	var req struct {
+		Identifiers []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid order")
+		return
+	}
+	s.mu.Lock()
+	o := &order{id: s.newID(), account: acct.id, status: statusPending}
+	for _, id := range req.Identifiers {
+		if id.Type != "dns" || strings.Contains(id.Value, "*") {
+			s.mu.Unlock()
+			s.problem(w, http.StatusBadRequest, "rejectedIdentifier", "unsupported identifier %s:%s", id.Type, id.Value)
+			return
+		}
+		z := &authorization{id: s.newID(), host: id.Value, status: statusPending}
+		for _, typ := range []string{"http-01", "tls-alpn-01"} {
+			token := make([]byte, 16)
+			_, _ = rand.Read(token)
+			c := &challenge{id: s.newID(), typ: typ, token: base64.RawURLEncoding.EncodeToString(token), status: statusPending, authz: z}
+			z.challenges = append(z.challenges, c)
+			s.challenges[c.id] = c
+		}
+		s.authzs[z.id] = z
+		o.hosts = append(o.hosts, id.Value)
+		o.authzs = append(o.authzs, z)
+	}
+	s.orders[o.id] = o
+	body := s.orderJSON(o)
+	s.mu.Unlock()
+	s.respond(w, http.StatusCreated, s.url("/order/%s", o.id), body)
+}
+
+// orderJSON must be called with mu held.
+func (s *Server) orderJSON(o *order) map[string]any {
+	if o.status == statusPending {
+		ready := true
+		for _, z := range o.authzs {
+			switch z.status {
+			case statusInvalid:
+				o.status = statusInvalid
+			case statusValid:
+			default:
+				ready = false
+			}
+		}
+		if ready && o.status == statusPending {
+			o.status = statusReady
+		}
+	}
+	var ids []map[string]string
+	var authzs []string
+	for i, z := range o.authzs {
+		ids = append(ids, map[string]string{"type": "dns", "value": o.hosts[i]})
+		authzs = append(authzs, s.url("/authz/%s", z.id))
+	}
+	body := map[string]any{
+		"status":         o.status,
+		"identifiers":    ids,
+		"authorizations": authzs,
+		"finalize":       s.url("/finalize/%s", o.id),
+	}
+	if o.chain != nil {
+		body["certificate"] = s.url("/cert/%s", o.id)
+	}
+	return body
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if _, _, _, ok := s.verify(w, r, false); !ok {
+		return
+	}
+	s.mu.Lock()
+	o := s.orders[r.PathValue("id")]
+	if o == nil {
+		s.mu.Unlock()
+		s.problem(w, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	body := s.orderJSON(o)
+	s.mu.Unlock()
+	s.respond(w, http.StatusOK, s.url("/order/%s", o.id), body)
+}
+
+// authzJSON must be called with mu held.
+func (s *Server) authzJSON(z *authorization) map[string]any {
+	var chals []map[string]any
+	for _, c := range z.challenges {
+		chals = append(chals, s.challengeJSON(c))
+	}
+	return map[string]any{
+		"status":     z.status,
+		"identifier": map[string]string{"type": "dns", "value": z.host},
+		"challenges": chals,
+		"expires":    time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+}
+
+// challengeJSON must be called with mu held.
+func (s *Server) challengeJSON(c *challenge) map[string]any {
+	return map[string]any{
+		"type":   c.typ,
+		"url":    s.url("/challenge/%s", c.id),
+		"token":  c.token,
+		"status": c.status,
+	}
+}
+
+func (s *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	if _, _, _, ok := s.verify(w, r, false); !ok {
+		return
+	}
+	s.mu.Lock()
+	z := s.authzs[r.PathValue("id")]
+	if z == nil {
+		s.mu.Unlock()
+		s.problem(w, http.StatusNotFound, "malformed", "authorization not found")
+		return
+	}
+	body := s.authzJSON(z)
+	s.mu.Unlock()
+	s.respond(w, http.StatusOK, "", body)
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	_, _, acct, ok := s.verify(w, r, false)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	c := s.challenges[r.PathValue("id")]
+	if c == nil {
+		s.mu.Unlock()
+		s.problem(w, http.StatusNotFound, "malformed", "challenge not found")
+		return
+	}
+	if c.status == statusPending {
+		c.status = statusProcessing
+		c.authz.status = statusProcessing
+		thumbprint, _ := acme.JWKThumbprint(acct.key)
+		s.wg.Add(1)
+		go s.validate(c, c.token+"."+thumbprint)
+	}
+	body := s.challengeJSON(c)
+	s.mu.Unlock()
+	s.respond(w, http.StatusOK, "", body)
+}
+
+// validate checks the challenge response, retrying until it succeeds or attempts run out.
+func (s *Server) validate(c *challenge, keyAuth string) {
+	defer s.wg.Done()
+	s.mu.Lock()
+	host, typ, token := c.authz.host, c.typ, c.token
+	httpAddress, tlsAddress := s.httpAddress, s.tlsAddress
+	s.mu.Unlock()
+
+	var err error
+	for i := 0; i < validationAttempts; i++ {
+		switch typ {
+		case "http-01":
+			err = validateHTTP01(httpAddress, host, token, keyAuth)
+		case "tls-alpn-01":
+			err = validateTLSALPN01(tlsAddress, host, keyAuth)
+		}
+		if err == nil {
+			break
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(validationInterval):
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		c.status = statusInvalid
+		c.authz.status = statusInvalid
+		return
+	}
+	c.status = statusValid
+	c.authz.status = statusValid
+}
+
+func validateHTTP01(address, host, token, keyAuth string) error {
+	if address == "" {
+		return fmt.Errorf("no http-01 validation address")
+	}
+	client := &http.Client{
+		Timeout: time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + host + "/.well-known/acme-challenge/" + token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != keyAuth {
+		return fmt.Errorf("unexpected http-01 response %d %q", resp.StatusCode, body)
+	}
+	return nil
+}
+
+func validateTLSALPN01(address, host, keyAuth string) error {
+	if address == "" {
+		return fmt.Errorf("no tls-alpn-01 validation address")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", address, &tls.Config{
+		ServerName: host,
+		NextProtos: []string{acme.ALPNProto},
+		// The certificate is self-signed; it is checked below.
+		InsecureSkipVerify: true, // nolint: gosec
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("negotiated protocol %q", state.NegotiatedProtocol)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != host {
+		return fmt.Errorf("certificate is for %v", cert.DNSNames)
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(ext.Value, &got); err != nil {
+			return err
+		}
+		if !ext.Critical || !bytes.Equal(got, want[:]) {
+			return fmt.Errorf("invalid acmeIdentifier extension")
+		}
+		return nil
+	}
+	return fmt.Errorf("no acmeIdentifier extension")
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	payload, _, _, ok := s.verify(w, r, false)
+	if !ok {
+		return
+	}
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", "invalid finalize request: %v", err)
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", "invalid CSR encoding: %v", err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR", "invalid CSR: %v", err)
+		return
+	}
+
+	body, perr := s.finalize(r.PathValue("id"), csr)
+	if perr != nil {
+		s.problem(w, perr.status, perr.typ, "%s", perr.detail)
+		return
+	}
+	s.respond(w, http.StatusOK, s.url("/order/%s", r.PathValue("id")), body)
+}
+
+type problemError struct {
+	status int
+	typ    string
+	detail string
+}
+
+// finalize issues the certificate of a ready order.
+func (s *Server) finalize(id string, csr *x509.CertificateRequest) (map[string]any, *problemError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.orders[id]
+	if o == nil {
+		return nil, &problemError{http.StatusNotFound, "malformed", "order not found"}
+	}
+	s.orderJSON(o)
+	if o.status != statusReady {
+		return nil, &problemError{http.StatusForbidden, "orderNotReady", "order is " + o.status}
+	}
+	if !sets.New(csr.DNSNames...).Equals(sets.New(o.hosts...)) {
+		return nil, &problemError{http.StatusBadRequest, "badCSR", fmt.Sprintf("CSR names %v do not match the order %v", csr.DNSNames, o.hosts)}
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(s.lifetime),
+		DNSNames:     csr.DNSNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, &problemError{http.StatusInternalServerError, "serverInternal", err.Error()}
+	}
+	cert, _ := x509.ParseCertificate(certDer)
+	s.issued = append(s.issued, cert)
+	o.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), s.caPem...)
+	o.status = statusValid
+	body := s.orderJSON(o)
+	return body, nil
+}
+
+func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
+	if _, _, _, ok := s.verify(w, r, false); !ok {
+		return
+	}
+	s.mu.Lock()
+	o := s.orders[r.PathValue("id")]
+	var chain []byte
+	if o != nil {
+		chain = o.chain
+	}
+	s.mu.Unlock()
+	if chain == nil {
+		s.problem(w, http.StatusNotFound, "malformed", "certificate not found")
+		return
+	}
+	s.addNonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(chain)
+}
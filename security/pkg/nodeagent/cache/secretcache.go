@@ -19,6 +19,9 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -123,6 +126,7 @@ type secretCache struct {
 	mu       sync.RWMutex
 	workload *security.SecretItem
 	certRoot []byte
+	crl      []byte
 }
 
 // GetRoot returns cached root cert and cert expiration time. This method is thread safe.
@@ -139,6 +143,20 @@ func (s *secretCache) SetRoot(rootCert []byte) {
 	s.certRoot = rootCert
 }
 
+// GetCRL returns the cached certificate revocation list. This method is thread safe.
+func (s *secretCache) GetCRL() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.crl
+}
+
+// SetCRL sets the certificate revocation list into cache. This method is thread safe.
+func (s *secretCache) SetCRL(crl []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crl = crl
+}
+
 func (s *secretCache) GetWorkload() *security.SecretItem {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -186,9 +204,81 @@ func NewSecretManagerClient(caClient security.Client, options *security.Options)
 
 	go ret.queue.Run(ret.stop)
 	go ret.handleFileWatch()
+	if crlClient, ok := caClient.(security.RevocationListClient); ok && options.CRLRefreshInterval > 0 {
+		go ret.watchCRL(crlClient)
+	}
 	return ret, nil
 }
 
+// watchCRL periodically fetches the certificate revocation list of the CA, and triggers a
+// ROOTCA update when it changes.
+func (sc *SecretManagerClient) watchCRL(client security.RevocationListClient) {
+	ticker := time.NewTicker(sc.configOptions.CRLRefreshInterval)
+	defer ticker.Stop()
+	for {
+		crl, err := client.GetRevocationList()
+		if errors.Is(err, security.ErrRevocationListUnsupported) {
+			cacheLog.Warnf("revocation checks are disabled: %v", err)
+			return
+		}
+		if err != nil {
+			cacheLog.Warnf("failed to fetch the certificate revocation list: %v", err)
+		} else if !bytes.Equal(crl, sc.cache.GetCRL()) {
+			cacheLog.Info("certificate revocation list has changed")
+			sc.cache.SetCRL(crl)
+			sc.OnSecretUpdate(security.RootCertReqResourceName)
+		}
+		select {
+		case <-sc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trustBundleCRL returns the certificate revocation list of the CA to deliver with the trust bundle, if any.
+// The proxy rejects the peer certificates whose issuer has no CRL, so the CRL is only delivered when it covers
+// every issuer of the peer certificates: when all the roots of the trust bundle are the CA issuing it.
+// Otherwise, such as with an intermediate CA or multiple roots, revocation checks are disabled.
+func (sc *SecretManagerClient) trustBundleCRL(rootCertBundle []byte) []byte {
+	crl := sc.cache.GetCRL()
+	if len(crl) == 0 {
+		return nil
+	}
+	if err := crlCoversTrustBundle(crl, rootCertBundle); err != nil {
+		cacheLog.Warnf("revocation checks are disabled: %v", err)
+		return nil
+	}
+	return crl
+}
+
+// crlCoversTrustBundle returns an error unless every certificate of the PEM encoded trust bundle issued the
+// PEM encoded CRL.
+func crlCoversTrustBundle(crlPEM, rootCertBundle []byte) error {
+	block, _ := pem.Decode(crlPEM)
+	if block == nil {
+		return fmt.Errorf("invalid certificate revocation list")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate revocation list: %v", err)
+	}
+	roots := pkiutil.PemCertBytestoString(rootCertBundle)
+	if len(roots) == 0 {
+		return fmt.Errorf("no trust anchor")
+	}
+	for _, root := range roots {
+		cert, err := pkiutil.ParsePemEncodedCertificate([]byte(root))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(cert.RawSubject, crl.RawIssuer) || crl.CheckSignatureFrom(cert) != nil {
+			return fmt.Errorf("the certificate revocation list of %v does not cover the trust anchor %v", crl.Issuer, cert.Subject)
+		}
+	}
+	return nil
+}
+
 func (sc *SecretManagerClient) Close() {
 	_ = sc.certWatcher.Close()
 	if sc.caClient != nil {
@@ -222,6 +312,7 @@ func (sc *SecretManagerClient) getCachedSecret(resourceName string) (secret *sec
 			ns = &security.SecretItem{
 				ResourceName: resourceName,
 				RootCert:     rootCertBundle,
+				CRL:          sc.trustBundleCRL(rootCertBundle),
 			}
 			cacheLog.WithLabels("ttl", time.Until(c.ExpireTime)).Info("returned workload trust anchor from cache")
 
@@ -304,6 +395,7 @@ func (sc *SecretManagerClient) GenerateSecret(resourceName string) (secret *secu
 
 	if resourceName == security.RootCertReqResourceName {
 		ns.RootCert = sc.mergeTrustAnchorBytes(ns.RootCert)
+		ns.CRL = sc.trustBundleCRL(ns.RootCert)
 	} else {
 		// If periodic cert refresh resulted in discovery of a new root, trigger a ROOTCA request to refresh trust anchor
 		oldRoot := sc.cache.GetRoot()
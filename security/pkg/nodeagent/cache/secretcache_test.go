@@ -16,7 +16,12 @@ package cache
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -80,6 +85,153 @@ func TestWorkloadAgentGenerateSecret(t *testing.T) {
 	}
 }
 
+// crlCAClient is a CA client publishing a certificate revocation list. Its CA is self-signed, and signs the
+// workload certificates directly.
+type crlCAClient struct {
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	root   []byte
+
+	mu    sync.Mutex
+	crl   []byte
+	err   error
+	calls int
+}
+
+func newCRLCAClient(t *testing.T) *crlCAClient {
+	root, key, err := pkiutil.GenCertKeyFromOptions(pkiutil.CertOptions{
+		TTL:          time.Hour,
+		Org:          "cluster.local",
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	})
+	assert.NoError(t, err)
+	caCert, err := pkiutil.ParsePemEncodedCertificate(root)
+	assert.NoError(t, err)
+	caKey, err := pkiutil.ParsePemEncodedKey(key)
+	assert.NoError(t, err)
+	return &crlCAClient{caCert: caCert, caKey: caKey.(crypto.Signer), root: root}
+}
+
+func (c *crlCAClient) CSRSign(csrPEM []byte, certValidTTLInSec int64) ([]string, error) {
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	der, err := pkiutil.GenCertFromCSR(csr, c.caCert, csr.PublicKey, c.caKey, []string{"test"}, time.Hour, false)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), string(c.root)}, nil
+}
+
+func (c *crlCAClient) GetRootCertBundle() ([]string, error) {
+	return nil, nil
+}
+
+func (c *crlCAClient) Close() {}
+
+func (c *crlCAClient) GetRevocationList() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.crl, c.err
+}
+
+func (c *crlCAClient) set(crl []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crl, c.err = crl, err
+}
+
+// createCRL returns a PEM encoded CRL signed by the CA.
+func createCRL(t *testing.T, cert *x509.Certificate, key crypto.Signer, number int64) []byte {
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, cert, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestWorkloadAgentRevocationList(t *testing.T) {
+	crlClient := newCRLCAClient(t)
+	crl1 := createCRL(t, crlClient.caCert, crlClient.caKey, 1)
+	crlClient.set(crl1, nil)
+	u := NewUpdateTracker(t)
+	sc := createCache(t, crlClient, u.Callback, security.Options{WorkloadRSAKeySize: 2048, CRLRefreshInterval: 10 * time.Millisecond})
+	u.Expect(map[string]int{security.RootCertReqResourceName: 1})
+
+	root, err := sc.GenerateSecret(security.RootCertReqResourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root.CRL, crl1) {
+		t.Fatalf("expected CRL to be delivered with the root cert, got %q", root.CRL)
+	}
+	// Served from the cache, with the workload certificate.
+	root, err = sc.GenerateSecret(security.RootCertReqResourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root.CRL, crl1) {
+		t.Fatalf("expected cached CRL to be delivered with the root cert, got %q", root.CRL)
+	}
+
+	crl2 := createCRL(t, crlClient.caCert, crlClient.caKey, 2)
+	crlClient.set(crl2, nil)
+	u.Expect(map[string]int{security.RootCertReqResourceName: 2})
+	if root, _ := sc.GenerateSecret(security.RootCertReqResourceName); !bytes.Equal(root.CRL, crl2) {
+		t.Fatalf("expected updated CRL, got %q", root.CRL)
+	}
+
+	// The CRL is not delivered once the trust bundle has another root, as it does not cover its certificates.
+	assert.NoError(t, sc.UpdateConfigTrustBundle([]byte(testcerts.CACert)))
+	if root, _ := sc.GenerateSecret(security.RootCertReqResourceName); root.CRL != nil {
+		t.Fatalf("expected no CRL with another root, got %q", root.CRL)
+	}
+
+	// The CRL is no longer fetched if the CA doesn't publish it.
+	crlClient.set(nil, security.ErrRevocationListUnsupported)
+	calls := -1
+	retry.UntilSuccessOrFail(t, func() error {
+		crlClient.mu.Lock()
+		defer crlClient.mu.Unlock()
+		if calls == crlClient.calls {
+			return nil
+		}
+		calls = crlClient.calls
+		return fmt.Errorf("CRL still fetched")
+	}, retry.Delay(50*time.Millisecond), retry.Timeout(time.Second*5))
+}
+
+func TestCRLCoversTrustBundle(t *testing.T) {
+	ca := newCRLCAClient(t)
+	crl := createCRL(t, ca.caCert, ca.caKey, 1)
+	assert.NoError(t, crlCoversTrustBundle(crl, ca.root))
+
+	// A CRL issued by an intermediate CA does not cover the root.
+	intermediatePEM, keyPEM, err := pkiutil.GenCertKeyFromOptions(pkiutil.CertOptions{
+		TTL:        time.Hour,
+		Org:        "cluster.local",
+		IsCA:       true,
+		SignerCert: ca.caCert,
+		SignerPriv: ca.caKey,
+		RSAKeySize: 2048,
+	})
+	assert.NoError(t, err)
+	intermediate, err := pkiutil.ParsePemEncodedCertificate(intermediatePEM)
+	assert.NoError(t, err)
+	key, err := pkiutil.ParsePemEncodedKey(keyPEM)
+	assert.NoError(t, err)
+	assert.Error(t, crlCoversTrustBundle(createCRL(t, intermediate, key.(crypto.Signer), 1), ca.root))
+	// Nor a trust bundle with other roots.
+	assert.Error(t, crlCoversTrustBundle(crl, append(append([]byte{}, ca.root...), newCRLCAClient(t).root...)))
+	assert.Error(t, crlCoversTrustBundle([]byte("not a crl"), ca.root))
+}
+
 func createCache(t *testing.T, caClient security.Client, notifyCb func(resourceName string), options security.Options) *SecretManagerClient {
 	t.Helper()
 	sc, err := NewSecretManagerClient(caClient, &options)
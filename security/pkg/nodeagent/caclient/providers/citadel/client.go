@@ -20,13 +20,16 @@ import (
 	"fmt"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	pb "istio.io/api/security/v1alpha1"
 	istiogrpc "istio.io/istio/pilot/pkg/grpc"
 	"istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/revocationapi"
 	"istio.io/istio/pkg/security"
 	"istio.io/istio/security/pkg/nodeagent/caclient"
 )
@@ -37,6 +40,8 @@ const (
 
 var citadelClientLog = log.RegisterScope("citadelclient", "citadel client debugging")
 
+var _ security.RevocationListClient = &CitadelClient{}
+
 type CitadelClient struct {
 	// It means enable tls connection to Citadel if this is not nil.
 	tlsOpts  *TLSOptions
@@ -168,3 +173,18 @@ func (c *CitadelClient) reconnect() error {
 func (c *CitadelClient) GetRootCertBundle() ([]string, error) {
 	return []string{}, nil
 }
+
+// GetRevocationList fetches the certificate revocation list of Istiod. It returns
+// security.ErrRevocationListUnsupported if revocation is not enabled in Istiod.
+func (c *CitadelClient) GetRevocationList() ([]byte, error) {
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("ClusterID", c.opts.ClusterID))
+	resp, err := revocationapi.NewIstioCertificateRevocationServiceClient(c.conn).
+		GetRevocationList(ctx, &revocationapi.RevocationListRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, security.ErrRevocationListUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get revocation list: %v", err)
+	}
+	return []byte(resp.Crl), nil
+}
@@ -17,6 +17,7 @@ package citadel
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"path"
@@ -37,6 +38,7 @@ import (
 	"istio.io/istio/pkg/config/constants"
 	"istio.io/istio/pkg/file"
 	"istio.io/istio/pkg/monitoring/monitortest"
+	"istio.io/istio/pkg/revocationapi"
 	"istio.io/istio/pkg/security"
 	"istio.io/istio/pkg/spiffe"
 	"istio.io/istio/pkg/test/env"
@@ -279,6 +281,55 @@ func TestCitadelClient(t *testing.T) {
 	}
 }
 
+type mockRevocationServer struct {
+	revocationapi.UnimplementedIstioCertificateRevocationServiceServer
+	crl string
+}
+
+func (s *mockRevocationServer) GetRevocationList(context.Context, *revocationapi.RevocationListRequest) (
+	*revocationapi.RevocationListResponse, error,
+) {
+	return &revocationapi.RevocationListResponse{Crl: s.crl}, nil
+}
+
+func TestCitadelClientRevocationList(t *testing.T) {
+	t.Run("unsupported", func(t *testing.T) {
+		addr := serve(t, mockCAServer{Certs: fakeCert})
+		cli, err := NewCitadelClient(&security.Options{CAEndpoint: addr}, nil)
+		if err != nil {
+			t.Fatalf("failed to create ca client: %v", err)
+		}
+		t.Cleanup(cli.Close)
+		if _, err := cli.GetRevocationList(); !errors.Is(err, security.ErrRevocationListUnsupported) {
+			t.Fatalf("expected unsupported error, got %v", err)
+		}
+	})
+	t.Run("published", func(t *testing.T) {
+		s := grpc.NewServer()
+		t.Cleanup(s.Stop)
+		revocationapi.RegisterIstioCertificateRevocationServiceServer(s, &mockRevocationServer{crl: "crl"})
+		lis, err := net.Listen("tcp", mockServerAddress)
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		go func() {
+			_ = s.Serve(lis)
+		}()
+		cli, err := NewCitadelClient(&security.Options{CAEndpoint: lis.Addr().String()}, nil)
+		if err != nil {
+			t.Fatalf("failed to create ca client: %v", err)
+		}
+		t.Cleanup(cli.Close)
+		crl, err := cli.GetRevocationList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(crl) != "crl" {
+			t.Fatalf("unexpected CRL %q", crl)
+		}
+	})
+}
+
 type mockTokenCAServer struct {
 	pb.UnimplementedIstioCertificateServiceServer
 	Certs []string
@@ -16,7 +16,7 @@ package ca
 
 import (
 	"context"
-	"crypto/elliptic"
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -121,6 +121,15 @@ type IstioCAOptions struct {
 
 	// OnRootCertUpdate is the cb which can only be called by self-signed root cert rotator
 	OnRootCertUpdate func() error
+
+	// RevocationStore persists the revoked certificates. Certificate revocation is disabled if nil.
+	RevocationStore RevocationStore
+	// CRLValidity is the validity of the revocation lists, signed again after half of it.
+	// Defaults to DefaultCRLValidity.
+	CRLValidity time.Duration
+	// RevocationRefreshInterval is the interval between loads of the certificates revoked by
+	// other replicas from the RevocationStore.
+	RevocationRefreshInterval time.Duration
 }
 
 type RootCertUpdateFunc func() error
@@ -129,10 +138,12 @@ type RootCertUpdateFunc func() error
 // The istio_ca file operates on files mounted in /etc/certs and should be kept in sync.
 //
 // Both can load intemediary certs.
+//
+// If signer is not nil, it holds the CA private key: the secrets only hold the certificates.
 func NewSelfSignedIstioCAOptions(ctx context.Context,
 	rootCertGracePeriodPercentile int, caCertTTL, rootCertCheckInverval, defaultCertTTL,
 	maxCertTTL time.Duration, org string, useCacertsSecretName, dualUse bool, namespace string, client corev1.CoreV1Interface,
-	rootCertFile string, enableJitter bool, caRSAKeySize int,
+	rootCertFile string, enableJitter bool, caRSAKeySize int, signer crypto.Signer,
 ) (caOpts *IstioCAOptions, err error) {
 	caOpts = &IstioCAOptions{
 		CAType:         selfSignedCA,
@@ -164,7 +175,7 @@ func NewSelfSignedIstioCAOptions(ctx context.Context,
 		// We should always check the cacerts first.
 
 		// 1. fetch `istio-ca-secret` in priority
-		err := loadCASecrets(client, namespace, caCertName, rootCertFile, caOpts)
+		err := loadCASecrets(client, namespace, caCertName, rootCertFile, signer, caOpts)
 		if err == nil {
 			return nil
 		} else if apierror.IsNotFound(err) {
@@ -173,7 +184,7 @@ func NewSelfSignedIstioCAOptions(ctx context.Context,
 			// and deprecate/phase out the mounted file in help/k8s. The code to use the filesystem is still useful.
 			if useCacertsSecretName {
 				caCertName = CACertsSecret
-				err := loadCacertSecret(client, namespace, caCertName, rootCertFile, caOpts)
+				err := loadCacertSecret(client, namespace, caCertName, rootCertFile, signer, caOpts)
 				if err == nil {
 					return nil
 				} else if apierror.IsNotFound(err) { // if neither `istio-ca-secret` nor `cacerts` exists, we create a `cacerts`
@@ -193,7 +204,7 @@ func NewSelfSignedIstioCAOptions(ctx context.Context,
 				RSAKeySize:   caRSAKeySize,
 				IsDualUse:    dualUse,
 			}
-			pemCert, pemKey, ckErr := util.GenCertKeyFromOptions(options)
+			pemCert, pemKey, ckErr := genSelfSignedCert(options, signer)
 			if ckErr != nil {
 				pkiCaLog.Warnf("unable to generate CA cert and key for self-signed CA (%v)", ckErr)
 				return fmt.Errorf("unable to generate CA cert and key for self-signed CA (%v)", ckErr)
@@ -204,7 +215,7 @@ func NewSelfSignedIstioCAOptions(ctx context.Context,
 				pkiCaLog.Warnf("failed to append root certificates (%v)", err)
 				return fmt.Errorf("failed to append root certificates (%v)", err)
 			}
-			if caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromPem(pemCert, pemKey, nil, rootCerts); err != nil {
+			if caOpts.KeyCertBundle, err = newVerifiedKeyCertBundle(pemCert, pemKey, signer, nil, rootCerts); err != nil {
 				pkiCaLog.Warnf("failed to create CA KeyCertBundle (%v)", err)
 				return fmt.Errorf("failed to create CA KeyCertBundle (%v)", err)
 			}
@@ -226,10 +237,31 @@ func NewSelfSignedIstioCAOptions(ctx context.Context,
 	return caOpts, err
 }
 
+// genSelfSignedCert generates a self-signed CA certificate, with a new private key unless signer is set.
+func genSelfSignedCert(options util.CertOptions, signer crypto.Signer) (pemCert []byte, pemKey []byte, err error) {
+	if signer == nil {
+		return util.GenCertKeyFromOptions(options)
+	}
+	options.SignerPriv = signer
+	return util.GenRootCertFromExistingKey(options)
+}
+
+// newVerifiedKeyCertBundle returns a new KeyCertBundle using the signer if set, or the private key PEM.
+func newVerifiedKeyCertBundle(certBytes, privKeyBytes []byte, signer crypto.Signer, certChainBytes, rootCertBytes []byte) (
+	*util.KeyCertBundle, error,
+) {
+	if signer != nil {
+		return util.NewVerifiedKeyCertBundleFromSigner(certBytes, signer, certChainBytes, rootCertBytes)
+	}
+	return util.NewVerifiedKeyCertBundleFromPem(certBytes, privKeyBytes, certChainBytes, rootCertBytes)
+}
+
 // loadCASecrets loads the original istio-ca-secret Secret from K8S.
 // To simplify - this secret should be used for both self-generated and user defined intermediary certs.
 // TODO: rename to loadRootsFromSecret.
-func loadCASecrets(client corev1.CoreV1Interface, namespace string, caCertName string, rootCertFile string, caOpts *IstioCAOptions) error {
+func loadCASecrets(client corev1.CoreV1Interface, namespace string, caCertName string, rootCertFile string,
+	signer crypto.Signer, caOpts *IstioCAOptions,
+) error {
 	caSecret, err := client.Secrets(namespace).Get(context.TODO(), caCertName, metav1.GetOptions{})
 	if err == nil {
 		// Original simple case - self-generate root, no intermediaries.
@@ -245,8 +277,8 @@ func loadCASecrets(client corev1.CoreV1Interface, namespace string, caCertName s
 		privData := caSecret.Data[CAPrivateKeyFile]
 		crtData := caSecret.Data[CACertFile]
 
-		if caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromPem(crtData,
-			privData, nil, rootCerts); err != nil {
+		if caOpts.KeyCertBundle, err = newVerifiedKeyCertBundle(crtData,
+			privData, signer, nil, rootCerts); err != nil {
 			return fmt.Errorf("failed to create CA KeyCertBundle (%v)", err)
 		}
 
@@ -254,7 +286,9 @@ func loadCASecrets(client corev1.CoreV1Interface, namespace string, caCertName s
 	return err
 }
 
-func loadCacertSecret(client corev1.CoreV1Interface, namespace string, caCertName string, rootCertFile string, caOpts *IstioCAOptions) error {
+func loadCacertSecret(client corev1.CoreV1Interface, namespace string, caCertName string, rootCertFile string,
+	signer crypto.Signer, caOpts *IstioCAOptions,
+) error {
 	caSecret, err := client.Secrets(namespace).Get(context.TODO(), caCertName, metav1.GetOptions{})
 	if err != nil {
 		return err
@@ -292,8 +326,8 @@ func loadCacertSecret(client corev1.CoreV1Interface, namespace string, caCertNam
 	// systems use the tls.crt style which is the chain that will be sent in all requests.
 	leafBytes, certChainBytes := util.SplitTlsCrt(crtData)
 
-	if caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromPem(leafBytes,
-		privData, certChainBytes, rootCerts); err != nil {
+	if caOpts.KeyCertBundle, err = newVerifiedKeyCertBundle(leafBytes,
+		privData, signer, certChainBytes, rootCerts); err != nil {
 		pkiCaLog.WithLabels("chain", intNames, "roots", rootNames, "error", err).Info("Failed to load CA")
 		return fmt.Errorf("failed to create CA KeyCertBundle (%v)", err)
 	}
@@ -306,9 +340,9 @@ func loadCacertSecret(client corev1.CoreV1Interface, namespace string, caCertNam
 }
 
 // NewSelfSignedDebugIstioCAOptions returns a new IstioCAOptions instance using self-signed certificate produced by in-memory CA,
-// which runs without K8s, and no local ca key file presented.
+// which runs without K8s, and no local ca key file presented. If signer is not nil, it holds the CA private key.
 func NewSelfSignedDebugIstioCAOptions(rootCertFile string, caCertTTL, defaultCertTTL, maxCertTTL time.Duration,
-	org string, caRSAKeySize int,
+	org string, caRSAKeySize int, signer crypto.Signer,
 ) (caOpts *IstioCAOptions, err error) {
 	caOpts = &IstioCAOptions{
 		CAType:         selfSignedCA,
@@ -325,7 +359,7 @@ func NewSelfSignedDebugIstioCAOptions(rootCertFile string, caCertTTL, defaultCer
 		RSAKeySize:   caRSAKeySize,
 		IsDualUse:    true, // hardcoded to true for K8S as well
 	}
-	pemCert, pemKey, ckErr := util.GenCertKeyFromOptions(options)
+	pemCert, pemKey, ckErr := genSelfSignedCert(options, signer)
 	if ckErr != nil {
 		return nil, fmt.Errorf("unable to generate CA cert and key for self-signed CA (%v)", ckErr)
 	}
@@ -335,7 +369,7 @@ func NewSelfSignedDebugIstioCAOptions(rootCertFile string, caCertTTL, defaultCer
 		return nil, fmt.Errorf("failed to append root certificates (%v)", err)
 	}
 
-	if caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromPem(pemCert, pemKey, nil, rootCerts); err != nil {
+	if caOpts.KeyCertBundle, err = newVerifiedKeyCertBundle(pemCert, pemKey, signer, nil, rootCerts); err != nil {
 		return nil, fmt.Errorf("failed to create CA KeyCertBundle (%v)", err)
 	}
 
@@ -345,8 +379,9 @@ func NewSelfSignedDebugIstioCAOptions(rootCertFile string, caCertTTL, defaultCer
 // NewPluggedCertIstioCAOptions returns a new IstioCAOptions instance using given certificate
 // from the file system. This is used only when "/etc/cacerts/" is mounted, using (in default install) cacerts secret.
 // It is simpler to just use the istio-ca-secret for all cases and k8s watching.
+// If signer is not nil, it holds the CA private key and fileBundle.SigningKeyFile is not used.
 func NewPluggedCertIstioCAOptions(fileBundle SigningCAFileBundle,
-	defaultCertTTL, maxCertTTL time.Duration, caRSAKeySize int,
+	defaultCertTTL, maxCertTTL time.Duration, caRSAKeySize int, signer crypto.Signer,
 ) (caOpts *IstioCAOptions, err error) {
 	caOpts = &IstioCAOptions{
 		CAType:         pluggedCertCA,
@@ -355,8 +390,14 @@ func NewPluggedCertIstioCAOptions(fileBundle SigningCAFileBundle,
 		CARSAKeySize:   caRSAKeySize,
 	}
 
-	if caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromFile(
-		fileBundle.SigningCertFile, fileBundle.SigningKeyFile, fileBundle.CertChainFiles, fileBundle.RootCertFile); err != nil {
+	if signer != nil {
+		caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromFileWithSigner(
+			fileBundle.SigningCertFile, signer, fileBundle.CertChainFiles, fileBundle.RootCertFile)
+	} else {
+		caOpts.KeyCertBundle, err = util.NewVerifiedKeyCertBundleFromFile(
+			fileBundle.SigningCertFile, fileBundle.SigningKeyFile, fileBundle.CertChainFiles, fileBundle.RootCertFile)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to create CA KeyCertBundle (%v)", err)
 	}
 
@@ -419,6 +460,9 @@ type IstioCA struct {
 	// rootCertRotator periodically rotates self-signed root cert for CA. It is nil
 	// if CA is not self-signed CA.
 	rootCertRotator *SelfSignedCARootCertRotator
+
+	// revocations holds the revoked certificates. It is nil if revocation is not enabled.
+	revocations *revocationList
 }
 
 // NewIstioCA returns a new IstioCA instance.
@@ -433,6 +477,13 @@ func NewIstioCA(opts *IstioCAOptions) (*IstioCA, error) {
 		ca.rootCertRotator = NewSelfSignedCARootCertRotator(opts.RotatorConfig, ca, opts.OnRootCertUpdate)
 	}
 
+	if opts.RevocationStore != nil {
+		ca.revocations = newRevocationList(opts)
+		if err := ca.revocations.refresh(); err != nil {
+			return nil, fmt.Errorf("failed to load revoked certificates: %v", err)
+		}
+	}
+
 	// if CA cert becomes invalid before workload cert it's going to cause workload cert to be invalid too,
 	// however citatel won't rotate if that happens, this function will prevent that using cert chain TTL as
 	// the workload TTL
@@ -450,6 +501,9 @@ func (ca *IstioCA) Run(stopChan chan struct{}) {
 		// Start root cert rotator in a separate goroutine.
 		go ca.rootCertRotator.Run(stopChan)
 	}
+	if ca.revocations != nil {
+		go ca.revocations.run(stopChan)
+	}
 }
 
 // Sign takes a PEM-encoded CSR and cert opts, and returns a signed leaf certificate.
@@ -486,15 +540,9 @@ func (ca *IstioCA) GenKeyCert(hostnames []string, certTTL time.Duration, checkLi
 
 	// use the type of private key the CA uses to generate an intermediate CA of that type (e.g. CA cert using RSA will
 	// cause intermediate CAs using RSA to be generated)
-	_, signingKey, _, _ := ca.keyCertBundle.GetAll()
-	curve, err := util.GetEllipticCurve(signingKey)
-	if err == nil {
-		opts.ECSigAlg = util.EcdsaSigAlg
-		switch curve {
-		case elliptic.P384():
-			opts.ECCCurve = util.P384Curve
-		default:
-			opts.ECCCurve = util.P256Curve
+	if _, signer := ca.keyCertBundle.GetSigner(); signer != nil {
+		if sigAlg, curve, err := util.GetKeyAlgorithm(signer.Public()); err == nil {
+			opts.ECSigAlg, opts.ECCCurve = sigAlg, curve
 		}
 	}
 
@@ -534,9 +582,10 @@ func (ca *IstioCA) minTTL(defaultCertTTL time.Duration) (time.Duration, error) {
 }
 
 // sign will check the request and return the signed leaf certificate.
+// The signing key may be held by any crypto.Signer.
 func (ca *IstioCA) sign(csrPEM []byte, subjectIDs []string, requestedLifetime time.Duration, checkLifetime, forCA bool) ([]byte, error) {
-	signingCert, signingKey, _, _ := ca.keyCertBundle.GetAll()
-	if signingCert == nil {
+	signingCert, signer := ca.keyCertBundle.GetSigner()
+	if signingCert == nil || signer == nil {
 		return nil, caerror.NewError(caerror.CANotReady, fmt.Errorf("Istio CA is not ready")) // nolint
 	}
 
@@ -560,7 +609,7 @@ func (ca *IstioCA) sign(csrPEM []byte, subjectIDs []string, requestedLifetime ti
 			"requested TTL %s is greater than the max allowed TTL %s", requestedLifetime, ca.maxCertTTL))
 	}
 
-	certBytes, err := util.GenCertFromCSR(csr, signingCert, csr.PublicKey, *signingKey, subjectIDs, lifetime, forCA)
+	certBytes, err := util.GenCertFromCSR(csr, signingCert, csr.PublicKey, signer, subjectIDs, lifetime, forCA)
 	if err != nil {
 		return nil, caerror.NewError(caerror.CertGenError, err)
 	}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"os"
 	"reflect"
 	"sync"
@@ -101,7 +102,7 @@ func TestCreateSelfSignedIstioCAWithoutSecret(t *testing.T) {
 	caopts, err := NewSelfSignedIstioCAOptions(context.Background(),
 		0, caCertTTL, rootCertCheckInverval, defaultCertTTL,
 		maxCertTTL, org, false, false, caNamespace, client.CoreV1(),
-		rootCertFile, false, rsaKeySize)
+		rootCertFile, false, rsaKeySize, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a self-signed CA Options: %v", err)
 	}
@@ -166,7 +167,7 @@ func TestCreateSelfSignedIstioCAWithoutSecretAndUseCacertsEnabled(t *testing.T)
 	caopts, err := NewSelfSignedIstioCAOptions(context.Background(),
 		0, caCertTTL, rootCertCheckInverval, defaultCertTTL,
 		maxCertTTL, org, true, false, caNamespace, client.CoreV1(),
-		rootCertFile, false, rsaKeySize)
+		rootCertFile, false, rsaKeySize, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a self-signed CA Options: %v", err)
 	}
@@ -242,7 +243,7 @@ func TestCreateSelfSignedIstioCAWithSecret(t *testing.T) {
 	caopts, err := NewSelfSignedIstioCAOptions(context.Background(),
 		0, caCertTTL, rootCertCheckInverval, defaultCertTTL, maxCertTTL,
 		org, false, false, caNamespace, client.CoreV1(),
-		rootCertFile, false, rsaKeySize)
+		rootCertFile, false, rsaKeySize, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a self-signed CA Options: %v", err)
 	}
@@ -292,7 +293,7 @@ func TestCreateSelfSignedIstioCAReadSigningCertOnly(t *testing.T) {
 	defer cancel0()
 	_, err := NewSelfSignedIstioCAOptions(ctx0, 0,
 		caCertTTL, defaultCertTTL, rootCertCheckInverval, maxCertTTL, org, false, false,
-		caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize)
+		caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize, nil)
 	if err != nil {
 		t.Errorf("Got unexpected error: %v", err)
 	}
@@ -308,7 +309,7 @@ func TestCreateSelfSignedIstioCAReadSigningCertOnly(t *testing.T) {
 	defer cancel1()
 	caopts, err := NewSelfSignedIstioCAOptions(ctx1, 0,
 		caCertTTL, defaultCertTTL, rootCertCheckInverval, maxCertTTL, org, false, false,
-		caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize)
+		caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize, nil)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -361,7 +362,7 @@ func TestConcurrentCreateSelfSignedIstioCA(t *testing.T) {
 			defer cancel0()
 			caOpts, err := NewSelfSignedIstioCAOptions(ctx0, 0,
 				caCertTTL, defaultCertTTL, rootCertCheckInverval, maxCertTTL, org, false, false,
-				caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize)
+				caNamespace, client.CoreV1(), rootCertFile, false, rsaKeySize, nil)
 			if err != nil {
 				t.Errorf("NewSelfSignedIstioCAOptions got unexpected error: %v", err)
 			}
@@ -412,7 +413,7 @@ func TestCreatePluggedCertCA(t *testing.T) {
 	maxWorkloadCertTTL := time.Hour
 
 	caopts, err := NewPluggedCertIstioCAOptions(SigningCAFileBundle{rootCertFile, certChainFile, signingCertFile, signingKeyFile},
-		defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize)
+		defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a plugged-cert CA Options: %v", err)
 	}
@@ -500,6 +501,24 @@ func TestSignCSR(t *testing.T) {
 			},
 			expectedError: "",
 		},
+		"Workload uses Ed25519": {
+			forCA: false,
+			certOpts: util.CertOptions{
+				// This value is not used, instead, subjectID should be used in certificate.
+				Host:     "spiffe://different.com/test",
+				ECSigAlg: util.Ed25519SigAlg,
+				IsCA:     false,
+			},
+			maxTTL:       time.Hour,
+			requestedTTL: 30 * time.Minute,
+			verifyFields: util.VerifyFields{
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+				KeyUsage:    x509.KeyUsageDigitalSignature,
+				IsCA:        false,
+				Host:        subjectID,
+			},
+			expectedError: "",
+		},
 		"CA uses RSA": {
 			forCA: true,
 			certOpts: util.CertOptions{
@@ -509,7 +528,7 @@ func TestSignCSR(t *testing.T) {
 			maxTTL:       365 * 24 * time.Hour,
 			requestedTTL: 30 * 24 * time.Hour,
 			verifyFields: util.VerifyFields{
-				KeyUsage: x509.KeyUsageCertSign,
+				KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 				IsCA:     true,
 				Host:     subjectID,
 			},
@@ -524,7 +543,22 @@ func TestSignCSR(t *testing.T) {
 			maxTTL:       365 * 24 * time.Hour,
 			requestedTTL: 30 * 24 * time.Hour,
 			verifyFields: util.VerifyFields{
-				KeyUsage: x509.KeyUsageCertSign,
+				KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+				IsCA:     true,
+				Host:     subjectID,
+			},
+			expectedError: "",
+		},
+		"CA uses Ed25519": {
+			forCA: true,
+			certOpts: util.CertOptions{
+				ECSigAlg: util.Ed25519SigAlg,
+				IsCA:     true,
+			},
+			maxTTL:       365 * 24 * time.Hour,
+			requestedTTL: 30 * 24 * time.Hour,
+			verifyFields: util.VerifyFields{
+				KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 				IsCA:     true,
 				Host:     subjectID,
 			},
@@ -610,6 +644,63 @@ func TestSignCSR(t *testing.T) {
 	}
 }
 
+// TestSignCSRWithMixedAlgorithms verifies that CAs of each key type issue verifiable chains for workloads
+// of each key type, and generate Istiod keys of their own type.
+func TestSignCSRWithMixedAlgorithms(t *testing.T) {
+	subjectID := "spiffe://example.com/ns/foo/sa/bar"
+	workloads := map[string]util.CertOptions{
+		"RSA":        {RSAKeySize: 2048},
+		"ECDSA-P256": {ECSigAlg: util.EcdsaSigAlg, ECCCurve: util.P256Curve},
+		"ECDSA-P384": {ECSigAlg: util.EcdsaSigAlg, ECCCurve: util.P384Curve},
+		"ECDSA-P521": {ECSigAlg: util.EcdsaSigAlg, ECCCurve: util.P521Curve},
+		"Ed25519":    {ECSigAlg: util.Ed25519SigAlg},
+	}
+	for _, caSigAlg := range []util.SupportedECSignatureAlgorithms{"", util.EcdsaSigAlg, util.Ed25519SigAlg} {
+		ca, err := createCA(time.Hour, caSigAlg)
+		if err != nil {
+			t.Fatalf("CA %q: createCA error: %v", caSigAlg, err)
+		}
+		_, _, certChainBytes, rootCertBytes := ca.GetCAKeyCertBundle().GetAll()
+		for name, opts := range workloads {
+			t.Run(fmt.Sprintf("CA %q signs %s", caSigAlg, name), func(t *testing.T) {
+				opts.Host = subjectID
+				csrPEM, keyPEM, err := util.GenCSR(opts)
+				if err != nil {
+					t.Fatalf("GenCSR error: %v", err)
+				}
+				certPEM, err := ca.Sign(csrPEM, CertOpts{SubjectIDs: []string{subjectID}, TTL: 30 * time.Minute})
+				if err != nil {
+					t.Fatalf("Sign error: %v", err)
+				}
+				keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+				if opts.ECSigAlg == util.Ed25519SigAlg {
+					keyUsage = x509.KeyUsageDigitalSignature
+				}
+				if err := util.VerifyCertificate(keyPEM, append(certPEM, certChainBytes...), rootCertBytes,
+					&util.VerifyFields{Host: subjectID, KeyUsage: keyUsage,
+						ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}}); err != nil {
+					t.Fatalf("VerifyCertificate error: %v", err)
+				}
+			})
+		}
+
+		certPEM, keyPEM, err := ca.GenKeyCert([]string{"istiod.istio-system.svc"}, time.Hour, false)
+		if err != nil {
+			t.Fatalf("CA %q: GenKeyCert error: %v", caSigAlg, err)
+		}
+		cert, err := util.ParsePemEncodedCertificate(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sigAlg, _, err := util.GetKeyAlgorithm(cert.PublicKey); err != nil || sigAlg != caSigAlg {
+			t.Errorf("CA %q: GenKeyCert generated a %T key", caSigAlg, cert.PublicKey)
+		}
+		if err := util.VerifyCertificate(keyPEM, certPEM, rootCertBytes, nil); err != nil {
+			t.Errorf("CA %q: VerifyCertificate error: %v", caSigAlg, err)
+		}
+	}
+}
+
 func TestAppendRootCerts(t *testing.T) {
 	root1 := "root-cert-1"
 	expRootCerts := `root-cert-1
@@ -649,7 +740,7 @@ func TestSignWithCertChain(t *testing.T) {
 	maxWorkloadCertTTL := time.Hour
 
 	caopts, err := NewPluggedCertIstioCAOptions(SigningCAFileBundle{rootCertFile, certChainFile, signingCertFile, signingKeyFile},
-		defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize)
+		defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize, nil)
 	if err != nil {
 		t.Fatalf("Failed to create a plugged-cert CA Options: %v", err)
 	}
@@ -747,7 +838,7 @@ func TestGenKeyCert(t *testing.T) {
 
 	for id, tc := range cases {
 		caopts, err := NewPluggedCertIstioCAOptions(SigningCAFileBundle{tc.rootCertFile, tc.certChainFile, tc.signingCertFile, tc.signingKeyFile},
-			defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize)
+			defaultWorkloadCertTTL, maxWorkloadCertTTL, rsaKeySize, nil)
 		if err != nil {
 			t.Fatalf("%s: failed to create a plugged-cert CA Options: %v", id, err)
 		}
@@ -0,0 +1,376 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"istio.io/istio/pkg/slices"
+)
+
+const (
+	// RevocationConfigMap stores the certificates revoked by the CA, shared by the Istiod replicas.
+	RevocationConfigMap = "istio-ca-revocations"
+	// revocationConfigMapKey is the key of the JSON encoded revoked certificates in RevocationConfigMap.
+	revocationConfigMapKey = "revoked.json"
+
+	// DefaultCRLValidity is the default validity of the certificate revocation lists.
+	DefaultCRLValidity = 24 * time.Hour
+	// defaultRevocationRefreshInterval is the default interval between loads of the revoked certificates.
+	defaultRevocationRefreshInterval = time.Minute
+	// maxRevocationStoreConflicts bounds the retries of conflicting updates of the revocation store.
+	maxRevocationStoreConflicts = 5
+)
+
+// RevokedCertificate is a certificate revoked by the CA.
+type RevokedCertificate struct {
+	SerialNumber   *big.Int  `json:"serialNumber"`
+	RevocationTime time.Time `json:"revocationTime"`
+	// ReasonCode is the CRL reason code, as defined in RFC 5280 section 5.3.1.
+	ReasonCode int `json:"reasonCode,omitempty"`
+	// NotAfter is the expiration of the certificate. The revocation is dropped once it has passed, as allowed
+	// by RFC 5280 section 3.3. It is never dropped if zero, when the expiration is unknown.
+	NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+// expired returns true if the revoked certificate has expired, so its revocation can be dropped.
+func (r RevokedCertificate) expired(now time.Time) bool {
+	return !r.NotAfter.IsZero() && now.After(r.NotAfter)
+}
+
+// RevocationStore persists the certificates revoked by the CA.
+type RevocationStore interface {
+	// Load returns the revoked certificates that have not expired.
+	Load() ([]RevokedCertificate, error)
+	// Add persists newly revoked certificates, dropping the expired ones, and returns all the revoked
+	// certificates. Certificates that are already revoked are left unchanged.
+	Add(revoked []RevokedCertificate) ([]RevokedCertificate, error)
+}
+
+// NewInMemoryRevocationStore returns a RevocationStore that is not persisted, for a CA without replicas.
+func NewInMemoryRevocationStore() RevocationStore {
+	return &inMemoryRevocationStore{}
+}
+
+type inMemoryRevocationStore struct {
+	mu      sync.Mutex
+	revoked []RevokedCertificate
+}
+
+func (s *inMemoryRevocationStore) Load() ([]RevokedCertificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pruneRevoked(s.revoked, time.Now()), nil
+}
+
+func (s *inMemoryRevocationStore) Add(revoked []RevokedCertificate) ([]RevokedCertificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = pruneRevoked(mergeRevoked(s.revoked, revoked), time.Now())
+	return slices.Clone(s.revoked), nil
+}
+
+// NewConfigMapRevocationStore returns a RevocationStore persisted in the RevocationConfigMap of namespace.
+func NewConfigMapRevocationStore(client corev1.CoreV1Interface, namespace string) RevocationStore {
+	return &configMapRevocationStore{client: client, namespace: namespace}
+}
+
+type configMapRevocationStore struct {
+	client    corev1.CoreV1Interface
+	namespace string
+}
+
+func (s *configMapRevocationStore) Load() ([]RevokedCertificate, error) {
+	cm, err := s.client.ConfigMaps(s.namespace).Get(context.TODO(), RevocationConfigMap, metav1.GetOptions{})
+	if apierror.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := decodeRevoked(cm)
+	if err != nil {
+		return nil, err
+	}
+	return pruneRevoked(revoked, time.Now()), nil
+}
+
+func (s *configMapRevocationStore) Add(revoked []RevokedCertificate) ([]RevokedCertificate, error) {
+	configMaps := s.client.ConfigMaps(s.namespace)
+	for attempt := 0; ; attempt++ {
+		cm, err := configMaps.Get(context.TODO(), RevocationConfigMap, metav1.GetOptions{})
+		if err != nil && !apierror.IsNotFound(err) {
+			return nil, err
+		}
+		exists := err == nil
+		var existing []RevokedCertificate
+		if exists {
+			if existing, err = decodeRevoked(cm); err != nil {
+				return nil, err
+			}
+		} else {
+			cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: RevocationConfigMap, Namespace: s.namespace}}
+		}
+		merged := pruneRevoked(mergeRevoked(existing, revoked), time.Now())
+		data, err := json.Marshal(merged)
+		if err != nil {
+			return nil, err
+		}
+		cm.Data = map[string]string{revocationConfigMapKey: string(data)}
+		if !exists {
+			_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		} else {
+			_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		}
+		if err == nil {
+			return merged, nil
+		}
+		if (!apierror.IsConflict(err) && !apierror.IsAlreadyExists(err)) || attempt >= maxRevocationStoreConflicts {
+			return nil, err
+		}
+	}
+}
+
+func decodeRevoked(cm *v1.ConfigMap) ([]RevokedCertificate, error) {
+	data := cm.Data[revocationConfigMapKey]
+	if data == "" {
+		return nil, nil
+	}
+	var revoked []RevokedCertificate
+	if err := json.Unmarshal([]byte(data), &revoked); err != nil {
+		return nil, fmt.Errorf("failed to decode %s/%s: %v", cm.Namespace, cm.Name, err)
+	}
+	return revoked, nil
+}
+
+// mergeRevoked returns existing with the certificates of revoked that are not already in it.
+func mergeRevoked(existing, revoked []RevokedCertificate) []RevokedCertificate {
+	out := slices.Clone(existing)
+	for _, r := range revoked {
+		if slices.FindFunc(out, func(e RevokedCertificate) bool { return e.SerialNumber.Cmp(r.SerialNumber) == 0 }) == nil {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// pruneRevoked returns the revoked certificates that have not expired.
+func pruneRevoked(revoked []RevokedCertificate, now time.Time) []RevokedCertificate {
+	return slices.Filter(revoked, func(r RevokedCertificate) bool {
+		return !r.expired(now)
+	})
+}
+
+// revocationList holds the revoked certificates, and the CRL signed for them.
+type revocationList struct {
+	store           RevocationStore
+	validity        time.Duration
+	refreshInterval time.Duration
+
+	mu      sync.RWMutex
+	revoked map[string]RevokedCertificate
+	// crl is the PEM encoded CRL, nil if it must be signed again.
+	crl []byte
+	// crlIssuer is the raw certificate that signed the crl.
+	crlIssuer []byte
+	// crlRenewAt is the time after which the crl is signed again.
+	crlRenewAt time.Time
+}
+
+func newRevocationList(opts *IstioCAOptions) *revocationList {
+	l := &revocationList{
+		store:           opts.RevocationStore,
+		validity:        opts.CRLValidity,
+		refreshInterval: opts.RevocationRefreshInterval,
+		revoked:         map[string]RevokedCertificate{},
+	}
+	if l.validity <= 0 {
+		l.validity = DefaultCRLValidity
+	}
+	if l.refreshInterval <= 0 {
+		l.refreshInterval = defaultRevocationRefreshInterval
+	}
+	return l
+}
+
+// set replaces the revoked certificates, invalidating the CRL if they changed.
+func (l *revocationList) set(revoked []RevokedCertificate) {
+	m := make(map[string]RevokedCertificate, len(revoked))
+	for _, r := range revoked {
+		m[r.SerialNumber.Text(16)] = r
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(m) == len(l.revoked) {
+		same := true
+		for k := range m {
+			if _, f := l.revoked[k]; !f {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	l.revoked = m
+	l.crl = nil
+}
+
+// refresh loads the certificates revoked by other replicas.
+func (l *revocationList) refresh() error {
+	revoked, err := l.store.Load()
+	if err != nil {
+		return err
+	}
+	l.set(revoked)
+	return nil
+}
+
+func (l *revocationList) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := l.refresh(); err != nil {
+				pkiCaLog.Warnf("failed to load revoked certificates: %v", err)
+			}
+		}
+	}
+}
+
+// RevocationEnabled returns true if the CA supports certificate revocation.
+func (ca *IstioCA) RevocationEnabled() bool {
+	return ca.revocations != nil
+}
+
+// Revoke revokes the certificates, identified by their serial number, with the given reason code and
+// expiration, and returns the number of certificates that were not already revoked. Their revocation time is
+// set to now.
+//
+// The expiration of a certificate is only known from the certificate itself: the certificates issued for other
+// CAs may outlive the max workload certificate TTL. The revocations without an expiration are never dropped.
+func (ca *IstioCA) Revoke(certs []RevokedCertificate) (int, error) {
+	if ca.revocations == nil {
+		return 0, fmt.Errorf("certificate revocation is not enabled")
+	}
+	now := time.Now()
+	var revoked []RevokedCertificate
+	for _, c := range certs {
+		if _, f := ca.IsRevoked(c.SerialNumber); f {
+			continue
+		}
+		c.RevocationTime = now
+		revoked = mergeRevoked(revoked, []RevokedCertificate{c})
+	}
+	if len(revoked) == 0 {
+		return 0, nil
+	}
+	all, err := ca.revocations.store.Add(revoked)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store revoked certificates: %v", err)
+	}
+	ca.revocations.set(all)
+	for _, r := range revoked {
+		// For audit - revoking a cert is an important operation.
+		pkiCaLog.WithLabels("serial", r.SerialNumber.Text(16), "reason", r.ReasonCode).Info("CertificateRevoked")
+	}
+	return len(revoked), nil
+}
+
+// IsRevoked returns the revocation of the certificate with the given serial number, if it was revoked.
+func (ca *IstioCA) IsRevoked(serialNumber *big.Int) (RevokedCertificate, bool) {
+	if ca.revocations == nil {
+		return RevokedCertificate{}, false
+	}
+	ca.revocations.mu.RLock()
+	defer ca.revocations.mu.RUnlock()
+	r, f := ca.revocations.revoked[serialNumber.Text(16)]
+	return r, f
+}
+
+// GetCRL returns the PEM encoded certificate revocation list, signed by the CA signing certificate.
+// It is nil if revocation is not enabled.
+func (ca *IstioCA) GetCRL() ([]byte, error) {
+	l := ca.revocations
+	if l == nil {
+		return nil, nil
+	}
+	signingCert, signer := ca.keyCertBundle.GetSigner()
+	if signingCert == nil || signer == nil {
+		return nil, fmt.Errorf("istio CA is not ready")
+	}
+	now := time.Now()
+	l.mu.RLock()
+	crl := l.crl
+	valid := crl != nil && bytes.Equal(l.crlIssuer, signingCert.Raw) && now.Before(l.crlRenewAt)
+	l.mu.RUnlock()
+	if valid {
+		return crl, nil
+	}
+
+	if signingCert.KeyUsage != 0 && signingCert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, fmt.Errorf("the CA certificate %q is not allowed to sign revocation lists (missing cRLSign key usage)",
+			signingCert.Subject)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]x509.RevocationListEntry, 0, len(l.revoked))
+	for _, r := range l.revoked {
+		if r.expired(now) {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   r.SerialNumber,
+			RevocationTime: r.RevocationTime,
+			ReasonCode:     r.ReasonCode,
+		})
+	}
+	slices.SortFunc(entries, func(a, b x509.RevocationListEntry) int {
+		return a.SerialNumber.Cmp(b.SerialNumber)
+	})
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		// The time makes the CRL number increase across replicas and restarts.
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(l.validity),
+	}, signingCert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign revocation list: %v", err)
+	}
+	l.crl = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	l.crlIssuer = signingCert.Raw
+	l.crlRenewAt = now.Add(l.validity / 2)
+	return l.crl, nil
+}
@@ -0,0 +1,261 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func createRevocationCA(t *testing.T, store RevocationStore) *IstioCA {
+	t.Helper()
+	ca, err := createCA(time.Hour, util.EcdsaSigAlg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.revocations = newRevocationList(&IstioCAOptions{RevocationStore: store})
+	if err := ca.revocations.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func parseCRL(t *testing.T, ca *IstioCA) *x509.RevocationList {
+	t.Helper()
+	crlPEM, err := ca.GetCRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(crlPEM)
+	if block == nil || block.Type != "X509 CRL" {
+		t.Fatalf("invalid CRL PEM: %s", crlPEM)
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingCert, _, _, _ := ca.keyCertBundle.GetAll()
+	if err := crl.CheckSignatureFrom(signingCert); err != nil {
+		t.Fatalf("CRL not signed by the CA: %v", err)
+	}
+	return crl
+}
+
+func crlSerials(crl *x509.RevocationList) []string {
+	var out []string
+	for _, e := range crl.RevokedCertificateEntries {
+		out = append(out, e.SerialNumber.Text(16))
+	}
+	return out
+}
+
+// revokedSerials returns the revocations of the certificates with the given serial numbers.
+func revokedSerials(reasonCode int, serials ...int64) []RevokedCertificate {
+	return slices.Map(serials, func(sn int64) RevokedCertificate {
+		return RevokedCertificate{SerialNumber: big.NewInt(sn), ReasonCode: reasonCode}
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	ca := createRevocationCA(t, NewInMemoryRevocationStore())
+	if !ca.RevocationEnabled() {
+		t.Fatal("expected revocation to be enabled")
+	}
+	if crl := parseCRL(t, ca); len(crl.RevokedCertificateEntries) != 0 {
+		t.Fatalf("expected empty CRL, got %v", crlSerials(crl))
+	}
+
+	n, err := ca.Revoke(revokedSerials(1, 0x20, 0x10, 0x10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 revoked certificates, got %d", n)
+	}
+	// Revoking again is a no-op.
+	if n, err := ca.Revoke(revokedSerials(0, 0x10)); err != nil || n != 0 {
+		t.Fatalf("expected no newly revoked certificates, got %d, %v", n, err)
+	}
+
+	r, f := ca.IsRevoked(big.NewInt(0x10))
+	if !f || r.ReasonCode != 1 {
+		t.Fatalf("expected 0x10 to be revoked for key compromise, got %v %v", r, f)
+	}
+	if _, f := ca.IsRevoked(big.NewInt(0x30)); f {
+		t.Fatal("expected 0x30 not to be revoked")
+	}
+
+	crl := parseCRL(t, ca)
+	if got := strings.Join(crlSerials(crl), ","); got != "10,20" {
+		t.Fatalf("unexpected CRL serials %v", got)
+	}
+	if crl.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Fatalf("unexpected reason code %d", crl.RevokedCertificateEntries[0].ReasonCode)
+	}
+	if !crl.NextUpdate.After(time.Now().Add(DefaultCRLValidity - time.Hour)) {
+		t.Fatalf("unexpected next update %v", crl.NextUpdate)
+	}
+	// The CRL is cached until half of its validity.
+	cached, _ := ca.GetCRL()
+	again, _ := ca.GetCRL()
+	if string(cached) != string(again) {
+		t.Fatal("expected the CRL to be cached")
+	}
+}
+
+func TestRevocationDisabled(t *testing.T) {
+	ca, err := createCA(time.Hour, util.EcdsaSigAlg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.RevocationEnabled() {
+		t.Fatal("expected revocation to be disabled")
+	}
+	if _, err := ca.Revoke(revokedSerials(0, 1)); err == nil {
+		t.Fatal("expected revocation to fail")
+	}
+	if crl, err := ca.GetCRL(); crl != nil || err != nil {
+		t.Fatalf("expected no CRL, got %s, %v", crl, err)
+	}
+}
+
+func TestRevocationMissingCRLSign(t *testing.T) {
+	rootCert, rootKey, err := util.GenCertKeyFromOptions(util.CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		TTL:          time.Hour,
+		Org:          "Root CA",
+		ECSigAlg:     util.EcdsaSigAlg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Certificates issued before the cRLSign key usage was added can't sign CRLs.
+	cert, _ := util.ParsePemEncodedCertificate(rootCert)
+	key, _ := util.ParsePemEncodedKey(rootKey)
+	cert.KeyUsage = x509.KeyUsageCertSign
+	der, err := x509.CreateCertificate(nil, cert, cert, cert.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	bundle, err := util.NewVerifiedKeyCertBundleFromPem(certPEM, rootKey, nil, certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewIstioCA(&IstioCAOptions{
+		DefaultCertTTL:  time.Hour,
+		MaxCertTTL:      time.Hour,
+		KeyCertBundle:   bundle,
+		RevocationStore: NewInMemoryRevocationStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.GetCRL(); err == nil || !strings.Contains(err.Error(), "cRLSign") {
+		t.Fatalf("expected missing cRLSign error, got %v", err)
+	}
+}
+
+func TestConfigMapRevocationStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	replica1 := createRevocationCA(t, NewConfigMapRevocationStore(client.CoreV1(), "istio-system"))
+	replica2 := createRevocationCA(t, NewConfigMapRevocationStore(client.CoreV1(), "istio-system"))
+
+	if _, err := replica1.Revoke(revokedSerials(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica2.Revoke(revokedSerials(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	// The second replica loaded the certificate revoked by the first one when updating the store.
+	if _, f := replica2.IsRevoked(big.NewInt(1)); !f {
+		t.Fatal("expected replica2 to know the certificate revoked by replica1")
+	}
+	if _, f := replica1.IsRevoked(big.NewInt(2)); f {
+		t.Fatal("expected replica1 to learn about revocations only on refresh")
+	}
+	if err := replica1.revocations.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(crlSerials(parseCRL(t, replica1)), ","); got != "1,2" {
+		t.Fatalf("unexpected CRL serials %v", got)
+	}
+
+	// A new replica loads the revoked certificates on creation.
+	replica3 := createRevocationCA(t, NewConfigMapRevocationStore(client.CoreV1(), "istio-system"))
+	if _, f := replica3.IsRevoked(big.NewInt(2)); !f {
+		t.Fatal("expected replica3 to load the revoked certificates")
+	}
+}
+
+func TestRevocationExpiry(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapRevocationStore(client.CoreV1(), "istio-system")
+	now := time.Now()
+	if _, err := store.Add([]RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)},
+		{SerialNumber: big.NewInt(2), RevocationTime: now.Add(-2 * time.Hour), NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(3), RevocationTime: now.Add(-2 * time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ca := createRevocationCA(t, store)
+	if _, f := ca.IsRevoked(big.NewInt(1)); f {
+		t.Fatal("expected the revocation of the expired certificate to be dropped")
+	}
+	if got := strings.Join(crlSerials(parseCRL(t, ca)), ","); got != "2,3" {
+		t.Fatalf("unexpected CRL serials %v", got)
+	}
+
+	// The revocation expires with the certificate, and is kept if its expiration is unknown.
+	if _, err := ca.Revoke([]RevokedCertificate{
+		{SerialNumber: big.NewInt(4), NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(5)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := ca.IsRevoked(big.NewInt(4)); !r.NotAfter.Equal(now.Add(time.Hour)) || r.RevocationTime.Before(now) {
+		t.Fatalf("expected the revocation to expire with the certificate, got %v", r)
+	}
+	if r, _ := ca.IsRevoked(big.NewInt(5)); !r.NotAfter.IsZero() {
+		t.Fatalf("expected the revocation to never expire, got %v", r.NotAfter)
+	}
+
+	// The expired revocations are removed from the store when it is updated.
+	cm, err := client.CoreV1().ConfigMaps("istio-system").Get(context.TODO(), RevocationConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := decodeRevoked(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials := slices.Map(stored, func(r RevokedCertificate) string { return r.SerialNumber.Text(16) })
+	if got := strings.Join(serials, ","); got != "2,3,4,5" {
+		t.Fatalf("unexpected stored serials %v", got)
+	}
+}
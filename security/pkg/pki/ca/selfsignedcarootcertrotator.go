@@ -174,8 +174,8 @@ func (rotator *SelfSignedCARootCertRotator) checkAndRotateRootCertForSigningCert
 				return
 			}
 
-			if err := rotator.ca.GetCAKeyCertBundle().VerifyAndSetAll(caSecret.Data[CACertFile],
-				caSecret.Data[CAPrivateKeyFile], nil, rootCerts); err != nil {
+			if err := rotator.setKeyCertBundle(caSecret.Data[CACertFile],
+				caSecret.Data[CAPrivateKeyFile], rootCerts); err != nil {
 				rootCertRotatorLog.Errorf("failed to reload root cert into KeyCertBundle (%v)", err)
 			} else {
 				rootCertRotatorLog.Info("Successfully reloaded root cert into KeyCertBundle.")
@@ -203,6 +203,11 @@ func (rotator *SelfSignedCARootCertRotator) checkAndRotateRootCertForSigningCert
 		RSAKeySize:    rotator.ca.caRSAKeySize,
 		IsDualUse:     rotator.config.dualUse,
 	}
+	// The private key is not in the secret if it is held by an external signer.
+	if signer := rotator.ca.GetCAKeyCertBundle().ExternalSigner(); signer != nil {
+		options.SignerPrivPem = nil
+		options.SignerPriv = signer
+	}
 	// options should be consistent with the one used in NewSelfSignedIstioCAOptions().
 	// This is to make sure when rotate the root cert, we don't make unnecessary changes
 	// to the certificate or add extra fields to the certificate.
@@ -259,7 +264,7 @@ func (rotator *SelfSignedCARootCertRotator) updateRootCertificate(caSecret *v1.S
 		return false, fmt.Errorf("failed to update CA secret (error: %s)", err.Error())
 	}
 	rootCertRotatorLog.Infof("Root certificate is written into CA secret: %v", string(cert))
-	if err := rotator.ca.GetCAKeyCertBundle().VerifyAndSetAll(cert, key, nil, rootCert); err != nil {
+	if err := rotator.setKeyCertBundle(cert, key, rootCert); err != nil {
 		if rollForward {
 			// Rolling forward root certificate fails at keycertbundle update, notify caller to rollback.
 			return true, fmt.Errorf("failed to update CA KeyCertBundle (error: %s)", err.Error())
@@ -272,3 +277,13 @@ func (rotator *SelfSignedCARootCertRotator) updateRootCertificate(caSecret *v1.S
 	}
 	return false, nil
 }
+
+// setKeyCertBundle verifies and sets the root cert and key in the CA KeyCertBundle. If the CA private key
+// is held by an external signer, the key from the secret is ignored and the signer is kept.
+func (rotator *SelfSignedCARootCertRotator) setKeyCertBundle(cert, key, rootCert []byte) error {
+	bundle := rotator.ca.GetCAKeyCertBundle()
+	if signer := bundle.ExternalSigner(); signer != nil {
+		return bundle.VerifyAndSetAllWithSigner(cert, signer, nil, rootCert)
+	}
+	return bundle.VerifyAndSetAll(cert, key, nil, rootCert)
+}
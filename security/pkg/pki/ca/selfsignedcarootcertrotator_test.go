@@ -17,7 +17,12 @@ package ca
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"testing"
 	"time"
 
@@ -319,6 +324,72 @@ func TestRootCertRotatorGoroutineForSigningCitadel(t *testing.T) {
 	verifyRootCertAndPrivateKey(t, false, certItem1, certItem2)
 }
 
+// externalSigner hides the private key behind the crypto.Signer interface, as a HSM would.
+type externalSigner struct {
+	crypto.Signer
+}
+
+// TestRootCertRotatorWithExternalSigner verifies that the rotator rotates a root cert signed
+// by an external key, without storing the key in the CA secret.
+func TestRootCertRotatorWithExternalSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := externalSigner{key}
+	client := fake.NewSimpleClientset()
+	caopts, err := NewSelfSignedIstioCAOptions(context.Background(),
+		cmd.DefaultRootCertGracePeriodPercentile, time.Hour, time.Hour, 30*time.Minute, time.Hour,
+		"test.ca.Org", false, false, caNamespace, client.CoreV1(), "", false, 2048, signer)
+	if err != nil {
+		t.Fatalf("failed to create a self-signed CA Options: %v", err)
+	}
+	rotator := getRootCertRotator(caopts)
+
+	certItem0 := loadCert(rotator)
+	if len(certItem0.caSecret.Data[CAPrivateKeyFile]) != 0 {
+		t.Fatalf("the CA secret should not store the external signing key")
+	}
+	rotator.config.certInspector = certutil.NewCertUtil(100)
+	rotator.checkAndRotateRootCert()
+	certItem1 := loadCert(rotator)
+	if bytes.Equal(certItem0.rootCertInKeyCertBundle, certItem1.rootCertInKeyCertBundle) {
+		t.Fatalf("root cert should be rotated")
+	}
+	if len(certItem1.caSecret.Data[CAPrivateKeyFile]) != 0 {
+		t.Fatalf("the CA secret should not store the external signing key")
+	}
+	rootCert, err := util.ParsePemEncodedCertificate(certItem1.rootCertInKeyCertBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(rootCert.PublicKey) {
+		t.Fatalf("the rotated root cert does not certify the external signing key")
+	}
+	if _, s := rotator.ca.keyCertBundle.GetSigner(); s != crypto.Signer(signer) {
+		t.Fatalf("the CA should keep signing with the external signer, got %T", s)
+	}
+
+	// Workload certificates are signed with the external key, and verified by the rotated root.
+	csrPEM, _, err := util.GenCSR(util.CertOptions{Host: "spiffe://cluster.local/ns/default/sa/default", ECSigAlg: util.EcdsaSigAlg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := rotator.ca.Sign(csrPEM, CertOpts{SubjectIDs: []string{"spiffe://cluster.local/ns/default/sa/default"}, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := util.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Fatalf("failed to verify the workload certificate: %v", err)
+	}
+}
+
 func getDefaultSelfSignedIstioCAOptions(fclient *fake.Clientset) *IstioCAOptions {
 	caCertTTL := time.Hour
 	defaultCertTTL := 30 * time.Minute
@@ -335,7 +406,7 @@ func getDefaultSelfSignedIstioCAOptions(fclient *fake.Clientset) *IstioCAOptions
 	caopts, _ := NewSelfSignedIstioCAOptions(context.Background(),
 		cmd.DefaultRootCertGracePeriodPercentile, caCertTTL,
 		rootCertCheckInverval, defaultCertTTL, maxCertTTL, org, false, false,
-		caNamespace, client, rootCertFile, false, rsaKeySize)
+		caNamespace, client, rootCertFile, false, rsaKeySize, nil)
 	return caopts
 }
 
@@ -0,0 +1,316 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// ACMEChallengeHTTP01 is the http-01 challenge type: the key authorization is served over
+	// plain HTTP at /.well-known/acme-challenge/<token>.
+	ACMEChallengeHTTP01 = "http-01"
+	// ACMEChallengeTLSALPN01 is the tls-alpn-01 challenge type (RFC 8737): a self-signed
+	// certificate is served on port 443 for the acme-tls/1 ALPN protocol.
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// DefaultACMEChallengeTypes are the challenge types used by default, in order of preference.
+var DefaultACMEChallengeTypes = []string{ACMEChallengeTLSALPN01, ACMEChallengeHTTP01}
+
+// ACMEOptions : Configuration Options for the ACME RA
+type ACMEOptions struct {
+	// DirectoryURL : URL of the ACME directory, for example https://acme-v02.api.letsencrypt.org/directory
+	DirectoryURL string
+
+	// Email : Optional contact for the ACME account.
+	Email string
+
+	// AccountKey : Key of the ACME account. The account is registered on first use.
+	AccountKey crypto.Signer
+
+	// ServerCACertFile : File containing PEM encoded roots used to verify the ACME server.
+	// The system roots are used if empty.
+	ServerCACertFile string
+
+	// ChallengeTypes : Challenge types to use, in order of preference. Defaults to DefaultACMEChallengeTypes.
+	ChallengeTypes []string
+
+	// AcceptTermsOfService : Agree to the terms of service of the ACME CA when registering the account.
+	// Accounts can not be registered with a CA publishing terms of service unless it is set.
+	AcceptTermsOfService bool
+}
+
+// ACMEChallenge is the response to an ACME challenge, to be served for Host until cleaned up.
+type ACMEChallenge struct {
+	// Type is ACMEChallengeHTTP01 or ACMEChallengeTLSALPN01.
+	Type string
+	Host string
+	// Token and KeyAuthorization are the http-01 path suffix and response body.
+	Token            string
+	KeyAuthorization string
+	// CertificatePEM and KeyPEM are the tls-alpn-01 certificate and key.
+	CertificatePEM []byte
+	KeyPEM         []byte
+}
+
+// ACMEChallengeSolver serves ACME challenge responses.
+type ACMEChallengeSolver interface {
+	// Present makes the challenge response reachable by the ACME server. It returns once
+	// the response is expected to be served.
+	Present(ctx context.Context, ch ACMEChallenge) error
+	// CleanUp stops serving the challenge response.
+	CleanUp(ch ACMEChallenge) error
+}
+
+// ACMERA obtains certificates for DNS names from an ACME (RFC 8555) CA.
+//
+// Unlike the other registration authorities, it is not a CertificateAuthority for workloads:
+// public ACME CAs only issue certificates for DNS names validated with challenges, not for
+// SPIFFE identities. It is used to provision gateway certificates, with the challenges
+// answered by the gateways through an ACMEChallengeSolver.
+type ACMERA struct {
+	opts   ACMEOptions
+	solver ACMEChallengeSolver
+	client *acme.Client
+
+	// mutex protects registered.
+	mutex      sync.Mutex
+	registered bool
+}
+
+// NewACMERA : Create a RA that obtains certificates from an ACME server.
+func NewACMERA(opts ACMEOptions, solver ACMEChallengeSolver) (*ACMERA, error) {
+	if opts.DirectoryURL == "" {
+		return nil, fmt.Errorf("ACME directory URL is required")
+	}
+	if opts.AccountKey == nil {
+		return nil, fmt.Errorf("ACME account key is required")
+	}
+	if solver == nil {
+		return nil, fmt.Errorf("ACME challenge solver is required")
+	}
+	if len(opts.ChallengeTypes) == 0 {
+		opts.ChallengeTypes = DefaultACMEChallengeTypes
+	}
+	for _, t := range opts.ChallengeTypes {
+		if t != ACMEChallengeHTTP01 && t != ACMEChallengeTLSALPN01 {
+			return nil, fmt.Errorf("unsupported ACME challenge type %q", t)
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.ServerCACertFile != "" {
+		roots, err := os.ReadFile(opts.ServerCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME server CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(roots) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.ServerCACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &ACMERA{
+		opts:   opts,
+		solver: solver,
+		client: &acme.Client{
+			Key:          opts.AccountKey,
+			DirectoryURL: opts.DirectoryURL,
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+			},
+			UserAgent: "istiod",
+		},
+	}, nil
+}
+
+// Close releases the connections to the ACME server.
+func (r *ACMERA) Close() {
+	r.client.HTTPClient.CloseIdleConnections()
+}
+
+// register creates the ACME account, or finds the existing one for the account key.
+func (r *ACMERA) register(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.registered {
+		return nil
+	}
+	acct := &acme.Account{}
+	if r.opts.Email != "" {
+		acct.Contact = []string{"mailto:" + r.opts.Email}
+	}
+	dir, err := r.client.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read ACME directory: %v", err)
+	}
+	if dir.Terms != "" && !r.opts.AcceptTermsOfService {
+		return fmt.Errorf("the terms of service of the ACME CA (%s) must be accepted to register an account", dir.Terms)
+	}
+	accept := func(string) bool { return r.opts.AcceptTermsOfService }
+	if _, err := r.client.Register(ctx, acct, accept); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("failed to register ACME account: %v", err)
+	}
+	r.registered = true
+	return nil
+}
+
+// Issue obtains a certificate for the DNS names in the PEM-encoded CSR. It returns the PEM
+// encoded certificate chain, leaf first.
+func (r *ACMERA) Issue(ctx context.Context, csrPEM []byte) ([]byte, error) {
+	csr, err := util.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %v", err)
+	}
+	if len(csr.DNSNames) == 0 {
+		return nil, fmt.Errorf("CSR has no DNS names")
+	}
+	// Wildcards can only be validated with dns-01, which is not supported.
+	for _, h := range csr.DNSNames {
+		if strings.Contains(h, "*") {
+			return nil, fmt.Errorf("wildcard DNS name %q is not supported", h)
+		}
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.URIs) > 0 || len(csr.EmailAddresses) > 0 {
+		return nil, fmt.Errorf("CSR may only contain DNS names")
+	}
+	if err := r.register(ctx); err != nil {
+		return nil, err
+	}
+
+	order, err := r.client.AuthorizeOrder(ctx, acme.DomainIDs(csr.DNSNames...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME order: %v", err)
+	}
+	if err := r.authorize(ctx, order.AuthzURLs); err != nil {
+		return nil, err
+	}
+	if _, err := r.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("ACME order failed: %v", err)
+	}
+	ders, _, err := r.client.CreateOrderCert(ctx, order.FinalizeURL, csr.Raw, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize ACME order: %v", err)
+	}
+	var chain []byte
+	for _, der := range ders {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return chain, nil
+}
+
+// authorize completes the pending authorizations of an order. All challenge responses are
+// presented before any is accepted, so the propagation delays overlap.
+func (r *ACMERA) authorize(ctx context.Context, authzURLs []string) error {
+	type pending struct {
+		authzURL  string
+		challenge *acme.Challenge
+		response  ACMEChallenge
+	}
+	var presented []pending
+	defer func() {
+		for _, p := range presented {
+			if err := r.solver.CleanUp(p.response); err != nil {
+				pkiRaLog.Warnf("failed to clean up ACME challenge for %s: %v", p.response.Host, err)
+			}
+		}
+	}()
+
+	for _, u := range authzURLs {
+		z, err := r.client.GetAuthorization(ctx, u)
+		if err != nil {
+			return fmt.Errorf("failed to get ACME authorization: %v", err)
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		chal := r.selectChallenge(z.Challenges)
+		if chal == nil {
+			return fmt.Errorf("no supported ACME challenge for %s", z.Identifier.Value)
+		}
+		resp, err := r.challengeResponse(chal, z.Identifier.Value)
+		if err != nil {
+			return err
+		}
+		if err := r.solver.Present(ctx, resp); err != nil {
+			return fmt.Errorf("failed to present ACME challenge for %s: %v", resp.Host, err)
+		}
+		presented = append(presented, pending{authzURL: u, challenge: chal, response: resp})
+	}
+
+	for _, p := range presented {
+		if _, err := r.client.Accept(ctx, p.challenge); err != nil {
+			return fmt.Errorf("failed to accept ACME challenge for %s: %v", p.response.Host, err)
+		}
+	}
+	for _, p := range presented {
+		if _, err := r.client.WaitAuthorization(ctx, p.authzURL); err != nil {
+			return fmt.Errorf("ACME authorization for %s failed: %v", p.response.Host, err)
+		}
+	}
+	return nil
+}
+
+func (r *ACMERA) selectChallenge(challenges []*acme.Challenge) *acme.Challenge {
+	for _, t := range r.opts.ChallengeTypes {
+		if c := slices.FindFunc(challenges, func(c *acme.Challenge) bool { return c.Type == t }); c != nil {
+			return *c
+		}
+	}
+	return nil
+}
+
+func (r *ACMERA) challengeResponse(chal *acme.Challenge, host string) (ACMEChallenge, error) {
+	resp := ACMEChallenge{Type: chal.Type, Host: host, Token: chal.Token}
+	switch chal.Type {
+	case ACMEChallengeHTTP01:
+		keyAuth, err := r.client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return resp, err
+		}
+		resp.KeyAuthorization = keyAuth
+	case ACMEChallengeTLSALPN01:
+		cert, err := r.client.TLSALPN01ChallengeCert(chal.Token, host)
+		if err != nil {
+			return resp, err
+		}
+		key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			return resp, err
+		}
+		resp.CertificatePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		resp.KeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	}
+	return resp, nil
+}
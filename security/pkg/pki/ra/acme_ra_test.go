@@ -0,0 +1,257 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/acmetest"
+	"istio.io/istio/pkg/test/util/assert"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+// fakeSolver serves challenge responses from local HTTP and TLS servers.
+type fakeSolver struct {
+	mu        sync.Mutex
+	responses map[string]ACMEChallenge
+	presented []string
+	cleaned   []string
+
+	httpServer *httptest.Server
+	tlsServer  *httptest.Server
+}
+
+func newFakeSolver(t *testing.T) *fakeSolver {
+	s := &fakeSolver{responses: map[string]ACMEChallenge{}}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		ch, f := s.responses[r.Host]
+		s.mu.Unlock()
+		if !f || ch.Type != ACMEChallengeHTTP01 || r.URL.Path != "/.well-known/acme-challenge/"+ch.Token {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(ch.KeyAuthorization))
+	}))
+	s.tlsServer = httptest.NewUnstartedServer(http.NotFoundHandler())
+	s.tlsServer.TLS = &tls.Config{
+		NextProtos: []string{"acme-tls/1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.mu.Lock()
+			ch, f := s.responses[hello.ServerName]
+			s.mu.Unlock()
+			if !f || ch.Type != ACMEChallengeTLSALPN01 {
+				return nil, nil
+			}
+			c, err := tls.X509KeyPair(ch.CertificatePEM, ch.KeyPEM)
+			return &c, err
+		},
+	}
+	s.tlsServer.StartTLS()
+	t.Cleanup(s.httpServer.Close)
+	t.Cleanup(s.tlsServer.Close)
+	return s
+}
+
+func (s *fakeSolver) Present(_ context.Context, ch ACMEChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[ch.Host] = ch
+	s.presented = append(s.presented, ch.Type+"/"+ch.Host)
+	return nil
+}
+
+func (s *fakeSolver) CleanUp(ch ACMEChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, ch.Host)
+	s.cleaned = append(s.cleaned, ch.Type+"/"+ch.Host)
+	return nil
+}
+
+func testACMEOptions(t *testing.T, server *acmetest.Server, challengeTypes ...string) ACMEOptions {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, server.ServerCACert(), 0o600))
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return ACMEOptions{
+		DirectoryURL:         server.DirectoryURL(),
+		Email:                "admin@example.com",
+		AccountKey:           key,
+		ServerCACertFile:     caFile,
+		ChallengeTypes:       challengeTypes,
+		AcceptTermsOfService: true,
+	}
+}
+
+func newTestACMERA(t *testing.T, server *acmetest.Server, solver ACMEChallengeSolver, challengeTypes ...string) *ACMERA {
+	r, err := NewACMERA(testACMEOptions(t, server, challengeTypes...), solver)
+	assert.NoError(t, err)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func createACMECsr(t test.Failer, hosts ...string) []byte {
+	csr, _, err := pkiutil.GenCSR(pkiutil.CertOptions{Host: strings.Join(hosts, ","), ECSigAlg: pkiutil.EcdsaSigAlg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestACMEIssue(t *testing.T) {
+	cases := []struct {
+		name           string
+		challengeTypes []string
+		hosts          []string
+		presented      []string
+	}{
+		{
+			name:      "default tls-alpn-01",
+			hosts:     []string{"a.example.com"},
+			presented: []string{"tls-alpn-01/a.example.com"},
+		},
+		{
+			name:           "http-01",
+			challengeTypes: []string{ACMEChallengeHTTP01},
+			hosts:          []string{"a.example.com", "b.example.com"},
+			presented:      []string{"http-01/a.example.com", "http-01/b.example.com"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			server := acmetest.NewServer(t)
+			solver := newFakeSolver(t)
+			server.SetValidationAddresses(solver.httpServer.Listener.Addr().String(), solver.tlsServer.Listener.Addr().String())
+			r := newTestACMERA(t, server, solver, tt.challengeTypes...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			chain, err := r.Issue(ctx, createACMECsr(t, tt.hosts...))
+			assert.NoError(t, err)
+
+			certs, _, err := pkiutil.ParsePemEncodedCertificateChain(chain)
+			assert.NoError(t, err)
+			assert.Equal(t, len(certs), 2)
+			assert.Equal(t, certs[0].DNSNames, tt.hosts)
+			roots := x509.NewCertPool()
+			roots.AppendCertsFromPEM(server.RootCert())
+			_, err = certs[0].Verify(x509.VerifyOptions{Roots: roots, DNSName: tt.hosts[0]})
+			assert.NoError(t, err)
+			assert.Equal(t, solver.presented, tt.presented)
+			assert.Equal(t, solver.cleaned, tt.presented)
+
+			// The account is reused for the next order.
+			_, err = r.Issue(ctx, createACMECsr(t, tt.hosts...))
+			assert.NoError(t, err)
+			assert.Equal(t, len(server.Issued()), 2)
+		})
+	}
+}
+
+func TestACMEIssueErrors(t *testing.T) {
+	server := acmetest.NewServer(t)
+	solver := newFakeSolver(t)
+	r := newTestACMERA(t, server, solver)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := r.Issue(ctx, createACMECsr(t, "*.example.com"))
+	assert.Error(t, err)
+	_, err = r.Issue(ctx, []byte("not a csr"))
+	assert.Error(t, err)
+
+	// No validation address: the challenge is never answered.
+	_, err = r.Issue(ctx, createACMECsr(t, "a.example.com"))
+	if err == nil || !strings.Contains(err.Error(), "authorization for a.example.com failed") {
+		t.Fatalf("expected authorization failure, got %v", err)
+	}
+	assert.Equal(t, solver.cleaned, []string{"tls-alpn-01/a.example.com"})
+	assert.Equal(t, len(server.Issued()), 0)
+}
+
+func TestACMETermsOfService(t *testing.T) {
+	server := acmetest.NewServer(t)
+	solver := newFakeSolver(t)
+	server.SetValidationAddresses(solver.httpServer.Listener.Addr().String(), solver.tlsServer.Listener.Addr().String())
+	opts := testACMEOptions(t, server)
+	opts.AcceptTermsOfService = false
+	r, err := NewACMERA(opts, solver)
+	assert.NoError(t, err)
+	t.Cleanup(r.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = r.Issue(ctx, createACMECsr(t, "a.example.com"))
+	if err == nil || !strings.Contains(err.Error(), server.TermsOfService()) {
+		t.Fatalf("expected the terms of service to be required, got %v", err)
+	}
+	assert.Equal(t, len(solver.presented), 0)
+	assert.Equal(t, len(server.Issued()), 0)
+}
+
+func TestNewACMERAErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	solver := &fakeSolver{}
+	cases := []struct {
+		name   string
+		opts   ACMEOptions
+		solver ACMEChallengeSolver
+	}{
+		{"no directory", ACMEOptions{AccountKey: key}, solver},
+		{"no key", ACMEOptions{DirectoryURL: "https://acme"}, solver},
+		{"no solver", ACMEOptions{DirectoryURL: "https://acme", AccountKey: key}, nil},
+		{"bad challenge", ACMEOptions{DirectoryURL: "https://acme", AccountKey: key, ChallengeTypes: []string{"dns-01"}}, solver},
+		{"missing CA", ACMEOptions{DirectoryURL: "https://acme", AccountKey: key, ServerCACertFile: "/does/not/exist"}, solver},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewACMERA(tt.opts, tt.solver); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestACMEChallengeCertificate(t *testing.T) {
+	server := acmetest.NewServer(t)
+	r := newTestACMERA(t, server, &fakeSolver{})
+	resp, err := r.challengeResponse(&acme.Challenge{Type: ACMEChallengeTLSALPN01, Token: "token"}, "a.example.com")
+	assert.NoError(t, err)
+	block, _ := pem.Decode(resp.CertificatePEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.DNSNames, []string{"a.example.com"})
+	_, err = tls.X509KeyPair(resp.CertificatePEM, resp.KeyPEM)
+	assert.NoError(t, err)
+}
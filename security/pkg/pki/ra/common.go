@@ -15,6 +15,8 @@ package ra
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	clientset "k8s.io/client-go/kubernetes"
@@ -41,8 +43,7 @@ type CaExternalType string
 // IstioRAOptions : Configuration Options for the IstioRA
 type IstioRAOptions struct {
 	// ExternalCAType: Integration API type with external CA
-	// Only ISTIOD_RA_KUBERNETES_API is supported
-	// deprecated - when we add a different kind we can add the right setting.
+	// ISTIOD_RA_KUBERNETES_API and ISTIOD_RA_EST are supported
 	ExternalCAType CaExternalType
 
 	// DefaultCertTTL: Default Certificate TTL
@@ -67,12 +68,17 @@ type IstioRAOptions struct {
 	TrustDomain string
 	// CertSignerDomain is based on CERT_SIGNER_DOMAIN env variable
 	CertSignerDomain string
+	// EST : Options for the EST server, used with ISTIOD_RA_EST
+	EST ESTOptions
 }
 
 const (
 	// ExtCAK8s : Integrate with external CA using k8s CSR API
 	ExtCAK8s CaExternalType = "ISTIOD_RA_KUBERNETES_API"
 
+	// ExtCAEST : Integrate with external CA using Enrollment over Secure Transport (RFC 7030)
+	ExtCAEST CaExternalType = "ISTIOD_RA_EST"
+
 	// DefaultExtCACertDir : Location of external CA certificate
 	DefaultExtCACertDir string = "./etc/external-ca-cert"
 )
@@ -136,3 +142,48 @@ func preSign(raOpts *IstioRAOptions, csrPEM []byte, subjectIDs []string, request
 	}
 	return lifetime, nil
 }
+
+// meshConfigCACertificates holds the root certificates from mesh config.
+type meshConfigCACertificates struct {
+	// Key is the comma-joined list of signer names
+	// Value is Root CAs (PEM list)
+	certs map[string]string
+
+	// mutex protects the R/W to certs.
+	mutex sync.RWMutex
+}
+
+func (m *meshConfigCACertificates) set(caCertificates []*meshconfig.MeshConfig_CertificateData) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.certs == nil {
+		m.certs = make(map[string]string)
+	}
+	for _, pemCert := range caCertificates {
+		// TODO:  take care of spiffe bundle format as well
+		cert := pemCert.GetPem()
+		certSigners := pemCert.CertSigners
+		if len(certSigners) != 0 {
+			certSigner := strings.Join(certSigners, ",")
+			if cert != "" {
+				m.certs[certSigner] = cert
+			}
+		}
+	}
+}
+
+func (m *meshConfigCACertificates) get(signerName string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if len(m.certs) == 0 {
+		return nil, fmt.Errorf("no caCertificates defined in mesh config")
+	}
+	for signers, caCertificate := range m.certs {
+		for _, signer := range strings.Split(signers, ",") {
+			if signer == signerName {
+				return []byte(caCertificate), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to find root cert for signer: %v in mesh config", signerName)
+}
@@ -0,0 +1,401 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// DefaultESTCACertsRefreshInterval is the default interval between fetches of the EST CA certificates.
+	DefaultESTCACertsRefreshInterval = time.Hour
+
+	estRequestTimeout = 30 * time.Second
+	// estMaxResponseSize bounds the responses read from the EST server.
+	estMaxResponseSize = 1 << 20
+)
+
+// ESTOptions : Configuration Options for the EST RA
+type ESTOptions struct {
+	// ServerURL : Base URL of the EST server, including the well-known prefix.
+	// For example https://est.example.com/.well-known/est
+	ServerURL string
+
+	// Label : Optional CA label, for EST servers hosting multiple CAs.
+	Label string
+
+	// ServerCACertFile : File containing PEM encoded roots used to verify the EST server.
+	// The system roots are used if empty.
+	ServerCACertFile string
+
+	// ClientCertFile and ClientKeyFile : Certificate and key used to authenticate to the EST server.
+	// They are read on each TLS handshake, so rotated files are picked up.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Username and PasswordFile : Credentials for HTTP basic authentication.
+	// The password file is read on each request.
+	Username     string
+	PasswordFile string
+
+	// CACertsRefreshInterval : Interval between fetches of /cacerts, to detect root rotation.
+	CACertsRefreshInterval time.Duration
+}
+
+// ESTRA integrated with an external CA using Enrollment over Secure Transport (RFC 7030).
+//
+// CSRs are validated by Istiod and forwarded to the EST server with /simpleenroll. The CA
+// certificates are fetched from /cacerts, at startup and periodically, and are used as the
+// roots and intermediates returned to workloads.
+type ESTRA struct {
+	raOpts *IstioRAOptions
+	opts   ESTOptions
+	client *http.Client
+
+	// keyCertBundle holds the CA certificates from /cacerts.
+	keyCertBundle atomic.Pointer[util.KeyCertBundle]
+
+	caCertificatesFromMeshConfig meshConfigCACertificates
+
+	// mutex protects rootCertHandlers, and serializes refreshes of keyCertBundle.
+	mutex            sync.Mutex
+	rootCertHandlers []func()
+}
+
+var _ RegistrationAuthority = &ESTRA{}
+
+// NewESTRA : Create a RA that interfaces with an EST server. The CA certificates are fetched
+// from the server, which must be reachable.
+func NewESTRA(raOpts *IstioRAOptions) (*ESTRA, error) {
+	opts := raOpts.EST
+	if opts.ServerURL == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("EST server URL is required"))
+	}
+	if _, err := url.Parse(opts.ServerURL); err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid EST server URL %q: %v", opts.ServerURL, err))
+	}
+	if (opts.ClientCertFile == "") != (opts.ClientKeyFile == "") {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("EST client certificate and key must be set together"))
+	}
+	if opts.CACertsRefreshInterval <= 0 {
+		opts.CACertsRefreshInterval = DefaultESTCACertsRefreshInterval
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.ServerCACertFile != "" {
+		roots, err := os.ReadFile(opts.ServerCACertFile)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("failed to read EST server CA: %v", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(roots) {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("no certificates found in %s", opts.ServerCACertFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.ClientCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("failed to load EST client certificate: %v", err))
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			c, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &c, nil
+		}
+	}
+
+	r := &ESTRA{
+		raOpts: raOpts,
+		opts:   opts,
+		client: &http.Client{
+			Timeout:   estRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}
+	if err := r.RefreshCACertificates(); err != nil {
+		r.client.CloseIdleConnections()
+		return nil, raerror.NewError(raerror.CAInitFail, err)
+	}
+	return r, nil
+}
+
+// Run refreshes the CA certificates periodically, until stop is closed.
+func (r *ESTRA) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.opts.CACertsRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			r.client.CloseIdleConnections()
+			return
+		case <-ticker.C:
+			if err := r.RefreshCACertificates(); err != nil {
+				pkiRaLog.Errorf("failed to refresh EST CA certificates: %v", err)
+			}
+		}
+	}
+}
+
+// AddRootCertsHandler registers a handler called when the CA certificates from the EST server change.
+func (r *ESTRA) AddRootCertsHandler(h func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rootCertHandlers = append(r.rootCertHandlers, h)
+}
+
+// RefreshCACertificates fetches the CA certificates from /cacerts.
+//
+// Self-signed certificates are used as roots, and the others as intermediates. During a root
+// rollover (RFC 7030 section 4.1.3) the server returns both the old and new roots, and the
+// NewWithOld and OldWithNew link certificates. Both roots are trusted and the link certificates,
+// which share a key with one of the roots, are dropped.
+func (r *ESTRA) RefreshCACertificates() error {
+	certs, err := r.fetchCerts(http.MethodGet, "cacerts", nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch CA certificates: %v", err)
+	}
+	var roots, intermediates []*x509.Certificate
+	for _, c := range certs {
+		if isSelfSigned(c) {
+			roots = append(roots, c)
+		}
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no root certificate in CA certificates")
+	}
+	for _, c := range certs {
+		if !isSelfSigned(c) && !sharesKey(c, roots) {
+			intermediates = append(intermediates, c)
+		}
+	}
+	rootPem, chainPem := encodeCerts(roots), encodeCerts(intermediates)
+
+	r.mutex.Lock()
+	old := r.keyCertBundle.Load()
+	if old != nil && bytes.Equal(old.GetRootCertPem(), rootPem) && bytes.Equal(old.GetCertChainPem(), chainPem) {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.keyCertBundle.Store(util.NewKeyCertBundleFromPem(nil, nil, chainPem, rootPem))
+	handlers := r.rootCertHandlers
+	r.mutex.Unlock()
+
+	if old != nil {
+		pkiRaLog.Infof("EST CA certificates changed: %d roots, %d intermediates", len(roots), len(intermediates))
+		for _, h := range handlers {
+			h()
+		}
+	}
+	return nil
+}
+
+// enroll forwards the CSR to the EST server, and returns the issued certificate followed by the
+// intermediates needed to verify it.
+func (r *ESTRA) enroll(csrPEM []byte, certOpts ca.CertOpts) ([]*x509.Certificate, error) {
+	// EST has no standard way to request a lifetime - the server profile decides.
+	if _, err := preSign(r.raOpts, csrPEM, certOpts.SubjectIDs, certOpts.TTL, certOpts.ForCA); err != nil {
+		return nil, err
+	}
+	if certOpts.CertSigner != "" && certOpts.CertSigner != r.opts.Label {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("EST RA does not support signer %s", certOpts.CertSigner))
+	}
+	csr, err := util.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	certs, err := r.fetchCerts(http.MethodPost, "simpleenroll", csr.Raw)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("EST enrollment failed: %v", err))
+	}
+
+	// The order of the certificates in the response is not specified; find the one for our key.
+	var leaf *x509.Certificate
+	var others []*x509.Certificate
+	for _, c := range certs {
+		if leaf == nil && publicKeyEqual(c.PublicKey, csr.PublicKey) {
+			leaf = c
+		} else if !isSelfSigned(c) {
+			others = append(others, c)
+		}
+	}
+	if leaf == nil {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("EST response does not contain a certificate for the CSR key"))
+	}
+	if err := r.verify(leaf, others); err != nil {
+		// The CA may have rotated since the last refresh.
+		if refreshErr := r.RefreshCACertificates(); refreshErr != nil {
+			pkiRaLog.Warnf("failed to refresh EST CA certificates: %v", refreshErr)
+		}
+		if err = r.verify(leaf, others); err != nil {
+			return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("certificate issued by EST server is not trusted: %v", err))
+		}
+	}
+	return append([]*x509.Certificate{leaf}, others...), nil
+}
+
+// verify checks the issued certificate chains to the roots from /cacerts.
+func (r *ESTRA) verify(leaf *x509.Certificate, intermediates []*x509.Certificate) error {
+	_, _, chainPem, rootPem := r.GetCAKeyCertBundle().GetAllPem()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootPem)
+	inter := x509.NewCertPool()
+	inter.AppendCertsFromPEM(chainPem)
+	for _, c := range intermediates {
+		inter.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// Sign takes a PEM-encoded CSR and cert opts, and returns a certificate signed by the EST server.
+// It returns the leaf certificate only.
+func (r *ESTRA) Sign(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	certs, err := r.enroll(csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCerts(certs[:1]), nil
+}
+
+// SignWithCertChain is similar to Sign but returns the leaf cert and the entire cert chain.
+// The intermediates come from the enrollment response, or /cacerts if the response has none.
+func (r *ESTRA) SignWithCertChain(csrPEM []byte, certOpts ca.CertOpts) ([]string, error) {
+	certs, err := r.enroll(csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	_, _, chainPem, rootPem := r.GetCAKeyCertBundle().GetAllPem()
+	cert := encodeCerts(certs)
+	if len(certs) == 1 {
+		cert = append(cert, chainPem...)
+	}
+	return []string{string(cert), string(rootPem)}, nil
+}
+
+// GetCAKeyCertBundle returns the KeyCertBundle with the CA certificates from the EST server.
+func (r *ESTRA) GetCAKeyCertBundle() *util.KeyCertBundle {
+	return r.keyCertBundle.Load()
+}
+
+func (r *ESTRA) SetCACertificatesFromMeshConfig(caCertificates []*meshconfig.MeshConfig_CertificateData) {
+	r.caCertificatesFromMeshConfig.set(caCertificates)
+}
+
+func (r *ESTRA) GetRootCertFromMeshConfig(signerName string) ([]byte, error) {
+	return r.caCertificatesFromMeshConfig.get(signerName)
+}
+
+// fetchCerts calls an EST operation and parses the base64 encoded PKCS#7 response.
+func (r *ESTRA) fetchCerts(method, operation string, body []byte) ([]*x509.Certificate, error) {
+	u := strings.TrimSuffix(r.opts.ServerURL, "/")
+	if r.opts.Label != "" {
+		u += "/" + url.PathEscape(r.opts.Label)
+	}
+	u += "/" + operation
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = strings.NewReader(base64.StdEncoding.EncodeToString(body))
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/pkcs10")
+		req.Header.Set("Content-Transfer-Encoding", "base64")
+	}
+	if r.opts.Username != "" {
+		password, err := os.ReadFile(r.opts.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EST password: %v", err)
+		}
+		req.SetBasicAuth(r.opts.Username, strings.TrimSpace(string(password)))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, estMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		// Manual approval on the EST server; the workload will retry.
+		return nil, fmt.Errorf("%s is pending, retry after %q", operation, resp.Header.Get("Retry-After"))
+	default:
+		return nil, fmt.Errorf("%s returned %s: %s", operation, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	// The response is base64 with optional line breaks (RFC 2045).
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(respBody)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %v", operation, err)
+	}
+	return parsePKCS7Certs(der)
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawSubject, c.RawIssuer) && c.CheckSignatureFrom(c) == nil
+}
+
+func sharesKey(c *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, o := range certs {
+		if bytes.Equal(c.RawSubjectPublicKeyInfo, o.RawSubjectPublicKeyInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+func publicKeyEqual(a, b any) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+func encodeCerts(certs []*x509.Certificate) []byte {
+	var out []byte
+	for _, c := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return out
+}
@@ -0,0 +1,566 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/test"
+	"istio.io/istio/pkg/test/util/assert"
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+// testCA is a CA certificate and its key.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+func newTestCA(t test.Failer, org string, parent *testCA) *testCA {
+	opts := pkiutil.CertOptions{
+		Org:          org,
+		TTL:          time.Hour,
+		IsCA:         true,
+		IsSelfSigned: parent == nil,
+		ECSigAlg:     pkiutil.EcdsaSigAlg,
+	}
+	if parent != nil {
+		opts.SignerCert = parent.cert
+		opts.SignerPriv = parent.key
+	}
+	certPem, keyPem, err := pkiutil.GenCertKeyFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := pkiutil.ParsePemEncodedCertificate(certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := pkiutil.ParsePemEncodedKey(keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// linkCert returns the certificate of ca, re-signed by signer, as used during EST root rollover.
+func linkCert(t test.Failer, ca, signer *testCA) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, ca.cert, signer.cert, ca.cert.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// estStub is an in-process EST server.
+type estStub struct {
+	t test.Failer
+
+	mu sync.Mutex
+	// signer issues the enrolled certificates, and chain is returned with them.
+	signer *testCA
+	chain  []*x509.Certificate
+	// caCerts are returned by /cacerts.
+	caCerts  []*x509.Certificate
+	pending  bool
+	enrolled int
+
+	// clientCA authenticates clients with a certificate, username and password with basic auth.
+	clientCA *testCA
+	username string
+	password string
+
+	server *httptest.Server
+}
+
+func newESTStub(t test.Failer, root *testCA, intermediates ...*testCA) *estStub {
+	s := &estStub{t: t, clientCA: newTestCA(t, "client-ca", nil), username: "istiod", password: "secret"}
+	s.setCA(root, intermediates...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/est/cacerts", s.handleCACerts)
+	mux.HandleFunc("/.well-known/est/simpleenroll", s.handleEnroll)
+	mux.HandleFunc("/.well-known/est/label/simpleenroll", s.handleEnroll)
+	mux.HandleFunc("/.well-known/est/label/cacerts", s.handleCACerts)
+	s.server = httptest.NewUnstartedServer(mux)
+	pool := x509.NewCertPool()
+	pool.AddCert(s.clientCA.cert)
+	s.server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	s.server.StartTLS()
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// setCA switches the CA issuing certificates, and the CA certificates served by the stub.
+func (s *estStub) setCA(root *testCA, intermediates ...*testCA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signer = root
+	s.chain = nil
+	s.caCerts = []*x509.Certificate{root.cert}
+	if len(intermediates) > 0 {
+		s.signer = intermediates[len(intermediates)-1]
+		for i := len(intermediates) - 1; i >= 0; i-- {
+			s.chain = append(s.chain, intermediates[i].cert)
+		}
+		s.caCerts = append(s.chain, root.cert)
+	}
+}
+
+func (s *estStub) setCACerts(certs ...*x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caCerts = certs
+}
+
+func (s *estStub) handleCACerts(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeCerts(w, s.caCerts)
+}
+
+func (s *estStub) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/pkcs10" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	user, password, ok := r.BasicAuth()
+	authenticated := len(r.TLS.PeerCertificates) > 0 || (ok && user == s.username && password == s.password)
+	if !authenticated {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := pkiutil.ExtractIDs(csr.Extensions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	leafDer, err := pkiutil.GenCertFromCSR(csr, s.signer.cert, csr.PublicKey, s.signer.key, ids, time.Hour, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	leaf, _ := x509.ParseCertificate(leafDer)
+	s.enrolled++
+	// Return the chain before the leaf, to check the RA does not depend on the order.
+	s.writeCerts(w, append(append([]*x509.Certificate{}, s.chain...), leaf))
+}
+
+func (s *estStub) writeCerts(w http.ResponseWriter, certs []*x509.Certificate) {
+	der, err := encodePKCS7Certs(certs)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/pkcs7-mime")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	// Wrap lines as RFC 2045 does.
+	enc := base64.StdEncoding.EncodeToString(der)
+	for len(enc) > 64 {
+		_, _ = w.Write([]byte(enc[:64] + "\r\n"))
+		enc = enc[64:]
+	}
+	_, _ = w.Write([]byte(enc))
+}
+
+// options returns the options to reach the stub, without credentials.
+func (s *estStub) options(t *testing.T) ESTOptions {
+	dir := t.TempDir()
+	serverCA := filepath.Join(dir, "server-ca.pem")
+	writeFile(t, serverCA, encodeCerts([]*x509.Certificate{s.server.Certificate()}))
+	return ESTOptions{
+		ServerURL:        s.server.URL + "/.well-known/est",
+		ServerCACertFile: serverCA,
+	}
+}
+
+// clientCert writes a client certificate issued by the client CA, and returns the cert and key files.
+func (s *estStub) clientCert(t *testing.T) (string, string) {
+	certPem, keyPem, err := pkiutil.GenCertKeyFromOptions(pkiutil.CertOptions{
+		Host:       "istiod.istio-system.svc",
+		TTL:        time.Hour,
+		SignerCert: s.clientCA.cert,
+		SignerPriv: s.clientCA.key,
+		IsClient:   true,
+		ECSigAlg:   pkiutil.EcdsaSigAlg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, certPem)
+	writeFile(t, keyFile, keyPem)
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestESTRA(t *testing.T, opts ESTOptions) (*ESTRA, error) {
+	r, err := NewESTRA(&IstioRAOptions{
+		ExternalCAType: ExtCAEST,
+		DefaultCertTTL: time.Hour,
+		MaxCertTTL:     24 * time.Hour,
+		EST:            opts,
+	})
+	if r != nil {
+		t.Cleanup(r.client.CloseIdleConnections)
+	}
+	return r, err
+}
+
+// createESTCsr returns a CSR for testCsrHostName which passes ValidateCSR: no subject, and the
+// identity as the only SAN.
+func createESTCsr(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, err := pkiutil.BuildSubjectAltNameExtension(testCsrHostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{*san}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func testCertOpts() ca.CertOpts {
+	return ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+}
+
+func TestPKCS7(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	der, err := encodePKCS7Certs([]*x509.Certificate{intermediate.cert, root.cert})
+	assert.NoError(t, err)
+	certs, err := parsePKCS7Certs(der)
+	assert.NoError(t, err)
+	assert.Equal(t, len(certs), 2)
+	assert.Equal(t, certs[0].Raw, intermediate.cert.Raw)
+	assert.Equal(t, certs[1].Raw, root.cert.Raw)
+
+	for name, input := range map[string][]byte{
+		"empty":       nil,
+		"certificate": root.cert.Raw,
+		"trailing":    append(append([]byte{}, der...), 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parsePKCS7Certs(input); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	if _, err := encodePKCS7Certs(nil); err != nil {
+		t.Fatal(err)
+	}
+	noCerts, _ := encodePKCS7Certs(nil)
+	if _, err := parsePKCS7Certs(noCerts); err == nil {
+		t.Fatal("expected error for no certificates")
+	}
+}
+
+func TestESTSign(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	stub := newESTStub(t, root, intermediate)
+	certFile, keyFile := stub.clientCert(t)
+	dir := t.TempDir()
+	passwordFile, badPasswordFile := filepath.Join(dir, "password"), filepath.Join(dir, "bad-password")
+	writeFile(t, passwordFile, []byte("secret\n"))
+	writeFile(t, badPasswordFile, []byte("wrong"))
+
+	cases := []struct {
+		name    string
+		opts    func(o *ESTOptions)
+		certOpt func(o *ca.CertOpts)
+		wantErr raerror.ErrType
+	}{
+		{
+			name: "client certificate",
+			opts: func(o *ESTOptions) {
+				o.ClientCertFile, o.ClientKeyFile = certFile, keyFile
+			},
+		},
+		{
+			name: "basic auth",
+			opts: func(o *ESTOptions) {
+				o.Username, o.PasswordFile = "istiod", passwordFile
+			},
+		},
+		{
+			name: "bad password",
+			opts: func(o *ESTOptions) {
+				o.Username, o.PasswordFile = "istiod", badPasswordFile
+			},
+			wantErr: raerror.CertGenError,
+		},
+		{
+			name:    "no credentials",
+			wantErr: raerror.CertGenError,
+		},
+		{
+			name: "identity not in CSR",
+			opts: func(o *ESTOptions) {
+				o.ClientCertFile, o.ClientKeyFile = certFile, keyFile
+			},
+			certOpt: func(o *ca.CertOpts) {
+				o.SubjectIDs = []string{"spiffe://cluster.local/ns/other/sa/other"}
+			},
+			wantErr: raerror.CSRError,
+		},
+		{
+			name: "TTL too long",
+			opts: func(o *ESTOptions) {
+				o.ClientCertFile, o.ClientKeyFile = certFile, keyFile
+			},
+			certOpt: func(o *ca.CertOpts) {
+				o.TTL = 48 * time.Hour
+			},
+			wantErr: raerror.TTLError,
+		},
+		{
+			name: "unknown signer",
+			opts: func(o *ESTOptions) {
+				o.ClientCertFile, o.ClientKeyFile = certFile, keyFile
+			},
+			certOpt: func(o *ca.CertOpts) {
+				o.CertSigner = "other"
+			},
+			wantErr: raerror.CertGenError,
+		},
+		{
+			name: "label",
+			opts: func(o *ESTOptions) {
+				o.ClientCertFile, o.ClientKeyFile = certFile, keyFile
+				o.Label = "label"
+			},
+			certOpt: func(o *ca.CertOpts) {
+				o.CertSigner = "label"
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := stub.options(t)
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			r, err := newTestESTRA(t, opts)
+			assert.NoError(t, err)
+			assert.Equal(t, r.GetCAKeyCertBundle().GetRootCertPem(), encodeCerts([]*x509.Certificate{root.cert}))
+			assert.Equal(t, r.GetCAKeyCertBundle().GetCertChainPem(), encodeCerts([]*x509.Certificate{intermediate.cert}))
+
+			certOpts := testCertOpts()
+			if tt.certOpt != nil {
+				tt.certOpt(&certOpts)
+			}
+			csrPEM := createESTCsr(t)
+			cert, err := r.Sign(csrPEM, certOpts)
+			if tt.wantErr != 0 || err != nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				assert.Equal(t, err.(*raerror.Error).ErrorType(), raerror.NewError(tt.wantErr, nil).ErrorType())
+				return
+			}
+			chain := append(cert, r.GetCAKeyCertBundle().GetCertChainPem()...)
+			assert.NoError(t, pkiutil.VerifyCertificate(nil, chain, r.GetCAKeyCertBundle().GetRootCertPem(), nil))
+			assert.Equal(t, strings.Count(string(cert), "BEGIN CERTIFICATE"), 1)
+		})
+	}
+}
+
+func TestESTSignWithCertChain(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	stub := newESTStub(t, root, intermediate)
+	opts := stub.options(t)
+	opts.ClientCertFile, opts.ClientKeyFile = stub.clientCert(t)
+	r, err := newTestESTRA(t, opts)
+	assert.NoError(t, err)
+
+	chain, err := r.SignWithCertChain(createESTCsr(t), testCertOpts())
+	assert.NoError(t, err)
+	assert.Equal(t, len(chain), 2)
+	assert.Equal(t, strings.Count(chain[0], "BEGIN CERTIFICATE"), 2)
+	assert.Equal(t, chain[1], string(encodeCerts([]*x509.Certificate{root.cert})))
+	assert.NoError(t, pkiutil.VerifyCertificate(nil, []byte(chain[0]), []byte(chain[1]), nil))
+}
+
+func TestESTPending(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	stub := newESTStub(t, root)
+	opts := stub.options(t)
+	opts.ClientCertFile, opts.ClientKeyFile = stub.clientCert(t)
+	r, err := newTestESTRA(t, opts)
+	assert.NoError(t, err)
+
+	stub.mu.Lock()
+	stub.pending = true
+	stub.mu.Unlock()
+	_, err = r.Sign(createESTCsr(t), testCertOpts())
+	if err == nil || !strings.Contains(err.Error(), "pending") {
+		t.Fatalf("expected pending error, got %v", err)
+	}
+}
+
+func TestESTRootRotation(t *testing.T) {
+	oldRoot := newTestCA(t, "old-root", nil)
+	newRoot := newTestCA(t, "new-root", nil)
+	stub := newESTStub(t, oldRoot)
+	opts := stub.options(t)
+	opts.ClientCertFile, opts.ClientKeyFile = stub.clientCert(t)
+	r, err := newTestESTRA(t, opts)
+	assert.NoError(t, err)
+	changes := 0
+	r.AddRootCertsHandler(func() {
+		changes++
+	})
+
+	// No change.
+	assert.NoError(t, r.RefreshCACertificates())
+	assert.Equal(t, changes, 0)
+
+	// Rollover: both roots are trusted, the link certificates are ignored.
+	stub.setCACerts(oldRoot.cert, newRoot.cert, linkCert(t, newRoot, oldRoot), linkCert(t, oldRoot, newRoot))
+	assert.NoError(t, r.RefreshCACertificates())
+	assert.Equal(t, changes, 1)
+	assert.Equal(t, r.GetCAKeyCertBundle().GetRootCertPem(), encodeCerts([]*x509.Certificate{oldRoot.cert, newRoot.cert}))
+	assert.Equal(t, len(r.GetCAKeyCertBundle().GetCertChainPem()), 0)
+
+	// The EST server switches to the new root before the next refresh: the RA refreshes when the
+	// issued certificate does not verify.
+	stub.setCA(oldRoot)
+	assert.NoError(t, r.RefreshCACertificates())
+	assert.Equal(t, changes, 2)
+	stub.setCA(newRoot)
+	_, err = r.Sign(createESTCsr(t), testCertOpts())
+	assert.NoError(t, err)
+	assert.Equal(t, changes, 3)
+	assert.Equal(t, r.GetCAKeyCertBundle().GetRootCertPem(), encodeCerts([]*x509.Certificate{newRoot.cert}))
+
+	// A response with no roots is rejected, and the previous roots are kept.
+	intermediate := newTestCA(t, "intermediate", newRoot)
+	stub.setCACerts(intermediate.cert)
+	assert.Error(t, r.RefreshCACertificates())
+	assert.Equal(t, r.GetCAKeyCertBundle().GetRootCertPem(), encodeCerts([]*x509.Certificate{newRoot.cert}))
+}
+
+func TestESTUntrustedCertificate(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	stub := newESTStub(t, root)
+	opts := stub.options(t)
+	opts.ClientCertFile, opts.ClientKeyFile = stub.clientCert(t)
+	r, err := newTestESTRA(t, opts)
+	assert.NoError(t, err)
+
+	// The server issues from a CA it does not advertise in /cacerts.
+	other := newTestCA(t, "other", nil)
+	stub.mu.Lock()
+	stub.signer = other
+	stub.mu.Unlock()
+	_, err = r.Sign(createESTCsr(t), testCertOpts())
+	if err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Fatalf("expected untrusted error, got %v", err)
+	}
+}
+
+func TestNewESTRAErrors(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	stub := newESTStub(t, root)
+	cases := []struct {
+		name string
+		opts func(o *ESTOptions)
+	}{
+		{
+			name: "no URL",
+			opts: func(o *ESTOptions) { o.ServerURL = "" },
+		},
+		{
+			name: "client cert without key",
+			opts: func(o *ESTOptions) { o.ClientCertFile = "cert.pem" },
+		},
+		{
+			name: "missing server CA",
+			opts: func(o *ESTOptions) { o.ServerCACertFile = "missing.pem" },
+		},
+		{
+			name: "untrusted server",
+			opts: func(o *ESTOptions) {
+				writeFile(t, o.ServerCACertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}))
+			},
+		},
+		{
+			name: "not found",
+			opts: func(o *ESTOptions) { o.ServerURL = stub.server.URL + "/missing" },
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := stub.options(t)
+			tt.opts(&opts)
+			if _, err := newTestESTRA(t, opts); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -17,8 +17,6 @@ package ra
 import (
 	"bytes"
 	"fmt"
-	"strings"
-	"sync"
 	"time"
 
 	cert "k8s.io/api/certificates/v1"
@@ -41,17 +39,12 @@ type KubernetesRA struct {
 
 	raOpts *IstioRAOptions
 
-	// Key is the signer name
-	// Value is Root CAs (PEM list)
-	caCertificatesFromMeshConfig map[string]string
+	caCertificatesFromMeshConfig meshConfigCACertificates
 
 	// certSignerDomain is based on CERT_SIGNER_DOMAIN env variable
 	// it is concatenanted with CertSigner metadata from the request to get the key for
 	// the root certificates in caCertificatesFromMeshConfig
 	certSignerDomain string
-
-	// mutex protects the R/W to caCertificatesFromMeshConfig.
-	mutex sync.RWMutex
 }
 
 var pkiRaLog = log.RegisterScope("pkira", "Istiod RA log")
@@ -62,8 +55,7 @@ func NewKubernetesRA(raOpts *IstioRAOptions) (*KubernetesRA, error) {
 		csrInterface: raOpts.K8sClient,
 		raOpts:       raOpts,
 		// CertSignerDomain is based on CERT_SIGNER_DOMAIN env variable
-		certSignerDomain:             raOpts.CertSignerDomain,
-		caCertificatesFromMeshConfig: make(map[string]string),
+		certSignerDomain: raOpts.CertSignerDomain,
 	}
 	return istioRA, nil
 }
@@ -191,38 +183,9 @@ func (r *KubernetesRA) SetCACertificatesFromFile(roots string) error {
 }
 
 func (r *KubernetesRA) SetCACertificatesFromMeshConfig(caCertificates []*meshconfig.MeshConfig_CertificateData) {
-	r.mutex.Lock()
-	for _, pemCert := range caCertificates {
-		// TODO:  take care of spiffe bundle format as well
-		cert := pemCert.GetPem()
-		certSigners := pemCert.CertSigners
-		if len(certSigners) != 0 {
-			certSigner := strings.Join(certSigners, ",")
-			if cert != "" {
-				r.caCertificatesFromMeshConfig[certSigner] = cert
-			}
-		}
-	}
-	r.mutex.Unlock()
+	r.caCertificatesFromMeshConfig.set(caCertificates)
 }
 
 func (r *KubernetesRA) GetRootCertFromMeshConfig(signerName string) ([]byte, error) {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-	caCertificates := r.caCertificatesFromMeshConfig
-	if len(caCertificates) == 0 {
-		return nil, fmt.Errorf("no caCertificates defined in mesh config")
-	}
-	for signers, caCertificate := range caCertificates {
-		signerList := strings.Split(signers, ",")
-		if len(signerList) == 0 {
-			continue
-		}
-		for _, signer := range signerList {
-			if signer == signerName {
-				return []byte(caCertificate), nil
-			}
-		}
-	}
-	return nil, fmt.Errorf("failed to find root cert for signer: %v in mesh config", signerName)
+	return r.caCertificatesFromMeshConfig.get(signerName)
 }
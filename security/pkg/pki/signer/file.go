@@ -0,0 +1,235 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// blockTypeEncryptedPrivateKey is the PEM block type of PKCS#8 encrypted private keys.
+	blockTypeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	// pbkdf2Iterations is the number of PBKDF2 iterations used to encrypt private keys.
+	pbkdf2Iterations = 600000
+	// passphraseSourceParam is the query parameter of the file URIs naming the file holding the passphrase.
+	passphraseSourceParam = "passphrase-source"
+)
+
+var (
+	oidPBES2             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	aesKeySizes          = map[string]int{oidAES128CBC.String(): 16, oidAES192CBC.String(): 24, oidAES256CBC.String(): 32}
+	pbkdf2PseudoRandFunc = map[string]func() hash.Hash{
+		oidHMACWithSHA1.String():   sha1.New,
+		oidHMACWithSHA256.String(): sha256.New,
+		oidHMACWithSHA384.String(): sha512.New384,
+		oidHMACWithSHA512.String(): sha512.New,
+	}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo (RFC 5958).
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params are the PBES2 parameters (RFC 8018).
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PBKDF2 parameters (RFC 8018). The pseudo random function defaults to HMAC-SHA1.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// newFileSigner loads the encrypted private key of a file URI.
+func newFileSigner(uri string) (crypto.Signer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key URI: %v", err)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("invalid signing key URI %q: missing path", uri)
+	}
+	passphraseFile := u.Query().Get(passphraseSourceParam)
+	if passphraseFile == "" {
+		return nil, fmt.Errorf("invalid signing key URI %q: missing %s", uri, passphraseSourceParam)
+	}
+	passphrase, err := readSecretFile(passphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the passphrase: %v", err)
+	}
+	keyPEM, err := os.ReadFile(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the private key: %v", err)
+	}
+	signer, err := DecryptPKCS8PrivateKey(keyPEM, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the private key %s: %v", u.Path, err)
+	}
+	signerLog.Infof("Loaded the encrypted CA signing key from %s", u.Path)
+	return signer, nil
+}
+
+// DecryptPKCS8PrivateKey decrypts a PEM encoded PKCS#8 private key encrypted with PBES2, using PBKDF2
+// and AES-CBC.
+func DecryptPKCS8PrivateKey(keyPEM []byte, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM encoded private key")
+	}
+	if block.Type != blockTypeEncryptedPrivateKey {
+		return nil, fmt.Errorf("the private key is not encrypted (PEM block %q)", block.Type)
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key: %v", err)
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption algorithm %v, only PBES2 is supported", info.EncryptionAlgorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %v, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+	prf := sha1.New
+	if len(kdf.PRF.Algorithm) > 0 {
+		var f bool
+		if prf, f = pbkdf2PseudoRandFunc[kdf.PRF.Algorithm.String()]; !f {
+			return nil, fmt.Errorf("unsupported PBKDF2 pseudo random function %v", kdf.PRF.Algorithm)
+		}
+	}
+	keySize, f := aesKeySizes[params.EncryptionScheme.Algorithm.String()]
+	if !f {
+		return nil, fmt.Errorf("unsupported encryption scheme %v, only AES-CBC is supported", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid AES-CBC initialization vector")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted data length %d", len(info.EncryptedData))
+	}
+
+	c, err := aes.NewCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keySize, prf))
+	if err != nil {
+		return nil, err
+	}
+	der := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(der, info.EncryptedData)
+	// Remove the PKCS#7 padding. An invalid padding most likely means an incorrect passphrase.
+	pad := int(der[len(der)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(der[len(der)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("incorrect passphrase")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der[:len(der)-pad])
+	if err != nil {
+		return nil, fmt.Errorf("incorrect passphrase or invalid private key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// EncryptPKCS8PrivateKey encrypts a private key with a passphrase, and returns it PEM encoded in the
+// PKCS#8 format, using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC.
+func EncryptPKCS8PrivateKey(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(encrypted, plain)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockTypeEncryptedPrivateKey, Bytes: encoded}), nil
+}
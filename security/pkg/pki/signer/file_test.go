@@ -0,0 +1,132 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecryptPKCS8PrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecKey, "rsa": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			encrypted, err := EncryptPKCS8PrivateKey(key, []byte("passphrase"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(encrypted); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+				t.Fatalf("expected an encrypted key, got %s", encrypted)
+			}
+			signer, err := DecryptPKCS8PrivateKey(encrypted, []byte("passphrase"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+				t.Fatal("decrypted key does not match the original key")
+			}
+			if _, err := DecryptPKCS8PrivateKey(encrypted, []byte("wrong")); err == nil {
+				t.Fatal("expected decryption with the wrong passphrase to fail")
+			}
+		})
+	}
+}
+
+func TestDecryptOpenSSLPrivateKey(t *testing.T) {
+	// Created with `openssl pkcs8 -topk8 -v2 aes-256-cbc -passout pass:istio-test`.
+	encrypted, err := os.ReadFile("testdata/ec-key.enc.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := DecryptPKCS8PrivateKey(encrypted, []byte("istio-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM, err := os.ReadFile("testdata/ec-pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pubPEM)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(signer.Public()) {
+		t.Fatal("decrypted key does not match the public key")
+	}
+}
+
+func TestDecryptUnencryptedPrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptPKCS8PrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), []byte("passphrase"))
+	if err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Fatalf("expected unencrypted key error, got %v", err)
+	}
+}
+
+func TestNewFileSigner(t *testing.T) {
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("istio-test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := filepath.Abs("testdata/ec-key.enc.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := New("file://" + keyFile + "?passphrase-source=" + passphraseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		t.Fatalf("unexpected public key %T", signer.Public())
+	}
+
+	for _, uri := range []string{
+		"file://" + keyFile,
+		"file://" + keyFile + "?passphrase-source=" + filepath.Join(dir, "missing"),
+		"file://" + filepath.Join(dir, "missing") + "?passphrase-source=" + passphraseFile,
+		"kms://key",
+		"key.pem",
+	} {
+		if _, err := New(uri); err == nil {
+			t.Errorf("New(%q) expected to fail", uri)
+		}
+	}
+}
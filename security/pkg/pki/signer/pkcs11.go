@@ -0,0 +1,304 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+// pkcs11Hashes are the PKCS#11 mechanisms and MGFs of the hash functions, and their DigestInfo prefix
+// for PKCS#1 v1.5 signatures (RFC 8017).
+var pkcs11Hashes = map[crypto.Hash]struct {
+	mechanism  uint
+	mgf        uint
+	digestInfo []byte
+}{
+	crypto.SHA256: {
+		pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256,
+		[]byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	},
+	crypto.SHA384: {
+		pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384,
+		[]byte{0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	},
+	crypto.SHA512: {
+		pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512,
+		[]byte{0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+	},
+}
+
+// PKCS11Signer is a crypto.Signer of a private key in a PKCS#11 token. The key never leaves the token.
+type PKCS11Signer struct {
+	ctx     *pkcs11.Ctx
+	key     pkcs11.ObjectHandle
+	public  crypto.PublicKey
+	keyType uint
+
+	// mutex serializes the operations on the session, which is not thread safe.
+	mutex   sync.Mutex
+	session pkcs11.SessionHandle
+}
+
+var _ crypto.Signer = &PKCS11Signer{}
+
+// newPKCS11Signer opens a session to the token and finds the private key and its public key.
+func newPKCS11Signer(u *pkcs11URI) (_ crypto.Signer, err error) {
+	ctx := pkcs11.New(u.modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load the PKCS#11 module %s", u.modulePath)
+	}
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize the PKCS#11 module %s: %v", u.modulePath, err)
+	}
+	s := &PKCS11Signer{ctx: ctx}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	slot, err := findPKCS11Slot(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if s.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		return nil, fmt.Errorf("failed to open a PKCS#11 session: %v", err)
+	}
+	if u.pin != "" {
+		if err := ctx.Login(s.session, pkcs11.CKU_USER, u.pin); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			return nil, fmt.Errorf("failed to log in the PKCS#11 token: %v", err)
+		}
+	}
+	if s.key, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, u); err != nil {
+		return nil, err
+	}
+	pub, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, u)
+	if err != nil {
+		return nil, err
+	}
+	if s.public, s.keyType, err = s.publicKey(pub); err != nil {
+		return nil, err
+	}
+	signerLog.Infof("Loaded the CA signing key %q from the PKCS#11 token on slot %d", u.object, slot)
+	return s, nil
+}
+
+// findPKCS11Slot returns the slot of the token with the label or slot ID of the URI.
+func findPKCS11Slot(ctx *pkcs11.Ctx, u *pkcs11URI) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the PKCS#11 slots: %v", err)
+	}
+	for _, slot := range slots {
+		if u.slotID >= 0 && slot != uint(u.slotID) {
+			continue
+		}
+		if u.token != "" {
+			info, err := ctx.GetTokenInfo(slot)
+			if err != nil || info.Label != u.token {
+				continue
+			}
+		}
+		return slot, nil
+	}
+	return 0, fmt.Errorf("PKCS#11 token %q not found", u.token)
+}
+
+// findObject returns the single object of the class with the label and ID of the URI.
+func (s *PKCS11Signer) findObject(class uint, u *pkcs11URI) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if u.object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.object))
+	}
+	if len(u.id) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.id))
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, fmt.Errorf("failed to find the PKCS#11 key: %v", err)
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the PKCS#11 key: %v", err)
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("PKCS#11 %s key (object %q, id %x) not found", kind, u.object, u.id)
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, fmt.Errorf("multiple PKCS#11 %s keys match object %q and id %x", kind, u.object, u.id)
+	}
+}
+
+// publicKey reads the public key object.
+func (s *PKCS11Signer) publicKey(o pkcs11.ObjectHandle) (crypto.PublicKey, uint, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, o, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil || len(attrs) != 1 {
+		return nil, 0, fmt.Errorf("failed to read the PKCS#11 key type: %v", err)
+	}
+	keyType := nativeUlong(attrs[0].Value)
+	switch keyType {
+	case pkcs11.CKK_EC:
+		attrs, err := s.ctx.GetAttributeValue(s.session, o, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil || len(attrs) != 2 {
+			return nil, 0, fmt.Errorf("failed to read the PKCS#11 EC public key: %v", err)
+		}
+		// The point is a DER encoded OCTET STRING, but some modules return it raw.
+		point := attrs[1].Value
+		var p []byte
+		if rest, err := asn1.Unmarshal(point, &p); err == nil && len(rest) == 0 {
+			point = p
+		}
+		// Parsing the SubjectPublicKeyInfo validates the curve and the point.
+		spki, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: attrs[0].Value}},
+			PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		pub, err := x509.ParsePKIXPublicKey(spki)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid PKCS#11 EC public key: %v", err)
+		}
+		return pub, keyType, nil
+	case pkcs11.CKK_RSA:
+		attrs, err := s.ctx.GetAttributeValue(s.session, o, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil || len(attrs) != 2 {
+			return nil, 0, fmt.Errorf("failed to read the PKCS#11 RSA public key: %v", err)
+		}
+		e := new(big.Int).SetBytes(attrs[1].Value)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, 0, fmt.Errorf("invalid PKCS#11 RSA public exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(e.Int64())}, keyType, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported PKCS#11 key type %d, only EC and RSA keys are supported", keyType)
+	}
+}
+
+// Public returns the public key of the private key in the token.
+func (s *PKCS11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest with the private key in the token.
+func (s *PKCS11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	data := digest
+	switch s.keyType {
+	case pkcs11.CKK_EC:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case pkcs11.CKK_RSA:
+		h, f := pkcs11Hashes[opts.HashFunc()]
+		if !f {
+			return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+		}
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = opts.HashFunc().Size()
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(h.mechanism, h.mgf, uint(saltLength)))
+		} else {
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = append(append([]byte{}, h.digestInfo...), digest...)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign failed: %v", err)
+	}
+	signature, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign failed: %v", err)
+	}
+	if s.keyType != pkcs11.CKK_EC {
+		return signature, nil
+	}
+	// PKCS#11 ECDSA signatures are the concatenation of r and s, while Go uses ASN.1.
+	if len(signature) == 0 || len(signature)%2 != 0 {
+		return nil, fmt.Errorf("invalid PKCS#11 ECDSA signature length %d", len(signature))
+	}
+	n := len(signature) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{new(big.Int).SetBytes(signature[:n]), new(big.Int).SetBytes(signature[n:])})
+}
+
+// Close closes the session to the token and unloads the module.
+func (s *PKCS11Signer) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+		s.session = 0
+	}
+	_ = s.ctx.Finalize()
+	s.ctx.Destroy()
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
+
+// nativeUlong decodes a CK_ULONG attribute, in the native byte order and size.
+func nativeUlong(b []byte) uint {
+	switch len(b) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	default:
+		return ^uint(0)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// newPKCS11Signer fails, as loading PKCS#11 modules requires cgo, which the release builds don't enable.
+func newPKCS11Signer(*pkcs11URI) (crypto.Signer, error) {
+	return nil, fmt.Errorf("PKCS#11 keys are not supported by this binary, which is built without cgo as the Istio " +
+		"releases are: build it with CGO_ENABLED=1 STATIC=0 and run it in an image with the C library, or use an " +
+		"encrypted key file (file:///path/to/key.pem?passphrase-source=...) instead")
+}
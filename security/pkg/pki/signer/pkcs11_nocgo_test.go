@@ -0,0 +1,29 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+
+package signer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPKCS11WithoutCgo(t *testing.T) {
+	_, err := New("pkcs11:token=istio;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234")
+	if err == nil || !strings.Contains(err.Error(), "CGO_ENABLED=1") {
+		t.Fatalf("expected an error explaining how to build with cgo, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	testTokenLabel = "istio"
+	testSOPin      = "5678"
+	testUserPin    = "1234"
+)
+
+// softHSMModule returns the path of the SoftHSM module, set with SOFTHSM2_MODULE or installed in a common location.
+func softHSMModule(t *testing.T) string {
+	candidates := []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+	}
+	for _, m := range candidates {
+		if m == "" {
+			continue
+		}
+		if _, err := os.Stat(m); err == nil {
+			return m
+		}
+	}
+	t.Skip("SoftHSM is not installed, set SOFTHSM2_MODULE to the path of libsofthsm2.so")
+	return ""
+}
+
+// initSoftHSMToken initializes a SoftHSM token in a temporary directory, with an ECDSA P-256 key labeled
+// "ec-key" and a RSA 2048 key labeled "rsa-key".
+func initSoftHSMToken(t *testing.T, module string) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.MkdirAll(filepath.Join(dir, "tokens"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\n", filepath.Join(dir, "tokens"))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("failed to load %s", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ctx.Finalize()
+		ctx.Destroy()
+	}()
+	slots, err := ctx.GetSlotList(false)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no SoftHSM slot: %v", err)
+	}
+	if err := ctx.InitToken(slots[0], testSOPin, testTokenLabel); err != nil {
+		t.Fatal(err)
+	}
+	// SoftHSM moves the initialized token to a new slot.
+	slot, err := findPKCS11Slot(ctx, &pkcs11URI{token: testTokenLabel, slotID: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctx.CloseSession(session) }()
+	if err := ctx.Login(session, pkcs11.CKU_SO, testSOPin); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.InitPIN(session, testUserPin); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Logout(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, testUserPin); err != nil {
+		t.Fatal(err)
+	}
+
+	keyAttributes := func(label string, class uint, attrs ...*pkcs11.Attribute) []*pkcs11.Attribute {
+		return append([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		}, attrs...)
+	}
+	// DER encoding of the P-256 curve OID.
+	p256 := []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	if _, _, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		keyAttributes("ec-key", pkcs11.CKO_PUBLIC_KEY, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true)),
+		keyAttributes("ec-key", pkcs11.CKO_PRIVATE_KEY, pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true), pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true))); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		keyAttributes("rsa-key", pkcs11.CKO_PUBLIC_KEY, pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}), pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true)),
+		keyAttributes("rsa-key", pkcs11.CKO_PRIVATE_KEY, pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true), pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	module := softHSMModule(t)
+	initSoftHSMToken(t, module)
+
+	cases := []struct {
+		object  string
+		public  crypto.PublicKey
+		sigAlgs []x509.SignatureAlgorithm
+	}{
+		{
+			object:  "ec-key",
+			public:  &ecdsa.PublicKey{},
+			sigAlgs: []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA384},
+		},
+		{
+			object:  "rsa-key",
+			public:  &rsa.PublicKey{},
+			sigAlgs: []x509.SignatureAlgorithm{x509.SHA256WithRSA, x509.SHA512WithRSA, x509.SHA256WithRSAPSS},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.object, func(t *testing.T) {
+			s, err := New(fmt.Sprintf("pkcs11:token=%s;object=%s?module-path=%s&pin-value=%s",
+				testTokenLabel, tc.object, module, testUserPin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.(*PKCS11Signer).Close()
+			if fmt.Sprintf("%T", s.Public()) != fmt.Sprintf("%T", tc.public) {
+				t.Fatalf("unexpected public key %T", s.Public())
+			}
+			for _, sigAlg := range tc.sigAlgs {
+				// A self-signed certificate is verified with the public key of the token.
+				template := &x509.Certificate{
+					SerialNumber:          big.NewInt(1),
+					Subject:               pkix.Name{Organization: []string{"cluster.local"}},
+					NotBefore:             time.Now(),
+					NotAfter:              time.Now().Add(time.Hour),
+					KeyUsage:              x509.KeyUsageCertSign,
+					BasicConstraintsValid: true,
+					IsCA:                  true,
+					SignatureAlgorithm:    sigAlg,
+				}
+				der, err := x509.CreateCertificate(rand.Reader, template, template, s.Public(), s)
+				if err != nil {
+					t.Fatalf("%v: %v", sigAlg, err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cert.CheckSignatureFrom(cert); err != nil {
+					t.Fatalf("%v: %v", sigAlg, err)
+				}
+			}
+		})
+	}
+
+	if _, err := New(fmt.Sprintf("pkcs11:token=%s;object=missing?module-path=%s&pin-value=%s",
+		testTokenLabel, module, testUserPin)); err == nil {
+		t.Fatal("expected a missing key to fail")
+	}
+	if _, err := New(fmt.Sprintf("pkcs11:token=%s;object=ec-key?module-path=%s&pin-value=0000",
+		testTokenLabel, module)); err == nil {
+		t.Fatal("expected an incorrect PIN to fail")
+	}
+}
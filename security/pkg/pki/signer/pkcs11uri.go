@@ -0,0 +1,109 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signer
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pkcs11URI identifies a private key in a PKCS#11 token.
+type pkcs11URI struct {
+	// token is the label of the token.
+	token string
+	// slotID is the slot of the token, or -1 to find the token by label.
+	slotID int
+	// object and id are the label and ID of the key. At least one of them is set.
+	object string
+	id     []byte
+	// modulePath is the path of the PKCS#11 module library.
+	modulePath string
+	// pin is the user PIN, empty if no login is required.
+	pin string
+}
+
+// parsePKCS11URI parses the subset of the PKCS#11 URIs (RFC 7512) used to identify a private key.
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	rest, ok := strings.CutPrefix(uri, PKCS11Scheme+":")
+	if !ok {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	attrs, err := parsePKCS11Attributes(path, ";")
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: %v", uri, err)
+	}
+	queryAttrs, err := parsePKCS11Attributes(query, "&")
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: %v", uri, err)
+	}
+
+	u := &pkcs11URI{
+		token:      attrs["token"],
+		slotID:     -1,
+		object:     attrs["object"],
+		id:         []byte(attrs["id"]),
+		modulePath: queryAttrs["module-path"],
+		pin:        queryAttrs["pin-value"],
+	}
+	if s, f := attrs["slot-id"]; f {
+		if u.slotID, err = strconv.Atoi(s); err != nil || u.slotID < 0 {
+			return nil, fmt.Errorf("invalid PKCS#11 URI %q: invalid slot-id %q", uri, s)
+		}
+	}
+	if u.token == "" && u.slotID < 0 {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: token or slot-id is required", uri)
+	}
+	if u.object == "" && len(u.id) == 0 {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: object or id is required", uri)
+	}
+	if u.modulePath == "" {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: module-path is required", uri)
+	}
+	if pinSource, f := queryAttrs["pin-source"]; f {
+		if u.pin != "" {
+			return nil, fmt.Errorf("invalid PKCS#11 URI %q: either pin-value or pin-source, not both", uri)
+		}
+		if u.pin, err = readSecretFile(pinSource); err != nil {
+			return nil, fmt.Errorf("failed to read the PKCS#11 PIN: %v", err)
+		}
+	}
+	return u, nil
+}
+
+// parsePKCS11Attributes parses the percent-encoded attributes of a PKCS#11 URI.
+func parsePKCS11Attributes(s string, sep string) (map[string]string, error) {
+	attrs := map[string]string{}
+	if s == "" {
+		return attrs, nil
+	}
+	for _, a := range strings.Split(s, sep) {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid attribute %q", a)
+		}
+		if _, f := attrs[k]; f {
+			return nil, fmt.Errorf("duplicate attribute %q", k)
+		}
+		value, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute %q: %v", a, err)
+		}
+		attrs[k] = value
+	}
+	return attrs, nil
+}
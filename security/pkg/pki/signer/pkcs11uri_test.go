@@ -0,0 +1,76 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		uri     string
+		want    *pkcs11URI
+		wantErr bool
+	}{
+		{
+			name: "token and object",
+			uri:  "pkcs11:token=istio;object=ca-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234",
+			want: &pkcs11URI{token: "istio", slotID: -1, object: "ca-key", id: []byte{}, modulePath: "/usr/lib/softhsm/libsofthsm2.so", pin: "1234"},
+		},
+		{
+			name: "slot and percent-encoded id",
+			uri:  "pkcs11:slot-id=2;id=%01%a2?module-path=/lib/p11.so&pin-source=file:" + pinFile,
+			want: &pkcs11URI{slotID: 2, id: []byte{0x01, 0xa2}, modulePath: "/lib/p11.so", pin: "1234"},
+		},
+		{
+			name: "percent-encoded label",
+			uri:  "pkcs11:token=my%20token;object=ca%3Bkey?module-path=/lib/p11.so",
+			want: &pkcs11URI{token: "my token", slotID: -1, object: "ca;key", id: []byte{}, modulePath: "/lib/p11.so"},
+		},
+		{name: "no module", uri: "pkcs11:token=istio;object=ca-key", wantErr: true},
+		{name: "no token", uri: "pkcs11:object=ca-key?module-path=/lib/p11.so", wantErr: true},
+		{name: "no key", uri: "pkcs11:token=istio?module-path=/lib/p11.so", wantErr: true},
+		{name: "invalid slot", uri: "pkcs11:slot-id=x;object=ca-key?module-path=/lib/p11.so", wantErr: true},
+		{name: "duplicate attribute", uri: "pkcs11:token=a;token=b;object=ca-key?module-path=/lib/p11.so", wantErr: true},
+		{name: "both pins", uri: "pkcs11:token=a;object=k?module-path=/lib/p11.so&pin-value=1&pin-source=" + pinFile, wantErr: true},
+		{name: "missing pin file", uri: "pkcs11:token=a;object=k?module-path=/lib/p11.so&pin-source=/nonexistent", wantErr: true},
+		{name: "invalid attribute", uri: "pkcs11:token?module-path=/lib/p11.so", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parsePKCS11URI(tc.uri)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
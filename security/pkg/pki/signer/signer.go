@@ -0,0 +1,70 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signer provides the crypto.Signer of a CA private key which is not stored in a Kubernetes secret:
+// a key in a PKCS#11 token, such as a HSM, or a key file encrypted at rest.
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+)
+
+var signerLog = log.RegisterScope("signer", "CA signing key providers")
+
+const (
+	// PKCS11Scheme is the scheme of the URIs of keys in a PKCS#11 token (RFC 7512).
+	PKCS11Scheme = "pkcs11"
+	// FileScheme is the scheme of the URIs of encrypted key files.
+	FileScheme = "file"
+)
+
+// New returns the crypto.Signer of the private key identified by uri:
+//
+//   - pkcs11:token=<token>;object=<label>?module-path=<module>&pin-source=<file> for a key in a PKCS#11 token
+//     (RFC 7512). The key may also be selected with the id or slot-id attributes, and the PIN set with pin-value.
+//     PKCS#11 modules are loaded with cgo: binaries built with CGO_ENABLED=0, as the Istio releases, don't support them.
+//   - file:///<path>?passphrase-source=<file> for a PKCS#8 key encrypted with PBES2, as created with
+//     `openssl pkcs8 -topk8 -v2 aes-256-cbc`.
+func New(uri string) (crypto.Signer, error) {
+	scheme, _, found := strings.Cut(uri, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid signing key URI %q: missing scheme", uri)
+	}
+	switch scheme {
+	case PKCS11Scheme:
+		u, err := parsePKCS11URI(uri)
+		if err != nil {
+			return nil, err
+		}
+		return newPKCS11Signer(u)
+	case FileScheme:
+		return newFileSigner(uri)
+	default:
+		return nil, fmt.Errorf("unsupported signing key URI scheme %q", scheme)
+	}
+}
+
+// readSecretFile reads a PIN or passphrase from a file, without the trailing new line.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(strings.TrimPrefix(path, "file:"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
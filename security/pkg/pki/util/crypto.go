@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
@@ -150,7 +151,15 @@ func ParsePemEncodedKey(keyBytes []byte) (crypto.PrivateKey, error) {
 		}
 		return key, nil
 	default:
-		return nil, fmt.Errorf("unsupported PEM block type for a private key: %s", kb.Type)
+		scheme := getSignatureSchemeForBlockType(kb.Type)
+		if scheme == nil {
+			return nil, fmt.Errorf("unsupported PEM block type for a private key: %s", kb.Type)
+		}
+		key, err := scheme.ParsePrivateKey(kb.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the %s: %v", kb.Type, err)
+		}
+		return key, nil
 	}
 }
 
@@ -167,9 +176,14 @@ func GetRSAKeySize(privKey crypto.PrivateKey) (int, error) {
 // if ECDSA is used, then only 384 and 256 (default) are returned; if non-ECDSA
 // is used then an error is returned
 func GetEllipticCurve(privKey *crypto.PrivateKey) (elliptic.Curve, error) {
-	switch key := (*privKey).(type) {
+	// The public key also covers private keys held by external signers.
+	var pub crypto.PublicKey
+	if signer, ok := (*privKey).(crypto.Signer); ok {
+		pub = signer.Public()
+	}
+	switch key := pub.(type) {
 	// this should agree with var SupportedECSignatureAlgorithms
-	case *ecdsa.PrivateKey:
+	case *ecdsa.PublicKey:
 		if key.Curve == elliptic.P384() {
 			return key.Curve, nil
 		}
@@ -179,6 +193,28 @@ func GetEllipticCurve(privKey *crypto.PrivateKey) (elliptic.Curve, error) {
 	}
 }
 
+// GetKeyAlgorithm returns the signature algorithm and curve of the CertOptions generating keys of the
+// type of the public key. The signature algorithm is empty for RSA keys.
+func GetKeyAlgorithm(pub crypto.PublicKey) (SupportedECSignatureAlgorithms, SupportedEllipticCurves, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return "", "", nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return EcdsaSigAlg, P384Curve, nil
+		case elliptic.P521():
+			return EcdsaSigAlg, P521Curve, nil
+		default:
+			return EcdsaSigAlg, P256Curve, nil
+		}
+	case ed25519.PublicKey:
+		return Ed25519SigAlg, "", nil
+	default:
+		return "", "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
 // PemCertBytestoString: takes an array of PEM certs in bytes and returns a string array in the same order with
 // trailing newline characters removed
 func PemCertBytestoString(caCerts []byte) []string {
@@ -39,7 +39,7 @@ import (
 )
 
 // SupportedECSignatureAlgorithms are the types of EC Signature Algorithms
-// to be used in key generation (e.g. ECDSA or ED2551)
+// to be used in key generation (e.g. ECDSA or ED25519)
 type SupportedECSignatureAlgorithms string
 
 // SupportedEllipticCurves are the types of curves
@@ -47,12 +47,14 @@ type SupportedECSignatureAlgorithms string
 type SupportedEllipticCurves string
 
 const (
-	// only ECDSA is currently supported
-	EcdsaSigAlg SupportedECSignatureAlgorithms = "ECDSA"
+	// ECDSA and Ed25519 are built in, other algorithms may be added with RegisterSignatureScheme.
+	EcdsaSigAlg   SupportedECSignatureAlgorithms = "ECDSA"
+	Ed25519SigAlg SupportedECSignatureAlgorithms = "ED25519"
 
-	// supported curves when using ECC
+	// supported curves when using ECDSA
 	P256Curve SupportedEllipticCurves = "P256"
 	P384Curve SupportedEllipticCurves = "P384"
+	P521Curve SupportedEllipticCurves = "P521"
 )
 
 // CertOptions contains options for generating a new certificate.
@@ -102,13 +104,12 @@ type CertOptions struct {
 	PKCS8Key bool
 
 	// The type of Elliptical Signature algorithm to use
-	// when generating private keys. Currently only ECDSA is supported.
+	// when generating private keys: ECDSA, ED25519 or a registered SignatureScheme.
 	// If empty, RSA is used, otherwise ECC is used.
 	ECSigAlg SupportedECSignatureAlgorithms
 
-	// The type of Elliptical Signature algorithm to use
-	// when generating private keys. Currently only ECDSA is supported.
-	// If empty, RSA is used, otherwise ECC is used.
+	// The elliptic curve to use when generating ECDSA private keys.
+	// If empty, P256 is used.
 	ECCCurve SupportedEllipticCurves
 
 	// Subjective Alternative Name values.
@@ -122,41 +123,50 @@ func GenCertKeyFromOptions(options CertOptions) (pemCert []byte, pemKey []byte,
 	// private key will be used to sign this certificate in the self-signed
 	// case, otherwise the certificate is signed by the signer private key
 	// as specified in the CertOptions.
-	if options.ECSigAlg != "" {
-		var ecPriv *ecdsa.PrivateKey
-
-		switch options.ECSigAlg {
-		case EcdsaSigAlg:
-			var curve elliptic.Curve
-			switch options.ECCCurve {
-			case P384Curve:
-				curve = elliptic.P384()
-			default:
-				curve = elliptic.P256()
-			}
+	priv, err := genKey(options)
+	if errors.Is(err, errUnsupportedSigAlg) {
+		return nil, nil, errors.New("cert generation fails due to unsupported EC signature algorithm")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("cert generation fails at key generation (%v)", err)
+	}
+	return genCert(options, priv, priv.Public())
+}
 
-			ecPriv, err = ecdsa.GenerateKey(curve, rand.Reader)
-			if err != nil {
-				return nil, nil, fmt.Errorf("cert generation fails at EC key generation (%v)", err)
-			}
+// errUnsupportedSigAlg is returned by genKey for unknown signature algorithms.
+var errUnsupportedSigAlg = errors.New("unsupported signature algorithm")
 
+// genKey generates a private key of the signature algorithm and curve of the options, or a RSA key
+// if no signature algorithm is set.
+func genKey(options CertOptions) (crypto.Signer, error) {
+	switch options.ECSigAlg {
+	case "":
+		if options.RSAKeySize < minimumRsaKeySize {
+			return nil, fmt.Errorf("requested key size does not meet the minimum required size of %d (requested: %d)", minimumRsaKeySize, options.RSAKeySize)
+		}
+		return rsa.GenerateKey(rand.Reader, options.RSAKeySize)
+	case EcdsaSigAlg:
+		var curve elliptic.Curve
+		switch options.ECCCurve {
+		case P384Curve:
+			curve = elliptic.P384()
+		case P521Curve:
+			curve = elliptic.P521()
 		default:
-			return nil, nil, errors.New("cert generation fails due to unsupported EC signature algorithm")
+			curve = elliptic.P256()
 		}
-		return genCert(options, ecPriv, &ecPriv.PublicKey)
-	}
-
-	if options.RSAKeySize < minimumRsaKeySize {
-		return nil, nil, fmt.Errorf("requested key size does not meet the minimum required size of %d (requested: %d)", minimumRsaKeySize, options.RSAKeySize)
-	}
-	rsaPriv, err := rsa.GenerateKey(rand.Reader, options.RSAKeySize)
-	if err != nil {
-		return nil, nil, fmt.Errorf("cert generation fails at RSA key generation (%v)", err)
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case Ed25519SigAlg:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		if scheme := getSignatureScheme(options.ECSigAlg); scheme != nil {
+			return scheme.GenerateKey(options)
+		}
+		return nil, errUnsupportedSigAlg
 	}
-	return genCert(options, rsaPriv, &rsaPriv.PublicKey)
 }
 
-func genCert(options CertOptions, priv any, key any) ([]byte, []byte, error) {
+func genCert(options CertOptions, priv crypto.Signer, key crypto.PublicKey) ([]byte, []byte, error) {
 	template, err := genCertTemplateFromOptions(options)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cert generation fails at cert template creation (%v)", err)
@@ -170,27 +180,16 @@ func genCert(options CertOptions, priv any, key any) ([]byte, []byte, error) {
 		return nil, nil, fmt.Errorf("cert generation fails at X509 cert creation (%v)", err)
 	}
 
-	pemCert, pemKey, err := encodePem(false, certBytes, priv, options.PKCS8Key)
+	pemCert, pemKey, err := encodePem(false, certBytes, priv, options)
 	return pemCert, pemKey, err
 }
 
-func publicKey(priv any) any {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &k.PublicKey
-	case *ecdsa.PrivateKey:
-		return &k.PublicKey
-	case ed25519.PrivateKey:
-		return k.Public().(ed25519.PublicKey)
-	default:
-		return nil
-	}
-}
-
 // GenRootCertFromExistingKey generates a X.509 certificate using existing
 // CA private key. Only called by a self-signed Citadel.
+// The private key is either options.SignerPrivPem, or options.SignerPriv if it is held by an
+// external crypto.Signer, in which case the returned key PEM is empty.
 func GenRootCertFromExistingKey(options CertOptions) (pemCert []byte, pemKey []byte, err error) {
-	if !options.IsSelfSigned || len(options.SignerPrivPem) == 0 {
+	if !options.IsSelfSigned || (len(options.SignerPrivPem) == 0 && options.SignerPriv == nil) {
 		return nil, nil, fmt.Errorf("skip cert " +
 			"generation. Citadel is not in self-signed mode or CA private key is not " +
 			"available")
@@ -200,12 +199,19 @@ func GenRootCertFromExistingKey(options CertOptions) (pemCert []byte, pemKey []b
 	if err != nil {
 		return nil, nil, fmt.Errorf("cert generation fails at cert template creation (%v)", err)
 	}
-	caPrivateKey, err := ParsePemEncodedKey(options.SignerPrivPem)
-	if err != nil {
-		return nil, nil, fmt.Errorf("unrecogniazed CA "+
-			"private key, skip root cert rotation: %s", err.Error())
+	caPrivateKey := options.SignerPriv
+	if len(options.SignerPrivPem) > 0 {
+		caPrivateKey, err = ParsePemEncodedKey(options.SignerPrivPem)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unrecogniazed CA "+
+				"private key, skip root cert rotation: %s", err.Error())
+		}
+	}
+	signer, ok := caPrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported CA private key type %T, skip root cert rotation", caPrivateKey)
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, publicKey(caPrivateKey), caPrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cert generation fails at X509 cert creation (%v)", err)
 	}
@@ -293,11 +299,11 @@ func genCertTemplateFromCSR(csr *x509.CertificateRequest, subjectIDs []string, t
 	var keyUsage x509.KeyUsage
 	extKeyUsages := []x509.ExtKeyUsage{}
 	if isCA {
-		// If the cert is a CA cert, the private key is allowed to sign other certificates.
-		keyUsage = x509.KeyUsageCertSign
+		// If the cert is a CA cert, the private key is allowed to sign other certificates and revocation lists.
+		keyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 	} else {
 		// Otherwise the private key is allowed for digital signature and key encipherment.
-		keyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		keyUsage = leafKeyUsage(csr.PublicKeyAlgorithm == x509.Ed25519)
 		// For now, we do not differentiate non-CA certs to be used on client auth or server auth.
 		extKeyUsages = append(extKeyUsages, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
 	}
@@ -344,15 +350,24 @@ func genCertTemplateFromCSR(csr *x509.CertificateRequest, subjectIDs []string, t
 	}, nil
 }
 
+// leafKeyUsage returns the key usage of a non-CA certificate. Ed25519 keys can only sign, so RFC 8410
+// forbids key encipherment for them.
+func leafKeyUsage(ed25519Key bool) x509.KeyUsage {
+	if ed25519Key {
+		return x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+}
+
 // genCertTemplateFromoptions generates a certificate template with the given options.
 func genCertTemplateFromOptions(options CertOptions) (*x509.Certificate, error) {
 	var keyUsage x509.KeyUsage
 	if options.IsCA {
-		// If the cert is a CA cert, the private key is allowed to sign other certificates.
-		keyUsage = x509.KeyUsageCertSign
+		// If the cert is a CA cert, the private key is allowed to sign other certificates and revocation lists.
+		keyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 	} else {
 		// Otherwise the private key is allowed for digital signature and key encipherment.
-		keyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		keyUsage = leafKeyUsage(options.ECSigAlg == Ed25519SigAlg)
 	}
 
 	extKeyUsages := []x509.ExtKeyUsage{}
@@ -423,7 +438,7 @@ func genSerialNum() (*big.Int, error) {
 	return serialNum, nil
 }
 
-func encodePem(isCSR bool, csrOrCert []byte, priv any, pkcs8 bool) (
+func encodePem(isCSR bool, csrOrCert []byte, priv crypto.Signer, options CertOptions) (
 	csrOrCertPem []byte, privPem []byte, err error,
 ) {
 	encodeMsg := "CERTIFICATE"
@@ -433,7 +448,13 @@ func encodePem(isCSR bool, csrOrCert []byte, priv any, pkcs8 bool) (
 	csrOrCertPem = pem.EncodeToMemory(&pem.Block{Type: encodeMsg, Bytes: csrOrCert})
 
 	var encodedKey []byte
-	if pkcs8 {
+	if scheme := getSignatureScheme(options.ECSigAlg); scheme != nil {
+		// Keys of registered schemes are encoded by the scheme, whether PKCS#8 is requested or not.
+		if encodedKey, err = scheme.MarshalPrivateKey(priv); err != nil {
+			return nil, nil, err
+		}
+		privPem = pem.EncodeToMemory(&pem.Block{Type: scheme.PEMBlockType(), Bytes: encodedKey})
+	} else if options.PKCS8Key {
 		if encodedKey, err = x509.MarshalPKCS8PrivateKey(priv); err != nil {
 			return nil, nil, err
 		}
@@ -449,6 +470,12 @@ func encodePem(isCSR bool, csrOrCert []byte, priv any, pkcs8 bool) (
 				return nil, nil, err
 			}
 			privPem = pem.EncodeToMemory(&pem.Block{Type: blockTypeECPrivateKey, Bytes: encodedKey})
+		case ed25519.PrivateKey:
+			// Ed25519 keys can only be encoded with PKCS#8.
+			if encodedKey, err = x509.MarshalPKCS8PrivateKey(k); err != nil {
+				return nil, nil, err
+			}
+			privPem = pem.EncodeToMemory(&pem.Block{Type: blockTypePKCS8PrivateKey, Bytes: encodedKey})
 		}
 	}
 	err = nil
@@ -19,11 +19,7 @@
 package util
 
 import (
-	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
@@ -40,46 +36,23 @@ const minimumRsaKeySize = 2048
 
 // GenCSR generates a X.509 certificate sign request and private key with the given options.
 func GenCSR(options CertOptions) ([]byte, []byte, error) {
-	var priv any
-	var err error
-	if options.ECSigAlg != "" {
-		switch options.ECSigAlg {
-		case EcdsaSigAlg:
-			var curve elliptic.Curve
-			switch options.ECCCurve {
-			case P384Curve:
-				curve = elliptic.P384()
-			default:
-				curve = elliptic.P256()
-			}
-			priv, err = ecdsa.GenerateKey(curve, rand.Reader)
-			if err != nil {
-				return nil, nil, fmt.Errorf("EC key generation failed (%v)", err)
-			}
-		default:
-			return nil, nil, errors.New("csr cert generation fails due to unsupported EC signature algorithm")
-		}
-	} else {
-		if options.RSAKeySize < minimumRsaKeySize {
-			return nil, nil, fmt.Errorf("requested key size does not meet the minimum required size of %d (requested: %d)", minimumRsaKeySize, options.RSAKeySize)
-		}
-
-		priv, err = rsa.GenerateKey(rand.Reader, options.RSAKeySize)
-		if err != nil {
-			return nil, nil, fmt.Errorf("RSA key generation failed (%v)", err)
-		}
+	priv, err := genKey(options)
+	if errors.Is(err, errUnsupportedSigAlg) {
+		return nil, nil, errors.New("csr cert generation fails due to unsupported EC signature algorithm")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("key generation failed (%v)", err)
 	}
 	template, err := GenCSRTemplate(options)
 	if err != nil {
 		return nil, nil, fmt.Errorf("CSR template creation failed (%v)", err)
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, crypto.PrivateKey(priv))
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
 	if err != nil {
 		return nil, nil, fmt.Errorf("CSR creation failed (%v)", err)
 	}
 
-	csr, privKey, err := encodePem(true, csrBytes, priv, options.PKCS8Key)
+	csr, privKey, err := encodePem(true, csrBytes, priv, options)
 	return csr, privKey, err
 }
 
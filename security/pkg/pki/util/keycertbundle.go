@@ -21,8 +21,8 @@ package util
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -36,6 +36,8 @@ import (
 // The cert and privKey should be a public/private key pair.
 // The cert should be verifiable from the rootCert through the certChain.
 // cert and priveKey are pointers to the cert/key parsed from certBytes/privKeyBytes.
+// The private key may also be held outside of the process by a crypto.Signer (for example in a HSM), in which
+// case privKeyBytes is empty and privKey points to the signer.
 type KeyCertBundle struct {
 	// certBytes holds the leaf certificate - associated with the private key.
 	certBytes    []byte
@@ -84,47 +86,71 @@ func NewVerifiedKeyCertBundleFromPem(certBytes, privKeyBytes, certChainBytes, ro
 	return bundle, nil
 }
 
+// NewVerifiedKeyCertBundleFromSigner returns a new KeyCertBundle using a private key held by an external signer,
+// or error if the provided certs failed the verification.
+func NewVerifiedKeyCertBundleFromSigner(certBytes []byte, signer crypto.Signer, certChainBytes, rootCertBytes []byte) (
+	*KeyCertBundle, error,
+) {
+	bundle := &KeyCertBundle{}
+	if err := bundle.VerifyAndSetAllWithSigner(certBytes, signer, certChainBytes, rootCertBytes); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
 // NewVerifiedKeyCertBundleFromFile returns a new KeyCertBundle, or error if the provided certs failed the
 // verification.
 func NewVerifiedKeyCertBundleFromFile(certFile string, privKeyFile string, certChainFiles []string, rootCertFile string) (
 	*KeyCertBundle, error,
 ) {
-	certBytes, err := os.ReadFile(certFile)
+	certBytes, certChainBytes, rootCertBytes, err := readCertFiles(certFile, certChainFiles, rootCertFile)
 	if err != nil {
 		return nil, err
 	}
-	chaincerts, _ := SplitPemEncodedCertificates(certBytes)
-	intNames := []string{}
-	for _, r := range chaincerts {
-		intNames = append(intNames, r.Subject.String())
+	privKeyBytes, err := os.ReadFile(privKeyFile)
+	if err != nil {
+		return nil, err
 	}
+	return NewVerifiedKeyCertBundleFromPem(certBytes, privKeyBytes, certChainBytes, rootCertBytes)
+}
 
-	// If the cert file is tls.key - it holds both leaf and intermediaries.
-	leafBytes, certChainBytes := SplitTlsCrt(certBytes)
-	certBytes = leafBytes
-
-	privKeyBytes, err := os.ReadFile(privKeyFile)
+// NewVerifiedKeyCertBundleFromFileWithSigner is similar to NewVerifiedKeyCertBundleFromFile, but the private
+// key is held by an external signer.
+func NewVerifiedKeyCertBundleFromFileWithSigner(certFile string, signer crypto.Signer, certChainFiles []string, rootCertFile string) (
+	*KeyCertBundle, error,
+) {
+	certBytes, certChainBytes, rootCertBytes, err := readCertFiles(certFile, certChainFiles, rootCertFile)
 	if err != nil {
 		return nil, err
 	}
+	return NewVerifiedKeyCertBundleFromSigner(certBytes, signer, certChainBytes, rootCertBytes)
+}
 
-	// Standalone, separate certChainFiles
-	if len(certChainFiles) > 0 {
-		for _, f := range certChainFiles {
-			var b []byte
+// readCertFiles reads the leaf, intermediate and root certificates from files.
+func readCertFiles(certFile string, certChainFiles []string, rootCertFile string) (
+	certBytes, certChainBytes, rootCertBytes []byte, err error,
+) {
+	certBytes, err = os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-			if b, err = os.ReadFile(f); err != nil {
-				return nil, err
-			}
+	// If the cert file is tls.key - it holds both leaf and intermediaries.
+	certBytes, certChainBytes = SplitTlsCrt(certBytes)
 
-			certChainBytes = append(certChainBytes, b...)
+	// Standalone, separate certChainFiles
+	for _, f := range certChainFiles {
+		var b []byte
+		if b, err = os.ReadFile(f); err != nil {
+			return nil, nil, nil, err
 		}
+		certChainBytes = append(certChainBytes, b...)
 	}
-	rootCertBytes, err := os.ReadFile(rootCertFile)
+	rootCertBytes, err = os.ReadFile(rootCertFile)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return NewVerifiedKeyCertBundleFromPem(certBytes, privKeyBytes, certChainBytes, rootCertBytes)
+	return certBytes, certChainBytes, rootCertBytes, nil
 }
 
 // NewKeyCertBundleWithRootCertFromFile returns a new KeyCertBundle with the root cert without verification.
@@ -174,6 +200,29 @@ func (b *KeyCertBundle) GetAll() (cert *x509.Certificate, privKey *crypto.Privat
 	return
 }
 
+// GetSigner returns the certificate and the crypto.Signer of its private key together. The signer is nil
+// if the private key can't sign.
+func (b *KeyCertBundle) GetSigner() (cert *x509.Certificate, signer crypto.Signer) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if b.privKey != nil {
+		signer, _ = (*b.privKey).(crypto.Signer)
+	}
+	return b.cert, signer
+}
+
+// ExternalSigner returns the signer holding the private key outside of the process, or nil if the private
+// key was loaded from PEM.
+func (b *KeyCertBundle) ExternalSigner() crypto.Signer {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if len(b.privKeyBytes) > 0 || b.privKey == nil {
+		return nil
+	}
+	signer, _ := (*b.privKey).(crypto.Signer)
+	return signer
+}
+
 // GetCertChainPem returns the certificate chain PEM.
 func (b *KeyCertBundle) GetCertChainPem() []byte {
 	b.mutex.RLock()
@@ -203,6 +252,24 @@ func (b *KeyCertBundle) VerifyAndSetAll(certBytes, privKeyBytes, certChainBytes,
 	return nil
 }
 
+// VerifyAndSetAllWithSigner is similar to VerifyAndSetAll, but the private key is held by an external signer.
+func (b *KeyCertBundle) VerifyAndSetAllWithSigner(certBytes []byte, signer crypto.Signer, certChainBytes, rootCertBytes []byte) error {
+	if err := VerifyWithSigner(certBytes, signer, certChainBytes, rootCertBytes); err != nil {
+		return err
+	}
+	cert, _ := ParsePemEncodedCertificate(certBytes)
+	privKey := crypto.PrivateKey(signer)
+	b.mutex.Lock()
+	b.certBytes = copyBytes(certBytes)
+	b.privKeyBytes = nil
+	b.certChainBytes = copyBytes(certChainBytes)
+	b.rootCertBytes = copyBytes(rootCertBytes)
+	b.cert = cert
+	b.privKey = &privKey
+	b.mutex.Unlock()
+	return nil
+}
+
 // Setting all values together avoids inconsistency.
 func (b *KeyCertBundle) setAllFromPem(certBytes, privKeyBytes, certChainBytes, rootCertBytes []byte) {
 	b.mutex.Lock()
@@ -238,15 +305,14 @@ func (b *KeyCertBundle) CertOptions() (*CertOptions, error) {
 		IsDualUse: ids[0] == b.cert.Subject.CommonName,
 	}
 
-	switch (*b.privKey).(type) {
-	case *rsa.PrivateKey:
-		size, err := GetRSAKeySize(*b.privKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get RSA key size: %v", err)
-		}
-		opts.RSAKeySize = size
-	case *ecdsa.PrivateKey:
+	// The type of the public key also covers private keys held by external signers.
+	switch pub := b.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		opts.RSAKeySize = pub.N.BitLen()
+	case *ecdsa.PublicKey:
 		opts.ECSigAlg = EcdsaSigAlg
+	case ed25519.PublicKey:
+		opts.ECSigAlg = Ed25519SigAlg
 	default:
 		return nil, errors.New("unknown private key type")
 	}
@@ -254,15 +320,19 @@ func (b *KeyCertBundle) CertOptions() (*CertOptions, error) {
 	return opts, nil
 }
 
-// UpdateVerifiedKeyCertBundleFromFile Verifies and updates KeyCertBundle with new certs
+// UpdateVerifiedKeyCertBundleFromFile Verifies and updates KeyCertBundle with new certs.
+// If the private key is held by an external signer, privKeyFile is not read and the signer is kept.
 func (b *KeyCertBundle) UpdateVerifiedKeyCertBundleFromFile(certFile string, privKeyFile string, certChainFiles []string, rootCertFile string) error {
 	certBytes, err := os.ReadFile(certFile)
 	if err != nil {
 		return err
 	}
-	privKeyBytes, err := os.ReadFile(privKeyFile)
-	if err != nil {
-		return err
+	signer := b.ExternalSigner()
+	var privKeyBytes []byte
+	if signer == nil {
+		if privKeyBytes, err = os.ReadFile(privKeyFile); err != nil {
+			return err
+		}
 	}
 	certChainBytes := []byte{}
 	if len(certChainFiles) != 0 {
@@ -280,6 +350,9 @@ func (b *KeyCertBundle) UpdateVerifiedKeyCertBundleFromFile(certFile string, pri
 		return err
 	}
 
+	if signer != nil {
+		return b.VerifyAndSetAllWithSigner(certBytes, signer, certChainBytes, rootCertBytes)
+	}
 	err = b.VerifyAndSetAll(certBytes, privKeyBytes, certChainBytes, rootCertBytes)
 	if err != nil {
 		return err
@@ -317,7 +390,55 @@ func TimeBeforeCertExpires(certBytes []byte, now time.Time) (time.Duration, erro
 
 // Verify that the cert chain, root cert and key/cert match.
 func Verify(certBytes, privKeyBytes, certChainBytes, rootCertBytes []byte) error {
-	// Verify the cert can be verified from the root cert through the cert chain.
+	if err := verifyChain(certBytes, certChainBytes, rootCertBytes); err != nil {
+		return err
+	}
+
+	// Verify that the key can be correctly parsed.
+	key, err := ParsePemEncodedKey(privKeyBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key PEM: %v", err)
+	}
+
+	// Verify the cert and key match. The public keys are compared, as the key may be of a registered
+	// SignatureScheme, which crypto/tls does not parse.
+	if !publicKeyMatches(key, certBytes) {
+		return fmt.Errorf("the cert does not match the key")
+	}
+
+	return nil
+}
+
+// VerifyWithSigner verifies that the cert chain, root cert and cert/signer match.
+func VerifyWithSigner(certBytes []byte, signer crypto.Signer, certChainBytes, rootCertBytes []byte) error {
+	if err := verifyChain(certBytes, certChainBytes, rootCertBytes); err != nil {
+		return err
+	}
+	if signer == nil {
+		return fmt.Errorf("no signer for the private key")
+	}
+	if !publicKeyMatches(signer, certBytes) {
+		return fmt.Errorf("the cert does not match the signer public key")
+	}
+	return nil
+}
+
+// publicKeyMatches returns whether the cert certifies the public key of the private key.
+func publicKeyMatches(key crypto.PrivateKey, certBytes []byte) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	cert, err := ParsePemEncodedCertificate(certBytes)
+	if err != nil {
+		return false
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+// verifyChain verifies the cert can be verified from the root cert through the cert chain.
+func verifyChain(certBytes, certChainBytes, rootCertBytes []byte) error {
 	rcp := x509.NewCertPool()
 	rcp.AppendCertsFromPEM(rootCertBytes)
 
@@ -339,17 +460,6 @@ func Verify(certBytes, privKeyBytes, certChainBytes, rootCertBytes []byte) error
 			"cannot verify the cert with the provided root chain and cert "+
 				"pool with error: %v", err)
 	}
-
-	// Verify that the key can be correctly parsed.
-	if _, err = ParsePemEncodedKey(privKeyBytes); err != nil {
-		return fmt.Errorf("failed to parse private key PEM: %v", err)
-	}
-
-	// Verify the cert and key match.
-	if _, err := tls.X509KeyPair(certBytes, privKeyBytes); err != nil {
-		return fmt.Errorf("the cert does not match the key: %v", err)
-	}
-
 	return nil
 }
 
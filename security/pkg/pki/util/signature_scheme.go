@@ -0,0 +1,98 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"fmt"
+	"sync"
+)
+
+// SignatureScheme generates and encodes the private keys of a signature algorithm which is not built in,
+// such as a hybrid or post-quantum signature scheme. Once registered with RegisterSignatureScheme, it is
+// selected by setting CertOptions.ECSigAlg, ECC_SIGNATURE_ALGORITHM for the workloads, to its name.
+//
+// CSRs and certificates are still created and verified by crypto/x509, so the public key of the generated
+// keys must be a RSA, ECDSA or Ed25519 key until crypto/x509 supports post-quantum keys. For instance a
+// hybrid scheme signs with a classical key, and binds its post-quantum key to it out of band.
+type SignatureScheme interface {
+	// GenerateKey generates a private key.
+	GenerateKey(options CertOptions) (crypto.Signer, error)
+	// PEMBlockType is the type of the PEM blocks holding the private keys, which must be unique.
+	PEMBlockType() string
+	// MarshalPrivateKey encodes a private key generated by GenerateKey.
+	MarshalPrivateKey(key crypto.Signer) ([]byte, error)
+	// ParsePrivateKey decodes a private key encoded by MarshalPrivateKey.
+	ParsePrivateKey(der []byte) (crypto.Signer, error)
+}
+
+var (
+	signatureSchemesMutex sync.RWMutex
+	signatureSchemes      = map[SupportedECSignatureAlgorithms]SignatureScheme{}
+)
+
+// RegisterSignatureScheme registers the scheme of a signature algorithm. It is meant to be called from
+// init functions, and panics if the algorithm or its PEM block type is already registered or built in.
+func RegisterSignatureScheme(alg SupportedECSignatureAlgorithms, scheme SignatureScheme) {
+	signatureSchemesMutex.Lock()
+	defer signatureSchemesMutex.Unlock()
+	switch alg {
+	case "", EcdsaSigAlg, Ed25519SigAlg:
+		panic(fmt.Sprintf("signature algorithm %q is built in", alg))
+	}
+	if _, f := signatureSchemes[alg]; f {
+		panic(fmt.Sprintf("signature algorithm %q is already registered", alg))
+	}
+	switch blockType := scheme.PEMBlockType(); blockType {
+	case blockTypeECPrivateKey, blockTypeRSAPrivateKey, blockTypePKCS8PrivateKey:
+		panic(fmt.Sprintf("PEM block type %q is built in", blockType))
+	default:
+		for _, registered := range signatureSchemes {
+			if registered.PEMBlockType() == blockType {
+				panic(fmt.Sprintf("PEM block type %q is already registered", blockType))
+			}
+		}
+	}
+	signatureSchemes[alg] = scheme
+}
+
+// UnregisterSignatureScheme removes the scheme of a signature algorithm. It is used by tests.
+func UnregisterSignatureScheme(alg SupportedECSignatureAlgorithms) {
+	signatureSchemesMutex.Lock()
+	defer signatureSchemesMutex.Unlock()
+	delete(signatureSchemes, alg)
+}
+
+// getSignatureScheme returns the scheme registered for the signature algorithm, or nil.
+func getSignatureScheme(alg SupportedECSignatureAlgorithms) SignatureScheme {
+	if alg == "" {
+		return nil
+	}
+	signatureSchemesMutex.RLock()
+	defer signatureSchemesMutex.RUnlock()
+	return signatureSchemes[alg]
+}
+
+// getSignatureSchemeForBlockType returns the scheme of the private keys in PEM blocks of the type, or nil.
+func getSignatureSchemeForBlockType(blockType string) SignatureScheme {
+	signatureSchemesMutex.RLock()
+	defer signatureSchemesMutex.RUnlock()
+	for _, scheme := range signatureSchemes {
+		if scheme.PEMBlockType() == blockType {
+			return scheme
+		}
+	}
+	return nil
+}
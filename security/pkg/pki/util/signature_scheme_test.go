@@ -0,0 +1,207 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testHybridSigAlg SupportedECSignatureAlgorithms = "TEST-HYBRID"
+
+// testHybridKey is a hybrid key of the test scheme: certificates are signed with the classical ECDSA key,
+// and the opaque post-quantum key is only carried along.
+type testHybridKey struct {
+	*ecdsa.PrivateKey
+	postQuantum []byte
+}
+
+type testHybridKeyEncoding struct {
+	Classical   []byte
+	PostQuantum []byte
+}
+
+type testHybridScheme struct{}
+
+func (testHybridScheme) GenerateKey(CertOptions) (crypto.Signer, error) {
+	classical, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	postQuantum := make([]byte, 32)
+	if _, err := rand.Read(postQuantum); err != nil {
+		return nil, err
+	}
+	return &testHybridKey{classical, postQuantum}, nil
+}
+
+func (testHybridScheme) PEMBlockType() string {
+	return "TEST HYBRID PRIVATE KEY"
+}
+
+func (testHybridScheme) MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	k, ok := key.(*testHybridKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected key type %T", key)
+	}
+	classical, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(testHybridKeyEncoding{classical, k.postQuantum})
+}
+
+func (testHybridScheme) ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	var encoding testHybridKeyEncoding
+	if _, err := asn1.Unmarshal(der, &encoding); err != nil {
+		return nil, err
+	}
+	classical, err := x509.ParsePKCS8PrivateKey(encoding.Classical)
+	if err != nil {
+		return nil, err
+	}
+	return &testHybridKey{classical.(*ecdsa.PrivateKey), encoding.PostQuantum}, nil
+}
+
+func TestSignatureSchemeChain(t *testing.T) {
+	RegisterSignatureScheme(testHybridSigAlg, testHybridScheme{})
+	defer UnregisterSignatureScheme(testHybridSigAlg)
+
+	rootCertPem, rootKeyPem, err := GenCertKeyFromOptions(CertOptions{
+		Org:          "Root CA",
+		TTL:          time.Hour,
+		IsCA:         true,
+		IsSelfSigned: true,
+		ECSigAlg:     testHybridSigAlg,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate the root cert: %v", err)
+	}
+	if block, _ := pem.Decode(rootKeyPem); block == nil || block.Type != "TEST HYBRID PRIVATE KEY" {
+		t.Fatalf("unexpected root key encoding:\n%s", rootKeyPem)
+	}
+	rootKey, err := ParsePemEncodedKey(rootKeyPem)
+	if err != nil {
+		t.Fatalf("failed to parse the root key: %v", err)
+	}
+	if _, ok := rootKey.(*testHybridKey); !ok {
+		t.Fatalf("unexpected root key type %T", rootKey)
+	}
+	if err := VerifyCertificate(rootKeyPem, rootCertPem, rootCertPem, &VerifyFields{
+		IsCA: true, KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}); err != nil {
+		t.Fatalf("failed to verify the root cert: %v", err)
+	}
+	rootCert, err := ParsePemEncodedCertificate(rootCertPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The intermediate CA holding a key of the scheme signs workload certificates.
+	intCertPem, intKeyPem, err := GenCertKeyFromOptions(CertOptions{
+		Org:        "Intermediate CA",
+		TTL:        time.Hour,
+		IsCA:       true,
+		SignerCert: rootCert,
+		SignerPriv: rootKey,
+		ECSigAlg:   testHybridSigAlg,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate the intermediate cert: %v", err)
+	}
+	bundle, err := NewVerifiedKeyCertBundleFromPem(intCertPem, intKeyPem, intCertPem, rootCertPem)
+	if err != nil {
+		t.Fatalf("failed to load the intermediate CA: %v", err)
+	}
+	intCert, intKey := bundle.GetSigner()
+
+	host := "spiffe://cluster.local/ns/default/sa/default"
+	for name, sigAlg := range map[string]SupportedECSignatureAlgorithms{
+		"RSA": "", "ECDSA": EcdsaSigAlg, "Ed25519": Ed25519SigAlg, "hybrid": testHybridSigAlg,
+	} {
+		t.Run(name, func(t *testing.T) {
+			csrPem, keyPem, err := GenCSR(CertOptions{Host: host, RSAKeySize: 2048, ECSigAlg: sigAlg})
+			if err != nil {
+				t.Fatalf("failed to generate the CSR: %v", err)
+			}
+			csr, err := ParsePemEncodedCSR(csrPem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := csr.CheckSignature(); err != nil {
+				t.Fatalf("invalid CSR signature: %v", err)
+			}
+			certDer, err := GenCertFromCSR(csr, intCert, csr.PublicKey, intKey, []string{host}, time.Hour, false)
+			if err != nil {
+				t.Fatalf("failed to sign the CSR: %v", err)
+			}
+			certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+			verifyFields := &VerifyFields{
+				Host:        host,
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}
+			if sigAlg == Ed25519SigAlg {
+				// Ed25519 keys can not be used for key encipherment.
+				verifyFields.KeyUsage = x509.KeyUsageDigitalSignature
+			}
+			if err := VerifyCertificate(keyPem, append(certPem, intCertPem...), rootCertPem, verifyFields); err != nil {
+				t.Fatalf("failed to verify the chain: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegisterSignatureScheme(t *testing.T) {
+	RegisterSignatureScheme(testHybridSigAlg, testHybridScheme{})
+	defer UnregisterSignatureScheme(testHybridSigAlg)
+
+	cases := map[string]struct {
+		alg    SupportedECSignatureAlgorithms
+		scheme SignatureScheme
+	}{
+		"built in algorithm":  {alg: Ed25519SigAlg, scheme: testHybridScheme{}},
+		"registered":          {alg: testHybridSigAlg, scheme: testHybridScheme{}},
+		"registered PEM type": {alg: "OTHER", scheme: testHybridScheme{}},
+		"built in PEM type":   {alg: "OTHER", scheme: builtinBlockTypeScheme{}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected the registration to panic")
+				}
+			}()
+			RegisterSignatureScheme(tc.alg, tc.scheme)
+		})
+	}
+}
+
+// builtinBlockTypeScheme is a scheme using the PEM block type of PKCS#8 keys.
+type builtinBlockTypeScheme struct {
+	testHybridScheme
+}
+
+func (builtinBlockTypeScheme) PEMBlockType() string {
+	return blockTypePKCS8PrivateKey
+}
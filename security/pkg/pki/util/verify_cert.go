@@ -15,8 +15,7 @@
 package util
 
 import (
-	"crypto/ecdsa"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	"os"
@@ -85,26 +84,14 @@ func VerifyCertificate(privPem []byte, certChainPem []byte, rootCertPem []byte,
 			return err
 		}
 
-		privRSAKey, privRSAOk := priv.(*rsa.PrivateKey)
-		pubRSAKey, pubRSAOk := cert.PublicKey.(*rsa.PublicKey)
-
-		privECKey, privECOk := priv.(*ecdsa.PrivateKey)
-		pubECKey, pubECOk := cert.PublicKey.(*ecdsa.PublicKey)
-
-		rsaMatch := privRSAOk && pubRSAOk
-		ecMatch := privECOk && pubECOk
-
-		if rsaMatch {
-			if !reflect.DeepEqual(privRSAKey.PublicKey, *pubRSAKey) {
-				return fmt.Errorf("the generated private RSA key and cert doesn't match")
-			}
-		} else if ecMatch {
-			if !reflect.DeepEqual(privECKey.PublicKey, *pubECKey) {
-				return fmt.Errorf("the generated private EC key and cert doesn't match")
-			}
-		} else {
+		// The private key may be of a registered SignatureScheme, so only its public key is compared.
+		signer, ok := priv.(crypto.Signer)
+		if !ok || reflect.TypeOf(signer.Public()) != reflect.TypeOf(cert.PublicKey) {
 			return fmt.Errorf("algorithms for private key and cert do not match")
 		}
+		if !publicKeyMatches(priv, certChainPem) {
+			return fmt.Errorf("the generated private %s key and cert doesn't match", cert.PublicKeyAlgorithm)
+		}
 	}
 	if strings.HasPrefix(host, "spiffe") {
 		matchHost := false
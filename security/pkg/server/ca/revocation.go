@@ -0,0 +1,286 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/types"
+
+	"istio.io/istio/pkg/revocationapi"
+	"istio.io/istio/pkg/security"
+	"istio.io/istio/pkg/slices"
+	"istio.io/istio/pkg/spiffe"
+	"istio.io/istio/pkg/util/sets"
+	"istio.io/istio/security/pkg/pki/ca"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// maxOCSPRequestSize bounds the size of the OCSP requests.
+	maxOCSPRequestSize = 10 * 1024
+	// ocspResponseValidity is the validity of the OCSP responses.
+	ocspResponseValidity = time.Hour
+)
+
+// RevocationAuthority contains methods to be supported by a CA that can revoke the certificates it issued.
+type RevocationAuthority interface {
+	// Revoke revokes the certificates, returning the number of newly revoked certificates.
+	Revoke(certs []ca.RevokedCertificate) (int, error)
+	// IsRevoked returns the revocation of the certificate with the given serial number, if it was revoked.
+	IsRevoked(serialNumber *big.Int) (ca.RevokedCertificate, bool)
+	// GetCRL returns the PEM encoded certificate revocation list.
+	GetCRL() ([]byte, error)
+	// GetCAKeyCertBundle returns the KeyCertBundle used by CA.
+	GetCAKeyCertBundle() *util.KeyCertBundle
+}
+
+// RevocationServer implements IstioCertificateRevocationService.
+type RevocationServer struct {
+	revocationapi.UnimplementedIstioCertificateRevocationServiceServer
+	Authenticators []security.Authenticator
+	ca             RevocationAuthority
+	// authorizedAccounts are the service accounts allowed to revoke certificates. Any other caller is denied.
+	authorizedAccounts sets.Set[types.NamespacedName]
+}
+
+// NewRevocationServer creates a RevocationServer.
+func NewRevocationServer(
+	ca RevocationAuthority,
+	authenticators []security.Authenticator,
+	authorizedAccounts sets.Set[types.NamespacedName],
+) *RevocationServer {
+	return &RevocationServer{
+		Authenticators:     authenticators,
+		ca:                 ca,
+		authorizedAccounts: authorizedAccounts,
+	}
+}
+
+// Register registers a GRPC server on the specified port.
+func (s *RevocationServer) Register(grpcServer *grpc.Server) {
+	revocationapi.RegisterIstioCertificateRevocationServiceServer(grpcServer, s)
+}
+
+// RevokeCertificates revokes the requested certificates, by serial number or certificate. Only the
+// authorized service accounts can revoke certificates.
+func (s *RevocationServer) RevokeCertificates(ctx context.Context, request *revocationapi.RevokeCertificatesRequest) (
+	*revocationapi.RevokeCertificatesResponse, error,
+) {
+	caller, err := security.Authenticate(ctx, s.Authenticators)
+	if caller == nil || err != nil {
+		return nil, status.Error(codes.Unauthenticated, "request authenticate failure")
+	}
+	if !s.authorized(caller) {
+		serverCaLog.Warnf("certificate revocation denied for %v", caller.Identities)
+		return nil, status.Error(codes.PermissionDenied, "caller is not authorized to revoke certificates")
+	}
+	// Reason code 7 is not used, and removeFromCRL (8) is only meaningful for delta CRLs.
+	if request.Reason < 0 || request.Reason == 7 || request.Reason == 8 || request.Reason > 10 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid revocation reason %d", request.Reason)
+	}
+	if len(request.SerialNumbers) == 0 && len(request.Certificates) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no serial number or certificate")
+	}
+	certs, err := s.revokedCertificates(request)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := s.ca.Revoke(certs)
+	if err != nil {
+		serverCaLog.Errorf("certificate revocation error: %v", err)
+		return nil, status.Errorf(codes.Internal, "certificate revocation error (%v)", err)
+	}
+	// For audit - revoking a cert is an important operation.
+	serverCaLog.WithLabels("identities", caller.Identities, "authSource", caller.AuthSource,
+		"serials", slices.Map(certs, func(c ca.RevokedCertificate) string { return c.SerialNumber.Text(16) }),
+		"reason", request.Reason).Info("CertificatesRevoked")
+	return &revocationapi.RevokeCertificatesResponse{Revoked: int32(revoked)}, nil
+}
+
+// revokedCertificates returns the certificates to revoke. The expiration of the certificates given by serial
+// number is unknown, and left unset.
+func (s *RevocationServer) revokedCertificates(request *revocationapi.RevokeCertificatesRequest) ([]ca.RevokedCertificate, error) {
+	certs := make([]ca.RevokedCertificate, 0, len(request.SerialNumbers)+len(request.Certificates))
+	for _, sn := range request.SerialNumbers {
+		serial, ok := ParseSerialNumber(sn)
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid serial number %q", sn)
+		}
+		certs = append(certs, ca.RevokedCertificate{SerialNumber: serial, ReasonCode: int(request.Reason)})
+	}
+	if len(request.Certificates) == 0 {
+		return certs, nil
+	}
+	signingCert, _, _, _ := s.ca.GetCAKeyCertBundle().GetAll()
+	if signingCert == nil {
+		return nil, status.Error(codes.Unavailable, "the CA is not ready")
+	}
+	for i, certPEM := range request.Certificates {
+		cert, err := util.ParsePemEncodedCertificate([]byte(certPEM))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid certificate %d: %v", i, err)
+		}
+		// The expiration is only trusted from the certificates issued by the CA.
+		if err := cert.CheckSignatureFrom(signingCert); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "certificate %d is not issued by the CA: %v", i, err)
+		}
+		c := ca.RevokedCertificate{SerialNumber: cert.SerialNumber, ReasonCode: int(request.Reason), NotAfter: cert.NotAfter}
+		// The certificate gives the expiration of a revocation requested by serial number.
+		if r := slices.FindFunc(certs, func(r ca.RevokedCertificate) bool { return r.SerialNumber.Cmp(c.SerialNumber) == 0 }); r != nil {
+			*r = c
+		} else {
+			certs = append(certs, c)
+		}
+	}
+	return certs, nil
+}
+
+// GetRevocationList returns the CRL of the CA to any authenticated caller.
+func (s *RevocationServer) GetRevocationList(ctx context.Context, _ *revocationapi.RevocationListRequest) (
+	*revocationapi.RevocationListResponse, error,
+) {
+	caller, err := security.Authenticate(ctx, s.Authenticators)
+	if caller == nil || err != nil {
+		return nil, status.Error(codes.Unauthenticated, "request authenticate failure")
+	}
+	crl, err := s.ca.GetCRL()
+	if err != nil {
+		serverCaLog.Errorf("failed to get revocation list: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to get revocation list (%v)", err)
+	}
+	return &revocationapi.RevocationListResponse{Crl: string(crl)}, nil
+}
+
+func (s *RevocationServer) authorized(caller *security.Caller) bool {
+	for _, id := range caller.Identities {
+		identity, err := spiffe.ParseIdentity(id)
+		if err != nil {
+			continue
+		}
+		if s.authorizedAccounts.Contains(types.NamespacedName{Namespace: identity.Namespace, Name: identity.ServiceAccount}) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSerialNumber parses a hexadecimal certificate serial number, optionally with ':' separators
+// as printed by openssl.
+func ParseSerialNumber(s string) (*big.Int, bool) {
+	s = strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(s, ":", "")), "0x")
+	if s == "" {
+		return nil, false
+	}
+	serial, ok := new(big.Int).SetString(s, 16)
+	if !ok || serial.Sign() < 0 {
+		return nil, false
+	}
+	return serial, true
+}
+
+// OCSPHandler returns an OCSP (RFC 6960) responder for the certificates issued by the CA.
+// Requests are accepted with POST, or GET with the base64 encoded request as the path, which
+// must be relative to the responder URL (see http.StripPrefix).
+func OCSPHandler(ca RevocationAuthority) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw []byte
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			raw, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+		case http.MethodGet:
+			raw, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		if err != nil {
+			_, _ = w.Write(ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		req, err := ocsp.ParseRequest(raw)
+		if err != nil {
+			_, _ = w.Write(ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		resp, err := ocspResponse(ca, req)
+		if err != nil {
+			serverCaLog.Warnf("OCSP request for %s failed: %v", req.SerialNumber.Text(16), err)
+			_, _ = w.Write(ocsp.InternalErrorErrorResponse)
+			return
+		}
+		_, _ = w.Write(resp)
+	})
+}
+
+func ocspResponse(ca RevocationAuthority, req *ocsp.Request) ([]byte, error) {
+	signingCert, signer := ca.GetCAKeyCertBundle().GetSigner()
+	if signingCert == nil || signer == nil {
+		return nil, fmt.Errorf("CA is not ready")
+	}
+	if !issuedBy(req, signingCert.RawSubject, signingCert.RawSubjectPublicKeyInfo) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspResponseValidity),
+	}
+	if revoked, f := ca.IsRevoked(req.SerialNumber); f {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = revoked.RevocationTime
+		template.RevocationReason = revoked.ReasonCode
+	}
+	return ocsp.CreateResponse(signingCert, signingCert, template, signer)
+}
+
+// issuedBy returns true if the OCSP request is for a certificate issued by the given name and key.
+func issuedBy(req *ocsp.Request, rawSubject, rawSubjectPublicKeyInfo []byte) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(rawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(rawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
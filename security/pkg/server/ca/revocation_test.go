@@ -0,0 +1,337 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/types"
+
+	"istio.io/istio/pkg/revocationapi"
+	"istio.io/istio/pkg/security"
+	"istio.io/istio/pkg/util/sets"
+	"istio.io/istio/security/pkg/pki/ca"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func createRevocationCA(t *testing.T) *ca.IstioCA {
+	t.Helper()
+	certPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		TTL:          time.Hour,
+		Org:          "Root CA",
+		ECSigAlg:     util.EcdsaSigAlg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := util.NewVerifiedKeyCertBundleFromPem(certPEM, keyPEM, nil, certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	istioCA, err := ca.NewIstioCA(&ca.IstioCAOptions{
+		DefaultCertTTL:  time.Hour,
+		MaxCertTTL:      time.Hour,
+		KeyCertBundle:   bundle,
+		RevocationStore: ca.NewInMemoryRevocationStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return istioCA
+}
+
+func tlsPeerContext() context.Context {
+	p := &peer.Peer{Addr: &net.IPAddr{IP: net.IPv4(192, 168, 1, 1)}, AuthInfo: credentials.TLSInfo{}}
+	return peer.NewContext(context.Background(), p)
+}
+
+func TestRevokeCertificates(t *testing.T) {
+	cases := []struct {
+		name       string
+		authorized sets.Set[types.NamespacedName]
+		caller     *mockAuthenticator
+		request    *revocationapi.RevokeCertificatesRequest
+		code       codes.Code
+		revoked    int32
+	}{
+		{
+			name:    "unauthenticated",
+			caller:  &mockAuthenticator{errMsg: "not authorized"},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}},
+			code:    codes.Unauthenticated,
+		},
+		{
+			name:    "workload in another namespace",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/default/sa/default"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}},
+			code:    codes.PermissionDenied,
+		},
+		{
+			name:    "istio namespace",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istio-ingressgateway-service-account"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}},
+			code:    codes.PermissionDenied,
+		},
+		{
+			name:    "istiod",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10", "0x11", "1:2"}, Reason: 1},
+			code:    codes.OK,
+			revoked: 3,
+		},
+		{
+			name:       "authorized account",
+			authorized: sets.New(types.NamespacedName{Namespace: "admin", Name: "revoker"}),
+			caller:     &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/admin/sa/revoker"}},
+			request:    &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}},
+			code:       codes.OK,
+			revoked:    1,
+		},
+		{
+			name:       "istiod with authorized accounts",
+			authorized: sets.New(types.NamespacedName{Namespace: "admin", Name: "revoker"}),
+			caller:     &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request:    &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}},
+			code:       codes.PermissionDenied,
+		},
+		{
+			name:    "invalid serial",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"xyz"}},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "no serial number or certificate",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "invalid certificate",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{Certificates: []string{"not a certificate"}},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "invalid reason",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}, Reason: 7},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "remove from CRL reason",
+			caller:  &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}},
+			request: &revocationapi.RevokeCertificatesRequest{SerialNumbers: []string{"10"}, Reason: 8},
+			code:    codes.InvalidArgument,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.authorized == nil {
+				tc.authorized = sets.New(types.NamespacedName{Namespace: "istio-system", Name: "istiod"})
+			}
+			server := NewRevocationServer(createRevocationCA(t), []security.Authenticator{tc.caller}, tc.authorized)
+			resp, err := server.RevokeCertificates(tlsPeerContext(), tc.request)
+			if status.Code(err) != tc.code {
+				t.Fatalf("expected code %v, got %v", tc.code, err)
+			}
+			if resp.GetRevoked() != tc.revoked {
+				t.Fatalf("expected %d revoked certificates, got %d", tc.revoked, resp.GetRevoked())
+			}
+		})
+	}
+}
+
+// issueCert returns a workload certificate issued by the CA.
+func issueCert(t *testing.T, istioCA *ca.IstioCA) *x509.Certificate {
+	t.Helper()
+	csr, _, err := util.GenCSR(util.CertOptions{Host: "spiffe://cluster.local/ns/default/sa/default", ECSigAlg: util.EcdsaSigAlg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := istioCA.Sign(csr, ca.CertOpts{SubjectIDs: []string{"spiffe://cluster.local/ns/default/sa/default"}, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := util.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRevokeCertificatesExpiration(t *testing.T) {
+	istioCA := createRevocationCA(t)
+	caller := &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/istio-system/sa/istiod"}}
+	server := NewRevocationServer(istioCA, []security.Authenticator{caller}, sets.New(types.NamespacedName{Namespace: "istio-system", Name: "istiod"}))
+	certPEM := func(cert *x509.Certificate) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	cert, other := issueCert(t, istioCA), issueCert(t, istioCA)
+	resp, err := server.RevokeCertificates(tlsPeerContext(), &revocationapi.RevokeCertificatesRequest{
+		SerialNumbers: []string{cert.SerialNumber.Text(16), other.SerialNumber.Text(16)},
+		Certificates:  []string{certPEM(cert)},
+		Reason:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Revoked != 2 {
+		t.Fatalf("expected 2 revoked certificates, got %d", resp.Revoked)
+	}
+	// The expiration is known from the certificate.
+	if r, _ := istioCA.IsRevoked(cert.SerialNumber); !r.NotAfter.Equal(cert.NotAfter) || r.ReasonCode != 1 {
+		t.Fatalf("expected the revocation to expire with the certificate, got %v", r)
+	}
+	// It is unknown from the serial number only.
+	if r, _ := istioCA.IsRevoked(other.SerialNumber); !r.NotAfter.IsZero() {
+		t.Fatalf("expected the revocation to never expire, got %v", r.NotAfter)
+	}
+
+	// Certificates issued by another CA are rejected.
+	_, err = server.RevokeCertificates(tlsPeerContext(), &revocationapi.RevokeCertificatesRequest{
+		Certificates: []string{certPEM(issueCert(t, createRevocationCA(t)))},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+}
+
+func TestGetRevocationList(t *testing.T) {
+	istioCA := createRevocationCA(t)
+	if _, err := istioCA.Revoke([]ca.RevokedCertificate{{SerialNumber: big.NewInt(0x42)}}); err != nil {
+		t.Fatal(err)
+	}
+	caller := &mockAuthenticator{identities: []string{"spiffe://cluster.local/ns/default/sa/default"}}
+	server := NewRevocationServer(istioCA, []security.Authenticator{caller}, nil)
+	resp, err := server.GetRevocationList(tlsPeerContext(), &revocationapi.RevocationListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(resp.Crl))
+	if block == nil {
+		t.Fatalf("invalid CRL %q", resp.Crl)
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Int64() != 0x42 {
+		t.Fatalf("unexpected CRL entries %v", crl.RevokedCertificateEntries)
+	}
+
+	server.Authenticators = []security.Authenticator{&mockAuthenticator{errMsg: "not authorized"}}
+	if _, err := server.GetRevocationList(tlsPeerContext(), &revocationapi.RevocationListRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got %v", err)
+	}
+}
+
+func TestOCSPHandler(t *testing.T) {
+	istioCA := createRevocationCA(t)
+	signingCert, _, _, _ := istioCA.GetCAKeyCertBundle().GetAll()
+	good, revoked := issueCert(t, istioCA), issueCert(t, istioCA)
+	if _, err := istioCA.Revoke([]ca.RevokedCertificate{{SerialNumber: revoked.SerialNumber, ReasonCode: ocsp.KeyCompromise}}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.StripPrefix("/ocsp", OCSPHandler(istioCA)))
+	defer server.Close()
+	query := func(t *testing.T, cert, issuer *x509.Certificate, get bool) *ocsp.Response {
+		t.Helper()
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var httpResp *http.Response
+		if get {
+			httpResp, err = http.Get(server.URL + "/ocsp/" + base64.StdEncoding.EncodeToString(req))
+		} else {
+			httpResp, err = http.Post(server.URL+"/ocsp", "application/ocsp-request", bytes.NewReader(req))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer httpResp.Body.Close()
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(httpResp.Body); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ocsp.ParseResponseForCert(body.Bytes(), cert, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, get := range []bool{false, true} {
+		if resp := query(t, good, signingCert, get); resp.Status != ocsp.Good {
+			t.Fatalf("expected good status, got %v", resp.Status)
+		}
+		resp := query(t, revoked, signingCert, get)
+		if resp.Status != ocsp.Revoked || resp.RevocationReason != ocsp.KeyCompromise {
+			t.Fatalf("expected revoked status, got %v %v", resp.Status, resp.RevocationReason)
+		}
+	}
+
+	// Certificates from other issuers are not known.
+	other := createRevocationCA(t)
+	otherCert, _, _, _ := other.GetCAKeyCertBundle().GetAll()
+	req, err := ocsp.CreateRequest(good, otherCert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp, err := http.Post(server.URL+"/ocsp", "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(httpResp.Body)
+	if !bytes.Equal(body.Bytes(), ocsp.UnauthorizedErrorResponse) {
+		t.Fatalf("expected unauthorized response, got %x", body.Bytes())
+	}
+}
+
+func TestParseSerialNumber(t *testing.T) {
+	for in, want := range map[string]int64{"10": 16, "0x10": 16, "0A:0b": 0xa0b, "FF": 255} {
+		got, ok := ParseSerialNumber(in)
+		if !ok || got.Int64() != want {
+			t.Errorf("ParseSerialNumber(%q) = %v, %v; want %d", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "xyz", "-1", "0x"} {
+		if _, ok := ParseSerialNumber(in); ok {
+			t.Errorf("ParseSerialNumber(%q) expected to fail", in)
+		}
+	}
+}